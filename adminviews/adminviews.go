@@ -0,0 +1,210 @@
+// Package adminviews stores the views admins save of the user list: a
+// filter, a sort order and the columns to show, under a name. Each admin has
+// their own views; naming one with ?view= on the list or the export turns a
+// recurring report into a single call.
+package adminviews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/usertags"
+)
+
+const collection = "admin_views"
+
+// MaxPerAdmin bounds the views one admin can save
+const MaxPerAdmin = 50
+
+// DefaultSort is the order of the user list without a sort
+const DefaultSort = "-created_at"
+
+// namePattern is what a view name looks like
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// sortFields are the user fields a view can sort by
+var sortFields = map[string]bool{"created_at": true, "updated_at": true, "display_name": true, "role": true}
+
+// columns are the user fields a view can show. The id is always shown.
+var columns = map[string]bool{
+	"email": true, "display_name": true, "role": true, "tags": true, "org_id": true,
+	"locale": true, "suspended": true, "forgotten_at": true, "created_at": true, "updated_at": true,
+	"custom_fields": true,
+}
+
+var (
+	// ErrInvalidName is returned for names that do not match the pattern
+	ErrInvalidName = errors.New("view names must be 1-64 lowercase letters, digits or _.- starting with a letter or digit")
+	// ErrInvalidSort is returned for sorts by an unknown field
+	ErrInvalidSort = errors.New("sort must be one of created_at, updated_at, display_name or role, optionally prefixed with -")
+	// ErrInvalidColumn is returned for unknown columns
+	ErrInvalidColumn = errors.New("columns must be among email, display_name, role, tags, org_id, locale, suspended, forgotten_at, created_at, updated_at and custom_fields")
+	// ErrInvalidRole is returned for roles other than user and admin
+	ErrInvalidRole = errors.New("role must be user or admin")
+	// ErrTooMany is returned when an admin already has MaxPerAdmin views
+	ErrTooMany = fmt.Errorf("admins have at most %d views", MaxPerAdmin)
+	// ErrNotFound is returned for views the admin has not saved
+	ErrNotFound = errors.New("view not found")
+)
+
+// Init creates the indexes of saved views
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "admin_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("adminviews: failed to create indexes: %v", err)
+	}
+}
+
+// Normalize checks a view and brings its name, tags and columns to their
+// canonical form
+func Normalize(view *models.AdminView) error {
+	view.Name = strings.ToLower(strings.TrimSpace(view.Name))
+	if !namePattern.MatchString(view.Name) {
+		return ErrInvalidName
+	}
+	if role := view.Filter.Role; role != "" && role != "user" && role != "admin" {
+		return ErrInvalidRole
+	}
+	tags, err := usertags.Normalize(view.Filter.Tags)
+	if err != nil {
+		return err
+	}
+	view.Filter.Tags = tags
+	if _, err := ParseSort(view.Sort); err != nil {
+		return err
+	}
+	if view.Columns, err = NormalizeColumns(view.Columns); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseSort turns a sort such as "-created_at" into a sort document, with
+// the id breaking ties so pages do not overlap. An empty sort is DefaultSort.
+func ParseSort(sort string) (bson.D, error) {
+	if sort == "" {
+		sort = DefaultSort
+	}
+	order := 1
+	field := sort
+	if strings.HasPrefix(sort, "-") {
+		order, field = -1, sort[1:]
+	}
+	if !sortFields[field] {
+		return nil, ErrInvalidSort
+	}
+	return bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}, nil
+}
+
+// NormalizeColumns deduplicates columns, keeping their order, and checks
+// them. The id is implied.
+func NormalizeColumns(list []string) ([]string, error) {
+	seen := make(map[string]bool, len(list))
+	var normalized []string
+	for _, column := range list {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" || column == "id" || seen[column] {
+			continue
+		}
+		if !columns[column] {
+			return nil, ErrInvalidColumn
+		}
+		seen[column] = true
+		normalized = append(normalized, column)
+	}
+	return normalized, nil
+}
+
+// List returns the views of an admin by name
+func List(ctx context.Context, adminID primitive.ObjectID) ([]models.AdminView, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"admin_id": adminID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	views := []models.AdminView{}
+	if err := cursor.All(ctx, &views); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+// Get returns the view of an admin with a name
+func Get(ctx context.Context, adminID primitive.ObjectID, name string) (*models.AdminView, error) {
+	var view models.AdminView
+	filter := bson.M{"admin_id": adminID, "name": strings.ToLower(name)}
+	err := database.DB.Collection(collection).FindOne(ctx, filter).Decode(&view)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// Save creates the view, or replaces the admin's view with the same name,
+// and reports whether it was created. The view must be normalized.
+func Save(ctx context.Context, view *models.AdminView) (bool, error) {
+	coll := database.DB.Collection(collection)
+	filter := bson.M{"admin_id": view.AdminID, "name": view.Name}
+
+	existing, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	if existing == 0 {
+		count, err := coll.CountDocuments(ctx, bson.M{"admin_id": view.AdminID})
+		if err != nil {
+			return false, err
+		}
+		if count >= MaxPerAdmin {
+			return false, ErrTooMany
+		}
+	}
+
+	now := clock.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"filter":     view.Filter,
+			"sort":       view.Sort,
+			"columns":    view.Columns,
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(view); err != nil {
+		return false, err
+	}
+	return existing == 0, nil
+}
+
+// Delete removes the view of an admin with a name
+func Delete(ctx context.Context, adminID primitive.ObjectID, name string) error {
+	result, err := database.DB.Collection(collection).DeleteOne(ctx, bson.M{"admin_id": adminID, "name": strings.ToLower(name)})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
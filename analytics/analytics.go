@@ -0,0 +1,288 @@
+// Package analytics ingests client analytics events. Validated events are
+// buffered in memory and written in batches to a pluggable Sink: a MongoDB
+// collection, a Kafka topic through a REST proxy, NDJSON objects in S3 or
+// local NDJSON files. When the buffer is full new batches are refused so
+// clients retry later, instead of the server growing without bound.
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/config"
+)
+
+// Limits of a single event
+const (
+	maxProperties    = 50
+	maxPropertyKey   = 64
+	maxPropertyValue = 1024
+	maxIDLength      = 128
+	maxPastSkew      = 7 * 24 * time.Hour
+	maxFutureSkew    = 5 * time.Minute
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,63}$`)
+
+// ErrBufferFull is returned when the buffer cannot take a batch
+var ErrBufferFull = errors.New("analytics buffer is full")
+
+// Input is an event as sent by a client
+type Input struct {
+	Name        string                 `json:"name" example:"page.viewed"`
+	Timestamp   *time.Time             `json:"timestamp,omitempty"`
+	AnonymousID string                 `json:"anonymous_id,omitempty" example:"b9e3c1d2-5f4a-4e8b-9c7d-1a2b3c4d5e6f"`
+	SessionID   string                 `json:"session_id,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Event is a validated event with what the server knows about its sender
+type Event struct {
+	ID          primitive.ObjectID     `bson:"_id" json:"id"`
+	Name        string                 `bson:"name" json:"name"`
+	Timestamp   time.Time              `bson:"timestamp" json:"timestamp"`
+	ReceivedAt  time.Time              `bson:"received_at" json:"received_at"`
+	AnonymousID string                 `bson:"anonymous_id,omitempty" json:"anonymous_id,omitempty"`
+	SessionID   string                 `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	UserID      string                 `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	OrgID       string                 `bson:"org_id,omitempty" json:"org_id,omitempty"`
+	UserAgent   string                 `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Properties  map[string]interface{} `bson:"properties,omitempty" json:"properties,omitempty"`
+}
+
+// Stats counts events since startup
+type Stats struct {
+	Sink          string `json:"sink" example:"mongo"`
+	Accepted      int64  `json:"accepted"`
+	Rejected      int64  `json:"rejected"`
+	Refused       int64  `json:"refused"`
+	Written       int64  `json:"written"`
+	Dropped       int64  `json:"dropped"`
+	FlushFailures int64  `json:"flush_failures"`
+	Buffered      int    `json:"buffered"`
+}
+
+var (
+	sink     Sink
+	sinkName string
+
+	mu         sync.Mutex
+	buffer     []Event
+	bufferSize int
+	flushSize  int
+	flushNow   = make(chan struct{}, 1)
+
+	accepted, rejected, refused, written, dropped, flushFailures atomic.Int64
+
+	limiter *clientLimiter
+)
+
+// Init creates the configured sink and starts flushing the buffer. Without
+// it the ingestion endpoint is not served.
+func Init(cfg *config.Config) {
+	if !cfg.AnalyticsEnabled {
+		return
+	}
+
+	s, err := newSink(cfg)
+	if err != nil {
+		log.Printf("analytics: ingestion disabled: %v", err)
+		return
+	}
+	sink, sinkName = s, cfg.AnalyticsSink
+	bufferSize = cfg.AnalyticsBufferSize
+	flushSize = cfg.AnalyticsFlushSize
+	limiter = &clientLimiter{limit: cfg.AnalyticsRateLimit, counts: make(map[string]int)}
+
+	go func() {
+		ticker := time.NewTicker(cfg.AnalyticsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-flushNow:
+			}
+			flush(context.Background())
+		}
+	}()
+}
+
+// Enabled reports whether events are being ingested
+func Enabled() bool {
+	return sink != nil
+}
+
+// Validate checks an event against the schema: a lowercase dotted name,
+// bounded IDs, a timestamp close to now and at most 50 scalar properties
+func Validate(in Input) error {
+	if !namePattern.MatchString(in.Name) {
+		return errors.New("name must be 1-64 lowercase letters, digits or _.:- starting with a letter")
+	}
+	if len(in.AnonymousID) > maxIDLength || len(in.SessionID) > maxIDLength {
+		return fmt.Errorf("anonymous_id and session_id must be at most %d characters", maxIDLength)
+	}
+	if in.Timestamp != nil {
+		now := clock.Now()
+		if in.Timestamp.Before(now.Add(-maxPastSkew)) || in.Timestamp.After(now.Add(maxFutureSkew)) {
+			return errors.New("timestamp must be within the last 7 days")
+		}
+	}
+	if len(in.Properties) > maxProperties {
+		return fmt.Errorf("at most %d properties are allowed", maxProperties)
+	}
+	for key, value := range in.Properties {
+		if key == "" || len(key) > maxPropertyKey {
+			return fmt.Errorf("property names must be 1-%d characters", maxPropertyKey)
+		}
+		switch v := value.(type) {
+		case nil, bool, float64:
+		case string:
+			if len(v) > maxPropertyValue {
+				return fmt.Errorf("property %q is longer than %d characters", key, maxPropertyValue)
+			}
+		default:
+			return fmt.Errorf("property %q must be a string, number, boolean or null", key)
+		}
+	}
+	return nil
+}
+
+// Enqueue buffers events for the next flush. It takes all of them or, when
+// they do not fit, none and returns ErrBufferFull.
+func Enqueue(events []Event) error {
+	mu.Lock()
+	if len(buffer)+len(events) > bufferSize {
+		mu.Unlock()
+		refused.Add(int64(len(events)))
+		return ErrBufferFull
+	}
+	buffer = append(buffer, events...)
+	full := len(buffer) >= flushSize
+	mu.Unlock()
+
+	accepted.Add(int64(len(events)))
+	if full {
+		select {
+		case flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Reject counts events that failed validation
+func Reject(n int) {
+	rejected.Add(int64(n))
+}
+
+// flush writes the buffer to the sink in batches of ANALYTICS_FLUSH_SIZE.
+// A failed batch goes back to the front of the buffer to be retried, unless
+// new events filled the buffer in the meantime.
+func flush(ctx context.Context) {
+	for {
+		mu.Lock()
+		n := min(len(buffer), flushSize)
+		batch := buffer[:n:n]
+		buffer = buffer[n:]
+		mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := sink.Write(writeCtx, batch)
+		cancel()
+		if err == nil {
+			written.Add(int64(n))
+			continue
+		}
+
+		flushFailures.Add(1)
+		log.Printf("analytics: writing %d events to %s failed: %v", n, sinkName, err)
+		mu.Lock()
+		if len(buffer)+n <= bufferSize {
+			buffer = append(batch, buffer...)
+		} else {
+			dropped.Add(int64(n))
+		}
+		mu.Unlock()
+		return
+	}
+}
+
+// Allow counts a batch from a client (user ID or IP), or returns the seconds
+// until it may send again
+func Allow(client string) (bool, int) {
+	if limiter == nil || limiter.limit <= 0 {
+		return true, 0
+	}
+	return limiter.allow(client)
+}
+
+// Snapshot returns the counters
+func Snapshot() Stats {
+	mu.Lock()
+	buffered := len(buffer)
+	mu.Unlock()
+	return Stats{
+		Sink:          sinkName,
+		Accepted:      accepted.Load(),
+		Rejected:      rejected.Load(),
+		Refused:       refused.Load(),
+		Written:       written.Load(),
+		Dropped:       dropped.Load(),
+		FlushFailures: flushFailures.Load(),
+		Buffered:      buffered,
+	}
+}
+
+// WriteMetrics writes the counters in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	if !Enabled() {
+		return
+	}
+	s := Snapshot()
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{sink=%q} %d\n", name, help, name, kind, name, s.Sink, value)
+	}
+	metric("analytics_events_accepted_total", "counter", "Events accepted into the buffer.", s.Accepted)
+	metric("analytics_events_rejected_total", "counter", "Events that failed validation.", s.Rejected)
+	metric("analytics_events_refused_total", "counter", "Events refused because the buffer was full.", s.Refused)
+	metric("analytics_events_written_total", "counter", "Events written to the sink.", s.Written)
+	metric("analytics_events_dropped_total", "counter", "Events dropped after the sink failed.", s.Dropped)
+	metric("analytics_flush_failures_total", "counter", "Failed writes to the sink.", s.FlushFailures)
+	metric("analytics_buffered_events", "gauge", "Events waiting to be written.", int64(s.Buffered))
+}
+
+// clientLimiter counts batches per client in fixed one-minute windows
+type clientLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	minute int64
+	counts map[string]int
+}
+
+func (l *clientLimiter) allow(client string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Unix()
+	if minute := now / 60; minute != l.minute {
+		l.minute = minute
+		l.counts = make(map[string]int)
+	}
+	l.counts[client]++
+	if l.counts[client] > l.limit {
+		return false, int(60 - now%60)
+	}
+	return true, 0
+}
@@ -0,0 +1,221 @@
+package analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/storage"
+)
+
+// Sink stores batches of analytics events
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+// newSink creates the sink named by ANALYTICS_SINK
+func newSink(cfg *config.Config) (Sink, error) {
+	switch cfg.AnalyticsSink {
+	case "mongo", "":
+		return NewMongoSink(cfg.AnalyticsEventTTL), nil
+	case "file":
+		return NewFileSink(cfg.AnalyticsFileDir)
+	case "kafka":
+		if cfg.AnalyticsKafkaRESTURL == "" {
+			return nil, errors.New("ANALYTICS_KAFKA_REST_URL is required for the kafka sink")
+		}
+		return NewKafkaSink(cfg.AnalyticsKafkaRESTURL, cfg.AnalyticsKafkaTopic), nil
+	case "s3":
+		if cfg.AnalyticsS3Bucket == "" || cfg.AWSAccessKeyID == "" || cfg.AWSSecretAccessKey == "" {
+			return nil, errors.New("ANALYTICS_S3_BUCKET and AWS credentials are required for the s3 sink")
+		}
+		creds := storage.Credentials{
+			AccessKey:    cfg.AWSAccessKeyID,
+			SecretKey:    cfg.AWSSecretAccessKey,
+			SessionToken: cfg.AWSSessionToken,
+			Region:       cfg.AnalyticsS3Region,
+		}
+		return &S3Sink{Bucket: storage.NewBucket(cfg.AnalyticsS3Bucket, cfg.AnalyticsS3Endpoint, creds), Prefix: cfg.AnalyticsS3Prefix}, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", cfg.AnalyticsSink)
+}
+
+// ndjson encodes events one per line
+func ndjson(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// MongoSink stores events in the analytics_events collection for ttl
+type MongoSink struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSink creates the collection's TTL index and returns the sink
+func NewMongoSink(ttl time.Duration) *MongoSink {
+	collection := database.DB.Collection("analytics_events")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.M{"received_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("analytics: failed to create TTL index: %v", err)
+	}
+	return &MongoSink{collection: collection}
+}
+
+// Write inserts the events, skipping ones already stored by a retried batch
+func (s *MongoSink) Write(ctx context.Context, events []Event) error {
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		docs[i] = event
+	}
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// FileSink appends events to one NDJSON file per hour in a directory, for
+// development or a log shipper to pick up
+type FileSink struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSink creates the directory
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Write appends the events to the current hour's file
+func (s *FileSink) Write(ctx context.Context, events []Event) error {
+	body, err := ndjson(events)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(s.dir, "events-"+clock.Now().UTC().Format("2006010215")+".ndjson")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// KafkaSink produces events to a Kafka topic through a Confluent REST Proxy
+// (v2 API), keyed by user or anonymous ID so a sender's events stay ordered
+type KafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaSink creates a sink for a REST proxy such as http://kafka-rest:8082
+func NewKafkaSink(proxyURL, topic string) *KafkaSink {
+	return &KafkaSink{
+		url:    strings.TrimRight(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Write produces the events as one request
+func (s *KafkaSink) Write(ctx context.Context, events []Event) error {
+	type record struct {
+		Key   string `json:"key,omitempty"`
+		Value Event  `json:"value"`
+	}
+	records := make([]record, len(events))
+	for i, event := range events {
+		key := event.UserID
+		if key == "" {
+			key = event.AnonymousID
+		}
+		records[i] = record{Key: key, Value: event}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka proxy returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// S3Sink uploads every batch as a gzipped NDJSON object under
+// <prefix>dt=<day>/, to AWS S3 or an S3 compatible store
+type S3Sink struct {
+	Bucket *storage.Bucket
+	Prefix string
+}
+
+// Write uploads the batch as one object
+func (s *S3Sink) Write(ctx context.Context, events []Event) error {
+	raw, err := ndjson(events)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(raw)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	now := clock.Now().UTC()
+	key := fmt.Sprintf("%sdt=%s/%s-%s.ndjson.gz", s.Prefix, now.Format("2006-01-02"), now.Format("150405"), events[0].ID.Hex())
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-ndjson")
+	header.Set("Content-Encoding", "gzip")
+	return s.Bucket.Put(ctx, key, body.Bytes(), header)
+}
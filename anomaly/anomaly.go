@@ -0,0 +1,177 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/notifier"
+)
+
+// Alert types raised by the analyzer
+const (
+	AlertMassDeletion       = "admin.mass_deletion"
+	AlertOffHoursRoleChange = "admin.off_hours_role_change"
+	AlertNewIP              = "admin.new_ip"
+)
+
+// Analyzer periodically scans the audit log for unusual admin behavior
+type Analyzer struct {
+	cfg      *config.Config
+	notifier *notifier.Notifier
+	since    time.Time
+	// alerted remembers mass deletion alerts so one burst raises one alert
+	alerted map[string]time.Time
+}
+
+// Start runs the analyzer in the background when anomaly detection is enabled
+func Start(cfg *config.Config, n *notifier.Notifier) {
+	if !cfg.AnomalyEnabled {
+		return
+	}
+
+	a := &Analyzer{
+		cfg:      cfg,
+		notifier: n,
+		since:    time.Now(),
+		alerted:  make(map[string]time.Time),
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.AnomalyInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := a.Run(context.Background()); err != nil {
+				log.Printf("anomaly: analysis failed: %v", err)
+			}
+		}
+	}()
+
+	log.Println("Admin anomaly detection started")
+}
+
+// Run analyzes audit entries recorded since the previous run
+func (a *Analyzer) Run(ctx context.Context) error {
+	now := time.Now()
+	collection := database.DB.Collection("audit_logs")
+
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := collection.Find(ctx, bson.M{"created_at": bson.M{"$gt": a.since, "$lte": now}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []models.AuditLog
+	if err := cursor.All(ctx, &entries); err != nil {
+		return err
+	}
+
+	if err := a.checkMassDeletions(ctx, now); err != nil {
+		return err
+	}
+
+	seenIPs := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Action == audit.ActionUpdateRole && a.offHours(entry.CreatedAt) {
+			a.notifier.Send(notifier.Event{
+				Type:     AlertOffHoursRoleChange,
+				Severity: notifier.SeverityWarning,
+				Message:  fmt.Sprintf("Admin %s changed the role of user %s outside business hours", entry.ActorID, entry.TargetID),
+				Data:     map[string]interface{}{"actor_id": entry.ActorID, "target_id": entry.TargetID, "at": entry.CreatedAt, "role": entry.After["role"]},
+			})
+		}
+
+		key := entry.ActorID + "|" + entry.IP
+		if entry.ActorID == "" || entry.IP == "" || seenIPs[key] {
+			continue
+		}
+		seenIPs[key] = true
+
+		known, err := a.knownIP(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if !known {
+			a.notifier.Send(notifier.Event{
+				Type:     AlertNewIP,
+				Severity: notifier.SeverityWarning,
+				Message:  fmt.Sprintf("Admin %s acted from a new IP address %s", entry.ActorID, entry.IP),
+				Data:     map[string]interface{}{"actor_id": entry.ActorID, "ip": entry.IP, "action": entry.Action, "at": entry.CreatedAt},
+			})
+		}
+	}
+
+	a.since = now
+	return nil
+}
+
+// checkMassDeletions alerts when an admin deleted more users than allowed within the window
+func (a *Analyzer) checkMassDeletions(ctx context.Context, now time.Time) error {
+	windowStart := now.Add(-a.cfg.AnomalyMassDeleteWindow)
+	pipeline := []bson.M{
+		{"$match": bson.M{"action": audit.ActionDeleteUser, "created_at": bson.M{"$gt": windowStart}}},
+		{"$group": bson.M{"_id": "$actor_id", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gte": a.cfg.AnomalyMassDeleteThreshold}}},
+	}
+
+	cursor, err := database.DB.Collection("audit_logs").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ActorID string `bson:"_id"`
+		Count   int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if last, ok := a.alerted[result.ActorID]; ok && last.After(windowStart) {
+			continue
+		}
+		a.alerted[result.ActorID] = now
+
+		a.notifier.Send(notifier.Event{
+			Type:     AlertMassDeletion,
+			Severity: notifier.SeverityCritical,
+			Message:  fmt.Sprintf("Admin %s deleted %d users in the last %s", result.ActorID, result.Count, a.cfg.AnomalyMassDeleteWindow),
+			Data:     map[string]interface{}{"actor_id": result.ActorID, "count": result.Count, "window": a.cfg.AnomalyMassDeleteWindow.String()},
+		})
+	}
+
+	return nil
+}
+
+// knownIP reports whether the actor used the entry's IP before the current analysis window
+func (a *Analyzer) knownIP(ctx context.Context, entry models.AuditLog) (bool, error) {
+	filter := bson.M{"actor_id": entry.ActorID, "ip": entry.IP, "created_at": bson.M{"$lte": a.since}}
+	count, err := database.DB.Collection("audit_logs").CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	// Admins without any earlier history are not flagged
+	history, err := database.DB.Collection("audit_logs").CountDocuments(ctx,
+		bson.M{"actor_id": entry.ActorID, "created_at": bson.M{"$lte": a.since}}, options.Count().SetLimit(1))
+	return history == 0, err
+}
+
+// offHours reports whether t falls outside the configured business hours (UTC)
+func (a *Analyzer) offHours(t time.Time) bool {
+	hour := t.UTC().Hour()
+	return hour < a.cfg.AnomalyBusinessHoursStart || hour >= a.cfg.AnomalyBusinessHoursEnd
+}
@@ -0,0 +1,158 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// KeyPrefix marks API keys so they are recognizable in logs and secret scanners
+const KeyPrefix = "gbk_"
+
+// ErrInvalidKey is returned for unknown, malformed or revoked keys
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Create issues a new API key for a user and returns the raw key, which is
+// not stored. With signed, requests made with the key must be signed, and the
+// signing secret is returned too.
+func Create(ctx context.Context, userID primitive.ObjectID, name string, scopes []string, signed bool) (*models.APIKey, string, string, error) {
+	secret, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, "", "", err
+	}
+	raw := KeyPrefix + secret
+
+	key := &models.APIKey{
+		ID:        clock.NewID(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:len(KeyPrefix)+6],
+		KeyHash:   utils.HashToken(raw),
+		Scopes:    scopes,
+		CreatedAt: clock.Now(),
+	}
+	var signingSecret string
+	if signed {
+		if signingSecret, key.SigningSecret, err = newSigningSecret(ctx); err != nil {
+			return nil, "", "", err
+		}
+		key.RequireSignature = true
+	}
+	if _, err := database.DB.Collection("api_keys").InsertOne(ctx, key); err != nil {
+		return nil, "", "", err
+	}
+	return key, raw, signingSecret, nil
+}
+
+// Authenticate resolves a raw key to its active APIKey and records its use
+func Authenticate(ctx context.Context, raw string) (*models.APIKey, error) {
+	if !strings.HasPrefix(raw, KeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	var key models.APIKey
+	filter := bson.M{"key_hash": utils.HashToken(raw), "revoked_at": nil}
+	if err := database.DB.Collection("api_keys").FindOne(ctx, filter).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+
+	go recordUsage(key)
+	return &key, nil
+}
+
+// HasScope reports whether a key grants a scope
+func HasScope(key *models.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns a user's keys, newest first
+func List(ctx context.Context, userID primitive.ObjectID) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := database.DB.Collection("api_keys").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := []models.APIKey{}
+	err = cursor.All(ctx, &keys)
+	return keys, err
+}
+
+// Revoke disables one of a user's keys. It returns mongo.ErrNoDocuments when
+// the user has no such active key.
+func Revoke(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	now := clock.Now()
+	result, err := database.DB.Collection("api_keys").UpdateOne(ctx,
+		bson.M{"_id": keyID, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": now}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// RevokeAll disables every active key of a user and returns how many were revoked
+func RevokeAll(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := database.DB.Collection("api_keys").UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": clock.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Usage returns a user's daily request counts per key since the given day
+func Usage(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]models.APIKeyUsage, error) {
+	filter := bson.M{"user_id": userID, "day": bson.M{"$gte": day(since)}}
+	opts := options.Find().SetSort(bson.M{"day": 1})
+	cursor, err := database.DB.Collection("api_key_usage").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	usage := []models.APIKeyUsage{}
+	err = cursor.All(ctx, &usage)
+	return usage, err
+}
+
+// recordUsage bumps the key's daily counter and last use time
+func recordUsage(key models.APIKey) {
+	ctx := context.Background()
+	now := clock.Now()
+
+	_, err := database.DB.Collection("api_key_usage").UpdateOne(ctx,
+		bson.M{"key_id": key.ID, "day": day(now)},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"user_id": key.UserID}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("apikeys: failed to record usage of %s: %v", key.ID.Hex(), err)
+	}
+
+	database.DB.Collection("api_keys").UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+}
+
+// day formats a time as a UTC calendar day
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,57 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-backend/config"
+	"golang-backend/dbtest"
+	"golang-backend/models"
+)
+
+func TestVerifySignatureRejectsReplays(t *testing.T) {
+	dbtest.Start(t)
+	conf = &config.Config{EncryptionKey: "0123456789abcdef0123456789abcdef", SignatureMaxSkew: time.Minute}
+	t.Cleanup(func() { conf = nil })
+
+	ctx := context.Background()
+	secret, encrypted, err := newSigningSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &models.APIKey{SigningSecret: encrypted}
+
+	sign := func(body string) (string, string) {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(StringToSign("POST", "/user/profile", timestamp, []byte(body))))
+		return timestamp, hex.EncodeToString(mac.Sum(nil))
+	}
+
+	timestamp, signature := sign(`{"name":"a"}`)
+	if err := VerifySignature(ctx, key, "POST", "/user/profile", timestamp, signature, []byte(`{"name":"a"}`)); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	for name, replay := range map[string]string{
+		"same":       signature,
+		"upper case": strings.ToUpper(signature),
+	} {
+		if err := VerifySignature(ctx, key, "POST", "/user/profile", timestamp, replay, []byte(`{"name":"a"}`)); err != ErrSignatureReplayed {
+			t.Errorf("%s signature replayed: got %v, want ErrSignatureReplayed", name, err)
+		}
+	}
+
+	if err := VerifySignature(ctx, key, "POST", "/user/profile", timestamp, signature, []byte(`{"name":"b"}`)); err != ErrSignatureInvalid {
+		t.Errorf("tampered body: got %v, want ErrSignatureInvalid", err)
+	}
+	timestamp, signature = sign(`{"name":"b"}`)
+	if err := VerifySignature(ctx, key, "POST", "/user/profile", timestamp, strings.ToUpper(signature), []byte(`{"name":"b"}`)); err != nil {
+		t.Errorf("upper-case first use: %v", err)
+	}
+}
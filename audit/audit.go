@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// Audit actions
+const (
+	ActionDeleteUser   = "user.delete"
+	ActionUpdateRole   = "user.role_update"
+	ActionImportUsers  = "user.import"
+	ActionUndo         = "operation.undo"
+	ActionSuspendUser  = "user.suspend"
+	ActionReportStatus = "report.status_update"
+	ActionForgetUser   = "user.forget"
+	ActionExportUsers  = "user.export"
+
+	ActionRoleGrantExpire = "user.role_grant_expire"
+	ActionTagUsers        = "user.tags_update"
+
+	ActionCreateOrg       = "org.create"
+	ActionAssignOrg       = "user.org_update"
+	ActionRotateOrgKey    = "org.key_rotate"
+	ActionDestroyOrgKeys  = "org.key_destroy"
+	ActionMoveOrgRegion   = "org.region_move"
+	ActionOrgBranding     = "org.branding_update"
+	ActionOrgDomainAdd    = "org.domain_add"
+	ActionOrgDomainVerify = "org.domain_verify"
+	ActionOrgDomainRemove = "org.domain_remove"
+	ActionOrgLimits       = "org.limits_update"
+	ActionSuspendOrg      = "org.suspend"
+	ActionArchiveOrg      = "org.archive"
+	ActionReactivateOrg   = "org.reactivate"
+	ActionExportOrg       = "org.export"
+	ActionDeleteOrg       = "org.delete"
+
+	ActionApprovalRequest = "approval.request"
+	ActionApprovalApprove = "approval.approve"
+	ActionApprovalReject  = "approval.reject"
+
+	ActionNameFilterAdd    = "name_filter.add"
+	ActionNameFilterRemove = "name_filter.remove"
+
+	ActionCustomFieldDefine = "custom_field.define"
+	ActionCustomFieldRemove = "custom_field.remove"
+
+	ActionSystemMessageCreate = "system_message.create"
+	ActionSystemMessageUpdate = "system_message.update"
+	ActionSystemMessageDelete = "system_message.delete"
+
+	ActionRevokeTokens      = "token.revoke_all"
+	ActionRotateJWTSecret   = "token.secret_rotate"
+	ActionRevokeCredentials = "user.credentials_revoke"
+
+	ActionReviewUpload   = "upload.review"
+	ActionDownloadUpload = "upload.download"
+
+	ActionBreakGlassIssue   = "break_glass.issue"
+	ActionBreakGlassRedeem  = "break_glass.redeem"
+	ActionBreakGlassRevoke  = "break_glass.revoke"
+	ActionBreakGlassRequest = "break_glass.request"
+
+	ActionDatabaseCutover = "database.cutover"
+
+	ActionRebuildUsers = "users.rebuild"
+
+	ActionActivateUser   = "user.activate"
+	ActionDeleteAccount  = "user.account_delete"
+	ActionChangePassword = "user.password_change"
+	ActionUpdatePhone    = "user.phone_update"
+	ActionAddPasskey     = "user.passkey_add"
+	ActionRemovePasskey  = "user.passkey_remove"
+
+	ActionRunJob = "job.run"
+
+	ActionCreateInvitation = "invitation.create"
+	ActionCreateAdmin      = "user.admin_create"
+	ActionRevokeInvitation = "invitation.revoke"
+
+	ActionLogArchiveVerify  = "log_archive.verify"
+	ActionLogArchiveRestore = "log_archive.restore"
+
+	ActionVerifyAuditChain = "audit.verify"
+
+	ActionUpdatePermissions = "permissions.update"
+
+	ActionRequest = "http.request"
+)
+
+// Record stores an audit entry for an action performed during the request.
+// The actor, client IP and correlation IDs are taken from the request.
+func Record(r *http.Request, action, targetID string, before, after bson.M) (primitive.ObjectID, error) {
+	ids := correlation.FromContext(r.Context())
+	return Insert(models.AuditLog{
+		ActorID:   ActorID(r),
+		Action:    action,
+		TargetID:  targetID,
+		Before:    before,
+		After:     after,
+		IP:        ClientIP(r),
+		RequestID: ids.RequestID,
+		TraceID:   ids.TraceID,
+	})
+}
+
+// Insert stores a prepared audit entry, for actions that complete outside a
+// request. The entry is chained to the ones before it; see Verify.
+func Insert(entry models.AuditLog) (primitive.ObjectID, error) {
+	if entry.ID.IsZero() {
+		entry.ID = clock.NewID()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = clock.Now()
+	}
+
+	return entry.ID, appendEntry(context.Background(), entry)
+}
+
+// Get returns a single audit entry by ID
+func Get(ctx context.Context, id primitive.ObjectID) (*models.AuditLog, error) {
+	var entry models.AuditLog
+	if err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id}).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ActorID returns the authenticated user ID from the request claims
+func ActorID(r *http.Request) string {
+	claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	id, _ := claims["userID"].(string)
+	return id
+}
+
+// ClientIP returns the remote IP of the request without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware records every authenticated request that may change state
+// (anything but GET, HEAD and OPTIONS) in a route group with its status, next
+// to the entries handlers record for specific actions
+func Middleware(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := utils.NewStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			after := bson.M{"group": group, "method": r.Method, "path": r.URL.Path, "status": sw.Status}
+			if _, err := Record(r, ActionRequest, r.URL.Path, nil, after); err != nil {
+				correlation.Errorf(r.Context(), "Failed to audit %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		})
+	}
+}
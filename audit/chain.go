@@ -0,0 +1,424 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// Entries are chained: each has a sequence number and stores the hash of the
+// one before it, and its own hash covers the stored document. The hash is
+// taken over the entry's BSON as written, so it does not depend on how the
+// driver orders maps or rounds times when the entry is read back. Fields
+// holding personal data are hashed one by one into personal_hashes, and
+// those together into personal_hash, which the entry's hash covers in their
+// place. Erasing a user's data replaces fields with Forgotten or removes
+// them; the stored hashes stand in for those fields alone, so the chain
+// stays intact while the fields left in a redacted entry are still checked.
+//
+// audit_chain holds the head of the chain, so entries deleted from its end
+// are noticed, and the anchor the remaining chain starts from once archived
+// entries are deleted.
+const (
+	collection      = "audit_logs"
+	chainCollection = "audit_chain"
+)
+
+// Forgotten replaces the user IDs erased from entries
+const Forgotten = "forgotten"
+
+// personalFields are hashed into personal_hashes, in this order
+var personalFields = []string{"actor_id", "target_id", "before", "after", "ip"}
+
+// unhashedFields hold the hashes themselves, and the mark of an erasure
+var unhashedFields = map[string]bool{"hash": true, "personal_hash": true, "personal_hashes": true, "redacted_at": true}
+
+// maxAppendAttempts bounds retries when other instances take the next
+// sequence number first
+const maxAppendAttempts = 20
+
+// maxProblems bounds the problems a verification lists
+const maxProblems = 100
+
+// errChainBusy is returned when an entry loses the race for a sequence
+// number too many times
+var errChainBusy = errors.New("audit chain busy")
+
+// chainMu orders the appends of this instance; other instances are kept
+// apart by the unique seq index
+var chainMu sync.Mutex
+
+// Mark is a position in the chain: a sequence number and the hash of the
+// entry there
+type Mark struct {
+	Seq  int64  `bson:"seq" json:"seq"`
+	Hash string `bson:"hash" json:"hash"`
+}
+
+// Init creates the audit log indexes. Entries written before chaining have
+// no seq and are left out of the unique index.
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"seq": 1}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"seq": bson.M{"$exists": true}})},
+		{Keys: bson.M{"created_at": 1}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("audit: failed to create indexes: %v", err)
+	}
+}
+
+// appendEntry links an entry to the end of the chain and stores it
+func appendEntry(ctx context.Context, entry models.AuditLog) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		last, err := chainEnd(ctx)
+		if err != nil {
+			return err
+		}
+		entry.Seq, entry.PrevHash = last.Seq+1, last.Hash
+		doc, hash, err := seal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = database.DB.Collection(collection).InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		advance(ctx, "head", Mark{Seq: entry.Seq, Hash: hash})
+		return nil
+	}
+	return errChainBusy
+}
+
+// chainEnd returns the last entry of the chain, or the recorded head when
+// that is further along, so entries appended after the end was deleted do
+// not hide the gap
+func chainEnd(ctx context.Context) (Mark, error) {
+	last, err := lastEntry(ctx)
+	if err != nil {
+		return last, err
+	}
+	head, err := chainMark(ctx, "head")
+	if err != nil {
+		return last, err
+	}
+	if head.Seq > last.Seq {
+		return head, nil
+	}
+	return last, nil
+}
+
+// lastEntry returns the last stored entry of the chain, zero when there is none
+func lastEntry(ctx context.Context) (Mark, error) {
+	var last Mark
+	opts := options.FindOne().SetSort(bson.M{"seq": -1}).SetProjection(bson.M{"seq": 1, "hash": 1})
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"seq": bson.M{"$exists": true}}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return Mark{}, nil
+	}
+	return last, err
+}
+
+// chainMark returns the head or anchor of the chain, zero when unset
+func chainMark(ctx context.Context, id string) (Mark, error) {
+	var point Mark
+	err := database.DB.Collection(chainCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&point)
+	if err == mongo.ErrNoDocuments {
+		return Mark{}, nil
+	}
+	return point, err
+}
+
+// advance moves the head or anchor of the chain forward to point. A mark
+// already further along makes the upsert collide, which is ignored.
+func advance(ctx context.Context, id string, point Mark) {
+	filter := bson.M{"_id": id, "seq": bson.M{"$lt": point.Seq}}
+	update := bson.M{"$set": bson.M{"seq": point.Seq, "hash": point.Hash, "updated_at": clock.Now()}}
+	_, err := database.DB.Collection(chainCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("audit: failed to move chain %s to %d: %v", id, point.Seq, err)
+	}
+}
+
+// seal marshals an entry and appends its personal_hashes, personal_hash and
+// hash, returning the document to store and its hash
+func seal(entry models.AuditLog) (bson.Raw, string, error) {
+	entry.PersonalHashes, entry.PersonalHash, entry.Hash, entry.RedactedAt = nil, "", "", nil
+	raw, err := bson.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	fields, personal, hash, err := digest(raw, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start, hashes := bsoncore.AppendArrayStart(nil)
+	for i, field := range fields {
+		hashes = bsoncore.AppendStringElement(hashes, strconv.Itoa(i), field)
+	}
+	hashes, _ = bsoncore.AppendArrayEnd(hashes, start)
+
+	doc := raw[:len(raw)-1]
+	doc = bsoncore.AppendArrayElement(doc, "personal_hashes", hashes)
+	doc = bsoncore.AppendStringElement(doc, "personal_hash", personal)
+	doc = bsoncore.AppendStringElement(doc, "hash", hash)
+	doc = append(doc, 0)
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc, hash, nil
+}
+
+// digest returns the hash of each personal field, the personal_hash and the
+// hash of a stored entry. With erased given, as the personal_hashes of a
+// redacted entry, a personal field that is missing or Forgotten is taken by
+// its erased hash; any other value is hashed as stored, so rewriting it is
+// found.
+func digest(raw bson.Raw, erased []string) ([]string, string, string, error) {
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, "", "", err
+	}
+	personal := make(map[string]bson.RawElement, len(personalFields))
+	core := sha256.New()
+	for _, element := range elements {
+		key := element.Key()
+		switch {
+		case unhashedFields[key]:
+		case isPersonal(key):
+			personal[key] = element
+		default:
+			core.Write(element)
+		}
+	}
+
+	if len(erased) != len(personalFields) {
+		erased = nil
+	}
+	fields := make([]string, len(personalFields))
+	all := sha256.New()
+	for i, key := range personalFields {
+		element, ok := personal[key]
+		if value, isString := element.Value().StringValueOK(); erased != nil && (!ok || isString && value == Forgotten) {
+			fields[i] = erased[i]
+		} else {
+			sum := sha256.Sum256(element)
+			fields[i] = hex.EncodeToString(sum[:])
+		}
+		all.Write([]byte(fields[i]))
+	}
+	personalHash := hex.EncodeToString(all.Sum(nil))
+	core.Write([]byte(personalHash))
+	return fields, personalHash, hex.EncodeToString(core.Sum(nil)), nil
+}
+
+func isPersonal(key string) bool {
+	for _, field := range personalFields {
+		if key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Truncate deletes the entries created in [from, to), once they are
+// archived, and anchors the rest of the chain so Verify does not report them
+// missing. The chain is cut before the first entry created since to; entries
+// after the cut are kept even when created earlier, by instances with skewed
+// clocks, and entries before it are kept when created before from, until
+// their own day is truncated. It returns how many entries it deleted.
+func Truncate(ctx context.Context, from, to time.Time) (int64, error) {
+	coll := database.DB.Collection(collection)
+
+	var first struct {
+		Seq      int64  `bson:"seq"`
+		PrevHash string `bson:"prev_hash"`
+	}
+	opts := options.FindOne().SetSort(bson.M{"seq": 1}).SetProjection(bson.M{"seq": 1, "prev_hash": 1})
+	err := coll.FindOne(ctx, bson.M{"seq": bson.M{"$exists": true}, "created_at": bson.M{"$gte": to}}, opts).Decode(&first)
+	anchor := Mark{Seq: first.Seq - 1, Hash: first.PrevHash}
+	if err == mongo.ErrNoDocuments {
+		// The whole chain goes; the head still tells whether it was whole
+		if anchor, err = lastEntry(ctx); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	if anchor.Seq > 0 {
+		advance(ctx, "anchor", anchor)
+	}
+
+	// Entries written before chaining have no seq and go by their time alone
+	filter := bson.M{"created_at": bson.M{"$gte": from, "$lt": to}, "$or": bson.A{
+		bson.M{"seq": bson.M{"$lte": anchor.Seq}},
+		bson.M{"seq": bson.M{"$exists": false}},
+	}}
+	result, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Problem is a break in the chain found by Verify
+type Problem struct {
+	Seq    int64  `json:"seq" example:"1042"`
+	ID     string `json:"id,omitempty" example:"507f1f77bcf86cd799439011"`
+	Kind   string `json:"kind" example:"hash_mismatch"`
+	Detail string `json:"detail" example:"entry does not match its hash"`
+}
+
+// Kinds of Problem
+const (
+	ProblemGap       = "gap"
+	ProblemLink      = "link_mismatch"
+	ProblemHash      = "hash_mismatch"
+	ProblemPersonal  = "personal_hash_mismatch"
+	ProblemUnsealed  = "unsealed"
+	ProblemTruncated = "truncated"
+)
+
+// Report is the outcome of Verify
+type Report struct {
+	OK bool `json:"ok" example:"true"`
+	// From and To are the first and last sequence numbers checked
+	From    int64 `json:"from" example:"1"`
+	To      int64 `json:"to" example:"1042"`
+	Checked int64 `json:"checked" example:"1042"`
+	// Redacted entries had personal data erased; the erased fields are
+	// taken by their stored hashes
+	Redacted int64 `json:"redacted" example:"3"`
+	// Unchained entries were written before chaining and cannot be checked
+	Unchained int64 `json:"unchained" example:"0"`
+	Anchor    Mark  `json:"anchor"`
+	Head      Mark  `json:"head"`
+	// Complete is false when the walk stopped at the limit before the head
+	Complete bool      `json:"complete" example:"true"`
+	Problems []Problem `json:"problems"`
+	// MoreProblems is set when problems beyond the first 100 were dropped
+	MoreProblems bool      `json:"more_problems,omitempty"`
+	VerifiedAt   time.Time `json:"verified_at"`
+}
+
+func (r *Report) add(p Problem) {
+	if len(r.Problems) >= maxProblems {
+		r.MoreProblems = true
+		return
+	}
+	r.Problems = append(r.Problems, p)
+}
+
+// Verify walks the chain from sequence number from (the start of the chain
+// when 0) through at most limit entries (no limit when 0), checking each
+// entry's hash and link to the one before, and that no sequence number is
+// missing. A walk that reaches the end also checks it against the recorded
+// head, so entries deleted from the end are found.
+func Verify(ctx context.Context, from, limit int64) (*Report, error) {
+	report := &Report{Problems: []Problem{}, VerifiedAt: clock.Now()}
+	var err error
+	if report.Anchor, err = chainMark(ctx, "anchor"); err != nil {
+		return nil, err
+	}
+	if report.Head, err = chainMark(ctx, "head"); err != nil {
+		return nil, err
+	}
+	coll := database.DB.Collection(collection)
+	if report.Unchained, err = coll.CountDocuments(ctx, bson.M{"seq": bson.M{"$exists": false}}); err != nil {
+		return nil, err
+	}
+
+	// The link into the first entry is checked against the anchor or the
+	// entry before, when there is one
+	expected := report.Anchor
+	linked := true
+	if from <= report.Anchor.Seq+1 {
+		from = report.Anchor.Seq + 1
+	} else if from > 1 {
+		var prev models.AuditLog
+		err := coll.FindOne(ctx, bson.M{"seq": from - 1}).Decode(&prev)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		expected, linked = Mark{Seq: from - 1, Hash: prev.Hash}, err == nil
+	}
+	report.From = from
+
+	opts := options.Find().SetSort(bson.M{"seq": 1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := coll.Find(ctx, bson.M{"seq": bson.M{"$gte": from}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var entry models.AuditLog
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		report.Checked++
+		report.To = entry.Seq
+		id := entry.ID.Hex()
+
+		if entry.Seq != expected.Seq+1 {
+			report.add(Problem{Seq: expected.Seq + 1, Kind: ProblemGap, Detail: fmt.Sprintf("entries %d to %d are missing", expected.Seq+1, entry.Seq-1)})
+		} else if linked && entry.PrevHash != expected.Hash {
+			report.add(Problem{Seq: entry.Seq, ID: id, Kind: ProblemLink, Detail: "entry does not link to the one before"})
+		}
+		expected, linked = Mark{Seq: entry.Seq, Hash: entry.Hash}, true
+
+		if entry.Hash == "" || entry.PersonalHash == "" {
+			report.add(Problem{Seq: entry.Seq, ID: id, Kind: ProblemUnsealed, Detail: "entry has no hash"})
+			continue
+		}
+		var erased []string
+		if entry.RedactedAt != nil {
+			report.Redacted++
+			erased = entry.PersonalHashes
+		}
+		_, personal, hash, err := digest(cursor.Current, erased)
+		if err != nil {
+			return nil, err
+		}
+		if personal != entry.PersonalHash {
+			report.add(Problem{Seq: entry.Seq, ID: id, Kind: ProblemPersonal, Detail: "personal fields do not match their hash"})
+		}
+		if hash != entry.Hash {
+			report.add(Problem{Seq: entry.Seq, ID: id, Kind: ProblemHash, Detail: "entry does not match its hash"})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Complete = limit == 0 || report.Checked < limit
+	if report.Complete && expected.Seq < report.Head.Seq {
+		report.add(Problem{Seq: expected.Seq + 1, Kind: ProblemTruncated, Detail: fmt.Sprintf("entries %d to %d at the end are missing", expected.Seq+1, report.Head.Seq)})
+	} else if report.Complete && expected.Seq == report.Head.Seq && linked && expected.Hash != report.Head.Hash {
+		report.add(Problem{Seq: expected.Seq, Kind: ProblemHash, Detail: "last entry does not match the recorded head"})
+	}
+	report.OK = len(report.Problems) == 0
+	return report, nil
+}
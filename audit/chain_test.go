@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/database"
+	"golang-backend/dbtest"
+	"golang-backend/models"
+)
+
+// chain stores three entries, the second about user "u2" by admin "a1"
+func chain(t *testing.T) {
+	t.Helper()
+	dbtest.Start(t)
+	entries := []models.AuditLog{
+		{ActorID: "a1", Action: ActionUpdateRole, TargetID: "u1", After: bson.M{"role": "admin"}, IP: "192.0.2.1"},
+		{ActorID: "a1", Action: ActionDeleteUser, TargetID: "u2", Before: bson.M{"email": "u2@example.com"}, IP: "192.0.2.1"},
+		{ActorID: "u3", Action: ActionUpdateRole, TargetID: "u1", IP: "198.51.100.7"},
+	}
+	for _, entry := range entries {
+		if _, err := Insert(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// edit applies update to the entry at seq
+func edit(t *testing.T, seq int64, update bson.M) {
+	t.Helper()
+	result, err := database.DB.Collection(collection).UpdateOne(context.Background(), bson.M{"seq": seq}, update)
+	if err != nil || result.MatchedCount != 1 {
+		t.Fatalf("edit %d: %v", seq, err)
+	}
+}
+
+// redact erases u2 from the second entry as forgetting the user does
+func redact(t *testing.T) {
+	edit(t, 2, bson.M{"$set": bson.M{"target_id": Forgotten, "redacted_at": time.Now().UTC()}, "$unset": bson.M{"before": "", "after": ""}})
+}
+
+func verify(t *testing.T) *Report {
+	t.Helper()
+	report, err := Verify(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	chain(t)
+	report := verify(t)
+	if !report.OK || report.Checked != 3 || report.Head.Seq != 3 || len(report.Problems) != 0 {
+		t.Fatalf("got %+v", report)
+	}
+}
+
+func TestVerifyRedactedChain(t *testing.T) {
+	chain(t)
+	redact(t)
+	report := verify(t)
+	if !report.OK || report.Redacted != 1 {
+		t.Fatalf("got %+v", report)
+	}
+}
+
+func TestVerifyFindsTampering(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(t *testing.T)
+		kind   string
+	}{
+		{"edited action", func(t *testing.T) { edit(t, 2, bson.M{"$set": bson.M{"action": ActionUpdateRole}}) }, ProblemHash},
+		{"edited personal field", func(t *testing.T) { edit(t, 2, bson.M{"$set": bson.M{"actor_id": "a2"}}) }, ProblemPersonal},
+		{"removed personal field", func(t *testing.T) { edit(t, 2, bson.M{"$unset": bson.M{"ip": ""}}) }, ProblemPersonal},
+		{"field kept by the redaction rewritten", func(t *testing.T) {
+			redact(t)
+			edit(t, 2, bson.M{"$set": bson.M{"actor_id": "a2"}})
+		}, ProblemPersonal},
+		{"erased field filled in", func(t *testing.T) {
+			redact(t)
+			edit(t, 2, bson.M{"$set": bson.M{"target_id": "u9"}})
+		}, ProblemPersonal},
+		{"erased hashes replaced", func(t *testing.T) {
+			redact(t)
+			edit(t, 2, bson.M{"$set": bson.M{"actor_id": Forgotten, "personal_hashes": []string{"", "", "", "", ""}}})
+		}, ProblemPersonal},
+		{"redaction marked without erasing", func(t *testing.T) {
+			edit(t, 2, bson.M{"$set": bson.M{"redacted_at": time.Now().UTC(), "target_id": "u9"}})
+		}, ProblemPersonal},
+		{"entry deleted", func(t *testing.T) {
+			database.DB.Collection(collection).DeleteOne(context.Background(), bson.M{"seq": 2})
+		}, ProblemGap},
+		{"last entry deleted", func(t *testing.T) {
+			database.DB.Collection(collection).DeleteOne(context.Background(), bson.M{"seq": 3})
+		}, ProblemTruncated},
+		{"link rewritten", func(t *testing.T) { edit(t, 3, bson.M{"$set": bson.M{"prev_hash": "0"}}) }, ProblemLink},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chain(t)
+			tc.tamper(t)
+			report := verify(t)
+			if report.OK {
+				t.Fatal("tampering not found")
+			}
+			for _, p := range report.Problems {
+				if p.Kind == tc.kind {
+					return
+				}
+			}
+			t.Fatalf("got %+v, want a %s", report.Problems, tc.kind)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package breakglass issues emergency admin access for deployments where
+// every admin account is locked out. A grant is created by adminctl on a
+// server host, which needs the deployment's database credentials, and printed
+// there once. Redeeming it at POST /admin/break-glass yields an admin session
+// that ends when the grant expires; the grant cannot be redeemed again. Every
+// step and every request made with the session is audited.
+package breakglass
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// TokenPrefix marks break-glass tokens so they are recognizable in logs and
+// secret scanners
+const TokenPrefix = "gbg_"
+
+// MaxTTL bounds how long a grant and its session last
+const MaxTTL = time.Hour
+
+// collection holds issued grants
+const collection = "break_glass_grants"
+
+// retention is how long expired grants are kept; the audit log keeps them
+// beyond that
+const retention = 90 * 24 * time.Hour
+
+// Errors returned by Create and Redeem
+var (
+	ErrDisabled     = errors.New("break-glass access is disabled")
+	ErrInvalidToken = errors.New("invalid, expired or already redeemed break-glass token")
+)
+
+var enabled bool
+
+// Init creates the indexes when BREAK_GLASS_ENABLED is set. Without it grants
+// can neither be issued nor redeemed.
+func Init(cfg *config.Config) {
+	enabled = cfg.BreakGlassEnabled
+	if !enabled {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds()))},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("breakglass: failed to create indexes: %v", err)
+	}
+}
+
+// Enabled reports whether break-glass access is configured
+func Enabled() bool {
+	return enabled
+}
+
+// Create issues a grant valid for ttl and returns it with the raw token,
+// which is not stored
+func Create(ctx context.Context, ttl time.Duration, reason, issuedBy string) (*models.BreakGlassGrant, string, error) {
+	if !enabled {
+		return nil, "", ErrDisabled
+	}
+	if ttl <= 0 || ttl > MaxTTL {
+		ttl = MaxTTL
+	}
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := TokenPrefix + secret
+
+	now := clock.Now()
+	grant := &models.BreakGlassGrant{
+		ID:        clock.NewID(),
+		TokenHash: utils.HashToken(raw),
+		Reason:    reason,
+		IssuedBy:  issuedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, grant); err != nil {
+		return nil, "", err
+	}
+	entry := models.AuditLog{
+		ActorID:  "adminctl",
+		Action:   audit.ActionBreakGlassIssue,
+		TargetID: grant.ID.Hex(),
+		After:    bson.M{"issued_by": issuedBy, "reason": reason, "expires_at": grant.ExpiresAt},
+	}
+	if _, err := audit.Insert(entry); err != nil {
+		// A grant that cannot be audited must not be usable
+		database.DB.Collection(collection).DeleteOne(ctx, bson.M{"_id": grant.ID})
+		return nil, "", err
+	}
+	return grant, raw, nil
+}
+
+// Redeem marks the grant of a raw token as used by ip and returns it. Tokens
+// that are unknown, expired, revoked or already redeemed return
+// ErrInvalidToken.
+func Redeem(ctx context.Context, raw, ip string) (*models.BreakGlassGrant, error) {
+	if !enabled {
+		return nil, ErrDisabled
+	}
+	if !strings.HasPrefix(raw, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	now := clock.Now()
+	filter := bson.M{
+		"token_hash":  utils.HashToken(raw),
+		"redeemed_at": nil,
+		"revoked_at":  nil,
+		"expires_at":  bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"redeemed_at": now, "redeemed_ip": ip}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var grant models.BreakGlassGrant
+	if err := database.DB.Collection(collection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&grant); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// Active reports whether the session of a redeemed grant may still be used
+func Active(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	if !enabled {
+		return false, nil
+	}
+	filter := bson.M{
+		"_id":         id,
+		"redeemed_at": bson.M{"$ne": nil},
+		"revoked_at":  nil,
+		"expires_at":  bson.M{"$gt": clock.Now()},
+	}
+	n, err := database.DB.Collection(collection).CountDocuments(ctx, filter)
+	return n > 0, err
+}
+
+// Revoke ends a grant, and the session redeemed with it, before it expires.
+// It returns mongo.ErrNoDocuments when there is no such unexpired grant.
+func Revoke(ctx context.Context, id primitive.ObjectID, revokedBy string) error {
+	result, err := database.DB.Collection(collection).UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": nil, "expires_at": bson.M{"$gt": clock.Now()}},
+		bson.M{"$set": bson.M{"revoked_at": clock.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = audit.Insert(models.AuditLog{
+		ActorID:  "adminctl",
+		Action:   audit.ActionBreakGlassRevoke,
+		TargetID: id.Hex(),
+		After:    bson.M{"revoked_by": revokedBy},
+	})
+	return err
+}
+
+// List returns the grants that have not expired, newest first
+func List(ctx context.Context) ([]models.BreakGlassGrant, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"expires_at": bson.M{"$gt": clock.Now()}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []models.BreakGlassGrant{}
+	err = cursor.All(ctx, &list)
+	return list, err
+}
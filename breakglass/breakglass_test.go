@@ -0,0 +1,148 @@
+package breakglass
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/dbtest"
+)
+
+// start enables grants under a clock the test moves
+func start(t *testing.T) *clock.Fixed {
+	t.Helper()
+	dbtest.Start(t)
+	now := clock.NewFixed(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(now, nil)
+	Init(&config.Config{BreakGlassEnabled: true})
+	t.Cleanup(func() {
+		clock.Set(nil, nil)
+		enabled = false
+	})
+	return now
+}
+
+func create(t *testing.T, ttl time.Duration) string {
+	t.Helper()
+	_, raw, err := Create(context.Background(), ttl, "locked out", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRedeemOnce(t *testing.T) {
+	now := start(t)
+	ctx := context.Background()
+	raw := create(t, 10*time.Minute)
+
+	grant, err := Redeem(ctx, raw, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.RedeemedAt == nil || grant.RedeemedIP != "192.0.2.1" {
+		t.Fatalf("got %+v", grant)
+	}
+	if active, err := Active(ctx, grant.ID); !active || err != nil {
+		t.Fatalf("session active %v, %v", active, err)
+	}
+	if _, err := Redeem(ctx, raw, "192.0.2.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("second redeem: got %v, want ErrInvalidToken", err)
+	}
+
+	// The session ends with the grant
+	now.Advance(10 * time.Minute)
+	if active, _ := Active(ctx, grant.ID); active {
+		t.Fatal("session outlived its grant")
+	}
+}
+
+func TestRedeemRejects(t *testing.T) {
+	cases := []struct {
+		name  string
+		token func(t *testing.T, now *clock.Fixed) string
+	}{
+		{"unknown", func(t *testing.T, now *clock.Fixed) string { return TokenPrefix + "unknown" }},
+		{"without prefix", func(t *testing.T, now *clock.Fixed) string {
+			return create(t, time.Minute)[len(TokenPrefix):]
+		}},
+		{"expired", func(t *testing.T, now *clock.Fixed) string {
+			raw := create(t, time.Minute)
+			now.Advance(time.Minute)
+			return raw
+		}},
+		{"revoked", func(t *testing.T, now *clock.Fixed) string {
+			grant, raw, err := Create(context.Background(), time.Minute, "locked out", "ops@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Revoke(context.Background(), grant.ID, "ops@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			return raw
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := start(t)
+			if _, err := Redeem(context.Background(), tc.token(t, now), "192.0.2.1"); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestCreateCapsTTL(t *testing.T) {
+	start(t)
+	for _, ttl := range []time.Duration{0, -time.Minute, 2 * MaxTTL} {
+		grant, _, err := Create(context.Background(), ttl, "locked out", "ops@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := grant.ExpiresAt.Sub(grant.CreatedAt); got != MaxTTL {
+			t.Fatalf("ttl %v: grant lasts %v", ttl, got)
+		}
+	}
+}
+
+func TestCreateAudited(t *testing.T) {
+	start(t)
+	grant, _, err := Create(context.Background(), time.Minute, "locked out", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := database.DB.Collection("audit_logs").CountDocuments(context.Background(), bson.M{"action": audit.ActionBreakGlassIssue, "target_id": grant.ID.Hex()})
+	if err != nil || n != 1 {
+		t.Fatalf("%d audit entries, %v", n, err)
+	}
+}
+
+func TestRevokeExpired(t *testing.T) {
+	now := start(t)
+	grant, _, err := Create(context.Background(), time.Minute, "locked out", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now.Advance(time.Minute)
+	if err := Revoke(context.Background(), grant.ID, "ops@example.com"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("got %v, want mongo.ErrNoDocuments", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	dbtest.Start(t)
+	Init(&config.Config{})
+	if _, _, err := Create(context.Background(), time.Minute, "locked out", "ops@example.com"); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("create: got %v, want ErrDisabled", err)
+	}
+	if _, err := Redeem(context.Background(), TokenPrefix+"x", "192.0.2.1"); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("redeem: got %v, want ErrDisabled", err)
+	}
+}
@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/config"
+	"golang-backend/tenant"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache tags grouping cached responses that are invalidated together
+const (
+	TagUsers = "users"
+	TagOrgs  = "orgs"
+)
+
+// Cache is a byte cache backend shared by all cached routes. Drivers are
+// MemoryStore and RistrettoStore in process, RedisStore and MemcachedStore shared
+// between instances, and TieredStore combining one of each.
+type Cache interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Incr(key string) (int64, error)
+	Delete(key string) error
+}
+
+// Stats are the cache counters since startup
+type Stats struct {
+	Backend       string `json:"backend"`
+	Hits          int64  `json:"hits"`
+	Misses        int64  `json:"misses"`
+	Stores        int64  `json:"stores"`
+	Invalidations int64  `json:"invalidations"`
+	Errors        int64  `json:"errors"`
+	Collapsed     int64  `json:"collapsed"`
+}
+
+// entry is a cached response
+type entry struct {
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+var (
+	store      Cache
+	backend    string
+	defaultTTL time.Duration
+	tagTTLs    map[string]time.Duration
+
+	hits, misses, stores, invalidations, failures, collapsed atomic.Int64
+
+	// flights collapses concurrent misses of the same key into one load
+	flights singleflight.Group
+)
+
+// Init selects the cache backend. Caching stays disabled when CACHE_ENABLED is false.
+func Init(cfg *config.Config) {
+	if !cfg.CacheEnabled {
+		return
+	}
+
+	defaultTTL = cfg.CacheTTL
+	tagTTLs = cfg.CacheTTLByTag
+
+	switch cfg.CacheBackend {
+	case "redis":
+		store = NewRedisStore(cfg.RedisAddr)
+	case "memcached":
+		store = NewMemcachedStore(cfg.MemcachedAddr)
+	case "ristretto", "tiered":
+		l1, err := NewRistrettoStore(cfg.CacheMaxBytes)
+		if err != nil {
+			log.Printf("cache: %v, caching disabled", err)
+			return
+		}
+		store = l1
+		if cfg.CacheBackend == "tiered" {
+			store = NewTieredStore(l1, NewRedisStore(cfg.RedisAddr), cfg.CacheL1TTL)
+		}
+	case "memory", "":
+		store = NewMemoryStore(time.Minute)
+	default:
+		log.Printf("cache: unknown backend %q, caching disabled", cfg.CacheBackend)
+		return
+	}
+	backend = cfg.CacheBackend
+	if backend == "" {
+		backend = "memory"
+	}
+}
+
+// Middleware caches successful GET responses of a route under a tag. Entries
+// are keyed by path, caller and query parameters. Concurrent misses of the
+// same key wait for the first one and share its response instead of all
+// running the handler.
+func Middleware(tag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if store == nil || r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := requestKey(r, tag)
+			if err != nil {
+				failures.Add(1)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if data, ok, err := store.Get(key); err != nil {
+				failures.Add(1)
+			} else if ok {
+				var cached entry
+				if err := json.Unmarshal(data, &cached); err == nil {
+					hits.Add(1)
+					writeEntry(w, cached, "HIT")
+					return
+				}
+			}
+			misses.Add(1)
+
+			leader := false
+			result, _, _ := flights.Do(key, func() (interface{}, error) {
+				leader = true
+				rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+				w.Header().Set("X-Cache", "MISS")
+				next.ServeHTTP(rec, r)
+
+				if rec.status != http.StatusOK {
+					return nil, nil
+				}
+				cached := entry{ContentType: w.Header().Get("Content-Type"), Body: rec.body.Bytes()}
+				data, _ := json.Marshal(cached)
+				if err := store.Set(key, data, ttlFor(tag)); err != nil {
+					failures.Add(1)
+				} else {
+					stores.Add(1)
+				}
+				return &cached, nil
+			})
+			if leader {
+				return
+			}
+
+			// The shared response failed; answer this request on its own
+			cached, ok := result.(*entry)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			collapsed.Add(1)
+			writeEntry(w, *cached, "HIT")
+		})
+	}
+}
+
+// writeEntry answers with a cached response
+func writeEntry(w http.ResponseWriter, cached entry, status string) {
+	w.Header().Set("Content-Type", cached.ContentType)
+	w.Header().Set("X-Cache", status)
+	w.Write(cached.Body)
+}
+
+// Load returns the value cached under key, calling load and caching its
+// result for ttl on a miss. Concurrent misses of the same key share one call
+// to load, which protects hot keys from stampedes. Without a backend it only
+// collapses concurrent calls.
+func Load(key string, ttl time.Duration, load func() ([]byte, error)) ([]byte, error) {
+	key = "load:" + key
+	if store != nil {
+		if data, ok, err := store.Get(key); err != nil {
+			failures.Add(1)
+		} else if ok {
+			hits.Add(1)
+			return data, nil
+		}
+		misses.Add(1)
+	}
+
+	value, err, shared := flights.Do(key, func() (interface{}, error) {
+		data, err := load()
+		if err != nil || store == nil {
+			return data, err
+		}
+		if err := store.Set(key, data, ttl); err != nil {
+			failures.Add(1)
+		} else {
+			stores.Add(1)
+		}
+		return data, nil
+	})
+	if shared {
+		collapsed.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+// Forget drops a value cached by Load
+func Forget(key string) {
+	if store == nil {
+		return
+	}
+	if err := store.Delete("load:" + key); err != nil {
+		failures.Add(1)
+		log.Printf("cache: failed to forget %s: %v", key, err)
+	}
+}
+
+// Invalidate drops every cached response under the given tags. Write handlers
+// call it after a successful change.
+func Invalidate(tags ...string) {
+	if store == nil {
+		return
+	}
+	for _, tag := range tags {
+		if _, err := store.Incr(generationKey(tag)); err != nil {
+			failures.Add(1)
+			log.Printf("cache: failed to invalidate %s: %v", tag, err)
+			continue
+		}
+		invalidations.Add(1)
+	}
+}
+
+// Snapshot returns the current cache counters
+func Snapshot() Stats {
+	return Stats{
+		Backend:       backend,
+		Hits:          hits.Load(),
+		Misses:        misses.Load(),
+		Stores:        stores.Load(),
+		Invalidations: invalidations.Load(),
+		Errors:        failures.Load(),
+		Collapsed:     collapsed.Load(),
+	}
+}
+
+// Enabled reports whether responses are being cached
+func Enabled() bool {
+	return store != nil
+}
+
+// Ping checks that the backend answers; it is a no-op when caching is disabled
+func Ping() error {
+	if store == nil {
+		return nil
+	}
+	_, _, err := store.Get("ping")
+	return err
+}
+
+// requestKey builds the cache key. The tag generation is part of the key, so
+// bumping it on invalidation orphans older entries until they expire. The
+// tenant is part of it too, since custom domains scope responses.
+func requestKey(r *http.Request, tag string) (string, error) {
+	gen, _, err := store.Get(generationKey(tag))
+	if err != nil {
+		return "", err
+	}
+
+	userID := ""
+	if claims, ok := r.Context().Value("claims").(jwt.MapClaims); ok {
+		userID, _ = claims["userID"].(string)
+	}
+
+	return "cache:" + tag + ":" + string(gen) + ":" + r.URL.Path + "|" + userID + "|" + tenant.OrgID(r) + "|" + r.URL.Query().Encode(), nil
+}
+
+// generationKey is the key holding a tag's invalidation counter
+func generationKey(tag string) string {
+	return "cache:gen:" + tag
+}
+
+// ttlFor returns the configured TTL of a tag
+func ttlFor(tag string) time.Duration {
+	if ttl, ok := tagTTLs[tag]; ok {
+		return ttl
+	}
+	return defaultTTL
+}
+
+// recorder captures the response body while passing it through
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
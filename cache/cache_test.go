@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// use makes s the cache backend for the rest of the test
+func use(t *testing.T, s Cache) {
+	t.Helper()
+	prevStore, prevTTL := store, defaultTTL
+	store, defaultTTL = s, time.Minute
+	t.Cleanup(func() { store, defaultTTL = prevStore, prevTTL })
+}
+
+// backends returns every store the middleware runs on, shared ones backed
+// by an in-process Redis
+func backends(t *testing.T) map[string]func(t *testing.T) Cache {
+	return map[string]func(t *testing.T) Cache{
+		"memory":    func(t *testing.T) Cache { return NewMemoryStore(time.Minute) },
+		"ristretto": func(t *testing.T) Cache { return ristrettoStore(t, 1<<20) },
+		"redis":     func(t *testing.T) Cache { return NewRedisStore(miniredis.RunT(t).Addr()) },
+		"tiered": func(t *testing.T) Cache {
+			return NewTieredStore(ristrettoStore(t, 1<<20), NewRedisStore(miniredis.RunT(t).Addr()), time.Second)
+		},
+	}
+}
+
+func ristrettoStore(t *testing.T, maxBytes int64) *RistrettoStore {
+	t.Helper()
+	s, err := NewRistrettoStore(maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// counted returns a handler answering with a fixed body and the number of
+// requests it served
+func counted() (http.Handler, *int) {
+	calls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}), &calls
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	return rec
+}
+
+func TestMiddlewareInvalidatesByTag(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			use(t, open(t))
+			users, userCalls := counted()
+			orgs, orgCalls := counted()
+			users, orgs = Middleware(TagUsers)(users), Middleware(TagOrgs)(orgs)
+
+			if rec := get(users, "/users"); rec.Header().Get("X-Cache") != "MISS" {
+				t.Fatalf("first request: X-Cache %q", rec.Header().Get("X-Cache"))
+			}
+			if rec := get(users, "/users"); rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != `{"ok":true}` || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("second request: X-Cache %q, body %s", rec.Header().Get("X-Cache"), rec.Body)
+			}
+			get(orgs, "/orgs")
+
+			Invalidate(TagUsers)
+			if get(users, "/users"); *userCalls != 2 {
+				t.Fatalf("users handler ran %d times, want 2 after invalidation", *userCalls)
+			}
+			if get(orgs, "/orgs"); *orgCalls != 1 {
+				t.Fatalf("orgs handler ran %d times, want 1: another tag was invalidated", *orgCalls)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSkipsFailedResponses(t *testing.T) {
+	use(t, NewMemoryStore(time.Minute))
+	calls := 0
+	h := Middleware(TagUsers)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	get(h, "/users")
+	if get(h, "/users"); calls != 2 {
+		t.Fatalf("handler ran %d times, want 2: errors must not be cached", calls)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	s := NewRedisStore(server.Addr())
+
+	if _, ok, err := s.Get("missing"); ok || err != nil {
+		t.Fatalf("missing key: ok %v, err %v", ok, err)
+	}
+	if err := s.Set("k", []byte("v"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := s.Get("k"); !ok || err != nil || string(value) != "v" {
+		t.Fatalf("got %q %v %v", value, ok, err)
+	}
+	server.FastForward(2 * time.Second)
+	if _, ok, _ := s.Get("k"); ok {
+		t.Fatal("value outlived its ttl")
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if n, err := s.Incr("n"); n != want || err != nil {
+			t.Fatalf("incr: got %d %v, want %d", n, err, want)
+		}
+	}
+	if err := s.Delete("n"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Eval(`return redis.call('INCRBY', KEYS[1], ARGV[1])`, "n", "5"); n != 5 || err != nil {
+		t.Fatalf("eval: got %d %v", n, err)
+	}
+}
+
+func TestRedisStoreDoesNotRetryCounters(t *testing.T) {
+	s := NewRedisStore(miniredis.RunT(t).Addr())
+	if s.once.Options().MaxRetries != 0 {
+		t.Fatalf("INCR and EVAL run with %d retries", s.once.Options().MaxRetries)
+	}
+	if s.client.Options().MaxRetries <= 0 {
+		t.Fatal("idempotent commands are not retried")
+	}
+}
+
+func TestRedisStoreDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	s := NewRedisStore(server.Addr())
+	server.Close()
+	use(t, s)
+	h, calls := counted()
+	if rec := get(Middleware(TagUsers)(h), "/users"); rec.Code != http.StatusOK || *calls != 1 {
+		t.Fatalf("got %d after %d calls, want the handler to answer", rec.Code, *calls)
+	}
+}
+
+func TestRistrettoStoreEvicts(t *testing.T) {
+	s := ristrettoStore(t, 1000)
+	value := make([]byte, 90)
+	for i := 0; i < 100; i++ {
+		s.Set("key"+strconv.Itoa(i), value, 0)
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if _, ok, _ := s.Get("key" + strconv.Itoa(i)); ok {
+			kept++
+		}
+	}
+	if kept == 0 || kept > 10 {
+		t.Fatalf("kept %d entries of 95 bytes in 1000", kept)
+	}
+
+	s.Set("huge", make([]byte, 2000), 0)
+	if _, ok, _ := s.Get("huge"); ok {
+		t.Fatal("kept a value larger than the store")
+	}
+}
+
+func TestRistrettoStoreExpires(t *testing.T) {
+	s := ristrettoStore(t, 1<<20)
+	s.Set("k", []byte("v"), 10*time.Millisecond)
+	if value, ok, _ := s.Get("k"); !ok || string(value) != "v" {
+		t.Fatalf("got %q %v right after Set", value, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := s.Get("k"); ok {
+		t.Fatal("value outlived its ttl")
+	}
+}
+
+func TestRistrettoStoreKeepsGenerations(t *testing.T) {
+	s := ristrettoStore(t, 1000)
+	use(t, s)
+	h, calls := counted()
+	h = Middleware(TagUsers)(h)
+	get(h, "/users")
+	Invalidate(TagUsers)
+
+	// Filling the store evicts entries but not the generation
+	for i := 0; i < 100; i++ {
+		s.Set("key"+strconv.Itoa(i), make([]byte, 90), 0)
+	}
+	if gen, ok, _ := s.Get(generationKey(TagUsers)); !ok || string(gen) != "1" {
+		t.Fatalf("generation %q %v, want 1", gen, ok)
+	}
+	get(h, "/users")
+	if *calls != 2 {
+		t.Fatalf("handler ran %d times, want 2", *calls)
+	}
+}
+
+func TestMemcachedKey(t *testing.T) {
+	if key := memcachedKey("cache:users:1:/admin/users"); key != "cache:users:1:/admin/users" {
+		t.Fatalf("valid key changed to %q", key)
+	}
+	for _, key := range []string{"with space", "new\nline", strings.Repeat("k", 251)} {
+		if hashed := memcachedKey(key); !strings.HasPrefix(hashed, "sha256:") || len(hashed) > 250 {
+			t.Fatalf("%q mapped to %q", key, hashed)
+		}
+	}
+}
+
+func TestMemcachedExpiry(t *testing.T) {
+	if n := expiry(0); n != 0 {
+		t.Fatalf("zero ttl: %d", n)
+	}
+	if n := expiry(1500 * time.Millisecond); n != 2 {
+		t.Fatalf("1.5s: %d, want 2", n)
+	}
+	if n := expiry(31 * 24 * time.Hour); int64(n) < time.Now().Unix() {
+		t.Fatalf("31 days: %d is not a timestamp", n)
+	}
+}
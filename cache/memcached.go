@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// maxRelativeExpiry is the longest expiry memcached reads as seconds from now;
+// longer ones are Unix timestamps
+const maxRelativeExpiry = 30 * 24 * time.Hour
+
+// MemcachedStore is a Cache shared between server instances, over a pool of
+// connections. Keys memcached would reject are replaced by their hash.
+type MemcachedStore struct {
+	client *memcache.Client
+}
+
+// NewMemcachedStore creates a MemcachedStore; connections are opened on first use
+func NewMemcachedStore(addr string) *MemcachedStore {
+	client := memcache.New(addr)
+	client.Timeout = 2 * time.Second
+	return &MemcachedStore{client: client}
+}
+
+// Get returns the value of a key
+func (s *MemcachedStore) Get(key string) ([]byte, bool, error) {
+	item, err := s.client.Get(memcachedKey(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+// Set stores a value, rounding the expiry up to whole seconds
+func (s *MemcachedStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(&memcache.Item{Key: memcachedKey(key), Value: value, Expiration: expiry(ttl)})
+}
+
+// Incr increments a counter, creating it at 1 when it does not exist
+func (s *MemcachedStore) Incr(key string) (int64, error) {
+	key = memcachedKey(key)
+	// Another instance may create the counter between incr and add
+	for attempt := 0; attempt < 2; attempt++ {
+		n, err := s.client.Increment(key, 1)
+		if err == nil {
+			return int64(n), nil
+		} else if !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, err
+		}
+
+		err = s.client.Add(&memcache.Item{Key: key, Value: []byte(strconv.Itoa(1))})
+		if err == nil {
+			return 1, nil
+		} else if !errors.Is(err, memcache.ErrNotStored) {
+			return 0, err
+		}
+	}
+	return 0, errors.New("memcached: counter kept changing during incr")
+}
+
+// Delete removes a key
+func (s *MemcachedStore) Delete(key string) error {
+	err := s.client.Delete(memcachedKey(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// memcachedKey returns key unchanged when memcached accepts it: at most 250
+// bytes without spaces or control characters. Others are hashed.
+func memcachedKey(key string) string {
+	valid := len(key) <= 250
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] != 0x7f
+	}
+	if valid {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// expiry converts a ttl to memcached's exptime; zero never expires
+func expiry(ttl time.Duration) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > maxRelativeExpiry {
+		return int32(time.Now().Unix() + seconds)
+	}
+	return int32(seconds)
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Cache; each server instance has its own cache
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a MemoryStore that sweeps expired entries every interval
+func NewMemoryStore(interval time.Duration) *MemoryStore {
+	s := &MemoryStore{entries: make(map[string]memoryEntry)}
+	go func() {
+		for range time.Tick(interval) {
+			s.sweep()
+		}
+	}()
+	return s
+}
+
+// Get returns a value that has not expired
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set stores a value; a zero ttl never expires
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	s.entries[key] = e
+	return nil
+}
+
+// Incr increments a counter stored as a decimal string
+func (s *MemoryStore) Incr(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, _ := strconv.ParseInt(string(s.entries[key].value), 10, 64)
+	n++
+	s.entries[key] = memoryEntry{value: []byte(strconv.FormatInt(n, 10))}
+	return n, nil
+}
+
+// Delete removes a key
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep removes expired entries
+func (s *MemoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, e := range s.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds every command, as the cache must never hold up a request
+const redisTimeout = 2 * time.Second
+
+// RedisStore is a Cache shared between server instances, over a pool of
+// connections. Commands that are safe to repeat are retried on network
+// errors; INCR and EVAL are not, since a reply lost after the server ran
+// them would count twice.
+type RedisStore struct {
+	client *redis.Client
+	// once runs the commands that must not be retried
+	once *redis.Client
+}
+
+// NewRedisStore creates a RedisStore; connections are opened on first use
+func NewRedisStore(addr string) *RedisStore {
+	opts := &redis.Options{Addr: addr, DialTimeout: redisTimeout, ReadTimeout: redisTimeout, WriteTimeout: redisTimeout}
+	onceOpts := *opts
+	onceOpts.MaxRetries = -1
+	return &RedisStore{client: redis.NewClient(opts), once: redis.NewClient(&onceOpts)}
+}
+
+// Get returns the value of a key
+func (s *RedisStore) Get(key string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores a value; a zero ttl never expires
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Incr increments a counter
+func (s *RedisStore) Incr(key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.once.Incr(ctx, key).Result()
+}
+
+// Delete removes a key
+func (s *RedisStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, key).Err()
+}
+
+// Eval runs a Lua script on one key and returns its integer reply, for
+// updates that must be atomic across instances
+func (s *RedisStore) Eval(script, key string, args ...string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	argv := make([]interface{}, len(args))
+	for i, arg := range args {
+		argv[i] = arg
+	}
+	return s.once.Eval(ctx, script, []string{key}, argv...).Int64()
+}
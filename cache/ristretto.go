@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// RistrettoStore is an in-process Cache bounded by the total size of its
+// keys and values. When full, ristretto admits and evicts by how often keys
+// are used, so hot keys stay cached under memory pressure. Counters are kept
+// apart, since evicting a tag's generation would bring back the entries its
+// invalidation orphaned.
+type RistrettoStore struct {
+	cache *ristretto.Cache[string, []byte]
+
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// NewRistrettoStore creates a RistrettoStore holding up to maxBytes of keys
+// and values
+func NewRistrettoStore(maxBytes int64) (*RistrettoStore, error) {
+	c, err := ristretto.NewCache(&ristretto.Config[string, []byte]{
+		// Ten counters per entry, for entries of about 1 KB
+		NumCounters:        max(maxBytes/100, 1000),
+		MaxCost:            maxBytes,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RistrettoStore{cache: c, counters: make(map[string]int64)}, nil
+}
+
+// Get returns a value that has not expired
+func (s *RistrettoStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	n, ok := s.counters[key]
+	s.mu.Unlock()
+	if ok {
+		return []byte(strconv.FormatInt(n, 10)), true, nil
+	}
+	value, ok := s.cache.Get(key)
+	return value, ok, nil
+}
+
+// Set stores a value; a zero ttl never expires. The value is readable once
+// Set returns, unless ristretto declined to admit it.
+func (s *RistrettoStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	delete(s.counters, key)
+	s.mu.Unlock()
+	if s.cache.SetWithTTL(key, value, int64(len(key)+len(value)), ttl) {
+		s.cache.Wait()
+	}
+	return nil
+}
+
+// Incr increments a counter
+func (s *RistrettoStore) Incr(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[key]++
+	return s.counters[key], nil
+}
+
+// Delete removes a key
+func (s *RistrettoStore) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.counters, key)
+	s.mu.Unlock()
+	s.cache.Del(key)
+	return nil
+}
@@ -0,0 +1,59 @@
+package cache
+
+import "time"
+
+// TieredStore puts an in-process L1 cache in front of a shared L2 cache.
+// Reads are served from L1 when possible and fill it from L2; writes go to
+// both. L1 entries live at most l1TTL, which bounds how stale a value written
+// by another instance can be.
+type TieredStore struct {
+	l1, l2 Cache
+	l1TTL  time.Duration
+}
+
+// NewTieredStore combines an L1 and an L2 cache
+func NewTieredStore(l1, l2 Cache, l1TTL time.Duration) *TieredStore {
+	return &TieredStore{l1: l1, l2: l2, l1TTL: l1TTL}
+}
+
+// Get returns a value from L1, or from L2 and keeps it in L1
+func (s *TieredStore) Get(key string) ([]byte, bool, error) {
+	if value, ok, err := s.l1.Get(key); err == nil && ok {
+		return value, true, nil
+	}
+
+	value, ok, err := s.l2.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	s.l1.Set(key, value, s.l1TTL)
+	return value, true, nil
+}
+
+// Set stores a value in L2, then in L1 for at most l1TTL
+func (s *TieredStore) Set(key string, value []byte, ttl time.Duration) error {
+	if err := s.l2.Set(key, value, ttl); err != nil {
+		return err
+	}
+	if ttl <= 0 || ttl > s.l1TTL {
+		ttl = s.l1TTL
+	}
+	return s.l1.Set(key, value, ttl)
+}
+
+// Incr increments a counter in L2 and drops the L1 copy, so this instance
+// sees the new value at once
+func (s *TieredStore) Incr(key string) (int64, error) {
+	n, err := s.l2.Incr(key)
+	if err != nil {
+		return 0, err
+	}
+	s.l1.Delete(key)
+	return n, nil
+}
+
+// Delete removes a key from both tiers
+func (s *TieredStore) Delete(key string) error {
+	s.l1.Delete(key)
+	return s.l2.Delete(key)
+}
@@ -0,0 +1,120 @@
+package challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang-backend/audit"
+	"golang-backend/config"
+)
+
+// captchaVerifyURLs are the siteverify endpoints of the CAPTCHA providers
+var captchaVerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// captchaFlows require a CAPTCHA on every attempt
+var captchaFlows = make(map[string]bool)
+
+// captcha verifies CAPTCHA responses with the provider's siteverify
+// endpoint, which hCaptcha, reCAPTCHA and Turnstile share. Providers reject
+// a response verified before, so solved CAPTCHAs cannot be replayed.
+type captcha struct {
+	verifyURL string
+	secret    string
+	siteKey   string
+	minScore  float64
+	client    *http.Client
+}
+
+func newCaptcha(cfg *config.Config) (*captcha, error) {
+	verifyURL := cfg.CaptchaVerifyURL
+	if verifyURL == "" {
+		verifyURL = captchaVerifyURLs[strings.ToLower(cfg.CaptchaProvider)]
+	}
+	if verifyURL == "" {
+		return nil, fmt.Errorf("unknown CAPTCHA_PROVIDER %q", cfg.CaptchaProvider)
+	}
+	return &captcha{
+		verifyURL: verifyURL,
+		secret:    cfg.CaptchaSecret,
+		siteKey:   cfg.CaptchaSiteKey,
+		minScore:  cfg.CaptchaMinScore,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (c *captcha) Params() map[string]string {
+	return map[string]string{"site_key": c.siteKey}
+}
+
+func (c *captcha) Verify(r *http.Request, flow, key, response string) (bool, error) {
+	form := url.Values{"secret": {c.secret}, "response": {response}}
+	if ip := audit.ClientIP(r); ip != "" {
+		form.Set("remoteip", ip)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+		// Score is only returned by reCAPTCHA v3, from 0 (a bot) to 1
+		Score *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if result.Success && result.Score != nil && *result.Score < c.minScore {
+		return false, nil
+	}
+	return result.Success, nil
+}
+
+// initCaptchaFlows reads the flows that require a CAPTCHA on every attempt
+func initCaptchaFlows(cfg *config.Config) {
+	captchaFlows = make(map[string]bool)
+	for _, flow := range cfg.CaptchaFlows {
+		captchaFlows[strings.TrimSpace(flow)] = true
+	}
+	if _, ok := verifiers[KindCaptcha]; !ok && len(captchaFlows) > 0 {
+		log.Printf("challenge: CAPTCHA is not configured, CAPTCHA_FLOWS have no effect until a verifier is registered")
+	}
+}
+
+// CaptchaRequired reports whether every attempt of a flow must carry a
+// CAPTCHA token, whatever its failures. Flows are not gated while no
+// CAPTCHA verifier is registered.
+func CaptchaRequired(flow string) bool {
+	_, ok := verifiers[KindCaptcha]
+	return ok && captchaFlows[flow]
+}
+
+// CaptchaParams returns the public values a client needs to present the
+// CAPTCHA, such as the site key
+func CaptchaParams() map[string]string {
+	if v, ok := verifiers[KindCaptcha]; ok {
+		return v.Params()
+	}
+	return nil
+}
+
+// CheckCaptcha verifies the CAPTCHA token of an attempt with the registered
+// captcha verifier
+func CheckCaptcha(r *http.Request, flow, key, token string) (bool, error) {
+	return Check(r, &Required{Kind: KindCaptcha}, flow, key, token)
+}
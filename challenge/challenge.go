@@ -0,0 +1,235 @@
+// Package challenge makes sensitive flows progressively harder after repeated
+// failures instead of refusing them: past a first threshold the client must
+// solve a CAPTCHA, past a second it must enter a code emailed to the account.
+// Failures are counted per flow and key (for login, the email blind index, so
+// unknown accounts behave like real ones) over CHALLENGE_WINDOW, in the
+// database so every instance agrees.
+//
+// Flows use it in three calls: Require before doing the work, Fail when the
+// attempt fails and Reset when it succeeds. Challenge kinds are pluggable
+// through Register. A step can be limited to one flow ("register:pow=0"),
+// and a threshold of 0 requires the challenge from the first attempt.
+//
+// Flows listed in CAPTCHA_FLOWS also require a CAPTCHA token on every
+// attempt, checked with CheckCaptcha before the progressive challenges.
+package challenge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Flows using challenges
+const (
+	FlowLogin         = "login"
+	FlowRegister      = "register"
+	FlowPasswordReset = "password_reset"
+	FlowMagicLink     = "magic_link"
+	FlowSudo          = "sudo"
+	FlowAvailability  = "availability"
+	FlowLoginOTP      = "login_otp"
+)
+
+// Challenge kinds
+const (
+	KindCaptcha     = "captcha"
+	KindEmailOTP    = "email_otp"
+	KindProofOfWork = "pow"
+)
+
+// collection counts recent failures per flow and key
+const collection = "challenge_failures"
+
+// Verifier checks the response to one kind of challenge
+type Verifier interface {
+	// Params are public values the client needs to present the challenge,
+	// such as a CAPTCHA site key
+	Params() map[string]string
+	// Verify reports whether response solves the challenge for the flow and
+	// key; a solved challenge cannot be reused
+	Verify(r *http.Request, flow, key, response string) (bool, error)
+}
+
+// Issuer is implemented by verifiers whose challenge differs per attempt,
+// such as a proof-of-work puzzle. Require asks it for the params instead of
+// calling Params, passing how many failures for key exceed the step's
+// threshold.
+type Issuer interface {
+	Issue(flow, key string, failures int) (map[string]string, error)
+}
+
+// Required describes the challenge a client must solve
+type Required struct {
+	Kind   string            `json:"challenge" example:"captcha"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// step requires a challenge kind from a number of failures on, in one flow
+// or in all when flow is empty
+type step struct {
+	flow  string
+	kind  string
+	after int
+}
+
+var (
+	steps     []step
+	window    time.Duration
+	verifiers = make(map[string]Verifier)
+)
+
+// Init registers the built-in challenges and parses CHALLENGE_STEPS. Steps
+// whose challenge is not configured are skipped.
+func Init(cfg *config.Config) {
+	window = cfg.ChallengeWindow
+	if cfg.CaptchaSecret != "" {
+		if c, err := newCaptcha(cfg); err != nil {
+			log.Printf("challenge: %v, CAPTCHAs are off", err)
+		} else {
+			Register(KindCaptcha, c)
+		}
+	}
+	Register(KindEmailOTP, newEmailOTP(cfg))
+	Register(KindProofOfWork, newProofOfWork(cfg))
+	initCaptchaFlows(cfg)
+
+	steps = nil
+	for name, value := range cfg.ChallengeSteps {
+		flow, kind, scoped := strings.Cut(name, ":")
+		if !scoped {
+			flow, kind = "", name
+		}
+		after, err := strconv.Atoi(value)
+		if err != nil || after < 0 {
+			log.Printf("challenge: invalid threshold %q for %s in CHALLENGE_STEPS, ignoring", value, name)
+			continue
+		}
+		if _, ok := verifiers[kind]; !ok {
+			log.Printf("challenge: %s is not configured, ignoring its step", kind)
+			continue
+		}
+		steps = append(steps, step{flow: flow, kind: kind, after: after})
+	}
+	// Highest threshold first, so Require finds the strongest step reached
+	sort.Slice(steps, func(i, j int) bool { return steps[i].after > steps[j].after })
+
+	// Indexed even without steps, for challenges demanded outright
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("challenge: failed to create TTL index: %v", err)
+	}
+	initOTP(ctx)
+	initProofOfWork(ctx)
+}
+
+// Register adds or replaces the verifier of a challenge kind. Kinds only
+// take effect when CHALLENGE_STEPS gives them a threshold.
+func Register(kind string, v Verifier) {
+	verifiers[kind] = v
+}
+
+// Require returns the challenge the next attempt of a flow for key must
+// solve, or nil when none is due
+func Require(ctx context.Context, flow, key string) (*Required, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	var doc struct {
+		Count int `bson:"count"`
+	}
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": flow + ":" + key, "expires_at": bson.M{"$gt": clock.Now()}}).Decode(&doc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	for _, s := range steps {
+		if (s.flow != "" && s.flow != flow) || doc.Count < s.after {
+			continue
+		}
+		v := verifiers[s.kind]
+		if issuer, ok := v.(Issuer); ok {
+			params, err := issuer.Issue(flow, key, doc.Count-s.after)
+			if err != nil {
+				return nil, err
+			}
+			return &Required{Kind: s.kind, Params: params}, nil
+		}
+		return &Required{Kind: s.kind, Params: v.Params()}, nil
+	}
+	return nil, nil
+}
+
+// Demand returns a challenge of kind that every attempt of a flow must
+// solve, whatever its failures, such as the puzzle in front of availability
+// checks. failures raises the difficulty of challenges that issue their own.
+func Demand(flow, key, kind string, failures int) (*Required, error) {
+	v, ok := verifiers[kind]
+	if !ok {
+		return nil, fmt.Errorf("challenge kind %s is not configured", kind)
+	}
+	if issuer, ok := v.(Issuer); ok {
+		params, err := issuer.Issue(flow, key, failures)
+		if err != nil {
+			return nil, err
+		}
+		return &Required{Kind: kind, Params: params}, nil
+	}
+	return &Required{Kind: kind, Params: v.Params()}, nil
+}
+
+// Check verifies the response to a required challenge
+func Check(r *http.Request, required *Required, flow, key, response string) (bool, error) {
+	if response == "" {
+		return false, nil
+	}
+	v, ok := verifiers[required.Kind]
+	if !ok {
+		return false, fmt.Errorf("no verifier for %s challenges", required.Kind)
+	}
+	return v.Verify(r, flow, key, response)
+}
+
+// Fail counts a failed attempt. The count restarts CHALLENGE_WINDOW after
+// the first failure.
+func Fail(ctx context.Context, flow, key string) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	now := clock.Now()
+	collection := database.DB.Collection(collection)
+	id := flow + ":" + key
+	// A lapsed window the TTL monitor has not removed yet starts over
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": id, "expires_at": bson.M{"$lte": now}}); err != nil {
+		return err
+	}
+	update := bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expires_at": now.Add(window)},
+	}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// Reset forgets the failures of a flow for key after a successful attempt
+func Reset(ctx context.Context, flow, key string) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	_, err := database.DB.Collection(collection).DeleteOne(ctx, bson.M{"_id": flow + ":" + key})
+	return err
+}
@@ -0,0 +1,150 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// otps holds the pending code of each flow and key
+const otps = "challenge_otps"
+
+const (
+	// otpDigits is the length of emailed codes
+	otpDigits = 6
+	// otpMaxAttempts is how many wrong codes void a code
+	otpMaxAttempts = 5
+)
+
+// ErrOTPCooldown is returned by IssueOTP when a code was sent too recently
+var ErrOTPCooldown = errors.New("a code was sent recently")
+
+// emailOTP verifies codes sent by IssueOTP
+type emailOTP struct {
+	ttl      time.Duration
+	cooldown time.Duration
+}
+
+// otp is a pending code
+type otp struct {
+	ID        string    `bson:"_id"`
+	CodeHash  string    `bson:"code_hash"`
+	Attempts  int       `bson:"attempts"`
+	SentAt    time.Time `bson:"sent_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+var codes *emailOTP
+
+func newEmailOTP(cfg *config.Config) *emailOTP {
+	codes = &emailOTP{ttl: cfg.ChallengeOTPTTL, cooldown: cfg.ChallengeOTPCooldown}
+	return codes
+}
+
+func initOTP(ctx context.Context) {
+	index := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.DB.Collection(otps).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("challenge: failed to create OTP TTL index: %v", err)
+	}
+}
+
+func (e *emailOTP) Params() map[string]string {
+	return nil
+}
+
+// Verify consumes the pending code when response matches it, and counts a
+// wrong attempt otherwise
+func (e *emailOTP) Verify(r *http.Request, flow, key, response string) (bool, error) {
+	ctx := r.Context()
+	collection := database.DB.Collection(otps)
+	id := flow + ":" + key
+	var pending otp
+	err := collection.FindOne(ctx, bson.M{"_id": id, "expires_at": bson.M{"$gt": clock.Now()}}).Decode(&pending)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pending.Attempts >= otpMaxAttempts {
+		return false, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(id+":"+response)), []byte(pending.CodeHash)) != 1 {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": id, "code_hash": pending.CodeHash}, bson.M{"$inc": bson.M{"attempts": 1}})
+		return false, err
+	}
+	// Only the request deleting the code may use it
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "code_hash": pending.CodeHash})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// IssueOTP creates a code for the flow and key and hands it to send, which
+// delivers it. A new code replaces the pending one, at most once per
+// CHALLENGE_OTP_COOLDOWN.
+func IssueOTP(ctx context.Context, flow, key string, send func(code string) error) error {
+	if codes == nil {
+		return errors.New("email codes are not configured")
+	}
+	code, err := randomCode()
+	if err != nil {
+		return err
+	}
+	id := flow + ":" + key
+	now := clock.Now()
+	collection := database.DB.Collection(otps)
+
+	// Replace the code unless one was sent within the cooldown; the duplicate
+	// key error of the upsert means one was
+	filter := bson.M{"_id": id, "$or": bson.A{
+		bson.M{"sent_at": bson.M{"$lte": now.Add(-codes.cooldown)}},
+		bson.M{"expires_at": bson.M{"$lte": now}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"code_hash":  utils.HashToken(id + ":" + code),
+		"attempts":   0,
+		"sent_at":    now,
+		"expires_at": now.Add(codes.ttl),
+	}}
+	_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrOTPCooldown
+	}
+	if err != nil {
+		return err
+	}
+	if err := send(code); err != nil {
+		collection.DeleteOne(ctx, bson.M{"_id": id, "sent_at": now})
+		return err
+	}
+	return nil
+}
+
+// randomCode returns a uniformly random numeric code
+func randomCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
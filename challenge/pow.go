@@ -0,0 +1,165 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// solvedPuzzles remembers solved puzzles until they expire, so each is
+// accepted once
+const solvedPuzzles = "challenge_pow"
+
+// maxNonceLength bounds the client's part of a solution
+const maxNonceLength = 64
+
+// proofOfWork is a CAPTCHA-free challenge that needs no third party: the
+// client searches for a nonce such that SHA-256(puzzle ":" nonce) starts
+// with difficulty zero bits and answers "puzzle:nonce". Puzzles are signed
+// rather than stored, so any instance can check them. Difficulty rises with
+// the key's failures past the step's threshold and while the instance is
+// handing out puzzles faster than CHALLENGE_POW_SURGE per minute.
+type proofOfWork struct {
+	key        []byte
+	difficulty int
+	max        int
+	surge      int
+	ttl        time.Duration
+
+	mu          sync.Mutex
+	minute      time.Time
+	issuedCount int
+}
+
+func newProofOfWork(cfg *config.Config) *proofOfWork {
+	// Derived from the encryption key so every instance signs alike
+	key := sha256.Sum256([]byte("challenge-pow\x00" + cfg.EncryptionKey))
+	return &proofOfWork{
+		key:        key[:],
+		difficulty: cfg.ChallengePoWDifficulty,
+		max:        cfg.ChallengePoWMaxDifficulty,
+		surge:      cfg.ChallengePoWSurge,
+		ttl:        cfg.ChallengePoWTTL,
+	}
+}
+
+func initProofOfWork(ctx context.Context) {
+	index := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.DB.Collection(solvedPuzzles).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("challenge: failed to create proof-of-work TTL index: %v", err)
+	}
+}
+
+// Params is unused; puzzles come from Issue
+func (p *proofOfWork) Params() map[string]string {
+	return nil
+}
+
+// Issue signs a new puzzle for the flow and key
+func (p *proofOfWork) Issue(flow, key string, failures int) (map[string]string, error) {
+	difficulty := p.difficulty + failures
+	if p.surging() {
+		difficulty += 2
+	}
+	if difficulty > p.max {
+		difficulty = p.max
+	}
+
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	expires := clock.Now().Add(p.ttl).Unix()
+	body := fmt.Sprintf("%s.%d.%d", nonce, expires, difficulty)
+	puzzle := body + "." + p.sign(flow, key, body)
+	return map[string]string{
+		"puzzle":     puzzle,
+		"difficulty": strconv.Itoa(difficulty),
+		"algorithm":  "sha256",
+	}, nil
+}
+
+// surging counts an issued puzzle and reports whether this minute's count
+// is over the surge rate
+func (p *proofOfWork) surging() bool {
+	if p.surge <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	minute := time.Now().Truncate(time.Minute)
+	if !minute.Equal(p.minute) {
+		p.minute, p.issuedCount = minute, 0
+	}
+	p.issuedCount++
+	return p.issuedCount > p.surge
+}
+
+// sign binds a puzzle to the flow and key it was issued for
+func (p *proofOfWork) sign(flow, key, body string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(flow + "\x00" + key + "\x00" + body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *proofOfWork) Verify(r *http.Request, flow, key, response string) (bool, error) {
+	puzzle, nonce, ok := strings.Cut(response, ":")
+	if !ok || nonce == "" || len(nonce) > maxNonceLength {
+		return false, nil
+	}
+	parts := strings.Split(puzzle, ".")
+	if len(parts) != 4 {
+		return false, nil
+	}
+	body := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(flow, key, body))) {
+		return false, nil
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || clock.Now().Unix() >= expires {
+		return false, nil
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+		return false, nil
+	}
+
+	// Spend the puzzle
+	_, err = database.DB.Collection(solvedPuzzles).InsertOne(r.Context(), bson.M{
+		"_id":        utils.HashToken(puzzle),
+		"expires_at": time.Unix(expires, 0),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// leadingZeroBits counts the zero bits a digest starts with
+func leadingZeroBits(digest [sha256.Size]byte) int {
+	n := 0
+	for _, b := range digest {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
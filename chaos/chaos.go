@@ -0,0 +1,137 @@
+// Package chaos injects latency, errors and dropped connections into matching
+// requests so client retry logic can be exercised. It is meant for development
+// and staging only and does nothing unless CHAOS_ENABLED is set.
+package chaos
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule describes the faults injected into requests whose path starts with Path.
+// Probabilities are between 0 and 1 and evaluated independently per request.
+type Rule struct {
+	Path               string  `json:"path" example:"/admin/users"`
+	Method             string  `json:"method,omitempty" example:"GET"`
+	LatencyMs          int     `json:"latency_ms,omitempty" example:"500"`
+	LatencyProbability float64 `json:"latency_probability,omitempty" example:"0.5"`
+	ErrorStatus        int     `json:"error_status,omitempty" example:"503"`
+	ErrorProbability   float64 `json:"error_probability,omitempty" example:"0.1"`
+	DropProbability    float64 `json:"drop_probability,omitempty" example:"0.05"`
+}
+
+var (
+	mu    sync.RWMutex
+	rules []Rule
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rndMu sync.Mutex
+)
+
+// Rules returns the active rules
+func Rules() []Rule {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Rule{}, rules...)
+}
+
+// SetRules replaces the active rules; an empty list turns injection off
+func SetRules(newRules []Rule) {
+	mu.Lock()
+	rules = newRules
+	mu.Unlock()
+	log.Printf("chaos: %d fault injection rules active", len(newRules))
+}
+
+// Middleware applies the first rule matching the request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rule.LatencyMs > 0 && roll(rule.LatencyProbability) {
+			time.Sleep(time.Duration(rule.LatencyMs) * time.Millisecond)
+		}
+
+		if roll(rule.DropProbability) {
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+
+		if rule.ErrorStatus >= 400 && roll(rule.ErrorProbability) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Chaos-Injected", "true")
+			http.Error(w, `{"error": "Injected fault"}`, rule.ErrorStatus)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// match returns the first rule for the request's method and path
+func match(r *http.Request) (Rule, bool) {
+	// Never break the endpoint used to turn injection off
+	if strings.HasPrefix(r.URL.Path, "/debug/") {
+		return Rule{}, false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, rule.Path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// roll returns true with probability p
+func roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return rnd.Float64() < p
+}
+
+// Handler exposes the rules: GET lists them, PUT replaces them and DELETE clears them
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPut:
+		var newRules []Rule
+		if err := json.NewDecoder(r.Body).Decode(&newRules); err != nil {
+			http.Error(w, `{"error": "Invalid rules"}`, http.StatusBadRequest)
+			return
+		}
+		for _, rule := range newRules {
+			if rule.Path == "" || !strings.HasPrefix(rule.Path, "/") {
+				http.Error(w, `{"error": "Every rule needs a path starting with /"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		SetRules(newRules)
+	case http.MethodDelete:
+		SetRules(nil)
+	}
+
+	json.NewEncoder(w).Encode(Rules())
+}
@@ -0,0 +1,122 @@
+// Command adminctl runs administrative procedures from a server host, with
+// the deployment's configuration and database access.
+//
+// break-glass issues a one-time emergency admin token, for when every admin
+// account is locked out. The token is printed to stdout and nowhere else;
+// redeem it at POST /admin/break-glass before it expires. Requires
+// BREAK_GLASS_ENABLED.
+//
+// Usage:
+//
+//	go run ./cmd/adminctl break-glass -reason "all admins locked out" [-ttl 15m]
+//	go run ./cmd/adminctl break-glass -list
+//	go run ./cmd/adminctl break-glass -revoke <grant id>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/breakglass"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "break-glass":
+		breakGlass(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: adminctl break-glass -reason <reason> [-ttl 15m] | -list | -revoke <grant id>")
+	os.Exit(2)
+}
+
+func breakGlass(args []string) {
+	cfg := config.Load()
+
+	fs := flag.NewFlagSet("break-glass", flag.ExitOnError)
+	reason := fs.String("reason", "", "why emergency access is needed, recorded in the audit log")
+	ttl := fs.Duration("ttl", cfg.BreakGlassTTL, "how long the token and its session last, at most 1h")
+	list := fs.Bool("list", false, "list unexpired grants")
+	revoke := fs.String("revoke", "", "grant ID to revoke, ending its session")
+	fs.Parse(args)
+
+	if !cfg.BreakGlassEnabled {
+		log.Fatal("Break-glass access is disabled, set BREAK_GLASS_ENABLED=true")
+	}
+	database.Connect(cfg.MongoURI)
+	breakglass.Init(cfg)
+	ctx := context.Background()
+	operator := operator()
+
+	switch {
+	case *list:
+		grants, err := breakglass.List(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list grants: %v", err)
+		}
+		for _, g := range grants {
+			state := "unused"
+			switch {
+			case g.RevokedAt != nil:
+				state = "revoked"
+			case g.RedeemedAt != nil:
+				state = "redeemed from " + g.RedeemedIP
+			}
+			fmt.Printf("%s\t%s\texpires %s\tby %s\t%s\n", g.ID.Hex(), state, g.ExpiresAt.Format(time.RFC3339), g.IssuedBy, g.Reason)
+		}
+
+	case *revoke != "":
+		id, err := primitive.ObjectIDFromHex(*revoke)
+		if err != nil {
+			log.Fatal("Invalid grant ID")
+		}
+		if err := breakglass.Revoke(ctx, id, operator); err != nil {
+			log.Fatalf("Failed to revoke grant %s: %v", *revoke, err)
+		}
+		log.Printf("Revoked grant %s", *revoke)
+
+	default:
+		if *reason == "" {
+			fs.Usage()
+			log.Fatal("-reason is required")
+		}
+		if *ttl > breakglass.MaxTTL {
+			log.Fatalf("-ttl may be at most %s", breakglass.MaxTTL)
+		}
+		grant, token, err := breakglass.Create(ctx, *ttl, *reason, operator)
+		if err != nil {
+			log.Fatalf("Failed to issue break-glass token: %v", err)
+		}
+		log.Printf("Issued grant %s for %s, expiring at %s. Redeem it with POST /admin/break-glass; revoke it with -revoke %s",
+			grant.ID.Hex(), operator, grant.ExpiresAt.Format(time.RFC3339), grant.ID.Hex())
+		// Only the token goes to stdout, so it can be piped without the log lines
+		fmt.Println(token)
+	}
+}
+
+// operator names the OS user and host running the command
+func operator() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
@@ -0,0 +1,68 @@
+// Command auditverify walks the hash chain of the audit log and reports
+// entries that were edited, removed, or do not link to the entry before, as
+// POST /admin/audit/verify does, without a size limit. Run it on a schedule,
+// from a machine with read-only database access, and keep the head it prints
+// somewhere the database's writers cannot reach: a later run whose chain no
+// longer passes through that head shows the chain was rewritten.
+//
+// Usage:
+//
+//	go run ./cmd/auditverify
+//	go run ./cmd/auditverify -from 1042 -json
+//
+// It exits 1 when the chain has problems.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+func main() {
+	from := flag.Int64("from", 0, "sequence number to start from (0 is the start of the chain)")
+	limit := flag.Int64("limit", 0, "entries to check (0 is no limit)")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	cfg := config.Load()
+	database.Connect(cfg.MongoURI)
+
+	report, err := audit.Verify(context.Background(), *from, *limit)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, p := range report.Problems {
+			fmt.Printf("%-22s seq %d %s: %s\n", p.Kind, p.Seq, p.ID, p.Detail)
+		}
+		if report.MoreProblems {
+			fmt.Printf("... more problems not listed\n")
+		}
+		fmt.Printf("\nchecked %d entries (%d to %d), %d redacted, %d written before chaining\n",
+			report.Checked, report.From, report.To, report.Redacted, report.Unchained)
+		fmt.Printf("anchor %d %s\nhead   %d %s\n", report.Anchor.Seq, report.Anchor.Hash, report.Head.Seq, report.Head.Hash)
+		if !report.Complete {
+			fmt.Printf("stopped at -limit before the head\n")
+		}
+	}
+
+	if !report.OK {
+		if !*asJSON {
+			fmt.Printf("%d problems found\n", len(report.Problems))
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,135 @@
+// Command emailbackfill rewrites every user's email and email_hash into the
+// normalized form used by registration and login: lowercased and trimmed,
+// with the index honoring EMAIL_FOLD_ALIASES. Run it once after upgrading,
+// and again whenever EMAIL_FOLD_ALIASES changes. It is safe to re-run.
+//
+// Usage:
+//
+//	go run ./cmd/emailbackfill -dry-run
+//	go run ./cmd/emailbackfill
+//
+// Accounts whose addresses collapse onto the same index are reported and left
+// untouched, so they can be merged or renamed by hand.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// pending is a user whose stored email or index is not normalized yet
+type pending struct {
+	id         primitive.ObjectID
+	collection *mongo.Collection
+	orgID      string
+	email      string
+	reencrypt  bool
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report changes and conflicts without writing")
+	flag.Parse()
+
+	cfg := config.Load()
+	database.Connect(cfg.MongoURI)
+	database.ConnectRegions(cfg.DataRegion, cfg.MongoRegionURIs)
+	ctx := context.Background()
+
+	owners := make(map[string][]primitive.ObjectID)
+	targets := make(map[primitive.ObjectID]string)
+	var changes []pending
+	var scanned, unreadable int
+
+	opts := options.Find().SetProjection(bson.M{"email": 1, "email_hash": 1, "org_id": 1})
+	for region, db := range database.Regions {
+		collection := db.Collection("users")
+		cursor, err := collection.Find(ctx, bson.M{"forgotten_at": bson.M{"$exists": false}}, opts)
+		if err != nil {
+			log.Fatalf("region %s: %v", region, err)
+		}
+
+		for cursor.Next(ctx) {
+			var user models.User
+			if err := cursor.Decode(&user); err != nil {
+				log.Fatalf("region %s: %v", region, err)
+			}
+			scanned++
+
+			plain, err := keys.Decrypt(ctx, cfg, user.Email)
+			if err != nil || plain == "" {
+				log.Printf("skipping user %s: email cannot be decrypted", user.ID.Hex())
+				unreadable++
+				continue
+			}
+
+			normalized := utils.NormalizeEmail(plain)
+			index := utils.EmailIndex(normalized, cfg.EmailFoldAliases)
+			owners[index] = append(owners[index], user.ID)
+			targets[user.ID] = index
+
+			if index != user.EmailHash || normalized != plain {
+				changes = append(changes, pending{
+					id:         user.ID,
+					collection: collection,
+					orgID:      user.OrgID,
+					email:      normalized,
+					reencrypt:  normalized != plain,
+				})
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Fatalf("region %s: %v", region, err)
+		}
+		cursor.Close(ctx)
+	}
+
+	conflicts := 0
+	for _, ids := range owners {
+		if len(ids) > 1 {
+			conflicts++
+			log.Printf("conflict: users %v share one normalized address; left unchanged", ids)
+		}
+	}
+
+	updated := 0
+	for _, change := range changes {
+		index := targets[change.id]
+		if len(owners[index]) > 1 {
+			continue
+		}
+		if *dryRun {
+			updated++
+			continue
+		}
+
+		set := bson.M{"email_hash": index}
+		if change.reencrypt {
+			encrypted, err := keys.Encrypt(ctx, cfg, change.orgID, change.email)
+			if err != nil {
+				log.Fatalf("user %s: %v", change.id.Hex(), err)
+			}
+			set["email"] = encrypted
+		}
+		if _, err := change.collection.UpdateOne(ctx, bson.M{"_id": change.id}, bson.M{"$set": set}); err != nil {
+			log.Fatalf("user %s: %v", change.id.Hex(), err)
+		}
+		updated++
+	}
+
+	verb := "Updated"
+	if *dryRun {
+		verb = "Would update"
+	}
+	log.Printf("%s %d of %d users; %d conflicts, %d unreadable", verb, updated, scanned, conflicts, unreadable)
+}
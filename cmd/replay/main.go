@@ -0,0 +1,129 @@
+// Command replay re-sends requests recorded with RECORD_REQUESTS_DIR against a
+// local instance and reports where the status code differs from the recording.
+//
+// Usage:
+//
+//	go run ./cmd/replay -file recordings/requests-2024-05-01.jsonl -token $TOKEN
+//
+// Credentials are redacted when recording, so pass -token (and -api-key) for
+// a local account to authenticate replayed requests.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang-backend/recorder"
+)
+
+func main() {
+	file := flag.String("file", "", "recording file (JSON lines of HAR entries)")
+	target := flag.String("target", "http://localhost:8080", "base URL to replay against")
+	token := flag.String("token", "", "bearer token replacing redacted Authorization headers")
+	apiKey := flag.String("api-key", "", "API key replacing redacted X-API-Key headers")
+	filter := flag.String("path", "", "only replay requests whose URL starts with this prefix")
+	method := flag.String("method", "", "only replay requests with this method")
+	delay := flag.Duration("delay", 0, "pause between requests")
+	verbose := flag.Bool("v", false, "print response bodies of mismatches")
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	var sent, mismatched int
+	for scanner.Scan() {
+		var entry recorder.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("skipping malformed entry: %v", err)
+			continue
+		}
+		if *filter != "" && !strings.HasPrefix(entry.Request.URL, *filter) {
+			continue
+		}
+		if *method != "" && !strings.EqualFold(entry.Request.Method, *method) {
+			continue
+		}
+
+		status, body, err := send(client, *target, *token, *apiKey, entry.Request)
+		sent++
+		switch {
+		case err != nil:
+			mismatched++
+			fmt.Printf("ERR  %s %s: %v\n", entry.Request.Method, entry.Request.URL, err)
+		case status != entry.Response.Status:
+			mismatched++
+			fmt.Printf("DIFF %s %s: recorded %d, got %d\n", entry.Request.Method, entry.Request.URL, entry.Response.Status, status)
+			if *verbose {
+				fmt.Printf("     recorded: %s\n     got:      %s\n", entry.Response.Content.Text, body)
+			}
+		default:
+			fmt.Printf("OK   %s %s: %d\n", entry.Request.Method, entry.Request.URL, status)
+		}
+
+		if *delay > 0 {
+			time.Sleep(*delay)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\n%d requests replayed, %d differed from the recording\n", sent, mismatched)
+	if mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// send replays one request and returns the status and body
+func send(client *http.Client, target, token, apiKey string, req recorder.Request) (int, string, error) {
+	var body io.Reader
+	if req.PostData != nil && !strings.HasPrefix(req.PostData.Text, "[OMITTED") {
+		body = strings.NewReader(req.PostData.Text)
+	}
+
+	httpReq, err := http.NewRequest(req.Method, strings.TrimRight(target, "/")+req.URL, body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, h := range req.Headers {
+		switch {
+		case h.Value != recorder.Redacted:
+			httpReq.Header.Add(h.Name, h.Value)
+		case strings.EqualFold(h.Name, "Authorization") && token != "":
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		case strings.EqualFold(h.Name, "X-Api-Key") && apiKey != "":
+			httpReq.Header.Set("X-API-Key", apiKey)
+		}
+	}
+	httpReq.Header.Del("Content-Length")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, string(data), nil
+}
@@ -0,0 +1,41 @@
+// Command tenantmove migrates an organization's users to the MongoDB cluster
+// of another data residency region. It is safe to re-run after a failure.
+//
+// Usage:
+//
+//	go run ./cmd/tenantmove -org <organization id> -region eu
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/repository"
+)
+
+func main() {
+	orgFlag := flag.String("org", "", "organization ID to move")
+	regionFlag := flag.String("region", "", "target data residency region")
+	flag.Parse()
+
+	orgID, err := primitive.ObjectIDFromHex(*orgFlag)
+	if err != nil || *regionFlag == "" {
+		flag.Usage()
+		log.Fatal("Both -org and -region are required")
+	}
+
+	cfg := config.Load()
+	database.Connect(cfg.MongoURI)
+	database.ConnectRegions(cfg.DataRegion, cfg.MongoRegionURIs)
+
+	moved, err := repository.MoveOrg(context.Background(), orgID, *regionFlag)
+	if err != nil {
+		log.Fatalf("Move stopped after %d users: %v", moved, err)
+	}
+
+	log.Printf("Moved %d users of organization %s to region %s", moved, orgID.Hex(), *regionFlag)
+}
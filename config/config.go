@@ -3,29 +3,887 @@ package config
 import (
 	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	MongoURI     string
-	JWTSecret    string
+	MongoURI      string
+	JWTSecret     string
 	EncryptionKey string
+
+	// JWTSecrets verifies session tokens, newest first; JWTSecret is the first
+	// one and signs. Set JWT_SECRETS="new,old" to rotate without ending the
+	// sessions signed with the old secret. Secrets rotated through the admin
+	// API are kept for JWTSecretRetention after a newer one replaced them.
+	JWTSecrets         []string
+	JWTSecretRetention time.Duration
+
+	// SMTP settings for outgoing email; when SMTPHost is empty emails are logged
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// AppURL is the public base URL used in links sent to users
+	AppURL string
+
+	// UndoWindow is how long an undo token for an admin operation stays valid
+	UndoWindow time.Duration
+
+	// ApprovalsEnabled requires a second admin to approve role elevation and deletions
+	ApprovalsEnabled bool
+	ApprovalTTL      time.Duration
+
+	// WebhookURLs receive notifier events, signed with WebhookSecret when set
+	WebhookURLs   []string
+	WebhookSecret string
+
+	// Anomaly detection thresholds for admin activity
+	AnomalyEnabled             bool
+	AnomalyInterval            time.Duration
+	AnomalyMassDeleteThreshold int
+	AnomalyMassDeleteWindow    time.Duration
+	AnomalyBusinessHoursStart  int
+	AnomalyBusinessHoursEnd    int
+
+	// SecurityEventTTL is how long security events are kept; SIEMSyslogAddr
+	// (udp://host:514 or tcp://host:601) forwards them as CEF over syslog
+	SecurityEventTTL time.Duration
+	SIEMSyslogAddr   string
+
+	// TermsVersion must be accepted at registration; MinAgeByRegion overrides
+	// MinAge for region codes (e.g. "EU=16,US=13")
+	TermsVersion   string
+	MinAge         int
+	MinAgeByRegion map[string]int
+
+	// DataRegion names the cluster behind MongoURI; MongoRegionURIs adds
+	// further residency regions (e.g. "eu=mongodb://...,us=mongodb://...")
+	DataRegion      string
+	MongoRegionURIs map[string]string
+
+	// Response cache for read endpoints; CacheTTLByTag overrides CacheTTL per
+	// cache tag (e.g. "users=10s,orgs=5m"). CacheBackend is memory, ristretto
+	// (bounded by CacheMaxBytes), redis, memcached, or tiered: a ristretto L1
+	// in front of redis, with L1 entries kept at most CacheL1TTL.
+	CacheEnabled  bool
+	CacheBackend  string
+	CacheTTL      time.Duration
+	CacheTTLByTag map[string]time.Duration
+	RedisAddr     string
+	MemcachedAddr string
+	CacheMaxBytes int64
+	CacheL1TTL    time.Duration
+
+	// MaxConcurrentRequests caps in-flight requests for the whole service and
+	// ConcurrencyLimits caps route groups (e.g. "IMPORT=2,EXPORT=2"); excess
+	// requests wait up to ConcurrencyQueueTimeout before a 503
+	MaxConcurrentRequests   int
+	ConcurrencyLimits       map[string]int
+	ConcurrencyQueueTimeout time.Duration
+
+	// BcryptWorkers bounds concurrent password hashing; requests wait up to
+	// BcryptQueueTimeout for a worker before getting a 503
+	BcryptWorkers      int
+	BcryptQueueTimeout time.Duration
+
+	// ChangeStreamsEnabled watches user documents for changes made by any
+	// instance; it requires MongoDB to run as a replica set
+	ChangeStreamsEnabled bool
+
+	// MockMode serves the core API from memory with seeded users and optional
+	// injected latency and failures, for frontend development without MongoDB
+	MockMode          bool
+	MockSeedUsers     int
+	MockSeed          int64
+	MockLatency       time.Duration
+	MockLatencyJitter time.Duration
+	MockErrorRate     float64
+
+	// ChaosEnabled mounts the fault injection middleware and /debug/chaos;
+	// never enable it in production
+	ChaosEnabled bool
+
+	// RecordRequestsDir turns on recording of sanitized requests and responses
+	// for replay; bodies larger than RecordMaxBody bytes are omitted
+	RecordRequestsDir string
+	RecordMaxBody     int
+
+	// RoleCheckTTL is how long the auth middleware caches a user's current
+	// role version before checking a token against the database again
+	RoleCheckTTL time.Duration
+
+	// EmailFoldAliases treats Gmail addresses that differ only in dots or a
+	// +tag as the same account
+	EmailFoldAliases bool
+
+	// EmailCheckMX rejects addresses whose domain cannot receive mail, caching
+	// lookups for EmailCheckCacheTTL; EmailSMTPCallout additionally asks the
+	// domain's mail server whether the mailbox exists
+	EmailCheckMX       bool
+	EmailSMTPCallout   bool
+	EmailCheckTimeout  time.Duration
+	EmailCheckCacheTTL time.Duration
+
+	// Email templates fall back to MailDefaultLocale; the brand settings style
+	// emails of users outside an organization with custom branding
+	MailDefaultLocale   string
+	MailBrandName       string
+	MailBrandColor      string
+	MailBrandLogoURL    string
+	WelcomeEmailEnabled bool
+
+	// RateLimitPlans sets each plan's requests per minute and monthly request
+	// quota (e.g. "free=600/1000000,pro=3000/10000000", 0 is unlimited).
+	// Organizations without a plan get DefaultPlan; usage counted by each
+	// instance is written to the database every UsageFlushInterval.
+	RateLimitEnabled   bool
+	RateLimitPlans     string
+	DefaultPlan        string
+	UsageFlushInterval time.Duration
+
+	// Service-to-service calls identify the caller with an X-Service-Token
+	// signed with ServiceTokenSecret; a ServiceTrafficSampleRate share of them
+	// is recorded for ServiceTrafficTTL. ServiceName identifies this service.
+	ServiceName              string
+	ServiceTokenSecret       string
+	ServiceTrafficSampleRate float64
+	ServiceTrafficTTL        time.Duration
+
+	// RequestTraceTTL is how long requests that logged or failed are kept for
+	// GET /admin/requests/{request_id}
+	RequestTraceTTL time.Duration
+
+	// Component health is checked every HealthCheckInterval and the samples are
+	// kept for HealthSampleTTL to compute uptime. The public /status endpoints
+	// answer StatusRateLimit requests per minute per IP and sign their bodies
+	// with StatusSigningSecret when it is set.
+	HealthCheckInterval time.Duration
+	HealthSampleTTL     time.Duration
+	StatusRateLimit     int
+	StatusSigningSecret string
+
+	// Synthetic self-tests run every SyntheticInterval and alert after
+	// SyntheticFailureThreshold consecutive failures of a check. GET /metrics
+	// requires MetricsToken as a bearer token when it is set.
+	SyntheticEnabled          bool
+	SyntheticInterval         time.Duration
+	SyntheticFailureThreshold int
+	MetricsToken              string
+
+	// JWTMinimalClaims issues session tokens carrying only the user ID (sub)
+	// and role version (rv); the role, organization and profile are loaded
+	// on each request instead of exposing them in the token
+	JWTMinimalClaims bool
+
+	// Every session token carries a jti that is stored until it expires.
+	// JWTStrictJTI rejects tokens with unknown IDs (such as those issued before
+	// a global revocation) and tokens reused more than JWTReplayGrace after a
+	// refreshed token replaced them; otherwise both are only reported.
+	JWTStrictJTI   bool
+	JWTReplayGrace time.Duration
+
+	// Per route group overrides (public, authenticated, admin, internal) of the
+	// browser origins allowed by CORS (space-separated, "*" for any), and of
+	// whether organization rate limits apply and writes are audited
+	CORSOrigins    map[string]string
+	RouteRateLimit map[string]bool
+	RouteAudit     map[string]bool
+
+	// NotificationInboxTTL is how long in-app notifications are kept
+	NotificationInboxTTL time.Duration
+
+	// Client analytics events posted to /events are validated, rate limited
+	// per user or IP (batches per minute) and buffered, then written every
+	// AnalyticsFlushInterval or AnalyticsFlushSize events to AnalyticsSink:
+	// "mongo", "file", "kafka" (through a Confluent REST Proxy) or "s3".
+	// The s3 sink signs requests with the AWS_* credentials.
+	AnalyticsEnabled       bool
+	AnalyticsSink          string
+	AnalyticsMaxBatch      int
+	AnalyticsMaxBodyBytes  int64
+	AnalyticsRateLimit     int
+	AnalyticsBufferSize    int
+	AnalyticsFlushSize     int
+	AnalyticsFlushInterval time.Duration
+	AnalyticsEventTTL      time.Duration
+	AnalyticsFileDir       string
+	AnalyticsKafkaRESTURL  string
+	AnalyticsKafkaTopic    string
+	AnalyticsS3Bucket      string
+	AnalyticsS3Region      string
+	AnalyticsS3Endpoint    string
+	AnalyticsS3Prefix      string
+	AWSAccessKeyID         string
+	AWSSecretAccessKey     string
+	AWSSessionToken        string
+
+	// Signup, retention and active user reports are recomputed once they are
+	// older than ReportsRefreshInterval
+	ReportsRefreshInterval time.Duration
+
+	// Uploads are checked by UploadScanners ("mime" verifies magic bytes,
+	// "clamav" asks the clamd daemon at ClamAVAddr). When a scanner fails the
+	// upload is refused unless UploadScanFailOpen is set. Flagged uploads are
+	// kept for review for UploadQuarantineTTL.
+	UploadScanners      []string
+	ClamAVAddr          string
+	ClamAVTimeout       time.Duration
+	UploadScanFailOpen  bool
+	UploadQuarantineTTL time.Duration
+
+	// Clients upload files of up to UploadMaxBytes straight to UploadBucket
+	// (AWS S3 unless UploadStorageEndpoint names an S3 compatible store such
+	// as GCS) with presigned URLs valid for UploadURLTTL, signed with the
+	// AWS_* credentials. Direct uploads are off without a bucket.
+	UploadBucket          string
+	UploadStorageEndpoint string
+	UploadStorageRegion   string
+	UploadKeyPrefix       string
+	UploadURLTTL          time.Duration
+	UploadMaxBytes        int64
+
+	// Every FileGCInterval, objects under the upload prefix older than
+	// FileOrphanGrace that no file or pending upload refers to are deleted
+	FileGCInterval  time.Duration
+	FileOrphanGrace time.Duration
+
+	// With BreakGlassEnabled, adminctl on a server host can issue one-time
+	// emergency admin tokens valid for BreakGlassTTL (at most an hour)
+	BreakGlassEnabled bool
+	BreakGlassTTL     time.Duration
+
+	// Data migrations, such as indexing emails the microservices stored in
+	// plain text, run at startup unless MigrateOnStartup is false
+	MigrateOnStartup bool
+
+	// ChallengeSteps maps challenge kinds (captcha, email_otp, pow), or
+	// flow:kind for one flow, to the number of failed logins or probing
+	// registrations within ChallengeWindow after which they are required.
+	// Email codes last ChallengeOTPTTL and are resent at most once per
+	// ChallengeOTPCooldown. CAPTCHAs are verified with CaptchaSecret at
+	// CaptchaVerifyURL and are skipped without a secret. Proof-of-work puzzles
+	// start at ChallengePoWDifficulty leading zero bits, one more per failure
+	// past the threshold and two more while an instance issues over
+	// ChallengePoWSurge per minute, up to ChallengePoWMaxDifficulty; they
+	// must be solved within ChallengePoWTTL.
+	ChallengeSteps       map[string]string
+	ChallengeWindow      time.Duration
+	ChallengeOTPTTL      time.Duration
+	ChallengeOTPCooldown time.Duration
+	CaptchaVerifyURL     string
+	CaptchaSecret        string
+	CaptchaSiteKey       string
+
+	ChallengePoWDifficulty    int
+	ChallengePoWMaxDifficulty int
+	ChallengePoWSurge         int
+	ChallengePoWTTL           time.Duration
+
+	// With MeshIdentity ("jwt" or "xfcc"), requests without credentials are
+	// authenticated by the identity header of a service mesh sidecar, from
+	// peers inside the TrustedProxies CIDRs only. "jwt" reads the verified
+	// token payload from MeshJWTHeader, issued by one of MeshJWTIssuers if
+	// set; "xfcc" maps client certificate SPIFFE IDs to user IDs with
+	// MeshServiceAccounts.
+	MeshIdentity        string
+	TrustedProxies      []string
+	MeshJWTHeader       string
+	MeshJWTIssuers      []string
+	MeshServiceAccounts map[string]string
+
+	// Sign-in issues a session token valid for AccessTokenTTL and a refresh
+	// token valid for RefreshTokenTTL, exchanged at /token/refresh for a new
+	// pair. Sign-ins with remember_me use RememberMeAccessTokenTTL and
+	// RememberMeRefreshTokenTTL instead, for every token of the session; a
+	// zero RememberMeRefreshTokenTTL ignores remember_me
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	RememberMeAccessTokenTTL  time.Duration
+	RememberMeRefreshTokenTTL time.Duration
+
+	// Signed API key requests must be timestamped within SignatureMaxSkew of
+	// the server clock
+	SignatureMaxSkew time.Duration
+
+	// Password reset tokens are valid for PasswordResetTTL and sent at most
+	// once per PasswordResetCooldown per account. The emailed link opens
+	// PasswordResetURL with the token in the query, APP_URL/reset-password
+	// when unset.
+	PasswordResetTTL      time.Duration
+	PasswordResetCooldown time.Duration
+	PasswordResetURL      string
+
+	// New users confirm their address with a link valid for
+	// EmailVerificationTTL that opens EmailVerificationURL, APP_URL/verify-email
+	// when unset. With EmailVerificationRequired, unverified users cannot
+	// sign in.
+	EmailVerificationRequired bool
+	EmailVerificationTTL      time.Duration
+	EmailVerificationURL      string
+
+	// Google sign-in is enabled with a client ID; GoogleRedirectURL must be
+	// registered with Google and defaults to APP_URL/auth/google/callback
+	GoogleClientID     string
+	GoogleClientSecret string
+	GoogleRedirectURL  string
+
+	// GitHub sign-in likewise; GitHubRedirectURL defaults to
+	// APP_URL/auth/github/callback
+	GitHubClientID     string
+	GitHubClientSecret string
+	GitHubRedirectURL  string
+
+	// MongoStandbyURI is the cluster an admin can cut the default region
+	// over to without a restart (blue/green). A cutover pauses new requests
+	// for at most MongoCutoverDrainTimeout while those in flight finish;
+	// instances check for cutovers made elsewhere every MongoCutoverPoll.
+	MongoStandbyURI          string
+	MongoCutoverDrainTimeout time.Duration
+	MongoCutoverPoll         time.Duration
+
+	// DemoMode serves the mock API from the fixtures in DemoFixtures (the
+	// built-in dataset when empty) for public demos. Nothing is persisted;
+	// the data returns to the fixtures every DemoResetInterval and on
+	// POST /demo/reset.
+	DemoMode          bool
+	DemoFixtures      string
+	DemoResetInterval time.Duration
+
+	// Passwordless sign-in links (POST /login/magic) are valid for
+	// MagicLinkTTL, at most one per MagicLinkCooldown per account, and point
+	// to MagicLinkURL (APP_URL/magic-login when empty) with the token
+	MagicLinkTTL      time.Duration
+	MagicLinkCooldown time.Duration
+	MagicLinkURL      string
+
+	// SudoTTL is how long POST /auth/sudo elevates a session for sensitive
+	// admin operations; zero lets them run without re-authentication
+	SudoTTL time.Duration
+
+	// RoleGrantInterval is how often roles granted with an expiry (PUT
+	// /admin/users/role with expires_at) are checked and reverted
+	RoleGrantInterval time.Duration
+
+	// AuthRatePerMinute limits /login and /register attempts per client IP
+	// with a token bucket per endpoint of AuthRateBurst attempts (0 disables).
+	// AuthRateStore is memory (per instance) or redis (shared, at RedisAddr).
+	AuthRatePerMinute int
+	AuthRateBurst     int
+	AuthRateStore     string
+
+	// OnboardingSteps are the steps of the GET /user/onboarding checklist in
+	// order, each optionally weighted in the completion percentage with
+	// ":weight", e.g. "email_verified:2,profile,api_key"
+	OnboardingSteps []string
+
+	// EventSourcingEnabled records every change to user documents as an
+	// append-only event stream per user, snapshotted every
+	// EventSnapshotInterval events, from which the users collections can be
+	// rebuilt. Like ChangeStreamsEnabled it requires a replica set.
+	EventSourcingEnabled  bool
+	EventSnapshotInterval int
+
+	// New passwords must be PasswordMinLength characters to PasswordMaxLength
+	// bytes long, use every class in PasswordRequiredClasses (lower, upper,
+	// digit, symbol) and, with PasswordDenyCommon, not be a common password
+	// or one listed in PasswordDenylistFile
+	PasswordMinLength       int
+	PasswordMaxLength       int
+	PasswordRequiredClasses []string
+	PasswordDenyCommon      bool
+	PasswordDenylistFile    string
+
+	// SignupGateRules decide in order whether a registration is activated or
+	// waitlisted, e.g. "activate:invited,waitlist:country!=US|CA" (see
+	// signupgate). The country comes from GeoIPCountryHeader when set by a
+	// CDN, or else the client IP's range in the GeoIPDatabase CSV
+	// (start_ip,end_ip,country).
+	SignupGateRules    []string
+	GeoIPCountryHeader string
+	GeoIPDatabase      string
+
+	// CaptchaFlows (register, login) require a valid captcha_token on every
+	// attempt, apart from the progressive challenges. CaptchaProvider
+	// (hcaptcha, recaptcha or turnstile) picks the default CaptchaVerifyURL;
+	// reCAPTCHA v3 tokens scoring below CaptchaMinScore are rejected.
+	CaptchaFlows    []string
+	CaptchaProvider string
+	CaptchaMinScore float64
+
+	// Background jobs are run by JobWorkers workers per instance, polling
+	// every JobPollInterval. A failing job is tried JobMaxAttempts times;
+	// finished jobs are kept for JobRetention.
+	JobWorkers      int
+	JobPollInterval time.Duration
+	JobMaxAttempts  int
+	JobRetention    time.Duration
+
+	// Verification emails can be resent once per VerifyEmailResendCooldown
+	// and VerifyEmailResendDailyCap times a day per address (0 is no cap)
+	VerifyEmailResendCooldown time.Duration
+	VerifyEmailResendDailyCap int
+
+	// RegistrationEnumeration keeps registered addresses from being probed.
+	// "availability" serves GET /availability, where every lookup solves an
+	// AvailabilityChallenge (pow or captcha) and each client IP gets one per
+	// AvailabilityCooldown and AvailabilityDailyCap a day. "silent" makes
+	// /register answer taken addresses as if it registered them and email
+	// their owner instead. Empty keeps the 409 response.
+	RegistrationEnumeration string
+	AvailabilityChallenge   string
+	AvailabilityCooldown    time.Duration
+	AvailabilityDailyCap    int
+
+	// EnumerationHardening makes registration, sign-in and password resets
+	// answer alike, and as slowly, whether or not an address has an account.
+	// It implies the "silent" RegistrationEnumeration.
+	EnumerationHardening bool
+
+	// Accounts deleted by their users are suspended at once and erased
+	// after AccountDeletionRetention
+	AccountDeletionRetention time.Duration
+
+	// LoginHistoryRetention is how long sign-in attempts are kept in the
+	// login history
+	LoginHistoryRetention time.Duration
+
+	// Route alerts compare each route's share of server errors, client
+	// errors and validation failures over RouteAlertInterval with these
+	// rates (0 disables one), once it served RouteAlertMinRequests requests
+	RouteAlertsEnabled        bool
+	RouteAlertInterval        time.Duration
+	RouteAlertMinRequests     int
+	RouteAlertServerErrorRate float64
+	RouteAlertClientErrorRate float64
+	RouteAlertValidationRate  float64
+
+	// Sign-in and phone verification codes are texted through SMSProvider
+	// (log, twilio or webhook) from SMSFrom. Codes last OTPTTL, allow
+	// OTPMaxAttempts guesses and are sent at most once per OTPCooldown per
+	// number.
+	SMSProvider      string
+	SMSFrom          string
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	SMSWebhookURL    string
+	OTPTTL           time.Duration
+	OTPMaxAttempts   int
+	OTPCooldown      time.Duration
+
+	// Audit logs and security events are archived every LogArchiveInterval
+	// to LogArchiveBucket (addressed like UploadBucket, with the AWS_*
+	// credentials), sealed with LogArchiveKey or else EncryptionKey. Archived
+	// records older than LogArchiveHotRetention leave the database (0 keeps
+	// them), archives move to LogArchiveColdStorageClass after
+	// LogArchiveWarmRetention and are deleted after LogArchiveRetention.
+	// Restores span at most LogArchiveMaxRestoreDays and are kept for
+	// LogArchiveRestoreTTL.
+	LogArchiveBucket           string
+	LogArchiveEndpoint         string
+	LogArchiveRegion           string
+	LogArchivePrefix           string
+	LogArchiveKey              string
+	LogArchiveInterval         time.Duration
+	LogArchiveHotRetention     time.Duration
+	LogArchiveWarmRetention    time.Duration
+	LogArchiveRetention        time.Duration
+	LogArchiveColdStorageClass string
+	LogArchiveMaxRestoreDays   int
+	LogArchiveRestoreTTL       time.Duration
+
+	// Passkeys are created for WebAuthnRPID (the host of AppURL when empty),
+	// shown as WebAuthnRPName, and only accepted from WebAuthnOrigins (the
+	// origin of AppURL when empty). Ceremonies must finish within
+	// WebAuthnTimeout. WebAuthnUserVerification is required, preferred or
+	// discouraged.
+	WebAuthnRPID             string
+	WebAuthnRPName           string
+	WebAuthnOrigins          []string
+	WebAuthnTimeout          time.Duration
+	WebAuthnUserVerification string
+
+	// With InviteOnly registering, also through social sign-in, needs an
+	// invitation from /admin/invitations. Invitations last InvitationTTL
+	// unless created with another expiry, and link to InvitationURL
+	// (APP_URL/register when empty) with the code in the query.
+	InviteOnly    bool
+	InvitationTTL time.Duration
+	InvitationURL string
+
+	// DeprecatedRoutes maps routes, as "METHOD /path/{template}", to their
+	// sunset date: until then calls get deprecation headers, and after it
+	// 410 Gone. DeprecationSuccessors names what replaces a route, and
+	// DeprecationURL documents the deprecations. Calls are counted per
+	// caller and kept for DeprecationUsageRetention.
+	DeprecatedRoutes          map[string]string
+	DeprecationSuccessors     map[string]string
+	DeprecationURL            string
+	DeprecationUsageRetention time.Duration
+
+	// PermissionCacheTTL is how long the role to permission mapping is
+	// cached when expanding minimal tokens (see JWT_MINIMAL_CLAIMS)
+	PermissionCacheTTL time.Duration
+}
+
+// loads counts Load calls, so tests can check it stays off request paths
+var loads atomic.Int64
+
+// Loads returns how many times Load has run
+func Loads() int64 {
+	return loads.Load()
 }
 
 // Load loads configuration from .env file and environment variables
 func Load() *Config {
+	loads.Add(1)
+
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	return &Config{
+	cfg := &Config{
 		MongoURI:      getEnv("MONGO_URI", "mongodb://localhost:27017/golang_backend"),
 		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key"),
 		EncryptionKey: getEnv("ENCRYPTION_KEY", "12345678901234567890123456789012"),
+		SMTPHost:      getEnv("SMTP_HOST", ""),
+		SMTPPort:      getEnv("SMTP_PORT", "587"),
+		SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:      getEnv("SMTP_FROM", "no-reply@example.com"),
+		AppURL:        getEnv("APP_URL", "http://localhost:8080"),
+		UndoWindow:    getDuration("UNDO_WINDOW", 5*time.Minute),
+
+		JWTSecrets:         getList("JWT_SECRETS"),
+		JWTSecretRetention: getDuration("JWT_SECRET_RETENTION", 48*time.Hour),
+
+		ApprovalsEnabled: getBool("APPROVALS_ENABLED", false),
+		ApprovalTTL:      getDuration("APPROVAL_TTL", 24*time.Hour),
+
+		WebhookURLs:   getList("WEBHOOK_URLS"),
+		WebhookSecret: getEnv("WEBHOOK_SECRET", ""),
+
+		AnomalyEnabled:             getBool("ANOMALY_ENABLED", true),
+		AnomalyInterval:            getDuration("ANOMALY_INTERVAL", time.Minute),
+		AnomalyMassDeleteThreshold: getInt("ANOMALY_MASS_DELETE_THRESHOLD", 10),
+		AnomalyMassDeleteWindow:    getDuration("ANOMALY_MASS_DELETE_WINDOW", 10*time.Minute),
+		AnomalyBusinessHoursStart:  getInt("ANOMALY_BUSINESS_HOURS_START", 8),
+		AnomalyBusinessHoursEnd:    getInt("ANOMALY_BUSINESS_HOURS_END", 18),
+
+		SecurityEventTTL: getDuration("SECURITY_EVENT_TTL", 90*24*time.Hour),
+		SIEMSyslogAddr:   getEnv("SIEM_SYSLOG_ADDR", ""),
+
+		TermsVersion:   getEnv("TERMS_VERSION", "1"),
+		MinAge:         getInt("MIN_AGE", 13),
+		MinAgeByRegion: getIntMap("MIN_AGE_BY_REGION"),
+
+		DataRegion:      getEnv("DATA_REGION", "default"),
+		MongoRegionURIs: getStringMap("MONGO_REGION_URIS"),
+
+		CacheEnabled:  getBool("CACHE_ENABLED", true),
+		CacheBackend:  getEnv("CACHE_BACKEND", "memory"),
+		CacheTTL:      getDuration("CACHE_TTL", 30*time.Second),
+		CacheTTLByTag: getDurationMap("CACHE_TTL_BY_TAG"),
+		RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
+		MemcachedAddr: getEnv("MEMCACHED_ADDR", "localhost:11211"),
+		CacheMaxBytes: int64(getInt("CACHE_MAX_BYTES", 64<<20)),
+		CacheL1TTL:    getDuration("CACHE_L1_TTL", 5*time.Second),
+
+		MaxConcurrentRequests:   getInt("MAX_CONCURRENT_REQUESTS", 256),
+		ConcurrencyLimits:       getIntMap("CONCURRENCY_LIMITS"),
+		ConcurrencyQueueTimeout: getDuration("CONCURRENCY_QUEUE_TIMEOUT", 250*time.Millisecond),
+
+		BcryptWorkers:      getInt("BCRYPT_WORKERS", runtime.NumCPU()),
+		BcryptQueueTimeout: getDuration("BCRYPT_QUEUE_TIMEOUT", time.Second),
+
+		ChangeStreamsEnabled: getBool("CHANGE_STREAMS_ENABLED", false),
+
+		MockMode:          getBool("MOCK_MODE", false),
+		MockSeedUsers:     getInt("MOCK_SEED_USERS", 25),
+		MockSeed:          int64(getInt("MOCK_SEED", 1)),
+		MockLatency:       getDuration("MOCK_LATENCY", 0),
+		MockLatencyJitter: getDuration("MOCK_LATENCY_JITTER", 0),
+		MockErrorRate:     getFloat("MOCK_ERROR_RATE", 0),
+
+		ChaosEnabled: getBool("CHAOS_ENABLED", false),
+
+		RecordRequestsDir: getEnv("RECORD_REQUESTS_DIR", ""),
+		RecordMaxBody:     getInt("RECORD_MAX_BODY", 64*1024),
+
+		RoleCheckTTL: getDuration("ROLE_CHECK_TTL", 30*time.Second),
+
+		EmailFoldAliases: getBool("EMAIL_FOLD_ALIASES", false),
+
+		EmailCheckMX:       getBool("EMAIL_CHECK_MX", true),
+		EmailSMTPCallout:   getBool("EMAIL_SMTP_CALLOUT", false),
+		EmailCheckTimeout:  getDuration("EMAIL_CHECK_TIMEOUT", 2*time.Second),
+		EmailCheckCacheTTL: getDuration("EMAIL_CHECK_CACHE_TTL", time.Hour),
+
+		MailDefaultLocale:   getEnv("MAIL_DEFAULT_LOCALE", "en"),
+		MailBrandName:       getEnv("MAIL_BRAND_NAME", "Golang Backend"),
+		MailBrandColor:      getEnv("MAIL_BRAND_COLOR", "#1a73e8"),
+		MailBrandLogoURL:    getEnv("MAIL_BRAND_LOGO_URL", ""),
+		WelcomeEmailEnabled: getBool("WELCOME_EMAIL_ENABLED", true),
+
+		RateLimitEnabled:   getBool("RATE_LIMIT_ENABLED", true),
+		RateLimitPlans:     getEnv("RATE_LIMIT_PLANS", "free=600/1000000,pro=3000/10000000,enterprise=0/0"),
+		DefaultPlan:        getEnv("DEFAULT_PLAN", "free"),
+		UsageFlushInterval: getDuration("USAGE_FLUSH_INTERVAL", 10*time.Second),
+
+		ServiceName:              getEnv("SERVICE_NAME", "golang-backend"),
+		ServiceTokenSecret:       getEnv("SERVICE_TOKEN_SECRET", ""),
+		ServiceTrafficSampleRate: getFloat("SERVICE_TRAFFIC_SAMPLE_RATE", 1),
+		ServiceTrafficTTL:        getDuration("SERVICE_TRAFFIC_TTL", 30*24*time.Hour),
+
+		RequestTraceTTL: getDuration("REQUEST_TRACE_TTL", 7*24*time.Hour),
+
+		HealthCheckInterval: getDuration("HEALTH_CHECK_INTERVAL", time.Minute),
+		HealthSampleTTL:     getDuration("HEALTH_SAMPLE_TTL", 90*24*time.Hour),
+		StatusRateLimit:     getInt("STATUS_RATE_LIMIT", 60),
+		StatusSigningSecret: getEnv("STATUS_SIGNING_SECRET", ""),
+
+		SyntheticEnabled:          getBool("SYNTHETIC_ENABLED", false),
+		SyntheticInterval:         getDuration("SYNTHETIC_INTERVAL", 5*time.Minute),
+		SyntheticFailureThreshold: getInt("SYNTHETIC_FAILURE_THRESHOLD", 2),
+		MetricsToken:              getEnv("METRICS_TOKEN", ""),
+
+		JWTMinimalClaims: getBool("JWT_MINIMAL_CLAIMS", false),
+
+		JWTStrictJTI:   getBool("JWT_STRICT_JTI", false),
+		JWTReplayGrace: getDuration("JWT_REPLAY_GRACE", 10*time.Second),
+
+		CORSOrigins:    getStringMap("CORS_ORIGINS"),
+		RouteRateLimit: getBoolMap("ROUTE_RATE_LIMIT"),
+		RouteAudit:     getBoolMap("ROUTE_AUDIT"),
+
+		NotificationInboxTTL: getDuration("NOTIFICATION_INBOX_TTL", 90*24*time.Hour),
+
+		AnalyticsEnabled:       getBool("ANALYTICS_ENABLED", true),
+		AnalyticsSink:          getEnv("ANALYTICS_SINK", "mongo"),
+		AnalyticsMaxBatch:      getInt("ANALYTICS_MAX_BATCH", 100),
+		AnalyticsMaxBodyBytes:  int64(getInt("ANALYTICS_MAX_BODY_BYTES", 256<<10)),
+		AnalyticsRateLimit:     getInt("ANALYTICS_RATE_LIMIT", 120),
+		AnalyticsBufferSize:    getInt("ANALYTICS_BUFFER_SIZE", 10000),
+		AnalyticsFlushSize:     getInt("ANALYTICS_FLUSH_SIZE", 500),
+		AnalyticsFlushInterval: getDuration("ANALYTICS_FLUSH_INTERVAL", 5*time.Second),
+		AnalyticsEventTTL:      getDuration("ANALYTICS_EVENT_TTL", 90*24*time.Hour),
+		AnalyticsFileDir:       getEnv("ANALYTICS_FILE_DIR", "data/analytics"),
+		AnalyticsKafkaRESTURL:  getEnv("ANALYTICS_KAFKA_REST_URL", ""),
+		AnalyticsKafkaTopic:    getEnv("ANALYTICS_KAFKA_TOPIC", "analytics-events"),
+		AnalyticsS3Bucket:      getEnv("ANALYTICS_S3_BUCKET", ""),
+		AnalyticsS3Region:      getEnv("ANALYTICS_S3_REGION", getEnv("AWS_REGION", "us-east-1")),
+		AnalyticsS3Endpoint:    getEnv("ANALYTICS_S3_ENDPOINT", ""),
+		AnalyticsS3Prefix:      getEnv("ANALYTICS_S3_PREFIX", "events/"),
+		AWSAccessKeyID:         getEnv("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey:     getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		AWSSessionToken:        getEnv("AWS_SESSION_TOKEN", ""),
+
+		ReportsRefreshInterval: getDuration("REPORTS_REFRESH_INTERVAL", 24*time.Hour),
+
+		UploadScanners:      getList("UPLOAD_SCANNERS"),
+		ClamAVAddr:          getEnv("CLAMAV_ADDR", ""),
+		ClamAVTimeout:       getDuration("CLAMAV_TIMEOUT", 30*time.Second),
+		UploadScanFailOpen:  getBool("UPLOAD_SCAN_FAIL_OPEN", false),
+		UploadQuarantineTTL: getDuration("UPLOAD_QUARANTINE_TTL", 30*24*time.Hour),
+
+		UploadBucket:          getEnv("UPLOAD_BUCKET", ""),
+		UploadStorageEndpoint: getEnv("UPLOAD_STORAGE_ENDPOINT", ""),
+		UploadStorageRegion:   getEnv("UPLOAD_STORAGE_REGION", getEnv("AWS_REGION", "us-east-1")),
+		UploadKeyPrefix:       getEnv("UPLOAD_KEY_PREFIX", "uploads/"),
+		UploadURLTTL:          getDuration("UPLOAD_URL_TTL", 15*time.Minute),
+		UploadMaxBytes:        int64(getInt("UPLOAD_MAX_BYTES", 100<<20)),
+
+		FileGCInterval:  getDuration("FILE_GC_INTERVAL", 6*time.Hour),
+		FileOrphanGrace: getDuration("FILE_ORPHAN_GRACE", 24*time.Hour),
+
+		BreakGlassEnabled: getBool("BREAK_GLASS_ENABLED", false),
+		BreakGlassTTL:     getDuration("BREAK_GLASS_TTL", 15*time.Minute),
+
+		MigrateOnStartup: getBool("MIGRATE_ON_STARTUP", true),
+
+		ChallengeSteps:       getStringMap("CHALLENGE_STEPS"),
+		ChallengeWindow:      getDuration("CHALLENGE_WINDOW", 15*time.Minute),
+		ChallengeOTPTTL:      getDuration("CHALLENGE_OTP_TTL", 10*time.Minute),
+		ChallengeOTPCooldown: getDuration("CHALLENGE_OTP_COOLDOWN", time.Minute),
+		CaptchaVerifyURL:     getEnv("CAPTCHA_VERIFY_URL", ""),
+		CaptchaSecret:        getEnv("CAPTCHA_SECRET", ""),
+		CaptchaSiteKey:       getEnv("CAPTCHA_SITE_KEY", ""),
+
+		ChallengePoWDifficulty:    getInt("CHALLENGE_POW_DIFFICULTY", 18),
+		ChallengePoWMaxDifficulty: getInt("CHALLENGE_POW_MAX_DIFFICULTY", 24),
+		ChallengePoWSurge:         getInt("CHALLENGE_POW_SURGE", 120),
+		ChallengePoWTTL:           getDuration("CHALLENGE_POW_TTL", 5*time.Minute),
+
+		MeshIdentity:        getEnv("MESH_IDENTITY", ""),
+		TrustedProxies:      getList("TRUSTED_PROXY"),
+		MeshJWTHeader:       getEnv("MESH_JWT_HEADER", "X-Jwt-Payload"),
+		MeshJWTIssuers:      getList("MESH_JWT_ISSUERS"),
+		MeshServiceAccounts: getStringMap("MESH_SERVICE_ACCOUNTS"),
+
+		AccessTokenTTL:            getDuration("ACCESS_TOKEN_TTL", 24*time.Hour),
+		RefreshTokenTTL:           getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		RememberMeAccessTokenTTL:  getDuration("REMEMBER_ME_ACCESS_TOKEN_TTL", 24*time.Hour),
+		RememberMeRefreshTokenTTL: getDuration("REMEMBER_ME_REFRESH_TOKEN_TTL", 90*24*time.Hour),
+
+		SignatureMaxSkew: getDuration("SIGNATURE_MAX_SKEW", 5*time.Minute),
+
+		PasswordResetTTL:      getDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetCooldown: getDuration("PASSWORD_RESET_COOLDOWN", time.Minute),
+		PasswordResetURL:      getEnv("PASSWORD_RESET_URL", ""),
+
+		EmailVerificationRequired: getBool("EMAIL_VERIFICATION_REQUIRED", false),
+		EmailVerificationTTL:      getDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		EmailVerificationURL:      getEnv("EMAIL_VERIFICATION_URL", ""),
+
+		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
+		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", ""),
+
+		GitHubClientID:     getEnv("GITHUB_CLIENT_ID", ""),
+		GitHubClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+		GitHubRedirectURL:  getEnv("GITHUB_REDIRECT_URL", ""),
+
+		MongoStandbyURI:          getEnv("MONGO_STANDBY_URI", ""),
+		MongoCutoverDrainTimeout: getDuration("MONGO_CUTOVER_DRAIN_TIMEOUT", 10*time.Second),
+		MongoCutoverPoll:         getDuration("MONGO_CUTOVER_POLL", 15*time.Second),
+
+		DemoMode:          getBool("DEMO_MODE", false),
+		DemoFixtures:      getEnv("DEMO_FIXTURES", ""),
+		DemoResetInterval: getDuration("DEMO_RESET_INTERVAL", time.Hour),
+
+		MagicLinkTTL:      getDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkCooldown: getDuration("MAGIC_LINK_COOLDOWN", time.Minute),
+		MagicLinkURL:      getEnv("MAGIC_LINK_URL", ""),
+
+		SudoTTL: getDuration("SUDO_TTL", 10*time.Minute),
+
+		RoleGrantInterval: getDuration("ROLE_GRANT_INTERVAL", time.Minute),
+
+		AuthRatePerMinute: getInt("AUTH_RATE_PER_MINUTE", 10),
+		AuthRateBurst:     getInt("AUTH_RATE_BURST", 20),
+		AuthRateStore:     getEnv("AUTH_RATE_STORE", "memory"),
+
+		OnboardingSteps: getList("ONBOARDING_STEPS"),
+
+		EventSourcingEnabled:  getBool("EVENT_SOURCING_ENABLED", false),
+		EventSnapshotInterval: getInt("EVENT_SNAPSHOT_INTERVAL", 100),
+
+		PasswordMinLength:       getInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:       getInt("PASSWORD_MAX_LENGTH", 72),
+		PasswordRequiredClasses: getList("PASSWORD_REQUIRED_CLASSES"),
+		PasswordDenyCommon:      getBool("PASSWORD_DENY_COMMON", true),
+		PasswordDenylistFile:    getEnv("PASSWORD_DENYLIST_FILE", ""),
+
+		SignupGateRules:    getList("SIGNUP_GATE_RULES"),
+		GeoIPCountryHeader: getEnv("GEOIP_COUNTRY_HEADER", ""),
+		GeoIPDatabase:      getEnv("GEOIP_DATABASE", ""),
+
+		CaptchaFlows:    getList("CAPTCHA_FLOWS"),
+		CaptchaProvider: getEnv("CAPTCHA_PROVIDER", "hcaptcha"),
+		CaptchaMinScore: getFloat("CAPTCHA_MIN_SCORE", 0),
+
+		JobWorkers:      getInt("JOB_WORKERS", 2),
+		JobPollInterval: getDuration("JOB_POLL_INTERVAL", time.Second),
+		JobMaxAttempts:  getInt("JOB_MAX_ATTEMPTS", 5),
+		JobRetention:    getDuration("JOB_RETENTION", 7*24*time.Hour),
+
+		VerifyEmailResendCooldown: getDuration("VERIFY_EMAIL_RESEND_COOLDOWN", time.Minute),
+		VerifyEmailResendDailyCap: getInt("VERIFY_EMAIL_RESEND_DAILY_CAP", 5),
+
+		RegistrationEnumeration: getEnv("REGISTRATION_ENUMERATION", ""),
+		AvailabilityChallenge:   getEnv("AVAILABILITY_CHALLENGE", "pow"),
+		AvailabilityCooldown:    getDuration("AVAILABILITY_COOLDOWN", 5*time.Second),
+		AvailabilityDailyCap:    getInt("AVAILABILITY_DAILY_CAP", 20),
+
+		EnumerationHardening: getBool("ENUMERATION_HARDENING", false),
+
+		AccountDeletionRetention: getDuration("ACCOUNT_DELETION_RETENTION", 30*24*time.Hour),
+
+		LoginHistoryRetention: getDuration("LOGIN_HISTORY_RETENTION", 90*24*time.Hour),
+
+		RouteAlertsEnabled:        getBool("ROUTE_ALERTS_ENABLED", false),
+		RouteAlertInterval:        getDuration("ROUTE_ALERT_INTERVAL", time.Minute),
+		RouteAlertMinRequests:     getInt("ROUTE_ALERT_MIN_REQUESTS", 20),
+		RouteAlertServerErrorRate: getFloat("ROUTE_ALERT_SERVER_ERROR_RATE", 0.05),
+		RouteAlertClientErrorRate: getFloat("ROUTE_ALERT_CLIENT_ERROR_RATE", 0.5),
+		RouteAlertValidationRate:  getFloat("ROUTE_ALERT_VALIDATION_RATE", 0.25),
+
+		SMSProvider:      getEnv("SMS_PROVIDER", "log"),
+		SMSFrom:          getEnv("SMS_FROM", ""),
+		TwilioAccountSID: getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioAuthToken:  getEnv("TWILIO_AUTH_TOKEN", ""),
+		SMSWebhookURL:    getEnv("SMS_WEBHOOK_URL", ""),
+		OTPTTL:           getDuration("OTP_TTL", 5*time.Minute),
+		OTPMaxAttempts:   getInt("OTP_MAX_ATTEMPTS", 5),
+		OTPCooldown:      getDuration("OTP_COOLDOWN", 30*time.Second),
+
+		LogArchiveBucket:           getEnv("LOG_ARCHIVE_BUCKET", ""),
+		LogArchiveEndpoint:         getEnv("LOG_ARCHIVE_ENDPOINT", ""),
+		LogArchiveRegion:           getEnv("LOG_ARCHIVE_REGION", getEnv("AWS_REGION", "us-east-1")),
+		LogArchivePrefix:           getEnv("LOG_ARCHIVE_PREFIX", "log-archives/"),
+		LogArchiveKey:              getEnv("LOG_ARCHIVE_KEY", ""),
+		LogArchiveInterval:         getDuration("LOG_ARCHIVE_INTERVAL", time.Hour),
+		LogArchiveHotRetention:     getDuration("LOG_ARCHIVE_HOT_RETENTION", 0),
+		LogArchiveWarmRetention:    getDuration("LOG_ARCHIVE_WARM_RETENTION", 90*24*time.Hour),
+		LogArchiveRetention:        getDuration("LOG_ARCHIVE_RETENTION", 7*365*24*time.Hour),
+		LogArchiveColdStorageClass: getEnv("LOG_ARCHIVE_COLD_STORAGE_CLASS", "STANDARD_IA"),
+		LogArchiveMaxRestoreDays:   getInt("LOG_ARCHIVE_MAX_RESTORE_DAYS", 31),
+		LogArchiveRestoreTTL:       getDuration("LOG_ARCHIVE_RESTORE_TTL", 7*24*time.Hour),
+
+		WebAuthnRPID:             getEnv("WEBAUTHN_RP_ID", ""),
+		WebAuthnRPName:           getEnv("WEBAUTHN_RP_NAME", ""),
+		WebAuthnOrigins:          getList("WEBAUTHN_ORIGINS"),
+		WebAuthnTimeout:          getDuration("WEBAUTHN_TIMEOUT", 5*time.Minute),
+		WebAuthnUserVerification: getEnv("WEBAUTHN_USER_VERIFICATION", "preferred"),
+
+		InviteOnly:    getBool("INVITE_ONLY", false),
+		InvitationTTL: getDuration("INVITATION_TTL", 7*24*time.Hour),
+		InvitationURL: getEnv("INVITATION_URL", ""),
+
+		DeprecatedRoutes:          getStringMap("DEPRECATED_ROUTES"),
+		DeprecationSuccessors:     getStringMap("DEPRECATION_SUCCESSORS"),
+		DeprecationURL:            getEnv("DEPRECATION_URL", ""),
+		DeprecationUsageRetention: getDuration("DEPRECATION_USAGE_RETENTION", 90*24*time.Hour),
+
+		PermissionCacheTTL: getDuration("PERMISSION_CACHE_TTL", time.Minute),
+	}
+
+	// JWT_SECRETS takes precedence over the single JWT_SECRET
+	if len(cfg.JWTSecrets) > 0 {
+		cfg.JWTSecret = cfg.JWTSecrets[0]
+	} else {
+		cfg.JWTSecrets = []string{cfg.JWTSecret}
+	}
+
+	// Uploads are verified by their magic bytes unless UPLOAD_SCANNERS says
+	// otherwise ("none" disables scanning)
+	if len(cfg.UploadScanners) == 0 {
+		cfg.UploadScanners = []string{"mime"}
 	}
+	if cfg.EnumerationHardening {
+		cfg.RegistrationEnumeration = "silent"
+	}
+	if len(cfg.OnboardingSteps) == 0 {
+		cfg.OnboardingSteps = []string{"email_verified", "profile", "api_key"}
+	}
+	return cfg
+}
+
+// GroupLimit returns the concurrency limit of a route group, defaulting to fallback
+func (c *Config) GroupLimit(group string, fallback int) int {
+	if limit, ok := c.ConcurrencyLimits[strings.ToUpper(group)]; ok {
+		return limit
+	}
+	return fallback
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -35,3 +893,115 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getDuration parses a duration environment variable or returns a default value
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Invalid duration for %s, using default %s", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getBool parses a boolean environment variable or returns a default value
+func getBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+		log.Printf("Invalid boolean for %s, using default %t", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getInt parses an integer environment variable or returns a default value
+func getInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+		log.Printf("Invalid integer for %s, using default %d", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getFloat parses a floating point environment variable or returns a default value
+func getFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		log.Printf("Invalid number for %s, using default %g", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// getList splits a comma-separated environment variable, dropping empty entries
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getIntMap parses a "KEY=1,OTHER=2" environment variable into a map with upper-cased keys
+func getIntMap(key string) map[string]int {
+	m := make(map[string]int)
+	for _, item := range getList(key) {
+		name, value, ok := strings.Cut(item, "=")
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil {
+			log.Printf("Invalid entry %q in %s, ignoring", item, key)
+			continue
+		}
+		m[strings.ToUpper(strings.TrimSpace(name))] = i
+	}
+	return m
+}
+
+// getStringMap parses a "key=value,other=value" environment variable into a map
+func getStringMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range getList(key) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("Invalid entry %q in %s, ignoring", item, key)
+			continue
+		}
+		m[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return m
+}
+
+// getBoolMap parses a "key=true,other=false" environment variable into a map of booleans
+func getBoolMap(key string) map[string]bool {
+	m := make(map[string]bool)
+	for name, value := range getStringMap(key) {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid boolean %q for %s in %s, ignoring", value, name, key)
+			continue
+		}
+		m[name] = b
+	}
+	return m
+}
+
+// getDurationMap parses a "key=30s,other=5m" environment variable into a map of durations
+func getDurationMap(key string) map[string]time.Duration {
+	m := make(map[string]time.Duration)
+	for name, value := range getStringMap(key) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid duration %q for %s in %s, ignoring", value, name, key)
+			continue
+		}
+		m[name] = d
+	}
+	return m
+}
@@ -0,0 +1,234 @@
+// Package correlation ties together everything one request produced. Every
+// request gets a request ID (X-Request-ID, kept when the client sends a valid
+// one) and a trace ID (from a W3C traceparent header, or new), and the user ID
+// once authenticated. Logf and Errorf prefix log lines with these IDs and keep
+// them with the request; audit records and security events store the IDs too.
+//
+// Requests that logged something or failed with a 5xx are stored as a Trace,
+// so GET /admin/requests/{request_id} can show the request, its log lines,
+// its errors and the audit and security records it created.
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// Headers carrying the IDs
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceIDHeader     = "X-Trace-ID"
+	TraceParentHeader = "traceparent"
+)
+
+// Log levels of trace lines
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+// collection holds stored traces
+const collection = "request_traces"
+
+// maxLines bounds the lines kept per request
+const maxLines = 200
+
+var (
+	requestIDPattern   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// Line is one log line written during a request
+type Line struct {
+	At      time.Time `bson:"at" json:"at"`
+	Level   string    `bson:"level" json:"level"`
+	Message string    `bson:"message" json:"message"`
+}
+
+// Trace is a stored request with the lines it logged
+type Trace struct {
+	RequestID  string    `bson:"_id" json:"request_id"`
+	TraceID    string    `bson:"trace_id" json:"trace_id"`
+	UserID     string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Method     string    `bson:"method" json:"method"`
+	Path       string    `bson:"path" json:"path"`
+	Status     int       `bson:"status" json:"status"`
+	DurationMs float64   `bson:"duration_ms" json:"duration_ms"`
+	Lines      []Line    `bson:"lines" json:"lines"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// IDs are the correlation IDs of a request
+type IDs struct {
+	RequestID string
+	TraceID   string
+	UserID    string
+}
+
+// state is the mutable correlation data of a request in flight
+type state struct {
+	requestID string
+	traceID   string
+
+	mu     sync.Mutex
+	userID string
+	lines  []Line
+}
+
+type contextKey struct{}
+
+var store bool
+
+// Init creates the TTL index for stored traces and turns storing on
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(cfg.RequestTraceTTL.Seconds())),
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("correlation: failed to create TTL index: %v", err)
+	}
+	store = true
+}
+
+// Middleware assigns the request its IDs, returns them in response headers
+// and stores the trace once the request is done. It should wrap every other
+// handler, including panic recovery, so failures are captured.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &state{requestID: r.Header.Get(RequestIDHeader), traceID: parseTraceParent(r.Header.Get(TraceParentHeader))}
+		if !requestIDPattern.MatchString(s.requestID) {
+			s.requestID = randomHex(12)
+		}
+		if s.traceID == "" {
+			s.traceID = randomHex(16)
+		}
+		w.Header().Set(RequestIDHeader, s.requestID)
+		w.Header().Set(TraceIDHeader, s.traceID)
+
+		start := time.Now()
+		sw := utils.NewStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !store || (len(s.lines) == 0 && sw.Status < 500) {
+			return
+		}
+		trace := Trace{
+			RequestID:  s.requestID,
+			TraceID:    s.traceID,
+			UserID:     s.userID,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.Status,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Lines:      s.lines,
+			CreatedAt:  clock.Now(),
+		}
+		if trace.Lines == nil {
+			trace.Lines = []Line{}
+		}
+		go func() {
+			opts := options.Replace().SetUpsert(true)
+			if _, err := database.DB.Collection(collection).ReplaceOne(context.Background(), bson.M{"_id": trace.RequestID}, trace, opts); err != nil {
+				log.Printf("correlation: failed to store trace %s: %v", trace.RequestID, err)
+			}
+		}()
+	})
+}
+
+// parseTraceParent returns the trace ID of a W3C traceparent header, or ""
+func parseTraceParent(header string) string {
+	m := traceParentPattern.FindStringSubmatch(strings.TrimSpace(header))
+	if m == nil || m[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	return m[1]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetUser records the authenticated user of the request
+func SetUser(ctx context.Context, userID string) {
+	if s, ok := ctx.Value(contextKey{}).(*state); ok {
+		s.mu.Lock()
+		s.userID = userID
+		s.mu.Unlock()
+	}
+}
+
+// FromContext returns the correlation IDs of the request; they are empty
+// outside a request
+func FromContext(ctx context.Context) IDs {
+	s, ok := ctx.Value(contextKey{}).(*state)
+	if !ok {
+		return IDs{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return IDs{RequestID: s.requestID, TraceID: s.traceID, UserID: s.userID}
+}
+
+// Logf logs a line prefixed with the request's IDs and keeps it with the request
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	write(ctx, LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Errorf is Logf for errors and failures
+func Errorf(ctx context.Context, format string, args ...interface{}) {
+	write(ctx, LevelError, fmt.Sprintf(format, args...))
+}
+
+func write(ctx context.Context, level, message string) {
+	s, ok := ctx.Value(contextKey{}).(*state)
+	if !ok {
+		log.Print(message)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := "[req=" + s.requestID + " trace=" + s.traceID
+	if s.userID != "" {
+		prefix += " user=" + s.userID
+	}
+	log.Print(prefix + "] " + message)
+
+	if len(s.lines) < maxLines {
+		s.lines = append(s.lines, Line{At: clock.Now(), Level: level, Message: message})
+	}
+}
+
+// Get returns a stored trace, or mongo.ErrNoDocuments when the request was
+// not stored or has expired
+func Get(ctx context.Context, requestID string) (*Trace, error) {
+	var trace Trace
+	if err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": requestID}).Decode(&trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
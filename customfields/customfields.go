@@ -0,0 +1,412 @@
+// Package customfields lets admins extend the user profile with fields of
+// their own, such as a company size or an employee number. The schema is kept
+// in the custom_fields collection; the profile endpoints validate values
+// against it and store them in the user's custom_fields, encrypting the
+// values of encrypted fields with the user's data key.
+package customfields
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+)
+
+const collection = "custom_fields"
+
+// MaxFields bounds the fields of the schema
+const MaxFields = 50
+
+// MaxStringLength bounds string values, and MaxLength with it
+const MaxStringLength = 1000
+
+// maxOptions bounds the options of an enum
+const maxOptions = 100
+
+// reloadInterval bounds how long other instances serve a stale schema
+const reloadInterval = time.Minute
+
+// dateLayout is the format of date values
+const dateLayout = "2006-01-02"
+
+// namePattern is what a field name looks like
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+var (
+	// ErrTooMany is returned when the schema already has MaxFields fields
+	ErrTooMany = fmt.Errorf("the schema has at most %d fields", MaxFields)
+	// ErrImmutable is returned when a field's type or encryption would change,
+	// which the values users already set could not follow
+	ErrImmutable = errors.New("the type and encryption of a field cannot change; remove it and define it anew")
+	// ErrNotFound is returned for fields not in the schema
+	ErrNotFound = errors.New("custom field not found")
+)
+
+// ValueError is returned for values a field does not accept
+type ValueError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValueError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+var (
+	mu       sync.RWMutex
+	fields   []models.CustomField
+	loadedAt time.Time
+)
+
+// Init creates the index of the schema
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"name": 1}, Options: options.Index().SetUnique(true)}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("customfields: failed to create indexes: %v", err)
+	}
+}
+
+// Schema returns the fields by name, reloading them when the cached copy is
+// older than reloadInterval
+func Schema(ctx context.Context) ([]models.CustomField, error) {
+	mu.RLock()
+	list, fresh := fields, time.Since(loadedAt) < reloadInterval
+	mu.RUnlock()
+	if fresh {
+		return list, nil
+	}
+	return Reload(ctx)
+}
+
+// Reload reads the schema from the database, e.g. after an admin change
+func Reload(ctx context.Context) ([]models.CustomField, error) {
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []models.CustomField{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	fields, loadedAt = list, time.Now()
+	mu.Unlock()
+	return list, nil
+}
+
+// schemaByName returns the fields keyed by name
+func schemaByName(ctx context.Context) (map[string]models.CustomField, error) {
+	list, err := Schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.CustomField, len(list))
+	for _, field := range list {
+		byName[field.Name] = field
+	}
+	return byName, nil
+}
+
+// Check validates a field definition
+func Check(field *models.CustomField) error {
+	if !namePattern.MatchString(field.Name) {
+		return errors.New("names must be 1-40 lowercase letters, digits or _ starting with a letter")
+	}
+	if field.Type != models.FieldEnum && len(field.Options) > 0 {
+		return errors.New("only enum fields have options")
+	}
+	if field.Type != models.FieldString && (field.MinLength != 0 || field.MaxLength != 0 || field.Pattern != "") {
+		return errors.New("only string fields have min_length, max_length and pattern")
+	}
+	if field.Type != models.FieldNumber && (field.Min != nil || field.Max != nil) {
+		return errors.New("only number fields have min and max")
+	}
+
+	switch field.Type {
+	case models.FieldString:
+		if field.MinLength < 0 || field.MaxLength < 0 || field.MaxLength > MaxStringLength {
+			return fmt.Errorf("min_length and max_length must be between 0 and %d", MaxStringLength)
+		}
+		if field.MaxLength != 0 && field.MinLength > field.MaxLength {
+			return errors.New("min_length must not exceed max_length")
+		}
+		if _, err := regexp.Compile(field.Pattern); err != nil {
+			return errors.New("pattern is not a valid regular expression")
+		}
+	case models.FieldNumber:
+		if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+			return errors.New("min must not exceed max")
+		}
+	case models.FieldEnum:
+		if len(field.Options) == 0 || len(field.Options) > maxOptions {
+			return fmt.Errorf("enum fields have 1-%d options", maxOptions)
+		}
+		seen := make(map[string]bool, len(field.Options))
+		for _, option := range field.Options {
+			if option == "" || seen[option] {
+				return errors.New("options must be distinct and not empty")
+			}
+			seen[option] = true
+		}
+	case models.FieldBoolean, models.FieldDate:
+	default:
+		return errors.New("type must be string, number, boolean, date or enum")
+	}
+	return nil
+}
+
+// Define adds a checked field to the schema, or replaces the definition of
+// the field with its name, and reports whether it was added
+func Define(ctx context.Context, field *models.CustomField) (bool, error) {
+	coll := database.DB.Collection(collection)
+	var existing models.CustomField
+	err := coll.FindOne(ctx, bson.M{"name": field.Name}).Decode(&existing)
+	created := errors.Is(err, mongo.ErrNoDocuments)
+	if err != nil && !created {
+		return false, err
+	}
+
+	now := clock.Now()
+	if created {
+		count, err := coll.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return false, err
+		}
+		if count >= MaxFields {
+			return false, ErrTooMany
+		}
+		field.ID, field.CreatedAt = primitive.NewObjectID(), now
+	} else {
+		if existing.Type != field.Type || existing.Encrypted != field.Encrypted {
+			return false, ErrImmutable
+		}
+		field.ID, field.CreatedAt = existing.ID, existing.CreatedAt
+	}
+	field.UpdatedAt = now
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := coll.ReplaceOne(ctx, bson.M{"name": field.Name}, field, opts); err != nil {
+		return false, err
+	}
+	_, err = Reload(ctx)
+	return created, err
+}
+
+// Remove deletes the values of a field from every user, then the field from
+// the schema, so a failed removal can be retried
+func Remove(ctx context.Context, name string) (*models.CustomField, error) {
+	coll := database.DB.Collection(collection)
+	var field models.CustomField
+	err := coll.FindOne(ctx, bson.M{"name": name}).Decode(&field)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	path := "custom_fields." + name
+	for region, db := range database.Regions {
+		if _, err := db.Collection("users").UpdateMany(ctx, bson.M{path: bson.M{"$exists": true}}, bson.M{"$unset": bson.M{path: ""}}); err != nil {
+			return nil, fmt.Errorf("removing values in region %s: %w", region, err)
+		}
+	}
+	if _, err := coll.DeleteOne(ctx, bson.M{"_id": field.ID}); err != nil {
+		return nil, err
+	}
+	_, err = Reload(ctx)
+	return &field, err
+}
+
+// Update validates values sent to the profile, keyed by field name, and
+// returns the $set and $unset of the user document: null clears a value.
+// Values of encrypted fields are encrypted for the organization orgID.
+func Update(ctx context.Context, cfg *config.Config, orgID string, values map[string]json.RawMessage) (bson.M, bson.M, error) {
+	schema, err := schemaByName(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for name, raw := range values {
+		field, ok := schema[name]
+		if !ok {
+			return nil, nil, &ValueError{Field: name, Message: "unknown custom field"}
+		}
+		value, err := validate(field, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		path := "custom_fields." + name
+		if value == nil {
+			unset[path] = ""
+			continue
+		}
+		if field.Encrypted {
+			if value, err = encrypt(ctx, cfg, orgID, value); err != nil {
+				return nil, nil, err
+			}
+		}
+		set[path] = value
+	}
+	return set, unset, nil
+}
+
+// validate checks a value against its field and returns it decoded, or nil
+// to clear it
+func validate(field models.CustomField, raw json.RawMessage) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, &ValueError{Field: field.Name, Message: "invalid value"}
+	}
+	if value == nil || value == "" {
+		if field.Required {
+			return nil, &ValueError{Field: field.Name, Message: "is required"}
+		}
+		return nil, nil
+	}
+
+	invalid := func(message string) error {
+		return &ValueError{Field: field.Name, Message: message}
+	}
+	switch field.Type {
+	case models.FieldString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid("must be a string")
+		}
+		length := utf8.RuneCountInString(s)
+		maxLength := field.MaxLength
+		if maxLength == 0 {
+			maxLength = MaxStringLength
+		}
+		if length < field.MinLength || length > maxLength {
+			return nil, invalid(fmt.Sprintf("must be %d-%d characters", field.MinLength, maxLength))
+		}
+		if field.Pattern != "" {
+			if matched, _ := regexp.MatchString(field.Pattern, s); !matched {
+				return nil, invalid("does not match the required format")
+			}
+		}
+	case models.FieldNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsInf(n, 0) {
+			return nil, invalid("must be a number")
+		}
+		if (field.Min != nil && n < *field.Min) || (field.Max != nil && n > *field.Max) {
+			return nil, invalid("is out of range")
+		}
+	case models.FieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return nil, invalid("must be true or false")
+		}
+	case models.FieldDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid("must be a date in YYYY-MM-DD format")
+		}
+		if _, err := time.Parse(dateLayout, s); err != nil {
+			return nil, invalid("must be a date in YYYY-MM-DD format")
+		}
+	case models.FieldEnum:
+		s, _ := value.(string)
+		for _, option := range field.Options {
+			if s == option {
+				return s, nil
+			}
+		}
+		return nil, invalid("must be one of the field's options")
+	}
+	return value, nil
+}
+
+// encrypt encrypts a value as JSON, so it decrypts to its type
+func encrypt(ctx context.Context, cfg *config.Config, orgID string, value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return keys.Encrypt(ctx, cfg, orgID, string(data))
+}
+
+// Decode returns the values a user stored, with encrypted ones decrypted.
+// Values of fields no longer in the schema are left out; those of a
+// crypto-shredded organization are returned as nil.
+func Decode(ctx context.Context, cfg *config.Config, stored map[string]interface{}) (map[string]interface{}, error) {
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	schema, err := schemaByName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(stored))
+	for name, value := range stored {
+		field, ok := schema[name]
+		if !ok {
+			continue
+		}
+		if field.Encrypted {
+			ciphertext, _ := value.(string)
+			plaintext, err := keys.Decrypt(ctx, cfg, ciphertext)
+			if errors.Is(err, keys.ErrKeyDestroyed) {
+				values[name] = nil
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			value = nil
+			if err := json.Unmarshal([]byte(plaintext), &value); err != nil {
+				return nil, err
+			}
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Reencrypt returns the $set moving the encrypted values a user stored to the
+// data key of the organization orgID
+func Reencrypt(ctx context.Context, cfg *config.Config, orgID string, stored map[string]interface{}) (bson.M, error) {
+	set := bson.M{}
+	if len(stored) == 0 {
+		return set, nil
+	}
+	schema, err := schemaByName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range stored {
+		ciphertext, ok := value.(string)
+		if field, known := schema[name]; !known || !field.Encrypted || !ok {
+			continue
+		}
+		plaintext, err := keys.Decrypt(ctx, cfg, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		if set["custom_fields."+name], err = keys.Encrypt(ctx, cfg, orgID, plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,320 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Blue/green cutover moves the default region between the cluster behind
+// MONGO_URI ("blue") and a standby cluster ("green") without a restart. A
+// cutover connects to and checks the target first, then pauses new requests,
+// waits for the ones in flight to finish, re-points DB and checks the target
+// again before letting requests through. The active cluster is recorded in
+// both clusters, so other instances, and instances started later, follow.
+
+// Cluster names
+const (
+	Blue  = "blue"
+	Green = "green"
+)
+
+// Cutover states
+const (
+	CutoverIdle    = "idle"
+	CutoverRunning = "running"
+	CutoverDone    = "done"
+	CutoverFailed  = "failed"
+)
+
+// markers holds the name of the active cluster, in both clusters
+const markers = "cluster_cutover"
+
+var (
+	// ErrNoStandby is returned when MONGO_STANDBY_URI is not set
+	ErrNoStandby = errors.New("no standby cluster configured")
+	// ErrCutoverRunning is returned while another cutover is in progress
+	ErrCutoverRunning = errors.New("a cutover is already running")
+	// ErrDrainTimeout is returned when in-flight requests outlast the drain
+	// timeout; the cutover is abandoned and the active cluster kept
+	ErrDrainTimeout = errors.New("in-flight requests did not drain in time")
+)
+
+// CutoverStatus describes the clusters and the latest cutover
+type CutoverStatus struct {
+	Active     string     `json:"active" example:"blue"`
+	Standby    string     `json:"standby,omitempty" example:"green"`
+	State      string     `json:"state" example:"done"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// DrainedIn is how long requests were paused
+	DrainedIn string `json:"drained_in,omitempty" example:"120ms"`
+}
+
+var (
+	cutoverMu    sync.Mutex
+	uris         = make(map[string]string)
+	connected    = make(map[string]*mongo.Database)
+	status       = CutoverStatus{Active: Blue, State: CutoverIdle}
+	drainTimeout time.Duration
+)
+
+// gate lets requests through unless a cutover is draining them
+var gate struct {
+	sync.Mutex
+	inFlight int
+	// paused is closed when requests may continue
+	paused chan struct{}
+	// drained is closed when the last request in flight finishes
+	drained chan struct{}
+}
+
+// ConfigureStandby sets the standby cluster, moves to it at once if the
+// active cluster says another instance already did, and from then on checks
+// for such moves every poll interval
+func ConfigureStandby(uri string, drain, poll time.Duration) {
+	if uri == "" {
+		return
+	}
+	cutoverMu.Lock()
+	uris[Blue] = primaryURI
+	uris[Green] = uri
+	connected[Blue] = DB
+	status.Standby = Green
+	drainTimeout = drain
+	cutoverMu.Unlock()
+
+	if marked() == Green {
+		log.Printf("database: %s is active, cutting over", Green)
+		if err := begin(); err == nil {
+			cutover(Blue, Green)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		for range ticker.C {
+			follow()
+		}
+	}()
+}
+
+// marked returns the active cluster recorded in DB, if any
+func marked() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var marker struct {
+		Active string `bson:"active"`
+	}
+	err := DB.Collection(markers).FindOne(ctx, bson.M{"_id": "active"}).Decode(&marker)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("database: failed to read cutover marker: %v", err)
+	}
+	return marker.Active
+}
+
+// follow cuts over when the active cluster says another instance moved away
+func follow() {
+	active := marked()
+	cutoverMu.Lock()
+	current := status.Active
+	cutoverMu.Unlock()
+	if active == "" || active == current {
+		return
+	}
+	log.Printf("database: following cutover from %s to %s", current, active)
+	if err := StartCutover(); err != nil && !errors.Is(err, ErrCutoverRunning) {
+		log.Printf("database: failed to follow cutover: %v", err)
+	}
+}
+
+// Status returns the clusters and the latest cutover
+func Status() CutoverStatus {
+	cutoverMu.Lock()
+	defer cutoverMu.Unlock()
+	return status
+}
+
+// StartCutover begins moving to the standby cluster in the background;
+// Status reports the outcome
+func StartCutover() error {
+	if err := begin(); err != nil {
+		return err
+	}
+	status := Status()
+	go cutover(status.Active, status.Standby)
+	return nil
+}
+
+// begin marks a cutover as running
+func begin() error {
+	cutoverMu.Lock()
+	defer cutoverMu.Unlock()
+	if status.Standby == "" {
+		return ErrNoStandby
+	}
+	if status.State == CutoverRunning {
+		return ErrCutoverRunning
+	}
+	now := time.Now()
+	status.State, status.Error, status.StartedAt, status.FinishedAt, status.DrainedIn = CutoverRunning, "", &now, nil, ""
+	return nil
+}
+
+func cutover(from, to string) {
+	drained, err := switchTo(from, to)
+
+	cutoverMu.Lock()
+	defer cutoverMu.Unlock()
+	now := time.Now()
+	status.FinishedAt = &now
+	if drained > 0 {
+		status.DrainedIn = drained.Round(time.Millisecond).String()
+	}
+	if err != nil {
+		status.State, status.Error = CutoverFailed, err.Error()
+		log.Printf("database: cutover from %s to %s failed: %v", from, to, err)
+		return
+	}
+	status.State, status.Active, status.Standby = CutoverDone, to, from
+	log.Printf("database: cut over from %s to %s, requests paused for %s", from, to, status.DrainedIn)
+}
+
+// switchTo re-points DB at a cluster and returns how long requests were paused
+func switchTo(from, to string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout+30*time.Second)
+	defer cancel()
+
+	// Check the target before pausing anything
+	target := connected[to]
+	if target == nil {
+		db, err := dial(ctx, uris[to])
+		if err != nil {
+			return 0, err
+		}
+		target = db
+		connected[to] = db
+	}
+	if err := verify(ctx, target); err != nil {
+		return 0, fmt.Errorf("%s is unhealthy: %w", to, err)
+	}
+
+	start := time.Now()
+	if err := drain(drainTimeout); err != nil {
+		resume()
+		return time.Since(start), err
+	}
+	previous := DB
+	DB, Regions[DefaultRegion] = target, target
+	err := verify(ctx, target)
+	if err != nil {
+		DB, Regions[DefaultRegion] = previous, previous
+	}
+	resume()
+	paused := time.Since(start)
+	if err != nil {
+		return paused, fmt.Errorf("%s failed after the switch, switched back: %w", to, err)
+	}
+
+	// Record the move in both clusters: the old one tells instances still
+	// using it, the new one tells instances that start with MONGO_URI
+	marker := bson.M{"$set": bson.M{"active": to, "updated_at": time.Now()}}
+	for _, db := range []*mongo.Database{previous, target} {
+		if _, err := db.Collection(markers).UpdateOne(ctx, bson.M{"_id": "active"}, marker, options.Update().SetUpsert(true)); err != nil {
+			log.Printf("database: failed to record cutover marker: %v", err)
+		}
+	}
+	return paused, nil
+}
+
+// verify checks that a cluster has a reachable primary that accepts writes
+func verify(ctx context.Context, db *mongo.Database) error {
+	if err := db.Client().Ping(ctx, readpref.Primary()); err != nil {
+		return err
+	}
+	probe := db.Collection(markers)
+	id := primitive.NewObjectID()
+	if _, err := probe.InsertOne(ctx, bson.M{"_id": id, "probe": true}); err != nil {
+		return err
+	}
+	_, err := probe.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// drain pauses new requests and waits for those in flight to finish
+func drain(timeout time.Duration) error {
+	drained := make(chan struct{})
+	gate.Lock()
+	gate.paused = make(chan struct{})
+	if gate.inFlight == 0 {
+		close(drained)
+	} else {
+		gate.drained = drained
+	}
+	gate.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return nil
+	case <-timer.C:
+		return ErrDrainTimeout
+	}
+}
+
+// resume lets paused requests continue
+func resume() {
+	gate.Lock()
+	defer gate.Unlock()
+	close(gate.paused)
+	gate.paused, gate.drained = nil, nil
+}
+
+// Track counts requests in flight so a cutover can drain them, and holds new
+// ones while it does. Event streams run for as long as the client listens,
+// so they are not waited for.
+func Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "text/event-stream" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for {
+			gate.Lock()
+			paused := gate.paused
+			if paused == nil {
+				gate.inFlight++
+				gate.Unlock()
+				break
+			}
+			gate.Unlock()
+			select {
+			case <-paused:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		defer func() {
+			gate.Lock()
+			gate.inFlight--
+			if gate.inFlight == 0 && gate.drained != nil {
+				close(gate.drained)
+				gate.drained = nil
+			}
+			gate.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -12,23 +12,75 @@ import (
 // DB is the global database connection
 var DB *mongo.Database
 
+// Regions holds the database of every data residency region, including the
+// default region served by DB
+var Regions = make(map[string]*mongo.Database)
+
+// DefaultRegion is the region name of DB
+var DefaultRegion = "default"
+
+// primaryURI is the URI DB was first connected with
+var primaryURI string
+
 // Connect initializes the MongoDB connection
 func Connect(mongoURI string) {
+	primaryURI = mongoURI
+	DB = connect(mongoURI)
+	Regions[DefaultRegion] = DB
+
+	log.Println("MongoDB connected successfully")
+}
+
+// ConnectRegions connects the additional regional clusters used for data residency
+func ConnectRegions(defaultRegion string, uris map[string]string) {
+	if defaultRegion != "" && defaultRegion != DefaultRegion {
+		delete(Regions, DefaultRegion)
+		DefaultRegion = defaultRegion
+		Regions[DefaultRegion] = DB
+	}
+
+	for region, uri := range uris {
+		if region == DefaultRegion {
+			continue
+		}
+		Regions[region] = connect(uri)
+		log.Printf("MongoDB region %s connected successfully", region)
+	}
+}
+
+// Region returns the database of a data residency region; an empty name
+// selects the default region. It returns nil for unknown regions.
+func Region(name string) *mongo.Database {
+	if name == "" {
+		return DB
+	}
+	return Regions[name]
+}
+
+// connect opens and pings a MongoDB connection
+func connect(mongoURI string) *mongo.Database {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	db, err := dial(ctx, mongoURI)
 	if err != nil {
 		log.Fatal("Failed to connect to MongoDB:", err)
 	}
+	return db
+}
 
-	// Ping the database
-	err = client.Ping(ctx, nil)
+// dial opens and pings a MongoDB connection
+func dial(ctx context.Context, mongoURI string) (*mongo.Database, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
 	if err != nil {
-		log.Fatal("Failed to ping MongoDB:", err)
+		return nil, err
 	}
 
-	DB = client.Database("golang-backend")
+	// Ping the database
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
 
-	log.Println("MongoDB connected successfully")
+	return client.Database("golang-backend"), nil
 }
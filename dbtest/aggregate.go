@@ -0,0 +1,612 @@
+package dbtest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (srv *Server) aggregate(c *collection, cmd bson.D) (bson.D, error) {
+	v, _ := get(cmd, "pipeline")
+	pipeline, ok := v.(bson.A)
+	if !ok {
+		return nil, fail("pipeline must be an array")
+	}
+	docs := make([]bson.D, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, clone(doc))
+	}
+	out, err := runPipeline(docs, pipeline)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+	return cursorReply(c.ns, out), nil
+}
+
+// runPipeline applies aggregation stages in order
+func runPipeline(docs []bson.D, pipeline bson.A) ([]bson.D, error) {
+	for _, s := range pipeline {
+		stage, ok := s.(bson.D)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("a stage must be a document with one field")
+		}
+		var err error
+		docs, err = runStage(docs, stage[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func runStage(docs []bson.D, stage bson.E) ([]bson.D, error) {
+	switch stage.Key {
+	case "$match":
+		filter, _ := stage.Value.(bson.D)
+		var out []bson.D
+		for _, doc := range docs {
+			m, err := matches(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if m {
+				out = append(out, doc)
+			}
+		}
+		return out, nil
+	case "$sort":
+		spec, _ := stage.Value.(bson.D)
+		sortDocs(docs, spec)
+		return docs, nil
+	case "$skip":
+		n, _ := toFloat(stage.Value)
+		if int(n) >= len(docs) {
+			return nil, nil
+		}
+		return docs[int(n):], nil
+	case "$limit":
+		n, _ := toFloat(stage.Value)
+		if int(n) < len(docs) {
+			return docs[:int(n)], nil
+		}
+		return docs, nil
+	case "$count":
+		name, _ := stage.Value.(string)
+		if len(docs) == 0 {
+			return nil, nil
+		}
+		return []bson.D{{{Key: name, Value: int32(len(docs))}}}, nil
+	case "$project":
+		spec, _ := stage.Value.(bson.D)
+		return projectStage(docs, spec)
+	case "$addFields", "$set":
+		spec, _ := stage.Value.(bson.D)
+		out := make([]bson.D, 0, len(docs))
+		for _, doc := range docs {
+			changed := doc
+			for _, f := range spec {
+				value, err := eval(doc, f.Value)
+				if err != nil {
+					return nil, err
+				}
+				if changed, err = setPath(changed, strings.Split(f.Key, "."), value); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, changed)
+		}
+		return out, nil
+	case "$unset":
+		var fields []string
+		switch t := stage.Value.(type) {
+		case string:
+			fields = []string{t}
+		case bson.A:
+			for _, f := range t {
+				s, _ := f.(string)
+				fields = append(fields, s)
+			}
+		}
+		for i := range docs {
+			for _, f := range fields {
+				docs[i] = unsetPath(docs[i], strings.Split(f, "."))
+			}
+		}
+		return docs, nil
+	case "$unwind":
+		path, _ := stage.Value.(string)
+		if d, ok := stage.Value.(bson.D); ok {
+			p, _ := get(d, "path")
+			path, _ = p.(string)
+		}
+		path = strings.TrimPrefix(path, "$")
+		var out []bson.D
+		for _, doc := range docs {
+			values := lookup(doc, strings.Split(path, "."))
+			if len(values) == 0 {
+				continue
+			}
+			arr, ok := values[0].(bson.A)
+			if !ok {
+				out = append(out, doc)
+				continue
+			}
+			for _, elem := range arr {
+				unwound, err := setPath(clone(doc), strings.Split(path, "."), elem)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, unwound)
+			}
+		}
+		return out, nil
+	case "$group":
+		spec, _ := stage.Value.(bson.D)
+		return group(docs, spec)
+	case "$facet":
+		spec, _ := stage.Value.(bson.D)
+		result := bson.D{}
+		for _, f := range spec {
+			pipeline, _ := f.Value.(bson.A)
+			copies := make([]bson.D, 0, len(docs))
+			for _, doc := range docs {
+				copies = append(copies, clone(doc))
+			}
+			out, err := runPipeline(copies, pipeline)
+			if err != nil {
+				return nil, err
+			}
+			arr := bson.A{}
+			for _, d := range out {
+				arr = append(arr, d)
+			}
+			result = append(result, bson.E{Key: f.Key, Value: arr})
+		}
+		return []bson.D{result}, nil
+	}
+	return nil, fmt.Errorf("unsupported aggregation stage %s", stage.Key)
+}
+
+// projectStage applies $project, which unlike find projections can compute
+// fields from expressions
+func projectStage(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	computed := false
+	for _, e := range spec {
+		switch e.Value.(type) {
+		case string, bson.D, bson.A:
+			computed = true
+		}
+	}
+	if !computed {
+		out := make([]bson.D, 0, len(docs))
+		for _, doc := range docs {
+			out = append(out, project(doc, spec))
+		}
+		return out, nil
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		projected := bson.D{}
+		if v, ok := get(spec, "_id"); !ok || truthy(v) {
+			if id, ok := get(doc, "_id"); ok {
+				projected = append(projected, bson.E{Key: "_id", Value: id})
+			}
+		}
+		for _, e := range spec {
+			if e.Key == "_id" {
+				continue
+			}
+			var value interface{}
+			switch e.Value.(type) {
+			case string, bson.D, bson.A:
+				v, err := eval(doc, e.Value)
+				if err != nil {
+					return nil, err
+				}
+				value = v
+			default:
+				if !truthy(e.Value) {
+					continue
+				}
+				values := lookup(doc, strings.Split(e.Key, "."))
+				if len(values) == 0 {
+					continue
+				}
+				value = values[0]
+			}
+			var err error
+			if projected, err = setPath(projected, strings.Split(e.Key, "."), value); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, projected)
+	}
+	return out, nil
+}
+
+// group applies $group with the usual accumulators
+func group(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpr, _ := get(spec, "_id")
+	type bucket struct {
+		id   interface{}
+		docs []bson.D
+	}
+	var buckets []*bucket
+	for _, doc := range docs {
+		id, err := eval(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		var b *bucket
+		for _, existing := range buckets {
+			if compare(existing.id, id) == 0 {
+				b = existing
+				break
+			}
+		}
+		if b == nil {
+			b = &bucket{id: id}
+			buckets = append(buckets, b)
+		}
+		b.docs = append(b.docs, doc)
+	}
+
+	out := make([]bson.D, 0, len(buckets))
+	for _, b := range buckets {
+		result := bson.D{{Key: "_id", Value: b.id}}
+		for _, f := range spec {
+			if f.Key == "_id" {
+				continue
+			}
+			acc, ok := f.Value.(bson.D)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("%s must be an accumulator", f.Key)
+			}
+			value, err := accumulate(b.docs, acc[0])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, bson.E{Key: f.Key, Value: value})
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+func accumulate(docs []bson.D, acc bson.E) (interface{}, error) {
+	values := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		v, err := eval(doc, acc.Value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	switch acc.Key {
+	case "$sum", "$avg":
+		var total float64
+		integral, n := true, 0
+		for _, v := range values {
+			f, ok := toFloat(v)
+			if !ok {
+				continue
+			}
+			total += f
+			n++
+			if isFloat(v) {
+				integral = false
+			}
+		}
+		if acc.Key == "$avg" {
+			if n == 0 {
+				return nil, nil
+			}
+			return total / float64(n), nil
+		}
+		if integral {
+			if total > math.MaxInt32 || total < math.MinInt32 {
+				return int64(total), nil
+			}
+			return int32(total), nil
+		}
+		return total, nil
+	case "$min", "$max":
+		var best interface{}
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if best == nil || (acc.Key == "$min" && compare(v, best) < 0) || (acc.Key == "$max" && compare(v, best) > 0) {
+				best = v
+			}
+		}
+		return best, nil
+	case "$first":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0], nil
+	case "$last":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[len(values)-1], nil
+	case "$push":
+		return bson.A(values), nil
+	case "$addToSet":
+		set := bson.A{}
+		for _, v := range values {
+			if !equalsAny([]interface{}{set}, v) {
+				set = append(set, v)
+			}
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("unsupported accumulator %s", acc.Key)
+}
+
+// eval evaluates an aggregation expression against a document
+func eval(doc bson.D, expr interface{}) (interface{}, error) {
+	switch t := expr.(type) {
+	case string:
+		if strings.HasPrefix(t, "$") && !strings.HasPrefix(t, "$$") {
+			values := lookup(doc, strings.Split(t[1:], "."))
+			if len(values) == 0 {
+				return nil, nil
+			}
+			if len(values) > 1 {
+				return bson.A(values), nil
+			}
+			return values[0], nil
+		}
+		return t, nil
+	case bson.A:
+		out := make(bson.A, 0, len(t))
+		for _, e := range t {
+			v, err := eval(doc, e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case bson.D:
+		if len(t) == 1 && strings.HasPrefix(t[0].Key, "$") {
+			return evalOperator(doc, t[0])
+		}
+		out := bson.D{}
+		for _, e := range t {
+			v, err := eval(doc, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, bson.E{Key: e.Key, Value: v})
+		}
+		return out, nil
+	}
+	return expr, nil
+}
+
+// args evaluates the arguments of an operator, given as an array or alone
+func args(doc bson.D, v interface{}) ([]interface{}, error) {
+	list, ok := v.(bson.A)
+	if !ok {
+		list = bson.A{v}
+	}
+	out := make([]interface{}, 0, len(list))
+	for _, e := range list {
+		value, err := eval(doc, e)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, nil
+}
+
+func evalOperator(doc bson.D, op bson.E) (interface{}, error) {
+	if op.Key == "$literal" {
+		return op.Value, nil
+	}
+	if op.Key == "$cond" {
+		var ifExpr, thenExpr, elseExpr interface{}
+		switch t := op.Value.(type) {
+		case bson.A:
+			if len(t) != 3 {
+				return nil, fmt.Errorf("$cond needs three arguments")
+			}
+			ifExpr, thenExpr, elseExpr = t[0], t[1], t[2]
+		case bson.D:
+			ifExpr, _ = get(t, "if")
+			thenExpr, _ = get(t, "then")
+			elseExpr, _ = get(t, "else")
+		}
+		cond, err := eval(doc, ifExpr)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return eval(doc, thenExpr)
+		}
+		return eval(doc, elseExpr)
+	}
+	if op.Key == "$dateToString" {
+		spec, _ := op.Value.(bson.D)
+		format, _ := get(spec, "format")
+		dateExpr, _ := get(spec, "date")
+		date, err := eval(doc, dateExpr)
+		if err != nil || date == nil {
+			return nil, err
+		}
+		layout, _ := format.(string)
+		if layout == "" {
+			layout = "%Y-%m-%dT%H:%M:%S.%LZ"
+		}
+		return formatDate(time.UnixMilli(millis(date)).UTC(), layout), nil
+	}
+
+	a, err := args(doc, op.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Key {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if len(a) != 2 {
+			return nil, fmt.Errorf("%s needs two arguments", op.Key)
+		}
+		c := compare(a[0], a[1])
+		switch op.Key {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		}
+		return c <= 0, nil
+	case "$and":
+		for _, v := range a {
+			if !truthy(v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, v := range a {
+			if truthy(v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		return len(a) == 1 && !truthy(a[0]), nil
+	case "$ifNull":
+		for _, v := range a {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "$size":
+		if len(a) == 1 {
+			if arr, ok := a[0].(bson.A); ok {
+				return int32(len(arr)), nil
+			}
+		}
+		return nil, fmt.Errorf("$size needs an array")
+	case "$sum", "$add", "$subtract", "$multiply", "$divide":
+		return arithmetic(op.Key, a)
+	case "$floor":
+		if len(a) == 1 {
+			if f, ok := toFloat(a[0]); ok {
+				if isFloat(a[0]) {
+					return math.Floor(f), nil
+				}
+				return a[0], nil
+			}
+		}
+		return nil, nil
+	case "$toString":
+		if len(a) == 1 {
+			switch v := a[0].(type) {
+			case string:
+				return v, nil
+			case primitive.ObjectID:
+				return v.Hex(), nil
+			case nil:
+				return nil, nil
+			}
+			return fmt.Sprint(a[0]), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported expression operator %s", op.Key)
+}
+
+func arithmetic(op string, a []interface{}) (interface{}, error) {
+	if op == "$subtract" && len(a) == 2 {
+		if _, ok := a[0].(primitive.DateTime); ok {
+			if _, ok := a[1].(primitive.DateTime); ok {
+				return millis(a[0]) - millis(a[1]), nil
+			}
+			n, _ := toFloat(a[1])
+			return primitive.DateTime(millis(a[0]) - int64(n)), nil
+		}
+	}
+	var result float64
+	integral := true
+	for i, v := range a {
+		if arr, ok := v.(bson.A); ok && op == "$sum" {
+			sum, err := arithmetic("$sum", arr)
+			if err != nil {
+				return nil, err
+			}
+			v = sum
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			if op == "$sum" {
+				continue
+			}
+			return nil, nil
+		}
+		if isFloat(v) {
+			integral = false
+		}
+		switch {
+		case i == 0:
+			result = f
+		case op == "$sum" || op == "$add":
+			result += f
+		case op == "$subtract":
+			result -= f
+		case op == "$multiply":
+			result *= f
+		case op == "$divide":
+			if f == 0 {
+				return nil, fmt.Errorf("can't $divide by zero")
+			}
+			result /= f
+			integral = false
+		}
+	}
+	if integral {
+		return int64(result), nil
+	}
+	return result, nil
+}
+
+// formatDate renders the $dateToString specifiers the repository uses
+func formatDate(t time.Time, format string) string {
+	replacer := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", int(t.Month())),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%M", fmt.Sprintf("%02d", t.Minute()),
+		"%S", fmt.Sprintf("%02d", t.Second()),
+		"%L", fmt.Sprintf("%03d", t.Nanosecond()/1e6),
+		"%G", fmt.Sprintf("%04d", isoYear(t)),
+		"%V", fmt.Sprintf("%02d", isoWeek(t)),
+		"%%", "%",
+	)
+	return replacer.Replace(format)
+}
+
+func isoYear(t time.Time) int {
+	y, _ := t.ISOWeek()
+	return y
+}
+
+func isoWeek(t time.Time) int {
+	_, w := t.ISOWeek()
+	return w
+}
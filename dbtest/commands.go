@@ -0,0 +1,602 @@
+package dbtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// collection is one namespace with its documents and indexes
+type collection struct {
+	name    string
+	ns      string
+	docs    []bson.D
+	indexes []index
+}
+
+// index is what the server keeps of a created index: unique ones are enforced
+type index struct {
+	Name    string
+	Keys    bson.D
+	Unique  bool
+	Sparse  bool
+	Partial bson.D
+	Spec    bson.D
+}
+
+// commandError is a failed command, answered with ok 0
+type commandError struct {
+	code    int32
+	name    string
+	message string
+}
+
+func (e *commandError) Error() string { return e.message }
+
+func fail(format string, args ...interface{}) error {
+	return &commandError{code: 2, name: "BadValue", message: fmt.Sprintf(format, args...)}
+}
+
+// duplicateKey is the error of a write that breaks a unique index
+func duplicateKey(c *collection, idx index, doc bson.D) error {
+	return &commandError{code: 11000, name: "DuplicateKey",
+		message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s dup key: %v", c.ns, idx.Name, keyOf(idx, doc))}
+}
+
+// run answers one command
+func (srv *Server) run(cmd bson.D) bson.D {
+	if len(cmd) == 0 {
+		return errorReply(fail("empty command"))
+	}
+	name := cmd[0].Key
+	db, _ := get(cmd, "$db")
+	dbName, _ := db.(string)
+	collName, _ := cmd[0].Value.(string)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if collName != "" && srv.failing[collName] {
+		return errorReply(&commandError{code: 8, name: "UnknownError", message: "dbtest: " + collName + " is failing"})
+	}
+
+	var reply bson.D
+	var err error
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		// A standalone server without topologyVersion, so the driver polls
+		// instead of streaming heartbeats
+		reply = bson.D{
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "ismaster", Value: true},
+			{Key: "minWireVersion", Value: int32(0)},
+			{Key: "maxWireVersion", Value: int32(21)},
+			{Key: "maxBsonObjectSize", Value: int32(16 * 1024 * 1024)},
+			{Key: "maxMessageSizeBytes", Value: int32(maxMessageSize)},
+			{Key: "maxWriteBatchSize", Value: int32(100000)},
+			{Key: "logicalSessionTimeoutMinutes", Value: int32(30)},
+			{Key: "connectionId", Value: int32(1)},
+		}
+	case "ping", "endsessions", "killcursors", "create", "collmod", "committransaction", "aborttransaction", "dropindexes":
+	case "buildinfo":
+		reply = bson.D{{Key: "version", Value: "7.0.0"}}
+	case "drop":
+		delete(srv.collections, dbName+"."+collName)
+	case "dropdatabase":
+		for ns := range srv.collections {
+			if strings.HasPrefix(ns, dbName+".") {
+				delete(srv.collections, ns)
+			}
+		}
+	case "listcollections":
+		reply = srv.listCollections(dbName)
+	case "createindexes":
+		err = srv.createIndexes(srv.collection(dbName, collName), cmd)
+	case "listindexes":
+		reply = srv.listIndexes(srv.collection(dbName, collName))
+	case "insert":
+		reply, err = srv.insert(srv.collection(dbName, collName), cmd)
+	case "find":
+		reply, err = srv.find(srv.collection(dbName, collName), cmd)
+	case "update":
+		reply, err = srv.update(srv.collection(dbName, collName), cmd)
+	case "delete":
+		reply, err = srv.remove(srv.collection(dbName, collName), cmd)
+	case "findandmodify":
+		reply, err = srv.findAndModify(srv.collection(dbName, collName), cmd)
+	case "count":
+		reply, err = srv.count(srv.collection(dbName, collName), cmd)
+	case "distinct":
+		reply, err = srv.distinct(srv.collection(dbName, collName), cmd)
+	case "aggregate":
+		if collName == "" {
+			err = fail("database aggregations are not served")
+			break
+		}
+		reply, err = srv.aggregate(srv.collection(dbName, collName), cmd)
+	default:
+		err = &commandError{code: 59, name: "CommandNotFound", message: "dbtest: no such command: " + name}
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	return append(reply, bson.E{Key: "ok", Value: float64(1)})
+}
+
+func errorReply(err error) bson.D {
+	ce, ok := err.(*commandError)
+	if !ok {
+		ce = &commandError{code: 2, name: "BadValue", message: err.Error()}
+	}
+	return bson.D{
+		{Key: "ok", Value: float64(0)},
+		{Key: "errmsg", Value: ce.message},
+		{Key: "code", Value: ce.code},
+		{Key: "codeName", Value: ce.name},
+	}
+}
+
+func cursorReply(ns string, docs []bson.D) bson.D {
+	batch := make(bson.A, 0, len(docs))
+	for _, d := range docs {
+		batch = append(batch, d)
+	}
+	return bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "firstBatch", Value: batch},
+		{Key: "id", Value: int64(0)},
+		{Key: "ns", Value: ns},
+	}}}
+}
+
+func docParam(cmd bson.D, key string) bson.D {
+	v, _ := get(cmd, key)
+	d, _ := v.(bson.D)
+	return d
+}
+
+func intParam(cmd bson.D, key string) int {
+	v, _ := get(cmd, key)
+	n, _ := toFloat(v)
+	return int(n)
+}
+
+func (srv *Server) listCollections(db string) bson.D {
+	var docs []bson.D
+	for _, c := range srv.collections {
+		if strings.HasPrefix(c.ns, db+".") {
+			docs = append(docs, bson.D{{Key: "name", Value: c.name}, {Key: "type", Value: "collection"}})
+		}
+	}
+	return cursorReply(db+".$cmd.listCollections", docs)
+}
+
+func (srv *Server) createIndexes(c *collection, cmd bson.D) error {
+	v, _ := get(cmd, "indexes")
+	specs, _ := v.(bson.A)
+	for _, s := range specs {
+		spec, ok := s.(bson.D)
+		if !ok {
+			return fail("index specifications must be documents")
+		}
+		idx := index{Keys: docParam(spec, "key"), Partial: docParam(spec, "partialFilterExpression"), Spec: spec}
+		if n, ok := get(spec, "name"); ok {
+			idx.Name, _ = n.(string)
+		}
+		if u, ok := get(spec, "unique"); ok {
+			idx.Unique = truthy(u)
+		}
+		if sp, ok := get(spec, "sparse"); ok {
+			idx.Sparse = truthy(sp)
+		}
+		if idx.Name == "" {
+			var parts []string
+			for _, k := range idx.Keys {
+				parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+			}
+			idx.Name = strings.Join(parts, "_")
+		}
+		replaced := false
+		for i, existing := range c.indexes {
+			if existing.Name == idx.Name {
+				c.indexes[i], replaced = idx, true
+			}
+		}
+		if !replaced {
+			c.indexes = append(c.indexes, idx)
+		}
+		if idx.Unique {
+			for i, doc := range c.docs {
+				if err := c.checkUnique(doc, i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (srv *Server) listIndexes(c *collection) bson.D {
+	docs := []bson.D{{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}}}
+	for _, idx := range c.indexes {
+		docs = append(docs, idx.Spec)
+	}
+	return cursorReply(c.ns, docs)
+}
+
+// keyOf returns the values a document has for the keys of an index, null for
+// missing fields as MongoDB indexes them
+func keyOf(idx index, doc bson.D) bson.A {
+	key := bson.A{}
+	for _, k := range idx.Keys {
+		values := lookup(doc, strings.Split(k.Key, "."))
+		if len(values) == 0 {
+			key = append(key, nil)
+			continue
+		}
+		key = append(key, values[0])
+	}
+	return key
+}
+
+// indexed reports whether an index holds a document
+func indexed(idx index, doc bson.D) bool {
+	if len(idx.Partial) > 0 {
+		m, err := matches(doc, idx.Partial)
+		if err != nil || !m {
+			return false
+		}
+	}
+	if idx.Sparse {
+		for _, k := range idx.Keys {
+			if len(lookup(doc, strings.Split(k.Key, "."))) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// checkUnique fails when doc, stored at position self (or -1 when new),
+// collides with another document on _id or a unique index
+func (c *collection) checkUnique(doc bson.D, self int) error {
+	id, _ := get(doc, "_id")
+	for i, other := range c.docs {
+		if i == self {
+			continue
+		}
+		if otherID, _ := get(other, "_id"); compare(id, otherID) == 0 {
+			return duplicateKey(c, index{Name: "_id_", Keys: bson.D{{Key: "_id", Value: 1}}}, doc)
+		}
+		for _, idx := range c.indexes {
+			if !idx.Unique || !indexed(idx, doc) || !indexed(idx, other) {
+				continue
+			}
+			if compare(keyOf(idx, doc), keyOf(idx, other)) == 0 {
+				return duplicateKey(c, idx, doc)
+			}
+		}
+	}
+	return nil
+}
+
+// writeError turns a failed write of a batch into a writeErrors entry
+func writeError(i int, err error) bson.D {
+	ce, ok := err.(*commandError)
+	if !ok {
+		ce = &commandError{code: 2, message: err.Error()}
+	}
+	return bson.D{{Key: "index", Value: int32(i)}, {Key: "code", Value: ce.code}, {Key: "errmsg", Value: ce.message}}
+}
+
+func ordered(cmd bson.D) bool {
+	v, ok := get(cmd, "ordered")
+	return !ok || truthy(v)
+}
+
+func (srv *Server) insert(c *collection, cmd bson.D) (bson.D, error) {
+	v, _ := get(cmd, "documents")
+	docs, _ := v.(bson.A)
+	n := 0
+	var errs bson.A
+	for i, d := range docs {
+		doc, ok := d.(bson.D)
+		if !ok {
+			return nil, fail("documents must be documents")
+		}
+		doc = withID(clone(doc))
+		if err := c.checkUnique(doc, -1); err != nil {
+			errs = append(errs, writeError(i, err))
+			if ordered(cmd) {
+				break
+			}
+			continue
+		}
+		c.docs = append(c.docs, doc)
+		n++
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}}
+	if len(errs) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: errs})
+	}
+	return reply, nil
+}
+
+// query returns the positions of the documents matching a filter, sorted
+func (c *collection) query(filter, sortSpec bson.D) ([]int, error) {
+	var positions []int
+	for i, doc := range c.docs {
+		m, err := matches(doc, filter)
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		if m {
+			positions = append(positions, i)
+		}
+	}
+	if len(sortSpec) > 0 {
+		less := sortLess(sortSpec)
+		sort.SliceStable(positions, func(i, j int) bool { return less(c.docs[positions[i]], c.docs[positions[j]]) })
+	}
+	return positions, nil
+}
+
+func (srv *Server) find(c *collection, cmd bson.D) (bson.D, error) {
+	positions, err := c.query(docParam(cmd, "filter"), docParam(cmd, "sort"))
+	if err != nil {
+		return nil, err
+	}
+	skip, limit := intParam(cmd, "skip"), intParam(cmd, "limit")
+	if limit < 0 {
+		limit = -limit
+	}
+	projection := docParam(cmd, "projection")
+	var docs []bson.D
+	for i, p := range positions {
+		if i < skip {
+			continue
+		}
+		if limit > 0 && len(docs) >= limit {
+			break
+		}
+		docs = append(docs, project(clone(c.docs[p]), projection))
+	}
+	return cursorReply(c.ns, docs), nil
+}
+
+// updateOne changes or upserts documents for one update statement and
+// returns how many matched and changed, and the _id of an upserted document
+func (c *collection) updateOne(filter bson.D, update interface{}, multi, upsert bool, sortSpec bson.D) (matched, modified int, upserted interface{}, err error) {
+	positions, err := c.query(filter, sortSpec)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if !multi && len(positions) > 1 {
+		positions = positions[:1]
+	}
+	if u, ok := update.(bson.D); ok && !isUpdateDoc(u) && multi {
+		return 0, 0, nil, fail("multi update only works with $ operators")
+	}
+
+	for _, p := range positions {
+		changed, err := applyUpdate(c.docs[p], update, false)
+		if err != nil {
+			return matched, modified, nil, fail("%v", err)
+		}
+		if id, _ := get(changed, "_id"); compare(id, mustGet(c.docs[p], "_id")) != 0 {
+			return matched, modified, nil, &commandError{code: 66, name: "ImmutableField", message: "the _id field cannot be changed"}
+		}
+		if err := c.checkUnique(changed, p); err != nil {
+			return matched, modified, nil, err
+		}
+		matched++
+		if compare(changed, c.docs[p]) != 0 {
+			modified++
+			c.docs[p] = changed
+		}
+	}
+	if matched > 0 || !upsert {
+		return matched, modified, nil, nil
+	}
+
+	base, err := upsertBase(filter)
+	if err != nil {
+		return 0, 0, nil, fail("%v", err)
+	}
+	doc, err := applyUpdate(base, update, true)
+	if err != nil {
+		return 0, 0, nil, fail("%v", err)
+	}
+	if u, ok := update.(bson.D); ok && !isUpdateDoc(u) {
+		if id, ok := get(base, "_id"); ok {
+			if _, has := get(doc, "_id"); !has {
+				doc = append(doc, bson.E{Key: "_id", Value: id})
+			}
+		}
+	}
+	doc = withID(doc)
+	if err := c.checkUnique(doc, -1); err != nil {
+		return 0, 0, nil, err
+	}
+	c.docs = append(c.docs, doc)
+	return 0, 0, mustGet(doc, "_id"), nil
+}
+
+func mustGet(doc bson.D, key string) interface{} {
+	v, _ := get(doc, key)
+	return v
+}
+
+func (srv *Server) update(c *collection, cmd bson.D) (bson.D, error) {
+	v, _ := get(cmd, "updates")
+	statements, _ := v.(bson.A)
+	var n, nModified int
+	var upserted, errs bson.A
+	for i, s := range statements {
+		st, ok := s.(bson.D)
+		if !ok {
+			return nil, fail("updates must be documents")
+		}
+		u, _ := get(st, "u")
+		multi, _ := get(st, "multi")
+		upsert, _ := get(st, "upsert")
+		matched, modified, id, err := c.updateOne(docParam(st, "q"), u, truthy(multi), truthy(upsert), nil)
+		if err != nil {
+			if ce, ok := err.(*commandError); ok && ce.code == 2 {
+				return nil, err
+			}
+			errs = append(errs, writeError(i, err))
+			if ordered(cmd) {
+				break
+			}
+			continue
+		}
+		n += matched
+		nModified += modified
+		if id != nil {
+			n++
+			upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: id}})
+		}
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}, {Key: "nModified", Value: int32(nModified)}}
+	if len(upserted) > 0 {
+		reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+	}
+	if len(errs) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: errs})
+	}
+	return reply, nil
+}
+
+func (srv *Server) remove(c *collection, cmd bson.D) (bson.D, error) {
+	v, _ := get(cmd, "deletes")
+	statements, _ := v.(bson.A)
+	n := 0
+	for _, s := range statements {
+		st, ok := s.(bson.D)
+		if !ok {
+			return nil, fail("deletes must be documents")
+		}
+		positions, err := c.query(docParam(st, "q"), nil)
+		if err != nil {
+			return nil, err
+		}
+		if intParam(st, "limit") == 1 && len(positions) > 1 {
+			positions = positions[:1]
+		}
+		drop := make(map[int]bool, len(positions))
+		for _, p := range positions {
+			drop[p] = true
+		}
+		kept := c.docs[:0:0]
+		for i, doc := range c.docs {
+			if !drop[i] {
+				kept = append(kept, doc)
+			}
+		}
+		c.docs = kept
+		n += len(positions)
+	}
+	return bson.D{{Key: "n", Value: int32(n)}}, nil
+}
+
+func (srv *Server) findAndModify(c *collection, cmd bson.D) (bson.D, error) {
+	query, sortSpec := docParam(cmd, "query"), docParam(cmd, "sort")
+	remove, _ := get(cmd, "remove")
+	returnNew, _ := get(cmd, "new")
+	upsert, _ := get(cmd, "upsert")
+	update, _ := get(cmd, "update")
+	fields := docParam(cmd, "fields")
+
+	positions, err := c.query(query, sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	lastError := bson.D{}
+
+	switch {
+	case truthy(remove):
+		if len(positions) > 0 {
+			p := positions[0]
+			value = project(clone(c.docs[p]), fields)
+			c.docs = append(c.docs[:p:p], c.docs[p+1:]...)
+		}
+		lastError = append(lastError, bson.E{Key: "n", Value: int32(len(positions[:min(1, len(positions))]))})
+	default:
+		var before bson.D
+		if len(positions) > 0 {
+			before = clone(c.docs[positions[0]])
+		}
+		matched, _, id, err := c.updateOne(query, update, false, truthy(upsert), sortSpec)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case id != nil:
+			lastError = append(lastError, bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "updatedExisting", Value: false}, bson.E{Key: "upserted", Value: id})
+			if truthy(returnNew) {
+				for _, doc := range c.docs {
+					if compare(mustGet(doc, "_id"), id) == 0 {
+						value = project(clone(doc), fields)
+					}
+				}
+			}
+		case matched > 0:
+			lastError = append(lastError, bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "updatedExisting", Value: true})
+			if truthy(returnNew) {
+				for _, doc := range c.docs {
+					if compare(mustGet(doc, "_id"), mustGet(before, "_id")) == 0 {
+						value = project(clone(doc), fields)
+					}
+				}
+			} else {
+				value = project(before, fields)
+			}
+		default:
+			lastError = append(lastError, bson.E{Key: "n", Value: int32(0)}, bson.E{Key: "updatedExisting", Value: false})
+		}
+	}
+	return bson.D{{Key: "lastErrorObject", Value: lastError}, {Key: "value", Value: value}}, nil
+}
+
+func (srv *Server) count(c *collection, cmd bson.D) (bson.D, error) {
+	positions, err := c.query(docParam(cmd, "query"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{Key: "n", Value: int32(len(positions))}}, nil
+}
+
+func (srv *Server) distinct(c *collection, cmd bson.D) (bson.D, error) {
+	key, _ := get(cmd, "key")
+	path, _ := key.(string)
+	positions, err := c.query(docParam(cmd, "query"), nil)
+	if err != nil {
+		return nil, err
+	}
+	values := bson.A{}
+	for _, p := range positions {
+		for _, v := range expandArrays(lookup(c.docs[p], strings.Split(path, "."))) {
+			if !equalsAny([]interface{}{values}, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return bson.D{{Key: "values", Value: values}}, nil
+}
+
+// expandArrays replaces array values by their elements
+func expandArrays(values []interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range values {
+		if a, ok := v.(bson.A); ok {
+			out = append(out, a...)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
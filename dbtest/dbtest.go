@@ -0,0 +1,182 @@
+// Package dbtest serves an in-memory MongoDB to tests, so handlers, middleware
+// and the packages they call can be exercised without a database server. It
+// listens on a loopback port and speaks the wire protocol, so tests connect
+// with the regular driver and a mongodb:// URI. It answers the commands the
+// driver sends for the CRUD methods, indexes, distinct and counts, and the
+// aggregation stages simple pipelines use, with unique indexes enforced so
+// conflicts surface as duplicate key errors. Anything else is answered with
+// an error, as is every command on a collection marked with Fail, for
+// database error cases.
+//
+//	srv := dbtest.Start(t)
+//	srv.Fail("users")
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+)
+
+// Server holds the collections of the in-memory database
+type Server struct {
+	mu          sync.Mutex
+	collections map[string]*collection
+	failing     map[string]bool
+
+	listener net.Listener
+	conns    sync.WaitGroup
+	open     map[net.Conn]bool
+}
+
+// New starts an empty server on a loopback port, panicking like
+// httptest.NewServer when it cannot listen. Most tests want Start instead;
+// others must Close it.
+func New() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("dbtest: failed to listen: %v", err))
+	}
+	srv := &Server{
+		collections: make(map[string]*collection),
+		failing:     make(map[string]bool),
+		listener:    l,
+		open:        make(map[net.Conn]bool),
+	}
+	go srv.serve()
+	return srv
+}
+
+// URI is the connection string of the server
+func (srv *Server) URI() string {
+	return "mongodb://" + srv.listener.Addr().String() + "/?directConnection=true"
+}
+
+// Close stops the server and drops its connections
+func (srv *Server) Close() error {
+	err := srv.listener.Close()
+	srv.mu.Lock()
+	for conn := range srv.open {
+		conn.Close()
+	}
+	srv.mu.Unlock()
+	srv.conns.Wait()
+	return err
+}
+
+// Start serves database.DB and the default region from a new server for the
+// duration of a test, restoring the previous databases when it ends
+func Start(t testing.TB) *Server {
+	t.Helper()
+	srv := New()
+	db, err := srv.Database("golang-backend")
+	if err != nil {
+		srv.Close()
+		t.Fatalf("dbtest: %v", err)
+	}
+
+	prevDB, prevRegions, prevDefault := database.DB, database.Regions, database.DefaultRegion
+	database.DB = db
+	database.DefaultRegion = "default"
+	database.Regions = map[string]*mongo.Database{database.DefaultRegion: db}
+	t.Cleanup(func() {
+		database.DB, database.Regions, database.DefaultRegion = prevDB, prevRegions, prevDefault
+		db.Client().Disconnect(context.Background())
+		srv.Close()
+	})
+	return srv
+}
+
+// Database returns a client database served by srv
+func (srv *Server) Database(name string) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(srv.URI()).SetRetryReads(false).SetRetryWrites(false)
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return client.Database(name), nil
+}
+
+// Fail makes every command on the named collections fail until Heal is called
+func (srv *Server) Fail(names ...string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, name := range names {
+		srv.failing[name] = true
+	}
+}
+
+// Heal undoes Fail
+func (srv *Server) Heal() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.failing = make(map[string]bool)
+}
+
+// Count returns how many documents of a collection match filter
+func (srv *Server) Count(name string, filter interface{}) int {
+	docs, err := srv.Find(name, filter)
+	if err != nil {
+		panic(err)
+	}
+	return len(docs)
+}
+
+// Find returns copies of the documents of a collection that match filter,
+// in insertion order
+func (srv *Server) Find(name string, filter interface{}) ([]bson.D, error) {
+	f, err := toD(filter)
+	if err != nil {
+		return nil, err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var found []bson.D
+	for _, c := range srv.collections {
+		if c.name != name {
+			continue
+		}
+		for _, doc := range c.docs {
+			ok, err := matches(doc, f)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				found = append(found, clone(doc))
+			}
+		}
+	}
+	return found, nil
+}
+
+// collection returns the named collection of a database, created on first use
+func (srv *Server) collection(db, name string) *collection {
+	ns := db + "." + name
+	c, ok := srv.collections[ns]
+	if !ok {
+		c = &collection{name: name, ns: ns}
+		srv.collections[ns] = c
+	}
+	return c
+}
+
+// toD converts a filter built by a test into a document
+func toD(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	err = bson.Unmarshal(raw, &d)
+	return d, err
+}
@@ -0,0 +1,103 @@
+package dbtest_test
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/dbtest"
+)
+
+func TestCRUD(t *testing.T) {
+	srv := dbtest.Start(t)
+	ctx := context.Background()
+	users := database.DB.Collection("users")
+
+	_, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"email_hash": 1}, Options: options.Index().SetUnique(true)})
+	if err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	for i, hash := range []string{"a", "b", "c"} {
+		if _, err := users.InsertOne(ctx, bson.M{"email_hash": hash, "n": i, "tags": bson.A{"x"}}); err != nil {
+			t.Fatalf("insert %s: %v", hash, err)
+		}
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email_hash": "a"}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("duplicate insert: got %v, want a duplicate key error", err)
+	}
+
+	var got struct {
+		EmailHash string `bson:"email_hash"`
+		N         int    `bson:"n"`
+	}
+	if err := users.FindOne(ctx, bson.M{"n": bson.M{"$gte": 1}}, options.FindOne().SetSort(bson.M{"n": -1})).Decode(&got); err != nil || got.EmailHash != "c" {
+		t.Fatalf("find one sorted: got %+v, %v", got, err)
+	}
+	if err := users.FindOne(ctx, bson.M{"email_hash": "zz"}).Err(); err != mongo.ErrNoDocuments {
+		t.Fatalf("find missing: got %v", err)
+	}
+
+	res, err := users.UpdateOne(ctx, bson.M{"email_hash": "b"}, bson.M{"$set": bson.M{"role": "admin"}, "$inc": bson.M{"n": 10}, "$addToSet": bson.M{"tags": "y"}})
+	if err != nil || res.ModifiedCount != 1 {
+		t.Fatalf("update: %+v %v", res, err)
+	}
+	res, err = users.UpdateOne(ctx, bson.M{"email_hash": "d"}, bson.M{"$setOnInsert": bson.M{"n": 4}}, options.Update().SetUpsert(true))
+	if err != nil || res.UpsertedID == nil {
+		t.Fatalf("upsert: %+v %v", res, err)
+	}
+	if n := srv.Count("users", bson.M{"email_hash": "d", "n": 4}); n != 1 {
+		t.Fatalf("upserted document not found: %d", n)
+	}
+
+	count, err := users.CountDocuments(ctx, bson.M{"tags": "x"})
+	if err != nil || count != 3 {
+		t.Fatalf("count: %d %v", count, err)
+	}
+	tags, err := users.Distinct(ctx, "tags", bson.M{})
+	if err != nil || len(tags) != 2 {
+		t.Fatalf("distinct: %v %v", tags, err)
+	}
+
+	var projected bson.M
+	err = users.FindOne(ctx, bson.M{"email_hash": "b"}, options.FindOne().SetProjection(bson.M{"n": 0, "tags": bson.M{"$slice": -1}})).Decode(&projected)
+	if sliced, _ := projected["tags"].(bson.A); err != nil || projected["n"] != nil || projected["email_hash"] != "b" || len(sliced) != 1 || sliced[0] != "y" {
+		t.Fatalf("find one projected: %v %v", projected, err)
+	}
+
+	cursor, err := users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"n": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$n"}}}},
+	})
+	if err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	var sums []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil || len(sums) != 1 || sums[0].Total != 0+11+2+4 {
+		t.Fatalf("aggregate result: %+v %v", sums, err)
+	}
+
+	var after bson.M
+	err = users.FindOneAndUpdate(ctx, bson.M{"email_hash": "a"}, bson.M{"$unset": bson.M{"tags": ""}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err != nil || after["tags"] != nil {
+		t.Fatalf("find one and update: %v %v", after, err)
+	}
+
+	deleted, err := users.DeleteMany(ctx, bson.M{"email_hash": bson.M{"$in": bson.A{"a", "b"}}})
+	if err != nil || deleted.DeletedCount != 2 {
+		t.Fatalf("delete: %+v %v", deleted, err)
+	}
+
+	srv.Fail("users")
+	if err := users.FindOne(ctx, bson.M{}).Err(); err == nil || err == mongo.ErrNoDocuments {
+		t.Fatalf("failing collection answered: %v", err)
+	}
+	srv.Heal()
+	if err := users.FindOne(ctx, bson.M{}).Err(); err != nil {
+		t.Fatalf("healed collection: %v", err)
+	}
+}
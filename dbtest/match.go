@@ -0,0 +1,632 @@
+package dbtest
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// clone returns a deep copy of a document
+func clone(doc bson.D) bson.D {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	var out bson.D
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// get returns the value of a top-level field
+func get(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// lookup resolves a dotted path. Arrays met on the way are traversed
+// element by element unless the next segment is an index, so a path can
+// resolve to several values.
+func lookup(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.D:
+		child, ok := get(t, path[0])
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < 0 || i >= len(t) {
+				return nil
+			}
+			return lookup(t[i], path[1:])
+		}
+		var out []interface{}
+		for _, elem := range t {
+			if d, ok := elem.(bson.D); ok {
+				out = append(out, lookup(d, path)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// matches reports whether a document satisfies a query filter
+func matches(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		ok, err := matchElem(doc, e)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElem(doc bson.D, e bson.E) (bool, error) {
+	switch e.Key {
+	case "$and", "$or", "$nor":
+		clauses, ok := e.Value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", e.Key)
+		}
+		for _, clause := range clauses {
+			sub, ok := clause.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("%s needs documents", e.Key)
+			}
+			m, err := matches(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case e.Key == "$and" && !m:
+				return false, nil
+			case e.Key == "$or" && m:
+				return true, nil
+			case e.Key == "$nor" && m:
+				return false, nil
+			}
+		}
+		return e.Key != "$or", nil
+	case "$comment":
+		return true, nil
+	}
+	if strings.HasPrefix(e.Key, "$") {
+		return false, fmt.Errorf("unsupported query operator %s", e.Key)
+	}
+	return matchValue(lookup(doc, strings.Split(e.Key, ".")), e.Value)
+}
+
+// isOperatorDoc reports whether a condition is made of query operators
+func isOperatorDoc(v interface{}) (bson.D, bool) {
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 {
+		return nil, false
+	}
+	for _, e := range d {
+		if !strings.HasPrefix(e.Key, "$") {
+			return nil, false
+		}
+	}
+	return d, true
+}
+
+// matchValue tests the values a path resolved to against a condition
+func matchValue(values []interface{}, cond interface{}) (bool, error) {
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		return equalsAny(values, cond), nil
+	}
+	for _, op := range ops {
+		m, err := matchOp(values, op, ops)
+		if err != nil || !m {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// expand returns the values and, for arrays, their elements, which is what
+// comparisons run against
+func expand(values []interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range values {
+		out = append(out, v)
+		if a, ok := v.(bson.A); ok {
+			out = append(out, a...)
+		}
+	}
+	return out
+}
+
+// equalsAny implements implicit equality, where null also matches a missing
+// field and a regular expression matches strings
+func equalsAny(values []interface{}, want interface{}) bool {
+	if want == nil && len(values) == 0 {
+		return true
+	}
+	if re, ok := want.(primitive.Regex); ok {
+		return regexAny(values, re)
+	}
+	for _, v := range expand(values) {
+		if compare(v, want) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOp(values []interface{}, op bson.E, all bson.D) (bool, error) {
+	switch op.Key {
+	case "$eq":
+		return equalsAny(values, op.Value), nil
+	case "$ne":
+		return !equalsAny(values, op.Value), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range expand(values) {
+			if !comparable(v, op.Value) {
+				continue
+			}
+			c := compare(v, op.Value)
+			if (op.Key == "$gt" && c > 0) || (op.Key == "$gte" && c >= 0) || (op.Key == "$lt" && c < 0) || (op.Key == "$lte" && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		list, ok := op.Value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", op.Key)
+		}
+		found := false
+		for _, want := range list {
+			if equalsAny(values, want) {
+				found = true
+				break
+			}
+		}
+		return found == (op.Key == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(op.Value), nil
+	case "$regex":
+		re, err := toRegex(op.Value, all)
+		if err != nil {
+			return false, err
+		}
+		return regexAny(values, re), nil
+	case "$options":
+		return true, nil
+	case "$not":
+		m, err := matchValue(values, op.Value)
+		return !m, err
+	case "$size":
+		n, ok := toFloat(op.Value)
+		if !ok {
+			return false, fmt.Errorf("$size needs a number")
+		}
+		for _, v := range values {
+			if a, ok := v.(bson.A); ok && float64(len(a)) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		list, ok := op.Value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		for _, want := range list {
+			if !equalsAny(values, want) {
+				return false, nil
+			}
+		}
+		return len(list) > 0, nil
+	case "$elemMatch":
+		cond, ok := op.Value.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch needs a document")
+		}
+		for _, v := range values {
+			a, ok := v.(bson.A)
+			if !ok {
+				continue
+			}
+			for _, elem := range a {
+				var m bool
+				var err error
+				if _, isOps := isOperatorDoc(cond); isOps {
+					m, err = matchValue([]interface{}{elem}, cond)
+				} else if d, ok := elem.(bson.D); ok {
+					m, err = matches(d, cond)
+				}
+				if err != nil {
+					return false, err
+				}
+				if m {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "$type":
+		for _, v := range values {
+			if typeName(v) == op.Value {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unsupported query operator %s", op.Key)
+}
+
+func toRegex(v interface{}, all bson.D) (primitive.Regex, error) {
+	switch t := v.(type) {
+	case primitive.Regex:
+		return t, nil
+	case string:
+		opts, _ := get(all, "$options")
+		s, _ := opts.(string)
+		return primitive.Regex{Pattern: t, Options: s}, nil
+	}
+	return primitive.Regex{}, fmt.Errorf("$regex needs a string")
+}
+
+func regexAny(values []interface{}, re primitive.Regex) bool {
+	pattern := re.Pattern
+	if strings.Contains(re.Options, "i") {
+		pattern = "(?i)" + pattern
+	}
+	if strings.Contains(re.Options, "m") {
+		pattern = "(?m)" + pattern
+	}
+	if strings.Contains(re.Options, "s") {
+		pattern = "(?s)" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	for _, v := range expand(values) {
+		if s, ok := v.(string); ok && compiled.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case nil:
+		return false
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case bson.D:
+		return "object"
+	case bson.A:
+		return "array"
+	case primitive.Binary:
+		return "binData"
+	case primitive.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Regex:
+		return "regex"
+	}
+	return "unknown"
+}
+
+// typeOrder is the BSON comparison order of a value's type
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 1
+	case nil, primitive.Undefined, primitive.Null:
+		return 2
+	case int32, int64, int, float64, primitive.Decimal128:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime, time.Time:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	case primitive.MaxKey:
+		return 13
+	}
+	return 14
+}
+
+// comparable reports whether range operators apply between two values, which
+// like MongoDB only compare values of the same type
+func comparable(a, b interface{}) bool {
+	return typeOrder(a) == typeOrder(b)
+}
+
+// compare orders two values as MongoDB sorts them
+func compare(a, b interface{}) int {
+	oa, ob := typeOrder(a), typeOrder(b)
+	if oa != ob {
+		return cmpInt(oa, ob)
+	}
+	switch x := a.(type) {
+	case int32, int64, int, float64:
+		fa, _ := toFloat(x)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		case math.IsNaN(fa) && !math.IsNaN(fb):
+			return -1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compare(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return cmpInt(len(x.Data), len(y.Data))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.DateTime, time.Time:
+		return cmpInt64(millis(x), millis(b))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		return primitive.CompareTimestamp(x, y)
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		return strings.Compare(x.Pattern+"/"+x.Options, y.Pattern+"/"+y.Options)
+	}
+	return 0
+}
+
+func millis(v interface{}) int64 {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return int64(t)
+	case time.Time:
+		return t.UnixMilli()
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int {
+	return cmpInt64(int64(a), int64(b))
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sortDocs orders documents by a sort specification
+func sortDocs(docs []bson.D, spec bson.D) {
+	if len(spec) == 0 {
+		return
+	}
+	less := sortLess(spec)
+	sort.SliceStable(docs, func(i, j int) bool { return less(docs[i], docs[j]) })
+}
+
+// sortLess orders two documents by a sort specification, missing fields
+// sorting as null
+func sortLess(spec bson.D) func(a, b bson.D) bool {
+	key := func(doc bson.D, path string) interface{} {
+		values := lookup(doc, strings.Split(path, "."))
+		if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+	return func(a, b bson.D) bool {
+		for _, e := range spec {
+			dir, _ := toFloat(e.Value)
+			if c := compare(key(a, e.Key), key(b, e.Key)); c != 0 {
+				return (c < 0) == (dir >= 0)
+			}
+		}
+		return false
+	}
+}
+
+// project applies an inclusion or exclusion projection, trimming the
+// array fields that $slice names
+func project(doc bson.D, spec bson.D) bson.D {
+	if len(spec) == 0 {
+		return doc
+	}
+	include := false
+	for _, e := range spec {
+		if _, ok := sliceOf(e.Value); !ok && e.Key != "_id" && truthy(e.Value) {
+			include = true
+		}
+	}
+	out := projectFields(doc, spec, include)
+	for _, e := range spec {
+		n, ok := sliceOf(e.Value)
+		if !ok {
+			continue
+		}
+		for i := range out {
+			arr, isArray := out[i].Value.(bson.A)
+			if out[i].Key != e.Key || !isArray {
+				continue
+			}
+			switch {
+			case n >= 0 && n < len(arr):
+				out[i].Value = arr[:n]
+			case n < 0 && -n < len(arr):
+				out[i].Value = arr[len(arr)+n:]
+			}
+		}
+	}
+	return out
+}
+
+// sliceOf reports the count of a {$slice: n} projection
+func sliceOf(v interface{}) (int, bool) {
+	op, ok := v.(bson.D)
+	if !ok {
+		return 0, false
+	}
+	s, ok := get(op, "$slice")
+	if !ok {
+		return 0, false
+	}
+	n, _ := toFloat(s)
+	return int(n), true
+}
+
+// projectFields keeps the fields an inclusion projection names, or drops
+// those an exclusion projection names
+func projectFields(doc bson.D, spec bson.D, include bool) bson.D {
+	keepID := true
+	if v, ok := get(spec, "_id"); ok && !truthy(v) {
+		keepID = false
+	}
+
+	if !include {
+		out := clone(doc)
+		for _, e := range spec {
+			if !truthy(e.Value) {
+				out = unsetPath(out, strings.Split(e.Key, "."))
+			}
+		}
+		return out
+	}
+
+	var out bson.D
+	if keepID {
+		if id, ok := get(doc, "_id"); ok {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+	}
+	for _, e := range spec {
+		if e.Key == "_id" || !truthy(e.Value) {
+			continue
+		}
+		path := strings.Split(e.Key, ".")
+		if values := lookup(doc, path[:1]); len(values) > 0 {
+			if len(path) == 1 {
+				out = append(out, bson.E{Key: e.Key, Value: values[0]})
+				continue
+			}
+			if sub, ok := values[0].(bson.D); ok {
+				nested := project(sub, bson.D{{Key: strings.Join(path[1:], "."), Value: 1}, {Key: "_id", Value: 0}})
+				if existing, ok := get(out, path[0]); ok {
+					if d, ok := existing.(bson.D); ok {
+						nested = append(d, nested...)
+					}
+					out = unsetPath(out, path[:1])
+				}
+				out = append(out, bson.E{Key: path[0], Value: nested})
+			}
+		}
+	}
+	return out
+}
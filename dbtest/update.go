@@ -0,0 +1,360 @@
+package dbtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+)
+
+// isUpdateDoc reports whether an update is made of update operators rather
+// than a replacement document
+func isUpdateDoc(update bson.D) bool {
+	return len(update) > 0 && strings.HasPrefix(update[0].Key, "$")
+}
+
+// applyUpdate returns doc changed by update operators or replaced by a
+// replacement document. inserting adds the fields of $setOnInsert.
+func applyUpdate(doc bson.D, update interface{}, inserting bool) (bson.D, error) {
+	u, ok := update.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("unsupported update %T, pipelines are not served", update)
+	}
+	if !isUpdateDoc(u) {
+		out := bson.D{}
+		if id, ok := get(doc, "_id"); ok {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+		for _, e := range clone(u) {
+			if e.Key != "_id" {
+				out = append(out, e)
+			}
+		}
+		return out, nil
+	}
+
+	out := clone(doc)
+	for _, op := range u {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a document", op.Key)
+		}
+		for _, f := range fields {
+			path := strings.Split(f.Key, ".")
+			var err error
+			switch op.Key {
+			case "$set":
+				out, err = setPath(out, path, f.Value)
+			case "$setOnInsert":
+				if inserting {
+					out, err = setPath(out, path, f.Value)
+				}
+			case "$unset":
+				out = unsetPath(out, path)
+			case "$inc", "$mul":
+				out, err = arith(out, path, f.Value, op.Key == "$mul")
+			case "$min", "$max":
+				current := lookup(out, path)
+				if len(current) == 0 || (op.Key == "$min" && compare(f.Value, current[0]) < 0) || (op.Key == "$max" && compare(f.Value, current[0]) > 0) {
+					out, err = setPath(out, path, f.Value)
+				}
+			case "$currentDate":
+				out, err = setPath(out, path, primitive.NewDateTimeFromTime(clock.Now()))
+			case "$push", "$addToSet":
+				out, err = push(out, path, f.Value, op.Key == "$addToSet")
+			case "$pull", "$pullAll":
+				out, err = pull(out, path, f.Value, op.Key == "$pullAll")
+			case "$rename":
+				to, ok := f.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("$rename needs a string")
+				}
+				if values := lookup(out, path); len(values) > 0 {
+					out = unsetPath(out, path)
+					out, err = setPath(out, strings.Split(to, "."), values[0])
+				}
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", op.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// setPath sets a dotted path, creating documents on the way
+func setPath(doc bson.D, path []string, value interface{}) (bson.D, error) {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+			return doc, nil
+		}
+		child, err := setIn(e.Value, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = child
+		return doc, nil
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value}), nil
+	}
+	child, err := setPath(bson.D{}, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return append(doc, bson.E{Key: path[0], Value: child}), nil
+}
+
+// setIn sets a path below a document or array element
+func setIn(v interface{}, path []string, value interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case bson.D:
+		return setPath(t, path, value)
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("cannot set %s in an array", path[0])
+		}
+		for len(t) <= i {
+			t = append(t, nil)
+		}
+		if len(path) == 1 {
+			t[i] = value
+			return t, nil
+		}
+		child, err := setIn(t[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		t[i] = child
+		return t, nil
+	case nil:
+		return setPath(bson.D{}, path, value)
+	}
+	return nil, fmt.Errorf("cannot set %s in a %s", strings.Join(path, "."), typeName(v))
+}
+
+// unsetPath removes a dotted path
+func unsetPath(doc bson.D, path []string) bson.D {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		if child, ok := e.Value.(bson.D); ok {
+			doc[i].Value = unsetPath(child, path[1:])
+		}
+		return doc
+	}
+	return doc
+}
+
+// arith adds to or multiplies a numeric field, keeping integers integral
+func arith(doc bson.D, path []string, by interface{}, multiply bool) (bson.D, error) {
+	var current interface{} = int32(0)
+	if values := lookup(doc, path); len(values) > 0 {
+		current = values[0]
+	}
+	a, okA := toFloat(current)
+	b, okB := toFloat(by)
+	if !okA || !okB {
+		return nil, fmt.Errorf("cannot apply arithmetic to %s", typeName(current))
+	}
+	result := a + b
+	if multiply {
+		result = a * b
+	}
+
+	var value interface{}
+	switch {
+	case isFloat(current) || isFloat(by):
+		value = result
+	case isInt64(current) || isInt64(by):
+		value = int64(result)
+	default:
+		value = int32(result)
+	}
+	return setPath(doc, path, value)
+}
+
+func isFloat(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
+
+func isInt64(v interface{}) bool {
+	_, ok := v.(int64)
+	return ok
+}
+
+// push appends to an array field, with $each, $slice and $position
+// modifiers, and skipping values already present for $addToSet
+func push(doc bson.D, path []string, value interface{}, asSet bool) (bson.D, error) {
+	var arr bson.A
+	if values := lookup(doc, path); len(values) > 0 && values[0] != nil {
+		existing, ok := values[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("cannot push to a %s", typeName(values[0]))
+		}
+		arr = append(bson.A{}, existing...)
+	}
+
+	items := bson.A{value}
+	slice, hasSlice := 0, false
+	position := -1
+	if mods, ok := value.(bson.D); ok {
+		if each, ok := get(mods, "$each"); ok {
+			list, ok := each.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("$each needs an array")
+			}
+			items = list
+			if s, ok := get(mods, "$slice"); ok {
+				n, _ := toFloat(s)
+				slice, hasSlice = int(n), true
+			}
+			if p, ok := get(mods, "$position"); ok {
+				n, _ := toFloat(p)
+				position = int(n)
+			}
+			if _, ok := get(mods, "$sort"); ok {
+				return nil, fmt.Errorf("$sort in $push is not served")
+			}
+		}
+	}
+
+	var added bson.A
+	for _, item := range items {
+		if asSet && (equalsAny([]interface{}{arr}, item) || equalsAny([]interface{}{added}, item)) {
+			continue
+		}
+		added = append(added, item)
+	}
+	if position >= 0 && position < len(arr) {
+		arr = append(arr[:position], append(added, arr[position:]...)...)
+	} else {
+		arr = append(arr, added...)
+	}
+	if hasSlice {
+		switch {
+		case slice >= 0 && slice < len(arr):
+			arr = arr[:slice]
+		case slice < 0 && -slice < len(arr):
+			arr = arr[len(arr)+slice:]
+		}
+	}
+	if arr == nil {
+		arr = bson.A{}
+	}
+	return setPath(doc, path, arr)
+}
+
+// pull removes the elements of an array field that equal a value or match a
+// condition
+func pull(doc bson.D, path []string, cond interface{}, all bool) (bson.D, error) {
+	values := lookup(doc, path)
+	if len(values) == 0 {
+		return doc, nil
+	}
+	arr, ok := values[0].(bson.A)
+	if !ok {
+		return doc, nil
+	}
+	kept := bson.A{}
+	for _, elem := range arr {
+		var remove bool
+		switch {
+		case all:
+			list, _ := cond.(bson.A)
+			for _, v := range list {
+				if compare(elem, v) == 0 {
+					remove = true
+				}
+			}
+		default:
+			if _, isOps := isOperatorDoc(cond); isOps {
+				m, err := matchValue([]interface{}{elem}, cond)
+				if err != nil {
+					return nil, err
+				}
+				remove = m
+			} else if c, isDoc := cond.(bson.D); isDoc {
+				if d, ok := elem.(bson.D); ok {
+					m, err := matches(d, c)
+					if err != nil {
+						return nil, err
+					}
+					remove = m
+				}
+			} else {
+				remove = compare(elem, cond) == 0
+			}
+		}
+		if !remove {
+			kept = append(kept, elem)
+		}
+	}
+	return setPath(doc, path, kept)
+}
+
+// upsertBase returns the document an upsert starts from: the fields a
+// filter pins with equality
+func upsertBase(filter bson.D) (bson.D, error) {
+	doc := bson.D{}
+	var err error
+	for _, e := range filter {
+		switch {
+		case e.Key == "$and":
+			clauses, _ := e.Value.(bson.A)
+			for _, clause := range clauses {
+				sub, ok := clause.(bson.D)
+				if !ok {
+					continue
+				}
+				base, err := upsertBase(sub)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range base {
+					if doc, err = setPath(doc, strings.Split(f.Key, "."), f.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case strings.HasPrefix(e.Key, "$"):
+		default:
+			value := e.Value
+			if ops, ok := isOperatorDoc(value); ok {
+				eq, found := get(ops, "$eq")
+				if !found {
+					continue
+				}
+				value = eq
+			}
+			if doc, err = setPath(doc, strings.Split(e.Key, "."), value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+// withID puts an _id first, generating one when the document has none
+func withID(doc bson.D) bson.D {
+	if id, ok := get(doc, "_id"); ok {
+		rest := unsetPath(doc, []string{"_id"})
+		return append(bson.D{{Key: "_id", Value: id}}, rest...)
+	}
+	return append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+}
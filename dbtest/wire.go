@@ -0,0 +1,263 @@
+package dbtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Wire protocol opcodes. The driver sends its handshake as OP_QUERY and
+// every later command as OP_MSG.
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// OP_MSG flag bits
+const (
+	flagChecksumPresent = 1 << 0
+	flagMoreToCome      = 1 << 1
+)
+
+// maxMessageSize bounds what a connection reads before giving up on a peer
+const maxMessageSize = 48000000
+
+var lastRequestID int32
+
+// serve accepts connections until the listener is closed
+func (srv *Server) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.open[conn] = true
+		srv.mu.Unlock()
+		srv.conns.Add(1)
+		go func() {
+			defer srv.conns.Done()
+			srv.handle(conn)
+			srv.mu.Lock()
+			delete(srv.open, conn)
+			srv.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle answers the messages of one connection in order
+func (srv *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		length := int32(binary.LittleEndian.Uint32(header))
+		if length < 16 || length > maxMessageSize {
+			return
+		}
+		requestID := int32(binary.LittleEndian.Uint32(header[4:]))
+		opcode := int32(binary.LittleEndian.Uint32(header[12:]))
+		body := make([]byte, length-16)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		var reply []byte
+		switch opcode {
+		case opMsg:
+			cmd, flags, err := readMsg(body)
+			if err != nil {
+				return
+			}
+			answer := srv.run(cmd)
+			if flags&flagMoreToCome != 0 {
+				continue
+			}
+			reply, err = msgReply(requestID, answer)
+			if err != nil {
+				return
+			}
+		case opQuery:
+			cmd, err := readQuery(body)
+			if err != nil {
+				return
+			}
+			reply, err = queryReply(requestID, srv.run(cmd))
+			if err != nil {
+				return
+			}
+		default:
+			return
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// readMsg decodes the command of an OP_MSG: its body section, with the
+// documents of any sequence sections appended as arrays
+func readMsg(body []byte) (bson.D, uint32, error) {
+	if len(body) < 4 {
+		return nil, 0, errMalformed
+	}
+	flags := binary.LittleEndian.Uint32(body)
+	rem := body[4:]
+	if flags&flagChecksumPresent != 0 {
+		if len(rem) < 4 {
+			return nil, 0, errMalformed
+		}
+		rem = rem[:len(rem)-4]
+	}
+
+	var cmd bson.D
+	var sequences []bson.E
+	for len(rem) > 0 {
+		kind := rem[0]
+		rem = rem[1:]
+		switch kind {
+		case 0:
+			doc, rest, err := readDocument(rem)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := bson.Unmarshal(doc, &cmd); err != nil {
+				return nil, 0, err
+			}
+			rem = rest
+		case 1:
+			if len(rem) < 4 {
+				return nil, 0, errMalformed
+			}
+			size := int(binary.LittleEndian.Uint32(rem))
+			if size < 4 || size > len(rem) {
+				return nil, 0, errMalformed
+			}
+			section, rest := rem[4:size], rem[size:]
+			id, section, err := readCString(section)
+			if err != nil {
+				return nil, 0, err
+			}
+			values := bson.A{}
+			for len(section) > 0 {
+				var doc []byte
+				doc, section, err = readDocument(section)
+				if err != nil {
+					return nil, 0, err
+				}
+				var d bson.D
+				if err := bson.Unmarshal(doc, &d); err != nil {
+					return nil, 0, err
+				}
+				values = append(values, d)
+			}
+			sequences = append(sequences, bson.E{Key: id, Value: values})
+			rem = rest
+		default:
+			return nil, 0, fmt.Errorf("dbtest: unsupported OP_MSG section %d", kind)
+		}
+	}
+	return append(cmd, sequences...), flags, nil
+}
+
+// readQuery decodes the command of an OP_QUERY on a $cmd namespace
+func readQuery(body []byte) (bson.D, error) {
+	if len(body) < 4 {
+		return nil, errMalformed
+	}
+	ns, rem, err := readCString(body[4:])
+	if err != nil {
+		return nil, err
+	}
+	if len(rem) < 8 {
+		return nil, errMalformed
+	}
+	doc, _, err := readDocument(rem[8:])
+	if err != nil {
+		return nil, err
+	}
+	var cmd bson.D
+	if err := bson.Unmarshal(doc, &cmd); err != nil {
+		return nil, err
+	}
+	// Commands sent with a read preference are wrapped in $query
+	if len(cmd) > 0 && cmd[0].Key == "$query" {
+		if inner, ok := cmd[0].Value.(bson.D); ok {
+			cmd = inner
+		}
+	}
+	db, _, _ := strings.Cut(ns, ".")
+	return append(cmd, bson.E{Key: "$db", Value: db}), nil
+}
+
+// msgReply encodes a reply as an OP_MSG
+func msgReply(responseTo int32, reply bson.D) ([]byte, error) {
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	writeHeader(&b, 16+4+1+len(doc), responseTo, opMsg)
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteByte(0)
+	b.Write(doc)
+	return b.Bytes(), nil
+}
+
+// queryReply encodes a reply as the OP_REPLY to an OP_QUERY
+func queryReply(responseTo int32, reply bson.D) ([]byte, error) {
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	writeHeader(&b, 16+20+len(doc), responseTo, opReply)
+	binary.Write(&b, binary.LittleEndian, int32(0)) // response flags
+	binary.Write(&b, binary.LittleEndian, int64(0)) // cursor ID
+	binary.Write(&b, binary.LittleEndian, int32(0)) // starting from
+	binary.Write(&b, binary.LittleEndian, int32(1)) // documents returned
+	b.Write(doc)
+	return b.Bytes(), nil
+}
+
+func writeHeader(b *bytes.Buffer, length int, responseTo, opcode int32) {
+	binary.Write(b, binary.LittleEndian, int32(length))
+	binary.Write(b, binary.LittleEndian, atomic.AddInt32(&lastRequestID, 1))
+	binary.Write(b, binary.LittleEndian, responseTo)
+	binary.Write(b, binary.LittleEndian, opcode)
+}
+
+var errMalformed = errors.New("dbtest: malformed wire message")
+
+// readDocument splits the BSON document at the start of b from the rest
+func readDocument(b []byte) ([]byte, []byte, error) {
+	if len(b) < 5 {
+		return nil, nil, errMalformed
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size < 5 || size > len(b) {
+		return nil, nil, errMalformed
+	}
+	return b[:size], b[size:], nil
+}
+
+// readCString splits the NUL-terminated string at the start of b from the rest
+func readCString(b []byte) (string, []byte, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, errMalformed
+	}
+	return string(b[:i]), b[i+1:], nil
+}
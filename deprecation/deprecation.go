@@ -0,0 +1,350 @@
+// Package deprecation retires routes by configuration instead of by deleting
+// code. Routes listed in DEPRECATED_ROUTES keep working until their sunset
+// date, answering with Deprecation, Sunset, Link and Warning headers, and are
+// then refused with 410 Gone. Every call to a deprecated route is logged with
+// its caller (the calling service, API key or user, or else the client IP)
+// and counted per caller and day in deprecated_calls, so admins can tell who
+// still has to move before the sunset.
+package deprecation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/servicetraffic"
+)
+
+// collection counts calls to deprecated routes per route, caller and day
+const collection = "deprecated_calls"
+
+// queueSize bounds calls waiting to be counted; beyond it calls are dropped
+const queueSize = 1024
+
+// Route is a deprecated route
+type Route struct {
+	Method string    `json:"method" example:"POST"`
+	Path   string    `json:"path" example:"/admin/users/delete"`
+	Sunset time.Time `json:"sunset"`
+	// Successor is what callers should use instead
+	Successor string `json:"successor,omitempty" example:"DELETE /admin/users/{id}"`
+}
+
+// Name returns the route as configured, "METHOD /path"
+func (rt Route) Name() string {
+	return rt.Method + " " + rt.Path
+}
+
+// Gone reports whether the route is past its sunset
+func (rt Route) Gone(now time.Time) bool {
+	return !now.Before(rt.Sunset)
+}
+
+// Usage is the calls of one caller to a deprecated route
+type Usage struct {
+	Method    string    `bson:"method" json:"method" example:"POST"`
+	Path      string    `bson:"path" json:"path" example:"/admin/users/delete"`
+	Caller    string    `bson:"caller" json:"caller" example:"service:user-service"`
+	UserAgent string    `bson:"user_agent" json:"user_agent,omitempty" example:"user-service/1.4"`
+	Calls     int64     `bson:"calls" json:"calls" example:"42"`
+	GoneCalls int64     `bson:"gone_calls" json:"gone_calls" example:"0"`
+	FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+// call is one call waiting to be counted
+type call struct {
+	route     Route
+	caller    string
+	userAgent string
+	gone      bool
+	at        time.Time
+}
+
+var (
+	registry  = make(map[string]Route)
+	docsURL   string
+	retention time.Duration
+	queue     chan call
+)
+
+// Init loads the deprecated routes, creates the TTL index for counted calls
+// and starts counting them. Without deprecated routes the middleware passes
+// every request through.
+func Init(cfg *config.Config) {
+	docsURL = cfg.DeprecationURL
+	retention = cfg.DeprecationUsageRetention
+	for name, date := range cfg.DeprecatedRoutes {
+		method, path, ok := parseName(name)
+		if !ok {
+			log.Printf("deprecation: invalid route %q in DEPRECATED_ROUTES, want \"METHOD /path\"", name)
+			continue
+		}
+		sunset, err := parseDate(date)
+		if err != nil {
+			log.Printf("deprecation: invalid sunset %q for %s, ignoring", date, name)
+			continue
+		}
+		rt := Route{Method: method, Path: path, Sunset: sunset, Successor: cfg.DeprecationSuccessors[name]}
+		registry[rt.Name()] = rt
+	}
+	if len(registry) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.M{"user_id": 1}, Options: options.Index().SetSparse(true)},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("deprecation: failed to create indexes: %v", err)
+	}
+
+	queue = make(chan call, queueSize)
+	go func() {
+		for c := range queue {
+			if err := count(context.Background(), c); err != nil {
+				log.Printf("deprecation: failed to count call to %s from %s: %v", c.route.Name(), c.caller, err)
+			}
+		}
+	}()
+	for _, rt := range Routes() {
+		log.Printf("Deprecated route %s, sunset %s", rt.Name(), rt.Sunset.Format(time.RFC3339))
+	}
+}
+
+// Enabled reports whether any route is deprecated
+func Enabled() bool {
+	return len(registry) > 0
+}
+
+// parseName splits "METHOD /path" into its parts
+func parseName(name string) (string, string, bool) {
+	fields := strings.Fields(name)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		return "", "", false
+	}
+	return strings.ToUpper(fields[0]), fields[1], true
+}
+
+// parseDate reads a sunset as a date (midnight UTC) or an RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Routes returns the deprecated routes, soonest sunset first
+func Routes() []Route {
+	routes := make([]Route, 0, len(registry))
+	for _, rt := range registry {
+		routes = append(routes, rt)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if !routes[i].Sunset.Equal(routes[j].Sunset) {
+			return routes[i].Sunset.Before(routes[j].Sunset)
+		}
+		return routes[i].Name() < routes[j].Name()
+	})
+	return routes
+}
+
+// Check logs deprecated routes that match no route of the router, such as
+// paths written without their template variables
+func Check(r *mux.Router) {
+	if !Enabled() {
+		return
+	}
+	known := make(map[string]bool)
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			known[method+" "+path] = true
+		}
+		return nil
+	})
+	for name := range registry {
+		if !known[name] {
+			log.Printf("deprecation: %s in DEPRECATED_ROUTES matches no route", name)
+		}
+	}
+}
+
+// GoneResponse is the body of calls to a route past its sunset
+type GoneResponse struct {
+	Error     string    `json:"error" example:"POST /admin/users/delete was removed"`
+	Sunset    time.Time `json:"sunset"`
+	Successor string    `json:"successor,omitempty" example:"DELETE /admin/users/{id}"`
+}
+
+// Middleware marks calls to deprecated routes with deprecation headers, or
+// refuses them with 410 once the route is past its sunset, and logs and
+// counts them by caller. It runs inside the route groups, after
+// authentication, so callers are known.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt, ok := lookup(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := clock.Now()
+		gone := rt.Gone(now)
+		caller := callerOf(r)
+		correlation.Logf(r.Context(), "Deprecated route %s called by %s", rt.Name(), caller)
+		c := call{route: rt, caller: caller, userAgent: r.UserAgent(), gone: gone, at: now}
+		select {
+		case queue <- c:
+		default:
+			log.Printf("deprecation: queue full, dropping call to %s from %s", rt.Name(), caller)
+		}
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", rt.Sunset.UTC().Format(http.TimeFormat))
+		if docsURL != "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", docsURL))
+		}
+		w.Header().Set("Warning", fmt.Sprintf("299 - %q", warning(rt, gone)))
+
+		if gone {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(GoneResponse{Error: rt.Name() + " was removed", Sunset: rt.Sunset, Successor: rt.Successor})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lookup returns the deprecated route a request matched, if any
+func lookup(r *http.Request) (Route, bool) {
+	if len(registry) == 0 {
+		return Route{}, false
+	}
+	current := mux.CurrentRoute(r)
+	if current == nil {
+		return Route{}, false
+	}
+	path, err := current.GetPathTemplate()
+	if err != nil {
+		return Route{}, false
+	}
+	rt, ok := registry[r.Method+" "+path]
+	return rt, ok
+}
+
+// warning is the text of the Warning header
+func warning(rt Route, gone bool) string {
+	text := fmt.Sprintf("%s is deprecated and stops working on %s", rt.Name(), rt.Sunset.UTC().Format("2006-01-02"))
+	if gone {
+		text = fmt.Sprintf("%s was removed on %s", rt.Name(), rt.Sunset.UTC().Format("2006-01-02"))
+	}
+	if rt.Successor != "" {
+		text += "; use " + rt.Successor
+	}
+	return text
+}
+
+// callerOf names who made a request: the service of a verified service
+// token, the API key or user of the credentials, or else the client IP
+func callerOf(r *http.Request) string {
+	if name, ok := servicetraffic.Caller(r); ok {
+		return "service:" + name
+	}
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	if keyID, _ := claims["keyID"].(string); keyID != "" {
+		return "api_key:" + keyID
+	}
+	if userID, _ := claims["userID"].(string); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + audit.ClientIP(r)
+}
+
+// count adds a call to the day's count of its route and caller
+func count(ctx context.Context, c call) error {
+	day := c.at.UTC().Truncate(24 * time.Hour)
+	id := c.route.Name() + "|" + c.caller + "|" + day.Format("2006-01-02")
+	set := bson.M{
+		"method":     c.route.Method,
+		"path":       c.route.Path,
+		"caller":     c.caller,
+		"day":        day,
+		"user_agent": c.userAgent,
+		"last_seen":  c.at,
+		"expires_at": day.Add(retention),
+	}
+	// Users are filed by ID too, so erasing one finds their calls
+	if id, ok := strings.CutPrefix(c.caller, "user:"); ok {
+		if userID, err := primitive.ObjectIDFromHex(id); err == nil {
+			set["user_id"] = userID
+		}
+	}
+	inc := bson.M{"calls": 1}
+	if c.gone {
+		inc["gone_calls"] = 1
+	}
+	update := bson.M{"$set": set, "$inc": inc, "$min": bson.M{"first_seen": c.at}}
+	_, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": id}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// Summarize adds up the calls to deprecated routes since a time by route and
+// caller, most recent first
+func Summarize(ctx context.Context, since time.Time) ([]Usage, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": since.UTC().Truncate(24 * time.Hour)}}}},
+		{{Key: "$sort", Value: bson.M{"last_seen": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        bson.M{"method": "$method", "path": "$path", "caller": "$caller"},
+			"user_agent": bson.M{"$last": "$user_agent"},
+			"calls":      bson.M{"$sum": "$calls"},
+			"gone_calls": bson.M{"$sum": "$gone_calls"},
+			"first_seen": bson.M{"$min": "$first_seen"},
+			"last_seen":  bson.M{"$max": "$last_seen"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"method": "$_id.method", "path": "$_id.path", "caller": "$_id.caller"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "last_seen", Value: -1}, {Key: "caller", Value: 1}}}},
+	}
+	cursor, err := database.DB.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	usage := []Usage{}
+	err = cursor.All(ctx, &usage)
+	return usage, err
+}
+
+// Purge deletes the counted calls of a user, returning how many days of
+// calls were removed
+func Purge(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := database.DB.Collection(collection).DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
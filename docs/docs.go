@@ -84,7 +84,12 @@ const docTemplate = `{
         },
         "/admin/register": {
             "post": {
-                "description": "Register a new admin user with email and password",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create an admin account with email and password (requires users:role, sudo). Refused while approvals are required",
                 "consumes": [
                     "application/json"
                 ],
@@ -107,8 +112,8 @@ const docTemplate = `{
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
                             "$ref": "#/definitions/handlers.RegisterResponse"
                         }
@@ -116,19 +121,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Invalid request payload",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
                     "409": {
-                        "description": "Admin already exists",
+                        "description": "User already exists",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     }
                 }
@@ -141,7 +158,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Get a paginated list of all users (Admin only)",
+                "description": "Get a paginated list of all users (requires users:read)",
                 "consumes": [
                     "application/json"
                 ],
@@ -209,7 +226,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Delete a user by ID (Admin only)",
+                "description": "Delete a user by ID (requires users:delete)",
                 "consumes": [
                     "application/json"
                 ],
@@ -278,7 +295,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Update a user's role (Admin only)",
+                "description": "Update a user's role (requires users:role)",
                 "consumes": [
                     "application/json"
                 ],
@@ -667,10 +684,6 @@ const docTemplate = `{
                 "password": {
                     "type": "string",
                     "example": "password123"
-                },
-                "role": {
-                    "type": "string",
-                    "example": "user"
                 }
             }
         },
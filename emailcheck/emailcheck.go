@@ -0,0 +1,223 @@
+// Package emailcheck validates email addresses before they are attached to an
+// account: syntax, whether the domain accepts mail (MX lookup, cached), and
+// optionally whether the mail server accepts the mailbox (SMTP callout).
+//
+// Lookups that time out or fail temporarily never reject an address; only a
+// definite answer does.
+package emailcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-backend/config"
+)
+
+// Error codes returned in *Error
+const (
+	CodeInvalidSyntax   = "invalid_syntax"
+	CodeNoMailServer    = "no_mail_server"
+	CodeMailboxRejected = "mailbox_rejected"
+)
+
+// Error describes why an address was rejected
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	errInvalidSyntax   = &Error{Code: CodeInvalidSyntax, Message: "Email address is not valid"}
+	errNoMailServer    = &Error{Code: CodeNoMailServer, Message: "Email domain does not accept mail"}
+	errMailboxRejected = &Error{Code: CodeMailboxRejected, Message: "Email address does not exist"}
+)
+
+// settings are set by Init; the zero value checks syntax only
+var settings struct {
+	checkMX  bool
+	callout  bool
+	timeout  time.Duration
+	cacheTTL time.Duration
+	from     string
+}
+
+// Init configures deliverability checks from the configuration
+func Init(cfg *config.Config) {
+	settings.checkMX = cfg.EmailCheckMX
+	settings.callout = cfg.EmailSMTPCallout
+	settings.timeout = cfg.EmailCheckTimeout
+	settings.cacheTTL = cfg.EmailCheckCacheTTL
+	settings.from = cfg.SMTPFrom
+}
+
+// ValidSyntax reports whether email is a bare, syntactically valid address
+// with a dotted domain
+func ValidSyntax(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".")
+}
+
+// Check validates an address. It returns an *Error when the address is
+// definitely unusable and nil otherwise.
+func Check(ctx context.Context, email string) error {
+	if !ValidSyntax(email) {
+		return errInvalidSyntax
+	}
+	if !settings.checkMX {
+		return nil
+	}
+
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	hosts, err := mailHosts(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	if settings.callout && len(hosts) > 0 {
+		return callout(hosts[0], email)
+	}
+	return nil
+}
+
+// mxEntry is a cached conclusive lookup. Inconclusive lookups are not cached.
+type mxEntry struct {
+	hosts   []string
+	err     error
+	expires time.Time
+}
+
+var (
+	mxMu    sync.Mutex
+	mxCache = make(map[string]mxEntry)
+)
+
+// mailHosts returns the mail servers of a domain by preference, using the
+// cache when fresh. It returns errNoMailServer when the domain cannot receive mail.
+func mailHosts(ctx context.Context, domain string) ([]string, error) {
+	mxMu.Lock()
+	entry, ok := mxCache[domain]
+	mxMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.hosts, entry.err
+	}
+
+	hosts, conclusive, err := lookupMailHosts(ctx, domain)
+	if !conclusive {
+		return nil, nil
+	}
+
+	mxMu.Lock()
+	mxCache[domain] = mxEntry{hosts: hosts, err: err, expires: time.Now().Add(settings.cacheTTL)}
+	mxMu.Unlock()
+	return hosts, err
+}
+
+// lookupMailHosts resolves MX records, falling back to the domain's own address
+// records as RFC 5321 allows. conclusive is false on timeouts and temporary failures.
+func lookupMailHosts(ctx context.Context, domain string) ([]string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, settings.timeout)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupMX(ctx, domain)
+	if err == nil {
+		var hosts []string
+		sort.Slice(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+		for _, mx := range records {
+			// A single "." record is a null MX: the domain declares it takes no mail
+			if host := strings.TrimSuffix(mx.Host, "."); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) == 0 {
+			return nil, true, errNoMailServer
+		}
+		return hosts, true, nil
+	}
+	if !isNotFound(err) {
+		log.Printf("MX lookup for %s inconclusive: %v", domain, err)
+		return nil, false, nil
+	}
+
+	if _, err := net.DefaultResolver.LookupHost(ctx, domain); err != nil {
+		if isNotFound(err) {
+			return nil, true, errNoMailServer
+		}
+		log.Printf("Address lookup for %s inconclusive: %v", domain, err)
+		return nil, false, nil
+	}
+	return []string{domain}, true, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// callout asks the mail server whether it accepts the mailbox, without sending
+// a message. Only a permanent (5xx) refusal of the recipient rejects the address.
+func callout(host, email string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "25"), settings.timeout)
+	if err != nil {
+		log.Printf("SMTP callout to %s inconclusive: %v", host, err)
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(3 * settings.timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		log.Printf("SMTP callout to %s inconclusive: %v", host, err)
+		return nil
+	}
+	defer client.Close()
+
+	if err := client.Hello(heloName()); err != nil {
+		return nil
+	}
+	if err := client.Mail(settings.from); err != nil {
+		return nil
+	}
+	err = client.Rcpt(email)
+	client.Quit()
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 && protoErr.Code < 600 {
+		return errMailboxRejected
+	}
+	return nil
+}
+
+// heloName is the name announced to mail servers, taken from the sender domain
+func heloName() string {
+	if at := strings.LastIndex(settings.from, "@"); at >= 0 {
+		return settings.from[at+1:]
+	}
+	return "localhost"
+}
+
+// Describe renders an error for plain-text responses, including the code
+func Describe(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+	}
+	return err.Error()
+}
@@ -0,0 +1,45 @@
+// Package emailverify issues the tokens that prove a user can read the
+// address they registered with. A token expires after
+// EMAIL_VERIFICATION_TTL and works once; it is bound to the address it was
+// sent to, so it cannot verify an address changed since. See singleuse.
+package emailverify
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/singleuse"
+)
+
+// ErrInvalidToken is returned for unknown, used and expired tokens
+var ErrInvalidToken = errors.New("invalid or expired verification token")
+
+// Verification is a pending verification token
+type Verification = singleuse.Token
+
+var store = singleuse.Store{Collection: "email_verifications", ErrInvalid: ErrInvalidToken}
+
+// Init reads the token lifetime and creates the indexes
+func Init(cfg *config.Config) {
+	store.TTL = cfg.EmailVerificationTTL
+	store.Init()
+}
+
+// TTL returns how long issued tokens are valid
+func TTL() time.Duration {
+	return store.TTL
+}
+
+// Issue creates a verification token for a user's current address, voiding
+// any earlier one
+func Issue(ctx context.Context, userID primitive.ObjectID, emailHash string) (string, error) {
+	return store.Issue(ctx, userID, emailHash)
+}
+
+// Redeem consumes a token and returns it
+func Redeem(ctx context.Context, raw string) (*Verification, error) {
+	return store.Redeem(ctx, raw)
+}
@@ -0,0 +1,214 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// changeEvent is the part of a change stream document recorded as an event
+type changeEvent struct {
+	ID            bson.Raw            `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	WallTime      *time.Time          `bson:"wallTime,omitempty"`
+	DocumentKey   bson.Raw            `bson:"documentKey"`
+	FullDocument  bson.M              `bson:"fullDocument,omitempty"`
+	Update        struct {
+		UpdatedFields   bson.M        `bson:"updatedFields"`
+		RemovedFields   []string      `bson:"removedFields"`
+		TruncatedArrays []interface{} `bson:"truncatedArrays"`
+	} `bson:"updateDescription"`
+}
+
+// tokenCollection stores the last processed resume token of every stream,
+// shared with the watcher under different stream IDs
+const tokenCollection = "change_stream_tokens"
+
+// Start records the changes to the users collection of every region. Every
+// instance runs it; a change recorded by one is skipped by the others.
+func Start(cfg *config.Config) {
+	if !cfg.EventSourcingEnabled {
+		return
+	}
+
+	for region, db := range database.Regions {
+		go capture(region, db.Collection("users"))
+	}
+
+	log.Println("User event capture started")
+}
+
+// capture consumes a change stream, reopening it from the stored resume
+// token after errors
+func capture(region string, collection *mongo.Collection) {
+	streamID := "user_events:" + region
+	backoff := time.Second
+
+	for {
+		err := consume(context.Background(), streamID, region, collection)
+		log.Printf("eventsource: stream %s stopped: %v; retrying in %s", streamID, err, backoff)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// consume records a change stream until it fails. The resume token is only
+// saved once its change is recorded, so none is lost.
+func consume(ctx context.Context, streamID, region string, collection *mongo.Collection) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token, err := loadToken(ctx, streamID); err != nil {
+		return err
+	} else if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	stream, err := collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var change changeEvent
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		if err := record(ctx, region, &change); err != nil {
+			return err
+		}
+		if err := saveToken(ctx, streamID, change.ID); err != nil {
+			log.Printf("eventsource: failed to persist resume token of %s: %v", streamID, err)
+		}
+	}
+	return stream.Err()
+}
+
+// record appends a change as the next event of its user
+func record(ctx context.Context, region string, change *changeEvent) error {
+	userID, ok := change.DocumentKey.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return nil
+	}
+	event := Event{
+		UserID:   userID,
+		Region:   region,
+		ChangeID: change.ID.String(),
+		Time:     time.Unix(int64(change.ClusterTime.T), 0).UTC(),
+	}
+	if change.WallTime != nil {
+		event.Time = change.WallTime.UTC()
+	}
+
+	switch change.OperationType {
+	case "insert":
+		event.Type, event.Document = Created, change.FullDocument
+	case "replace":
+		event.Type, event.Document = Replaced, change.FullDocument
+		// The projector rebuilding a user replaces it with its own state
+		if state, err := Load(ctx, userID, time.Time{}); err == nil && !state.Deleted && reflect.DeepEqual(state.Document, change.FullDocument) {
+			return nil
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	case "update":
+		event.Type = Updated
+		event.Set, event.Unset = change.Update.UpdatedFields, change.Update.RemovedFields
+		if !topLevel(change) {
+			event.Set, event.Unset, event.Document = nil, nil, change.FullDocument
+		}
+	case "delete":
+		event.Type = Deleted
+	default:
+		return nil
+	}
+
+	collection := database.DB.Collection(eventCollection)
+	for attempt := 0; attempt < 5; attempt++ {
+		last, err := lastVersion(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// The first event of a user changed before event sourcing was enabled
+		// holds the whole document
+		if last == 0 && event.Type == Updated && change.FullDocument != nil {
+			event.Set, event.Unset, event.Document = nil, nil, change.FullDocument
+		}
+
+		event.ID, event.Version = primitive.NewObjectID(), last+1
+		_, err = collection.InsertOne(ctx, event)
+		if err == nil {
+			if event.Version%snapshotInterval == 0 {
+				if err := saveSnapshot(ctx, userID); err != nil {
+					log.Printf("eventsource: failed to snapshot user %s: %v", userID.Hex(), err)
+				}
+			}
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		// Another instance recorded this change, or another change of the
+		// same user took the version
+		if n, err := collection.CountDocuments(ctx, bson.M{"change_id": event.ChangeID}); err != nil {
+			return err
+		} else if n > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("eventsource: could not record change of user %s", userID.Hex())
+}
+
+// topLevel reports whether an update only sets and removes whole top-level
+// fields, so it can be replayed field by field
+func topLevel(change *changeEvent) bool {
+	if len(change.Update.TruncatedArrays) > 0 {
+		return false
+	}
+	for field := range change.Update.UpdatedFields {
+		if strings.Contains(field, ".") {
+			return false
+		}
+	}
+	for _, field := range change.Update.RemovedFields {
+		if strings.Contains(field, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// loadToken returns the stored resume token of a stream, or nil
+func loadToken(ctx context.Context, streamID string) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := database.DB.Collection(tokenCollection).FindOne(ctx, bson.M{"_id": streamID}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+// saveToken persists the resume token of a stream
+func saveToken(ctx context.Context, streamID string, token bson.Raw) error {
+	_, err := database.DB.Collection(tokenCollection).UpdateOne(ctx,
+		bson.M{"_id": streamID},
+		bson.M{"$set": bson.M{"token": token, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,248 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Collections of the event store
+const (
+	eventCollection    = "user_events"
+	snapshotCollection = "user_snapshots"
+)
+
+// Event types
+const (
+	Created  = "created"
+	Updated  = "updated"
+	Replaced = "replaced"
+	Deleted  = "deleted"
+)
+
+// ErrNotFound is returned for users without recorded events
+var ErrNotFound = errors.New("no events recorded for user")
+
+// Event is a change to a user document. Created and replaced events hold
+// the whole document; updated events hold the changed top-level fields,
+// or the whole document when the change cannot be replayed field by field.
+// Versions number the events of a user from 1 without gaps.
+type Event struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	UserID   primitive.ObjectID `bson:"user_id"`
+	Version  int64              `bson:"version"`
+	Type     string             `bson:"type"`
+	Region   string             `bson:"region"`
+	Set      bson.M             `bson:"set,omitempty"`
+	Unset    []string           `bson:"unset,omitempty"`
+	Document bson.M             `bson:"document,omitempty"`
+	ChangeID string             `bson:"change_id"`
+	Time     time.Time          `bson:"time"`
+}
+
+// Fields names the fields an event changed, or nil when it holds the whole
+// document
+func (e *Event) Fields() []string {
+	if e.Document != nil {
+		return nil
+	}
+	fields := make([]string, 0, len(e.Set)+len(e.Unset))
+	for field := range e.Set {
+		fields = append(fields, field)
+	}
+	return append(fields, e.Unset...)
+}
+
+// State is a user document folded from its events
+type State struct {
+	Version int64     `bson:"version"`
+	Region  string    `bson:"region"`
+	Deleted bool      `bson:"deleted,omitempty"`
+	Time    time.Time `bson:"time"`
+	// Document is nil until an event holding the whole document, for
+	// users that changed before event sourcing was enabled
+	Document bson.M `bson:"document,omitempty"`
+}
+
+// apply folds an event into the state
+func (s *State) apply(e *Event) {
+	s.Version, s.Time = e.Version, e.Time
+	switch e.Type {
+	case Created, Replaced:
+		s.Document, s.Region, s.Deleted = copyDocument(e.Document), e.Region, false
+	case Updated:
+		if e.Document != nil {
+			s.Document, s.Region, s.Deleted = copyDocument(e.Document), e.Region, false
+			return
+		}
+		if s.Document == nil {
+			return
+		}
+		for field, value := range e.Set {
+			s.Document[field] = value
+		}
+		for _, field := range e.Unset {
+			delete(s.Document, field)
+		}
+	case Deleted:
+		// Moving a user between regions inserts it in one and deletes it
+		// from the other, in either order
+		if e.Region == s.Region {
+			s.Document, s.Deleted = nil, true
+		}
+	}
+}
+
+func copyDocument(doc bson.M) bson.M {
+	if doc == nil {
+		return nil
+	}
+	c := make(bson.M, len(doc))
+	for k, v := range doc {
+		c[k] = v
+	}
+	return c
+}
+
+// snapshot is the state of a user at a version, so loading it replays only
+// the events since
+type snapshot struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	State  `bson:",inline"`
+}
+
+// snapshotInterval is the number of events between snapshots
+var snapshotInterval int64 = 100
+
+// Init indexes the event store. Events are not recorded unless Start runs.
+func Init(cfg *config.Config) {
+	if cfg.EventSnapshotInterval > 0 {
+		snapshotInterval = int64(cfg.EventSnapshotInterval)
+	}
+	if !cfg.EventSourcingEnabled {
+		return
+	}
+
+	ctx := context.Background()
+	events := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"change_id": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time", Value: 1}}},
+	}
+	if _, err := database.DB.Collection(eventCollection).Indexes().CreateMany(ctx, events); err != nil {
+		log.Printf("eventsource: failed to create event indexes: %v", err)
+	}
+	snapshots := mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)}
+	if _, err := database.DB.Collection(snapshotCollection).Indexes().CreateOne(ctx, snapshots); err != nil {
+		log.Printf("eventsource: failed to create snapshot index: %v", err)
+	}
+}
+
+// Load folds the events of a user into its state as of a time, or its
+// latest state when asOf is zero, starting from the closest snapshot
+func Load(ctx context.Context, userID primitive.ObjectID, asOf time.Time) (*State, error) {
+	snapshotFilter := bson.M{"user_id": userID}
+	eventFilter := bson.M{"user_id": userID}
+	if !asOf.IsZero() {
+		snapshotFilter["time"] = bson.M{"$lte": asOf}
+		eventFilter["time"] = bson.M{"$lte": asOf}
+	}
+
+	state := &State{}
+	var snap snapshot
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	err := database.DB.Collection(snapshotCollection).FindOne(ctx, snapshotFilter, opts).Decode(&snap)
+	if err == nil {
+		state = &snap.State
+		eventFilter["version"] = bson.M{"$gt": snap.Version}
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	cursor, err := database.DB.Collection(eventCollection).Find(ctx, eventFilter, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var event Event
+		if err := cursor.Decode(&event); err != nil {
+			return nil, err
+		}
+		state.apply(&event)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if state.Version == 0 {
+		return nil, ErrNotFound
+	}
+	return state, nil
+}
+
+// History returns up to limit events of a user after a version, oldest
+// first
+func History(ctx context.Context, userID primitive.ObjectID, after int64, limit int) ([]Event, error) {
+	filter := bson.M{"user_id": userID, "version": bson.M{"$gt": after}}
+	opts := options.Find().SetSort(bson.M{"version": 1}).SetLimit(int64(limit))
+	cursor, err := database.DB.Collection(eventCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Purge deletes the events and snapshots of a user, so an erased user
+// leaves no history behind. It returns the number of events deleted.
+func Purge(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := database.DB.Collection(eventCollection).DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := database.DB.Collection(snapshotCollection).DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return result.DeletedCount, err
+	}
+	return result.DeletedCount, nil
+}
+
+// lastVersion returns the version of the latest event of a user, or 0
+func lastVersion(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	var event struct {
+		Version int64 `bson:"version"`
+	}
+	opts := options.FindOne().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"version": 1})
+	err := database.DB.Collection(eventCollection).FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&event)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return event.Version, err
+}
+
+// saveSnapshot stores the state of a user at its latest version
+func saveSnapshot(ctx context.Context, userID primitive.ObjectID) error {
+	state, err := Load(ctx, userID, time.Time{})
+	if err != nil {
+		return err
+	}
+	if state.Document == nil && !state.Deleted {
+		return nil
+	}
+	_, err = database.DB.Collection(snapshotCollection).InsertOne(ctx, snapshot{ID: primitive.NewObjectID(), UserID: userID, State: *state})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
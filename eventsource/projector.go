@@ -0,0 +1,85 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/cache"
+	"golang-backend/database"
+	"golang-backend/repository"
+)
+
+// ErrNoBase is returned when rebuilding a user whose events do not start
+// from a whole document
+var ErrNoBase = errors.New("user events hold no whole document")
+
+// Rebuild projects the latest state of a user onto the users collections:
+// the document is written to the region of its last event and removed from
+// the others, or removed everywhere once deleted
+func Rebuild(ctx context.Context, userID primitive.ObjectID) error {
+	state, err := Load(ctx, userID, time.Time{})
+	if err != nil {
+		return err
+	}
+	if state.Document == nil && !state.Deleted {
+		return ErrNoBase
+	}
+
+	for region, db := range database.Regions {
+		users := db.Collection("users")
+		if region == state.Region && !state.Deleted {
+			continue
+		}
+		if _, err := users.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+			return err
+		}
+	}
+	if !state.Deleted {
+		users, err := repository.Users(state.Region)
+		if err != nil {
+			return err
+		}
+		if _, err := users.ReplaceOne(ctx, bson.M{"_id": userID}, state.Document, options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	cache.Invalidate(cache.TagUsers)
+	return nil
+}
+
+// RebuildAll rebuilds every user with recorded events. Users whose events
+// hold no whole document are skipped. It returns the number of users
+// rebuilt and skipped.
+func RebuildAll(ctx context.Context) (rebuilt, skipped int, err error) {
+	cursor, err := database.DB.Collection(eventCollection).Aggregate(ctx, bson.A{
+		bson.M{"$group": bson.M{"_id": "$user_id"}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var stream struct {
+			UserID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&stream); err != nil {
+			return rebuilt, skipped, err
+		}
+		switch err := Rebuild(ctx, stream.UserID); {
+		case errors.Is(err, ErrNoBase):
+			skipped++
+		case err != nil:
+			log.Printf("eventsource: failed to rebuild user %s: %v", stream.UserID.Hex(), err)
+			skipped++
+		default:
+			rebuilt++
+		}
+	}
+	return rebuilt, skipped, cursor.Err()
+}
@@ -0,0 +1,240 @@
+// Package files keeps the metadata of files users attach: owner, size,
+// checksum, content type, storage key and visibility. Files are created from
+// confirmed direct uploads, and a scheduled job deletes storage objects that
+// no file or pending upload refers to any more, such as uploads never
+// attached or objects whose deletion failed.
+package files
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/storage"
+	"golang-backend/uploads"
+)
+
+// collection holds file metadata
+const collection = "files"
+
+// Errors returned by Create
+var (
+	ErrUploadNotConfirmed = errors.New("upload is not confirmed")
+	ErrAlreadyAttached    = errors.New("upload is already attached to a file")
+)
+
+type fileKey struct{}
+
+// Init creates the indexes and starts the garbage collection of orphaned
+// objects every FILE_GC_INTERVAL
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.M{"upload_id": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"key": 1}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("files: failed to create indexes: %v", err)
+	}
+
+	if !uploads.Enabled() {
+		return
+	}
+	go func() {
+		for range time.Tick(cfg.FileGCInterval) {
+			deleted, err := CollectGarbage(context.Background(), cfg.FileOrphanGrace)
+			if err != nil {
+				log.Printf("files: garbage collection failed after deleting %d objects: %v", deleted, err)
+			} else if deleted > 0 {
+				log.Printf("files: deleted %d orphaned objects", deleted)
+			}
+		}
+	}()
+}
+
+// Create attaches a confirmed upload of the owner as a file
+func Create(ctx context.Context, ownerID, orgID string, uploadID primitive.ObjectID, name, visibility string) (*models.File, error) {
+	upload, err := uploads.Get(ctx, ownerID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.DirectUploadConfirmed {
+		return nil, ErrUploadNotConfirmed
+	}
+	if name == "" {
+		name = upload.Filename
+	}
+
+	now := clock.Now()
+	file := models.File{
+		ID:          clock.NewID(),
+		OwnerID:     ownerID,
+		OrgID:       orgID,
+		UploadID:    upload.ID,
+		Name:        name,
+		Size:        upload.Size,
+		Checksum:    upload.Checksum,
+		ContentType: upload.ContentType,
+		Key:         upload.Key,
+		Visibility:  visibility,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, file); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyAttached
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Get returns a file
+func Get(ctx context.Context, id primitive.ObjectID) (*models.File, error) {
+	var file models.File
+	if err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id}).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// List returns the files of an owner, newest first
+func List(ctx context.Context, ownerID string, limit int64) ([]models.File, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"owner_id": ownerID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []models.File{}
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// Update changes the name and visibility of a file
+func Update(ctx context.Context, id primitive.ObjectID, name, visibility string) (*models.File, error) {
+	set := bson.M{"updated_at": clock.Now()}
+	if name != "" {
+		set["name"] = name
+	}
+	if visibility != "" {
+		set["visibility"] = visibility
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var file models.File
+	if err := database.DB.Collection(collection).FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Delete removes a file and its object. The metadata goes first, so an
+// object left behind by a failed delete is collected as an orphan later.
+func Delete(ctx context.Context, file *models.File) error {
+	if _, err := database.DB.Collection(collection).DeleteOne(ctx, bson.M{"_id": file.ID}); err != nil {
+		return err
+	}
+	if bucket := uploads.Bucket(); bucket != nil {
+		if err := bucket.Delete(ctx, file.Key); err != nil {
+			log.Printf("files: failed to delete object %s, leaving it to garbage collection: %v", file.Key, err)
+		}
+	}
+	return nil
+}
+
+// CanRead reports whether a user in an organization may see a file
+func CanRead(file *models.File, userID, orgID string, admin bool) bool {
+	switch {
+	case admin || file.OwnerID == userID:
+		return true
+	case file.Visibility == models.FilePublic:
+		return true
+	case file.Visibility == models.FileOrg:
+		return file.OrgID != "" && file.OrgID == orgID
+	}
+	return false
+}
+
+// CanWrite reports whether a user may change or delete a file
+func CanWrite(file *models.File, userID string, admin bool) bool {
+	return admin || file.OwnerID == userID
+}
+
+// WithFile stores the file a request operates on
+func WithFile(ctx context.Context, file *models.File) context.Context {
+	return context.WithValue(ctx, fileKey{}, file)
+}
+
+// FromContext returns the file stored by WithFile
+func FromContext(ctx context.Context) *models.File {
+	file, _ := ctx.Value(fileKey{}).(*models.File)
+	return file
+}
+
+// CollectGarbage deletes objects under the upload prefix older than grace
+// that neither a file nor a pending upload refers to, returning how many it
+// deleted
+func CollectGarbage(ctx context.Context, grace time.Duration) (int, error) {
+	bucket := uploads.Bucket()
+	if bucket == nil {
+		return 0, nil
+	}
+	cutoff := clock.Now().Add(-grace)
+	deleted := 0
+	err := bucket.List(ctx, uploads.Prefix(), func(page []storage.ObjectInfo) error {
+		var keys []string
+		for _, object := range page {
+			if object.LastModified.Before(cutoff) {
+				keys = append(keys, object.Key)
+			}
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		referenced, err := referencedKeys(ctx, keys)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if referenced[key] {
+				continue
+			}
+			if err := bucket.Delete(ctx, key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// referencedKeys returns which keys belong to a file or a pending upload
+func referencedKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	referenced := make(map[string]bool, len(keys))
+	fileKeys, err := database.DB.Collection(collection).Distinct(ctx, "key", bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	pendingKeys, err := database.DB.Collection("direct_uploads").Distinct(ctx, "key", bson.M{"key": bson.M{"$in": keys}, "status": models.DirectUploadPending})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range append(fileKeys, pendingKeys...) {
+		if s, ok := key.(string); ok {
+			referenced[s] = true
+		}
+	}
+	return referenced, nil
+}
@@ -0,0 +1,101 @@
+// Package geoip resolves the country a request comes from, either from a
+// header set by a CDN or load balancer in front of the API, or from a CSV
+// database of IP ranges such as the free DB-IP or IP2Location exports.
+package geoip
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"golang-backend/audit"
+	"golang-backend/config"
+)
+
+// ipRange maps the addresses from start to end to a country
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+var (
+	// header carries the country when the API sits behind a CDN
+	header string
+	// ranges are sorted by start address
+	ranges []ipRange
+)
+
+// Init loads the country header and range database from the configuration.
+// Database lines that do not parse are skipped.
+func Init(cfg *config.Config) {
+	header = cfg.GeoIPCountryHeader
+	ranges = nil
+	if cfg.GeoIPDatabase == "" {
+		return
+	}
+
+	f, err := os.Open(cfg.GeoIPDatabase)
+	if err != nil {
+		log.Printf("geoip: failed to open GEOIP_DATABASE: %v", err)
+		return
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		log.Printf("geoip: failed to read GEOIP_DATABASE: %v", err)
+		return
+	}
+	skipped := 0
+	for _, record := range records {
+		if len(record) < 3 {
+			skipped++
+			continue
+		}
+		start, err1 := netip.ParseAddr(strings.TrimSpace(record[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(record[1]))
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if err1 != nil || err2 != nil || len(country) != 2 || end.Less(start) {
+			skipped++
+			continue
+		}
+		ranges = append(ranges, ipRange{start.Unmap(), end.Unmap(), country})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	log.Printf("geoip: loaded %d ranges, skipped %d lines", len(ranges), skipped)
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the request's country, or
+// "" when it is unknown
+func Country(r *http.Request) string {
+	if header != "" {
+		// CDNs use XX or T1 (Tor) for addresses they cannot place
+		if c := strings.ToUpper(strings.TrimSpace(r.Header.Get(header))); len(c) == 2 && c != "XX" && c != "T1" {
+			return c
+		}
+	}
+	addr, err := netip.ParseAddr(audit.ClientIP(r))
+	if err != nil {
+		return ""
+	}
+	return Lookup(addr)
+}
+
+// Lookup returns the country of an address in the range database, or ""
+func Lookup(addr netip.Addr) string {
+	addr = addr.Unmap()
+	i := sort.Search(len(ranges), func(i int) bool { return addr.Less(ranges[i].start) })
+	if i == 0 {
+		return ""
+	}
+	if r := ranges[i-1]; !r.end.Less(addr) {
+		return r.country
+	}
+	return ""
+}
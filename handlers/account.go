@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/apikeys"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/challenge"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/jobs"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// selfServiceActor requests the erasure of accounts their users deleted
+const selfServiceActor = "self-service"
+
+// DeleteAccountRequest confirms deleting the caller's account
+type DeleteAccountRequest struct {
+	Password string `json:"password" example:"password123"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// DeleteAccountResponse tells when a deleted account's data is erased
+type DeleteAccountResponse struct {
+	Message string    `json:"message" example:"Account deleted"`
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// DeleteAccount handles self-service account deletion
+// @Summary Delete your account
+// @Description Delete the caller's account after confirming the password. The account is suspended and every session and API key revoked at once; its personal data is erased after ACCOUNT_DELETION_RETENTION, with a deletion certificate as for POST /admin/users/{id}/forget. Failed attempts are challenged as for sudo
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body DeleteAccountRequest true "Current password"
+// @Success 200 {object} DeleteAccountResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse "Invalid password"
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Account has no password, or is already deleted"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/account [delete]
+func DeleteAccount(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Only user sessions have a password to confirm
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if claims["auth"] != nil {
+			http.Error(w, `{"error": "This endpoint requires a session token"}`, http.StatusForbidden)
+			return
+		}
+		userIDStr, _ := claims["userID"].(string)
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+
+		var req DeleteAccountRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		user, users, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.CredentialFields)
+		if err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		if user.Password == "" {
+			http.Error(w, `{"error": "Set a password to confirm deleting the account"}`, http.StatusConflict)
+			return
+		}
+
+		// Guessing the password of a stolen session is challenged like sudo
+		codeTo := func(ctx context.Context) (string, *models.User, error) {
+			email, err := keys.Decrypt(ctx, cfg, user.Email)
+			return email, user, err
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowSudo, userIDStr, req.ChallengeResponse, codeTo) {
+			return
+		}
+		if err := utils.ComparePassword(user.Password, req.Password); errors.Is(err, utils.ErrPasswordPoolBusy) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error": "Server busy, please retry"}`, http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			failChallenge(r, challenge.FlowSudo, userIDStr)
+			security.Emit(r, security.EventAccountDeleted, security.OutcomeFailure, userIDStr, "invalid password")
+			http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+			return
+		}
+		resetChallenge(r, challenge.FlowSudo, userIDStr)
+
+		// The erasure is scheduled first; it does nothing unless the account
+		// is then marked deleted
+		now := clock.Now()
+		purgeAt := now.Add(cfg.AccountDeletionRetention)
+		if _, err := jobs.Schedule(ctx, jobPurgeAccount, userJob{UserID: userID}, purgeAt); err != nil {
+			correlation.Errorf(ctx, "Failed to schedule erasure of user %s: %v", userIDStr, err)
+			http.Error(w, `{"error": "Failed to delete account"}`, http.StatusInternalServerError)
+			return
+		}
+		update := bson.M{"$set": bson.M{
+			"suspended":         true,
+			"suspended_at":      now,
+			"suspension_reason": "deleted by user",
+			"deleted_at":        now,
+			"purge_at":          purgeAt,
+			"updated_at":        now,
+		}}
+		result, err := users.UpdateOne(ctx, bson.M{"_id": userID, "deleted_at": bson.M{"$exists": false}}, update)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to delete account"}`, http.StatusInternalServerError)
+			return
+		}
+		if result.MatchedCount == 0 {
+			http.Error(w, `{"error": "Account is already deleted"}`, http.StatusConflict)
+			return
+		}
+		cache.Invalidate(cache.TagUsers)
+
+		if _, err := tokens.RevokeUser(ctx, userIDStr); err != nil {
+			correlation.Errorf(ctx, "Failed to revoke sessions of deleted user %s: %v", userIDStr, err)
+		}
+		if _, err := apikeys.RevokeAll(ctx, userID); err != nil {
+			correlation.Errorf(ctx, "Failed to revoke API keys of deleted user %s: %v", userIDStr, err)
+		}
+
+		if _, err := audit.Record(r, audit.ActionDeleteAccount, userIDStr, nil, bson.M{"purge_at": purgeAt}); err != nil {
+			correlation.Errorf(ctx, "Failed to audit deletion of user %s: %v", userIDStr, err)
+		}
+		security.Emit(r, security.EventAccountDeleted, security.OutcomeSuccess, userIDStr, "")
+
+		json.NewEncoder(w).Encode(DeleteAccountResponse{Message: "Account deleted", PurgeAt: purgeAt.UTC()})
+	}
+}
+
+// purgeAccountJob erases the personal data of an account its user deleted,
+// once the retention window has passed
+func purgeAccountJob(cfg *config.Config, notify *notifier.Notifier) jobs.Handler {
+	return func(ctx context.Context, payload bson.Raw) error {
+		var job userJob
+		if err := bson.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": job.UserID}, repository.Fields("deleted_at", "purge_at", "forgotten_at"))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Restored, already erased, or postponed since the job was queued
+		if user.DeletedAt == nil || user.ForgottenAt != nil || (user.PurgeAt != nil && user.PurgeAt.After(clock.Now())) {
+			jobs.Logf(ctx, "user %s is not due for erasure", job.UserID.Hex())
+			return nil
+		}
+
+		affected, err := forgetUser(ctx, job.UserID)
+		if err != nil {
+			return err
+		}
+		cert, err := issueCertificate(ctx, cfg, selfServiceActor, job.UserID, "Account deleted by the user", affected)
+		if err != nil {
+			return err
+		}
+		jobs.Logf(ctx, "erased user %s, certificate %s", job.UserID.Hex(), cert.ID.Hex())
+		audit.Insert(models.AuditLog{
+			ActorID:  selfServiceActor,
+			Action:   audit.ActionForgetUser,
+			TargetID: forgottenActor,
+			After:    bson.M{"certificate_id": cert.ID, "subject_hash": cert.SubjectHash},
+		})
+		notify.Send(notifier.Event{
+			Type:     "user.forgotten",
+			Severity: notifier.SeverityInfo,
+			Message:  "User personal data erased",
+			Data:     map[string]interface{}{"certificate_id": cert.ID.Hex(), "subject_hash": cert.SubjectHash, "completed_at": cert.CompletedAt},
+		})
+		return nil
+	}
+}
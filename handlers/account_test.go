@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/notifier"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	del := func(password string) string { return `{"password":"` + password + `"}` }
+	runCases(t, DeleteAccount(testConfig), []handlerCase{
+		{
+			name: "deletes the account", as: "user", method: "DELETE", target: "/user/account", body: del(testPassword),
+			setup:  otherSession,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp DeleteAccountResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if want := clock.Now().Add(testConfig.AccountDeletionRetention); resp.PurgeAt.Sub(want).Abs() > time.Minute {
+					t.Fatalf("purge at %s, want about %s", resp.PurgeAt, want)
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "suspended": true, "deleted_at": bson.M{"$exists": true}}); n != 1 {
+					t.Fatal("account not marked deleted")
+				}
+				if n := f.srv.Count("jobs", bson.M{"kind": jobPurgeAccount, "payload.user_id": f.user.user.ID}); n != 1 {
+					t.Fatal("erasure not scheduled")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionDeleteAccount, "target_id": f.user.user.ID.Hex()}); n != 1 {
+					t.Fatal("deletion not audited")
+				}
+				checkToken(t, f.user.token, http.StatusUnauthorized)
+				checkToken(t, f.vars["other"], http.StatusUnauthorized)
+			},
+		},
+		{
+			name: "wrong password", as: "user", method: "DELETE", target: "/user/account", body: del("wrong password"),
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"deleted_at": bson.M{"$exists": true}}); n != 0 {
+					t.Fatal("account deleted")
+				}
+			},
+		},
+		{name: "malformed body", as: "user", method: "DELETE", target: "/user/account", body: `{"password":1}`, status: http.StatusBadRequest},
+		{
+			name: "no password", as: "user", method: "DELETE", target: "/user/account", body: del(""),
+			setup:  func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"password": ""}) },
+			status: http.StatusConflict,
+		},
+		{
+			name: "already deleted", as: "user", method: "DELETE", target: "/user/account", body: del(testPassword),
+			setup:  func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"deleted_at": clock.Now()}) },
+			status: http.StatusConflict,
+		},
+		{name: "no session", as: "guest", method: "DELETE", target: "/user/account", body: del(testPassword), status: http.StatusUnauthorized},
+		{name: "deleted after sign-in", as: "user", method: "DELETE", target: "/user/account", body: del(testPassword), setup: userDeleted, status: http.StatusNotFound},
+		{name: "database down", as: "user", method: "DELETE", target: "/user/account", body: del(testPassword), setup: dbDown("jobs"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestPurgeAccountJob(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields bson.M
+		erased bool
+	}{
+		{"due", bson.M{"deleted_at": clock.Now().Add(-time.Hour), "purge_at": clock.Now().Add(-time.Minute)}, true},
+		{"postponed", bson.M{"deleted_at": clock.Now().Add(-time.Hour), "purge_at": clock.Now().Add(time.Hour)}, false},
+		{"restored", bson.M{}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			if len(tc.fields) > 0 {
+				setUser(t, f, tc.fields)
+			}
+			payload, _ := bson.Marshal(userJob{UserID: f.user.user.ID})
+			if err := purgeAccountJob(testConfig, notifier.New(testConfig))(context.Background(), payload); err != nil {
+				t.Fatal(err)
+			}
+			erased := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_hash": f.user.user.EmailHash}) == 0
+			certified := f.srv.Count("deletion_certificates", bson.M{"requested_by": selfServiceActor}) == 1
+			if erased != tc.erased || certified != tc.erased {
+				t.Fatalf("erased %v with certificate %v, want %v", erased, certified, tc.erased)
+			}
+		})
+	}
+}
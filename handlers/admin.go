@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,10 +13,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
 	"golang-backend/config"
-	"golang-backend/database"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
+	"golang-backend/emailcheck"
+	"golang-backend/keys"
+	"golang-backend/middleware"
 	"golang-backend/models"
+	"golang-backend/permissions"
+	"golang-backend/repository"
+	"golang-backend/rolegrants"
+	"golang-backend/tenant"
 	"golang-backend/utils"
 )
 
@@ -25,7 +36,11 @@ type ListUsersRequest struct {
 	Limit int `json:"limit,omitempty"`
 }
 
+//go:generate easyjson -build_tags easyjson -no_std_marshalers admin.go
+
 // ListUsersResponse represents the response for listing users
+//
+//easyjson:json
 type ListUsersResponse struct {
 	Users      []UserResponse `json:"users"`
 	Total      int            `json:"total"`
@@ -35,12 +50,18 @@ type ListUsersResponse struct {
 }
 
 // UserResponse represents a user in the response
+//
+//easyjson:json
 type UserResponse struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Role        string    `json:"role"`
+	Tags        []string  `json:"tags,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// CustomFields are the values of the deployment's custom fields
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty"`
 }
 
 // DeleteUserRequest represents the request for deleting a user
@@ -48,19 +69,40 @@ type DeleteUserRequest struct {
 	UserID string `json:"user_id"`
 }
 
+// DeleteUserResponse represents the response for deleting a user
+type DeleteUserResponse struct {
+	Message string           `json:"message"`
+	Undo    *models.UndoInfo `json:"undo,omitempty"`
+}
+
 // UpdateUserRoleRequest represents the request for updating user role
 type UpdateUserRoleRequest struct {
 	UserID string `json:"user_id"`
 	Role   string `json:"role"`
+	// ExpiresAt makes the role temporary; the previous role is restored then
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2024-01-16T18:00:00Z"`
+}
+
+// UpdateUserRoleResponse represents the response for updating a user role
+type UpdateUserRoleResponse struct {
+	Message   string           `json:"message"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	Undo      *models.UndoInfo `json:"undo,omitempty"`
 }
 
 // @Summary List all users
-// @Description Get a paginated list of all users (Admin only)
+// @Description Get a paginated list of all users. Pass the name of a view saved at PUT /admin/views/{name} to apply its filter, sort and columns; the other parameters take precedence over the view's (requires users:read)
 // @Tags admin
 // @Accept json
 // @Produce json
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(10)
+// @Param region query string false "Data residency region (defaults to the default region)"
+// @Param tag query []string false "Only users with every one of these tags" collectionFormat(multi)
+// @Param role query string false "Only users with this role" Enums(user, admin)
+// @Param sort query string false "Sort field, descending when prefixed with -" default(-created_at)
+// @Param columns query string false "Comma-separated fields to show besides the id"
+// @Param view query string false "Saved view to apply"
 // @Security BearerAuth
 // @Success 200 {object} ListUsersResponse
 // @Failure 400 {object} ErrorResponse
@@ -68,223 +110,356 @@ type UpdateUserRoleRequest struct {
 // @Failure 403 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /admin/users [get]
-func ListUsers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get user claims from context
-	claims := r.Context().Value("claims").(jwt.MapClaims)
-	userRole := claims["role"].(string)
-
-	if userRole != "admin" {
-		http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
-		return
-	}
+func ListUsers(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get user claims from context
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if !permissions.Grants(permissions.Held(claims), permissions.UsersRead) {
+			http.Error(w, `{"error": "Forbidden: missing permission `+permissions.UsersRead+`"}`, http.StatusForbidden)
+			return
+		}
 
-	// Parse query parameters
-	page := 1
-	limit := 10
+		// Parse query parameters
+		page := 1
+		limit := 10
 
-	if p := r.URL.Query().Get("page"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
-			page = parsed
+		if p := r.URL.Query().Get("page"); p != "" {
+			if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+				page = parsed
+			}
 		}
-	}
 
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
-			limit = parsed
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+				limit = parsed
+			}
 		}
-	}
 
-	skip := (page - 1) * limit
+		skip := (page - 1) * limit
 
-	// Get users from database
-	collection := database.DB.Collection("users")
-	ctx := context.Background()
-
-	// Count total users
-	total, err := collection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, `{"error": "Failed to count users"}`, http.StatusInternalServerError)
-		return
-	}
+		query, ok := requestUserQuery(w, r)
+		if !ok {
+			return
+		}
 
-	// Find users with pagination
-	opts := options.Find().SetSkip(int64(skip)).SetLimit(int64(limit)).SetSort(bson.M{"created_at": -1})
-	cursor, err := collection.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to fetch users"}`, http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
+		// Get users from the requested data residency region. On an organization's
+		// custom domain the list is limited to its members, in its region.
+		filter := query.filter()
+		collection, err := repository.Users(query.Region)
+		if orgID := tenant.OrgID(r); orgID != "" {
+			filter["org_id"] = orgID
+			collection, err = repository.UsersForOrg(r.Context(), orgID)
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Unknown region"}`, http.StatusBadRequest)
+			return
+		}
+		ctx := context.Background()
 
-	var users []models.User
-	if err = cursor.All(ctx, &users); err != nil {
-		http.Error(w, `{"error": "Failed to decode users"}`, http.StatusInternalServerError)
-		return
-	}
+		// Count total users
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to count users"}`, http.StatusInternalServerError)
+			return
+		}
 
-	// Decrypt emails and prepare response
-	var userResponses []UserResponse
-	for _, user := range users {
-		decryptedEmail, err := utils.Decrypt(user.Email, config.Load().EncryptionKey)
+		// Find users with pagination
+		opts := options.Find().SetSkip(int64(skip)).SetLimit(int64(limit)).SetSort(query.Sort).
+			SetProjection(bson.M{"email": 1, "display_name": 1, "role": 1, "tags": 1, "created_at": 1, "updated_at": 1})
+		cursor, err := collection.Find(ctx, filter, opts)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error": "Failed to fetch users"}`, http.StatusInternalServerError)
 			return
 		}
+		defer cursor.Close(ctx)
 
-		userResponses = append(userResponses, UserResponse{
-			ID:        user.ID.Hex(),
-			Email:     decryptedEmail,
-			Role:      user.Role,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-		})
-	}
+		var users []models.User
+		if err = cursor.All(ctx, &users); err != nil {
+			http.Error(w, `{"error": "Failed to decode users"}`, http.StatusInternalServerError)
+			return
+		}
 
-	totalPages := (int(total) + limit - 1) / limit
+		// Decrypt emails and prepare response
+		var userResponses []UserResponse
+		for _, user := range users {
+			decryptedEmail, err := keys.Decrypt(ctx, cfg, user.Email)
+			if errors.Is(err, keys.ErrKeyDestroyed) {
+				// The organization's data was crypto-shredded
+				decryptedEmail = ""
+			} else if err != nil {
+				http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+				return
+			}
+
+			userResponses = append(userResponses, UserResponse{
+				ID:          user.ID.Hex(),
+				Email:       decryptedEmail,
+				DisplayName: user.DisplayName,
+				Role:        user.Role,
+				Tags:        user.Tags,
+				CreatedAt:   user.CreatedAt,
+				UpdatedAt:   user.UpdatedAt,
+			})
+		}
 
-	response := ListUsersResponse{
-		Users:      userResponses,
-		Total:      int(total),
-		Page:       page,
-		Limit:      limit,
-		TotalPages: totalPages,
-	}
+		totalPages := (int(total) + limit - 1) / limit
+
+		response := ListUsersResponse{
+			Users:      userResponses,
+			Total:      int(total),
+			Page:       page,
+			Limit:      limit,
+			TotalPages: totalPages,
+		}
 
-	json.NewEncoder(w).Encode(response)
+		// A view's columns narrow the users down to the fields it shows
+		if len(query.Columns) > 0 {
+			rows := make([]map[string]json.RawMessage, 0, len(userResponses))
+			for _, user := range userResponses {
+				rows = append(rows, pickColumns(user, query.Columns))
+			}
+			json.NewEncoder(w).Encode(struct {
+				ListUsersResponse
+				Users []map[string]json.RawMessage `json:"users"`
+			}{response, rows})
+			return
+		}
+
+		utils.WriteJSON(w, response)
+	}
 }
 
 // @Summary Delete a user
-// @Description Delete a user by ID (Admin only)
+// @Description Delete a user by ID (requires users:delete)
 // @Tags admin
 // @Accept json
 // @Produce json
 // @Param request body DeleteUserRequest true "User deletion request"
 // @Security BearerAuth
-// @Success 200 {object} SuccessResponse
+// @Success 200 {object} DeleteUserResponse
+// @Success 202 {object} ApprovalPendingResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /admin/users/delete [post]
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func DeleteUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get user claims from context
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if !permissions.Grants(permissions.Held(claims), permissions.UsersDelete) {
+			http.Error(w, `{"error": "Forbidden: missing permission `+permissions.UsersDelete+`"}`, http.StatusForbidden)
+			return
+		}
 
-	// Get user claims from context
-	claims := r.Context().Value("claims").(jwt.MapClaims)
-	userRole := claims["role"].(string)
+		var req DeleteUserRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
 
-	if userRole != "admin" {
-		http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
-		return
-	}
+		if req.UserID == "" {
+			http.Error(w, `{"error": "User ID is required"}`, http.StatusBadRequest)
+			return
+		}
 
-	var req DeleteUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
-		return
-	}
+		userID, err := primitive.ObjectIDFromHex(req.UserID)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
 
-	if req.UserID == "" {
-		http.Error(w, `{"error": "User ID is required"}`, http.StatusBadRequest)
-		return
-	}
+		if cfg.ApprovalsEnabled {
+			requestApproval(w, r, cfg, models.ApprovalDeleteUser, req.UserID, nil)
+			return
+		}
 
-	userID, err := primitive.ObjectIDFromHex(req.UserID)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
-		return
+		response, status, msg := deleteUser(cfg, r, userID)
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(response)
 	}
+}
 
-	collection := database.DB.Collection("users")
+// deleteUser removes a user, recording an audit snapshot and an undo operation
+func deleteUser(cfg *config.Config, r *http.Request, userID primitive.ObjectID) (*DeleteUserResponse, int, string) {
 	ctx := context.Background()
+	if status, msg := memberWritable(ctx, userID); status != http.StatusOK {
+		return nil, status, msg
+	}
+	collection, err := repository.LocateUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return nil, http.StatusNotFound, "User not found"
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to locate user"
+	}
 
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": userID})
-	if err != nil {
-		http.Error(w, `{"error": "Failed to delete user"}`, http.StatusInternalServerError)
-		return
+	// Snapshot the document so the deletion can be undone
+	var snapshot bson.M
+	if err := collection.FindOneAndDelete(ctx, bson.M{"_id": userID}).Decode(&snapshot); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, http.StatusNotFound, "User not found"
+		}
+		return nil, http.StatusInternalServerError, "Failed to delete user"
 	}
 
-	if result.DeletedCount == 0 {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
-		return
+	cache.Invalidate(cache.TagUsers)
+	response := &DeleteUserResponse{Message: "User deleted successfully"}
+
+	auditID, err := audit.Record(r, audit.ActionDeleteUser, userID.Hex(), snapshot, nil)
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit deletion of user %s: %v", userID.Hex(), err)
+	} else if undo, err := newUndoOperation(cfg, models.OperationDeleteUser, audit.ActorID(r), auditID, []primitive.ObjectID{userID}); err == nil {
+		response.Undo = undo
 	}
 
-	json.NewEncoder(w).Encode(SuccessResponse{Message: "User deleted successfully"})
+	return response, http.StatusOK, ""
 }
 
 // @Summary Update user role
-// @Description Update a user's role (Admin only)
+// @Description Update a user's role (requires users:role). With expires_at the role is temporary: the previous role is restored when it expires, unless the role was changed again since, and pending expirations are listed at GET /admin/role-grants
 // @Tags admin
 // @Accept json
 // @Produce json
 // @Param request body UpdateUserRoleRequest true "User role update request"
 // @Security BearerAuth
-// @Success 200 {object} SuccessResponse
+// @Success 200 {object} UpdateUserRoleResponse
+// @Success 202 {object} ApprovalPendingResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /admin/users/role [put]
-func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func UpdateUserRole(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get user claims from context
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if !permissions.Grants(permissions.Held(claims), permissions.UsersRole) {
+			http.Error(w, `{"error": "Forbidden: missing permission `+permissions.UsersRole+`"}`, http.StatusForbidden)
+			return
+		}
 
-	// Get user claims from context
-	claims := r.Context().Value("claims").(jwt.MapClaims)
-	userRole := claims["role"].(string)
+		var req UpdateUserRoleRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
 
-	if userRole != "admin" {
-		http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
-		return
-	}
+		if req.UserID == "" || req.Role == "" {
+			http.Error(w, `{"error": "User ID and role are required"}`, http.StatusBadRequest)
+			return
+		}
 
-	var req UpdateUserRoleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
-		return
-	}
+		if req.Role != "user" && req.Role != "admin" {
+			http.Error(w, `{"error": "Invalid role. Must be 'user' or 'admin'"}`, http.StatusBadRequest)
+			return
+		}
 
-	if req.UserID == "" || req.Role == "" {
-		http.Error(w, `{"error": "User ID and role are required"}`, http.StatusBadRequest)
-		return
-	}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(clock.Now()) {
+			http.Error(w, `{"error": "Expiry must be in the future"}`, http.StatusBadRequest)
+			return
+		}
 
-	if req.Role != "user" && req.Role != "admin" {
-		http.Error(w, `{"error": "Invalid role. Must be 'user' or 'admin'"}`, http.StatusBadRequest)
-		return
-	}
+		userID, err := primitive.ObjectIDFromHex(req.UserID)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
 
-	userID, err := primitive.ObjectIDFromHex(req.UserID)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
-		return
+		// Elevating a user to admin needs a second admin when approvals are enabled
+		if cfg.ApprovalsEnabled && req.Role == "admin" {
+			payload := bson.M{"role": req.Role}
+			if req.ExpiresAt != nil {
+				payload["expires_at"] = *req.ExpiresAt
+			}
+			requestApproval(w, r, cfg, models.ApprovalUpdateRole, req.UserID, payload)
+			return
+		}
+
+		response, status, msg := updateUserRole(cfg, r, userID, req.Role, req.ExpiresAt)
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(response)
 	}
+}
 
-	collection := database.DB.Collection("users")
+// updateUserRole changes a user's role, recording an audit snapshot and an
+// undo operation. A role with an expiry is scheduled to revert then.
+func updateUserRole(cfg *config.Config, r *http.Request, userID primitive.ObjectID, role string, expiresAt *time.Time) (*UpdateUserRoleResponse, int, string) {
 	ctx := context.Background()
+	if expiresAt != nil && !expiresAt.After(clock.Now()) {
+		return nil, http.StatusBadRequest, "Expiry must be in the future"
+	}
+	if status, msg := memberWritable(ctx, userID); status != http.StatusOK {
+		return nil, status, msg
+	}
+	collection, err := repository.LocateUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return nil, http.StatusNotFound, "User not found"
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to locate user"
+	}
 
 	update := bson.M{
 		"$set": bson.M{
-			"role":       req.Role,
-			"updated_at": time.Now(),
+			"role":       role,
+			"updated_at": clock.Now(),
 		},
+		"$inc": bson.M{"role_version": 1},
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to update user role"}`, http.StatusInternalServerError)
-		return
+	// Keep the previous role so the change can be undone
+	var before models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{"role": 1})
+	if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update, opts).Decode(&before); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, http.StatusNotFound, "User not found"
+		}
+		return nil, http.StatusInternalServerError, "Failed to update user role"
+	}
+
+	cache.Invalidate(cache.TagUsers)
+	response := &UpdateUserRoleResponse{Message: "User role updated successfully"}
+	after := bson.M{"role": role}
+
+	// A temporary role must not outlive its expiry, so the change is rolled
+	// back when the grant cannot be recorded
+	if expiresAt != nil {
+		if _, err := rolegrants.Grant(ctx, userID, role, before.Role, audit.ActorID(r), *expiresAt); err != nil {
+			correlation.Errorf(r.Context(), "Failed to schedule role expiry of user %s: %v", userID.Hex(), err)
+			rollback := bson.M{"$set": bson.M{"role": before.Role, "updated_at": clock.Now()}, "$inc": bson.M{"role_version": 1}}
+			collection.UpdateOne(ctx, bson.M{"_id": userID}, rollback)
+			cache.Invalidate(cache.TagUsers)
+			return nil, http.StatusInternalServerError, "Failed to schedule role expiry"
+		}
+		response.ExpiresAt = expiresAt
+		after["expires_at"] = *expiresAt
+	} else if err := rolegrants.Cancel(ctx, userID); err != nil {
+		correlation.Errorf(r.Context(), "Failed to cancel role expiry of user %s: %v", userID.Hex(), err)
 	}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
-		return
+	auditID, err := audit.Record(r, audit.ActionUpdateRole, userID.Hex(), bson.M{"role": before.Role}, after)
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit role change of user %s: %v", userID.Hex(), err)
+	} else if undo, err := newUndoOperation(cfg, models.OperationUpdateRole, audit.ActorID(r), auditID, []primitive.ObjectID{userID}); err == nil {
+		response.Undo = undo
 	}
 
-	json.NewEncoder(w).Encode(SuccessResponse{Message: "User role updated successfully"})
+	return response, http.StatusOK, ""
 }
 
 // @Summary Get user profile
@@ -298,53 +473,40 @@ func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
 // @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /user/profile [get]
-func GetUserProfile(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get user claims from context
-	claims := r.Context().Value("claims").(jwt.MapClaims)
-	userIDStr := claims["userID"].(string)
-
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
-		return
-	}
-
-	collection := database.DB.Collection("users")
-	ctx := context.Background()
+func GetUserProfile(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	var user models.User
-	err = collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		profile, err := middleware.Profile(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, middleware.ErrNoSession):
+				http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			case err == mongo.ErrNoDocuments:
+				http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			default:
+				http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+			}
 			return
 		}
-		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
-		return
-	}
 
-	// Decrypt email
-	decryptedEmail, err := utils.Decrypt(user.Email, config.Load().EncryptionKey)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
-		return
-	}
+		response := UserResponse{
+			ID:          profile.ID.Hex(),
+			Email:       profile.Email,
+			DisplayName: profile.DisplayName,
+			Role:        profile.Role,
+			CreatedAt:   profile.CreatedAt,
+			UpdatedAt:   profile.UpdatedAt,
 
-	response := UserResponse{
-		ID:        user.ID.Hex(),
-		Email:     decryptedEmail,
-		Role:      user.Role,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-	}
+			CustomFields: profile.CustomFields,
+		}
 
-	json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(response)
+	}
 }
 
 // @Summary Update user profile
-// @Description Update current user's profile information
+// @Description Update current user's profile information. custom_fields sets the values of the fields listed at GET /user/custom-fields; null clears one. The password is changed at PUT /user/password instead; requests setting password are rejected
 // @Tags user
 // @Accept json
 // @Produce json
@@ -356,88 +518,170 @@ func GetUserProfile(w http.ResponseWriter, r *http.Request) {
 // @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /user/profile [put]
-func UpdateUserProfile(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get user claims from context
-	claims := r.Context().Value("claims").(jwt.MapClaims)
-	userIDStr := claims["userID"].(string)
-
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
-		return
-	}
+func UpdateUserProfile(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	var req UpdateProfileRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
-		return
-	}
-
-	collection := database.DB.Collection("users")
-	ctx := context.Background()
+		// Get user claims from context
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		userIDStr, _ := claims["userID"].(string)
 
-	update := bson.M{
-		"$set": bson.M{
-			"updated_at": time.Now(),
-		},
-	}
-
-	// Update email if provided
-	if req.Email != "" {
-		// Check if email is already taken by another user
-		emailHash := utils.HashEmail(req.Email)
-		cfg := config.Load()
-		encryptedEmail, err := utils.Encrypt(req.Email, cfg.EncryptionKey)
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to encrypt email"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
 			return
 		}
 
-		count, err := collection.CountDocuments(ctx, bson.M{"email_hash": emailHash, "_id": bson.M{"$ne": userID}})
-		if err != nil {
-			http.Error(w, `{"error": "Failed to check email availability"}`, http.StatusInternalServerError)
+		var req UpdateProfileRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		// Changing the password takes the current one
+		if req.Password != "" {
+			http.Error(w, `{"error": "Change the password at PUT /user/password"}`, http.StatusBadRequest)
 			return
 		}
 
-		if count > 0 {
-			http.Error(w, `{"error": "Email already in use"}`, http.StatusConflict)
+		ctx := context.Background()
+		collection, err := repository.LocateUser(ctx, userID)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
 			return
 		}
 
-		update["$set"].(bson.M)["email"] = encryptedEmail
-		update["$set"].(bson.M)["email_hash"] = emailHash
-	}
+		update := bson.M{
+			"$set": bson.M{
+				"updated_at": clock.Now(),
+			},
+		}
+
+		// Encrypted fields use the organization's data key when the user
+		// belongs to one
+		req.Email = utils.NormalizeEmail(req.Email)
+		var current models.User
+		if req.Email != "" || len(req.CustomFields) > 0 {
+			if err := collection.FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(bson.M{"org_id": 1})).Decode(&current); err != nil {
+				if err == mongo.ErrNoDocuments {
+					http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+					return
+				}
+				http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Update email if provided
+		if req.Email != "" {
+			var invalid *emailcheck.Error
+			if err := emailcheck.Check(r.Context(), req.Email); errors.As(err, &invalid) {
+				http.Error(w, `{"error": "`+invalid.Message+`", "code": "`+invalid.Code+`"}`, http.StatusBadRequest)
+				return
+			}
+
+			// Check if email is already taken by another user
+			emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+
+			encryptedEmail, err := keys.Encrypt(ctx, cfg, current.OrgID, req.Email)
+			if err != nil {
+				http.Error(w, `{"error": "Failed to encrypt email"}`, http.StatusInternalServerError)
+				return
+			}
+
+			_, _, err = repository.FindUser(ctx, bson.M{"email_hash": emailHash, "_id": bson.M{"$ne": userID}}, repository.IDOnly)
+			if err != nil && err != mongo.ErrNoDocuments {
+				http.Error(w, `{"error": "Failed to check email availability"}`, http.StatusInternalServerError)
+				return
+			}
+
+			if err == nil {
+				http.Error(w, `{"error": "Email already in use"}`, http.StatusConflict)
+				return
+			}
+
+			update["$set"].(bson.M)["email"] = encryptedEmail
+			update["$set"].(bson.M)["email_hash"] = emailHash
+		}
+
+		// Update display name if provided; names must pass the name filter
+		if req.DisplayName != nil {
+			name, status, msg := checkDisplayName(r.Context(), *req.DisplayName)
+			if status != http.StatusOK {
+				http.Error(w, `{"error": "`+msg+`"}`, status)
+				return
+			}
+			if name == "" {
+				update["$unset"] = bson.M{"display_name": "", "name_flagged": ""}
+			} else {
+				update["$set"].(bson.M)["display_name"] = name
+				update["$set"].(bson.M)["name_flagged"] = false
+			}
+		}
+
+		if req.Locale != "" {
+			locale := normalizeLocale(req.Locale)
+			if locale == "" {
+				http.Error(w, `{"error": "Invalid locale"}`, http.StatusBadRequest)
+				return
+			}
+			update["$set"].(bson.M)["locale"] = locale
+		}
 
-	// Update password if provided
-	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if len(req.CustomFields) > 0 {
+			set, unset, err := customfields.Update(ctx, cfg, current.OrgID, req.CustomFields)
+			var invalid *customfields.ValueError
+			if errors.As(err, &invalid) {
+				msg, _ := json.Marshal(invalid.Error())
+				http.Error(w, `{"error": `+string(msg)+`}`, http.StatusBadRequest)
+				return
+			} else if err != nil {
+				correlation.Errorf(ctx, "Failed to set custom fields of user %s: %v", userIDStr, err)
+				http.Error(w, `{"error": "Failed to update custom fields"}`, http.StatusInternalServerError)
+				return
+			}
+			for path, value := range set {
+				update["$set"].(bson.M)[path] = value
+			}
+			if len(unset) > 0 {
+				if update["$unset"] == nil {
+					update["$unset"] = bson.M{}
+				}
+				for path := range unset {
+					update["$unset"].(bson.M)[path] = ""
+				}
+			}
+		}
+
+		result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to hash password"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error": "Failed to update profile"}`, http.StatusInternalServerError)
 			return
 		}
-		update["$set"].(bson.M)["password"] = string(hashedPassword)
-	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to update profile"}`, http.StatusInternalServerError)
-		return
-	}
+		if result.MatchedCount == 0 {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
-		return
+		cache.Invalidate(cache.TagUsers)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "Profile updated successfully"})
 	}
-
-	json.NewEncoder(w).Encode(SuccessResponse{Message: "Profile updated successfully"})
 }
 
 // UpdateProfileRequest represents the request for updating user profile
 type UpdateProfileRequest struct {
-	Email    string `json:"email,omitempty"`
+	Email string `json:"email,omitempty"`
+	// Deprecated: Password is rejected; use PUT /user/password
 	Password string `json:"password,omitempty"`
+	// DisplayName is left unchanged when omitted and removed when empty
+	DisplayName *string `json:"display_name,omitempty"`
+	// Locale sets the language of emails, e.g. "de" or "pt-br"
+	Locale string `json:"locale,omitempty"`
+	// CustomFields sets custom field values by name; null clears one
+	CustomFields map[string]json.RawMessage `json:"custom_fields,omitempty" swaggertype:"object"`
 }
 
 // SuccessResponse represents a success response
@@ -0,0 +1,86 @@
+//go:build easyjson
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mailru/easyjson"
+)
+
+// usersPage is a full page of users as GET /admin/users returns it
+func usersPage(n int) ListUsersResponse {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	page := ListUsersResponse{Total: 1000, Page: 1, Limit: n, TotalPages: 1000 / n}
+	for i := 0; i < n; i++ {
+		page.Users = append(page.Users, UserResponse{
+			ID:          fmt.Sprintf("65f1c0ffee%014d", i),
+			Email:       fmt.Sprintf("user%d@example.com", i),
+			DisplayName: "Jane <Doe> & \"Co\"",
+			Role:        "user",
+			Tags:        []string{"beta", "vip"},
+			CreatedAt:   created,
+			UpdatedAt:   created.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return page
+}
+
+func TestEasyJSONMatchesEncodingJSON(t *testing.T) {
+	full := usersPage(3)
+	full.Users[0].CustomFields = map[string]interface{}{"plan": "pro"}
+	full.Users[1].CustomFields = map[string]interface{}{"plan": "free", "seats": 3, "sso": true}
+
+	for name, v := range map[string]ListUsersResponse{
+		"empty":   {Page: 1, Limit: 20},
+		"no rows": {Users: []UserResponse{}, Page: 1, Limit: 20},
+		"minimal": {Users: []UserResponse{{ID: "1"}}, Total: 1},
+		"full":    full,
+	} {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := easyjson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(got, want) {
+			continue
+		}
+		// Custom field keys come out in map order, so compare the values
+		var gotValue, wantValue interface{}
+		if err := json.Unmarshal(got, &gotValue); err != nil {
+			t.Fatalf("%s: easyjson wrote invalid JSON: %v", name, err)
+		}
+		json.Unmarshal(want, &wantValue)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("%s:\neasyjson      %s\nencoding/json %s", name, got, want)
+		}
+	}
+}
+
+func BenchmarkListUsersResponse(b *testing.B) {
+	page := usersPage(100)
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(page); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("easyjson", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := easyjson.Marshal(page); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
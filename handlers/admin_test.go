@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/middleware"
+	"golang-backend/permissions"
+	"golang-backend/routes"
+)
+
+func TestListUsers(t *testing.T) {
+	runCases(t, ListUsers(testConfig), []handlerCase{
+		{
+			name: "lists users", as: "admin", method: "GET", target: "/admin/users?limit=1",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var page ListUsersResponse
+				json.Unmarshal(rec.Body.Bytes(), &page)
+				if page.Total != 2 || page.TotalPages != 2 || len(page.Users) != 1 || page.Users[0].Email == "" {
+					t.Fatalf("got %+v", page)
+				}
+			},
+		},
+		{name: "filters by role", as: "admin", method: "GET", target: "/admin/users?role=user", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var page ListUsersResponse
+				json.Unmarshal(rec.Body.Bytes(), &page)
+				if page.Total != 1 || page.Users[0].Email != "user@example.com" {
+					t.Fatalf("got %+v", page)
+				}
+			},
+		},
+		{name: "unknown region", as: "admin", method: "GET", target: "/admin/users?region=mars", status: http.StatusBadRequest},
+		{name: "unknown view", as: "admin", method: "GET", target: "/admin/users?view=missing", status: http.StatusNotFound},
+		{name: "not an admin", as: "user", method: "GET", target: "/admin/users", status: http.StatusForbidden},
+		{name: "role granted users:read", as: "user", method: "GET", target: "/admin/users", setup: grant(permissions.UsersRead), status: http.StatusOK},
+		{name: "role granted other permissions", as: "user", method: "GET", target: "/admin/users", setup: grant(permissions.UsersTag), status: http.StatusForbidden},
+		{name: "no session", as: "guest", method: "GET", target: "/admin/users", status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	runCases(t, DeleteUser(testConfig), []handlerCase{
+		{
+			name: "deletes the user", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{user}"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 0 {
+					t.Fatal("user still stored")
+				}
+				var resp DeleteUserResponse
+				if json.Unmarshal(rec.Body.Bytes(), &resp); resp.Undo == nil {
+					t.Fatalf("no undo token in %s", rec.Body)
+				}
+			},
+		},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/users/delete", body: `{`, status: http.StatusBadRequest},
+		{name: "missing user ID", as: "admin", method: "POST", target: "/admin/users/delete", body: `{}`, status: http.StatusBadRequest},
+		{name: "malformed user ID", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"42"}`, status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{missing}"}`, status: http.StatusNotFound},
+		{
+			name: "not an admin", as: "user", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{user}"}`,
+			status: http.StatusForbidden,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 1 {
+					t.Fatal("user was deleted")
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{user}"}`, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	runCases(t, UpdateUserRole(testConfig), []handlerCase{
+		{
+			name: "changes the role", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "role": "admin", "role_version": 1}); n != 1 {
+					t.Fatal("role or role version not updated")
+				}
+			},
+		},
+		{name: "missing role", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}"}`, status: http.StatusBadRequest},
+		{name: "unknown role", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"owner"}`, status: http.StatusBadRequest},
+		{name: "expiry in the past", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin","expires_at":"2000-01-01T00:00:00Z"}`, status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{missing}","role":"admin"}`, status: http.StatusNotFound},
+		{name: "not an admin", as: "user", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin"}`, status: http.StatusForbidden},
+		{name: "role granted users:role", as: "user", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin"}`, setup: grant(permissions.UsersRole), status: http.StatusOK},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin"}`, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestGetUserProfile(t *testing.T) {
+	runCases(t, GetUserProfile(testConfig), []handlerCase{
+		{
+			name: "returns the profile", as: "user", method: "GET", target: "/user/profile",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var profile UserResponse
+				json.Unmarshal(rec.Body.Bytes(), &profile)
+				if profile.ID != f.user.user.ID.Hex() || profile.Email != "user@example.com" || profile.Role != "user" {
+					t.Fatalf("got %+v", profile)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "GET", target: "/user/profile", status: http.StatusUnauthorized},
+		{
+			name: "deleted after sign-in", as: "user", method: "GET", target: "/user/profile",
+			setup: userDeleted, status: http.StatusNotFound,
+		},
+		{name: "database down", as: "user", method: "GET", target: "/user/profile", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUpdateUserProfile(t *testing.T) {
+	runCases(t, UpdateUserProfile(testConfig), []handlerCase{
+		{
+			name: "changes the email", as: "user", method: "PUT", target: "/user/profile", body: `{"email":"New@Example.com"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				hash := f.user.user.EmailHash
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_hash": bson.M{"$ne": hash}}); n != 1 {
+					t.Fatal("email not updated")
+				}
+			},
+		},
+		{name: "malformed body", as: "user", method: "PUT", target: "/user/profile", body: `{"email":`, status: http.StatusBadRequest},
+		{name: "sets the password", as: "user", method: "PUT", target: "/user/profile", body: `{"password":"hunter2hunter2"}`, status: http.StatusBadRequest},
+		{name: "invalid email", as: "user", method: "PUT", target: "/user/profile", body: `{"email":"not-an-address"}`, status: http.StatusBadRequest},
+		{name: "email taken", as: "user", method: "PUT", target: "/user/profile", body: `{"email":"admin@example.com"}`, status: http.StatusConflict},
+		{
+			name: "deleted after sign-in", as: "user", method: "PUT", target: "/user/profile", body: `{"email":"new@example.com"}`,
+			setup: userDeleted, status: http.StatusNotFound,
+		},
+		{name: "database down", as: "user", method: "PUT", target: "/user/profile", body: `{"email":"new@example.com"}`, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+// TestAdminRoutePermissions serves ListUsers as main does, behind the admin
+// group and users:read, so a role's permissions decide access rather than
+// its name
+func TestAdminRoutePermissions(t *testing.T) {
+	r := mux.NewRouter()
+	admin := routes.Group(r, testConfig, routes.Admin, "/admin")
+	admin.Handle("/users", middleware.RequirePermission(permissions.UsersRead)(ListUsers(testConfig))).Methods("GET")
+
+	for _, tc := range []struct {
+		name   string
+		as     string
+		perms  []string
+		status int
+	}{
+		{"admin", "admin", nil, http.StatusOK},
+		{"user", "user", nil, http.StatusForbidden},
+		{"user granted users:read", "user", []string{permissions.UsersRead}, http.StatusOK},
+		{"user granted every users permission", "user", []string{"users:*"}, http.StatusOK},
+		{"user granted other permissions", "user", []string{permissions.ReportsRead}, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			s := f.admin
+			if tc.as == "user" {
+				s = f.user
+			}
+			if tc.perms != nil {
+				grant(tc.perms...)(t, f)
+			}
+			rec := request(r, "GET", "/admin/users", s.token, "")
+			if rec.Code != tc.status {
+				t.Fatalf("got %d %s, want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tc.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/analytics"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+)
+
+// IngestEventsRequest is a batch of client analytics events
+type IngestEventsRequest struct {
+	Events []analytics.Input `json:"events"`
+}
+
+// RejectedEvent explains why an event of a batch was not accepted
+type RejectedEvent struct {
+	Index int    `json:"index" example:"2"`
+	Error string `json:"error" example:"name must be 1-64 lowercase letters, digits or _.:- starting with a letter"`
+}
+
+// IngestEventsResponse reports how much of a batch was accepted
+type IngestEventsResponse struct {
+	Accepted int             `json:"accepted" example:"24"`
+	Rejected []RejectedEvent `json:"rejected,omitempty"`
+}
+
+// @Summary Send analytics events
+// @Description Send a batch of client analytics events. Each event needs a lowercase name such as "page.viewed" and may carry up to 50 string, number, boolean or null properties. Invalid events are reported and skipped while the rest of the batch is accepted. A valid bearer token attributes the events to its user; otherwise they are anonymous. Batches are limited per user or IP (ANALYTICS_RATE_LIMIT per minute), in size (ANALYTICS_MAX_BATCH events, ANALYTICS_MAX_BODY_BYTES)
+// @Tags analytics
+// @Accept json
+// @Produce json
+// @Param request body IngestEventsRequest true "Events"
+// @Success 202 {object} IngestEventsResponse
+// @Failure 400 {object} IngestEventsResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /events [post]
+func IngestEvents(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, orgID := eventSender(r)
+		client := userID
+		if client == "" {
+			client = audit.ClientIP(r)
+		}
+		if ok, retryAfter := analytics.Allow(client); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, `{"error": "Too many event batches"}`, http.StatusTooManyRequests)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.AnalyticsMaxBodyBytes)
+		var req IngestEventsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf(`{"error": "Request body exceeds %d bytes"}`, cfg.AnalyticsMaxBodyBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		if len(req.Events) == 0 {
+			http.Error(w, `{"error": "events is required"}`, http.StatusBadRequest)
+			return
+		}
+		if len(req.Events) > cfg.AnalyticsMaxBatch {
+			http.Error(w, fmt.Sprintf(`{"error": "At most %d events per batch"}`, cfg.AnalyticsMaxBatch), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		now := clock.Now()
+		userAgent := r.UserAgent()
+		if len(userAgent) > 256 {
+			userAgent = userAgent[:256]
+		}
+		events := make([]analytics.Event, 0, len(req.Events))
+		var rejected []RejectedEvent
+		for i, in := range req.Events {
+			if err := analytics.Validate(in); err != nil {
+				rejected = append(rejected, RejectedEvent{Index: i, Error: err.Error()})
+				continue
+			}
+			timestamp := now
+			if in.Timestamp != nil {
+				timestamp = *in.Timestamp
+			}
+			events = append(events, analytics.Event{
+				ID:          primitive.NewObjectID(),
+				Name:        in.Name,
+				Timestamp:   timestamp,
+				ReceivedAt:  now,
+				AnonymousID: in.AnonymousID,
+				SessionID:   in.SessionID,
+				UserID:      userID,
+				OrgID:       orgID,
+				UserAgent:   userAgent,
+				Properties:  in.Properties,
+			})
+		}
+		analytics.Reject(len(rejected))
+
+		if len(events) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(IngestEventsResponse{Rejected: rejected})
+			return
+		}
+		if err := analytics.Enqueue(events); err != nil {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, `{"error": "Event buffer is full, retry later"}`, http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(IngestEventsResponse{Accepted: len(events), Rejected: rejected})
+	}
+}
+
+// eventSender returns the user and organization of a valid bearer token, if
+// any. The endpoint is public, so an invalid token is ignored rather than
+// refused.
+func eventSender(r *http.Request) (string, string) {
+	orgID := tenant.OrgID(r)
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		return "", orgID
+	}
+	token, err := tokens.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return "", orgID
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["userID"].(string)
+	if userID == "" {
+		// Minimal tokens only name the user
+		userID, _ = claims["sub"].(string)
+	}
+	if tokenOrg, ok := claims["orgID"].(string); ok && orgID == "" {
+		orgID = tokenOrg
+	}
+	return userID, orgID
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-backend/analytics"
+)
+
+func TestIngestEvents(t *testing.T) {
+	batch := func(events ...string) string { return `{"events":[` + strings.Join(events, ",") + `]}` }
+	var accepted int64
+	accepts := func(n int, rejected ...int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp IngestEventsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Accepted != n || len(resp.Rejected) != len(rejected) {
+				t.Fatalf("got %+v, want %d accepted and %d rejected", resp, n, len(rejected))
+			}
+			for i, index := range rejected {
+				if resp.Rejected[i].Index != index || resp.Rejected[i].Error == "" {
+					t.Fatalf("rejected %+v, want index %d", resp.Rejected[i], index)
+				}
+			}
+			if got := analytics.Snapshot().Accepted - accepted; got != int64(n) {
+				t.Fatalf("buffered %d events, want %d", got, n)
+			}
+		}
+	}
+	countAccepted := func(t *testing.T, f *fixture) { accepted = analytics.Snapshot().Accepted }
+	rateLimited := func(t *testing.T, f *fixture) {
+		for ok, _ := analytics.Allow(f.user.user.ID.Hex()); ok; ok, _ = analytics.Allow(f.user.user.ID.Hex()) {
+		}
+	}
+	tooMany := make([]string, testConfig.AnalyticsMaxBatch+1)
+	for i := range tooMany {
+		tooMany[i] = `{"name":"page.viewed"}`
+	}
+
+	runCases(t, IngestEvents(testConfig), []handlerCase{
+		{
+			name: "anonymous events", method: "POST", target: "/events",
+			body:   batch(`{"name":"page.viewed","anonymous_id":"a1","properties":{"path":"/pricing"}}`, `{"name":"signup.started"}`),
+			setup:  countAccepted,
+			status: http.StatusAccepted, check: accepts(2),
+		},
+		{
+			name: "events of a user", as: "user", method: "POST", target: "/events",
+			body:   batch(`{"name":"page.viewed"}`),
+			setup:  countAccepted,
+			status: http.StatusAccepted, check: accepts(1),
+		},
+		{
+			name: "invalid events skipped", method: "POST", target: "/events",
+			body:   batch(`{"name":"page.viewed"}`, `{"name":"Page Viewed"}`, `{"name":"cart.updated","properties":{"items":[1]}}`),
+			setup:  countAccepted,
+			status: http.StatusAccepted, check: accepts(1, 1, 2),
+		},
+		{
+			name: "every event invalid", method: "POST", target: "/events",
+			body:   batch(`{"name":""}`, `{"name":"page.viewed","timestamp":"2001-01-01T00:00:00Z"}`),
+			setup:  countAccepted,
+			status: http.StatusBadRequest, check: accepts(0, 0, 1),
+		},
+		{name: "no events", method: "POST", target: "/events", body: batch(), status: http.StatusBadRequest},
+		{name: "malformed body", method: "POST", target: "/events", body: `{"events":{}}`, status: http.StatusBadRequest},
+		{name: "too many events", method: "POST", target: "/events", body: batch(tooMany...), status: http.StatusRequestEntityTooLarge},
+		{
+			name: "body too large", method: "POST", target: "/events",
+			body:   batch(`{"name":"page.viewed","properties":{"path":"` + strings.Repeat("x", int(testConfig.AnalyticsMaxBodyBytes)) + `"}}`),
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "rate limited", as: "user", method: "POST", target: "/events", body: batch(`{"name":"page.viewed"}`),
+			setup:  rateLimited,
+			status: http.StatusTooManyRequests,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Fatal("no Retry-After")
+				}
+			},
+		},
+	})
+}
+
+func TestEventSender(t *testing.T) {
+	f := newFixture(t)
+	cases := []struct {
+		name, token, want string
+	}{
+		{"session token", f.user.token, f.user.user.ID.Hex()},
+		{"invalid token", "not-a-token", ""},
+		{"no token", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/events", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if userID, _ := eventSender(req); userID != tc.want {
+				t.Fatalf("got user %q, want %q", userID, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/apikeys"
+	"golang-backend/clock"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// CreateAPIKeyRequest represents the request for creating an API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" example:"CI pipeline"`
+	Scopes []string `json:"scopes" example:"profile:read"`
+	// RequireSignature makes the key only accept HMAC signed requests
+	RequireSignature bool `json:"require_signature,omitempty" example:"false"`
+}
+
+// CreateAPIKeyResponse returns the raw key and, for keys requiring
+// signatures, the signing secret; neither can be retrieved again
+type CreateAPIKeyResponse struct {
+	Key           string        `json:"key"`
+	SigningSecret string        `json:"signing_secret,omitempty"`
+	APIKey        models.APIKey `json:"api_key"`
+}
+
+// ListAPIKeysResponse represents the response for listing API keys
+type ListAPIKeysResponse struct {
+	Keys []models.APIKey `json:"keys"`
+}
+
+// APIScope describes a scope an API key can be granted
+type APIScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// APIKeyUsageResponse represents daily request counts per key
+type APIKeyUsageResponse struct {
+	Usage []models.APIKeyUsage `json:"usage"`
+}
+
+// @Summary Create an API key
+// @Description Create a scoped API key for programmatic access; the key is only returned once. Send it in the X-API-Key header. With require_signature, requests must also be signed with the returned signing secret (see X-Signature).
+// @Tags developer
+// @Accept json
+// @Produce json
+// @Param request body CreateAPIKeyRequest true "Key name and scopes"
+// @Security BearerAuth
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/api-keys [post]
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, `{"error": "Key name is required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, `{"error": "At least one scope is required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if _, ok := models.APIScopes[scope]; !ok {
+			http.Error(w, `{"error": "Unknown scope: `+scope+`"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, raw, secret, err := apikeys.Create(context.Background(), userID, req.Name, req.Scopes, req.RequireSignature)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create API key"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: raw, SigningSecret: secret, APIKey: *key})
+}
+
+// @Summary List API keys
+// @Description List the current user's API keys, including revoked ones
+// @Tags developer
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListAPIKeysResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/api-keys [get]
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	keys, err := apikeys.List(context.Background(), userID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch API keys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListAPIKeysResponse{Keys: keys})
+}
+
+// @Summary Revoke an API key
+// @Description Revoke one of the current user's API keys
+// @Tags developer
+// @Produce json
+// @Param id path string true "API key ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/api-keys/{id} [delete]
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid API key ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := apikeys.Revoke(context.Background(), userID, keyID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "API key not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to revoke API key"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "API key revoked"})
+}
+
+// @Summary API key usage
+// @Description Daily request counts of the current user's API keys
+// @Tags developer
+// @Produce json
+// @Param days query int false "Number of days to include" default(30)
+// @Security BearerAuth
+// @Success 200 {object} APIKeyUsageResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/api-keys/usage [get]
+func GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 || days > 365 {
+		days = 30
+	}
+
+	since := clock.Now().AddDate(0, 0, -(days - 1))
+	usage, err := apikeys.Usage(context.Background(), userID, since)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch usage"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIKeyUsageResponse{Usage: usage})
+}
+
+// @Summary List API key scopes
+// @Description Scopes that can be granted to API keys
+// @Tags developer
+// @Produce json
+// @Success 200 {array} APIScope
+// @Router /developer/scopes [get]
+func ListAPIScopes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	scopes := make([]APIScope, 0, len(models.APIScopes))
+	for name, description := range models.APIScopes {
+		scopes = append(scopes, APIScope{Name: name, Description: description})
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].Name < scopes[j].Name })
+
+	json.NewEncoder(w).Encode(scopes)
+}
+
+// claimsUserID returns the authenticated user's ID from the request claims
+func claimsUserID(r *http.Request) (primitive.ObjectID, bool) {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	id, _ := claims["userID"].(string)
+	userID, err := primitive.ObjectIDFromHex(id)
+	return userID, err == nil
+}
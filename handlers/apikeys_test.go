@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/apikeys"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// apiKey creates an API key of the signed-in user as {key}
+func apiKey(t *testing.T, f *fixture) {
+	t.Helper()
+	key, _, _, err := apikeys.Create(context.Background(), f.user.user.ID, "CI", []string{models.ScopeProfileRead}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("key", key.ID.Hex())
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	created := func(signed bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp CreateAPIKeyResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.APIKey.Name != "CI" || resp.APIKey.UserID != f.user.user.ID || (resp.SigningSecret != "") != signed {
+				t.Fatalf("created %+v", resp)
+			}
+			if n := f.srv.Count("api_keys", bson.M{"key_hash": utils.HashToken(resp.Key), "require_signature": bson.M{"$exists": signed}}); n != 1 {
+				t.Fatal("key not stored hashed")
+			}
+			if key, err := apikeys.Authenticate(context.Background(), resp.Key); err != nil || key.ID != resp.APIKey.ID {
+				t.Fatalf("created key does not authenticate: %v", err)
+			}
+		}
+	}
+	notCreated := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		if n := f.srv.Count("api_keys", bson.M{}); n != 0 {
+			t.Fatalf("%d keys stored", n)
+		}
+	}
+	body := func(name string, signed bool, scopes ...string) string {
+		return marshal(t, CreateAPIKeyRequest{Name: name, Scopes: scopes, RequireSignature: signed})
+	}
+	runCases(t, http.HandlerFunc(CreateAPIKey), []handlerCase{
+		{name: "plain key", as: "user", method: "POST", target: "/user/api-keys", body: body(" CI ", false, models.ScopeProfileRead), status: http.StatusCreated, check: created(false)},
+		{name: "signed key", as: "user", method: "POST", target: "/user/api-keys", body: body("CI", true, models.ScopeFilesRead, models.ScopeUploadsWrite), status: http.StatusCreated, check: created(true)},
+		{name: "no name", as: "user", method: "POST", target: "/user/api-keys", body: body(" ", false, models.ScopeProfileRead), status: http.StatusBadRequest, check: notCreated},
+		{name: "no scopes", as: "user", method: "POST", target: "/user/api-keys", body: body("CI", false), status: http.StatusBadRequest, check: notCreated},
+		{name: "unknown scope", as: "user", method: "POST", target: "/user/api-keys", body: body("CI", false, models.ScopeProfileRead, "admin:all"), status: http.StatusBadRequest, check: notCreated},
+		{name: "malformed body", as: "user", method: "POST", target: "/user/api-keys", body: `{"name":1}`, status: http.StatusBadRequest},
+		{name: "no session", as: "guest", method: "POST", target: "/user/api-keys", body: body("CI", false, models.ScopeProfileRead), status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "POST", target: "/user/api-keys", body: body("CI", false, models.ScopeProfileRead), setup: dbDown("api_keys"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListAPIKeys(t *testing.T) {
+	runCases(t, http.HandlerFunc(ListAPIKeys), []handlerCase{
+		{
+			name: "own keys", as: "user", method: "GET", target: "/user/api-keys",
+			setup: setups(apiKey, func(t *testing.T, f *fixture) {
+				if _, _, _, err := apikeys.Create(context.Background(), f.admin.user.ID, "Admin", []string{models.ScopeProfileRead}, false); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ListAPIKeysResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Keys) != 1 || resp.Keys[0].ID.Hex() != f.vars["key"] {
+					t.Fatalf("got %+v, want the user's key", resp.Keys)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "GET", target: "/user/api-keys", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "GET", target: "/user/api-keys", setup: dbDown("api_keys"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	h := route("/user/api-keys/{id}", http.HandlerFunc(RevokeAPIKey))
+	runCases(t, h, []handlerCase{
+		{
+			name: "revokes", as: "user", method: "DELETE", target: "/user/api-keys/{key}",
+			setup:  apiKey,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				id, _ := primitive.ObjectIDFromHex(f.vars["key"])
+				if n := f.srv.Count("api_keys", bson.M{"_id": id, "revoked_at": bson.M{"$ne": nil}}); n != 1 {
+					t.Fatal("key not revoked")
+				}
+			},
+		},
+		{
+			name: "already revoked", as: "user", method: "DELETE", target: "/user/api-keys/{key}",
+			setup: setups(apiKey, func(t *testing.T, f *fixture) {
+				id, _ := primitive.ObjectIDFromHex(f.vars["key"])
+				if err := apikeys.Revoke(context.Background(), f.user.user.ID, id); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusNotFound,
+		},
+		{
+			name: "key of another user", as: "admin", method: "DELETE", target: "/user/api-keys/{key}",
+			setup:  apiKey,
+			status: http.StatusNotFound,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("api_keys", bson.M{"revoked_at": bson.M{"$ne": nil}}); n != 0 {
+					t.Fatal("key revoked")
+				}
+			},
+		},
+		{name: "invalid ID", as: "user", method: "DELETE", target: "/user/api-keys/nope", status: http.StatusBadRequest},
+		{name: "no session", as: "guest", method: "DELETE", target: "/user/api-keys/{missing}", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "DELETE", target: "/user/api-keys/{key}", setup: setups(apiKey, dbDown("api_keys")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestGetAPIKeyUsage(t *testing.T) {
+	usage := func(t *testing.T, f *fixture) {
+		t.Helper()
+		keyID := primitive.NewObjectID()
+		for _, doc := range []models.APIKeyUsage{
+			{KeyID: keyID, UserID: f.user.user.ID, Day: clock.Now().UTC().Format("2006-01-02"), Count: 3},
+			{KeyID: keyID, UserID: f.user.user.ID, Day: clock.Now().AddDate(0, 0, -40).UTC().Format("2006-01-02"), Count: 5},
+			{KeyID: primitive.NewObjectID(), UserID: f.admin.user.ID, Day: clock.Now().UTC().Format("2006-01-02"), Count: 7},
+		} {
+			if _, err := database.DB.Collection("api_key_usage").InsertOne(context.Background(), doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	days := func(want ...int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp APIKeyUsageResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Usage) != len(want) {
+				t.Fatalf("got %+v, want counts %v", resp.Usage, want)
+			}
+			for i, count := range want {
+				if resp.Usage[i].Count != count {
+					t.Fatalf("got %+v, want counts %v", resp.Usage, want)
+				}
+			}
+		}
+	}
+	h := http.HandlerFunc(GetAPIKeyUsage)
+	runCases(t, h, []handlerCase{
+		{name: "last 30 days", as: "user", method: "GET", target: "/user/api-keys/usage", setup: usage, status: http.StatusOK, check: days(3)},
+		{name: "last 60 days", as: "user", method: "GET", target: "/user/api-keys/usage?days=60", setup: usage, status: http.StatusOK, check: days(5, 3)},
+		{name: "days out of range", as: "user", method: "GET", target: "/user/api-keys/usage?days=1000", setup: usage, status: http.StatusOK, check: days(3)},
+		{name: "no session", as: "guest", method: "GET", target: "/user/api-keys/usage", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "GET", target: "/user/api-keys/usage", setup: dbDown("api_key_usage"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListAPIScopes(t *testing.T) {
+	rec := request(http.HandlerFunc(ListAPIScopes), "GET", "/developer/scopes", "", "")
+	var scopes []APIScope
+	json.Unmarshal(rec.Body.Bytes(), &scopes)
+	if rec.Code != http.StatusOK || len(scopes) != len(models.APIScopes) {
+		t.Fatalf("got %d %+v", rec.Code, scopes)
+	}
+	for i, scope := range scopes {
+		if scope.Description != models.APIScopes[scope.Name] || i > 0 && scopes[i-1].Name >= scope.Name {
+			t.Fatalf("got %+v, want every scope sorted by name", scopes)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// ApprovalPendingResponse is returned when an action was queued for approval
+type ApprovalPendingResponse struct {
+	Message    string    `json:"message"`
+	ApprovalID string    `json:"approval_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ListApprovalsResponse represents the response for listing approvals
+type ListApprovalsResponse struct {
+	Approvals []models.Approval `json:"approvals"`
+}
+
+// requestApproval stores a pending approval for a sensitive action and writes a 202 response
+func requestApproval(w http.ResponseWriter, r *http.Request, cfg *config.Config, action, targetID string, payload bson.M) {
+	now := clock.Now()
+	approval := models.Approval{
+		ID:          clock.NewID(),
+		Action:      action,
+		TargetID:    targetID,
+		Payload:     payload,
+		Status:      models.ApprovalPending,
+		RequestedBy: audit.ActorID(r),
+		ExpiresAt:   now.Add(cfg.ApprovalTTL),
+		CreatedAt:   now,
+	}
+
+	if _, err := database.DB.Collection("approvals").InsertOne(context.Background(), approval); err != nil {
+		http.Error(w, `{"error": "Failed to create approval request"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionApprovalRequest, approval.ID.Hex(), nil, bson.M{"action": action, "target_id": targetID, "payload": payload}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit approval request %s: %v", approval.ID.Hex(), err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ApprovalPendingResponse{
+		Message:    "Action requires approval by another admin",
+		ApprovalID: approval.ID.Hex(),
+		ExpiresAt:  approval.ExpiresAt,
+	})
+}
+
+// @Summary List approval requests
+// @Description List admin actions waiting for (or decided by) a second admin (Admin only)
+// @Tags admin
+// @Produce json
+// @Param status query string false "Filter by status (pending, approved, rejected, expired)" default(pending)
+// @Security BearerAuth
+// @Success 200 {object} ListApprovalsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/approvals [get]
+func ListApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	collection := database.DB.Collection("approvals")
+	ctx := context.Background()
+
+	// Mark stale requests as expired before listing
+	now := clock.Now()
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"status": models.ApprovalPending, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": models.ApprovalExpired}})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update approvals"}`, http.StatusInternalServerError)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ApprovalPending
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(100)
+	cursor, err := collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch approvals"}`, http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	approvals := []models.Approval{}
+	if err := cursor.All(ctx, &approvals); err != nil {
+		http.Error(w, `{"error": "Failed to decode approvals"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListApprovalsResponse{Approvals: approvals})
+}
+
+// @Summary Approve a pending action
+// @Description Approve and execute an action requested by another admin (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Approval ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/approvals/{id}/approve [post]
+func ApproveApproval(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		approval, status, msg := decideApproval(r, models.ApprovalApproved)
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		targetID, err := primitive.ObjectIDFromHex(approval.TargetID)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
+
+		var response interface{}
+		switch approval.Action {
+		case models.ApprovalDeleteUser:
+			response, status, msg = deleteUser(cfg, r, targetID)
+		case models.ApprovalUpdateRole:
+			role, _ := approval.Payload["role"].(string)
+			var expiresAt *time.Time
+			if at, ok := approval.Payload["expires_at"].(primitive.DateTime); ok {
+				t := at.Time()
+				expiresAt = &t
+			}
+			response, status, msg = updateUserRole(cfg, r, targetID, role, expiresAt)
+		default:
+			status, msg = http.StatusBadRequest, "Unknown approval action"
+		}
+
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// @Summary Reject a pending action
+// @Description Reject an action requested by another admin (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Approval ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/approvals/{id}/reject [post]
+func RejectApproval(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, status, msg := decideApproval(r, models.ApprovalRejected); status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Approval request rejected"})
+}
+
+// decideApproval atomically moves a pending approval to the given status.
+// The deciding admin must differ from the requester.
+func decideApproval(r *http.Request, decision string) (*models.Approval, int, string) {
+	approvalID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid approval ID format"
+	}
+
+	collection := database.DB.Collection("approvals")
+	ctx := context.Background()
+
+	var approval models.Approval
+	if err := collection.FindOne(ctx, bson.M{"_id": approvalID}).Decode(&approval); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, http.StatusNotFound, "Approval not found"
+		}
+		return nil, http.StatusInternalServerError, "Failed to fetch approval"
+	}
+
+	adminID := audit.ActorID(r)
+	if approval.RequestedBy == adminID {
+		return nil, http.StatusForbidden, "Requests must be decided by a different admin"
+	}
+
+	now := clock.Now()
+	filter := bson.M{"_id": approvalID, "status": models.ApprovalPending, "expires_at": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{"status": decision, "decided_by": adminID, "decided_at": now}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to update approval"
+	}
+	if result.MatchedCount == 0 {
+		return nil, http.StatusConflict, "Approval is no longer pending or has expired"
+	}
+
+	action := audit.ActionApprovalApprove
+	if decision == models.ApprovalRejected {
+		action = audit.ActionApprovalReject
+	}
+	if _, err := audit.Record(r, action, approval.ID.Hex(), nil, bson.M{"action": approval.Action, "target_id": approval.TargetID, "requested_by": approval.RequestedBy}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit approval decision %s: %v", approval.ID.Hex(), err)
+	}
+
+	return &approval, http.StatusOK, ""
+}
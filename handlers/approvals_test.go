@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// approvalsRequired is the test configuration with approvals enabled
+func approvalsRequired() *config.Config {
+	cfg := *testConfig
+	cfg.ApprovalsEnabled = true
+	return &cfg
+}
+
+// pendingApproval stores an approval of action on the signed-in user as
+// {approval}. It is requested by another admin unless edit changes it.
+func pendingApproval(action string, payload bson.M, edit ...func(*testing.T, *fixture, *models.Approval)) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		now := clock.Now()
+		approval := models.Approval{
+			ID:          primitive.NewObjectID(),
+			Action:      action,
+			TargetID:    f.user.user.ID.Hex(),
+			Payload:     payload,
+			Status:      models.ApprovalPending,
+			RequestedBy: primitive.NewObjectID().Hex(),
+			ExpiresAt:   now.Add(time.Hour),
+			CreatedAt:   now,
+		}
+		for _, e := range edit {
+			e(t, f, &approval)
+		}
+		if _, err := database.DB.Collection("approvals").InsertOne(context.Background(), approval); err != nil {
+			t.Fatal(err)
+		}
+		f.set("approval", approval.ID.Hex())
+	}
+}
+
+func requestedByAdmin(t *testing.T, f *fixture, a *models.Approval) {
+	a.RequestedBy = f.admin.user.ID.Hex()
+}
+
+func approvalExpired(t *testing.T, f *fixture, a *models.Approval) {
+	a.ExpiresAt = clock.Now().Add(-time.Minute)
+}
+
+func approvalRejected(t *testing.T, f *fixture, a *models.Approval) {
+	a.Status = models.ApprovalRejected
+}
+
+// checkApproval fails unless {approval} has status, decided by the admin
+// unless it is still pending
+func checkApproval(status string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		id, _ := primitive.ObjectIDFromHex(f.vars["approval"])
+		filter := bson.M{"_id": id, "status": status}
+		if status != models.ApprovalPending {
+			filter["decided_by"] = f.admin.user.ID.Hex()
+		}
+		if n := f.srv.Count("approvals", filter); n != 1 {
+			t.Fatalf("approval not %s", status)
+		}
+	}
+}
+
+func TestRequestApproval(t *testing.T) {
+	pending := func(action string, payload bson.M) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ApprovalPendingResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			id, err := primitive.ObjectIDFromHex(resp.ApprovalID)
+			if err != nil || !resp.ExpiresAt.After(clock.Now()) {
+				t.Fatalf("got %+v", resp)
+			}
+			filter := bson.M{"_id": id, "action": action, "target_id": f.user.user.ID.Hex(), "status": models.ApprovalPending, "requested_by": f.admin.user.ID.Hex()}
+			for key, value := range payload {
+				filter["payload."+key] = value
+			}
+			if n := f.srv.Count("approvals", filter); n != 1 {
+				t.Fatal("approval not stored")
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionApprovalRequest, "target_id": resp.ApprovalID}); n != 1 {
+				t.Fatal("request not audited")
+			}
+			if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "role": "user"}); n != 1 {
+				t.Fatal("action run before approval")
+			}
+		}
+	}
+	cfg := approvalsRequired()
+	t.Run("delete", func(t *testing.T) {
+		runCases(t, DeleteUser(cfg), []handlerCase{
+			{name: "queued", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{user}"}`, status: http.StatusAccepted, check: pending(models.ApprovalDeleteUser, nil)},
+			{name: "database down", as: "admin", method: "POST", target: "/admin/users/delete", body: `{"user_id":"{user}"}`, setup: dbDown("approvals"), status: http.StatusInternalServerError},
+		})
+	})
+	t.Run("role", func(t *testing.T) {
+		runCases(t, UpdateUserRole(cfg), []handlerCase{
+			{name: "admin role queued", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"admin"}`, status: http.StatusAccepted, check: pending(models.ApprovalUpdateRole, bson.M{"role": "admin"})},
+			{
+				name: "user role applied", as: "admin", method: "PUT", target: "/admin/users/role", body: `{"user_id":"{user}","role":"user"}`,
+				status: http.StatusOK,
+				check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+					if n := f.srv.Count("approvals", bson.M{}); n != 0 {
+						t.Fatal("approval requested")
+					}
+				},
+			},
+		})
+	})
+}
+
+func TestListApprovals(t *testing.T) {
+	approvals := setups(
+		pendingApproval(models.ApprovalDeleteUser, nil),
+		pendingApproval(models.ApprovalDeleteUser, nil, approvalExpired),
+		pendingApproval(models.ApprovalDeleteUser, nil, approvalRejected),
+	)
+	listed := func(n int, status string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ListApprovalsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Approvals) != n {
+				t.Fatalf("got %d approvals, want %d", len(resp.Approvals), n)
+			}
+			for _, approval := range resp.Approvals {
+				if approval.Status != status {
+					t.Fatalf("listed %s approval, want %s", approval.Status, status)
+				}
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(ListApprovals), []handlerCase{
+		{name: "pending", as: "admin", method: "GET", target: "/admin/approvals", setup: approvals, status: http.StatusOK, check: listed(1, models.ApprovalPending)},
+		{name: "stale ones expired", as: "admin", method: "GET", target: "/admin/approvals?status=expired", setup: approvals, status: http.StatusOK, check: listed(1, models.ApprovalExpired)},
+		{name: "rejected", as: "admin", method: "GET", target: "/admin/approvals?status=rejected", setup: approvals, status: http.StatusOK, check: listed(1, models.ApprovalRejected)},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/approvals", setup: dbDown("approvals"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestApproveApproval(t *testing.T) {
+	h := route("/admin/approvals/{id}/approve", ApproveApproval(testConfig))
+	roleUntil := primitive.NewDateTimeFromTime(clock.Now().Add(time.Hour))
+	runCases(t, h, []handlerCase{
+		{
+			name: "deletes the user", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve",
+			setup:  pendingApproval(models.ApprovalDeleteUser, nil),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkApproval(models.ApprovalApproved)(t, f, rec)
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 0 {
+					t.Fatal("user not deleted")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionApprovalApprove, "target_id": f.vars["approval"]}); n != 1 {
+					t.Fatal("approval not audited")
+				}
+			},
+		},
+		{
+			name: "grants the role", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve",
+			setup:  pendingApproval(models.ApprovalUpdateRole, bson.M{"role": "admin", "expires_at": roleUntil}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkApproval(models.ApprovalApproved)(t, f, rec)
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "role": "admin"}); n != 1 {
+					t.Fatal("role not granted")
+				}
+				if n := f.srv.Count("role_grants", bson.M{"user_id": f.user.user.ID, "expires_at": roleUntil}); n != 1 {
+					t.Fatal("role expiry not scheduled")
+				}
+			},
+		},
+		{
+			name: "own request", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve",
+			setup:  pendingApproval(models.ApprovalDeleteUser, nil, requestedByAdmin),
+			status: http.StatusForbidden, check: checkApproval(models.ApprovalPending),
+		},
+		{name: "expired", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve", setup: pendingApproval(models.ApprovalDeleteUser, nil, approvalExpired), status: http.StatusConflict},
+		{
+			name: "already decided", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve",
+			setup:  pendingApproval(models.ApprovalDeleteUser, nil, approvalRejected),
+			status: http.StatusConflict,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 1 {
+					t.Fatal("user deleted")
+				}
+			},
+		},
+		{name: "user gone", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve", setup: setups(pendingApproval(models.ApprovalDeleteUser, nil), userDeleted), status: http.StatusNotFound},
+		{name: "unknown action", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve", setup: pendingApproval("user.rename", nil), status: http.StatusBadRequest},
+		{name: "unknown approval", as: "admin", method: "POST", target: "/admin/approvals/{missing}/approve", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/approvals/nope/approve", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/approvals/{approval}/approve", setup: setups(pendingApproval(models.ApprovalDeleteUser, nil), dbDown("approvals")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRejectApproval(t *testing.T) {
+	h := route("/admin/approvals/{id}/reject", http.HandlerFunc(RejectApproval))
+	runCases(t, h, []handlerCase{
+		{
+			name: "rejects", as: "admin", method: "POST", target: "/admin/approvals/{approval}/reject",
+			setup:  pendingApproval(models.ApprovalDeleteUser, nil),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkApproval(models.ApprovalRejected)(t, f, rec)
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 1 {
+					t.Fatal("user deleted")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionApprovalReject, "target_id": f.vars["approval"]}); n != 1 {
+					t.Fatal("rejection not audited")
+				}
+			},
+		},
+		{
+			name: "own request", as: "admin", method: "POST", target: "/admin/approvals/{approval}/reject",
+			setup:  pendingApproval(models.ApprovalDeleteUser, nil, requestedByAdmin),
+			status: http.StatusForbidden, check: checkApproval(models.ApprovalPending),
+		},
+		{name: "expired", as: "admin", method: "POST", target: "/admin/approvals/{approval}/reject", setup: pendingApproval(models.ApprovalDeleteUser, nil, approvalExpired), status: http.StatusConflict},
+		{name: "unknown approval", as: "admin", method: "POST", target: "/admin/approvals/{missing}/reject", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/approvals/nope/reject", status: http.StatusBadRequest},
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// auditEntries chains three audit entries about the signed-in user, after
+// any the fixture wrote
+func auditEntries(t *testing.T, f *fixture) {
+	t.Helper()
+	for _, action := range []string{audit.ActionUpdateRole, audit.ActionSuspendUser, audit.ActionTagUsers} {
+		entry := models.AuditLog{ActorID: f.admin.user.ID.Hex(), Action: action, TargetID: f.user.user.ID.Hex(), IP: "192.0.2.1"}
+		if _, err := audit.Insert(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// auditTampered edits the action of the last entry auditEntries wrote
+func auditTampered(t *testing.T, f *fixture) {
+	t.Helper()
+	filter := bson.M{"action": audit.ActionTagUsers}
+	if _, err := database.DB.Collection("audit_logs").UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"action": audit.ActionDeleteUser}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	verified := func(ok bool, problem string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var report audit.Report
+			json.Unmarshal(rec.Body.Bytes(), &report)
+			if report.OK != ok || report.Checked < 3 || !report.Complete {
+				t.Fatalf("got %+v", report)
+			}
+			if problem != "" && (len(report.Problems) != 1 || report.Problems[0].Kind != problem) {
+				t.Fatalf("got problems %+v, want %s", report.Problems, problem)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionVerifyAuditChain, "after.ok": ok}); n != 1 {
+				t.Fatal("verification not audited")
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(VerifyAuditChain), []handlerCase{
+		{name: "intact chain", as: "admin", method: "POST", target: "/admin/audit/verify", setup: auditEntries, status: http.StatusOK, check: verified(true, "")},
+		{name: "edited entry", as: "admin", method: "POST", target: "/admin/audit/verify", setup: setups(auditEntries, auditTampered), status: http.StatusOK, check: verified(false, audit.ProblemHash)},
+		{
+			name: "one page", as: "admin", method: "POST", target: "/admin/audit/verify?from=2&limit=1",
+			setup:  auditEntries,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var report audit.Report
+				json.Unmarshal(rec.Body.Bytes(), &report)
+				if !report.OK || report.From != 2 || report.To != 2 || report.Checked != 1 || report.Complete {
+					t.Fatalf("got %+v", report)
+				}
+			},
+		},
+		{name: "negative from", as: "admin", method: "POST", target: "/admin/audit/verify?from=-1", status: http.StatusBadRequest},
+		{name: "malformed from", as: "admin", method: "POST", target: "/admin/audit/verify?from=first", status: http.StatusBadRequest},
+		{name: "zero limit", as: "admin", method: "POST", target: "/admin/audit/verify?limit=0", status: http.StatusBadRequest},
+		{name: "limit too high", as: "admin", method: "POST", target: "/admin/audit/verify?limit=1000001", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/audit/verify", setup: dbDown("audit_logs"), status: http.StatusInternalServerError},
+	})
+}
@@ -3,25 +3,48 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/challenge"
+	"golang-backend/clock"
 	"golang-backend/config"
-	"golang-backend/database"
+	"golang-backend/correlation"
+	"golang-backend/emailcheck"
+	"golang-backend/keys"
+	"golang-backend/loginhistory"
 	"golang-backend/models"
+	"golang-backend/permissions"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
 	"golang-backend/utils"
 )
 
 // RegisterRequest represents the request payload for user registration
 type RegisterRequest struct {
-	Email    string `json:"email" example:"user@example.com"`
-	Password string `json:"password" example:"password123"`
-	Role     string `json:"role,omitempty" example:"user"`
+	Email                string `json:"email" example:"user@example.com"`
+	Password             string `json:"password" example:"password123"`
+	AcceptedTermsVersion string `json:"accepted_terms_version" example:"1"`
+	DateOfBirth          string `json:"date_of_birth" example:"1990-04-21"`
+	Region               string `json:"region,omitempty" example:"US"`
+	DisplayName          string `json:"display_name,omitempty" example:"Jane D."`
+	Locale               string `json:"locale,omitempty" example:"de"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+	// CaptchaToken is the CAPTCHA solved on every attempt when the flow is in
+	// CAPTCHA_FLOWS
+	CaptchaToken string `json:"captcha_token,omitempty" example:"10000000-aaaa-bbbb-cccc-000000000001"`
+	// InviteCode is the code of an invitation from /admin/invitations,
+	// required with INVITE_ONLY
+	InviteCode string `json:"invite_code,omitempty" example:"pY2c9KqLx0vR3tWm8ZbN1aHs7dFe4GjU"`
 }
 
 // AdminRegisterRequest represents the request payload for admin user registration
@@ -34,58 +57,145 @@ type AdminRegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email" example:"user@example.com"`
 	Password string `json:"password" example:"password123"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+	// CaptchaToken is the CAPTCHA solved on every attempt when the flow is in
+	// CAPTCHA_FLOWS
+	CaptchaToken string `json:"captcha_token,omitempty" example:"10000000-aaaa-bbbb-cccc-000000000001"`
+	// RememberMe asks for a longer-lived session
+	RememberMe bool `json:"remember_me,omitempty" example:"true"`
 }
 
 // RegisterResponse represents the response for user registration
 type RegisterResponse struct {
 	Message string `json:"message" example:"User registered successfully"`
+	// Waitlisted users can sign in once an admin activates them
+	Waitlisted bool `json:"waitlisted,omitempty" example:"false"`
 }
 
 // LoginResponse represents the response for user login
 type LoginResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	Role  string `json:"role" example:"user"`
+	Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+	Role         string `json:"role" example:"user"`
 }
 
 // AdminLoginRequest represents the request payload for admin login
 type AdminLoginRequest struct {
 	Email    string `json:"email" example:"admin@example.com"`
 	Password string `json:"password" example:"admin123"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+	// CaptchaToken is the CAPTCHA solved on every attempt when the flow is in
+	// CAPTCHA_FLOWS
+	CaptchaToken string `json:"captcha_token,omitempty" example:"10000000-aaaa-bbbb-cccc-000000000001"`
 }
 
 // AdminLoginResponse represents the response for admin login
 type AdminLoginResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	Role  string `json:"role" example:"admin"`
+	Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+	Role         string `json:"role" example:"admin"`
 }
 
 // Register handles user registration
 // @Summary Register a new user
-// @Description Register a new user with email and password
+// @Description Register a new user with email and password. The password must meet the password policy; violations are listed per field. The current terms version must be accepted and the user must meet the minimum age for their region. The user is emailed a link to verify the address. Registrations the signup gate waitlists cannot sign in until an admin activates them. An invite_code registers with the invitation's role and is used up; with INVITE_ONLY one is required
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "User registration data"
 // @Success 200 {object} RegisterResponse
-// @Failure 400 {string} string "Invalid request payload"
-// @Failure 409 {string} string "User already exists"
+// @Failure 400 {object} ValidationErrorResponse "Invalid request payload, or the password does not meet the requirements"
+// @Failure 403 {string} string "Minimum age requirement not met, or an invitation is required"
+// @Failure 409 {string} string "User already exists, unless REGISTRATION_ENUMERATION=silent"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
 // @Failure 500 {string} string "Internal server error"
 // @Router /register [post]
 func Register(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req RegisterRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+
+		// Terms acceptance and age gate
+		minAge, status, msg := checkRegistrationConsent(cfg, req)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
 
-		collection := database.DB.Collection("users")
 		ctx := context.Background()
 
-		// Check if user already exists
-		var existingUser models.User
-		err := collection.FindOne(ctx, bson.M{"email_hash": req.Email}).Decode(&existingUser)
+		// Clients probing for registered addresses are challenged; codes go
+		// to the address being registered
+		clientIP := audit.ClientIP(r)
+		recipient := &models.User{Locale: requestLocale(r, req.Locale), OrgID: tenant.OrgID(r)}
+		codeTo := func(context.Context) (string, *models.User, error) { return req.Email, recipient, nil }
+		if !requireCaptcha(w, r, challenge.FlowRegister, clientIP, req.CaptchaToken) {
+			return
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowRegister, clientIP, req.ChallengeResponse, codeTo) {
+			return
+		}
+
+		invitation, status, msg := checkInvitation(ctx, cfg, req.InviteCode)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+		invited := invitation != nil
+
+		if err := emailcheck.Check(r.Context(), req.Email); err != nil {
+			http.Error(w, emailcheck.Describe(err), http.StatusBadRequest)
+			return
+		}
+
+		displayName, status, msg := checkDisplayName(r.Context(), req.DisplayName)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+		if !checkNewPassword(w, "password", req.Password) {
+			return
+		}
+
+		// Registrations on an organization's custom domain join that
+		// organization. Checked first, so a closed organization answers alike
+		// for taken addresses.
+		orgID := tenant.OrgID(r)
+		if status, err := repository.OrgStatus(ctx, orgID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		} else if status != models.OrgActive {
+			http.Error(w, "Organization is not accepting registrations", http.StatusForbidden)
+			return
+		}
+
+		// Check if user already exists in any region
+		_, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.IDOnly)
 		if err == nil {
+			failChallenge(r, challenge.FlowRegister, clientIP)
+			if cfg.RegistrationEnumeration == EnumerationSilent {
+				// Answered like a new registration, as slowly, and the owner
+				// is told instead
+				utils.HashPassword(req.Password)
+				sendAccountExistsEmail(cfg, req.Email, emailHash)
+				w.Header().Set("Content-Type", "application/json")
+				if gateSignup(r, req.Email, invited, clock.Now()) != nil {
+					json.NewEncoder(w).Encode(RegisterResponse{Message: "User registered and added to the waitlist", Waitlisted: true})
+					return
+				}
+				json.NewEncoder(w).Encode(RegisterResponse{Message: "User registered successfully"})
+				return
+			}
 			http.Error(w, "User already exists", http.StatusConflict)
 			return
 		} else if err != mongo.ErrNoDocuments {
@@ -93,55 +203,113 @@ func Register(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		collection, err := repository.UsersForOrg(ctx, orgID)
 		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// Hash the password
+		hashedPassword, err := utils.HashPassword(req.Password)
+		if errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, "Server busy, please retry")
+			return
+		} else if err != nil {
 			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 			return
 		}
 
 		// Encrypt email
-		encryptedEmail, err := utils.Encrypt(req.Email, cfg.EncryptionKey)
+		encryptedEmail, err := keys.Encrypt(ctx, cfg, orgID, req.Email)
 		if err != nil {
 			http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
 			return
 		}
 
-		// Create email hash for lookup (not encrypted, just hashed for indexing)
-		emailHash := req.Email // For now, using plain email as hash. In production, use proper hashing.
+		// Encrypt date of birth
+		encryptedDOB, err := keys.Encrypt(ctx, cfg, orgID, req.DateOfBirth)
+		if err != nil {
+			http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+			return
+		}
 
-		// Determine role (default to "user" if not specified or invalid)
+		// Only an invitation grants a role other than user
 		role := "user"
-		if req.Role == "admin" {
-			role = "admin"
+		if invited {
+			role = invitation.Role
 		}
 
 		// Create new user
-		now := time.Now()
+		now := clock.Now()
 		user := models.User{
-			ID:        primitive.NewObjectID(),
-			EmailHash: emailHash,
-			Email:     encryptedEmail,
-			Password:  string(hashedPassword),
-			Role:      role,
-			CreatedAt: now,
-			UpdatedAt: now,
+			ID:              clock.NewID(),
+			EmailHash:       emailHash,
+			Email:           encryptedEmail,
+			Password:        hashedPassword,
+			Role:            role,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			DateOfBirth:     encryptedDOB,
+			DisplayName:     displayName,
+			Locale:          requestLocale(r, req.Locale),
+			OrgID:           orgID,
+			TermsVersion:    req.AcceptedTermsVersion,
+			TermsAcceptedAt: &now,
+			EmailUnverified: true,
+			Waitlist:        gateSignup(r, req.Email, invited, now),
+		}
+		if invited {
+			user.InvitationID = &invitation.ID
 		}
 
+		// The invitation is used up first, so concurrent registrations cannot
+		// share it, and released again if the account is not created
+		if status, msg := redeemInvitation(ctx, req.InviteCode, user.ID); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
 		_, err = collection.InsertOne(ctx, user)
 		if err != nil {
+			releaseInvitation(ctx, user)
 			http.Error(w, "Failed to create user", http.StatusInternalServerError)
 			return
 		}
 
+		// Record the consent artifact; without it the signup is not compliant
+		if err := recordConsent(r, user.ID, req.AcceptedTermsVersion, req.Region, minAge, now); err != nil {
+			collection.DeleteOne(ctx, bson.M{"_id": user.ID})
+			releaseInvitation(ctx, user)
+			http.Error(w, "Failed to record consent", http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(cache.TagUsers)
+		resetChallenge(r, challenge.FlowRegister, clientIP)
+		sendVerificationEmail(cfg, req.Email, user)
+
+		// Waitlisted users are told when they are activated instead
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+		if user.Waitlist != nil {
+			json.NewEncoder(w).Encode(RegisterResponse{Message: "User registered and added to the waitlist", Waitlisted: true})
+			return
+		}
+		sendWelcomeEmail(cfg, req.Email, user)
+		json.NewEncoder(w).Encode(RegisterResponse{Message: "User registered successfully"})
 	}
 }
 
+// comparePassword checks a password against a user's hash. With
+// ENUMERATION_HARDENING an empty hash, of an unknown or passwordless account,
+// costs a bcrypt comparison too, so response times do not tell them apart.
+func comparePassword(cfg *config.Config, hash, password string) error {
+	if hash == "" && cfg.EnumerationHardening {
+		return utils.CompareNoPassword(password)
+	}
+	return utils.ComparePassword(hash, password)
+}
+
 // Login handles user login
 // @Summary Login user
-// @Description Login with email and password to get JWT token
+// @Description Login with email and password to get a short-lived JWT and a refresh token for POST /token/refresh. With remember_me the session lasts REMEMBER_ME_REFRESH_TOKEN_TTL instead of REFRESH_TOKEN_TTL
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -149,24 +317,42 @@ func Register(cfg *config.Config) http.HandlerFunc {
 // @Success 200 {object} LoginResponse
 // @Failure 400 {string} string "Invalid request payload"
 // @Failure 401 {string} string "Invalid credentials"
+// @Failure 403 {string} string "Account suspended, waitlisted or email not verified"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
 // @Failure 500 {string} string "Internal server error"
 // @Router /login [post]
 func Login(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req LoginRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
 
-		collection := database.DB.Collection("users")
 		ctx := context.Background()
 
-		// Find user by email hash
-		var user models.User
-		err := collection.FindOne(ctx, bson.M{"email_hash": req.Email}).Decode(&user)
+		// Repeated failures call for a challenge before the password is checked
+		if !requireCaptcha(w, r, challenge.FlowLogin, emailHash, req.CaptchaToken) {
+			return
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowLogin, emailHash, req.ChallengeResponse, accountRecipient(req.Email, emailHash)) {
+			return
+		}
+
+		// Find user by email hash in any region
+		user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.CredentialFields)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
+				// Hardened, unknown accounts cost a password check too
+				if cfg.EnumerationHardening && errors.Is(utils.CompareNoPassword(req.Password), utils.ErrPasswordPoolBusy) {
+					retryLater(w, "Server busy, please retry")
+					return
+				}
+				failChallenge(r, challenge.FlowLogin, emailHash)
+				security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", "unknown account")
 				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			} else {
 				http.Error(w, "Database error", http.StatusInternalServerError)
@@ -175,117 +361,166 @@ func Login(cfg *config.Config) http.HandlerFunc {
 		}
 
 		// Check password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := comparePassword(cfg, user.Password, req.Password); errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, "Server busy, please retry")
+			return
+		} else if err != nil {
+			failChallenge(r, challenge.FlowLogin, emailHash)
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, user.ID.Hex(), "invalid password")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "invalid password")
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		// Decrypt email for JWT
-		decryptedEmail, err := utils.Decrypt(user.Email, cfg.EncryptionKey)
-		if err != nil {
-			http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
+		// On an organization's custom domain only its members can sign in
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			failChallenge(r, challenge.FlowLogin, emailHash)
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, user.ID.Hex(), "not a member of the tenant")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "not a member of the tenant")
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"userID": user.ID.Hex(),
-			"email":  decryptedEmail,
-			"role":   user.Role,
-			"exp":    time.Now().Add(time.Hour * 24).Unix(),
-		})
+		// Suspended accounts cannot sign in
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "account suspended")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+
+		// Members of suspended or deleted organizations cannot sign in
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
+
+		// Optionally, neither can users who have not verified their address
+		if cfg.EmailVerificationRequired && user.EmailUnverified {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "email not verified")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "email not verified")
+			http.Error(w, "Email not verified", http.StatusForbidden)
+			return
+		}
 
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		// Generate the session and refresh tokens
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", req.RememberMe)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
+		resetChallenge(r, challenge.FlowLogin, emailHash)
+		correlation.SetUser(r.Context(), user.ID.Hex())
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, user.ID.Hex(), "")
+		recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, true, "")
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"token": tokenString,
-			"role":  user.Role,
-		})
+		json.NewEncoder(w).Encode(session)
 	}
 }
 
 // AdminRegister handles admin user registration
 // @Summary Register a new admin user
-// @Description Register a new admin user with email and password
+// @Description Create an admin account with email and password. The password must meet the password policy; violations are listed per field. While APPROVALS_ENABLED, admins are refused, since they would skip the approval of the role; register a user and request the role change instead. Outside the API, an admin comes from an admin invitation or, for the first one, a break-glass session. Requires a session elevated with POST /auth/sudo (requires users:role)
 // @Tags admin
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param request body AdminRegisterRequest true "Admin registration data"
-// @Success 200 {object} RegisterResponse
-// @Failure 400 {string} string "Invalid request payload"
-// @Failure 409 {string} string "Admin already exists"
-// @Failure 500 {string} string "Internal server error"
+// @Success 201 {object} RegisterResponse
+// @Failure 400 {object} ValidationErrorResponse "Invalid request payload, or the password does not meet the requirements"
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /admin/register [post]
 func AdminRegister(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if cfg.ApprovalsEnabled {
+			http.Error(w, `{"error": "Admin registration is disabled while approvals are required; register a user and request the role change"}`, http.StatusForbidden)
+			return
+		}
+
 		var req AdminRegisterRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
 			return
 		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+		ctx := r.Context()
 
-		collection := database.DB.Collection("users")
-		ctx := context.Background()
+		if err := emailcheck.Check(ctx, req.Email); err != nil {
+			http.Error(w, `{"error": "`+emailcheck.Describe(err)+`"}`, http.StatusBadRequest)
+			return
+		}
+		if !checkNewPassword(w, "password", req.Password) {
+			return
+		}
 
-		// Check if admin already exists
-		var existingUser models.User
-		err := collection.FindOne(ctx, bson.M{"email_hash": req.Email}).Decode(&existingUser)
+		// Check if the address is taken in any region
+		_, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.IDOnly)
 		if err == nil {
-			http.Error(w, "Admin already exists", http.StatusConflict)
+			http.Error(w, `{"error": "User already exists"}`, http.StatusConflict)
 			return
 		} else if err != mongo.ErrNoDocuments {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
 			return
 		}
 
-		// Hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		// Admins belong to no organization
+		collection, err := repository.UsersForOrg(ctx, "")
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		hashedPassword, err := utils.HashPassword(req.Password)
+		if errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, `{"error": "Server busy, please retry"}`)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to hash password"}`, http.StatusInternalServerError)
 			return
 		}
 
-		// Encrypt email
 		encryptedEmail, err := utils.Encrypt(req.Email, cfg.EncryptionKey)
 		if err != nil {
-			http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+			http.Error(w, `{"error": "Failed to encrypt data"}`, http.StatusInternalServerError)
 			return
 		}
 
-		// Create email hash for lookup
-		emailHash := req.Email
-
-		// Create new admin user
-		now := time.Now()
+		now := clock.Now()
 		user := models.User{
-			ID:        primitive.NewObjectID(),
+			ID:        clock.NewID(),
 			EmailHash: emailHash,
 			Email:     encryptedEmail,
-			Password:  string(hashedPassword),
+			Password:  hashedPassword,
 			Role:      "admin",
 			CreatedAt: now,
 			UpdatedAt: now,
 		}
-
-		_, err = collection.InsertOne(ctx, user)
-		if err != nil {
-			http.Error(w, "Failed to create admin", http.StatusInternalServerError)
+		if _, err := collection.InsertOne(ctx, user); err != nil {
+			http.Error(w, `{"error": "Failed to create admin"}`, http.StatusInternalServerError)
 			return
 		}
+		cache.Invalidate(cache.TagUsers)
+		if _, err := audit.Record(r, audit.ActionCreateAdmin, user.ID.Hex(), nil, bson.M{"role": user.Role}); err != nil {
+			correlation.Errorf(ctx, "Failed to audit admin registration %s: %v", user.ID.Hex(), err)
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Admin registered successfully"})
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(RegisterResponse{Message: "Admin registered successfully"})
 	}
 }
 
 // AdminLogin handles admin login
 // @Summary Admin login
-// @Description Login with admin email and password to get JWT token
+// @Description Login with admin email and password to get a short-lived JWT and a refresh token for POST /token/refresh
 // @Tags admin
 // @Accept json
 // @Produce json
@@ -294,24 +529,41 @@ func AdminRegister(cfg *config.Config) http.HandlerFunc {
 // @Failure 400 {string} string "Invalid request payload"
 // @Failure 401 {string} string "Invalid credentials"
 // @Failure 403 {string} string "Access denied: Admin only"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
 // @Failure 500 {string} string "Internal server error"
 // @Router /admin/login [post]
 func AdminLogin(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req AdminLoginRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
 
-		collection := database.DB.Collection("users")
 		ctx := context.Background()
 
-		// Find user by email hash
-		var user models.User
-		err := collection.FindOne(ctx, bson.M{"email_hash": req.Email}).Decode(&user)
+		// Repeated failures call for a challenge before the password is checked
+		if !requireCaptcha(w, r, challenge.FlowLogin, emailHash, req.CaptchaToken) {
+			return
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowLogin, emailHash, req.ChallengeResponse, accountRecipient(req.Email, emailHash)) {
+			return
+		}
+
+		// Find user by email hash in any region
+		user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.CredentialFields)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
+				// Hardened, unknown accounts cost a password check too
+				if cfg.EnumerationHardening && errors.Is(utils.CompareNoPassword(req.Password), utils.ErrPasswordPoolBusy) {
+					retryLater(w, "Server busy, please retry")
+					return
+				}
+				failChallenge(r, challenge.FlowLogin, emailHash)
+				security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", "unknown account")
 				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			} else {
 				http.Error(w, "Database error", http.StatusInternalServerError)
@@ -319,43 +571,103 @@ func AdminLogin(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Check if user is admin
-		if user.Role != "admin" {
+		// Check if user is admin; hardened, only once the password matched, so
+		// the answer does not tell which addresses have accounts
+		if user.Role != "admin" && !cfg.EnumerationHardening {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "admin login by non-admin")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "admin login by non-admin")
 			http.Error(w, "Access denied: Admin only", http.StatusForbidden)
 			return
 		}
 
 		// Check password
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		if err := comparePassword(cfg, user.Password, req.Password); errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, "Server busy, please retry")
+			return
+		} else if err != nil {
+			failChallenge(r, challenge.FlowLogin, emailHash)
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, user.ID.Hex(), "invalid password")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "invalid password")
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		// Decrypt email for JWT
-		decryptedEmail, err := utils.Decrypt(user.Email, cfg.EncryptionKey)
-		if err != nil {
-			http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
+		if user.Role != "admin" {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "admin login by non-admin")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "admin login by non-admin")
+			http.Error(w, "Access denied: Admin only", http.StatusForbidden)
 			return
 		}
 
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"userID": user.ID.Hex(),
-			"email":  decryptedEmail,
-			"role":   user.Role,
-			"exp":    time.Now().Add(time.Hour * 24).Unix(),
-		})
+		// Suspended accounts cannot sign in
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "account suspended")
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+
+		// Members of suspended or deleted organizations cannot sign in
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
 
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		// Generate the session and refresh tokens
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", false)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
+		resetChallenge(r, challenge.FlowLogin, emailHash)
+		correlation.SetUser(r.Context(), user.ID.Hex())
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, user.ID.Hex(), "")
+		recordLogin(r, user.ID.Hex(), loginhistory.MethodPassword, true, "")
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"token": tokenString,
-			"role":  user.Role,
-		})
+		json.NewEncoder(w).Encode(session)
 	}
 }
+
+// sessionClaims builds the claims of a session token valid for ttl and
+// records its ID. The token carries the permissions of the user's role. With
+// JWT_MINIMAL_CLAIMS the token only names the user and role version, so it
+// holds no personal data; JWTAuthMiddleware loads the rest on each request.
+func sessionClaims(ctx context.Context, cfg *config.Config, user *models.User, client tokens.Client, ttl time.Duration) (jwt.MapClaims, error) {
+	exp := clock.Now().Add(ttl)
+	jti := tokens.NewID()
+	if err := tokens.Issue(ctx, jti, user.ID.Hex(), exp, client); err != nil {
+		return nil, err
+	}
+	if cfg.JWTMinimalClaims {
+		return jwt.MapClaims{"sub": user.ID.Hex(), "rv": user.RoleVersion, "jti": jti, "exp": exp.Unix()}, nil
+	}
+
+	// Decrypt email for JWT
+	email, err := keys.Decrypt(ctx, cfg, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := permissions.Load(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.MapClaims{
+		"userID":      user.ID.Hex(),
+		"email":       email,
+		"role":        user.Role,
+		"permissions": perms,
+		"roleVersion": user.RoleVersion,
+		"orgID":       user.OrgID,
+		"jti":         jti,
+		"exp":         exp.Unix(),
+	}, nil
+}
+
+// retryLater answers 503 with Retry-After when the password hashing pool is saturated
+func retryLater(w http.ResponseWriter, message string) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/invitations"
+	"golang-backend/utils"
+)
+
+// registration returns a valid registration body for email
+func registration(email string) string {
+	return `{"email":"` + email + `","password":"` + testPassword + `","accepted_terms_version":"1","date_of_birth":"1990-04-21"}`
+}
+
+func TestRegister(t *testing.T) {
+	runCases(t, Register(testConfig), []handlerCase{
+		{
+			name: "creates the user", method: "POST", target: "/register", body: registration("New@Example.com"),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				hash := utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)
+				if n := f.srv.Count("users", bson.M{"email_hash": hash, "role": "user", "email_unverified": true}); n != 1 {
+					t.Fatal("user not stored")
+				}
+				if n := f.srv.Count("consents", bson.M{"terms_version": "1"}); n != 1 {
+					t.Fatal("consent not recorded")
+				}
+			},
+		},
+		{
+			name: "role in the body is ignored", method: "POST", target: "/register",
+			body:   `{"email":"new@example.com","password":"` + testPassword + `","accepted_terms_version":"1","date_of_birth":"1990-04-21","role":"admin"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"role": "admin"}); n != 1 {
+					t.Fatalf("%d admins stored, want 1", n)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/register", body: `[]`, status: http.StatusBadRequest},
+		{name: "terms not accepted", method: "POST", target: "/register", body: `{"email":"new@example.com","password":"` + testPassword + `","date_of_birth":"1990-04-21"}`, status: http.StatusBadRequest},
+		{name: "weak password", method: "POST", target: "/register", body: `{"email":"new@example.com","password":"abc","accepted_terms_version":"1","date_of_birth":"1990-04-21"}`, status: http.StatusBadRequest},
+		{name: "invalid email", method: "POST", target: "/register", body: registration("not-an-address"), status: http.StatusBadRequest},
+		{name: "under age", method: "POST", target: "/register", body: `{"email":"new@example.com","password":"` + testPassword + `","accepted_terms_version":"1","date_of_birth":"2020-01-01"}`, status: http.StatusForbidden},
+		{
+			name: "email taken", method: "POST", target: "/register", body: registration("user@example.com"),
+			status: http.StatusConflict,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{}); n != 2 {
+					t.Fatalf("%d users stored, want 2", n)
+				}
+			},
+		},
+		{name: "database down", method: "POST", target: "/register", body: registration("new@example.com"), setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRegisterInvited(t *testing.T) {
+	f := newFixture(t)
+	code, _, err := invitations.Create(context.Background(), "admin", "", f.admin.user.ID.Hex(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"email":"new@example.com","password":"` + testPassword + `","accepted_terms_version":"1","date_of_birth":"1990-04-21","invite_code":"` + code + `"}`
+	if rec := request(Register(testConfig), "POST", "/register", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	hash := utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)
+	if n := f.srv.Count("users", bson.M{"email_hash": hash, "role": "admin"}); n != 1 {
+		t.Fatal("invitation role not granted")
+	}
+	if rec := request(Register(testConfig), "POST", "/register", "", strings.Replace(body, "new@", "other@", 1)); rec.Code == http.StatusOK {
+		t.Fatal("invitation used twice")
+	}
+}
+
+func TestAdminRegister(t *testing.T) {
+	approvals := *testConfig
+	approvals.ApprovalsEnabled = true
+	body := `{"email":"New@Example.com","password":"` + testPassword + `"}`
+	runCases(t, AdminRegister(testConfig), []handlerCase{
+		{
+			name: "creates the admin", as: "admin", method: "POST", target: "/admin/register", body: body,
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				hash := utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)
+				if n := f.srv.Count("users", bson.M{"email_hash": hash, "role": "admin"}); n != 1 {
+					t.Fatal("admin not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCreateAdmin}); n != 1 {
+					t.Fatal("creation not audited")
+				}
+			},
+		},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/register", body: `[]`, status: http.StatusBadRequest},
+		{name: "weak password", as: "admin", method: "POST", target: "/admin/register", body: `{"email":"new@example.com","password":"abc"}`, status: http.StatusBadRequest},
+		{name: "invalid email", as: "admin", method: "POST", target: "/admin/register", body: `{"email":"nope","password":"` + testPassword + `"}`, status: http.StatusBadRequest},
+		{name: "email taken", as: "admin", method: "POST", target: "/admin/register", body: `{"email":"user@example.com","password":"` + testPassword + `"}`, status: http.StatusConflict},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/register", body: body, status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/register", body: body, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+	runCases(t, AdminRegister(&approvals), []handlerCase{
+		{name: "approvals required", as: "admin", method: "POST", target: "/admin/register", body: body, status: http.StatusForbidden},
+	})
+}
+
+func TestLogin(t *testing.T) {
+	login := func(email, password string) string {
+		return `{"email":"` + email + `","password":"` + password + `"}`
+	}
+	runCases(t, Login(testConfig), []handlerCase{
+		{
+			name: "signs in", method: "POST", target: "/login", body: login("USER@example.com", testPassword),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var session LoginResponse
+				json.Unmarshal(rec.Body.Bytes(), &session)
+				if session.Token == "" || session.RefreshToken == "" || session.Role != "user" {
+					t.Fatalf("got %+v", session)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/login", body: `{"email":1}`, status: http.StatusBadRequest},
+		{name: "wrong password", method: "POST", target: "/login", body: login("user@example.com", "wrong password"), status: http.StatusUnauthorized},
+		{name: "unknown account", method: "POST", target: "/login", body: login("nobody@example.com", testPassword), status: http.StatusUnauthorized},
+		{
+			name: "suspended", method: "POST", target: "/login", body: login("user@example.com", testPassword),
+			setup:  func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"suspended": true}) },
+			status: http.StatusForbidden,
+		},
+		{name: "database down", method: "POST", target: "/login", body: login("user@example.com", testPassword), setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/challenge"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/mailer"
+	"golang-backend/ratelimit"
+	"golang-backend/repository"
+	"golang-backend/utils"
+)
+
+// Values of REGISTRATION_ENUMERATION
+const (
+	EnumerationAvailability = "availability"
+	EnumerationSilent       = "silent"
+)
+
+// AvailabilityResponse tells whether an address can be registered
+type AvailabilityResponse struct {
+	Email     string `json:"email" example:"user@example.com"`
+	Available bool   `json:"available" example:"true"`
+	ratelimit.Allowance
+}
+
+// @Summary Check whether an email address is available
+// @Description Tell whether an address can still be registered. Every lookup must solve a challenge (AVAILABILITY_CHALLENGE): the first request gets a 428 with the puzzle or CAPTCHA, to be repeated with the answer in challenge_response. Each client IP gets one lookup per AVAILABILITY_COOLDOWN and AVAILABILITY_DAILY_CAP a day, and puzzles get harder as the day's lookups add up. Only served with REGISTRATION_ENUMERATION=availability
+// @Tags auth
+// @Produce json
+// @Param email query string true "Address to check"
+// @Param challenge_response query string false "Answer to the challenge of a 428 response"
+// @Success 200 {object} AvailabilityResponse
+// @Failure 400 {string} string "Invalid email"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {object} AvailabilityResponse "Lookups exhausted for now"
+// @Failure 500 {string} string "Internal server error"
+// @Router /availability [get]
+func CheckAvailability(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		email := utils.NormalizeEmail(query.Get("email"))
+		if email == "" {
+			http.Error(w, "Invalid email", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		clientIP := audit.ClientIP(r)
+		if response := query.Get("challenge_response"); response != "" {
+			required := &challenge.Required{Kind: cfg.AvailabilityChallenge}
+			solved, err := challenge.Check(r, required, challenge.FlowAvailability, clientIP, response)
+			if err != nil {
+				correlation.Errorf(ctx, "Failed to verify %s challenge: %v", required.Kind, err)
+				retryLater(w, "Challenge verification unavailable, please retry")
+				return
+			}
+			if solved {
+				lookupAvailability(w, r, cfg, email, clientIP)
+				return
+			}
+		}
+
+		// Puzzles get harder with every lookup the client made today
+		taken, err := ratelimit.TakenToday(ctx, "availability", clientIP)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		required, err := challenge.Demand(challenge.FlowAvailability, clientIP, cfg.AvailabilityChallenge, taken)
+		if err != nil {
+			correlation.Errorf(ctx, "Failed to issue availability challenge: %v", err)
+			http.Error(w, "Failed to issue challenge", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		json.NewEncoder(w).Encode(ChallengeResponse{Error: "Challenge required", Required: *required})
+	}
+}
+
+// lookupAvailability answers a solved availability check, if the client has
+// lookups left
+func lookupAvailability(w http.ResponseWriter, r *http.Request, cfg *config.Config, email, clientIP string) {
+	ctx := r.Context()
+	allowance, err := ratelimit.Cooldown(ctx, "availability", clientIP, cfg.AvailabilityCooldown, cfg.AvailabilityDailyCap)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !allowance.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(allowance.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(AvailabilityResponse{Email: email, Allowance: allowance})
+		return
+	}
+
+	_, _, err = repository.FindUserByEmailHash(ctx, utils.EmailIndex(email, cfg.EmailFoldAliases), repository.IDOnly)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(AvailabilityResponse{Email: email, Available: err != nil, Allowance: allowance})
+}
+
+// accountExistsCooldown bounds how often the owner of an address is told
+// about attempts to register it again
+const accountExistsCooldown = time.Hour
+
+// sendAccountExistsEmail tells the owner of an address someone tried to
+// register it, in the background. Under REGISTRATION_ENUMERATION=silent this
+// replaces the 409 response, so only the owner learns the address is taken.
+func sendAccountExistsEmail(cfg *config.Config, to, emailHash string) {
+	go func() {
+		ctx := context.Background()
+		allowance, err := ratelimit.Cooldown(ctx, "account_exists_email", emailHash, accountExistsCooldown, 0)
+		if err != nil || !allowance.Allowed {
+			return
+		}
+		user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.Fields("display_name", "locale", "org_id"))
+		if err != nil {
+			log.Printf("Failed to find owner of a registered address: %v", err)
+			return
+		}
+		msg, err := renderUserEmail(ctx, cfg, "account_exists", to, user, nil)
+		if err != nil {
+			log.Printf("Failed to render account exists email for user %s: %v", user.ID.Hex(), err)
+			return
+		}
+		// About the user's own account, so muted categories do not apply
+		if err := mailer.New(cfg).SendMessage(msg); err != nil {
+			log.Printf("Failed to send account exists email to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"golang-backend/challenge"
+	"golang-backend/ratelimit"
+)
+
+// remoteIP is the client IP of httptest requests
+const remoteIP = "192.0.2.1"
+
+// solvedPuzzle sets {answer} to the solution of an availability puzzle
+func solvedPuzzle(t *testing.T, f *fixture) {
+	t.Helper()
+	required, err := challenge.Demand(challenge.FlowAvailability, remoteIP, challenge.KindProofOfWork, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("answer", url.QueryEscape(solve(t, *required)))
+}
+
+// lookedUp takes the availability lookup of the client
+func lookedUp(t *testing.T, f *fixture) {
+	t.Helper()
+	if _, err := ratelimit.Cooldown(context.Background(), "availability", remoteIP, testConfig.AvailabilityCooldown, testConfig.AvailabilityDailyCap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAvailability(t *testing.T) {
+	cfg := challenges(t, nil)
+	available := func(want bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp AvailabilityResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Available != want || !resp.Allowed || resp.RemainingToday != cfg.AvailabilityDailyCap-1 {
+				t.Fatalf("got %+v, want available %t", resp, want)
+			}
+		}
+	}
+	puzzle := func(difficulty int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ChallengeResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Kind != challenge.KindProofOfWork || resp.Params["puzzle"] == "" || resp.Params["difficulty"] != strconv.Itoa(difficulty) {
+				t.Fatalf("got %+v, want a puzzle of difficulty %d", resp, difficulty)
+			}
+		}
+	}
+	spent := func(t *testing.T, f *fixture) {
+		answer, _ := url.QueryUnescape(f.vars["answer"])
+		r := httptest.NewRequest("GET", "/availability", nil)
+		if solved, err := challenge.Check(r, &challenge.Required{Kind: challenge.KindProofOfWork}, challenge.FlowAvailability, remoteIP, answer); !solved {
+			t.Fatalf("puzzle not solved: %v", err)
+		}
+	}
+
+	runCases(t, CheckAvailability(cfg), []handlerCase{
+		{name: "challenge required", method: "GET", target: "/availability?email=new@example.com", status: http.StatusPreconditionRequired, check: puzzle(4)},
+		{name: "harder after lookups", method: "GET", target: "/availability?email=new@example.com", setup: lookedUp, status: http.StatusPreconditionRequired, check: puzzle(5)},
+		{name: "available", method: "GET", target: "/availability?email=new@example.com&challenge_response={answer}", setup: solvedPuzzle, status: http.StatusOK, check: available(true)},
+		{name: "taken", method: "GET", target: "/availability?email=User@Example.com&challenge_response={answer}", setup: solvedPuzzle, status: http.StatusOK, check: available(false)},
+		{name: "wrong answer", method: "GET", target: "/availability?email=new@example.com&challenge_response=puzzle:1", status: http.StatusPreconditionRequired},
+		{name: "answer reused", method: "GET", target: "/availability?email=new@example.com&challenge_response={answer}", setup: setups(solvedPuzzle, spent), status: http.StatusPreconditionRequired},
+		{
+			name: "cooling down", method: "GET", target: "/availability?email=new@example.com&challenge_response={answer}",
+			setup:  setups(solvedPuzzle, lookedUp),
+			status: http.StatusTooManyRequests,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp AvailabilityResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if rec.Header().Get("Retry-After") == "" || resp.Allowed || resp.Available {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+		{name: "no email", method: "GET", target: "/availability?email=%20", status: http.StatusBadRequest},
+		{name: "database down", method: "GET", target: "/availability?email=new@example.com", setup: dbDown("action_limits"), status: http.StatusInternalServerError},
+		{name: "database down after the puzzle", method: "GET", target: "/availability?email=new@example.com&challenge_response={answer}", setup: setups(solvedPuzzle, dbDown("action_limits")), status: http.StatusInternalServerError},
+	})
+}
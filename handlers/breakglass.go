@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/breakglass"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/permissions"
+	"golang-backend/security"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// BreakGlassRequest redeems a token printed by adminctl
+type BreakGlassRequest struct {
+	Token string `json:"token" example:"gbg_..."`
+}
+
+// BreakGlassResponse is an emergency admin session
+type BreakGlassResponse struct {
+	Token     string    `json:"token"`
+	Role      string    `json:"role" example:"admin"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RedeemBreakGlass exchanges a break-glass token for an admin session
+// @Summary Redeem a break-glass token
+// @Description Exchange a one-time token printed by "adminctl break-glass" on a server host for an admin session that ends when the token expires. The session belongs to no user account, and every request made with it is audited. Only available with BREAK_GLASS_ENABLED
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body BreakGlassRequest true "Break-glass token"
+// @Success 200 {object} BreakGlassResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid break-glass token"
+// @Failure 500 {string} string "Failed to generate token"
+// @Router /admin/break-glass [post]
+func RedeemBreakGlass(w http.ResponseWriter, r *http.Request) {
+	var req BreakGlassRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ip := audit.ClientIP(r)
+	grant, err := breakglass.Redeem(r.Context(), req.Token, ip)
+	if errors.Is(err, breakglass.ErrInvalidToken) || errors.Is(err, breakglass.ErrDisabled) {
+		security.Emit(r, security.EventBreakGlass, security.OutcomeFailure, "", "invalid break-glass token")
+		http.Error(w, "Invalid break-glass token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeem token", http.StatusInternalServerError)
+		return
+	}
+
+	grantID := grant.ID.Hex()
+	jti := tokens.NewID()
+	if err := tokens.Issue(r.Context(), jti, grantID, grant.ExpiresAt, tokens.ClientOf(r)); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	// Emergency access holds every permission, whatever the stored mapping
+	tokenString, err := tokens.Sign(jwt.MapClaims{
+		"userID":      grantID,
+		"role":        "admin",
+		"permissions": []string{permissions.Wildcard},
+		"auth":        "break_glass",
+		"jti":         jti,
+		"exp":         grant.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	ids := correlation.FromContext(r.Context())
+	entry := models.AuditLog{
+		ActorID:   grantID,
+		Action:    audit.ActionBreakGlassRedeem,
+		TargetID:  grantID,
+		After:     bson.M{"issued_by": grant.IssuedBy, "reason": grant.Reason, "expires_at": grant.ExpiresAt, "jti": jti},
+		IP:        ip,
+		RequestID: ids.RequestID,
+		TraceID:   ids.TraceID,
+	}
+	if _, err := audit.Insert(entry); err != nil {
+		// A session that cannot be audited is not handed out
+		correlation.Errorf(r.Context(), "Failed to audit break-glass redemption: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	correlation.SetUser(r.Context(), grantID)
+	security.Emit(r, security.EventBreakGlass, security.OutcomeSuccess, grantID, "break-glass session opened: "+grant.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BreakGlassResponse{Token: tokenString, Role: "admin", ExpiresAt: grant.ExpiresAt})
+}
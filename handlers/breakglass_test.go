@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/breakglass"
+)
+
+// breakGlass enables break-glass access and sets {token} to a grant for an
+// hour
+func breakGlass(t *testing.T, f *fixture) {
+	cfg := *testConfig
+	cfg.BreakGlassEnabled = true
+	breakglass.Init(&cfg)
+	t.Cleanup(func() { breakglass.Init(testConfig) })
+	_, token, err := breakglass.Create(context.Background(), time.Hour, "locked out", "ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("token", token)
+}
+
+func TestRedeemBreakGlass(t *testing.T) {
+	runCases(t, http.HandlerFunc(RedeemBreakGlass), []handlerCase{
+		{
+			name: "opens an admin session", method: "POST", target: "/admin/break-glass", body: `{"token":"{token}"}`,
+			setup:  breakGlass,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp BreakGlassResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Token == "" || resp.Role != "admin" || resp.ExpiresAt.IsZero() {
+					t.Fatalf("got %+v", resp)
+				}
+				checkToken(t, resp.Token, http.StatusOK)
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionBreakGlassRedeem}); n != 1 {
+					t.Fatal("redemption not audited")
+				}
+				body := `{"token":"` + f.vars["token"] + `"}`
+				if rec := request(http.HandlerFunc(RedeemBreakGlass), "POST", "/admin/break-glass", "", body); rec.Code != http.StatusUnauthorized {
+					t.Fatalf("token redeemed twice: got %d", rec.Code)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/admin/break-glass", body: `{"token":1}`, status: http.StatusBadRequest},
+		{name: "missing token", method: "POST", target: "/admin/break-glass", body: `{}`, status: http.StatusBadRequest},
+		{name: "unknown token", method: "POST", target: "/admin/break-glass", body: `{"token":"gbg_unknown"}`, setup: breakGlass, status: http.StatusUnauthorized},
+		{
+			name: "disabled", method: "POST", target: "/admin/break-glass", body: `{"token":"{token}"}`,
+			setup:  setups(breakGlass, func(t *testing.T, f *fixture) { breakglass.Init(testConfig) }),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "audit log down", method: "POST", target: "/admin/break-glass", body: `{"token":"{token}"}`,
+			setup:  setups(breakGlass, dbDown("audit_logs")),
+			status: http.StatusInternalServerError,
+		},
+	})
+}
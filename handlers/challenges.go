@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/challenge"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/mailer"
+	"golang-backend/models"
+	"golang-backend/repository"
+)
+
+// ChallengeResponse is the 428 response asking the client to solve a
+// challenge and repeat the request with its answer in challenge_response
+type ChallengeResponse struct {
+	Error string `json:"error" example:"Challenge required"`
+	challenge.Required
+}
+
+// requireCaptcha enforces the CAPTCHA of flows listed in CAPTCHA_FLOWS. A
+// missing or rejected token is answered with a 428 naming the CAPTCHA, and
+// false returned.
+func requireCaptcha(w http.ResponseWriter, r *http.Request, flow, key, token string) bool {
+	if !challenge.CaptchaRequired(flow) {
+		return true
+	}
+	message := "CAPTCHA required"
+	if token != "" {
+		solved, err := challenge.CheckCaptcha(r, flow, key, token)
+		if err != nil {
+			correlation.Errorf(r.Context(), "Failed to verify CAPTCHA: %v", err)
+			retryLater(w, "Challenge verification unavailable, please retry")
+			return false
+		}
+		if solved {
+			return true
+		}
+		message = "CAPTCHA verification failed"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionRequired)
+	json.NewEncoder(w).Encode(ChallengeResponse{Error: message, Required: challenge.Required{Kind: challenge.KindCaptcha, Params: challenge.CaptchaParams()}})
+	return false
+}
+
+// requireChallenge enforces the challenge due for a flow and key. When it
+// is unsolved the request is answered and false returned; for an email code,
+// an empty response has recipient send a new one. recipient returns the
+// address and user to email, or a nil user when there is nobody to send to.
+func requireChallenge(w http.ResponseWriter, r *http.Request, cfg *config.Config, flow, key, response string, recipient func(ctx context.Context) (string, *models.User, error)) bool {
+	required, err := challenge.Require(r.Context(), flow, key)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if required == nil {
+		return true
+	}
+
+	solved, err := challenge.Check(r, required, flow, key, response)
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to verify %s challenge: %v", required.Kind, err)
+		retryLater(w, "Challenge verification unavailable, please retry")
+		return false
+	}
+	if solved {
+		return true
+	}
+
+	if required.Kind == challenge.KindEmailOTP && response == "" {
+		sendAnonymously(func(ctx context.Context) { sendChallengeCode(ctx, cfg, flow, key, recipient) })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionRequired)
+	json.NewEncoder(w).Encode(ChallengeResponse{Error: "Challenge required", Required: *required})
+	return false
+}
+
+// sendChallengeCode emails a new challenge code, unless one was sent
+// moments ago
+func sendChallengeCode(ctx context.Context, cfg *config.Config, flow, key string, recipient func(ctx context.Context) (string, *models.User, error)) {
+	to, user, err := recipient(ctx)
+	if err != nil {
+		log.Printf("Failed to find recipient of %s challenge code: %v", flow, err)
+		return
+	}
+	if user == nil {
+		return
+	}
+	err = challenge.IssueOTP(ctx, flow, key, func(code string) error {
+		msg, err := renderUserEmail(ctx, cfg, "challenge_code", to, user, map[string]string{"Code": code})
+		if err != nil {
+			return err
+		}
+		return sendRequested(mailer.New(cfg), msg)
+	})
+	if err != nil && !errors.Is(err, challenge.ErrOTPCooldown) {
+		log.Printf("Failed to send %s challenge code: %v", flow, err)
+	}
+}
+
+// failChallenge counts a failed attempt towards the flow's challenges
+func failChallenge(r *http.Request, flow, key string) {
+	if err := challenge.Fail(r.Context(), flow, key); err != nil {
+		correlation.Errorf(r.Context(), "Failed to count %s failure: %v", flow, err)
+	}
+}
+
+// resetChallenge clears the failures of a flow after a successful attempt
+func resetChallenge(r *http.Request, flow, key string) {
+	if err := challenge.Reset(r.Context(), flow, key); err != nil {
+		correlation.Errorf(r.Context(), "Failed to reset %s failures: %v", flow, err)
+	}
+}
+
+// accountRecipient sends challenge codes to the account registered with an
+// email, if any
+func accountRecipient(email, emailHash string) func(ctx context.Context) (string, *models.User, error) {
+	return func(ctx context.Context) (string, *models.User, error) {
+		user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.Fields("display_name", "locale", "org_id"))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return email, user, nil
+	}
+}
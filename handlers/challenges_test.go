@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/challenge"
+	"golang-backend/config"
+	"golang-backend/utils"
+)
+
+// challenges initializes the challenges with steps and puzzles quick to
+// solve, for the test. edit may change the configuration first.
+func challenges(t *testing.T, steps map[string]string, edit ...func(*config.Config)) *config.Config {
+	t.Helper()
+	cfg := *testConfig
+	cfg.ChallengeSteps = steps
+	cfg.ChallengePoWDifficulty, cfg.ChallengePoWMaxDifficulty = 4, 8
+	for _, e := range edit {
+		e(&cfg)
+	}
+	challenge.Init(&cfg)
+	t.Cleanup(func() {
+		quiet := *testConfig
+		quiet.ChallengeSteps, quiet.CaptchaFlows = nil, nil
+		challenge.Init(&quiet)
+	})
+	return &cfg
+}
+
+// solve answers a proof-of-work challenge
+func solve(t *testing.T, required challenge.Required) string {
+	t.Helper()
+	difficulty, err := strconv.Atoi(required.Params["difficulty"])
+	if err != nil {
+		t.Fatalf("challenge %+v has no difficulty", required)
+	}
+	for nonce := 0; ; nonce++ {
+		response := required.Params["puzzle"] + ":" + strconv.Itoa(nonce)
+		digest := sha256.Sum256([]byte(response))
+		zeros := 0
+		for _, b := range digest {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= difficulty {
+			return response
+		}
+	}
+}
+
+// loginKey is what login challenges of the signed-in user are counted by
+func loginKey() string {
+	return utils.EmailIndex("user@example.com", testConfig.EmailFoldAliases)
+}
+
+// loginFailed counts n failed sign-ins of the user
+func loginFailed(n int) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := challenge.Fail(context.Background(), challenge.FlowLogin, loginKey()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// loginPuzzle sets {answer} to the solution of the puzzle due for the
+// user's next sign-in
+func loginPuzzle(t *testing.T, f *fixture) {
+	t.Helper()
+	required, err := challenge.Require(context.Background(), challenge.FlowLogin, loginKey())
+	if err != nil || required == nil {
+		t.Fatalf("no challenge due: %v", err)
+	}
+	f.set("answer", solve(t, *required))
+}
+
+// loginChallengeCode sets {answer} to an email code for the user's next sign-in
+func loginChallengeCode(t *testing.T, f *fixture) {
+	t.Helper()
+	err := challenge.IssueOTP(context.Background(), challenge.FlowLogin, loginKey(), func(code string) error {
+		f.set("answer", code)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// challenged checks a 428 asks for kind
+func challenged(kind string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp ChallengeResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Kind != kind {
+			t.Fatalf("got %+v, want a %s challenge", resp, kind)
+		}
+	}
+}
+
+// loginFailures checks how many failed sign-ins of the user are counted
+func loginFailures(n int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		filter := bson.M{"_id": challenge.FlowLogin + ":" + loginKey()}
+		want := 0
+		if n > 0 {
+			filter["count"], want = n, 1
+		}
+		if f.srv.Count("challenge_failures", filter) != want {
+			t.Fatalf("want %d failures counted", n)
+		}
+	}
+}
+
+func TestLoginPuzzle(t *testing.T) {
+	cfg := challenges(t, map[string]string{"login:pow": "2"})
+	login := func(password string) string {
+		return `{"email":"user@example.com","password":"` + password + `","challenge_response":"{answer}"}`
+	}
+	runCases(t, Login(cfg), []handlerCase{
+		{name: "no puzzle before failures", method: "POST", target: "/login", body: login(testPassword), setup: loginFailed(1), status: http.StatusOK, check: loginFailures(0)},
+		{name: "failure counted", method: "POST", target: "/login", body: login("wrong password"), setup: loginFailed(1), status: http.StatusUnauthorized, check: loginFailures(2)},
+		{name: "puzzle after failures", method: "POST", target: "/login", body: login(testPassword), setup: loginFailed(2), status: http.StatusPreconditionRequired, check: challenged(challenge.KindProofOfWork)},
+		{name: "puzzle solved", method: "POST", target: "/login", body: login(testPassword), setup: setups(loginFailed(2), loginPuzzle), status: http.StatusOK, check: loginFailures(0)},
+		{name: "puzzle solved, wrong password", method: "POST", target: "/login", body: login("wrong password"), setup: setups(loginFailed(2), loginPuzzle), status: http.StatusUnauthorized, check: loginFailures(3)},
+		{
+			name: "failures counted for unknown accounts", method: "POST", target: "/login", body: `{"email":"nobody@example.com","password":"x"}`,
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				key := utils.EmailIndex("nobody@example.com", testConfig.EmailFoldAliases)
+				if n := f.srv.Count("challenge_failures", bson.M{"_id": challenge.FlowLogin + ":" + key, "count": 1}); n != 1 {
+					t.Fatal("failure not counted")
+				}
+			},
+		},
+		{name: "database down", method: "POST", target: "/login", body: login(testPassword), setup: dbDown("challenge_failures"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestLoginEmailCode(t *testing.T) {
+	cfg := challenges(t, map[string]string{"login:email_otp": "1"})
+	login := func(code string) string {
+		return `{"email":"user@example.com","password":"` + testPassword + `","challenge_response":"` + code + `"}`
+	}
+	runCases(t, Login(cfg), []handlerCase{
+		{
+			name: "code sent", method: "POST", target: "/login", body: login(""),
+			setup:  loginFailed(1),
+			status: http.StatusPreconditionRequired,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				challenged(challenge.KindEmailOTP)(t, f, rec)
+				eventually(t, "a code is sent", func() bool {
+					return f.srv.Count("challenge_otps", bson.M{"_id": challenge.FlowLogin + ":" + loginKey()}) == 1
+				})
+			},
+		},
+		{name: "code entered", method: "POST", target: "/login", body: login("{answer}"), setup: setups(loginFailed(1), loginChallengeCode), status: http.StatusOK, check: loginFailures(0)},
+		{name: "wrong code", method: "POST", target: "/login", body: login("000000"), setup: setups(loginFailed(1), loginChallengeCode), status: http.StatusPreconditionRequired},
+	})
+}
+
+func TestLoginCaptcha(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]bool{"success": r.PostFormValue("response") == "solved" && r.PostFormValue("secret") == "captcha-secret"})
+	}))
+	defer provider.Close()
+	cfg := challenges(t, nil, func(cfg *config.Config) {
+		cfg.CaptchaVerifyURL, cfg.CaptchaSecret, cfg.CaptchaSiteKey = provider.URL, "captcha-secret", "site-key"
+		cfg.CaptchaFlows = []string{challenge.FlowLogin}
+	})
+	login := func(token string) string {
+		return `{"email":"user@example.com","password":"` + testPassword + `","captcha_token":"` + token + `"}`
+	}
+	captcha := func(message string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ChallengeResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Error != message || resp.Kind != challenge.KindCaptcha || resp.Params["site_key"] != "site-key" {
+				t.Fatalf("got %+v, want %q", resp, message)
+			}
+		}
+	}
+	runCases(t, Login(cfg), []handlerCase{
+		{name: "solved", method: "POST", target: "/login", body: login("solved"), status: http.StatusOK},
+		{name: "missing", method: "POST", target: "/login", body: login(""), status: http.StatusPreconditionRequired, check: captcha("CAPTCHA required")},
+		{name: "rejected", method: "POST", target: "/login", body: login("forged"), status: http.StatusPreconditionRequired, check: captcha("CAPTCHA verification failed")},
+	})
+
+	provider.Close()
+	t.Run("provider down", func(t *testing.T) {
+		newFixture(t)
+		if rec := request(Login(cfg), "POST", "/login", "", login("solved")); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("got %d, want 503 with Retry-After", rec.Code)
+		}
+	})
+}
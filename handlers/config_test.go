@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"golang-backend/config"
+)
+
+// Handlers get the configuration injected; loading it again per request
+// would reread the environment and .env on every call
+func TestRequestsDoNotLoadConfig(t *testing.T) {
+	startDB(t)
+	admin := createUser(t, "admin@example.com", "admin")
+	for i := 0; i < 5; i++ {
+		createUser(t, fmt.Sprintf("user%d@example.com", i), "user")
+	}
+	session := signIn(t, admin)
+
+	before := config.Loads()
+	for _, tc := range []struct {
+		name           string
+		handler        http.Handler
+		method, target string
+		body           string
+	}{
+		{"list users", ListUsers(testConfig), "GET", "/admin/users?limit=10", ""},
+		{"get profile", GetUserProfile(testConfig), "GET", "/user/profile", ""},
+		{"update profile", UpdateUserProfile(testConfig), "PUT", "/user/profile", `{"email":"new-admin@example.com"}`},
+	} {
+		if rec := session.do(tc.handler, tc.method, tc.target, tc.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", tc.name, rec.Code, rec.Body)
+		}
+	}
+	if loads := config.Loads() - before; loads != 0 {
+		t.Fatalf("config was loaded %d times while serving requests", loads)
+	}
+}
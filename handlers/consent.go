@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// dateOfBirthLayout is the expected date_of_birth format
+const dateOfBirthLayout = "2006-01-02"
+
+// minimumAge returns the minimum signup age for a region
+func minimumAge(cfg *config.Config, region string) int {
+	if age, ok := cfg.MinAgeByRegion[strings.ToUpper(region)]; ok {
+		return age
+	}
+	return cfg.MinAge
+}
+
+// ageOn returns the age in whole years of someone born on dob at the given
+// time. Birthdays are compared by month and day, since days of the year
+// shift after February in leap years; people born on February 29 turn a
+// year older on March 1 in other years.
+func ageOn(dob, now time.Time) int {
+	age := now.Year() - dob.Year()
+	if now.Month() < dob.Month() || now.Month() == dob.Month() && now.Day() < dob.Day() {
+		age--
+	}
+	return age
+}
+
+// checkRegistrationConsent validates the accepted terms version and the minimum age.
+// It returns the applicable minimum age, or an HTTP status and message when invalid.
+func checkRegistrationConsent(cfg *config.Config, req RegisterRequest) (int, int, string) {
+	if req.AcceptedTermsVersion == "" {
+		return 0, http.StatusBadRequest, "accepted_terms_version is required"
+	}
+	if req.AcceptedTermsVersion != cfg.TermsVersion {
+		return 0, http.StatusBadRequest, "The current terms of service (version " + cfg.TermsVersion + ") must be accepted"
+	}
+
+	if req.DateOfBirth == "" {
+		return 0, http.StatusBadRequest, "date_of_birth is required"
+	}
+	dob, err := time.Parse(dateOfBirthLayout, req.DateOfBirth)
+	now := clock.Now().UTC()
+	if err != nil || dob.After(now) {
+		return 0, http.StatusBadRequest, "date_of_birth must be a past date in YYYY-MM-DD format"
+	}
+
+	minAge := minimumAge(cfg, req.Region)
+	if ageOn(dob, now) < minAge {
+		return 0, http.StatusForbidden, "You do not meet the minimum age requirement"
+	}
+
+	return minAge, http.StatusOK, ""
+}
+
+// recordConsent stores the consent artifact for a newly registered user
+func recordConsent(r *http.Request, userID primitive.ObjectID, termsVersion, region string, minAge int, acceptedAt time.Time) error {
+	consent := models.Consent{
+		ID:           clock.NewID(),
+		UserID:       userID,
+		TermsVersion: termsVersion,
+		Region:       strings.ToUpper(region),
+		MinimumAge:   minAge,
+		AgeVerified:  true,
+		IP:           audit.ClientIP(r),
+		UserAgent:    r.UserAgent(),
+		AcceptedAt:   acceptedAt,
+	}
+
+	_, err := database.DB.Collection("consents").InsertOne(context.Background(), consent)
+	return err
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+)
+
+func TestAgeOn(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(dateOfBirthLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	cases := []struct {
+		dob, on string
+		want    int
+	}{
+		{"2000-06-15", "2018-06-14", 17},
+		{"2000-06-15", "2018-06-15", 18},
+		{"2001-03-01", "2024-02-29", 22},
+		{"2001-03-01", "2024-03-01", 23},
+		{"2000-12-31", "2018-12-31", 18},
+		{"2000-02-29", "2018-02-28", 17},
+		{"2000-02-29", "2018-03-01", 18},
+		{"2000-02-29", "2020-02-29", 20},
+	}
+	for _, tc := range cases {
+		if got := ageOn(day(tc.dob), day(tc.on)); got != tc.want {
+			t.Errorf("born %s, on %s: got %d, want %d", tc.dob, tc.on, got, tc.want)
+		}
+	}
+}
+
+func TestCheckRegistrationConsent(t *testing.T) {
+	cfg := *testConfig
+	cfg.TermsVersion, cfg.MinAge, cfg.MinAgeByRegion = "3", 13, map[string]int{"KR": 14}
+	born := func(years int) string {
+		return clock.Now().UTC().AddDate(-years, 0, -1).Format(dateOfBirthLayout)
+	}
+	cases := []struct {
+		name   string
+		req    RegisterRequest
+		status int
+		minAge int
+	}{
+		{"accepted", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: born(13)}, http.StatusOK, 13},
+		{"regional minimum", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: born(14), Region: "kr"}, http.StatusOK, 14},
+		{"under the regional minimum", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: born(13), Region: "KR"}, http.StatusForbidden, 0},
+		{"under age", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: born(12)}, http.StatusForbidden, 0},
+		{"no terms", RegisterRequest{DateOfBirth: born(30)}, http.StatusBadRequest, 0},
+		{"outdated terms", RegisterRequest{AcceptedTermsVersion: "2", DateOfBirth: born(30)}, http.StatusBadRequest, 0},
+		{"no date of birth", RegisterRequest{AcceptedTermsVersion: "3"}, http.StatusBadRequest, 0},
+		{"malformed date of birth", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: "21/04/1990"}, http.StatusBadRequest, 0},
+		{"future date of birth", RegisterRequest{AcceptedTermsVersion: "3", DateOfBirth: born(-1)}, http.StatusBadRequest, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			minAge, status, msg := checkRegistrationConsent(&cfg, tc.req)
+			if status != tc.status || minAge != tc.minAge {
+				t.Fatalf("got %d %q, minimum age %d; want %d, minimum age %d", status, msg, minAge, tc.status, tc.minAge)
+			}
+		})
+	}
+}
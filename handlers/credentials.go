@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/apikeys"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/tokens"
+	"golang-backend/webauthn"
+)
+
+// SessionResponse is an outstanding session token, identified by its jti
+type SessionResponse struct {
+	ID        string    `json:"id" example:"4f1c9a7be2d04c6a8b1f0e3d5a7c9b21"`
+	IP        string    `json:"ip,omitempty" example:"203.0.113.7"`
+	UserAgent string    `json:"user_agent,omitempty" example:"Mozilla/5.0"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserCredentialsResponse lists a user's active sessions, API keys and
+// passkeys
+type UserCredentialsResponse struct {
+	UserID   string                `json:"user_id"`
+	Sessions []SessionResponse     `json:"sessions"`
+	APIKeys  []models.APIKey       `json:"api_keys"`
+	Passkeys []webauthn.Credential `json:"passkeys"`
+}
+
+// RevokeCredentialsResponse reports what was revoked
+type RevokeCredentialsResponse struct {
+	SessionsRevoked int64 `json:"sessions_revoked" example:"3"`
+	APIKeysRevoked  int64 `json:"api_keys_revoked" example:"1"`
+}
+
+// @Summary List a user's credentials
+// @Description List a user's active session tokens, with the client they were issued to, API keys including revoked ones, and passkeys. Refreshed tokens appear as new sessions. Sessions are only tracked while token IDs are stored (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} UserCredentialsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/credentials [get]
+func ListUserCredentials(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := credentialsUser(w, r)
+	if !ok {
+		return
+	}
+	userID := user.ID
+
+	records, err := tokens.Active(r.Context(), userID.Hex())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch sessions"}`, http.StatusInternalServerError)
+		return
+	}
+	keys, err := apikeys.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch API keys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	passkeys, err := webauthn.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch passkeys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	resp := UserCredentialsResponse{UserID: userID.Hex(), Sessions: []SessionResponse{}, APIKeys: keys, Passkeys: passkeys}
+	for _, rec := range records {
+		resp.Sessions = append(resp.Sessions, SessionResponse{
+			ID:        rec.ID,
+			IP:        rec.Client.IP,
+			UserAgent: rec.Client.UserAgent,
+			IssuedAt:  rec.IssuedAt,
+			ExpiresAt: rec.ExpiresAt,
+		})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @Summary Revoke a user's credentials
+// @Description Revoke the sessions and API keys named by the session and api_key query parameters (both repeatable), or every session and API key of the user when neither is given. Revoked sessions are rejected even without JWT_STRICT_JTI. The user is sent a security notice (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param session query []string false "Session ID (jti) to revoke" collectionFormat(multi)
+// @Param api_key query []string false "API key ID to revoke" collectionFormat(multi)
+// @Security BearerAuth
+// @Success 200 {object} RevokeCredentialsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/credentials [delete]
+func RevokeUserCredentials(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		user, ok := credentialsUser(w, r)
+		if !ok {
+			return
+		}
+		userID := user.ID
+
+		sessions := r.URL.Query()["session"]
+		var keyIDs []primitive.ObjectID
+		for _, id := range r.URL.Query()["api_key"] {
+			keyID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				http.Error(w, `{"error": "Invalid API key ID format"}`, http.StatusBadRequest)
+				return
+			}
+			keyIDs = append(keyIDs, keyID)
+		}
+
+		ctx := r.Context()
+		var resp RevokeCredentialsResponse
+		if len(sessions) == 0 && len(keyIDs) == 0 {
+			var err error
+			if resp.SessionsRevoked, err = tokens.RevokeUser(ctx, userID.Hex()); err != nil {
+				http.Error(w, `{"error": "Failed to revoke sessions"}`, http.StatusInternalServerError)
+				return
+			}
+			if resp.APIKeysRevoked, err = apikeys.RevokeAll(ctx, userID); err != nil {
+				http.Error(w, `{"error": "Failed to revoke API keys"}`, http.StatusInternalServerError)
+				return
+			}
+		} else {
+			for _, jti := range sessions {
+				if err := tokens.Revoke(ctx, userID.Hex(), jti); err != nil {
+					if err == mongo.ErrNoDocuments {
+						continue
+					}
+					http.Error(w, `{"error": "Failed to revoke sessions"}`, http.StatusInternalServerError)
+					return
+				}
+				resp.SessionsRevoked++
+			}
+			for _, keyID := range keyIDs {
+				if err := apikeys.Revoke(ctx, userID, keyID); err != nil {
+					if err == mongo.ErrNoDocuments {
+						continue
+					}
+					http.Error(w, `{"error": "Failed to revoke API keys"}`, http.StatusInternalServerError)
+					return
+				}
+				resp.APIKeysRevoked++
+			}
+		}
+
+		after := bson.M{
+			"sessions":         sessions,
+			"api_keys":         r.URL.Query()["api_key"],
+			"all":              len(sessions) == 0 && len(keyIDs) == 0,
+			"sessions_revoked": resp.SessionsRevoked,
+			"api_keys_revoked": resp.APIKeysRevoked,
+		}
+		if _, err := audit.Record(r, audit.ActionRevokeCredentials, userID.Hex(), nil, after); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit credential revocation: %v", err)
+		}
+
+		if resp.SessionsRevoked+resp.APIKeysRevoked > 0 {
+			sendRevocationNotice(cfg, *user, resp)
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// sendRevocationNotice tells the user their credentials were revoked, in the
+// background. Security notices cannot be muted by email or in-app.
+func sendRevocationNotice(cfg *config.Config, user models.User, revoked RevokeCredentialsResponse) {
+	go func() {
+		ctx := context.Background()
+		to, err := keys.Decrypt(ctx, cfg, user.Email)
+		if err != nil {
+			log.Printf("Failed to decrypt email of user %s for revocation notice: %v", user.ID.Hex(), err)
+			return
+		}
+		vars := map[string]string{
+			"Sessions": strconv.FormatInt(revoked.SessionsRevoked, 10),
+			"APIKeys":  strconv.FormatInt(revoked.APIKeysRevoked, 10),
+		}
+		msg, err := renderUserEmail(ctx, cfg, "credentials_revoked", to, &user, vars)
+		if err != nil {
+			log.Printf("Failed to render revocation notice for user %s: %v", user.ID.Hex(), err)
+			return
+		}
+		notice := notifier.UserMessage{
+			Type:     "account.credentials_revoked",
+			Category: models.CategorySecurity,
+			Email:    &msg,
+			Title:    msg.Subject,
+			Body:     "An administrator revoked " + vars["Sessions"] + " session(s) and " + vars["APIKeys"] + " API key(s) of your account.",
+		}
+		if err := notifier.Deliver(ctx, cfg, &user, notice); err != nil {
+			log.Printf("Failed to send revocation notice to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
+
+// credentialsUser loads the user named in the path with what a notice to
+// them needs
+func credentialsUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	user, _, err := repository.FindUser(r.Context(), bson.M{"_id": userID}, repository.Fields("email", "display_name", "locale", "org_id", "notifications"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
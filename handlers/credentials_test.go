@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/tokens"
+)
+
+// sessionID returns the jti of a session token
+func sessionID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, err := tokens.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jti, _ := parsed.Claims.(jwt.MapClaims)["jti"].(string)
+	if jti == "" {
+		t.Fatal("session token has no jti")
+	}
+	return jti
+}
+
+// credentials gives the user a second session as {other}, with its ID as
+// {session}, an API key as {key} and a passkey
+func credentials(t *testing.T, f *fixture) {
+	t.Helper()
+	otherSession(t, f)
+	f.set("session", sessionID(t, f.vars["other"]))
+	apiKey(t, f)
+	addPasskey(t, f.user)
+}
+
+// revocationNotified checks the user was told about the revocation
+func revocationNotified(t *testing.T, f *fixture) {
+	t.Helper()
+	eventually(t, "the user is notified", func() bool {
+		return f.srv.Count("user_notifications", bson.M{"user_id": f.user.user.ID, "type": "account.credentials_revoked"}) == 1
+	})
+}
+
+func TestListUserCredentials(t *testing.T) {
+	h := route("/admin/users/{id}/credentials", http.HandlerFunc(ListUserCredentials))
+	runCases(t, h, []handlerCase{
+		{
+			name: "lists credentials", as: "admin", method: "GET", target: "/admin/users/{user}/credentials",
+			setup:  credentials,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp UserCredentialsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.UserID != f.user.user.ID.Hex() || len(resp.Sessions) != 2 || len(resp.APIKeys) != 1 || len(resp.Passkeys) != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+				for _, s := range resp.Sessions {
+					if s.IP != testClient.IP || s.UserAgent != testClient.UserAgent || !s.ExpiresAt.After(s.IssuedAt) {
+						t.Fatalf("session %+v", s)
+					}
+				}
+			},
+		},
+		{
+			name: "no credentials", as: "admin", method: "GET", target: "/admin/users/{user}/credentials",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp UserCredentialsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.APIKeys) != 0 || len(resp.Passkeys) != 0 || resp.Sessions == nil {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "unknown user", as: "admin", method: "GET", target: "/admin/users/{missing}/credentials", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/users/nope/credentials", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/{user}/credentials", setup: setups(credentials, dbDown("api_keys")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRevokeUserCredentials(t *testing.T) {
+	h := route("/admin/users/{id}/credentials", RevokeUserCredentials(testConfig))
+	revoked := func(sessions, keys int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp RevokeCredentialsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.SessionsRevoked != sessions || resp.APIKeysRevoked != keys {
+				t.Fatalf("got %+v, want %d sessions and %d keys", resp, sessions, keys)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRevokeCredentials, "target_id": f.user.user.ID.Hex(), "after.sessions_revoked": sessions}); n != 1 {
+				t.Fatal("revocation not audited")
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "everything", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials",
+			setup:  credentials,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				revoked(2, 1)(t, f, rec)
+				checkToken(t, f.user.token, http.StatusUnauthorized)
+				checkToken(t, f.vars["other"], http.StatusUnauthorized)
+				if n := f.srv.Count("api_keys", bson.M{"revoked_at": nil}); n != 0 {
+					t.Fatal("API key not revoked")
+				}
+				revocationNotified(t, f)
+			},
+		},
+		{
+			name: "one session", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials?session={session}",
+			setup:  credentials,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				revoked(1, 0)(t, f, rec)
+				checkToken(t, f.vars["other"], http.StatusUnauthorized)
+				checkToken(t, f.user.token, http.StatusOK)
+				revocationNotified(t, f)
+			},
+		},
+		{
+			name: "one key", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials?api_key={key}",
+			setup:  credentials,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				revoked(0, 1)(t, f, rec)
+				checkToken(t, f.vars["other"], http.StatusOK)
+			},
+		},
+		{
+			name: "nothing to revoke", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials?session=unknown&api_key={missing}",
+			setup:  credentials,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				revoked(0, 0)(t, f, rec)
+				checkToken(t, f.vars["other"], http.StatusOK)
+			},
+		},
+		{name: "invalid key ID", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials?api_key=nope", status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "DELETE", target: "/admin/users/{missing}/credentials", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/users/{user}/credentials", setup: setups(credentials, dbDown("api_keys")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// CustomFieldRequest represents the definition of a custom field
+type CustomFieldRequest struct {
+	Label     string   `json:"label,omitempty" example:"Company size"`
+	Type      string   `json:"type" example:"enum"`
+	Required  bool     `json:"required,omitempty"`
+	Encrypted bool     `json:"encrypted,omitempty"`
+	MinLength int      `json:"min_length,omitempty"`
+	MaxLength int      `json:"max_length,omitempty" example:"200"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Options   []string `json:"options,omitempty" example:"1-10,11-50,51+"`
+}
+
+// CustomFieldsResponse represents the custom fields schema
+type CustomFieldsResponse struct {
+	Fields []models.CustomField `json:"fields"`
+}
+
+// @Summary List custom fields
+// @Description The custom profile fields of this deployment, which PUT /user/profile accepts in custom_fields
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} CustomFieldsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/custom-fields [get]
+func ListCustomFields(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fields, err := customfields.Schema(r.Context())
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to load custom fields: %v", err)
+		http.Error(w, `{"error": "Failed to fetch custom fields"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(CustomFieldsResponse{Fields: fields})
+}
+
+// @Summary Define a custom field
+// @Description Add a profile field, or change the definition of an existing one. Types are string (min_length, max_length up to 1000, pattern), number (min, max), boolean, date (YYYY-MM-DD) and enum (options). Values of encrypted fields are encrypted with the user's data key and left out of reports. A field's type and encryption cannot change. The schema has at most 50 fields (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Field name, 1-40 lowercase letters, digits or _"
+// @Param request body CustomFieldRequest true "Field definition"
+// @Security BearerAuth
+// @Success 200 {object} models.CustomField
+// @Success 201 {object} models.CustomField
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Too many fields, or the type or encryption would change"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/custom-fields/{name} [put]
+func DefineCustomField(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CustomFieldRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	field := &models.CustomField{
+		Name:      mux.Vars(r)["name"],
+		Label:     req.Label,
+		Type:      req.Type,
+		Required:  req.Required,
+		Encrypted: req.Encrypted,
+		MinLength: req.MinLength,
+		MaxLength: req.MaxLength,
+		Pattern:   req.Pattern,
+		Min:       req.Min,
+		Max:       req.Max,
+		Options:   req.Options,
+	}
+	if err := customfields.Check(field); err != nil {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	before, _ := customfields.Schema(ctx)
+	created, err := customfields.Define(ctx, field)
+	if errors.Is(err, customfields.ErrTooMany) || errors.Is(err, customfields.ErrImmutable) {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		correlation.Errorf(ctx, "Failed to define custom field %s: %v", field.Name, err)
+		http.Error(w, `{"error": "Failed to save custom field"}`, http.StatusInternalServerError)
+		return
+	}
+	// Cached profiles render the previous schema
+	cache.Invalidate(cache.TagUsers)
+
+	var previous bson.M
+	for i := range before {
+		if before[i].Name == field.Name {
+			previous = customFieldAudit(&before[i])
+		}
+	}
+	if _, err := audit.Record(r, audit.ActionCustomFieldDefine, field.Name, previous, customFieldAudit(field)); err != nil {
+		correlation.Errorf(ctx, "Failed to audit custom field %s: %v", field.Name, err)
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(field)
+}
+
+// @Summary Remove a custom field
+// @Description Remove a profile field from the schema and its values from every user (Admin only)
+// @Tags admin
+// @Produce json
+// @Param name path string true "Field name"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/custom-fields/{name} [delete]
+func RemoveCustomField(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx := r.Context()
+	name := mux.Vars(r)["name"]
+	field, err := customfields.Remove(ctx, name)
+	if errors.Is(err, customfields.ErrNotFound) {
+		http.Error(w, `{"error": "Custom field not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		correlation.Errorf(ctx, "Failed to remove custom field %s: %v", name, err)
+		http.Error(w, `{"error": "Failed to remove custom field"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	if _, err := audit.Record(r, audit.ActionCustomFieldRemove, name, customFieldAudit(field), nil); err != nil {
+		correlation.Errorf(ctx, "Failed to audit custom field %s: %v", name, err)
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Custom field removed"})
+}
+
+// customFieldAudit is the definition of a field as recorded in the audit log
+func customFieldAudit(field *models.CustomField) bson.M {
+	return bson.M{
+		"label":      field.Label,
+		"type":       field.Type,
+		"required":   field.Required,
+		"encrypted":  field.Encrypted,
+		"min_length": field.MinLength,
+		"max_length": field.MaxLength,
+		"pattern":    field.Pattern,
+		"min":        field.Min,
+		"max":        field.Max,
+		"options":    field.Options,
+	}
+}
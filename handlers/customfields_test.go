@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/customfields"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// customField defines a field of the schema, which the user has a value
+// for. The schema is emptied after the test, as it is cached.
+func customField(name, typ string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		t.Cleanup(func() {
+			ctx := context.Background()
+			database.DB.Collection("custom_fields").Drop(ctx)
+			customfields.Reload(ctx)
+		})
+		field := &models.CustomField{Name: name, Label: name, Type: typ, Options: []string{"small", "large"}}
+		if typ != models.FieldEnum {
+			field.Options = nil
+		}
+		if _, err := customfields.Define(context.Background(), field); err != nil {
+			t.Fatal(err)
+		}
+		setUser(t, f, bson.M{"custom_fields." + name: "small"})
+	}
+}
+
+// schemaReloaded drops the schema cached by tests before
+func schemaReloaded(t *testing.T, f *fixture) {
+	t.Helper()
+	if _, err := customfields.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListCustomFields(t *testing.T) {
+	listed := func(names ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp CustomFieldsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Fields) != len(names) {
+				t.Fatalf("got %+v, want %v", resp.Fields, names)
+			}
+			for i, name := range names {
+				if resp.Fields[i].Name != name {
+					t.Fatalf("got %+v, want %v", resp.Fields, names)
+				}
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(ListCustomFields), []handlerCase{
+		{name: "fields by name", as: "user", method: "GET", target: "/user/custom-fields", setup: setups(customField("size", models.FieldEnum), customField("nickname", models.FieldString)), status: http.StatusOK, check: listed("nickname", "size")},
+		{name: "no fields", as: "user", method: "GET", target: "/user/custom-fields", setup: schemaReloaded, status: http.StatusOK, check: listed()},
+	})
+}
+
+func TestDefineCustomField(t *testing.T) {
+	h := route("/admin/custom-fields/{name}", http.HandlerFunc(DefineCustomField))
+	defined := func(label string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var field models.CustomField
+			json.Unmarshal(rec.Body.Bytes(), &field)
+			if field.Name != "size" || field.Label != label || len(field.Options) != 3 {
+				t.Fatalf("got %+v", field)
+			}
+			if n := f.srv.Count("custom_fields", bson.M{"name": "size", "label": label}); n != 1 {
+				t.Fatal("field not stored")
+			}
+			schema, _ := customfields.Schema(context.Background())
+			if len(schema) != 1 || schema[0].Label != label {
+				t.Fatalf("schema %+v", schema)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCustomFieldDefine, "target_id": "size", "after.label": label}); n != 1 {
+				t.Fatal("definition not audited")
+			}
+		}
+	}
+	enum := func(label string) string {
+		return `{"label":"` + label + `","type":"enum","options":["1-10","11-50","51+"]}`
+	}
+	tooMany := func(t *testing.T, f *fixture) {
+		for i := 0; i < customfields.MaxFields; i++ {
+			customField("field"+strconv.Itoa(i), models.FieldBoolean)(t, f)
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "adds a field", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: enum("Company size"), setup: schemaReloaded, status: http.StatusCreated, check: defined("Company size")},
+		{
+			name: "changes a field", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: enum("Team size"),
+			setup:  customField("size", models.FieldEnum),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				defined("Team size")(t, f, rec)
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCustomFieldDefine, "before.label": "size"}); n != 1 {
+					t.Fatal("previous definition not audited")
+				}
+			},
+		},
+		{name: "type changed", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: `{"type":"string"}`, setup: customField("size", models.FieldEnum), status: http.StatusConflict},
+		{name: "too many fields", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: enum("Company size"), setup: tooMany, status: http.StatusConflict},
+		{name: "invalid name", as: "admin", method: "PUT", target: "/admin/custom-fields/Size", body: enum("Company size"), status: http.StatusBadRequest},
+		{name: "enum without options", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: `{"type":"enum"}`, status: http.StatusBadRequest},
+		{name: "unknown type", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: `{"type":"color"}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: `{"type":1}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/custom-fields/size", body: enum("Company size"), setup: dbDown("custom_fields"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRemoveCustomField(t *testing.T) {
+	h := route("/admin/custom-fields/{name}", http.HandlerFunc(RemoveCustomField))
+	runCases(t, h, []handlerCase{
+		{
+			name: "removes the field and values", as: "admin", method: "DELETE", target: "/admin/custom-fields/size",
+			setup:  setups(customField("size", models.FieldEnum), customField("nickname", models.FieldString)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("custom_fields", bson.M{}); n != 1 {
+					t.Fatal("field not removed")
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "custom_fields.size": bson.M{"$exists": false}, "custom_fields.nickname": "small"}); n != 1 {
+					t.Fatal("wrong values removed")
+				}
+				if schema, _ := customfields.Schema(context.Background()); len(schema) != 1 {
+					t.Fatalf("schema %+v", schema)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCustomFieldRemove, "target_id": "size", "before.type": models.FieldEnum}); n != 1 {
+					t.Fatal("removal not audited")
+				}
+			},
+		},
+		{name: "unknown field", as: "admin", method: "DELETE", target: "/admin/custom-fields/size", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/custom-fields/size", setup: setups(customField("size", models.FieldEnum), dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/database"
+)
+
+// @Summary Database cluster status
+// @Description The active and standby MongoDB clusters of the default region and the outcome of the latest blue/green cutover on this instance (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} database.CutoverStatus
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/database/cluster [get]
+func DatabaseCluster(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(database.Status())
+}
+
+// @Summary Cut over to the standby cluster
+// @Description Move the default region to the standby MongoDB cluster without a restart. The target is checked, requests are paused while those in flight finish, and the target is checked again before they resume; a failed check switches back. Runs in the background, poll GET /admin/database/cluster for the outcome. Other instances follow within MONGO_CUTOVER_POLL (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} database.CutoverStatus
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "No standby cluster configured"
+// @Failure 409 {object} ErrorResponse "A cutover is already running"
+// @Router /admin/database/cutover [post]
+func DatabaseCutover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	before := database.Status()
+	err := database.StartCutover()
+	if errors.Is(err, database.ErrNoStandby) {
+		http.Error(w, `{"error": "No standby cluster configured"}`, http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrCutoverRunning) {
+		http.Error(w, `{"error": "A cutover is already running"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to start cutover"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionDatabaseCutover, before.Standby, bson.M{"active": before.Active}, bson.M{"active": before.Standby}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit database cutover: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(database.Status())
+}
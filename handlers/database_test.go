@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+)
+
+func TestDatabaseCluster(t *testing.T) {
+	runCases(t, http.HandlerFunc(DatabaseCluster), []handlerCase{
+		{
+			name: "active cluster", as: "admin", method: "GET", target: "/admin/database/cluster",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp database.CutoverStatus
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Active != database.Blue || resp.Standby != "" || resp.State != database.CutoverIdle {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+	})
+}
+
+func TestDatabaseCutover(t *testing.T) {
+	runCases(t, http.HandlerFunc(DatabaseCutover), []handlerCase{
+		{
+			name: "no standby", as: "admin", method: "POST", target: "/admin/database/cutover",
+			status: http.StatusNotFound,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if database.Status().State != database.CutoverIdle {
+					t.Fatal("cutover started")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionDatabaseCutover}); n != 0 {
+					t.Fatal("cutover audited")
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/deprecation"
+)
+
+// DeprecationsResponse lists the deprecated routes and who still calls them
+type DeprecationsResponse struct {
+	Routes []deprecation.Route `json:"routes"`
+	Since  time.Time           `json:"since"`
+	Usage  []deprecation.Usage `json:"usage"`
+}
+
+// @Summary List deprecated routes
+// @Description List the routes retired through DEPRECATED_ROUTES with their sunset dates and successors, and the calls made to them by caller (service, API key, user or client IP), most recent first. Routes past their sunset answer 410; those calls are counted as gone_calls (Admin only)
+// @Tags admin
+// @Produce json
+// @Param window query string false "How far back to look, as a duration" default(720h)
+// @Security BearerAuth
+// @Success 200 {object} DeprecationsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/deprecations [get]
+func ListDeprecations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	window := 30 * 24 * time.Hour
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, `{"error": "Invalid window"}`, http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+
+	since := clock.Now().Add(-window)
+	usage := []deprecation.Usage{}
+	if deprecation.Enabled() {
+		var err error
+		if usage, err = deprecation.Summarize(r.Context(), since); err != nil {
+			http.Error(w, `{"error": "Failed to summarize deprecated route calls"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(DeprecationsResponse{
+		Routes: deprecation.Routes(),
+		Since:  since,
+		Usage:  usage,
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/deprecation"
+)
+
+// deprecatedCalls stores calls of the user to a deprecated route, the first
+// today and the second the given number of days ago
+func deprecatedCalls(daysAgo int) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		today := clock.Now().UTC().Truncate(24 * time.Hour)
+		var calls []interface{}
+		for _, day := range []time.Time{today, today.AddDate(0, 0, -daysAgo)} {
+			calls = append(calls, bson.M{
+				"_id":    "POST /admin/users/delete|user:" + f.user.user.ID.Hex() + "|" + day.Format("2006-01-02"),
+				"method": "POST", "path": "/admin/users/delete", "caller": "user:" + f.user.user.ID.Hex(),
+				"day": day, "calls": 2, "gone_calls": 0, "first_seen": day, "last_seen": day.Add(time.Hour),
+			})
+		}
+		if _, err := database.DB.Collection("deprecated_calls").InsertMany(context.Background(), calls); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListDeprecations(t *testing.T) {
+	cfg := *testConfig
+	cfg.DeprecatedRoutes = map[string]string{"POST /admin/users/delete": "2030-01-01"}
+	cfg.DeprecationSuccessors = map[string]string{"POST /admin/users/delete": "DELETE /admin/users/{id}"}
+	deprecation.Init(&cfg)
+
+	used := func(calls int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp DeprecationsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Routes) != 1 || resp.Routes[0].Successor != "DELETE /admin/users/{id}" {
+				t.Fatalf("routes %+v", resp.Routes)
+			}
+			if len(resp.Usage) != 1 || resp.Usage[0].Calls != calls || resp.Usage[0].Caller != "user:"+f.user.user.ID.Hex() {
+				t.Fatalf("usage %+v, want %d calls", resp.Usage, calls)
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(ListDeprecations), []handlerCase{
+		{name: "last 30 days", as: "admin", method: "GET", target: "/admin/deprecations", setup: deprecatedCalls(10), status: http.StatusOK, check: used(4)},
+		{name: "window", as: "admin", method: "GET", target: "/admin/deprecations?window=48h", setup: deprecatedCalls(10), status: http.StatusOK, check: used(2)},
+		{
+			name: "no calls", as: "admin", method: "GET", target: "/admin/deprecations",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp DeprecationsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Routes) != 1 || resp.Usage == nil || len(resp.Usage) != 0 {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "invalid window", as: "admin", method: "GET", target: "/admin/deprecations?window=month", status: http.StatusBadRequest},
+		{name: "negative window", as: "admin", method: "GET", target: "/admin/deprecations?window=-1h", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/deprecations", setup: dbDown("deprecated_calls"), status: http.StatusInternalServerError},
+	})
+}
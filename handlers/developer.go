@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed developer/index.html
+var developerPage []byte
+
+// DeveloperPortal serves the developer portal page. The page signs in with
+// the regular login endpoint and manages API keys through /user/api-keys.
+func DeveloperPortal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(developerPage)
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeveloperPortal(t *testing.T) {
+	rec := request(http.HandlerFunc(DeveloperPortal), "GET", "/developer", "", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "<title>Developer Portal</title>") {
+		t.Fatal("portal page not served")
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/models"
+	"golang-backend/scan"
+	"golang-backend/uploads"
+	"golang-backend/utils"
+)
+
+// SignUploadRequest describes a file the client is about to upload
+type SignUploadRequest struct {
+	Kind        string `json:"kind" example:"avatar"`
+	Filename    string `json:"filename" example:"me.png"`
+	ContentType string `json:"content_type" example:"image/png"`
+	Size        int64  `json:"size" example:"48213"`
+}
+
+// SignUploadResponse tells the client where and how to upload the file
+type SignUploadResponse struct {
+	UploadID   string            `json:"upload_id" example:"6650c1a2b3d4e5f60718293a"`
+	Method     string            `json:"method" example:"PUT"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+	ConfirmURL string            `json:"confirm_url" example:"/user/uploads/6650c1a2b3d4e5f60718293a/confirm"`
+}
+
+// DirectUploadResponse is an upload with a download URL once confirmed
+type DirectUploadResponse struct {
+	models.DirectUpload
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// DirectUploadsResponse lists a user's uploads
+type DirectUploadsResponse struct {
+	Uploads []models.DirectUpload `json:"uploads"`
+}
+
+// @Summary Sign a direct upload
+// @Description Get a presigned URL to PUT a file straight to object storage, so large files bypass the API servers. The request must send exactly the returned headers and the declared number of bytes before expires_at; then call confirm_url. Kinds are "avatar" (PNG, JPEG, GIF or WebP) and "file" (any type except executables and archives)
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body SignUploadRequest true "File to upload"
+// @Security BearerAuth
+// @Success 201 {object} SignUploadResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/uploads/sign [post]
+func SignUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req SignUploadRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Kind != models.UploadAvatar && req.Kind != models.UploadFile {
+		http.Error(w, `{"error": "kind must be avatar or file"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Filename == "" || len(req.Filename) > 255 {
+		http.Error(w, `{"error": "filename must be 1-255 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if !scan.Allowed(req.Kind, req.ContentType) {
+		http.Error(w, fmt.Sprintf(`{"error": "content_type %s is not allowed for %s uploads"}`, req.ContentType, req.Kind), http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		http.Error(w, `{"error": "size is required"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Size > uploads.MaxBytes() {
+		http.Error(w, fmt.Sprintf(`{"error": "Files are limited to %d bytes"}`, uploads.MaxBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	upload, url, err := uploads.Sign(r.Context(), userID.Hex(), req.Kind, req.Filename, req.ContentType, req.Size)
+	if errors.Is(err, uploads.ErrTooManyPending) {
+		http.Error(w, `{"error": "Too many unconfirmed uploads"}`, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to sign upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SignUploadResponse{
+		UploadID:   upload.ID.Hex(),
+		Method:     http.MethodPut,
+		URL:        url,
+		Headers:    map[string]string{"Content-Type": upload.ContentType},
+		ExpiresAt:  upload.ExpiresAt,
+		ConfirmURL: "/user/uploads/" + upload.ID.Hex() + "/confirm",
+	})
+}
+
+// @Summary Confirm a direct upload
+// @Description Check the uploaded object: it must exist, have the declared size, and its first bytes must match the kind of upload. Rejected objects are deleted from storage
+// @Tags user
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Security BearerAuth
+// @Success 200 {object} DirectUploadResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 422 {object} DirectUploadResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/uploads/{id}/confirm [post]
+func ConfirmUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid upload ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	upload, err := uploads.Confirm(r.Context(), userID.Hex(), id)
+	var rejection *uploads.Rejection
+	switch {
+	case errors.As(err, &rejection):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(DirectUploadResponse{DirectUpload: *upload})
+		return
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(w, `{"error": "Upload not found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, uploads.ErrNotPending):
+		http.Error(w, `{"error": "Upload was already confirmed, rejected or expired"}`, http.StatusConflict)
+		return
+	case errors.Is(err, uploads.ErrNotUploaded):
+		http.Error(w, `{"error": "File has not been uploaded yet"}`, http.StatusConflict)
+		return
+	case errors.Is(err, uploads.ErrExpired):
+		http.Error(w, `{"error": "Upload expired, sign a new one"}`, http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Failed to confirm upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(DirectUploadResponse{DirectUpload: *upload, DownloadURL: uploads.DownloadURL(upload.Key)})
+}
+
+// @Summary List your uploads
+// @Description The 50 most recent direct uploads with their status
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} DirectUploadsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/uploads [get]
+func ListUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	list, err := uploads.List(r.Context(), userID.Hex(), 50)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch uploads"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(DirectUploadsResponse{Uploads: list})
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/uploads"
+)
+
+// pngBytes starts like a PNG image
+const pngBytes = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+// objectStore is an S3 compatible bucket kept in memory
+type objectStore struct {
+	bucket  string
+	url     string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// newObjectStore serves a bucket for the test
+func newObjectStore(t *testing.T, bucket string) *objectStore {
+	t.Helper()
+	store := &objectStore{bucket: bucket, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+	store.url = srv.URL
+	return store
+}
+
+// uploadBucket points direct uploads at an objectStore for the test
+func uploadBucket(t *testing.T) *objectStore {
+	t.Helper()
+	store := newObjectStore(t, "uploads")
+	cfg := *testConfig
+	cfg.UploadBucket, cfg.UploadStorageEndpoint, cfg.UploadStorageRegion = store.bucket, store.url, "us-east-1"
+	cfg.UploadURLTTL, cfg.UploadMaxBytes = 15*time.Minute, 1<<20
+	uploads.Init(&cfg)
+	return store
+}
+
+func (s *objectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[r.URL.Path] = body
+		return
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	content, ok := s.objects[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := md5.Sum(content)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		return
+	}
+	var end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=0-%d", &end); err == nil && end+1 < len(content) {
+		content = content[:end+1]
+	}
+	w.Write(content)
+}
+
+// put stores an object as a client following a presigned URL would
+func (s *objectStore) put(key, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects["/"+s.bucket+"/"+key] = []byte(content)
+}
+
+// has reports whether an object is stored
+func (s *objectStore) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects["/"+s.bucket+"/"+key]
+	return ok
+}
+
+// signedUpload signs a PNG avatar upload of size bytes for the user as
+// {upload}; unless content is empty, it is uploaded too
+func signedUpload(store *objectStore, content string, size int64) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		upload, _, err := uploads.Sign(context.Background(), f.user.user.ID.Hex(), models.UploadAvatar, "me.png", "image/png", size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != "" {
+			store.put(upload.Key, content)
+		}
+		f.set("upload", upload.ID.Hex())
+		f.set("key", upload.Key)
+	}
+}
+
+// uploadStatus checks the stored status of {upload}
+func uploadStatus(t *testing.T, f *fixture, status string) {
+	t.Helper()
+	if n := f.srv.Count("direct_uploads", bson.M{"key": f.vars["key"], "status": status}); n != 1 {
+		t.Fatalf("upload not %s", status)
+	}
+}
+
+func TestSignUpload(t *testing.T) {
+	uploadBucket(t)
+	sign := func(kind, contentType string, size int) string {
+		return fmt.Sprintf(`{"kind":%q,"filename":"me.png","content_type":%q,"size":%d}`, kind, contentType, size)
+	}
+	tooMany := func(t *testing.T, f *fixture) {
+		for i := 0; i < 20; i++ {
+			if _, _, err := uploads.Sign(context.Background(), f.user.user.ID.Hex(), models.UploadFile, "notes.txt", "text/plain", 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(SignUpload), []handlerCase{
+		{
+			name: "signed", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "image/png", 48213),
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp SignUploadResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Method != http.MethodPut || !strings.Contains(resp.URL, "/uploads/"+f.user.user.ID.Hex()+"/"+resp.UploadID+"/me.png?") ||
+					resp.Headers["Content-Type"] != "image/png" || resp.ConfirmURL != "/user/uploads/"+resp.UploadID+"/confirm" || !resp.ExpiresAt.After(clock.Now()) {
+					t.Fatalf("got %+v", resp)
+				}
+				if n := f.srv.Count("direct_uploads", bson.M{"user_id": f.user.user.ID.Hex(), "status": models.DirectUploadPending, "size": 48213}); n != 1 {
+					t.Fatal("upload not recorded")
+				}
+			},
+		},
+		{name: "unknown kind", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("video", "video/mp4", 10), status: http.StatusBadRequest},
+		{name: "no filename", as: "user", method: "POST", target: "/user/uploads/sign", body: `{"kind":"avatar","content_type":"image/png","size":10}`, status: http.StatusBadRequest},
+		{name: "type not allowed", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "application/pdf", 10), status: http.StatusBadRequest},
+		{name: "no size", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "image/png", 0), status: http.StatusBadRequest},
+		{name: "too large", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "image/png", 1<<20+1), status: http.StatusRequestEntityTooLarge},
+		{name: "too many pending", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "image/png", 10), setup: tooMany, status: http.StatusTooManyRequests},
+		{name: "malformed body", as: "user", method: "POST", target: "/user/uploads/sign", body: `{"size":"big"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "user", method: "POST", target: "/user/uploads/sign", body: sign("avatar", "image/png", 10), setup: dbDown("direct_uploads"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestConfirmUpload(t *testing.T) {
+	store := uploadBucket(t)
+	h := route("/user/uploads/{id}/confirm", http.HandlerFunc(ConfirmUpload))
+	image := pngBytes + strings.Repeat("\x00", 100)
+	rejected := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var resp DirectUploadResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Status != models.DirectUploadRejected || resp.Reason == "" || resp.DownloadURL != "" {
+			t.Fatalf("got %+v", resp)
+		}
+		uploadStatus(t, f, models.DirectUploadRejected)
+		if store.has(f.vars["key"]) {
+			t.Fatal("rejected object kept")
+		}
+	}
+	confirmed := func(t *testing.T, f *fixture) {
+		signedUpload(store, image, int64(len(image)))(t, f)
+		id, _ := primitive.ObjectIDFromHex(f.vars["upload"])
+		if _, err := uploads.Confirm(context.Background(), f.user.user.ID.Hex(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := func(t *testing.T, f *fixture) {
+		_, err := database.DB.Collection("direct_uploads").UpdateOne(context.Background(), bson.M{"key": f.vars["key"]}, bson.M{"$set": bson.M{"expires_at": clock.Now().Add(-2 * time.Hour)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "confirmed", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm",
+			setup:  signedUpload(store, image, int64(len(image))),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp DirectUploadResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				sum := md5.Sum([]byte(image))
+				if resp.Status != models.DirectUploadConfirmed || resp.Checksum != hex.EncodeToString(sum[:]) || resp.DetectedType != "image/png" || resp.ConfirmedAt == nil || resp.DownloadURL == "" {
+					t.Fatalf("got %+v", resp)
+				}
+				uploadStatus(t, f, models.DirectUploadConfirmed)
+			},
+		},
+		{name: "wrong size", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm", setup: signedUpload(store, image, 10), status: http.StatusUnprocessableEntity, check: rejected},
+		{name: "not an image", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm", setup: signedUpload(store, "plain text", 10), status: http.StatusUnprocessableEntity, check: rejected},
+		{
+			name: "not uploaded", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm",
+			setup:  signedUpload(store, "", 10),
+			status: http.StatusConflict,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				uploadStatus(t, f, models.DirectUploadPending)
+			},
+		},
+		{name: "already confirmed", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm", setup: confirmed, status: http.StatusConflict},
+		{name: "expired", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm", setup: setups(signedUpload(store, image, int64(len(image))), expired), status: http.StatusGone},
+		{name: "someone else's upload", as: "admin", method: "POST", target: "/user/uploads/{upload}/confirm", setup: signedUpload(store, image, int64(len(image))), status: http.StatusNotFound},
+		{name: "unknown upload", as: "user", method: "POST", target: "/user/uploads/{missing}/confirm", status: http.StatusNotFound},
+		{name: "invalid ID", as: "user", method: "POST", target: "/user/uploads/nope/confirm", status: http.StatusBadRequest},
+		{name: "database down", as: "user", method: "POST", target: "/user/uploads/{upload}/confirm", setup: setups(signedUpload(store, image, int64(len(image))), dbDown("direct_uploads")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListUploads(t *testing.T) {
+	store := uploadBucket(t)
+	earlier := func(t *testing.T, f *fixture) {
+		_, err := database.DB.Collection("direct_uploads").UpdateOne(context.Background(), bson.M{"key": f.vars["key"]}, bson.M{"$set": bson.M{"created_at": clock.Now().Add(-time.Hour)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, http.HandlerFunc(ListUploads), []handlerCase{
+		{
+			name: "newest first", as: "user", method: "GET", target: "/user/uploads",
+			setup:  setups(signedUpload(store, "", 10), earlier, signedUpload(store, "", 20)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp DirectUploadsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Uploads) != 2 || resp.Uploads[0].Size != 20 || resp.Uploads[1].Size != 10 {
+					t.Fatalf("got %+v", resp.Uploads)
+				}
+			},
+		},
+		{
+			name: "only yours", as: "admin", method: "GET", target: "/user/uploads",
+			setup:  signedUpload(store, "", 10),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"uploads":[]`) {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "database down", as: "user", method: "GET", target: "/user/uploads", setup: dbDown("direct_uploads"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/tenant"
+	"golang-backend/utils"
+)
+
+// hostPattern accepts fully qualified host names such as app.acme.example
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// AddOrgDomainRequest represents the request for registering a custom domain
+type AddOrgDomainRequest struct {
+	Host string `json:"host" example:"app.acme.example"`
+}
+
+// OrgDomainResponse is a custom domain with the DNS record that verifies it
+type OrgDomainResponse struct {
+	models.OrgDomain
+	TXTName  string `json:"txt_name" example:"_gbk-verify.app.acme.example"`
+	TXTValue string `json:"txt_value" example:"gbk-verify=3f9c..."`
+}
+
+// ListOrgDomainsResponse represents an organization's custom domains
+type ListOrgDomainsResponse struct {
+	Domains []OrgDomainResponse `json:"domains"`
+}
+
+func domainResponse(domain *models.OrgDomain) OrgDomainResponse {
+	name, value := tenant.TXTRecord(domain)
+	return OrgDomainResponse{OrgDomain: *domain, TXTName: name, TXTValue: value}
+}
+
+// @Summary Add a custom domain
+// @Description Register a custom domain for an organization. Publish the returned TXT record, then verify the domain; requests to it are then served as the organization (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body AddOrgDomainRequest true "Domain"
+// @Security BearerAuth
+// @Success 201 {object} OrgDomainResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/domains [post]
+func AddOrgDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	var req AddOrgDomainRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	host := tenant.NormalizeHost(req.Host)
+	if !hostPattern.MatchString(host) {
+		http.Error(w, `{"error": "Invalid host name"}`, http.StatusBadRequest)
+		return
+	}
+
+	domain, err := tenant.Add(r.Context(), org.ID, host)
+	if err == tenant.ErrDomainTaken {
+		http.Error(w, `{"error": "Domain is registered to another organization"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to add domain"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionOrgDomainAdd, org.ID.Hex(), nil, bson.M{"host": host}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit domain %s of organization %s: %v", host, org.ID.Hex(), err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(domainResponse(domain))
+}
+
+// @Summary List custom domains
+// @Description List an organization's custom domains with their verification records (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} ListOrgDomainsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/domains [get]
+func ListOrgDomains(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	domains, err := tenant.List(r.Context(), org.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch domains"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := ListOrgDomainsResponse{Domains: make([]OrgDomainResponse, 0, len(domains))}
+	for i := range domains {
+		response.Domains = append(response.Domains, domainResponse(&domains[i]))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary Verify a custom domain
+// @Description Check the domain's DNS TXT record and start serving the domain as the organization once it matches (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param domainID path string true "Domain ID"
+// @Security BearerAuth
+// @Success 200 {object} OrgDomainResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/domains/{domainID}/verify [post]
+func VerifyOrgDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	domain, status, msg := findOrgDomain(r)
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	if !domain.Verified {
+		err := tenant.Verify(r.Context(), domain)
+		if err == tenant.ErrNotVerified {
+			http.Error(w, `{"error": "TXT record not found or does not match"}`, http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to verify domain"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionOrgDomainVerify, domain.OrgID.Hex(), nil, bson.M{"host": domain.Host}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit verification of domain %s: %v", domain.Host, err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(domainResponse(domain))
+}
+
+// @Summary Remove a custom domain
+// @Description Stop serving a custom domain as the organization (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param domainID path string true "Domain ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/domains/{domainID} [delete]
+func RemoveOrgDomain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	domain, status, msg := findOrgDomain(r)
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	if err := tenant.Remove(r.Context(), domain); err != nil {
+		http.Error(w, `{"error": "Failed to remove domain"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionOrgDomainRemove, domain.OrgID.Hex(), bson.M{"host": domain.Host}, nil); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit removal of domain %s: %v", domain.Host, err)
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Domain removed"})
+}
+
+// findOrgDomain loads the domain named by the id and domainID path variables
+func findOrgDomain(r *http.Request) (*models.OrgDomain, int, string) {
+	vars := mux.Vars(r)
+	orgID, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid organization ID format"
+	}
+	domainID, err := primitive.ObjectIDFromHex(vars["domainID"])
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid domain ID format"
+	}
+
+	domain, err := tenant.Get(r.Context(), orgID, domainID)
+	if err == mongo.ErrNoDocuments {
+		return nil, http.StatusNotFound, "Domain not found"
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to fetch domain"
+	}
+	return domain, http.StatusOK, ""
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/tenant"
+)
+
+// orgDomain registers host for {org} as {domain}, verified if set
+func orgDomain(host string, verified bool) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		createOrg(t, f)
+		orgID, _ := primitive.ObjectIDFromHex(testOrg)
+		domain, err := tenant.Add(context.Background(), orgID, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verified {
+			if _, err := database.DB.Collection("org_domains").UpdateOne(context.Background(), bson.M{"_id": domain.ID}, bson.M{"$set": bson.M{"verified": true}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f.set("domain", domain.ID.Hex())
+	}
+}
+
+// otherOrgDomain registers host for another organization
+func otherOrgDomain(host string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		if _, err := tenant.Add(context.Background(), primitive.NewObjectID(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddOrgDomain(t *testing.T) {
+	h := route("/admin/orgs/{id}/domains", http.HandlerFunc(AddOrgDomain))
+	added := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var resp OrgDomainResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Host != "app.acme.example" || resp.Verified || resp.TXTName != tenant.TXTPrefix+"app.acme.example" || !strings.HasPrefix(resp.TXTValue, "gbk-verify=") {
+			t.Fatalf("got %+v", resp)
+		}
+		if n := f.srv.Count("org_domains", bson.M{"host": "app.acme.example"}); n != 1 {
+			t.Fatal("domain not stored once")
+		}
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionOrgDomainAdd, "target_id": testOrg, "after.host": "app.acme.example"}); n != 1 {
+			t.Fatal("domain not audited")
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "added", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":"App.Acme.Example."}`, setup: createOrg, status: http.StatusCreated, check: added},
+		{
+			name: "added again", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":"app.acme.example"}`,
+			setup:  orgDomain("app.acme.example", false),
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp OrgDomainResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.ID.Hex() != f.vars["domain"] || f.srv.Count("org_domains", bson.M{}) != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+		{name: "taken", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":"app.acme.example"}`, setup: setups(createOrg, otherOrgDomain("app.acme.example")), status: http.StatusConflict},
+		{name: "invalid host", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":"acme"}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":1}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "POST", target: "/admin/orgs/{missing}/domains", body: `{"host":"app.acme.example"}`, status: http.StatusNotFound},
+		{name: "invalid organization ID", as: "admin", method: "POST", target: "/admin/orgs/nope/domains", body: `{"host":"app.acme.example"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains", body: `{"host":"app.acme.example"}`, setup: setups(createOrg, dbDown("org_domains")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListOrgDomains(t *testing.T) {
+	h := route("/admin/orgs/{id}/domains", http.HandlerFunc(ListOrgDomains))
+	listed := func(hosts ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ListOrgDomainsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Domains) != len(hosts) {
+				t.Fatalf("got %+v, want %v", resp.Domains, hosts)
+			}
+			for i, host := range hosts {
+				if resp.Domains[i].Host != host || resp.Domains[i].TXTValue == "" {
+					t.Fatalf("got %+v, want %v", resp.Domains, hosts)
+				}
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "domains", as: "admin", method: "GET", target: "/admin/orgs/{org}/domains", setup: setups(orgDomain("app.acme.example", true), otherOrgDomain("app.other.example")), status: http.StatusOK, check: listed("app.acme.example")},
+		{name: "no domains", as: "admin", method: "GET", target: "/admin/orgs/{org}/domains", setup: createOrg, status: http.StatusOK, check: listed()},
+		{name: "unknown organization", as: "admin", method: "GET", target: "/admin/orgs/{missing}/domains", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/orgs/{org}/domains", setup: setups(createOrg, dbDown("org_domains")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestVerifyOrgDomain(t *testing.T) {
+	h := route("/admin/orgs/{id}/domains/{domainID}/verify", http.HandlerFunc(VerifyOrgDomain))
+	runCases(t, h, []handlerCase{
+		{
+			name: "already verified", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains/{domain}/verify",
+			setup:  orgDomain("app.acme.example", true),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp OrgDomainResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if !resp.Verified {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+		{
+			name: "no TXT record", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains/{domain}/verify",
+			setup:  orgDomain("app.acme.invalid", false),
+			status: http.StatusUnprocessableEntity,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("org_domains", bson.M{"verified": true}); n != 0 {
+					t.Fatal("domain verified")
+				}
+			},
+		},
+		{name: "another organization's domain", as: "admin", method: "POST", target: "/admin/orgs/{missing}/domains/{domain}/verify", setup: orgDomain("app.acme.example", true), status: http.StatusNotFound},
+		{name: "invalid domain ID", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains/nope/verify", setup: createOrg, status: http.StatusBadRequest},
+		{name: "invalid organization ID", as: "admin", method: "POST", target: "/admin/orgs/nope/domains/{missing}/verify", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/orgs/{org}/domains/{domain}/verify", setup: setups(orgDomain("app.acme.example", true), dbDown("org_domains")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRemoveOrgDomain(t *testing.T) {
+	h := route("/admin/orgs/{id}/domains/{domainID}", http.HandlerFunc(RemoveOrgDomain))
+	runCases(t, h, []handlerCase{
+		{
+			name: "removed", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/domains/{domain}",
+			setup:  setups(orgDomain("app.acme.example", true), otherOrgDomain("app.other.example")),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("org_domains", bson.M{}); n != 1 {
+					t.Fatal("domain not removed")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionOrgDomainRemove, "target_id": testOrg, "before.host": "app.acme.example"}); n != 1 {
+					t.Fatal("removal not audited")
+				}
+			},
+		},
+		{name: "unknown domain", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/domains/{missing}", setup: createOrg, status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/domains/{domain}", setup: setups(orgDomain("app.acme.example", true), dbDown("org_domains")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/mailer"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/utils"
+)
+
+var (
+	localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+	colorPattern  = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// normalizeLocale returns a lowercase language tag such as "pt-br", or ""
+// when the value is not one
+func normalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if !localePattern.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// requestLocale returns the locale a client asked for explicitly, or the first
+// language of its Accept-Language header
+func requestLocale(r *http.Request, explicit string) string {
+	if locale := normalizeLocale(explicit); locale != "" {
+		return locale
+	}
+	first, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	first, _, _ = strings.Cut(first, ";")
+	return normalizeLocale(first)
+}
+
+// emailBrand returns the deployment's branding, overridden by the
+// organization's when the user belongs to one that customized it
+func emailBrand(ctx context.Context, cfg *config.Config, orgID string) mailer.Brand {
+	brand := mailer.Brand{
+		Name:         cfg.MailBrandName,
+		SenderName:   cfg.MailBrandName,
+		LogoURL:      cfg.MailBrandLogoURL,
+		PrimaryColor: cfg.MailBrandColor,
+	}
+	if orgID == "" {
+		return brand
+	}
+
+	org, status, _ := findOrganization(orgID)
+	if status != http.StatusOK {
+		return brand
+	}
+	brand.Name = org.Name
+	brand.SenderName = org.Name
+	if b := org.Branding; b != nil {
+		if b.SenderName != "" {
+			brand.SenderName = b.SenderName
+		}
+		if b.LogoURL != "" {
+			brand.LogoURL = b.LogoURL
+		}
+		if b.PrimaryColor != "" {
+			brand.PrimaryColor = b.PrimaryColor
+		}
+	}
+	return brand
+}
+
+// renderUserEmail renders a template for a user in their locale, falling back
+// to the default locale, and with their organization's branding
+func renderUserEmail(ctx context.Context, cfg *config.Config, name, to string, user *models.User, vars map[string]string) (mailer.Message, error) {
+	data := mailer.TemplateData{
+		Locale: mailer.ResolveLocale(name, user.Locale, cfg.MailDefaultLocale),
+		AppURL: cfg.AppURL,
+		Brand:  emailBrand(ctx, cfg, user.OrgID),
+		User:   mailer.Recipient{Email: to, DisplayName: user.DisplayName},
+		Vars:   vars,
+	}
+	return mailer.Render(name, to, data)
+}
+
+// sendAnonymously runs send in the background for a request naming an
+// address or number that may not belong to an account, so the response time
+// does not tell whether it does. send looks the account up itself and
+// returns quietly when there is none.
+func sendAnonymously(send func(ctx context.Context)) {
+	go send(context.Background())
+}
+
+// sendRequested emails a message the user asked for, such as a reset or
+// sign-in link. Muted categories do not apply; they only hold back
+// notifications.
+func sendRequested(mail mailer.Mailer, msg mailer.Message) error {
+	return mail.SendMessage(msg)
+}
+
+// sendWelcomeEmail sends the welcome email in the background
+func sendWelcomeEmail(cfg *config.Config, to string, user models.User) {
+	if !cfg.WelcomeEmailEnabled {
+		return
+	}
+	go func() {
+		msg, err := renderUserEmail(context.Background(), cfg, "welcome", to, &user, nil)
+		if err != nil {
+			log.Printf("Failed to render welcome email for user %s: %v", user.ID.Hex(), err)
+			return
+		}
+		welcome := notifier.UserMessage{Type: "account.welcome", Category: models.CategoryProduct, Email: &msg, Title: msg.Subject}
+		if err := notifier.Deliver(context.Background(), cfg, &user, welcome); err != nil {
+			log.Printf("Failed to send welcome email to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
+
+// @Summary Update organization branding
+// @Description Set the sender name, logo and accent color used in emails to the organization's users. Empty fields fall back to the defaults (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body models.OrgBranding true "Branding"
+// @Security BearerAuth
+// @Success 200 {object} models.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/branding [put]
+func UpdateOrganizationBranding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	var req models.OrgBranding
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.SenderName = strings.TrimSpace(req.SenderName)
+	req.LogoURL = strings.TrimSpace(req.LogoURL)
+	req.PrimaryColor = strings.TrimSpace(req.PrimaryColor)
+	if req.PrimaryColor != "" && !colorPattern.MatchString(req.PrimaryColor) {
+		http.Error(w, `{"error": "primary_color must be a hex color such as #d0021b"}`, http.StatusBadRequest)
+		return
+	}
+	if req.LogoURL != "" {
+		if u, err := url.Parse(req.LogoURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			http.Error(w, `{"error": "logo_url must be an https URL"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	before := bson.M{"branding": org.Branding}
+	org.Branding = &req
+	org.UpdatedAt = clock.Now()
+	update := bson.M{"$set": bson.M{"branding": org.Branding, "updated_at": org.UpdatedAt}}
+	if _, err := database.DB.Collection("organizations").UpdateOne(r.Context(), bson.M{"_id": org.ID}, update); err != nil {
+		http.Error(w, `{"error": "Failed to update branding"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagOrgs)
+
+	if _, err := audit.Record(r, audit.ActionOrgBranding, org.ID.Hex(), before, bson.M{"branding": org.Branding}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit branding change of organization %s: %v", org.ID.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(org)
+}
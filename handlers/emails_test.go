@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// orgBranding gives {org} its own branding
+func orgBranding(branding *models.OrgBranding) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		createOrg(t, f)
+		orgID, _ := primitive.ObjectIDFromHex(testOrg)
+		if _, err := database.DB.Collection("organizations").UpdateOne(context.Background(), bson.M{"_id": orgID}, bson.M{"$set": bson.M{"branding": branding}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	cases := []struct {
+		explicit, header, want string
+	}{
+		{"pt_BR", "de", "pt-br"},
+		{"", "fr-CA,fr;q=0.9,en;q=0.8", "fr-ca"},
+		{"", "de;q=0.9", "de"},
+		{"not a locale", "es", "es"},
+		{"", "*", ""},
+		{"", "", ""},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", tc.header)
+		if got := requestLocale(r, tc.explicit); got != tc.want {
+			t.Errorf("requestLocale(%q, %q) = %q, want %q", tc.explicit, tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestEmailBrand(t *testing.T) {
+	f := newFixture(t)
+	cfg := *testConfig
+	cfg.MailBrandName, cfg.MailBrandLogoURL, cfg.MailBrandColor = "Backend", "https://example.com/logo.png", "#000000"
+
+	if brand := emailBrand(context.Background(), &cfg, ""); brand.Name != "Backend" || brand.SenderName != "Backend" || brand.PrimaryColor != "#000000" {
+		t.Fatalf("without an organization: got %+v", brand)
+	}
+	if brand := emailBrand(context.Background(), &cfg, testOrg); brand.Name != "Backend" {
+		t.Fatalf("unknown organization: got %+v", brand)
+	}
+	createOrg(t, f)
+	if brand := emailBrand(context.Background(), &cfg, testOrg); brand.Name != "Acme" || brand.SenderName != "Acme" || brand.LogoURL != cfg.MailBrandLogoURL {
+		t.Fatalf("organization: got %+v", brand)
+	}
+	orgBranding(&models.OrgBranding{SenderName: "Acme Support", PrimaryColor: "#d0021b"})(t, f)
+	if brand := emailBrand(context.Background(), &cfg, testOrg); brand.Name != "Acme" || brand.SenderName != "Acme Support" || brand.PrimaryColor != "#d0021b" || brand.LogoURL != cfg.MailBrandLogoURL {
+		t.Fatalf("organization branding: got %+v", brand)
+	}
+}
+
+func TestUpdateOrganizationBranding(t *testing.T) {
+	h := route("/admin/orgs/{id}/branding", http.HandlerFunc(UpdateOrganizationBranding))
+	branded := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var org models.Organization
+		json.Unmarshal(rec.Body.Bytes(), &org)
+		if org.Branding == nil || org.Branding.SenderName != "Acme Support" || org.Branding.LogoURL != "https://acme.example/logo.png" || org.Branding.PrimaryColor != "#d0021b" {
+			t.Fatalf("got %+v", org.Branding)
+		}
+		if n := f.srv.Count("organizations", bson.M{"branding.sender_name": "Acme Support", "branding.primary_color": "#d0021b"}); n != 1 {
+			t.Fatal("branding not stored")
+		}
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionOrgBranding, "target_id": testOrg, "after.branding.sender_name": "Acme Support"}); n != 1 {
+			t.Fatal("branding not audited")
+		}
+	}
+	body := `{"sender_name":" Acme Support ","logo_url":"https://acme.example/logo.png","primary_color":"#d0021b"}`
+	runCases(t, h, []handlerCase{
+		{name: "branded", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: body, setup: createOrg, status: http.StatusOK, check: branded},
+		{name: "rebranded", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: body, setup: orgBranding(&models.OrgBranding{SenderName: "Acme"}), status: http.StatusOK, check: branded},
+		{name: "reset", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: `{}`, setup: orgBranding(&models.OrgBranding{SenderName: "Acme"}), status: http.StatusOK},
+		{name: "invalid color", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: `{"primary_color":"red"}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "insecure logo", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: `{"logo_url":"http://acme.example/logo.png"}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: `{"sender_name":1}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "PUT", target: "/admin/orgs/{missing}/branding", body: body, status: http.StatusNotFound},
+		{name: "invalid organization ID", as: "admin", method: "PUT", target: "/admin/orgs/nope/branding", body: body, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/orgs/{org}/branding", body: body, setup: setups(createOrg, dbDown("organizations")), status: http.StatusInternalServerError},
+	})
+}
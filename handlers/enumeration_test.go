@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang-backend/mailer"
+	"golang-backend/utils"
+)
+
+// probeResponse is what a client learns from one response: its status,
+// content type and body shape, and its timing class, the number of bcrypt
+// operations the request cost
+type probeResponse struct {
+	status      int
+	contentType string
+	shape       interface{}
+	bcrypt      int64
+}
+
+// probe sends a request and describes its response
+func probe(h http.Handler, target, body string) probeResponse {
+	before := utils.PasswordPool().Completed
+	rec := request(h, "POST", target, "", body)
+	resp := probeResponse{
+		status:      rec.Code,
+		contentType: rec.Header().Get("Content-Type"),
+		bcrypt:      utils.PasswordPool().Completed - before,
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err == nil {
+		resp.shape = shapeOf(decoded)
+	} else {
+		resp.shape = strings.TrimSpace(rec.Body.String())
+	}
+	return resp
+}
+
+// shapeOf replaces the values of a decoded JSON document with their types,
+// keeping object keys, so responses with different tokens compare equal
+func shapeOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		shape := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			shape[k] = shapeOf(item)
+		}
+		return shape
+	case []interface{}:
+		if len(v) == 0 {
+			return []interface{}{}
+		}
+		return []interface{}{shapeOf(v[0])}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	default:
+		return "null"
+	}
+}
+
+// TestEnumerationHardening sends the same request for a registered address
+// and for one without an account: with ENUMERATION_HARDENING the responses
+// must agree in status, content type, body shape and timing class.
+func TestEnumerationHardening(t *testing.T) {
+	cfg := *testConfig
+	cfg.EnumerationHardening, cfg.RegistrationEnumeration = true, EnumerationSilent
+	mail := mailer.New(&cfg)
+
+	credentials := func(email string) string {
+		return `{"email":"` + email + `","password":"wrong-Password-1"}`
+	}
+	address := func(email string) string {
+		return `{"email":"` + email + `"}`
+	}
+	for _, tc := range []struct {
+		name, target string
+		handler      http.Handler
+		known        string
+		body         func(email string) string
+	}{
+		{"login", "/login", Login(&cfg), "user@example.com", credentials},
+		{"admin login", "/admin/login", AdminLogin(&cfg), "admin@example.com", credentials},
+		{"admin login as user", "/admin/login", AdminLogin(&cfg), "user@example.com", credentials},
+		{"password reset", "/password/forgot", ForgotPassword(&cfg, mail), "user@example.com", address},
+		{"sign-in link", "/login/magic", RequestMagicLink(&cfg, mail), "user@example.com", address},
+		{"register", "/register", Register(&cfg), "user@example.com", registration},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newFixture(t)
+			known := probe(tc.handler, tc.target, tc.body(tc.known))
+			unknown := probe(tc.handler, tc.target, tc.body("nobody@example.com"))
+			if !reflect.DeepEqual(known, unknown) {
+				t.Fatalf("registered address got %+v, unknown address %+v", known, unknown)
+			}
+		})
+	}
+}
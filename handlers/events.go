@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/watcher"
+)
+
+// @Summary Stream account changes
+// @Description Server-Sent Events stream of changes to the current user's account, from any server instance
+// @Tags user
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Success 200 {object} watcher.Event
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/events [get]
+func UserEvents(w http.ResponseWriter, r *http.Request) {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	userID, _ := claims["userID"].(string)
+
+	streamEvents(w, r, func(event watcher.Event) bool { return event.UserID == userID })
+}
+
+// @Summary Stream user changes
+// @Description Server-Sent Events stream of every user document change (Admin only)
+// @Tags admin
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Success 200 {object} watcher.Event
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/events [get]
+func AdminUserEvents(w http.ResponseWriter, r *http.Request) {
+	streamEvents(w, r, nil)
+}
+
+// streamEvents writes watcher events as SSE until the client disconnects
+func streamEvents(w http.ResponseWriter, r *http.Request, filter func(watcher.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `{"error": "Streaming not supported"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	events, cancel := watcher.Subscribe(filter)
+	defer cancel()
+
+	// Comments keep idle connections open through proxies
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: user.%s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// noFlusher is a ResponseWriter that cannot stream
+type noFlusher struct {
+	http.ResponseWriter
+}
+
+func TestStreamEvents(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{"user": UserEvents, "admin": AdminUserEvents} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest("GET", "/events", nil).WithContext(ctx))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" || rec.Header().Get("Cache-Control") != "no-cache" || !rec.Flushed {
+				t.Fatalf("got %d %v", rec.Code, rec.Header())
+			}
+		})
+	}
+
+	t.Run("streaming not supported", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		UserEvents(noFlusher{rec}, httptest.NewRequest("GET", "/user/events", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("got %d", rec.Code)
+		}
+	})
+}
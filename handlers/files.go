@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/files"
+	"golang-backend/models"
+	"golang-backend/uploads"
+	"golang-backend/utils"
+)
+
+// CreateFileRequest attaches a confirmed upload as a file
+type CreateFileRequest struct {
+	UploadID   string `json:"upload_id" example:"6650c1a2b3d4e5f60718293a"`
+	Name       string `json:"name,omitempty" example:"report.pdf"`
+	Visibility string `json:"visibility,omitempty" example:"private"`
+}
+
+// UpdateFileRequest renames a file or changes who can see it
+type UpdateFileRequest struct {
+	Name       string `json:"name,omitempty" example:"report-final.pdf"`
+	Visibility string `json:"visibility,omitempty" example:"org"`
+}
+
+// FileResponse is a file with a URL to download its content
+type FileResponse struct {
+	models.File
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// FilesResponse lists a user's files
+type FilesResponse struct {
+	Files []models.File `json:"files"`
+}
+
+// validVisibility reports whether v is a file visibility
+func validVisibility(v string) bool {
+	return v == models.FilePrivate || v == models.FileOrg || v == models.FilePublic
+}
+
+// @Summary Create a file
+// @Description Attach a confirmed direct upload as a file. Size, checksum and content type are taken from the upload; name defaults to the uploaded file name. Visibility is "private" (default), "org" (members of your organization) or "public" (every signed-in user)
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body CreateFileRequest true "Upload to attach"
+// @Security BearerAuth
+// @Success 201 {object} FileResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/files [post]
+func CreateFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req CreateFileRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	uploadID, err := primitive.ObjectIDFromHex(req.UploadID)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid upload ID format"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Name) > 255 {
+		http.Error(w, `{"error": "name must be at most 255 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Visibility == "" {
+		req.Visibility = models.FilePrivate
+	}
+	if !validVisibility(req.Visibility) {
+		http.Error(w, `{"error": "visibility must be private, org or public"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	orgID, _ := claims["orgID"].(string)
+	if req.Visibility == models.FileOrg && orgID == "" {
+		http.Error(w, `{"error": "You are not a member of an organization"}`, http.StatusBadRequest)
+		return
+	}
+
+	file, err := files.Create(r.Context(), userID.Hex(), orgID, uploadID, req.Name, req.Visibility)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(w, `{"error": "Upload not found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, files.ErrUploadNotConfirmed):
+		http.Error(w, `{"error": "Upload must be confirmed first"}`, http.StatusConflict)
+		return
+	case errors.Is(err, files.ErrAlreadyAttached):
+		http.Error(w, `{"error": "Upload is already attached to a file"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Failed to create file"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(FileResponse{File: *file, DownloadURL: uploads.DownloadURL(file.Key)})
+}
+
+// @Summary List your files
+// @Description The 100 most recent files you own
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} FilesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/files [get]
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	list, err := files.List(r.Context(), userID.Hex(), 100)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch files"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(FilesResponse{Files: list})
+}
+
+// @Summary Get a file
+// @Description Metadata of a file you can see, with a short-lived download URL. Files you cannot see are reported as not found
+// @Tags files
+// @Produce json
+// @Param id path string true "File ID"
+// @Security BearerAuth
+// @Success 200 {object} FileResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /files/{id} [get]
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	file := files.FromContext(r.Context())
+	json.NewEncoder(w).Encode(FileResponse{File: *file, DownloadURL: uploads.DownloadURL(file.Key)})
+}
+
+// @Summary Update a file
+// @Description Rename a file or change its visibility (owner or admin only)
+// @Tags files
+// @Accept json
+// @Produce json
+// @Param id path string true "File ID"
+// @Param request body UpdateFileRequest true "Fields to change"
+// @Security BearerAuth
+// @Success 200 {object} models.File
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /files/{id} [patch]
+func UpdateFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	file := files.FromContext(r.Context())
+	var req UpdateFileRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Name) > 255 {
+		http.Error(w, `{"error": "name must be at most 255 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Visibility != "" && !validVisibility(req.Visibility) {
+		http.Error(w, `{"error": "visibility must be private, org or public"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Visibility == models.FileOrg && file.OrgID == "" {
+		http.Error(w, `{"error": "File was not created in an organization"}`, http.StatusBadRequest)
+		return
+	}
+
+	updated, err := files.Update(r.Context(), file.ID, req.Name, req.Visibility)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, `{"error": "File not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update file"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(updated)
+}
+
+// @Summary Delete a file
+// @Description Delete a file and its stored content (owner or admin only)
+// @Tags files
+// @Param id path string true "File ID"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /files/{id} [delete]
+func DeleteFile(w http.ResponseWriter, r *http.Request) {
+	if err := files.Delete(r.Context(), files.FromContext(r.Context())); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Failed to delete file"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/files"
+	"golang-backend/middleware"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/uploads"
+)
+
+// testOrg is the organization of files and users set up in one
+const testOrg = "6650c0ffee0000000000000a"
+
+// createOrg stores testOrg as an active organization, as {org}
+func createOrg(t *testing.T, f *fixture) {
+	t.Helper()
+	orgID, _ := primitive.ObjectIDFromHex(testOrg)
+	_, err := database.DB.Collection("organizations").UpdateOne(context.Background(), bson.M{"_id": orgID}, bson.M{"$set": bson.M{"name": "Acme"}}, options.Update().SetUpsert(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository.ForgetOrgStatus(testOrg)
+	f.set("org", testOrg)
+}
+
+// joinOrg makes a user a member of testOrg
+func joinOrg(t *testing.T, f *fixture, userID primitive.ObjectID) {
+	t.Helper()
+	createOrg(t, f)
+	if _, err := database.DB.Collection("users").UpdateOne(context.Background(), bson.M{"_id": userID}, bson.M{"$set": bson.M{"org_id": testOrg}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// inOrg makes the signed-in user a member of testOrg
+func inOrg(t *testing.T, f *fixture) {
+	joinOrg(t, f, f.user.user.ID)
+}
+
+// confirmedUpload uploads and confirms a PNG avatar of the user as {upload}
+func confirmedUpload(store *objectStore) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		image := pngBytes + strings.Repeat("\x00", 100)
+		signedUpload(store, image, int64(len(image)))(t, f)
+		id, _ := primitive.ObjectIDFromHex(f.vars["upload"])
+		if _, err := uploads.Confirm(context.Background(), f.user.user.ID.Hex(), id); err != nil {
+			t.Fatal(err)
+		}
+		index := mongo.IndexModel{Keys: bson.M{"upload_id": 1}, Options: options.Index().SetUnique(true)}
+		if _, err := database.DB.Collection("files").Indexes().CreateOne(context.Background(), index); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// userFile attaches a confirmed upload of the user as {file}
+func userFile(store *objectStore, visibility, orgID string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		confirmedUpload(store)(t, f)
+		id, _ := primitive.ObjectIDFromHex(f.vars["upload"])
+		file, err := files.Create(context.Background(), f.user.user.ID.Hex(), orgID, id, "", visibility)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.set("file", file.ID.Hex())
+	}
+}
+
+// stranger requests {file} as another user, a member of testOrg when
+// inOrg is set, and returns the status
+func stranger(t *testing.T, f *fixture, h http.Handler, inOrg bool, method, body string) int {
+	t.Helper()
+	other := createUser(t, "other@example.com", "user")
+	if inOrg {
+		joinOrg(t, f, other.ID)
+	}
+	return signIn(t, other).do(h, method, "/files/"+f.vars["file"], body).Code
+}
+
+// strangerCases checks who besides the owner and admins may reach a file
+func strangerCases(t *testing.T, store *objectStore, h http.Handler, method, body string, cases []struct {
+	visibility string
+	inOrg      bool
+	status     int
+}) {
+	for _, tc := range cases {
+		f := newFixture(t)
+		userFile(store, tc.visibility, testOrg)(t, f)
+		if status := stranger(t, f, h, tc.inOrg, method, body); status != tc.status {
+			t.Errorf("%s file, stranger in the organization %t: got %d, want %d", tc.visibility, tc.inOrg, status, tc.status)
+		}
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	store := uploadBucket(t)
+	created := func(name, visibility, orgID string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp FileResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Name != name || resp.Visibility != visibility || resp.OrgID != orgID ||
+				resp.ContentType != "image/png" || resp.Size == 0 || resp.Checksum == "" || resp.DownloadURL == "" {
+				t.Fatalf("got %+v", resp)
+			}
+			if n := f.srv.Count("files", bson.M{"owner_id": f.user.user.ID.Hex(), "key": f.vars["key"], "visibility": visibility}); n != 1 {
+				t.Fatal("file not stored")
+			}
+		}
+	}
+	attached := func(t *testing.T, f *fixture) {
+		id, _ := primitive.ObjectIDFromHex(f.vars["upload"])
+		if _, err := files.Create(context.Background(), f.user.user.ID.Hex(), "", id, "", models.FilePrivate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, http.HandlerFunc(CreateFile), []handlerCase{
+		{name: "private by default", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}"}`, setup: confirmedUpload(store), status: http.StatusCreated, check: created("me.png", models.FilePrivate, "")},
+		{name: "named and public", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}","name":"avatar.png","visibility":"public"}`, setup: confirmedUpload(store), status: http.StatusCreated, check: created("avatar.png", models.FilePublic, "")},
+		{name: "organization", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}","visibility":"org"}`, setup: setups(confirmedUpload(store), inOrg), status: http.StatusCreated, check: created("me.png", models.FileOrg, testOrg)},
+		{name: "organization without one", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}","visibility":"org"}`, setup: confirmedUpload(store), status: http.StatusBadRequest},
+		{name: "unknown visibility", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}","visibility":"friends"}`, setup: confirmedUpload(store), status: http.StatusBadRequest},
+		{name: "name too long", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}","name":"` + strings.Repeat("a", 256) + `"}`, setup: confirmedUpload(store), status: http.StatusBadRequest},
+		{name: "not confirmed", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}"}`, setup: signedUpload(store, "", 10), status: http.StatusConflict},
+		{name: "already attached", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}"}`, setup: setups(confirmedUpload(store), attached), status: http.StatusConflict},
+		{name: "someone else's upload", as: "admin", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}"}`, setup: confirmedUpload(store), status: http.StatusNotFound},
+		{name: "invalid upload ID", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"nope"}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":1}`, status: http.StatusBadRequest},
+		{name: "database down", as: "user", method: "POST", target: "/user/files", body: `{"upload_id":"{upload}"}`, setup: setups(confirmedUpload(store), dbDown("files")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListFiles(t *testing.T) {
+	store := uploadBucket(t)
+	runCases(t, http.HandlerFunc(ListFiles), []handlerCase{
+		{
+			name: "your files", as: "user", method: "GET", target: "/user/files",
+			setup:  userFile(store, models.FilePublic, ""),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp FilesResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Files) != 1 || resp.Files[0].ID.Hex() != f.vars["file"] {
+					t.Fatalf("got %+v", resp.Files)
+				}
+			},
+		},
+		{
+			name: "not others' files", as: "admin", method: "GET", target: "/user/files",
+			setup:  userFile(store, models.FilePublic, ""),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"files":[]`) {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "database down", as: "user", method: "GET", target: "/user/files", setup: dbDown("files"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestGetFile(t *testing.T) {
+	store := uploadBucket(t)
+	h := route("/files/{id}", middleware.FileAccess(false)(http.HandlerFunc(GetFile)))
+	served := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var resp FileResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.ID.Hex() != f.vars["file"] || !strings.Contains(resp.DownloadURL, f.vars["key"]) {
+			t.Fatalf("got %+v", resp)
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "owner", as: "user", method: "GET", target: "/files/{file}", setup: userFile(store, models.FilePrivate, ""), status: http.StatusOK, check: served},
+		{name: "admin", as: "admin", method: "GET", target: "/files/{file}", setup: userFile(store, models.FilePrivate, ""), status: http.StatusOK, check: served},
+		{name: "unknown file", as: "user", method: "GET", target: "/files/{missing}", status: http.StatusNotFound},
+		{name: "invalid ID", as: "user", method: "GET", target: "/files/nope", status: http.StatusBadRequest},
+		{name: "database down", as: "user", method: "GET", target: "/files/{file}", setup: setups(userFile(store, models.FilePrivate, ""), dbDown("files")), status: http.StatusInternalServerError},
+	})
+	strangerCases(t, store, h, "GET", "", []struct {
+		visibility string
+		inOrg      bool
+		status     int
+	}{
+		{models.FilePrivate, true, http.StatusNotFound},
+		{models.FileOrg, false, http.StatusNotFound},
+		{models.FileOrg, true, http.StatusOK},
+		{models.FilePublic, false, http.StatusOK},
+	})
+}
+
+func TestUpdateFile(t *testing.T) {
+	store := uploadBucket(t)
+	h := route("/files/{id}", middleware.FileAccess(true)(http.HandlerFunc(UpdateFile)))
+	updated := func(name, visibility string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var file models.File
+			json.Unmarshal(rec.Body.Bytes(), &file)
+			if file.Name != name || file.Visibility != visibility {
+				t.Fatalf("got %+v", file)
+			}
+			if n := f.srv.Count("files", bson.M{"name": name, "visibility": visibility}); n != 1 {
+				t.Fatal("file not updated")
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "renamed", as: "user", method: "PATCH", target: "/files/{file}", body: `{"name":"avatar.png"}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusOK, check: updated("avatar.png", models.FilePrivate)},
+		{name: "shared with the organization", as: "user", method: "PATCH", target: "/files/{file}", body: `{"visibility":"org"}`, setup: userFile(store, models.FilePrivate, testOrg), status: http.StatusOK, check: updated("me.png", models.FileOrg)},
+		{name: "by an admin", as: "admin", method: "PATCH", target: "/files/{file}", body: `{"visibility":"public"}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusOK, check: updated("me.png", models.FilePublic)},
+		{name: "no organization", as: "user", method: "PATCH", target: "/files/{file}", body: `{"visibility":"org"}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusBadRequest},
+		{name: "unknown visibility", as: "user", method: "PATCH", target: "/files/{file}", body: `{"visibility":"friends"}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusBadRequest},
+		{name: "name too long", as: "user", method: "PATCH", target: "/files/{file}", body: `{"name":"` + strings.Repeat("a", 256) + `"}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusBadRequest},
+		{name: "malformed body", as: "user", method: "PATCH", target: "/files/{file}", body: `{"name":1}`, setup: userFile(store, models.FilePrivate, ""), status: http.StatusBadRequest},
+		{name: "unknown file", as: "user", method: "PATCH", target: "/files/{missing}", body: `{"name":"x"}`, status: http.StatusNotFound},
+	})
+	strangerCases(t, store, h, "PATCH", `{"name":"x"}`, []struct {
+		visibility string
+		inOrg      bool
+		status     int
+	}{
+		{models.FilePublic, false, http.StatusForbidden},
+		{models.FileOrg, true, http.StatusForbidden},
+		{models.FilePrivate, true, http.StatusNotFound},
+	})
+}
+
+func TestDeleteFile(t *testing.T) {
+	store := uploadBucket(t)
+	h := route("/files/{id}", middleware.FileAccess(true)(http.HandlerFunc(DeleteFile)))
+	deleted := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		if n := f.srv.Count("files", bson.M{}); n != 0 {
+			t.Fatal("file not deleted")
+		}
+		if store.has(f.vars["key"]) {
+			t.Fatal("object not deleted")
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "owner", as: "user", method: "DELETE", target: "/files/{file}", setup: userFile(store, models.FilePrivate, ""), status: http.StatusNoContent, check: deleted},
+		{name: "admin", as: "admin", method: "DELETE", target: "/files/{file}", setup: userFile(store, models.FilePrivate, ""), status: http.StatusNoContent, check: deleted},
+		{name: "unknown file", as: "user", method: "DELETE", target: "/files/{missing}", status: http.StatusNotFound},
+	})
+	strangerCases(t, store, h, "DELETE", "", []struct {
+		visibility string
+		inOrg      bool
+		status     int
+	}{
+		{models.FilePublic, false, http.StatusForbidden},
+		{models.FilePrivate, false, http.StatusNotFound},
+	})
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/deprecation"
+	"golang-backend/eventsource"
+	"golang-backend/loginhistory"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/otp"
+	"golang-backend/repository"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+	"golang-backend/webauthn"
+)
+
+// forgottenActor replaces user IDs in records that must be kept for integrity
+const forgottenActor = audit.Forgotten
+
+// ForgetUserRequest represents the request for erasing a user's personal data
+type ForgetUserRequest struct {
+	Reason string `json:"reason,omitempty" example:"GDPR Art. 17 request #1234"`
+}
+
+// @Summary Forget a user
+// @Description Erase a user's personal data across users, sessions, audit logs, security events, consents, reports and import reports, and issue a signed deletion certificate. Registered webhooks are notified (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body ForgetUserRequest false "Reason"
+// @Security BearerAuth
+// @Success 200 {object} models.DeletionCertificate
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/forget [post]
+func ForgetUser(cfg *config.Config, notify *notifier.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
+
+		var req ForgetUserRequest
+		if r.ContentLength > 0 {
+			if err := utils.DecodeJSON(r.Body, &req); err != nil {
+				http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		affected, err := forgetUser(context.Background(), userID)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			correlation.Errorf(r.Context(), "Failed to forget user %s: %v", userID.Hex(), err)
+			http.Error(w, `{"error": "Failed to erase user data"}`, http.StatusInternalServerError)
+			return
+		}
+
+		cert, err := issueCertificate(context.Background(), cfg, audit.ActorID(r), userID, req.Reason, affected)
+		if err != nil {
+			http.Error(w, `{"error": "Data erased but failed to store deletion certificate"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionForgetUser, forgottenActor, nil, bson.M{"certificate_id": cert.ID, "subject_hash": cert.SubjectHash}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit erasure certificate %s: %v", cert.ID.Hex(), err)
+		}
+
+		notify.Send(notifier.Event{
+			Type:     "user.forgotten",
+			Severity: notifier.SeverityInfo,
+			Message:  "User personal data erased",
+			Data:     map[string]interface{}{"certificate_id": cert.ID.Hex(), "subject_hash": cert.SubjectHash, "completed_at": cert.CompletedAt},
+		})
+
+		json.NewEncoder(w).Encode(cert)
+	}
+}
+
+// forgetUser anonymizes the user document and scrubs personal data from related
+// collections. It returns the number of documents changed per collection.
+func forgetUser(ctx context.Context, userID primitive.ObjectID) (map[string]int64, error) {
+	db := database.DB
+	id := userID.Hex()
+	affected := make(map[string]int64)
+
+	// The user document is kept as a tombstone so references stay valid
+	placeholder, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	users, err := repository.LocateUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	result, err := users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{
+			"email_hash":        "forgotten:" + placeholder,
+			"email":             "",
+			"password":          "",
+			"suspended":         true,
+			"suspension_reason": "forgotten",
+			"forgotten_at":      now,
+			"updated_at":        now,
+		},
+		"$unset": bson.M{"date_of_birth": "", "org_id": "", "display_name": "", "name_flagged": "", "tags": "", "custom_fields": "", "phone": "", "phone_hash": "", "phone_verified_at": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	affected["users"] = result.ModifiedCount
+	cache.Invalidate(cache.TagUsers)
+
+	// Sessions end now; their token IDs and refresh tokens are kept, without
+	// the client, so the tokens stay rejected until they expire
+	if _, err := tokens.RevokeUser(ctx, id); err != nil {
+		return nil, fmt.Errorf("token_ids: %w", err)
+	}
+
+	// Undo operations could restore the erased snapshot, so drop them
+	deleted, err := db.Collection("operations").DeleteMany(ctx, bson.M{"target_ids": userID})
+	if err != nil {
+		return nil, err
+	}
+	affected["operations"] = deleted.DeletedCount
+
+	scrubs := []struct {
+		collection string
+		filter     bson.M
+		update     bson.M
+	}{
+		// Audit entries are marked, so their chain is checked against the
+		// personal_hash they keep
+		{"audit_logs", bson.M{"target_id": id}, bson.M{"$set": bson.M{"target_id": forgottenActor, "redacted_at": now}, "$unset": bson.M{"before": "", "after": ""}}},
+		{"audit_logs", bson.M{"actor_id": id}, bson.M{"$set": bson.M{"actor_id": forgottenActor, "redacted_at": now}, "$unset": bson.M{"ip": ""}}},
+		{"security_events", bson.M{"user_id": id}, bson.M{"$set": bson.M{"user_id": forgottenActor}, "$unset": bson.M{"ip": "", "user_agent": ""}}},
+		{"consents", bson.M{"user_id": userID}, bson.M{"$unset": bson.M{"ip": "", "user_agent": ""}}},
+		{"abuse_reports", bson.M{"reporter_id": id}, bson.M{"$set": bson.M{"reporter_id": forgottenActor}, "$unset": bson.M{"details": ""}}},
+		{"abuse_reports", bson.M{"reported_user_id": id}, bson.M{"$set": bson.M{"reported_user_id": forgottenActor}}},
+		{"api_keys", bson.M{"user_id": userID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": now, "name": ""}}},
+		{"token_ids", bson.M{"user_id": id}, bson.M{"$unset": bson.M{"ip": "", "user_agent": ""}}},
+		{"refresh_tokens", bson.M{"user_id": id}, bson.M{"$unset": bson.M{"ip": "", "user_agent": ""}}},
+	}
+	for _, scrub := range scrubs {
+		result, err := db.Collection(scrub.collection).UpdateMany(ctx, scrub.filter, scrub.update)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scrub.collection, err)
+		}
+		affected[scrub.collection] += result.ModifiedCount
+	}
+
+	// Import reports keep the row but lose the address and temporary password
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"row.user_id": id}}})
+	result, err = db.Collection("user_imports").UpdateMany(ctx,
+		bson.M{"rows.user_id": id},
+		bson.M{"$set": bson.M{"rows.$[row].email": "", "rows.$[row].temp_password": "", "rows.$[row].user_id": forgottenActor}},
+		opts)
+	if err != nil {
+		return nil, fmt.Errorf("user_imports: %w", err)
+	}
+	affected["user_imports"] = result.ModifiedCount
+
+	// The event stream holds every earlier version of the document
+	purged, err := eventsource.Purge(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user_events: %w", err)
+	}
+	affected["user_events"] = purged
+
+	// Sign-in attempts record where the user connected from
+	logins, err := loginhistory.Purge(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("login_events: %w", err)
+	}
+	affected["login_events"] = logins
+
+	// Calls to deprecated routes are counted under the caller
+	calls, err := deprecation.Purge(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("deprecated_calls: %w", err)
+	}
+	affected["deprecated_calls"] = calls
+
+	// Unused codes hold the number they were texted to
+	codes, err := otp.Purge(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("otp_codes: %w", err)
+	}
+	affected["otp_codes"] = codes
+
+	// Passkeys carry the user's ID as their user handle
+	passkeys, err := webauthn.Purge(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn_credentials: %w", err)
+	}
+	affected["webauthn_credentials"] = passkeys
+
+	return affected, nil
+}
+
+// issueCertificate signs and stores the deletion certificate of a forgotten user
+func issueCertificate(ctx context.Context, cfg *config.Config, requestedBy string, userID primitive.ObjectID, reason string, affected map[string]int64) (*models.DeletionCertificate, error) {
+	cert := models.DeletionCertificate{
+		ID:          clock.NewID(),
+		SubjectHash: utils.HashToken(userID.Hex()),
+		RequestedBy: requestedBy,
+		Reason:      reason,
+		Affected:    affected,
+		CompletedAt: clock.Now().UTC(),
+	}
+	cert.Signature = signCertificate(cfg, &cert)
+
+	if _, err := database.DB.Collection("deletion_certificates").InsertOne(ctx, cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// signCertificate returns an HMAC over the certificate contents so it can be verified later
+func signCertificate(cfg *config.Config, cert *models.DeletionCertificate) string {
+	collections := make([]string, 0, len(cert.Affected))
+	for name := range cert.Affected {
+		collections = append(collections, name)
+	}
+	sort.Strings(collections)
+
+	mac := hmac.New(sha256.New, []byte(cfg.EncryptionKey))
+	fmt.Fprintf(mac, "%s|%s|%s|%s", cert.ID.Hex(), cert.SubjectHash, cert.RequestedBy, cert.CompletedAt.Format(time.RFC3339Nano))
+	for _, name := range collections {
+		fmt.Fprintf(mac, "|%s=%d", name, cert.Affected[name])
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/models"
+	"golang-backend/notifier"
+)
+
+func TestForgetUser(t *testing.T) {
+	f := newFixture(t)
+	userID := f.user.user.ID.Hex()
+	router := mux.NewRouter()
+	router.Handle("/admin/users/{id}/forget", ForgetUser(testConfig, notifier.New(testConfig)))
+
+	rec := f.admin.do(router, "POST", "/admin/users/"+userID+"/forget", `{"reason":"erasure request"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forget: got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := f.user.do(GetUserProfile(testConfig), "GET", "/user/profile", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token of the forgotten user: got %d, want 401", rec.Code)
+	}
+	for _, collection := range []string{"token_ids", "refresh_tokens"} {
+		if n := f.srv.Count(collection, bson.M{"user_id": userID}); n == 0 {
+			t.Errorf("%s: records were deleted, so their tokens could not be rejected", collection)
+		}
+		if n := f.srv.Count(collection, bson.M{"user_id": userID, "revoked_at": nil}); n != 0 {
+			t.Errorf("%s: %d records still active", collection, n)
+		}
+		client := bson.M{"$or": bson.A{bson.M{"ip": bson.M{"$exists": true}}, bson.M{"user_agent": bson.M{"$exists": true}}}}
+		if n := f.srv.Count(collection, bson.M{"user_id": userID, "$and": bson.A{client}}); n != 0 {
+			t.Errorf("%s: %d records keep the client IP or user agent", collection, n)
+		}
+	}
+	if n := f.srv.Count("token_ids", bson.M{"user_id": f.admin.user.ID.Hex(), "ip": testClient.IP}); n != 1 {
+		t.Errorf("admin's own session was scrubbed too")
+	}
+}
+
+func TestForgetUserCases(t *testing.T) {
+	h := route("/admin/users/{id}/forget", ForgetUser(testConfig, notifier.New(testConfig)))
+	runCases(t, h, []handlerCase{
+		{
+			name: "issues a certificate", as: "admin", method: "POST", target: "/admin/users/{user}/forget", body: `{"reason":"erasure request"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var cert models.DeletionCertificate
+				json.Unmarshal(rec.Body.Bytes(), &cert)
+				if cert.Reason != "erasure request" || cert.RequestedBy != f.admin.user.ID.Hex() || cert.Signature == "" || cert.SubjectHash == "" {
+					t.Fatalf("got %+v", cert)
+				}
+				if n := f.srv.Count("deletion_certificates", bson.M{"_id": cert.ID}); n != 1 {
+					t.Fatal("certificate not stored")
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_hash": f.user.user.EmailHash}); n != 0 {
+					t.Fatal("email index kept")
+				}
+			},
+		},
+		{name: "without a reason", as: "admin", method: "POST", target: "/admin/users/{user}/forget", status: http.StatusOK},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/users/{user}/forget", body: `{"reason":1}`, status: http.StatusBadRequest},
+		{name: "malformed ID", as: "admin", method: "POST", target: "/admin/users/42/forget", status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "POST", target: "/admin/users/{missing}/forget", status: http.StatusNotFound},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/users/{user}/forget", status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/{user}/forget", setup: dbDown("operations"), status: http.StatusInternalServerError},
+		{name: "certificate not stored", as: "admin", method: "POST", target: "/admin/users/{user}/forget", setup: dbDown("deletion_certificates"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// specOperation is an endpoint of the OpenAPI spec and the handler serving it
+type specOperation struct {
+	method, path string
+	// as signs the request in like handlerCase.as
+	as      string
+	handler http.Handler
+	// body is the schema of the JSON body, query the names of the query
+	// parameters
+	body  map[string]specProperty
+	query []string
+}
+
+type specProperty struct {
+	Type    string          `json:"type"`
+	Example json.RawMessage `json:"example"`
+}
+
+// specHandlers maps every operation of docs/swagger.json to its handler, as
+// main routes it
+func specHandlers() map[string]struct {
+	as      string
+	handler http.Handler
+} {
+	type served = struct {
+		as      string
+		handler http.Handler
+	}
+	return map[string]served{
+		"POST /register":           {"", Register(testConfig)},
+		"POST /login":              {"", Login(testConfig)},
+		"POST /admin/register":     {"admin", AdminRegister(testConfig)},
+		"POST /admin/login":        {"", AdminLogin(testConfig)},
+		"GET /user/profile":        {"user", GetUserProfile(testConfig)},
+		"PUT /user/profile":        {"user", UpdateUserProfile(testConfig)},
+		"GET /admin/users":         {"admin", ListUsers(testConfig)},
+		"POST /admin/users/delete": {"admin", DeleteUser(testConfig)},
+		"PUT /admin/users/role":    {"admin", UpdateUserRole(testConfig)},
+	}
+}
+
+// specMissing are body fields the handlers require that docs/swagger.json
+// predates, until the spec is regenerated
+var specMissing = map[string]map[string]specProperty{
+	"POST /register": {
+		"accepted_terms_version": {Type: "string", Example: json.RawMessage(`"1"`)},
+		"date_of_birth":          {Type: "string", Example: json.RawMessage(`"1990-04-21"`)},
+	},
+}
+
+// specOperations reads the operations of the OpenAPI spec, sorted so fuzz
+// inputs pick the same operation on every run
+func specOperations(tb testing.TB) []specOperation {
+	tb.Helper()
+	data, err := os.ReadFile("../docs/swagger.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				In     string `json:"in"`
+				Name   string `json:"name"`
+				Schema struct {
+					Ref string `json:"$ref"`
+				} `json:"schema"`
+			} `json:"parameters"`
+		} `json:"paths"`
+		Definitions map[string]struct {
+			Properties map[string]specProperty `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		tb.Fatal(err)
+	}
+
+	handlers := specHandlers()
+	var ops []specOperation
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			method = strings.ToUpper(method)
+			served, ok := handlers[method+" "+path]
+			if !ok {
+				tb.Fatalf("%s %s is in the spec but has no handler to fuzz", method, path)
+			}
+			o := specOperation{method: method, path: path, as: served.as, handler: served.handler}
+			for _, p := range op.Parameters {
+				switch p.In {
+				case "body":
+					o.body = spec.Definitions[strings.TrimPrefix(p.Schema.Ref, "#/definitions/")].Properties
+				case "query":
+					o.query = append(o.query, p.Name)
+				}
+			}
+			for name, p := range specMissing[method+" "+path] {
+				o.body[name] = p
+			}
+			ops = append(ops, o)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].method+" "+ops[i].path < ops[j].method+" "+ops[j].path
+	})
+	return ops
+}
+
+// seedBodies are valid, boundary and invalid bodies for a schema: the
+// examples, then each property swapped for a value of the wrong type or
+// length, then bodies that are not the object at all
+func seedBodies(schema map[string]specProperty) []string {
+	valid := map[string]any{}
+	for name, p := range schema {
+		var example any = "x"
+		if len(p.Example) > 0 {
+			json.Unmarshal(p.Example, &example)
+		}
+		// Examples the handlers would turn down, swapped for ones they take
+		switch name {
+		case "user_id":
+			example = "{user}"
+		case "password":
+			example = testPassword
+		case "role":
+			example = "user"
+		}
+		valid[name] = example
+	}
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+
+	bodies := []string{encode(valid)}
+	if _, ok := valid["email"]; ok {
+		// The examples are the fixture's users; registrations need a new one
+		fresh := map[string]any{}
+		for k, e := range valid {
+			fresh[k] = e
+		}
+		fresh["email"] = "new@example.com"
+		bodies = append(bodies, encode(fresh))
+	}
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range []any{nil, "", strings.Repeat("a", 100000), "\u0000‮", 1e308, -1, true, map[string]any{"$ne": nil}, []any{"a"}, "{missing}", "not-an-id"} {
+			body := map[string]any{}
+			for k, e := range valid {
+				body[k] = e
+			}
+			body[name] = v
+			bodies = append(bodies, encode(body))
+		}
+	}
+	return append(bodies,
+		"", "null", "{}", "[]", `"string"`, "0", "{", `{"email":`, "\xff\xfe",
+		strings.Repeat("[", 10000)+strings.Repeat("]", 10000),
+		encode(valid)+encode(valid),
+		`{"email":"a@example.com","email":"b@example.com"}`,
+	)
+}
+
+// seedQueries are boundary values for every query parameter
+func seedQueries(names []string) []string {
+	queries := []string{""}
+	for _, name := range names {
+		for _, v := range []string{"0", "-1", "1", "99999999999999999999", "abc", "1.5", "%zz", ""} {
+			queries = append(queries, name+"="+v)
+		}
+	}
+	return queries
+}
+
+// FuzzSpecEndpoints sends boundary and invalid inputs to every endpoint of
+// the OpenAPI spec. Whatever the input, the handler must answer without a
+// server error or a panic. go test runs the seeds; go test -fuzz
+// FuzzSpecEndpoints ./handlers keeps mutating them.
+func FuzzSpecEndpoints(f *testing.F) {
+	ops := specOperations(f)
+	for i, op := range ops {
+		for _, query := range seedQueries(op.query) {
+			f.Add(uint8(i), query, "")
+		}
+		if op.body != nil {
+			for _, body := range seedBodies(op.body) {
+				f.Add(uint8(i), "", body)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, index uint8, query, body string) {
+		op := ops[int(index)%len(ops)]
+		fx := newFixture(t)
+		placeholders := strings.NewReplacer("{user}", fx.user.user.ID.Hex(), "{missing}", primitive.NewObjectID().Hex())
+		body = placeholders.Replace(body)
+		target := op.path
+		if query != "" {
+			target += "?" + escapeQuery(query)
+		}
+
+		var status int
+		switch op.as {
+		case "admin":
+			status = fx.admin.do(op.handler, op.method, target, body).Code
+		case "user":
+			status = fx.user.do(op.handler, op.method, target, body).Code
+		default:
+			status = request(op.handler, op.method, target, "", body).Code
+		}
+		if status >= http.StatusInternalServerError {
+			t.Fatalf("%s %s %s: got %d", op.method, target, truncate(body), status)
+		}
+	})
+}
+
+// escapeQuery percent-encodes what a client could not put in a request line
+// as is, leaving malformed escapes for the handler to deal with
+func escapeQuery(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		if c := query[i]; c <= ' ' || c >= 0x7f || c == '#' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// truncate keeps failure messages readable for large bodies
+func truncate(body string) string {
+	if len(body) > 200 {
+		return fmt.Sprintf("%q... (%d bytes)", body[:200], len(body))
+	}
+	return fmt.Sprintf("%q", body)
+}
+
+// FuzzClaims serves the handlers that read session claims with claims of
+// any shape, as a token signed with missing or mistyped claims would leave
+// them. Handlers must turn such requests down rather than crash.
+func FuzzClaims(f *testing.F) {
+	for _, claims := range []string{
+		"", "null", "{}", `{"userID":"{user}","role":"admin"}`, `{"userID":"{user}","role":"user"}`,
+		`{"userID":"{missing}","role":"admin"}`, `{"userID":null,"role":null}`, `{"userID":7,"role":true}`,
+		`{"userID":["{user}"],"role":["admin"]}`, `{"userID":{"$oid":"{user}"},"role":{"admin":true}}`,
+		`{"userID":"","role":""}`, `{"userID":"not-an-id","role":"admin"}`, `{"role":"admin"}`, `{"userID":"{user}"}`,
+		`{"userID":"{user}","role":"admin","permissions":["*"]}`, `{"userID":"{user}","role":"user","permissions":["users:*"]}`,
+		`{"userID":"{missing}","permissions":["*"]}`, `{"userID":"{user}","permissions":"*"}`, `{"userID":"{user}","permissions":[null,7,"*"]}`,
+	} {
+		f.Add(claims, `{"user_id":"{user}","role":"user","email":"new@example.com"}`)
+	}
+
+	handlers := []struct {
+		method, target string
+		handler        http.Handler
+	}{
+		{"GET", "/user/profile", GetUserProfile(testConfig)},
+		{"PUT", "/user/profile", UpdateUserProfile(testConfig)},
+		{"GET", "/admin/users", ListUsers(testConfig)},
+		{"POST", "/admin/users/delete", DeleteUser(testConfig)},
+		{"PUT", "/admin/users/role", UpdateUserRole(testConfig)},
+	}
+	f.Fuzz(func(t *testing.T, claims, body string) {
+		fx := newFixture(t)
+		placeholders := strings.NewReplacer("{user}", fx.user.user.ID.Hex(), "{missing}", primitive.NewObjectID().Hex())
+		var parsed jwt.MapClaims
+		if json.Unmarshal([]byte(placeholders.Replace(claims)), &parsed) != nil {
+			parsed = nil
+		}
+		body = placeholders.Replace(body)
+
+		for _, h := range handlers {
+			req := httptest.NewRequest(h.method, h.target, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if claims != "" {
+				req = req.WithContext(context.WithValue(req.Context(), "claims", parsed))
+			}
+			rec := httptest.NewRecorder()
+			h.handler.ServeHTTP(rec, req)
+			if rec.Code >= http.StatusInternalServerError {
+				t.Fatalf("%s %s with claims %s: got %d %s", h.method, h.target, claims, rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+		}
+	})
+}
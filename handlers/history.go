@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
+	"golang-backend/eventsource"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/tenant"
+)
+
+// UserEventResponse is a recorded change to a user document. Field values
+// are not returned; they include password hashes and encrypted data.
+type UserEventResponse struct {
+	Version int64     `json:"version" example:"12"`
+	Type    string    `json:"type" example:"updated"`
+	Region  string    `json:"region" example:"eu"`
+	Fields  []string  `json:"fields,omitempty" example:"display_name,updated_at"`
+	Time    time.Time `json:"time"`
+}
+
+// UserHistoryResponse is a page of a user's events
+type UserHistoryResponse struct {
+	UserID string              `json:"user_id"`
+	Events []UserEventResponse `json:"events"`
+	// NextAfter continues the listing as the after parameter
+	NextAfter int64 `json:"next_after,omitempty" example:"12"`
+}
+
+// UserDetailResponse is a user as it is now, or as it was at as_of
+type UserDetailResponse struct {
+	UserResponse
+	OrgID     string `json:"org_id,omitempty"`
+	Suspended bool   `json:"suspended,omitempty"`
+	// AsOf and Version identify a reconstructed state
+	AsOf    *time.Time `json:"as_of,omitempty"`
+	Version int64      `json:"version,omitempty" example:"12"`
+}
+
+// RebuildUsersResponse reports the outcome of rebuilding users
+type RebuildUsersResponse struct {
+	Message string `json:"message" example:"Rebuilding users from their events"`
+}
+
+// @Summary List a user's events
+// @Description The recorded changes to a user document, oldest first, with the fields each changed. Events holding the whole document list no fields. Only available with EVENT_SOURCING_ENABLED (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param after query int false "Return events after this version"
+// @Param limit query int false "Maximum events to return" default(100)
+// @Security BearerAuth
+// @Success 200 {object} UserHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/history [get]
+func UserHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	var after int64
+	if a := query.Get("after"); a != "" {
+		if after, err = strconv.ParseInt(a, 10, 64); err != nil || after < 0 {
+			http.Error(w, `{"error": "Invalid after version"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	events, err := eventsource.History(r.Context(), userID, after, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch events"}`, http.StatusInternalServerError)
+		return
+	}
+	response := UserHistoryResponse{UserID: userID.Hex(), Events: make([]UserEventResponse, len(events))}
+	for i, event := range events {
+		response.Events[i] = UserEventResponse{
+			Version: event.Version,
+			Type:    event.Type,
+			Region:  event.Region,
+			Fields:  event.Fields(),
+			Time:    event.Time,
+		}
+	}
+	if len(events) == limit {
+		response.NextAfter = events[len(events)-1].Version
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary Rebuild a user from its events
+// @Description Replace the user document with the state folded from its events, in the region of the latest one, or delete it when the latest event deleted it. Only available with EVENT_SOURCING_ENABLED (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "No events recorded for the user"
+// @Failure 409 {object} ErrorResponse "The events hold no whole document"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/rebuild [post]
+func RebuildUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	err = eventsource.Rebuild(r.Context(), userID)
+	switch {
+	case errors.Is(err, eventsource.ErrNotFound):
+		http.Error(w, `{"error": "No events recorded for the user"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, eventsource.ErrNoBase):
+		http.Error(w, `{"error": "The user's events do not start from a whole document"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Failed to rebuild user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionRebuildUsers, userID.Hex(), nil, nil); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit rebuild of user %s: %v", userID.Hex(), err)
+	}
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "User rebuilt from its events"})
+}
+
+// @Summary Rebuild all users from their events
+// @Description Rebuild every user with recorded events as POST /admin/users/{id}/rebuild does, in the background. Users whose events do not start from a whole document are skipped. Only available with EVENT_SOURCING_ENABLED (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} RebuildUsersResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/users/rebuild [post]
+func RebuildUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := audit.Record(r, audit.ActionRebuildUsers, "", nil, nil); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit rebuild of all users: %v", err)
+	}
+	go func() {
+		rebuilt, skipped, err := eventsource.RebuildAll(context.Background())
+		if err != nil {
+			log.Printf("eventsource: rebuild stopped after %d users: %v", rebuilt+skipped, err)
+			return
+		}
+		log.Printf("eventsource: rebuilt %d users, skipped %d", rebuilt, skipped)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(RebuildUsersResponse{Message: "Rebuilding users from their events"})
+}
+
+// @Summary Get a user
+// @Description Get a user by ID. With as_of the user is reconstructed from its events as it was at that time, for investigations; this needs EVENT_SOURCING_ENABLED and reaches back to when the user's events begin (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param as_of query string false "RFC 3339 time to reconstruct the user at" example(2024-01-15T10:00:00Z)
+// @Security BearerAuth
+// @Success 200 {object} UserDetailResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "User not found, or not recorded at that time"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id} [get]
+func GetUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+
+		var user *models.User
+		response := UserDetailResponse{}
+		if a := r.URL.Query().Get("as_of"); a != "" {
+			asOf, err := time.Parse(time.RFC3339, a)
+			if err != nil {
+				http.Error(w, `{"error": "as_of must be an RFC 3339 time"}`, http.StatusBadRequest)
+				return
+			}
+			if !cfg.EventSourcingEnabled {
+				http.Error(w, `{"error": "as_of needs EVENT_SOURCING_ENABLED"}`, http.StatusBadRequest)
+				return
+			}
+			state, err := eventsource.Load(ctx, userID, asOf)
+			if errors.Is(err, eventsource.ErrNotFound) {
+				http.Error(w, `{"error": "No events recorded for the user by that time"}`, http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, `{"error": "Failed to reconstruct user"}`, http.StatusInternalServerError)
+				return
+			}
+			if state.Deleted {
+				http.Error(w, `{"error": "User was deleted at that time"}`, http.StatusNotFound)
+				return
+			}
+			if state.Document == nil {
+				http.Error(w, `{"error": "The user's events do not reach back to that time"}`, http.StatusNotFound)
+				return
+			}
+			if user, err = decodeState(state.Document); err != nil {
+				http.Error(w, `{"error": "Failed to decode user"}`, http.StatusInternalServerError)
+				return
+			}
+			asOf = asOf.UTC()
+			response.AsOf, response.Version = &asOf, state.Version
+		} else {
+			user, _, err = repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("email", "display_name", "role", "tags", "org_id", "suspended", "custom_fields", "created_at", "updated_at"))
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+
+		email, err := keys.Decrypt(ctx, cfg, user.Email)
+		if errors.Is(err, keys.ErrKeyDestroyed) {
+			// The organization's data was crypto-shredded
+			email = ""
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+			return
+		}
+		custom, err := customfields.Decode(ctx, cfg, user.CustomFields)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+			return
+		}
+
+		response.UserResponse = UserResponse{
+			ID:           user.ID.Hex(),
+			Email:        email,
+			DisplayName:  user.DisplayName,
+			Role:         user.Role,
+			Tags:         user.Tags,
+			CreatedAt:    user.CreatedAt,
+			UpdatedAt:    user.UpdatedAt,
+			CustomFields: custom,
+		}
+		response.OrgID, response.Suspended = user.OrgID, user.Suspended
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// decodeState decodes a user document folded from events
+func decodeState(doc bson.M) (*models.User, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := bson.Unmarshal(raw, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
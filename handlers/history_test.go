@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/eventsource"
+)
+
+// userEvents records events of the user an hour apart, ending an hour ago,
+// and sets {at1}, {at2}... to times just after each
+func userEvents(events ...eventsource.Event) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		ctx := context.Background()
+		start := clock.Now().UTC().Add(-time.Duration(len(events)) * time.Hour).Truncate(time.Second)
+		for i, event := range events {
+			event.ID, event.UserID, event.Version = clock.NewID(), f.user.user.ID, int64(i+1)
+			event.Region, event.ChangeID = database.DefaultRegion, event.ID.Hex()
+			event.Time = start.Add(time.Duration(i) * time.Hour)
+			if event.Type == eventsource.Created {
+				if err := database.DB.Collection("users").FindOne(ctx, bson.M{"_id": f.user.user.ID}).Decode(&event.Document); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := database.DB.Collection("user_events").InsertOne(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+			f.set("at"+string(rune('1'+i)), event.Time.Add(time.Minute).Format(time.RFC3339))
+		}
+		f.set("before", start.Add(-time.Minute).Format(time.RFC3339))
+	}
+}
+
+var (
+	userCreated = eventsource.Event{Type: eventsource.Created}
+	userRenamed = eventsource.Event{Type: eventsource.Updated, Set: bson.M{"display_name": "Renamed"}, Unset: []string{"tags"}}
+	userRemoved = eventsource.Event{Type: eventsource.Deleted}
+)
+
+// tampered changes the stored user behind the back of its events
+func tampered(t *testing.T, f *fixture) {
+	setUser(t, f, bson.M{"display_name": "Tampered"})
+}
+
+// displayName checks the stored display name of the user
+func displayName(t *testing.T, f *fixture, name string) {
+	t.Helper()
+	if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "display_name": name}); n != 1 {
+		t.Fatalf("user not named %q", name)
+	}
+}
+
+func TestUserHistory(t *testing.T) {
+	h := route("/admin/users/{id}/history", http.HandlerFunc(UserHistory))
+	history := func(versions []int64, next int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp UserHistoryResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.UserID != f.user.user.ID.Hex() || len(resp.Events) != len(versions) || resp.NextAfter != next {
+				t.Fatalf("got %+v, want versions %v", resp, versions)
+			}
+			for i, version := range versions {
+				if resp.Events[i].Version != version {
+					t.Fatalf("got %+v, want versions %v", resp.Events, versions)
+				}
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "events", as: "admin", method: "GET", target: "/admin/users/{user}/history",
+			setup:  userEvents(userCreated, userRenamed),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				history([]int64{1, 2}, 0)(t, f, rec)
+				var resp UserHistoryResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if created := resp.Events[0]; created.Type != eventsource.Created || created.Fields != nil || created.Region != database.DefaultRegion {
+					t.Fatalf("created %+v", created)
+				}
+				if renamed := resp.Events[1]; renamed.Type != eventsource.Updated || len(renamed.Fields) != 2 || renamed.Fields[0] != "display_name" || renamed.Fields[1] != "tags" {
+					t.Fatalf("renamed %+v", renamed)
+				}
+				if strings.Contains(rec.Body.String(), "Renamed") {
+					t.Fatalf("values returned: %s", rec.Body)
+				}
+			},
+		},
+		{name: "after a version", as: "admin", method: "GET", target: "/admin/users/{user}/history?after=1", setup: userEvents(userCreated, userRenamed), status: http.StatusOK, check: history([]int64{2}, 0)},
+		{name: "a page", as: "admin", method: "GET", target: "/admin/users/{user}/history?limit=1", setup: userEvents(userCreated, userRenamed), status: http.StatusOK, check: history([]int64{1}, 1)},
+		{name: "no events", as: "admin", method: "GET", target: "/admin/users/{user}/history", status: http.StatusOK, check: history(nil, 0)},
+		{name: "invalid after", as: "admin", method: "GET", target: "/admin/users/{user}/history?after=-1", status: http.StatusBadRequest},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/users/nope/history", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/{user}/history", setup: dbDown("user_events"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRebuildUser(t *testing.T) {
+	h := route("/admin/users/{id}/rebuild", http.HandlerFunc(RebuildUser))
+	audited := func(t *testing.T, f *fixture) {
+		t.Helper()
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRebuildUsers, "target_id": f.user.user.ID.Hex()}); n != 1 {
+			t.Fatal("rebuild not audited")
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "rebuilt", as: "admin", method: "POST", target: "/admin/users/{user}/rebuild",
+			setup:  setups(userEvents(userCreated, userRenamed), tampered),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				displayName(t, f, "Renamed")
+				audited(t, f)
+			},
+		},
+		{
+			name: "deleted", as: "admin", method: "POST", target: "/admin/users/{user}/rebuild",
+			setup:  userEvents(userCreated, userRemoved),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID}); n != 0 {
+					t.Fatal("user not deleted")
+				}
+				audited(t, f)
+			},
+		},
+		{name: "no events", as: "admin", method: "POST", target: "/admin/users/{user}/rebuild", status: http.StatusNotFound},
+		{name: "no whole document", as: "admin", method: "POST", target: "/admin/users/{user}/rebuild", setup: userEvents(userRenamed), status: http.StatusConflict},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/users/nope/rebuild", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/{user}/rebuild", setup: setups(userEvents(userCreated), dbDown("user_events")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRebuildUsers(t *testing.T) {
+	runCases(t, http.HandlerFunc(RebuildUsers), []handlerCase{
+		{
+			name: "in the background", as: "admin", method: "POST", target: "/admin/users/rebuild",
+			setup:  setups(userEvents(userCreated, userRenamed), tampered),
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				eventually(t, "the user is rebuilt", func() bool {
+					return f.srv.Count("users", bson.M{"_id": f.user.user.ID, "display_name": "Renamed"}) == 1
+				})
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRebuildUsers}); n != 1 {
+					t.Fatal("rebuild not audited")
+				}
+			},
+		},
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	cfg := *testConfig
+	cfg.EventSourcingEnabled = true
+	h := route("/admin/users/{id}", GetUser(&cfg))
+	detail := func(name string, version int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp UserDetailResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.ID != f.user.user.ID.Hex() || resp.Email != "user@example.com" || resp.DisplayName != name || resp.Role != "user" || resp.Version != version || (version == 0) != (resp.AsOf == nil) {
+				t.Fatalf("got %+v, want %q at version %d", resp, name, version)
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "current", as: "admin", method: "GET", target: "/admin/users/{user}", setup: userEvents(userCreated, userRenamed), status: http.StatusOK, check: detail("", 0)},
+		{name: "as created", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={at1}", setup: userEvents(userCreated, userRenamed), status: http.StatusOK, check: detail("", 1)},
+		{name: "as renamed", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={at2}", setup: userEvents(userCreated, userRenamed), status: http.StatusOK, check: detail("Renamed", 2)},
+		{name: "before the events", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={before}", setup: userEvents(userCreated, userRenamed), status: http.StatusNotFound},
+		{name: "deleted by then", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={at2}", setup: userEvents(userCreated, userRemoved), status: http.StatusNotFound},
+		{name: "events after the time", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={at1}", setup: userEvents(userRenamed), status: http.StatusNotFound},
+		{name: "invalid as_of", as: "admin", method: "GET", target: "/admin/users/{user}?as_of=yesterday", status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "GET", target: "/admin/users/{missing}", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/users/nope", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/{user}?as_of={at1}", setup: setups(userEvents(userCreated), dbDown("user_events")), status: http.StatusInternalServerError},
+	})
+
+	t.Run("without event sourcing", func(t *testing.T) {
+		f := newFixture(t)
+		rec := f.admin.do(route("/admin/users/{id}", GetUser(testConfig)), "GET", "/admin/users/"+f.user.user.ID.Hex()+"?as_of=2024-01-15T10:00:00Z", "")
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/emailcheck"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/scan"
+	"golang-backend/utils"
+)
+
+// maxImportSize is the largest CSV upload accepted by ImportUsers
+const maxImportSize = 5 << 20
+
+// ImportUsersResponse represents the response for a started user import
+type ImportUsersResponse struct {
+	ImportID  string `json:"import_id"`
+	Status    string `json:"status"`
+	Total     int    `json:"total"`
+	Invalid   int    `json:"invalid"`
+	ReportURL string `json:"report_url"`
+}
+
+// @Summary Import users from CSV
+// @Description Upload a CSV with an "email" column and an optional "role" column. The file is scanned first and quarantined when flagged. Rows are validated immediately and valid rows are created asynchronously (Admin only)
+// @Tags admin
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV file"
+// @Param send_invites formData bool false "Email each user an invite instead of returning temporary passwords"
+// @Security BearerAuth
+// @Success 202 {object} ImportUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/users/import [post]
+func ImportUsers(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		adminID, _ := claims["userID"].(string)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		if err := r.ParseMultipartForm(maxImportSize); err != nil {
+			http.Error(w, `{"error": "Invalid upload or file too large"}`, http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, `{"error": "CSV file is required"}`, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid upload or file too large"}`, http.StatusBadRequest)
+			return
+		}
+		if !scanUpload(w, r, scan.Upload{Kind: models.UploadCSV, Filename: header.Filename, DeclaredType: header.Header.Get("Content-Type"), Content: content}) {
+			return
+		}
+
+		rows, err := parseImportCSV(bytes.NewReader(content), cfg.EmailFoldAliases)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.Background()
+
+		invalid := 0
+		for i := range rows {
+			if rows[i].Status == models.ImportRowInvalid {
+				invalid++
+				continue
+			}
+
+			_, _, err := repository.FindUserByEmailHash(ctx, utils.EmailIndex(rows[i].Email, cfg.EmailFoldAliases), repository.IDOnly)
+			if err != nil && err != mongo.ErrNoDocuments {
+				http.Error(w, `{"error": "Failed to check existing users"}`, http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				rows[i].Status = models.ImportRowInvalid
+				rows[i].Error = "user already exists"
+				invalid++
+			}
+		}
+
+		imp := models.UserImport{
+			ID:          clock.NewID(),
+			CreatedBy:   adminID,
+			Status:      models.ImportStatusProcessing,
+			SendInvites: r.FormValue("send_invites") == "true",
+			Total:       len(rows),
+			Failed:      invalid,
+			Rows:        rows,
+			CreatedAt:   clock.Now(),
+		}
+
+		stored, err := encryptImportRows(rows, cfg.EncryptionKey)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to encrypt import data"}`, http.StatusInternalServerError)
+			return
+		}
+		imp.Rows = stored
+
+		if _, err := database.DB.Collection("user_imports").InsertOne(ctx, imp); err != nil {
+			http.Error(w, `{"error": "Failed to create import"}`, http.StatusInternalServerError)
+			return
+		}
+
+		ids := correlation.FromContext(r.Context())
+		entry := models.AuditLog{ActorID: adminID, Action: audit.ActionImportUsers, TargetID: imp.ID.Hex(), IP: audit.ClientIP(r), RequestID: ids.RequestID, TraceID: ids.TraceID}
+		go processUserImport(cfg, imp.ID, rows, imp.SendInvites, entry)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ImportUsersResponse{
+			ImportID:  imp.ID.Hex(),
+			Status:    imp.Status,
+			Total:     imp.Total,
+			Invalid:   invalid,
+			ReportURL: "/admin/users/import/" + imp.ID.Hex(),
+		})
+	}
+}
+
+// @Summary Get user import report
+// @Description Get the per-row result report of a user import as JSON, or as a CSV download with format=csv (Admin only)
+// @Tags admin
+// @Produce json
+// @Produce text/csv
+// @Param id path string true "Import ID"
+// @Param format query string false "Report format (json or csv)"
+// @Security BearerAuth
+// @Success 200 {object} models.UserImport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/import/{id} [get]
+func GetUserImport(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		importID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Invalid import ID format"}`, http.StatusBadRequest)
+			return
+		}
+
+		var imp models.UserImport
+		err = database.DB.Collection("user_imports").FindOne(context.Background(), bson.M{"_id": importID}).Decode(&imp)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			if err == mongo.ErrNoDocuments {
+				http.Error(w, `{"error": "Import not found"}`, http.StatusNotFound)
+				return
+			}
+			http.Error(w, `{"error": "Failed to fetch import"}`, http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := decryptImportRows(imp.Rows, cfg.EncryptionKey)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Failed to decrypt import data"}`, http.StatusInternalServerError)
+			return
+		}
+		imp.Rows = rows
+
+		if imp.Undo != nil {
+			if imp.Undo.ExpiresAt.Before(clock.Now()) {
+				imp.Undo = nil
+			} else if imp.Undo.Token, err = utils.Decrypt(imp.Undo.Token, cfg.EncryptionKey); err != nil {
+				imp.Undo = nil
+			}
+		}
+
+		if r.URL.Query().Get("format") != "csv" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(imp)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=import-%s.csv", imp.ID.Hex()))
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"line", "email", "role", "status", "error", "user_id", "temp_password"})
+		for _, row := range imp.Rows {
+			writer.Write([]string{fmt.Sprint(row.Line), row.Email, row.Role, row.Status, row.Error, row.UserID, row.TempPassword})
+		}
+		writer.Flush()
+	}
+}
+
+// parseImportCSV reads and validates the uploaded CSV, marking invalid rows.
+// Rows whose addresses share an email index are duplicates.
+func parseImportCSV(file io.Reader, foldAliases bool) ([]models.ImportRow, error) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("CSV file is empty or malformed")
+	}
+
+	emailCol, roleCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "email":
+			emailCol = i
+		case "role":
+			roleCol = i
+		}
+	}
+	if emailCol == -1 {
+		return nil, fmt.Errorf("CSV header must contain an email column")
+	}
+
+	var rows []models.ImportRow
+	seen := make(map[string]int)
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			rows = append(rows, models.ImportRow{Line: line, Status: models.ImportRowInvalid, Error: "malformed row"})
+			continue
+		}
+
+		row := models.ImportRow{Line: line, Role: "user"}
+		if emailCol < len(record) {
+			row.Email = utils.NormalizeEmail(record[emailCol])
+		}
+		if roleCol != -1 && roleCol < len(record) && strings.TrimSpace(record[roleCol]) != "" {
+			row.Role = strings.TrimSpace(record[roleCol])
+		}
+
+		index := utils.EmailIndex(row.Email, foldAliases)
+		switch {
+		case !emailcheck.ValidSyntax(row.Email):
+			row.Status = models.ImportRowInvalid
+			row.Error = "invalid email format"
+		case row.Role != "user" && row.Role != "admin":
+			row.Status = models.ImportRowInvalid
+			row.Error = "invalid role. Must be 'user' or 'admin'"
+		case seen[index] != 0:
+			row.Status = models.ImportRowInvalid
+			row.Error = fmt.Sprintf("duplicate of line %d", seen[index])
+		default:
+			seen[index] = line
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV file contains no rows")
+	}
+
+	return rows, nil
+}
+
+// processUserImport creates the valid rows of an import and stores the final report
+// and an undo operation covering every created user
+func processUserImport(cfg *config.Config, importID primitive.ObjectID, rows []models.ImportRow, sendInvites bool, entry models.AuditLog) {
+	ctx := context.Background()
+	// Imported users belong to no organization
+	collection, err := repository.UsersForOrg(ctx, "")
+	if err != nil {
+		log.Printf("import %s: failed to locate users: %v", importID.Hex(), err)
+	}
+
+	succeeded, failed := 0, 0
+	var created []primitive.ObjectID
+	for i := range rows {
+		row := &rows[i]
+		if row.Status == models.ImportRowInvalid {
+			failed++
+			continue
+		}
+		if collection == nil {
+			row.Status, row.Error = models.ImportRowFailed, "failed to create user"
+			failed++
+			continue
+		}
+
+		tempPassword, err := utils.RandomToken(12)
+		if err != nil {
+			row.Status, row.Error = models.ImportRowFailed, "failed to generate password"
+			failed++
+			continue
+		}
+
+		hashedPassword, err := utils.HashPasswordQueued(tempPassword)
+		if err != nil {
+			row.Status, row.Error = models.ImportRowFailed, "failed to hash password"
+			failed++
+			continue
+		}
+
+		encryptedEmail, err := utils.Encrypt(row.Email, cfg.EncryptionKey)
+		if err != nil {
+			row.Status, row.Error = models.ImportRowFailed, "failed to encrypt data"
+			failed++
+			continue
+		}
+
+		now := clock.Now()
+		user := models.User{
+			ID:        clock.NewID(),
+			EmailHash: utils.EmailIndex(row.Email, cfg.EmailFoldAliases),
+			Email:     encryptedEmail,
+			Password:  hashedPassword,
+			Role:      row.Role,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		if _, err := collection.InsertOne(ctx, user); err != nil {
+			row.Status, row.Error = models.ImportRowFailed, "failed to create user"
+			failed++
+			continue
+		}
+		row.UserID = user.ID.Hex()
+		created = append(created, user.ID)
+
+		if sendInvites {
+			msg, err := renderUserEmail(ctx, cfg, "invite", row.Email, &user, map[string]string{"TempPassword": tempPassword})
+			if err == nil {
+				// Invites carry the sign-in credentials, so they cannot be muted
+				err = notifier.Deliver(ctx, cfg, &user, notifier.UserMessage{Type: "account.invite", Category: models.CategorySecurity, Email: &msg})
+			}
+			if err != nil {
+				log.Printf("import %s: failed to send invite for line %d: %v", importID.Hex(), row.Line, err)
+				row.Status, row.Error = models.ImportRowFailed, "user created but invite email failed"
+				row.TempPassword = tempPassword
+				failed++
+				continue
+			}
+			row.Status = models.ImportRowInvited
+		} else {
+			row.Status = models.ImportRowCreated
+			row.TempPassword = tempPassword
+		}
+		succeeded++
+	}
+
+	stored, err := encryptImportRows(rows, cfg.EncryptionKey)
+	if err != nil {
+		log.Printf("import %s: failed to encrypt report: %v", importID.Hex(), err)
+		return
+	}
+
+	completedAt := clock.Now()
+	set := bson.M{
+		"status":       models.ImportStatusCompleted,
+		"succeeded":    succeeded,
+		"failed":       failed,
+		"rows":         stored,
+		"completed_at": completedAt,
+	}
+
+	if len(created) > 0 {
+		cache.Invalidate(cache.TagUsers)
+		entry.After = bson.M{"user_ids": created}
+		auditID, err := audit.Insert(entry)
+		if err != nil {
+			log.Printf("import %s: failed to audit import: %v", importID.Hex(), err)
+		} else if undo, err := newUndoOperation(cfg, models.OperationImportUsers, entry.ActorID, auditID, created); err == nil {
+			if undo.Token, err = utils.Encrypt(undo.Token, cfg.EncryptionKey); err == nil {
+				set["undo"] = undo
+			}
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if _, err := database.DB.Collection("user_imports").UpdateOne(ctx, bson.M{"_id": importID}, update); err != nil {
+		log.Printf("import %s: failed to store report: %v", importID.Hex(), err)
+	}
+}
+
+// encryptImportRows returns a copy of rows with emails and temporary passwords encrypted
+func encryptImportRows(rows []models.ImportRow, key string) ([]models.ImportRow, error) {
+	out := make([]models.ImportRow, len(rows))
+	for i, row := range rows {
+		var err error
+		if row.Email != "" {
+			if row.Email, err = utils.Encrypt(row.Email, key); err != nil {
+				return nil, err
+			}
+		}
+		if row.TempPassword != "" {
+			if row.TempPassword, err = utils.Encrypt(row.TempPassword, key); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = row
+	}
+	return out, nil
+}
+
+// decryptImportRows reverses encryptImportRows
+func decryptImportRows(rows []models.ImportRow, key string) ([]models.ImportRow, error) {
+	out := make([]models.ImportRow, len(rows))
+	for i, row := range rows {
+		var err error
+		if row.Email != "" {
+			if row.Email, err = utils.Decrypt(row.Email, key); err != nil {
+				return nil, err
+			}
+		}
+		if row.TempPassword != "" {
+			if row.TempPassword, err = utils.Decrypt(row.TempPassword, key); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = row
+	}
+	return out, nil
+}
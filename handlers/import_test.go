@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+func TestProcessUserImport(t *testing.T) {
+	f := newFixture(t)
+	rows := []models.ImportRow{
+		{Line: 2, Email: "new@example.com", Role: "user"},
+		{Line: 3, Email: "bad", Role: "user", Status: models.ImportRowInvalid},
+	}
+	entry := models.AuditLog{ActorID: f.admin.user.ID.Hex(), Action: audit.ActionImportUsers}
+	processUserImport(testConfig, primitive.NewObjectID(), rows, false, entry)
+
+	if rows[0].Status != models.ImportRowCreated || rows[0].TempPassword == "" || rows[1].Status != models.ImportRowInvalid {
+		t.Fatalf("got %+v", rows)
+	}
+	hash := utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)
+	if n := f.srv.Count("users", bson.M{"email_hash": hash, "role": "user"}); n != 1 {
+		t.Fatal("user not stored")
+	}
+	if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionImportUsers}); n != 1 {
+		t.Fatal("import not audited")
+	}
+}
+
+func TestProcessUserImportDatabaseDown(t *testing.T) {
+	f := newFixture(t)
+	f.srv.Fail("users")
+	rows := []models.ImportRow{{Line: 2, Email: "new@example.com", Role: "user"}}
+	processUserImport(testConfig, primitive.NewObjectID(), rows, false, models.AuditLog{Action: audit.ActionImportUsers})
+
+	if rows[0].Status != models.ImportRowFailed || rows[0].UserID != "" {
+		t.Fatalf("got %+v", rows)
+	}
+	if n := f.srv.Count("audit_logs", bson.M{}); n != 0 {
+		t.Fatal("failed import audited")
+	}
+}
+
+// importUsers imports a CSV as the admin, waits for the import to finish and
+// sets {import} to its ID
+func importUsers(csv string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		rec := f.admin.do(multipartForm(ImportUsers(testConfig)), "POST", "/admin/users/import", form(t, "users.csv", csv, nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("import: got %d %s", rec.Code, rec.Body)
+		}
+		var resp ImportUsersResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		f.set("import", resp.ImportID)
+		importID, _ := primitive.ObjectIDFromHex(resp.ImportID)
+		eventually(t, "the import completes", func() bool {
+			return f.srv.Count("user_imports", bson.M{"_id": importID, "status": models.ImportStatusCompleted}) == 1
+		})
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	h := multipartForm(ImportUsers(testConfig))
+	runCases(t, h, []handlerCase{
+		{
+			name: "creates the valid rows", as: "admin", method: "POST", target: "/admin/users/import",
+			body:   form(t, "users.csv", "email,role\nnew@example.com,user\nUSER@example.com,user\nbad,user\n", nil),
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ImportUsersResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Total != 3 || resp.Invalid != 2 || resp.Status != models.ImportStatusProcessing || resp.ReportURL != "/admin/users/import/"+resp.ImportID {
+					t.Fatalf("got %+v", resp)
+				}
+				hash := utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)
+				eventually(t, "the user is created", func() bool { return f.srv.Count("users", bson.M{"email_hash": hash}) == 1 })
+			},
+		},
+		{name: "not multipart", as: "admin", method: "POST", target: "/admin/users/import", body: `{}`, status: http.StatusBadRequest},
+		{name: "no file", as: "admin", method: "POST", target: "/admin/users/import", body: form(t, "", "", map[string]string{"send_invites": "true"}), status: http.StatusBadRequest},
+		{name: "no email column", as: "admin", method: "POST", target: "/admin/users/import", body: form(t, "users.csv", "name\nAda\n", nil), status: http.StatusBadRequest},
+		{name: "empty file", as: "admin", method: "POST", target: "/admin/users/import", body: form(t, "users.csv", "", nil), status: http.StatusBadRequest},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/users/import", body: form(t, "users.csv", "email\nnew@example.com\n", nil), status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/import", body: form(t, "users.csv", "email\nnew@example.com\n", nil), setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestGetUserImport(t *testing.T) {
+	h := route("/admin/users/import/{id}", GetUserImport(testConfig))
+	imported := importUsers("email,role\nnew@example.com,admin\nbad,user\n")
+	runCases(t, h, []handlerCase{
+		{
+			name: "reports every row", as: "admin", method: "GET", target: "/admin/users/import/{import}",
+			setup:  imported,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var imp models.UserImport
+				json.Unmarshal(rec.Body.Bytes(), &imp)
+				if imp.Succeeded != 1 || imp.Failed != 1 || len(imp.Rows) != 2 || imp.Undo == nil || imp.Undo.Token == "" {
+					t.Fatalf("got %+v", imp)
+				}
+				if row := imp.Rows[0]; row.Email != "new@example.com" || row.Status != models.ImportRowCreated || row.TempPassword == "" {
+					t.Fatalf("created row %+v", row)
+				}
+			},
+		},
+		{
+			name: "as CSV", as: "admin", method: "GET", target: "/admin/users/import/{import}?format=csv",
+			setup:  imported,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+				if rec.Header().Get("Content-Type") != "text/csv" || len(lines) != 3 || !strings.HasPrefix(lines[1], "2,new@example.com,admin,created,") {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{
+			name: "undo window passed", as: "admin", method: "GET", target: "/admin/users/import/{import}",
+			setup: setups(imported, func(t *testing.T, f *fixture) {
+				importID, _ := primitive.ObjectIDFromHex(f.vars["import"])
+				if _, err := database.DB.Collection("user_imports").UpdateOne(context.Background(), bson.M{"_id": importID}, bson.M{"$set": bson.M{"undo.expires_at": clock.Now().Add(-time.Minute)}}); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var imp models.UserImport
+				if json.Unmarshal(rec.Body.Bytes(), &imp); imp.Undo != nil {
+					t.Fatalf("expired undo token returned: %+v", imp.Undo)
+				}
+			},
+		},
+		{name: "malformed ID", as: "admin", method: "GET", target: "/admin/users/import/42", status: http.StatusBadRequest},
+		{name: "unknown import", as: "admin", method: "GET", target: "/admin/users/import/{missing}", status: http.StatusNotFound},
+		{name: "no session", as: "guest", method: "GET", target: "/admin/users/import/{missing}", status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/import/{import}", setup: setups(imported, dbDown("user_imports")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/invitations"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// maxInvitationNote bounds the note kept with an invitation
+const maxInvitationNote = 200
+
+// CreateInvitationRequest describes an invitation to issue
+type CreateInvitationRequest struct {
+	// Role is given to the invited account, user by default
+	Role string `json:"role,omitempty" example:"user"`
+	Note string `json:"note,omitempty" example:"Beta cohort 3"`
+	// ExpiresAt defaults to INVITATION_TTL from now
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateInvitationResponse is a new invitation with its code, which is only
+// shown once
+type CreateInvitationResponse struct {
+	invitations.Invitation
+	Code string `json:"code" example:"pY2c9KqLx0vR3tWm8ZbN1aHs7dFe4GjU"`
+	URL  string `json:"url" example:"http://localhost:8080/register?invite_code=pY2c9KqLx0vR3tWm8ZbN1aHs7dFe4GjU"`
+}
+
+// InvitationsResponse lists invitations
+type InvitationsResponse struct {
+	Invitations []invitations.Invitation `json:"invitations"`
+}
+
+// @Summary Create an invitation
+// @Description Issue a single-use code that registers one account through POST /register (invite_code) or social sign-in, with the given role. The code is only returned here. While APPROVALS_ENABLED, admin invitations are refused, since they would skip the approval of the role. Requires a session elevated with POST /auth/sudo (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateInvitationRequest false "Role, note and expiry"
+// @Success 201 {object} CreateInvitationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations [post]
+func CreateInvitation(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req CreateInvitationRequest
+		if r.ContentLength != 0 {
+			if err := utils.DecodeJSON(r.Body, &req); err != nil {
+				http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Role == "" {
+			req.Role = "user"
+		}
+		if req.Role != "user" && req.Role != "admin" {
+			http.Error(w, `{"error": "Invalid role. Must be 'user' or 'admin'"}`, http.StatusBadRequest)
+			return
+		}
+		if cfg.ApprovalsEnabled && req.Role == "admin" {
+			http.Error(w, `{"error": "Admin invitations are disabled while approvals are required; invite a user and request the role change"}`, http.StatusForbidden)
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if len(req.Note) > maxInvitationNote {
+			http.Error(w, `{"error": "note must be at most 200 characters"}`, http.StatusBadRequest)
+			return
+		}
+		ttl := cfg.InvitationTTL
+		if req.ExpiresAt != nil {
+			ttl = req.ExpiresAt.Sub(clock.Now())
+			if ttl <= 0 {
+				http.Error(w, `{"error": "expires_at must be in the future"}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx := r.Context()
+		code, inv, err := invitations.Create(ctx, req.Role, req.Note, audit.ActorID(r), ttl)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to create invitation"}`, http.StatusInternalServerError)
+			return
+		}
+		after := bson.M{"role": inv.Role, "note": inv.Note, "expires_at": inv.ExpiresAt}
+		if _, err := audit.Record(r, audit.ActionCreateInvitation, inv.ID.Hex(), nil, after); err != nil {
+			correlation.Errorf(ctx, "Failed to audit invitation %s: %v", inv.ID.Hex(), err)
+		}
+
+		link := cfg.InvitationURL
+		if link == "" {
+			link = cfg.AppURL + "/register"
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateInvitationResponse{Invitation: *inv, Code: code, URL: link + "?invite_code=" + url.QueryEscape(code)})
+	}
+}
+
+// @Summary List invitations
+// @Description Issued invitations, newest first, with who created them and the account that used each (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "Only invitations with this status" Enums(pending, used, expired, revoked)
+// @Param limit query int false "Invitations to return (default 100, max 1000)"
+// @Success 200 {object} InvitationsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations [get]
+func ListInvitations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !invitations.ValidStatus(status) {
+		http.Error(w, `{"error": "status must be pending, used, expired or revoked"}`, http.StatusBadRequest)
+		return
+	}
+	limit := int64(100)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, `{"error": "limit must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	list, err := invitations.List(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to list invitations"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(InvitationsResponse{Invitations: list})
+}
+
+// @Summary Revoke an invitation
+// @Description Void a pending invitation so its code no longer registers an account (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} invitations.Invitation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Invitation already used or revoked"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/invitations/{id} [delete]
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid invitation ID format"}`, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	inv, err := invitations.Revoke(ctx, id)
+	switch {
+	case errors.Is(err, invitations.ErrNotFound):
+		http.Error(w, `{"error": "Invitation not found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, invitations.ErrNotPending):
+		http.Error(w, `{"error": "Invitation was already used or revoked"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "Failed to revoke invitation"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := audit.Record(r, audit.ActionRevokeInvitation, id.Hex(), nil, bson.M{"revoked_at": inv.RevokedAt}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit revocation of invitation %s: %v", id.Hex(), err)
+	}
+	json.NewEncoder(w).Encode(inv)
+}
+
+// checkInvitation looks up the invitation a registration names. Without a
+// code it returns nil, unless INVITE_ONLY requires one; otherwise the
+// status and message to refuse the registration with.
+func checkInvitation(ctx context.Context, cfg *config.Config, code string) (*invitations.Invitation, int, string) {
+	if code == "" {
+		if cfg.InviteOnly {
+			return nil, http.StatusForbidden, "Registration requires an invitation"
+		}
+		return nil, http.StatusOK, ""
+	}
+	inv, err := invitations.Find(ctx, code)
+	if errors.Is(err, invitations.ErrInvalid) {
+		return nil, http.StatusBadRequest, "Invalid or expired invitation"
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+	return inv, http.StatusOK, ""
+}
+
+// redeemInvitation uses the invitation of a registration for the account
+// about to be created. It fails when another registration used it first.
+func redeemInvitation(ctx context.Context, code string, userID primitive.ObjectID) (int, string) {
+	if code == "" {
+		return http.StatusOK, ""
+	}
+	if _, err := invitations.Redeem(ctx, code, userID); errors.Is(err, invitations.ErrInvalid) {
+		return http.StatusBadRequest, "Invalid or expired invitation"
+	} else if err != nil {
+		return http.StatusInternalServerError, "Database error"
+	}
+	return http.StatusOK, ""
+}
+
+// releaseInvitation makes the invitation of an account that could not be
+// created usable again
+func releaseInvitation(ctx context.Context, user models.User) {
+	if user.InvitationID == nil {
+		return
+	}
+	if err := invitations.Release(ctx, *user.InvitationID, user.ID); err != nil {
+		correlation.Errorf(ctx, "Failed to release invitation %s: %v", user.InvitationID.Hex(), err)
+	}
+}
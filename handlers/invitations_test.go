@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/invitations"
+	"golang-backend/utils"
+)
+
+// invitation issues a user invitation valid for ttl as {invitation}, with
+// its code as {code}
+func invitation(ttl time.Duration) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		code, inv, err := invitations.Create(context.Background(), "user", "", f.admin.user.ID.Hex(), ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.set("invitation", inv.ID.Hex())
+		f.set("code", code)
+	}
+}
+
+// invitationUsed redeems {invitation} for the user
+func invitationUsed(t *testing.T, f *fixture) {
+	t.Helper()
+	if _, err := invitations.Redeem(context.Background(), f.vars["code"], f.user.user.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invitationAged moves a time of {invitation} by d
+func invitationAged(field string, d time.Duration) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		id, _ := primitive.ObjectIDFromHex(f.vars["invitation"])
+		if _, err := database.DB.Collection("invitations").UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": bson.M{field: clock.Now().Add(d)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateInvitation(t *testing.T) {
+	created := func(role string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp CreateInvitationResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Role != role || resp.Code == "" || resp.URL != testConfig.AppURL+"/register?invite_code="+resp.Code || resp.CreatedBy != f.admin.user.ID.Hex() {
+				t.Fatalf("got %+v", resp)
+			}
+			if n := f.srv.Count("invitations", bson.M{"code_hash": utils.HashToken(resp.Code), "role": role}); n != 1 {
+				t.Fatal("invitation not stored by its code hash")
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCreateInvitation, "target_id": resp.ID.Hex(), "after.role": role}); n != 1 {
+				t.Fatal("invitation not audited")
+			}
+		}
+	}
+	approvals := *testConfig
+	approvals.ApprovalsEnabled = true
+	runCases(t, CreateInvitation(testConfig), []handlerCase{
+		{name: "user by default", as: "admin", method: "POST", target: "/admin/invitations", status: http.StatusCreated, check: created("user")},
+		{
+			name: "admin with a note and expiry", as: "admin", method: "POST", target: "/admin/invitations",
+			body:   `{"role":"admin","note":" Beta cohort 3 ","expires_at":"` + clock.Now().Add(48*time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				created("admin")(t, f, rec)
+				var resp CreateInvitationResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Note != "Beta cohort 3" || resp.ExpiresAt.Before(clock.Now().Add(47*time.Hour)) {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+		{name: "unknown role", as: "admin", method: "POST", target: "/admin/invitations", body: `{"role":"owner"}`, status: http.StatusBadRequest},
+		{name: "note too long", as: "admin", method: "POST", target: "/admin/invitations", body: `{"note":"` + strings.Repeat("a", 201) + `"}`, status: http.StatusBadRequest},
+		{name: "expired", as: "admin", method: "POST", target: "/admin/invitations", body: `{"expires_at":"2020-01-01T00:00:00Z"}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/invitations", body: `{"role":1}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/invitations", setup: dbDown("invitations"), status: http.StatusInternalServerError},
+	})
+	runCases(t, CreateInvitation(&approvals), []handlerCase{
+		{name: "admin while approvals are required", as: "admin", method: "POST", target: "/admin/invitations", body: `{"role":"admin"}`, status: http.StatusForbidden},
+		{name: "user while approvals are required", as: "admin", method: "POST", target: "/admin/invitations", body: `{"role":"user"}`, status: http.StatusCreated, check: created("user")},
+	})
+}
+
+func TestListInvitations(t *testing.T) {
+	listed := func(statuses ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp InvitationsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Invitations) != len(statuses) {
+				t.Fatalf("got %+v, want %v", resp.Invitations, statuses)
+			}
+			for i, status := range statuses {
+				if resp.Invitations[i].Status != status {
+					t.Fatalf("got %+v, want %v", resp.Invitations, statuses)
+				}
+			}
+		}
+	}
+	// The first invitation is an hour older and used, the second pending
+	invitationsOf := setups(invitation(time.Hour), invitationUsed, invitationAged("created_at", -time.Hour), invitation(time.Hour))
+	runCases(t, http.HandlerFunc(ListInvitations), []handlerCase{
+		{name: "all", as: "admin", method: "GET", target: "/admin/invitations", setup: invitationsOf, status: http.StatusOK, check: listed("pending", "used")},
+		{name: "pending", as: "admin", method: "GET", target: "/admin/invitations?status=pending", setup: invitationsOf, status: http.StatusOK, check: listed("pending")},
+		{name: "used", as: "admin", method: "GET", target: "/admin/invitations?status=used", setup: invitationsOf, status: http.StatusOK, check: listed("used")},
+		{name: "expired", as: "admin", method: "GET", target: "/admin/invitations?status=expired", setup: setups(invitationsOf, invitationAged("expires_at", -time.Minute)), status: http.StatusOK, check: listed("expired")},
+		{name: "limited", as: "admin", method: "GET", target: "/admin/invitations?limit=1", setup: invitationsOf, status: http.StatusOK, check: listed("pending")},
+		{name: "unknown status", as: "admin", method: "GET", target: "/admin/invitations?status=open", status: http.StatusBadRequest},
+		{name: "invalid limit", as: "admin", method: "GET", target: "/admin/invitations?limit=1001", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/invitations", setup: dbDown("invitations"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	h := route("/admin/invitations/{id}", http.HandlerFunc(RevokeInvitation))
+	revoked := func(t *testing.T, f *fixture) {
+		id, _ := primitive.ObjectIDFromHex(f.vars["invitation"])
+		if _, err := invitations.Revoke(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "revoked", as: "admin", method: "DELETE", target: "/admin/invitations/{invitation}",
+			setup:  invitation(time.Hour),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var inv invitations.Invitation
+				json.Unmarshal(rec.Body.Bytes(), &inv)
+				if inv.Status != invitations.StatusRevoked || inv.RevokedAt == nil {
+					t.Fatalf("got %+v", inv)
+				}
+				if _, err := invitations.Find(context.Background(), f.vars["code"]); err != invitations.ErrInvalid {
+					t.Fatalf("code still valid: %v", err)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRevokeInvitation, "target_id": f.vars["invitation"]}); n != 1 {
+					t.Fatal("revocation not audited")
+				}
+			},
+		},
+		{name: "used", as: "admin", method: "DELETE", target: "/admin/invitations/{invitation}", setup: setups(invitation(time.Hour), invitationUsed), status: http.StatusConflict},
+		{name: "already revoked", as: "admin", method: "DELETE", target: "/admin/invitations/{invitation}", setup: setups(invitation(time.Hour), revoked), status: http.StatusConflict},
+		{name: "unknown invitation", as: "admin", method: "DELETE", target: "/admin/invitations/{missing}", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "DELETE", target: "/admin/invitations/nope", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/invitations/{invitation}", setup: setups(invitation(time.Hour), dbDown("invitations")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/jobs"
+	"golang-backend/notifier"
+	"golang-backend/utils"
+)
+
+// Kinds of background job queued by handlers
+const (
+	jobVerificationEmail = "email.verification"
+	jobPurgeAccount      = "user.purge"
+)
+
+// userJob is the payload of a job about one user. Only the ID is queued;
+// personal data is read when the job runs.
+type userJob struct {
+	UserID primitive.ObjectID `bson:"user_id"`
+}
+
+// RegisterJobs registers the kinds of background job queued by handlers
+func RegisterJobs(cfg *config.Config, notify *notifier.Notifier) {
+	jobs.Register(jobVerificationEmail, verificationEmailJob(cfg))
+	jobs.Register(jobPurgeAccount, purgeAccountJob(cfg, notify))
+}
+
+// JobsResponse lists the registered job kinds
+type JobsResponse struct {
+	Jobs []jobs.KindSummary `json:"jobs"`
+}
+
+// RunJobRequest is the optional payload of a job run on demand
+type RunJobRequest struct {
+	// Payload is the job's payload in extended JSON, e.g.
+	// {"user_id": {"$oid": "..."}}
+	Payload json.RawMessage `json:"payload,omitempty" swaggertype:"object"`
+}
+
+// RunJobResponse identifies a job queued on demand
+type RunJobResponse struct {
+	Message string `json:"message" example:"Job queued"`
+	RunID   string `json:"run_id" example:"665f1c2e8b3f4a2d9c0e1a2b"`
+}
+
+// JobRunsResponse lists the recent runs of a job kind
+type JobRunsResponse struct {
+	Kind string     `json:"kind" example:"email.verification"`
+	Runs []jobs.Job `json:"runs"`
+}
+
+// JobLogResponse is a run with the tail of its log
+type JobLogResponse struct {
+	Run jobs.Job       `json:"run"`
+	Log []jobs.LogLine `json:"log"`
+}
+
+// @Summary List background jobs
+// @Description Every registered job kind with its queued, running and failed counts, the status and duration of its last run and when the next queued run is due (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} JobsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jobs [get]
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	summaries, err := jobs.Summarize(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch jobs"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(JobsResponse{Jobs: summaries})
+}
+
+// @Summary Run a background job now
+// @Description Queue a job of a registered kind to run as soon as a worker is free, with an optional payload in extended JSON. Jobs check their payload when they run, so a run that has nothing to do finishes without effect (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job kind"
+// @Param request body RunJobRequest false "Payload"
+// @Success 202 {object} RunJobResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jobs/{name}/run [post]
+func RunJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	kind := mux.Vars(r)["name"]
+	var req RunJobRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil && r.ContentLength != 0 {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	var payload interface{} = bson.D{}
+	if len(req.Payload) > 0 && string(req.Payload) != "null" {
+		var raw bson.Raw
+		if err := bson.UnmarshalExtJSON(req.Payload, false, &raw); err != nil {
+			http.Error(w, `{"error": "payload must be an extended JSON object"}`, http.StatusBadRequest)
+			return
+		}
+		payload = raw
+	}
+
+	ctx := r.Context()
+	id, err := jobs.Trigger(ctx, kind, payload, audit.ActorID(r))
+	if errors.Is(err, jobs.ErrUnknownKind) {
+		http.Error(w, `{"error": "Unknown job"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := audit.Record(r, audit.ActionRunJob, kind, nil, bson.M{"run_id": id.Hex()}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit run of job %s: %v", kind, err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(RunJobResponse{Message: "Job queued", RunID: id.Hex()})
+}
+
+// @Summary List runs of a background job
+// @Description The most recent runs of a job kind, newest first, with status, attempts, last error and duration (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job kind"
+// @Param limit query int false "Runs to return (default 20, max 100)"
+// @Success 200 {object} JobRunsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jobs/{name}/runs [get]
+func ListJobRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	kind := mux.Vars(r)["name"]
+	if !registeredJob(kind) {
+		http.Error(w, `{"error": "Unknown job"}`, http.StatusNotFound)
+		return
+	}
+	limit := int64(20)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 100 {
+			http.Error(w, `{"error": "limit must be between 1 and 100"}`, http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	runs, err := jobs.Runs(r.Context(), kind, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch job runs"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(JobRunsResponse{Kind: kind, Runs: runs})
+}
+
+// @Summary Tail the log of a job run
+// @Description The last lines of a run's log: when each attempt started and how it ended, with lines written by the job itself (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job kind"
+// @Param id path string true "Run ID"
+// @Param tail query int false "Lines to return (default 50, max 200)"
+// @Success 200 {object} JobLogResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jobs/{name}/runs/{id}/log [get]
+func JobRunLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid run ID"}`, http.StatusBadRequest)
+		return
+	}
+	tail := 50
+	if value := r.URL.Query().Get("tail"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 200 {
+			http.Error(w, `{"error": "tail must be between 1 and 200"}`, http.StatusBadRequest)
+			return
+		}
+		tail = parsed
+	}
+
+	run, err := jobs.Run(r.Context(), id, tail)
+	if errors.Is(err, jobs.ErrNotFound) || (err == nil && run.Kind != vars["name"]) {
+		http.Error(w, `{"error": "Job run not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to fetch job run"}`, http.StatusInternalServerError)
+		return
+	}
+	lines := run.Log
+	if lines == nil {
+		lines = []jobs.LogLine{}
+	}
+	json.NewEncoder(w).Encode(JobLogResponse{Run: *run, Log: lines})
+}
+
+// registeredJob reports whether a job kind is registered
+func registeredJob(kind string) bool {
+	for _, k := range jobs.Kinds() {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/jobs"
+)
+
+// jobRun stores a finished run of kind as {run}, with a log line for
+// each message
+func jobRun(kind, status string, messages ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		now := clock.Now()
+		payload, _ := bson.Marshal(bson.M{})
+		run := jobs.Job{ID: clock.NewID(), Kind: kind, Payload: payload, Status: status, Attempts: 1, RunAt: now, CreatedAt: now, StartedAt: &now, FinishedAt: &now, DurationMS: 12}
+		for _, message := range messages {
+			run.Log = append(run.Log, jobs.LogLine{At: now, Message: message})
+		}
+		if _, err := database.DB.Collection("jobs").InsertOne(context.Background(), run); err != nil {
+			t.Fatal(err)
+		}
+		f.set("run", run.ID.Hex())
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	runCases(t, http.HandlerFunc(ListJobs), []handlerCase{
+		{
+			name: "registered kinds", as: "admin", method: "GET", target: "/admin/jobs",
+			setup:  setups(jobRun(jobPurgeAccount, jobs.StatusFailed), jobRun(jobPurgeAccount, jobs.StatusQueued)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp JobsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				kinds := map[string]jobs.KindSummary{}
+				for _, s := range resp.Jobs {
+					kinds[s.Kind] = s
+				}
+				purge, ok := kinds[jobPurgeAccount]
+				if _, verification := kinds[jobVerificationEmail]; !ok || !verification {
+					t.Fatalf("got %+v", resp.Jobs)
+				}
+				if purge.Failed != 1 || purge.Queued != 1 || purge.LastRun == nil || purge.NextRunAt == nil {
+					t.Fatalf("purge %+v", purge)
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/jobs", setup: dbDown("jobs"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRunJob(t *testing.T) {
+	h := route("/admin/jobs/{name}/run", http.HandlerFunc(RunJob))
+	queued := func(payload bson.M) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp RunJobResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			id, _ := primitive.ObjectIDFromHex(resp.RunID)
+			filter := bson.M{"_id": id, "kind": jobPurgeAccount, "status": jobs.StatusQueued, "triggered_by": f.admin.user.ID.Hex()}
+			for field, value := range payload {
+				filter["payload."+field] = value
+			}
+			if n := f.srv.Count("jobs", filter); n != 1 {
+				t.Fatalf("job %s not queued", resp.RunID)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRunJob, "target_id": jobPurgeAccount, "after.run_id": resp.RunID}); n != 1 {
+				t.Fatal("run not audited")
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "without a payload", as: "admin", method: "POST", target: "/admin/jobs/" + jobPurgeAccount + "/run", status: http.StatusAccepted, check: queued(nil)},
+		{name: "with a payload", as: "admin", method: "POST", target: "/admin/jobs/" + jobPurgeAccount + "/run", body: `{"payload":{"user_id":{"$oid":"{user}"}}}`, status: http.StatusAccepted, check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			queued(bson.M{"user_id": f.user.user.ID})(t, f, rec)
+		}},
+		{name: "unknown kind", as: "admin", method: "POST", target: "/admin/jobs/nope/run", status: http.StatusNotFound},
+		{name: "payload not an object", as: "admin", method: "POST", target: "/admin/jobs/" + jobPurgeAccount + "/run", body: `{"payload":[1]}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/jobs/" + jobPurgeAccount + "/run", body: `{"payload"`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/jobs/" + jobPurgeAccount + "/run", setup: dbDown("jobs"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListJobRuns(t *testing.T) {
+	h := route("/admin/jobs/{name}/runs", http.HandlerFunc(ListJobRuns))
+	runs := func(n int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp JobRunsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Kind != jobPurgeAccount || len(resp.Runs) != n || resp.Runs[0].ID.Hex() != f.vars["run"] {
+				t.Fatalf("got %+v, want %d runs, newest first", resp, n)
+			}
+		}
+	}
+	twoRuns := setups(jobRun(jobPurgeAccount, jobs.StatusDone), jobRun(jobVerificationEmail, jobs.StatusDone), jobRun(jobPurgeAccount, jobs.StatusFailed))
+	target := "/admin/jobs/" + jobPurgeAccount + "/runs"
+	runCases(t, h, []handlerCase{
+		{name: "runs of the kind", as: "admin", method: "GET", target: target, setup: twoRuns, status: http.StatusOK, check: runs(2)},
+		{name: "limited", as: "admin", method: "GET", target: target + "?limit=1", setup: twoRuns, status: http.StatusOK, check: runs(1)},
+		{name: "invalid limit", as: "admin", method: "GET", target: target + "?limit=101", status: http.StatusBadRequest},
+		{name: "unknown kind", as: "admin", method: "GET", target: "/admin/jobs/nope/runs", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "GET", target: target, setup: dbDown("jobs"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestJobRunLog(t *testing.T) {
+	h := route("/admin/jobs/{name}/runs/{id}/log", http.HandlerFunc(JobRunLog))
+	logged := func(messages ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp JobLogResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Run.ID.Hex() != f.vars["run"] || len(resp.Log) != len(messages) {
+				t.Fatalf("got %+v, want %v", resp, messages)
+			}
+			for i, message := range messages {
+				if resp.Log[i].Message != message {
+					t.Fatalf("got %+v, want %v", resp.Log, messages)
+				}
+			}
+		}
+	}
+	var lines []string
+	for i := 1; i <= 60; i++ {
+		lines = append(lines, "line "+strconv.Itoa(i))
+	}
+	target := "/admin/jobs/" + jobPurgeAccount + "/runs/{run}/log"
+	runCases(t, h, []handlerCase{
+		{name: "last 50 lines", as: "admin", method: "GET", target: target, setup: jobRun(jobPurgeAccount, jobs.StatusDone, lines...), status: http.StatusOK, check: logged(lines[10:]...)},
+		{name: "tail", as: "admin", method: "GET", target: target + "?tail=2", setup: jobRun(jobPurgeAccount, jobs.StatusDone, lines...), status: http.StatusOK, check: logged("line 59", "line 60")},
+		{
+			name: "no log", as: "admin", method: "GET", target: target,
+			setup:  jobRun(jobPurgeAccount, jobs.StatusDone),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				logged()(t, f, rec)
+				var resp map[string]json.RawMessage
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if string(resp["log"]) != "[]" {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "run of another kind", as: "admin", method: "GET", target: "/admin/jobs/" + jobVerificationEmail + "/runs/{run}/log", setup: jobRun(jobPurgeAccount, jobs.StatusDone), status: http.StatusNotFound},
+		{name: "unknown run", as: "admin", method: "GET", target: "/admin/jobs/" + jobPurgeAccount + "/runs/{missing}/log", status: http.StatusNotFound},
+		{name: "invalid tail", as: "admin", method: "GET", target: target + "?tail=0", setup: jobRun(jobPurgeAccount, jobs.StatusDone), status: http.StatusBadRequest},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/jobs/" + jobPurgeAccount + "/runs/nope/log", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: target, setup: setups(jobRun(jobPurgeAccount, jobs.StatusDone), dbDown("jobs")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/utils"
+)
+
+// OrgStatusRequest represents the request for suspending or archiving an organization
+type OrgStatusRequest struct {
+	Reason string `json:"reason,omitempty" example:"Unpaid invoice #2041"`
+}
+
+// DeleteOrganizationRequest confirms deletion by repeating the organization name
+type DeleteOrganizationRequest struct {
+	Confirm string `json:"confirm" example:"Acme Inc"`
+	Reason  string `json:"reason,omitempty" example:"Contract terminated"`
+}
+
+// OrgExport bundles an organization and all of its members' data
+type OrgExport struct {
+	Organization models.Organization `json:"organization"`
+	Domains      []models.OrgDomain  `json:"domains"`
+	ExportedAt   time.Time           `json:"exported_at"`
+	Members      []MemberExport      `json:"members"`
+}
+
+// MemberExport is one member's decrypted data in an organization export
+type MemberExport struct {
+	ID          string           `json:"id"`
+	Email       string           `json:"email"`
+	DisplayName string           `json:"display_name,omitempty"`
+	DateOfBirth string           `json:"date_of_birth,omitempty"`
+	Role        string           `json:"role"`
+	Locale      string           `json:"locale,omitempty"`
+	Suspended   bool             `json:"suspended,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	Consents    []models.Consent `json:"consents"`
+	APIKeys     []models.APIKey  `json:"api_keys"`
+	// CustomFields are the member's custom field values, decrypted
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty"`
+}
+
+// signInAllowed rejects sign-ins by waitlisted users and by members of
+// suspended or deleted organizations
+func signInAllowed(r *http.Request, user *models.User) (int, string) {
+	if user.Waitlist != nil {
+		security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "account waitlisted")
+		return http.StatusForbidden, "Account is on the waitlist"
+	}
+	status, err := repository.OrgStatus(r.Context(), user.OrgID)
+	if err != nil {
+		return http.StatusInternalServerError, "Database error"
+	}
+	if status == models.OrgSuspended || status == models.OrgDeleted {
+		security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "organization "+status)
+		return http.StatusForbidden, "Organization " + status
+	}
+	return http.StatusOK, ""
+}
+
+// orgWritable reports whether an organization's data may change. Archived and
+// deleted organizations are frozen.
+func orgWritable(ctx context.Context, orgID string) (int, string) {
+	status, err := repository.OrgStatus(ctx, orgID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to verify organization"
+	}
+	if status == models.OrgArchived || status == models.OrgDeleted {
+		return http.StatusConflict, "Organization is " + status + " and read-only"
+	}
+	return http.StatusOK, ""
+}
+
+// memberWritable applies orgWritable to the organization of a user
+func memberWritable(ctx context.Context, userID primitive.ObjectID) (int, string) {
+	user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("org_id"))
+	if err != nil {
+		// Missing users are reported by the caller
+		return http.StatusOK, ""
+	}
+	return orgWritable(ctx, user.OrgID)
+}
+
+// setOrgStatus moves an organization to a lifecycle status and audits it
+func setOrgStatus(w http.ResponseWriter, r *http.Request, status, action string, allowed ...string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, code, msg := findOrganization(mux.Vars(r)["id"])
+	if code != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, code)
+		return
+	}
+
+	var req OrgStatusRequest
+	if r.ContentLength > 0 {
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	current := org.Status
+	if current == "" {
+		current = models.OrgActive
+	}
+	permitted := false
+	for _, from := range allowed {
+		permitted = permitted || from == current
+	}
+	if !permitted {
+		http.Error(w, `{"error": "Organization is `+current+`"}`, http.StatusConflict)
+		return
+	}
+
+	before := bson.M{"status": current, "status_reason": org.StatusReason}
+	org.Status, org.StatusReason, org.UpdatedAt = status, req.Reason, clock.Now()
+	if status == models.OrgActive {
+		org.Status, org.StatusReason = "", ""
+	}
+	update := bson.M{"$set": bson.M{"status": org.Status, "status_reason": org.StatusReason, "updated_at": org.UpdatedAt}}
+	if _, err := database.DB.Collection("organizations").UpdateOne(r.Context(), bson.M{"_id": org.ID}, update); err != nil {
+		http.Error(w, `{"error": "Failed to update organization"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagOrgs)
+	repository.ForgetOrgStatus(org.ID.Hex())
+
+	if _, err := audit.Record(r, action, org.ID.Hex(), before, bson.M{"status": status, "status_reason": req.Reason}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit status change of organization %s: %v", org.ID.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(org)
+}
+
+// @Summary Suspend an organization
+// @Description Lock every member out: logins, sessions and API keys are rejected until the organization is reactivated (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body OrgStatusRequest false "Reason"
+// @Security BearerAuth
+// @Success 200 {object} models.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/suspend [post]
+func SuspendOrganization(w http.ResponseWriter, r *http.Request) {
+	setOrgStatus(w, r, models.OrgSuspended, audit.ActionSuspendOrg, models.OrgActive, models.OrgArchived)
+}
+
+// @Summary Archive an organization
+// @Description Freeze an organization's data read-only: members can sign in and read, but every change to the organization or its members is rejected until it is reactivated (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body OrgStatusRequest false "Reason"
+// @Security BearerAuth
+// @Success 200 {object} models.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/archive [post]
+func ArchiveOrganization(w http.ResponseWriter, r *http.Request) {
+	setOrgStatus(w, r, models.OrgArchived, audit.ActionArchiveOrg, models.OrgActive, models.OrgSuspended)
+}
+
+// @Summary Reactivate an organization
+// @Description Lift a suspension or archival (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} models.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/reactivate [post]
+func ReactivateOrganization(w http.ResponseWriter, r *http.Request) {
+	setOrgStatus(w, r, models.OrgActive, audit.ActionReactivateOrg, models.OrgSuspended, models.OrgArchived)
+}
+
+// @Summary Export an organization
+// @Description Download the organization, its domains and every member's decrypted profile, consents and API keys as one JSON document (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} OrgExport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/export [get]
+func ExportOrganization(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		org, status, msg := findOrganization(mux.Vars(r)["id"])
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+		if org.Status == models.OrgDeleted {
+			http.Error(w, `{"error": "Organization is deleted"}`, http.StatusConflict)
+			return
+		}
+
+		ctx := r.Context()
+		domains, err := tenant.List(ctx, org.ID)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to fetch domains"}`, http.StatusInternalServerError)
+			return
+		}
+		collection, err := repository.UsersForOrg(ctx, org.ID.Hex())
+		if err != nil {
+			http.Error(w, `{"error": "Failed to resolve organization region"}`, http.StatusInternalServerError)
+			return
+		}
+		cursor, err := collection.Find(ctx, bson.M{"org_id": org.ID.Hex(), "forgotten_at": bson.M{"$exists": false}}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			http.Error(w, `{"error": "Failed to fetch members"}`, http.StatusInternalServerError)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		if _, err := audit.Record(r, audit.ActionExportOrg, org.ID.Hex(), nil, nil); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit export of organization %s: %v", org.ID.Hex(), err)
+		}
+
+		// Members are streamed so large organizations are not held in memory
+		head, _ := json.Marshal(struct {
+			Organization *models.Organization `json:"organization"`
+			Domains      []models.OrgDomain   `json:"domains"`
+			ExportedAt   time.Time            `json:"exported_at"`
+		}{org, domains, clock.Now().UTC()})
+		w.Header().Set("Content-Disposition", `attachment; filename="org-`+org.ID.Hex()+`-export.json"`)
+		w.Write(head[:len(head)-1])
+		w.Write([]byte(`,"members":[`))
+
+		exported := 0
+		for cursor.Next(ctx) {
+			var user models.User
+			if err := cursor.Decode(&user); err != nil {
+				correlation.Errorf(r.Context(), "Export of organization %s aborted: %v", org.ID.Hex(), err)
+				return
+			}
+			member, err := exportMember(ctx, cfg, &user)
+			if err != nil {
+				correlation.Errorf(r.Context(), "Export of organization %s aborted at user %s: %v", org.ID.Hex(), user.ID.Hex(), err)
+				return
+			}
+			if exported > 0 {
+				w.Write([]byte(","))
+			}
+			body, _ := json.Marshal(member)
+			w.Write(body)
+			exported++
+		}
+		if err := cursor.Err(); err != nil {
+			correlation.Errorf(r.Context(), "Export of organization %s aborted: %v", org.ID.Hex(), err)
+			return
+		}
+		w.Write([]byte("]}\n"))
+	}
+}
+
+// exportMember decrypts a member's data and gathers their related records
+func exportMember(ctx context.Context, cfg *config.Config, user *models.User) (*MemberExport, error) {
+	member := &MemberExport{
+		ID:          user.ID.Hex(),
+		DisplayName: user.DisplayName,
+		Role:        user.Role,
+		Locale:      user.Locale,
+		Suspended:   user.Suspended,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Consents:    []models.Consent{},
+		APIKeys:     []models.APIKey{},
+	}
+
+	var err error
+	if member.Email, err = keys.Decrypt(ctx, cfg, user.Email); err != nil {
+		return nil, err
+	}
+	if user.DateOfBirth != "" {
+		if member.DateOfBirth, err = keys.Decrypt(ctx, cfg, user.DateOfBirth); err != nil {
+			return nil, err
+		}
+	}
+	if member.CustomFields, err = customfields.Decode(ctx, cfg, user.CustomFields); err != nil {
+		return nil, err
+	}
+
+	cursor, err := database.DB.Collection("consents").Find(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &member.Consents); err != nil {
+		return nil, err
+	}
+	cursor, err = database.DB.Collection("api_keys").Find(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &member.APIKeys); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// @Summary Delete an organization
+// @Description Lock the organization, run the forget workflow for every member (issuing a deletion certificate each), remove its custom domains and destroy its data keys. Returns the deletion report; members that failed are listed and the request can be repeated to finish. Repeat the organization name in confirm (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body DeleteOrganizationRequest true "Confirmation"
+// @Security BearerAuth
+// @Success 200 {object} models.OrgDeletionReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id} [delete]
+func DeleteOrganization(cfg *config.Config, notify *notifier.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		org, status, msg := findOrganization(mux.Vars(r)["id"])
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		var req DeleteOrganizationRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Confirm != org.Name {
+			http.Error(w, `{"error": "Confirm with the exact organization name"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.Background()
+		report := models.OrgDeletionReport{
+			ID:           clock.NewID(),
+			OrgID:        org.ID,
+			RequestedBy:  audit.ActorID(r),
+			Reason:       req.Reason,
+			Certificates: []primitive.ObjectID{},
+			Affected:     make(map[string]int64),
+			StartedAt:    clock.Now().UTC(),
+		}
+
+		// Lock everyone out first so nothing changes while members are erased
+		update := bson.M{"$set": bson.M{"status": models.OrgDeleted, "status_reason": req.Reason, "updated_at": clock.Now()}}
+		if _, err := database.DB.Collection("organizations").UpdateOne(ctx, bson.M{"_id": org.ID}, update); err != nil {
+			http.Error(w, `{"error": "Failed to lock organization"}`, http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(cache.TagOrgs)
+		repository.ForgetOrgStatus(org.ID.Hex())
+
+		members, err := orgMemberIDs(ctx, org.ID.Hex())
+		if err != nil {
+			http.Error(w, `{"error": "Failed to fetch members"}`, http.StatusInternalServerError)
+			return
+		}
+		report.Members = len(members)
+
+		for _, userID := range members {
+			affected, err := forgetUser(ctx, userID)
+			if err == nil {
+				var cert *models.DeletionCertificate
+				if cert, err = issueCertificate(ctx, cfg, audit.ActorID(r), userID, req.Reason, affected); err == nil {
+					report.Certificates = append(report.Certificates, cert.ID)
+				}
+			}
+			if err != nil {
+				correlation.Errorf(r.Context(), "Failed to forget user %s of organization %s: %v", userID.Hex(), org.ID.Hex(), err)
+				report.Failed = append(report.Failed, userID.Hex())
+				continue
+			}
+			report.Forgotten++
+			for name, n := range affected {
+				report.Affected[name] += n
+			}
+		}
+
+		if removed, err := tenant.RemoveOrg(ctx, org.ID); err != nil {
+			correlation.Errorf(r.Context(), "Failed to remove domains of organization %s: %v", org.ID.Hex(), err)
+		} else {
+			report.Affected["org_domains"] = removed
+		}
+
+		// Keys are only shredded once nothing is left that a retry would need to decrypt
+		if len(report.Failed) == 0 {
+			if destroyed, err := keys.Destroy(ctx, org.ID); err != nil {
+				correlation.Errorf(r.Context(), "Failed to destroy keys of organization %s: %v", org.ID.Hex(), err)
+			} else {
+				report.Affected["org_keys"] = destroyed
+			}
+		}
+		report.CompletedAt = clock.Now().UTC()
+
+		if _, err := database.DB.Collection("org_deletion_reports").InsertOne(ctx, report); err != nil {
+			correlation.Errorf(r.Context(), "Failed to store deletion report of organization %s: %v", org.ID.Hex(), err)
+		}
+		if _, err := audit.Record(r, audit.ActionDeleteOrg, org.ID.Hex(), bson.M{"name": org.Name, "status": org.Status}, bson.M{"report_id": report.ID, "forgotten": report.Forgotten, "failed": len(report.Failed)}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit deletion of organization %s: %v", org.ID.Hex(), err)
+		}
+
+		notify.Send(notifier.Event{
+			Type:     "org.deleted",
+			Severity: notifier.SeverityInfo,
+			Message:  "Organization deleted and member data erased",
+			Data:     map[string]interface{}{"org_id": org.ID.Hex(), "report_id": report.ID.Hex(), "forgotten": report.Forgotten, "failed": len(report.Failed)},
+		})
+
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// orgMemberIDs returns the IDs of an organization's members not yet forgotten
+func orgMemberIDs(ctx context.Context, orgID string) ([]primitive.ObjectID, error) {
+	collection, err := repository.UsersForOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, bson.M{"org_id": orgID, "forgotten_at": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+)
+
+// orgStatus creates testOrg as {org} in a lifecycle status
+func orgStatus(status string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		createOrg(t, f)
+		if _, err := database.DB.Collection("organizations").UpdateOne(context.Background(), bson.M{"name": "Acme"}, bson.M{"$set": bson.M{"status": status, "status_reason": "was " + status}}); err != nil {
+			t.Fatal(err)
+		}
+		repository.ForgetOrgStatus(testOrg)
+	}
+}
+
+func TestSetOrgStatus(t *testing.T) {
+	moved := func(status, action, reason string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var org models.Organization
+			json.Unmarshal(rec.Body.Bytes(), &org)
+			stored := status
+			if status == models.OrgActive {
+				stored = ""
+			}
+			if org.Status != stored || org.StatusReason != reason {
+				t.Fatalf("got %+v", org)
+			}
+			if n := f.srv.Count("organizations", bson.M{"name": "Acme", "status": stored, "status_reason": reason}); n != 1 {
+				t.Fatal("status not stored")
+			}
+			if got, _ := repository.OrgStatus(context.Background(), testOrg); got != status {
+				t.Fatalf("cached status %q, want %q", got, status)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": action, "target_id": testOrg, "after.status": status}); n != 1 {
+				t.Fatal("status change not audited")
+			}
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		h      http.HandlerFunc
+		target string
+		cases  []handlerCase
+	}{
+		{"suspend", SuspendOrganization, "/admin/orgs/{org}/suspend", []handlerCase{
+			{name: "active", setup: createOrg, body: `{"reason":"Unpaid invoice"}`, status: http.StatusOK, check: moved(models.OrgSuspended, audit.ActionSuspendOrg, "Unpaid invoice")},
+			{name: "archived", setup: orgStatus(models.OrgArchived), status: http.StatusOK, check: moved(models.OrgSuspended, audit.ActionSuspendOrg, "")},
+			{name: "already suspended", setup: orgStatus(models.OrgSuspended), status: http.StatusConflict},
+			{name: "deleted", setup: orgStatus(models.OrgDeleted), status: http.StatusConflict},
+			{name: "malformed body", setup: createOrg, body: `{"reason":1}`, status: http.StatusBadRequest},
+		}},
+		{"archive", ArchiveOrganization, "/admin/orgs/{org}/archive", []handlerCase{
+			{name: "active", setup: orgStatus(models.OrgActive), body: `{"reason":"Contract ended"}`, status: http.StatusOK, check: moved(models.OrgArchived, audit.ActionArchiveOrg, "Contract ended")},
+			{name: "suspended", setup: orgStatus(models.OrgSuspended), status: http.StatusOK, check: moved(models.OrgArchived, audit.ActionArchiveOrg, "")},
+			{name: "already archived", setup: orgStatus(models.OrgArchived), status: http.StatusConflict},
+		}},
+		{"reactivate", ReactivateOrganization, "/admin/orgs/{org}/reactivate", []handlerCase{
+			{name: "suspended", setup: orgStatus(models.OrgSuspended), status: http.StatusOK, check: moved(models.OrgActive, audit.ActionReactivateOrg, "")},
+			{name: "archived", setup: orgStatus(models.OrgArchived), status: http.StatusOK, check: moved(models.OrgActive, audit.ActionReactivateOrg, "")},
+			{name: "active", setup: createOrg, status: http.StatusConflict},
+			{name: "deleted", setup: orgStatus(models.OrgDeleted), status: http.StatusConflict},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cases := append(tc.cases,
+				handlerCase{name: "unknown organization", target: strings.Replace(tc.target, "{org}", "{missing}", 1), status: http.StatusNotFound},
+				handlerCase{name: "invalid ID", target: strings.Replace(tc.target, "{org}", "nope", 1), status: http.StatusBadRequest},
+				handlerCase{name: "database down", setup: setups(createOrg, dbDown("organizations")), status: http.StatusInternalServerError},
+			)
+			for i := range cases {
+				cases[i].as, cases[i].method = "admin", "POST"
+				if cases[i].target == "" {
+					cases[i].target = tc.target
+				}
+			}
+			runCases(t, route(strings.Replace(tc.target, "{org}", "{id}", 1), tc.h), cases)
+		})
+	}
+}
+
+func TestExportOrganization(t *testing.T) {
+	h := route("/admin/orgs/{id}/export", ExportOrganization(testConfig))
+	runCases(t, h, []handlerCase{
+		{
+			name: "members decrypted", as: "admin", method: "GET", target: "/admin/orgs/{org}/export",
+			setup:  setups(inOrg, orgDomain("acme.example", true)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var export OrgExport
+				if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+					t.Fatalf("%v: %s", err, rec.Body)
+				}
+				if export.Organization.Name != "Acme" || len(export.Domains) != 1 || len(export.Members) != 1 {
+					t.Fatalf("got %+v", export)
+				}
+				member := export.Members[0]
+				if member.ID != f.user.user.ID.Hex() || member.Email != "user@example.com" || member.Consents == nil || member.APIKeys == nil {
+					t.Fatalf("member %+v", member)
+				}
+				if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "org-"+testOrg+"-export.json") {
+					t.Fatalf("Content-Disposition %q", got)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionExportOrg, "target_id": testOrg}); n != 1 {
+					t.Fatal("export not audited")
+				}
+			},
+		},
+		{
+			name: "no members", as: "admin", method: "GET", target: "/admin/orgs/{org}/export",
+			setup:  createOrg,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var export map[string]json.RawMessage
+				json.Unmarshal(rec.Body.Bytes(), &export)
+				if string(export["members"]) != "[]" || string(export["domains"]) != "[]" {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+		{name: "deleted", as: "admin", method: "GET", target: "/admin/orgs/{org}/export", setup: orgStatus(models.OrgDeleted), status: http.StatusConflict},
+		{name: "unknown organization", as: "admin", method: "GET", target: "/admin/orgs/{missing}/export", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/orgs/{org}/export", setup: setups(inOrg, dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	h := route("/admin/orgs/{id}", DeleteOrganization(testConfig, notifier.New(testConfig)))
+	runCases(t, h, []handlerCase{
+		{
+			name: "members forgotten", as: "admin", method: "DELETE", target: "/admin/orgs/{org}", body: `{"confirm":"Acme","reason":"Contract terminated"}`,
+			setup:  setups(inOrg, orgDomain("acme.example", true)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var report models.OrgDeletionReport
+				json.Unmarshal(rec.Body.Bytes(), &report)
+				if report.Members != 1 || report.Forgotten != 1 || len(report.Failed) != 0 || len(report.Certificates) != 1 || report.Affected["org_domains"] != 1 {
+					t.Fatalf("got %+v", report)
+				}
+				if n := f.srv.Count("deletion_certificates", bson.M{"_id": report.Certificates[0]}); n != 1 {
+					t.Fatal("certificate not stored")
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "forgotten_at": bson.M{"$exists": true}}); n != 1 {
+					t.Fatal("member not forgotten")
+				}
+				if n := f.srv.Count("organizations", bson.M{"name": "Acme", "status": models.OrgDeleted, "status_reason": "Contract terminated"}); n != 1 {
+					t.Fatal("organization not locked")
+				}
+				if n := f.srv.Count("org_deletion_reports", bson.M{"_id": report.ID}); n != 1 {
+					t.Fatal("report not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionDeleteOrg, "target_id": testOrg, "after.forgotten": 1}); n != 1 {
+					t.Fatal("deletion not audited")
+				}
+			},
+		},
+		{
+			name: "repeated", as: "admin", method: "DELETE", target: "/admin/orgs/{org}", body: `{"confirm":"Acme"}`,
+			setup:  setups(orgStatus(models.OrgDeleted)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var report models.OrgDeletionReport
+				json.Unmarshal(rec.Body.Bytes(), &report)
+				if report.Members != 0 || report.Forgotten != 0 {
+					t.Fatalf("got %+v", report)
+				}
+			},
+		},
+		{name: "wrong name", as: "admin", method: "DELETE", target: "/admin/orgs/{org}", body: `{"confirm":"acme"}`, setup: inOrg, status: http.StatusBadRequest, check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			if n := f.srv.Count("organizations", bson.M{"status": models.OrgDeleted}); n != 0 {
+				t.Fatal("organization locked")
+			}
+		}},
+		{name: "no confirmation", as: "admin", method: "DELETE", target: "/admin/orgs/{org}", setup: createOrg, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "DELETE", target: "/admin/orgs/{missing}", body: `{"confirm":"Acme"}`, status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/orgs/{org}", body: `{"confirm":"Acme"}`, setup: setups(createOrg, dbDown("organizations")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/ratelimit"
+	"golang-backend/utils"
+)
+
+// UpdateOrgLimitsRequest sets an organization's plan and limit overrides.
+// Omitted limits follow the plan; zero means unlimited.
+type UpdateOrgLimitsRequest struct {
+	Plan              string `json:"plan,omitempty" example:"pro"`
+	RequestsPerMinute *int   `json:"requests_per_minute,omitempty" example:"1200"`
+	MonthlyRequests   *int   `json:"monthly_requests,omitempty" example:"5000000"`
+}
+
+// OrgUsageResponse reports an organization's effective limits and usage
+type OrgUsageResponse struct {
+	OrgID  string           `json:"org_id"`
+	Plan   string           `json:"plan" example:"free"`
+	Limits ratelimit.Limits `json:"limits"`
+	Usage  ratelimit.Usage  `json:"usage"`
+}
+
+// @Summary Update organization limits
+// @Description Set an organization's plan and override its requests per minute or monthly request quota. Omitted limits follow the plan; zero means unlimited (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body UpdateOrgLimitsRequest true "Plan and limits"
+// @Security BearerAuth
+// @Success 200 {object} OrgUsageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/limits [put]
+func UpdateOrganizationLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	var req UpdateOrgLimitsRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.Plan = strings.TrimSpace(req.Plan)
+	if req.Plan != "" && !ratelimit.HasPlan(req.Plan) {
+		http.Error(w, `{"error": "Unknown plan"}`, http.StatusBadRequest)
+		return
+	}
+	if (req.RequestsPerMinute != nil && *req.RequestsPerMinute < 0) || (req.MonthlyRequests != nil && *req.MonthlyRequests < 0) {
+		http.Error(w, `{"error": "Limits cannot be negative"}`, http.StatusBadRequest)
+		return
+	}
+
+	before := bson.M{"plan": org.Plan, "limits": org.Limits}
+	org.Plan = req.Plan
+	org.Limits = nil
+	if req.RequestsPerMinute != nil || req.MonthlyRequests != nil {
+		org.Limits = &models.OrgLimits{RequestsPerMinute: req.RequestsPerMinute, MonthlyRequests: req.MonthlyRequests}
+	}
+	org.UpdatedAt = clock.Now()
+
+	update := bson.M{"$set": bson.M{"plan": org.Plan, "limits": org.Limits, "updated_at": org.UpdatedAt}}
+	if _, err := database.DB.Collection("organizations").UpdateOne(r.Context(), bson.M{"_id": org.ID}, update); err != nil {
+		http.Error(w, `{"error": "Failed to update limits"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagOrgs)
+	ratelimit.Forget(org.ID.Hex())
+
+	if _, err := audit.Record(r, audit.ActionOrgLimits, org.ID.Hex(), before, bson.M{"plan": org.Plan, "limits": org.Limits}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit limits change of organization %s: %v", org.ID.Hex(), err)
+	}
+
+	writeOrgUsage(w, r, org)
+}
+
+// @Summary Get organization usage
+// @Description Show an organization's plan, effective rate limit and monthly quota, and the requests counted against it this month (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} OrgUsageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/usage [get]
+func OrganizationUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	writeOrgUsage(w, r, org)
+}
+
+// writeOrgUsage responds with an organization's limits and current usage
+func writeOrgUsage(w http.ResponseWriter, r *http.Request, org *models.Organization) {
+	plan, limits := ratelimit.Effective(org)
+	usage, err := ratelimit.CurrentUsage(r.Context(), org.ID.Hex(), limits)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch usage"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(OrgUsageResponse{OrgID: org.ID.Hex(), Plan: plan, Limits: limits, Usage: usage})
+}
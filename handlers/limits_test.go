@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/ratelimit"
+)
+
+// ratePlans defines the configured plans without counting requests
+func ratePlans(t *testing.T) {
+	t.Helper()
+	cfg := *testConfig
+	cfg.RateLimitEnabled = false
+	cfg.RateLimitPlans, cfg.DefaultPlan = "free=600/1000,pro=3000/100000", "free"
+	ratelimit.Init(&cfg)
+}
+
+// orgRequests records the requests testOrg made this month
+func orgRequests(n int64) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		period := clock.Now().UTC().Format("2006-01")
+		usage := bson.M{"_id": testOrg + ":" + period, "org_id": testOrg, "period": period, "requests": n}
+		if _, err := database.DB.Collection("org_usage").InsertOne(context.Background(), usage); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// orgUsage checks the plan, limits and remaining quota of the response
+func orgUsage(plan string, perMinute, monthly int, remaining int64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp OrgUsageResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		want := ratelimit.Limits{RequestsPerMinute: perMinute, MonthlyRequests: monthly}
+		if resp.OrgID != testOrg || resp.Plan != plan || resp.Limits != want || resp.Usage.Remaining != remaining {
+			t.Fatalf("got %+v", resp)
+		}
+	}
+}
+
+func TestUpdateOrganizationLimits(t *testing.T) {
+	ratePlans(t)
+	h := route("/admin/orgs/{id}/limits", http.HandlerFunc(UpdateOrganizationLimits))
+	runCases(t, h, []handlerCase{
+		{
+			name: "plan", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{"plan":"pro"}`,
+			setup:  setups(createOrg, orgRequests(400)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				orgUsage("pro", 3000, 100000, 99600)(t, f, rec)
+				if n := f.srv.Count("organizations", bson.M{"name": "Acme", "plan": "pro", "limits": nil}); n != 1 {
+					t.Fatal("plan not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionOrgLimits, "target_id": testOrg, "after.plan": "pro"}); n != 1 {
+					t.Fatal("change not audited")
+				}
+			},
+		},
+		{
+			name: "overrides", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{"requests_per_minute":10,"monthly_requests":0}`,
+			setup:  setups(createOrg, orgRequests(400)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				orgUsage("free", 10, 0, -1)(t, f, rec)
+				if n := f.srv.Count("organizations", bson.M{"name": "Acme", "limits.requests_per_minute": 10, "limits.monthly_requests": 0}); n != 1 {
+					t.Fatal("overrides not stored")
+				}
+			},
+		},
+		{name: "unknown plan", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{"plan":"gold"}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "negative limit", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{"monthly_requests":-1}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{"plan":1}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "PUT", target: "/admin/orgs/{missing}/limits", body: `{}`, status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/orgs/{org}/limits", body: `{}`, setup: setups(createOrg, dbDown("organizations")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestOrganizationUsage(t *testing.T) {
+	ratePlans(t)
+	h := route("/admin/orgs/{id}/usage", http.HandlerFunc(OrganizationUsage))
+	runCases(t, h, []handlerCase{
+		{name: "default plan", as: "admin", method: "GET", target: "/admin/orgs/{org}/usage", setup: setups(createOrg, orgRequests(400)), status: http.StatusOK, check: orgUsage("free", 600, 1000, 600)},
+		{name: "over quota", as: "admin", method: "GET", target: "/admin/orgs/{org}/usage", setup: setups(createOrg, orgRequests(5000)), status: http.StatusOK, check: orgUsage("free", 600, 1000, 0)},
+		{name: "no usage", as: "admin", method: "GET", target: "/admin/orgs/{org}/usage", setup: createOrg, status: http.StatusOK, check: orgUsage("free", 600, 1000, 1000)},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/orgs/nope/usage", status: http.StatusBadRequest},
+		{name: "usage unavailable", as: "admin", method: "GET", target: "/admin/orgs/{org}/usage", setup: setups(createOrg, dbDown("org_usage")), status: http.StatusInternalServerError},
+	})
+}
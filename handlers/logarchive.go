@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/logarchive"
+	"golang-backend/utils"
+)
+
+// LogArchivesResponse lists log archive manifests
+type LogArchivesResponse struct {
+	Archives []logarchive.Archive `json:"archives"`
+}
+
+// RestoreLogsRequest names the range of a log to restore
+type RestoreLogsRequest struct {
+	Log  string    `json:"log" example:"audit_logs"`
+	From time.Time `json:"from" example:"2026-10-01T00:00:00Z"`
+	To   time.Time `json:"to" example:"2026-10-03T00:00:00Z"`
+}
+
+// @Summary List log archives
+// @Description Manifests of the archived days of the audit log and security events, newest first, with their tier, checksums and last verification (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param log query string false "audit_logs or security_events"
+// @Param limit query int false "Archives to return (default 100, max 1000)"
+// @Success 200 {object} LogArchivesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/log-archives [get]
+func ListLogArchives(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := int64(100)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, `{"error": "limit must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	archives, err := logarchive.List(r.Context(), r.URL.Query().Get("log"), limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to list log archives"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(LogArchivesResponse{Archives: archives})
+}
+
+// @Summary Verify a log archive
+// @Description Download an archive and check the stored object's checksum, that it decrypts with the current key, and the checksum and count of its records. The outcome is recorded on the manifest (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Archive ID, e.g. audit_logs:2026-10-15"
+// @Success 200 {object} logarchive.VerifyResult
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/log-archives/{id}/verify [post]
+func VerifyLogArchive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+	ctx := r.Context()
+	result, err := logarchive.Verify(ctx, id)
+	if errors.Is(err, logarchive.ErrNotFound) {
+		http.Error(w, `{"error": "Archive not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		correlation.Errorf(ctx, "Failed to verify log archive %s: %v", id, err)
+		http.Error(w, `{"error": "Failed to verify archive"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := audit.Record(r, audit.ActionLogArchiveVerify, id, nil, bson.M{"ok": result.OK, "problems": result.Problems}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit verification of log archive %s: %v", id, err)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// @Summary Restore archived logs
+// @Description Restore the records of a log created in [from, to) from its archives into the restored_audit_logs or restored_security_events collection for an investigation, where they are kept for LOG_ARCHIVE_RESTORE_TTL. Archives that fail verification are skipped and listed (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body RestoreLogsRequest true "Log and time range"
+// @Success 200 {object} logarchive.RestoreResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/log-archives/restore [post]
+func RestoreLogArchives(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RestoreLogsRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := logarchive.Restore(ctx, req.Log, req.From, req.To)
+	switch {
+	case errors.Is(err, logarchive.ErrUnknownLog):
+		http.Error(w, `{"error": "log must be audit_logs or security_events"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, logarchive.ErrInvalidRange):
+		http.Error(w, `{"error": "to must be after from, within LOG_ARCHIVE_MAX_RESTORE_DAYS"}`, http.StatusBadRequest)
+		return
+	case err != nil:
+		correlation.Errorf(ctx, "Failed to restore %s archives: %v", req.Log, err)
+		http.Error(w, `{"error": "Failed to restore archives"}`, http.StatusInternalServerError)
+		return
+	}
+	after := bson.M{"from": req.From, "to": req.To, "records": result.Records}
+	if _, err := audit.Record(r, audit.ActionLogArchiveRestore, req.Log, nil, after); err != nil {
+		correlation.Errorf(ctx, "Failed to audit restore of %s: %v", req.Log, err)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/logarchive"
+)
+
+// archiveDay is the day the test archives hold, a week ago
+var archiveDay = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -7)
+
+// logArchiveBucket points log archives at an objectStore for the test
+func logArchiveBucket(t *testing.T) *objectStore {
+	t.Helper()
+	store := newObjectStore(t, "log-archives")
+	cfg := *testConfig
+	cfg.LogArchiveBucket, cfg.LogArchiveEndpoint, cfg.LogArchiveKey = store.bucket, store.url, ""
+	logarchive.Init(&cfg)
+	return store
+}
+
+// sum is the hex SHA-256 of b
+func sum(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+// archived stores the audit log records of archiveDay plus offset days as
+// {archive}, sealed as the archive job would
+func archived(store *objectStore, offset int, records ...bson.M) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		d := archiveDay.AddDate(0, 0, offset)
+		var content bytes.Buffer
+		for _, record := range records {
+			line, err := bson.MarshalExtJSON(record, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content.Write(line)
+			content.WriteByte('\n')
+		}
+		key := []byte(testConfig.EncryptionKey)
+		archive := logarchive.Archive{
+			ID:            "audit_logs:" + d.Format("2006-01-02"),
+			Log:           "audit_logs",
+			Day:           d,
+			Records:       len(records),
+			Tier:          logarchive.TierWarm,
+			ContentSHA256: sum(content.Bytes()),
+			KeyID:         sum(key)[:8],
+			CreatedAt:     time.Now(),
+		}
+		if len(records) > 0 {
+			var compressed bytes.Buffer
+			zw := gzip.NewWriter(&compressed)
+			zw.Write(content.Bytes())
+			zw.Close()
+			block, _ := aes.NewCipher(key)
+			gcm, _ := cipher.NewGCM(block)
+			nonce := make([]byte, gcm.NonceSize())
+			object := gcm.Seal(nonce, nonce, compressed.Bytes(), nil)
+			archive.Key = "warm/audit_logs/" + d.Format("2006/01/02") + ".ndjson.gz.enc"
+			archive.Size, archive.ObjectSHA256 = int64(len(object)), sum(object)
+			store.put(archive.Key, string(object))
+		}
+		if _, err := database.DB.Collection("log_archives").InsertOne(context.Background(), archive); err != nil {
+			t.Fatal(err)
+		}
+		f.set("archive", archive.ID)
+	}
+}
+
+// auditRecord is an audit log record created at hour of archiveDay plus
+// offset days
+func auditRecord(offset, hour int) bson.M {
+	return bson.M{"_id": primitive.NewObjectID(), "action": audit.ActionUpdateRole, "created_at": archiveDay.AddDate(0, 0, offset).Add(time.Duration(hour) * time.Hour)}
+}
+
+func TestListLogArchives(t *testing.T) {
+	store := logArchiveBucket(t)
+	days := setups(archived(store, 0, auditRecord(0, 1)), archived(store, 1), archived(store, 2, auditRecord(2, 1), auditRecord(2, 2)))
+	listed := func(records ...int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp LogArchivesResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Archives) != len(records) {
+				t.Fatalf("got %+v, want %d archives", resp.Archives, len(records))
+			}
+			for i, n := range records {
+				if resp.Archives[i].Records != n {
+					t.Fatalf("got %+v, want records %v, newest first", resp.Archives, records)
+				}
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(ListLogArchives), []handlerCase{
+		{name: "newest first", as: "admin", method: "GET", target: "/admin/log-archives", setup: days, status: http.StatusOK, check: listed(2, 0, 1)},
+		{name: "limited", as: "admin", method: "GET", target: "/admin/log-archives?log=audit_logs&limit=1", setup: days, status: http.StatusOK, check: listed(2)},
+		{name: "other log", as: "admin", method: "GET", target: "/admin/log-archives?log=security_events", setup: days, status: http.StatusOK, check: listed()},
+		{name: "invalid limit", as: "admin", method: "GET", target: "/admin/log-archives?limit=1001", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/log-archives", setup: dbDown("log_archives"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestVerifyLogArchive(t *testing.T) {
+	store := logArchiveBucket(t)
+	h := route("/admin/log-archives/{id}/verify", http.HandlerFunc(VerifyLogArchive))
+	verified := func(ok bool, problems ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var result logarchive.VerifyResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			if result.OK != ok || len(result.Problems) != len(problems) || result.Archive.Verified == nil || *result.Archive.Verified != ok {
+				t.Fatalf("got %+v", result)
+			}
+			for i, problem := range problems {
+				if result.Problems[i] != problem {
+					t.Fatalf("got problems %v, want %v", result.Problems, problems)
+				}
+			}
+			if n := f.srv.Count("log_archives", bson.M{"_id": f.vars["archive"], "verified": ok, "verified_at": bson.M{"$exists": true}}); n != 1 {
+				t.Fatal("outcome not recorded")
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionLogArchiveVerify, "target_id": f.vars["archive"], "after.ok": ok}); n != 1 {
+				t.Fatal("verification not audited")
+			}
+		}
+	}
+	tampered := func(t *testing.T, f *fixture) {
+		store.put("warm/audit_logs/"+archiveDay.Format("2006/01/02")+".ndjson.gz.enc", "tampered")
+	}
+	runCases(t, h, []handlerCase{
+		{name: "intact", as: "admin", method: "POST", target: "/admin/log-archives/{archive}/verify", setup: archived(store, 0, auditRecord(0, 1), auditRecord(0, 2)), status: http.StatusOK, check: verified(true)},
+		{name: "empty day", as: "admin", method: "POST", target: "/admin/log-archives/{archive}/verify", setup: archived(store, 0), status: http.StatusOK, check: verified(true)},
+		{name: "tampered", as: "admin", method: "POST", target: "/admin/log-archives/{archive}/verify", setup: setups(archived(store, 0, auditRecord(0, 1)), tampered), status: http.StatusOK, check: verified(false, "object checksum mismatch")},
+		{name: "unknown archive", as: "admin", method: "POST", target: "/admin/log-archives/audit_logs:1999-01-01/verify", status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/log-archives/{archive}/verify", setup: setups(archived(store, 0), dbDown("log_archives")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRestoreLogArchives(t *testing.T) {
+	store := logArchiveBucket(t)
+	days := setups(archived(store, 0, auditRecord(0, 1), auditRecord(0, 20)), archived(store, 1, auditRecord(1, 3)))
+	restore := func(from, to time.Time) string {
+		return `{"log":"audit_logs","from":"` + from.Format(time.RFC3339) + `","to":"` + to.Format(time.RFC3339) + `"}`
+	}
+	restored := func(archives, records int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var result logarchive.RestoreResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			if result.Collection != "restored_audit_logs" || result.Archives != archives || result.Records != records || len(result.Problems) != 0 {
+				t.Fatalf("got %+v", result)
+			}
+			if n := f.srv.Count("restored_audit_logs", bson.M{"restored_at": bson.M{"$exists": true}}); n != records {
+				t.Fatalf("%d records restored, want %d", n, records)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionLogArchiveRestore, "target_id": "audit_logs", "after.records": records}); n != 1 {
+				t.Fatal("restore not audited")
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(RestoreLogArchives), []handlerCase{
+		{name: "range", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay.AddDate(0, 0, 2)), setup: days, status: http.StatusOK, check: restored(2, 3)},
+		{name: "part of a day", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay.Add(12*time.Hour)), setup: days, status: http.StatusOK, check: restored(1, 1)},
+		{
+			name: "tampered archive", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay.AddDate(0, 0, 2)),
+			setup: setups(days, func(t *testing.T, f *fixture) {
+				store.put("warm/audit_logs/"+archiveDay.Format("2006/01/02")+".ndjson.gz.enc", "tampered")
+			}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var result logarchive.RestoreResult
+				json.Unmarshal(rec.Body.Bytes(), &result)
+				if result.Archives != 1 || result.Records != 1 || len(result.Problems) != 1 {
+					t.Fatalf("got %+v", result)
+				}
+			},
+		},
+		{name: "unknown log", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: `{"log":"users","from":"2026-10-01T00:00:00Z","to":"2026-10-02T00:00:00Z"}`, status: http.StatusBadRequest},
+		{name: "empty range", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay), status: http.StatusBadRequest},
+		{name: "range too long", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay.AddDate(0, 0, 32)), status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: `{"log":"audit_logs","from":"yesterday"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/log-archives/restore", body: restore(archiveDay, archiveDay.AddDate(0, 0, 2)), setup: dbDown("log_archives"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/loginhistory"
+	"golang-backend/repository"
+)
+
+// LoginHistoryResponse is a page of sign-in attempts on an account, newest
+// first
+type LoginHistoryResponse struct {
+	UserID string               `json:"user_id"`
+	Logins []loginhistory.Event `json:"logins"`
+	// NextCursor continues the listing as the cursor parameter
+	NextCursor string `json:"next_cursor,omitempty" example:"65a5f1c2e4b0a1b2c3d4e5f6"`
+}
+
+// recordLogin adds a sign-in attempt to the user's login history
+func recordLogin(r *http.Request, userID, method string, ok bool, reason string) {
+	outcome := loginhistory.OutcomeSuccess
+	if !ok {
+		outcome = loginhistory.OutcomeFailure
+	}
+	loginhistory.Record(r, userID, method, outcome, reason)
+}
+
+// @Summary Your login history
+// @Description List successful and failed sign-ins to your account, newest first, with the client IP and user agent of each. Kept for LOGIN_HISTORY_RETENTION
+// @Tags user
+// @Produce json
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Security BearerAuth
+// @Success 200 {object} LoginHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/login-history [get]
+func GetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	writeLoginHistory(w, r, userID)
+}
+
+// @Summary List a user's logins
+// @Description List successful and failed sign-ins to a user's account, newest first, with the client IP, user agent and, for failures, the reason (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Security BearerAuth
+// @Success 200 {object} LoginHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/logins [get]
+func ListUserLogins(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return
+	}
+	if _, _, err := repository.FindUser(r.Context(), bson.M{"_id": userID}, repository.IDOnly); errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+	writeLoginHistory(w, r, userID)
+}
+
+// writeLoginHistory answers with the page of a user's login history the
+// cursor and limit parameters ask for
+func writeLoginHistory(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) {
+	query := r.URL.Query()
+	var before primitive.ObjectID
+	if c := query.Get("cursor"); c != "" {
+		id, err := primitive.ObjectIDFromHex(c)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+		before = id
+	}
+	limit := 50
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	events, err := loginhistory.List(r.Context(), userID, before, int64(limit))
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch login history"}`, http.StatusInternalServerError)
+		return
+	}
+	resp := LoginHistoryResponse{UserID: userID.Hex(), Logins: events}
+	if len(events) == limit {
+		resp.NextCursor = events[len(events)-1].ID.Hex()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/loginhistory"
+)
+
+// signInAttempts records a failed, a successful and a failed sign-in of the
+// user, oldest first; {login} is the middle one
+func signInAttempts(t *testing.T, f *fixture) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("User-Agent", "attempts/1.0")
+	userID := f.user.user.ID.Hex()
+	recordLogin(r, userID, "password", false, "invalid password")
+	recordLogin(r, userID, "password", true, "")
+	recordLogin(r, userID, "otp", false, "invalid code")
+	recordLogin(r, f.admin.user.ID.Hex(), "password", true, "")
+	events, err := loginhistory.List(r.Context(), f.user.user.ID, primitive.NilObjectID, 3)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("got %v, %v", events, err)
+	}
+	f.set("login", events[1].ID.Hex())
+}
+
+// loginPage checks the methods of a page of login history and whether it
+// continues
+func loginPage(more bool, methods ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp LoginHistoryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.UserID != f.user.user.ID.Hex() || len(resp.Logins) != len(methods) || (resp.NextCursor != "") != more {
+			t.Fatalf("got %+v, want %v", resp, methods)
+		}
+		for i, method := range methods {
+			if resp.Logins[i].Method != method || resp.Logins[i].UserAgent != "attempts/1.0" {
+				t.Fatalf("got %+v, want %v, newest first", resp.Logins, methods)
+			}
+		}
+		if len(methods) > 0 && resp.Logins[0].Outcome != loginhistory.OutcomeFailure {
+			t.Fatalf("got %+v", resp.Logins[0])
+		}
+	}
+}
+
+func TestGetLoginHistory(t *testing.T) {
+	runCases(t, http.HandlerFunc(GetLoginHistory), []handlerCase{
+		{name: "own attempts", as: "user", method: "GET", target: "/user/login-history", setup: signInAttempts, status: http.StatusOK, check: loginPage(false, "otp", "password", "password")},
+		{name: "first page", as: "user", method: "GET", target: "/user/login-history?limit=2", setup: signInAttempts, status: http.StatusOK, check: loginPage(true, "otp", "password")},
+		{name: "next page", as: "user", method: "GET", target: "/user/login-history?limit=2&cursor={login}", setup: signInAttempts, status: http.StatusOK, check: loginPage(false, "password")},
+		{name: "limit out of range", as: "user", method: "GET", target: "/user/login-history?limit=500", setup: signInAttempts, status: http.StatusOK, check: loginPage(false, "otp", "password", "password")},
+		{name: "no attempts", as: "user", method: "GET", target: "/user/login-history", status: http.StatusOK, check: loginPage(false)},
+		{name: "invalid cursor", as: "user", method: "GET", target: "/user/login-history?cursor=nope", status: http.StatusBadRequest},
+		{name: "no session", as: "guest", method: "GET", target: "/user/login-history", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "GET", target: "/user/login-history", setup: dbDown("login_events"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListUserLogins(t *testing.T) {
+	h := route("/admin/users/{id}/logins", http.HandlerFunc(ListUserLogins))
+	runCases(t, h, []handlerCase{
+		{name: "attempts of the user", as: "admin", method: "GET", target: "/admin/users/{user}/logins", setup: signInAttempts, status: http.StatusOK, check: loginPage(false, "otp", "password", "password")},
+		{name: "paged", as: "admin", method: "GET", target: "/admin/users/{user}/logins?limit=1&cursor={login}", setup: signInAttempts, status: http.StatusOK, check: loginPage(true, "password")},
+		{name: "unknown user", as: "admin", method: "GET", target: "/admin/users/{missing}/logins", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/users/nope/logins", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/{user}/logins", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/security"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// LogoutRequest represents the optional request payload for signing out
+type LogoutRequest struct {
+	// All signs out every session of the user, not just this one
+	All bool `json:"all,omitempty" example:"false"`
+}
+
+// LogoutResponse represents the response for signing out
+type LogoutResponse struct {
+	Message         string `json:"message" example:"Signed out"`
+	SessionsRevoked int64  `json:"sessions_revoked" example:"1"`
+}
+
+// Logout revokes the session token of the request
+// @Summary Sign out
+// @Description Revoke the session token used for this request, and the refresh tokens of its sign-in, before they expire. With all, every session of the user is revoked
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body LogoutRequest false "Sign out options"
+// @Security BearerAuth
+// @Success 200 {object} LogoutResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	userID, _ := claims["userID"].(string)
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		http.Error(w, `{"error": "Only session tokens can be signed out"}`, http.StatusBadRequest)
+		return
+	}
+
+	revoked := int64(1)
+	if req.All {
+		n, err := tokens.RevokeUser(r.Context(), userID)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to sign out"}`, http.StatusInternalServerError)
+			return
+		}
+		revoked = n
+	} else {
+		exp, _ := claims["exp"].(float64)
+		if err := tokens.RevokeSession(r.Context(), userID, jti, time.Unix(int64(exp), 0)); err != nil {
+			http.Error(w, `{"error": "Failed to sign out"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	security.Emit(r, security.EventLogout, security.OutcomeSuccess, userID, "")
+	json.NewEncoder(w).Encode(LogoutResponse{Message: "Signed out", SessionsRevoked: revoked})
+}
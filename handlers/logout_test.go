@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogout(t *testing.T) {
+	runCases(t, http.HandlerFunc(Logout), []handlerCase{
+		{
+			name: "signs out the session", as: "user", method: "POST", target: "/logout",
+			setup:  otherSession,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp LogoutResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.SessionsRevoked != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+				checkToken(t, f.user.token, http.StatusUnauthorized)
+				checkToken(t, f.vars["other"], http.StatusOK)
+			},
+		},
+		{
+			name: "signs out every session", as: "user", method: "POST", target: "/logout", body: `{"all":true}`,
+			setup:  otherSession,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp LogoutResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.SessionsRevoked != 2 {
+					t.Fatalf("got %+v", resp)
+				}
+				checkToken(t, f.user.token, http.StatusUnauthorized)
+				checkToken(t, f.vars["other"], http.StatusUnauthorized)
+				checkToken(t, f.admin.token, http.StatusOK)
+			},
+		},
+		{name: "malformed body", as: "user", method: "POST", target: "/logout", body: `{"all":1}`, status: http.StatusBadRequest},
+		{name: "no session", as: "guest", method: "POST", target: "/logout", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "POST", target: "/logout", setup: dbDown("token_ids", "refresh_tokens"), status: http.StatusInternalServerError},
+	})
+}
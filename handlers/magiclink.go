@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/challenge"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/loginhistory"
+	"golang-backend/magiclink"
+	"golang-backend/mailer"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// MagicLinkRequest represents the request for a sign-in link
+type MagicLinkRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// MagicLinkLoginRequest represents the request for signing in with a link
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" example:"Qm9x1kV8pR2sT7wZ4nC6eH0jU5yA3dF8gL2iO1qEb3J"`
+}
+
+// RequestMagicLink handles passwordless sign-in requests
+// @Summary Request a sign-in link
+// @Description Email a single-use link for signing in without a password. The response is the same whether or not the address has an account; repeated requests from one client are challenged as for login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkRequest true "Account email"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /login/magic [post]
+func RequestMagicLink(cfg *config.Config, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MagicLinkRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Email == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+
+		// As for password resets, every request counts towards the client's
+		// challenges
+		clientIP := audit.ClientIP(r)
+		if !requireChallenge(w, r, cfg, challenge.FlowMagicLink, clientIP, req.ChallengeResponse, accountRecipient(req.Email, emailHash)) {
+			return
+		}
+		failChallenge(r, challenge.FlowMagicLink, clientIP)
+
+		orgID := tenant.OrgID(r)
+		sendAnonymously(func(ctx context.Context) { sendMagicLink(ctx, cfg, mail, req.Email, emailHash, orgID) })
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "If the address has an account, a sign-in link is on its way"})
+	}
+}
+
+// sendMagicLink emails a sign-in link to the account registered with an
+// email, if there is one that may sign in here
+func sendMagicLink(ctx context.Context, cfg *config.Config, mail mailer.Mailer, email, emailHash, orgID string) {
+	user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.Fields("display_name", "locale", "org_id", "suspended"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up sign-in link recipient: %v", err)
+		return
+	}
+	if (orgID != "" && user.OrgID != orgID) || user.Suspended {
+		return
+	}
+
+	token, err := magiclink.Issue(ctx, user.ID, emailHash)
+	if errors.Is(err, magiclink.ErrCooldown) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue sign-in link for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	link := cfg.MagicLinkURL
+	if link == "" {
+		link = cfg.AppURL + "/magic-login"
+	}
+	vars := map[string]string{
+		"LoginURL": link + "?token=" + url.QueryEscape(token),
+		"Minutes":  strconv.Itoa(int(magiclink.TTL().Minutes())),
+	}
+	msg, err := renderUserEmail(ctx, cfg, "magic_link", email, user, vars)
+	if err != nil {
+		log.Printf("Failed to render sign-in link email for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if err := sendRequested(mail, msg); err != nil {
+		log.Printf("Failed to send sign-in link to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// MagicLinkLogin handles signing in with a link
+// @Summary Sign in with a link
+// @Description Exchange the token from a sign-in link for the same session as POST /login. The token works once. Following the link also verifies the address
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkLoginRequest true "Sign-in token"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid or expired sign-in link"
+// @Failure 403 {string} string "Account suspended"
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /login/magic/verify [post]
+func MagicLinkLogin(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MagicLinkLoginRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Token == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		token, err := magiclink.Redeem(ctx, req.Token)
+		if errors.Is(err, magiclink.ErrInvalidToken) {
+			security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid sign-in link")
+			http.Error(w, "Invalid or expired sign-in link", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// The address must not have changed since the link was sent
+		filter := bson.M{"_id": token.UserID, "email_hash": token.EmailHash}
+		user, collection, err := repository.FindUser(ctx, filter, repository.CredentialFields)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Invalid or expired sign-in link", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		userID := user.ID.Hex()
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, userID, "not a member of the tenant")
+			recordLogin(r, userID, loginhistory.MethodMagicLink, false, "not a member of the tenant")
+			http.Error(w, "Invalid or expired sign-in link", http.StatusUnauthorized)
+			return
+		}
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "account suspended")
+			recordLogin(r, userID, loginhistory.MethodMagicLink, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, userID, loginhistory.MethodMagicLink, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
+
+		// Opening the link proves the address
+		if user.EmailUnverified {
+			update := bson.M{
+				"$set":   bson.M{"email_verified_at": clock.Now()},
+				"$unset": bson.M{"email_unverified": ""},
+			}
+			if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+				correlation.Errorf(ctx, "Failed to verify email of user %s after sign-in link: %v", userID, err)
+			} else {
+				cache.Invalidate(cache.TagUsers)
+			}
+		}
+
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", false)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		resetChallenge(r, challenge.FlowLogin, token.EmailHash)
+		correlation.SetUser(ctx, userID)
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, userID, "sign-in link")
+		recordLogin(r, userID, loginhistory.MethodMagicLink, true, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/magiclink"
+	"golang-backend/utils"
+)
+
+// magicLink sets {token} to the token of a sign-in link sent to the user
+func magicLink(t *testing.T, f *fixture) {
+	token, err := magiclink.Issue(context.Background(), f.user.user.ID, f.user.user.EmailHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("token", token)
+}
+
+func TestRequestMagicLink(t *testing.T) {
+	mail := newOutbox()
+	runCases(t, RequestMagicLink(testConfig, mail), []handlerCase{
+		{
+			name: "emails a link", method: "POST", target: "/login/magic", body: `{"email":"User@example.com"}`,
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				msg := mail.next(t)
+				if msg.To != "user@example.com" {
+					t.Fatalf("emailed %s", msg.To)
+				}
+				body := marshal(t, MagicLinkLoginRequest{Token: linkToken(t, msg)})
+				if rec := request(MagicLinkLogin(testConfig), "POST", "/login/magic/verify", "", body); rec.Code != http.StatusOK {
+					t.Fatalf("emailed link: got %d %s", rec.Code, rec.Body)
+				}
+			},
+		},
+		{name: "unknown address", method: "POST", target: "/login/magic", body: `{"email":"nobody@example.com"}`, status: http.StatusAccepted},
+		{name: "malformed body", method: "POST", target: "/login/magic", body: `{"email":1}`, status: http.StatusBadRequest},
+		{name: "missing address", method: "POST", target: "/login/magic", body: `{}`, status: http.StatusBadRequest},
+	})
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	runCases(t, MagicLinkLogin(testConfig), []handlerCase{
+		{
+			name: "signs in", method: "POST", target: "/login/magic/verify", body: `{"token":"{token}"}`,
+			setup:  magicLink,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var session LoginResponse
+				json.Unmarshal(rec.Body.Bytes(), &session)
+				if session.Token == "" || session.RefreshToken == "" || session.Role != "user" {
+					t.Fatalf("got %+v", session)
+				}
+				body := `{"token":"` + f.vars["token"] + `"}`
+				if rec := request(MagicLinkLogin(testConfig), "POST", "/login/magic/verify", "", body); rec.Code != http.StatusUnauthorized {
+					t.Fatalf("link used twice: got %d", rec.Code)
+				}
+			},
+		},
+		{
+			name: "verifies the address", method: "POST", target: "/login/magic/verify", body: `{"token":"{token}"}`,
+			setup:  setups(magicLink, func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"email_unverified": true}) }),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_unverified": bson.M{"$exists": false}, "email_verified_at": bson.M{"$exists": true}}); n != 1 {
+					t.Fatal("address not verified")
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/login/magic/verify", body: `{"token":1}`, status: http.StatusBadRequest},
+		{name: "missing token", method: "POST", target: "/login/magic/verify", body: `{}`, status: http.StatusBadRequest},
+		{name: "unknown token", method: "POST", target: "/login/magic/verify", body: `{"token":"unknown"}`, setup: magicLink, status: http.StatusUnauthorized},
+		{
+			name: "address changed since", method: "POST", target: "/login/magic/verify", body: `{"token":"{token}"}`,
+			setup: setups(magicLink, func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"email_hash": utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)})
+			}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "suspended", method: "POST", target: "/login/magic/verify", body: `{"token":"{token}"}`,
+			setup:  setups(magicLink, func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"suspended": true}) }),
+			status: http.StatusForbidden,
+		},
+		{name: "database down", method: "POST", target: "/login/magic/verify", body: `{"token":"{token}"}`, setup: setups(magicLink, dbDown("login_tokens")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/analytics"
+	"golang-backend/apikeys"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/dbtest"
+	"golang-backend/emailverify"
+	"golang-backend/magiclink"
+	"golang-backend/mailer"
+	"golang-backend/middleware"
+	"golang-backend/models"
+	"golang-backend/otp"
+	"golang-backend/passwordpolicy"
+	"golang-backend/passwordreset"
+	"golang-backend/permissions"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+	"golang-backend/webauthn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testConfig is loaded once; handlers get it injected like in main
+var testConfig *config.Config
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	testConfig = config.Load()
+	passwordpolicy.Init(testConfig)
+	RegisterJobs(testConfig, nil)
+
+	// Emails and other background work a test starts may outlive it; they
+	// finish against this database once the test's own is gone
+	background, err := dbtest.New().Database("golang-backend")
+	if err != nil {
+		panic(err)
+	}
+	database.DB, database.DefaultRegion = background, "default"
+	database.Regions = map[string]*mongo.Database{"default": background}
+	// Analytics events are buffered for the whole run and flushed to it too
+	analytics.Init(testConfig)
+	os.Exit(m.Run())
+}
+
+// startDB serves the handlers from an empty in-memory database, with the
+// packages that keep state in it initialized
+func startDB(t *testing.T) *dbtest.Server {
+	t.Helper()
+	srv := dbtest.Start(t)
+	tokens.Init(testConfig)
+	permissions.Init(testConfig)
+	apikeys.Init(testConfig)
+	webauthn.Init(testConfig)
+	otp.Init(testConfig)
+	magiclink.Init(testConfig)
+	passwordreset.Init(testConfig)
+	emailverify.Init(testConfig)
+	return srv
+}
+
+// testPassword is the password of every user createUser stores
+const testPassword = "correct horse battery staple"
+
+// createUser stores a user with the given role and returns it
+func createUser(t *testing.T, email, role string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	encrypted, err := utils.Encrypt(email, testConfig.EncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := clock.Now()
+	user := &models.User{
+		ID:        primitive.NewObjectID(),
+		EmailHash: utils.EmailIndex(email, testConfig.EmailFoldAliases),
+		Email:     encrypted,
+		Password:  string(hash),
+		Role:      role,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := database.DB.Collection("users").InsertOne(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// session is a signed-in user making requests through its own session
+// middleware, which caches the user's role like a running server would
+type session struct {
+	user  *models.User
+	token string
+	auth  func(http.Handler) http.Handler
+}
+
+// testClient is the client sessions are signed in from
+var testClient = tokens.Client{IP: "203.0.113.7", UserAgent: "handlers-test/1.0"}
+
+// signIn issues a session token for user as a login would
+func signIn(t *testing.T, user *models.User) *session {
+	t.Helper()
+	issued, err := issueSession(context.Background(), testConfig, user, testClient, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &session{user: user, token: issued.Token, auth: middleware.JWTAuthMiddleware(testConfig)}
+}
+
+// do sends a request through the session middleware to h. A nil session
+// sends it without a token.
+func (s *session) do(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	auth := middleware.JWTAuthMiddleware(testConfig)
+	token := ""
+	if s != nil {
+		auth, token = s.auth, s.token
+	}
+	return request(auth(h), method, target, token, body)
+}
+
+// request serves one request with an optional bearer token
+func request(h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// route serves h at a path pattern, so it gets the path variables it reads
+// through mux.Vars
+func route(pattern string, h http.Handler) http.Handler {
+	router := mux.NewRouter()
+	router.Handle(pattern, h)
+	return router
+}
+
+// formBoundary separates the parts of the bodies form builds
+const formBoundary = "handlers-test-boundary"
+
+// form returns a multipart body with the given fields and, unless filename
+// is empty, a file part named file. Handlers read it through multipartForm.
+func form(t *testing.T, filename, content string, fields map[string]string) string {
+	t.Helper()
+	var body strings.Builder
+	writer := multipart.NewWriter(&body)
+	writer.SetBoundary(formBoundary)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	if filename != "" {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body.String()
+}
+
+// multipartForm serves h requests with bodies built by form
+func multipartForm(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Content-Type", "multipart/form-data; boundary="+formBoundary)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// marshal returns v as a JSON request body
+func marshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// outbox is a mailer.Mailer passing the messages it sends on
+type outbox chan mailer.Message
+
+func newOutbox() outbox {
+	return make(outbox, 16)
+}
+
+func (o outbox) Send(to, subject, body string) error {
+	return o.SendMessage(mailer.Message{To: to, Subject: subject, Text: body})
+}
+
+func (o outbox) SendMessage(msg mailer.Message) error {
+	o <- msg
+	return nil
+}
+
+// next waits for the next message, which may be sent in the background
+func (o outbox) next(t *testing.T) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-o:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+		return mailer.Message{}
+	}
+}
+
+// eventually waits for cond to hold, for work a handler leaves running in
+// the background
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+	}
+}
+
+// linkToken reads the token of the link in an email
+func linkToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	match := regexp.MustCompile(`[?&]token=([^&\s"]+)`).FindStringSubmatch(msg.Text)
+	if match == nil {
+		t.Fatalf("no link in %q", msg.Text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// fixture is an in-memory database with an admin and a user signed in
+type fixture struct {
+	srv         *dbtest.Server
+	admin, user *session
+	// vars are the placeholders set up for a case
+	vars map[string]string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{srv: startDB(t), vars: make(map[string]string)}
+	f.admin = signIn(t, createUser(t, "admin@example.com", "admin"))
+	f.user = signIn(t, createUser(t, "user@example.com", "user"))
+	return f
+}
+
+// handlerCase is one request of a table-driven handler test. In target and
+// body, {user} stands for the signed-in user's ID, {missing} for an ID
+// no user has, and {name} for what setup stored with f.set.
+type handlerCase struct {
+	name string
+	// as is "admin" or "user" to send the request with their session, "guest"
+	// to send it through the session middleware without one, or empty for
+	// public routes, served without the middleware
+	as             string
+	method, target string
+	body           string
+	// setup runs before the request; check after it, on success or not
+	setup  func(t *testing.T, f *fixture)
+	status int
+	check  func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder)
+}
+
+// runCases serves each case from a fresh fixture
+func runCases(t *testing.T, h http.Handler, cases []handlerCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			if tc.setup != nil {
+				tc.setup(t, f)
+			}
+			replace := []string{"{user}", f.user.user.ID.Hex(), "{missing}", primitive.NewObjectID().Hex()}
+			for name, value := range f.vars {
+				replace = append(replace, "{"+name+"}", value)
+			}
+			placeholders := strings.NewReplacer(replace...)
+			target, body := placeholders.Replace(tc.target), placeholders.Replace(tc.body)
+			var rec *httptest.ResponseRecorder
+			switch tc.as {
+			case "admin":
+				rec = f.admin.do(h, tc.method, target, body)
+			case "user":
+				rec = f.user.do(h, tc.method, target, body)
+			case "guest":
+				rec = (*session)(nil).do(h, tc.method, target, body)
+			default:
+				rec = request(h, tc.method, target, "", body)
+			}
+			if rec.Code != tc.status {
+				t.Fatalf("got %d %s, want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tc.status)
+			}
+			if tc.check != nil {
+				tc.check(t, f, rec)
+			}
+		})
+	}
+}
+
+// set defines the placeholder {name} for the request of a case
+func (f *fixture) set(name, value string) {
+	f.vars[name] = value
+}
+
+// checkSessions sends a request with both sessions, so their middleware has
+// the users' roles cached as on a running server
+func (f *fixture) checkSessions(t *testing.T) {
+	t.Helper()
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, s := range []*session{f.admin, f.user} {
+		if rec := s.do(ok, "GET", "/", ""); rec.Code != http.StatusOK {
+			t.Fatalf("session check: got %d", rec.Code)
+		}
+	}
+}
+
+// dbDown fails every command on the named collections once the sessions
+// have been checked, so the session middleware still lets them in
+func dbDown(names ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		f.checkSessions(t)
+		f.srv.Fail(names...)
+	}
+}
+
+// userDeleted removes the signed-in user after the sessions have been checked
+func userDeleted(t *testing.T, f *fixture) {
+	f.checkSessions(t)
+	if _, err := database.DB.Collection("users").DeleteOne(context.Background(), bson.M{"_id": f.user.user.ID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setups runs several setups in order
+func setups(steps ...func(*testing.T, *fixture)) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		for _, step := range steps {
+			step(t, f)
+		}
+	}
+}
+
+// setUser sets fields of the signed-in user's stored document
+func setUser(t *testing.T, f *fixture, fields bson.M) {
+	t.Helper()
+	if _, err := database.DB.Collection("users").UpdateOne(context.Background(), bson.M{"_id": f.user.user.ID}, bson.M{"$set": fields}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// grant maps the user role to perms, as PUT /admin/permissions/user would.
+// The user's session picks them up through its bumped role version.
+func grant(perms ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		ctx := context.Background()
+		if _, _, err := permissions.Set(ctx, "user", perms, ""); err != nil {
+			t.Fatal(err)
+		}
+		// The cached mapping outlives the database; leave it as seeded
+		t.Cleanup(func() { permissions.Set(ctx, "user", nil, "") })
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"golang-backend/analytics"
+	"golang-backend/cache"
+	"golang-backend/config"
+	"golang-backend/routestats"
+	"golang-backend/synthetic"
+	"golang-backend/utils"
+)
+
+// @Summary Response cache metrics
+// @Description Hit, miss, store and invalidation counters of the response cache since startup (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} cache.Stats
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/cache/stats [get]
+func CacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.Snapshot())
+}
+
+// @Summary Password hashing pool metrics
+// @Description Worker count, in-flight operations, rejections and average queue wait of the bcrypt pool (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} utils.PasswordPoolStats
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/password-pool/stats [get]
+func PasswordPoolStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(utils.PasswordPool())
+}
+
+// @Summary Per-route request metrics
+// @Description Requests, client errors, server errors and validation failures of every route since startup on this instance, with the route alerts currently raised (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} routestats.Stats
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/routes/stats [get]
+func RouteStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routestats.Snapshot())
+}
+
+// @Summary Prometheus metrics
+// @Description Results of the synthetic self-tests and per-route request counters in the Prometheus text format. Requires METRICS_TOKEN as a bearer token when it is configured
+// @Tags status
+// @Produce plain
+// @Success 200 {string} string "Metrics"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /metrics [get]
+func Metrics(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.MetricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		synthetic.WriteMetrics(w)
+		analytics.WriteMetrics(w)
+		routestats.WriteMetrics(w)
+	}
+}
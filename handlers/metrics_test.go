@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang-backend/cache"
+	"golang-backend/routestats"
+	"golang-backend/utils"
+)
+
+func TestCacheStats(t *testing.T) {
+	runCases(t, http.HandlerFunc(CacheStats), []handlerCase{
+		{
+			name: "counters", as: "admin", method: "GET", target: "/admin/cache/stats",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var stats cache.Stats
+				if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats.Backend != cache.Snapshot().Backend {
+					t.Fatalf("got %s, %v", rec.Body, err)
+				}
+			},
+		},
+	})
+}
+
+func TestPasswordPoolStats(t *testing.T) {
+	runCases(t, http.HandlerFunc(PasswordPoolStats), []handlerCase{
+		{
+			name: "pool", as: "admin", method: "GET", target: "/admin/password-pool/stats",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var stats utils.PasswordPoolStats
+				json.Unmarshal(rec.Body.Bytes(), &stats)
+				if stats.Workers != runtime.NumCPU() || stats.QueueTimeoutSec != 1 {
+					t.Fatalf("got %+v", stats)
+				}
+			},
+		},
+	})
+}
+
+func TestRouteStats(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(routestats.Middleware)
+	router.HandleFunc("/route-stats-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid", http.StatusBadRequest)
+	})
+	for _, id := range []string{"1", "2"} {
+		request(router, "GET", "/route-stats-test/"+id, "", "")
+	}
+
+	runCases(t, http.HandlerFunc(RouteStats), []handlerCase{
+		{
+			name: "counted routes", as: "admin", method: "GET", target: "/admin/routes/stats",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var stats []routestats.Stats
+				json.Unmarshal(rec.Body.Bytes(), &stats)
+				for _, s := range stats {
+					if s.Route == "/route-stats-test/{id}" {
+						if s.Method != "GET" || s.Requests != 2 || s.ClientErrors != 2 || s.ValidationFailures != 2 {
+							t.Fatalf("got %+v", s)
+						}
+						return
+					}
+				}
+				t.Fatalf("route not counted in %s", rec.Body)
+			},
+		},
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(routestats.Middleware)
+	router.HandleFunc("/metrics-test", func(w http.ResponseWriter, r *http.Request) {})
+	request(router, "GET", "/metrics-test", "", "")
+
+	open := Metrics(testConfig)
+	cfg := *testConfig
+	cfg.MetricsToken = "scrape-token"
+	protected := Metrics(&cfg)
+	for _, tc := range []struct {
+		name   string
+		h      http.Handler
+		token  string
+		status int
+	}{
+		{"without a token configured", open, "", http.StatusOK},
+		{"with the token", protected, "scrape-token", http.StatusOK},
+		{"without the token", protected, "", http.StatusUnauthorized},
+		{"with another token", protected, "scrape", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := request(tc.h, "GET", "/metrics", tc.token, "")
+			if rec.Code != tc.status {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body, tc.status)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4" {
+				t.Fatalf("Content-Type %q", got)
+			}
+			if want := `http_route_requests_total{method="GET",route="/metrics-test"} 1`; !strings.Contains(rec.Body.String(), want) {
+				t.Fatalf("no %s in %s", want, rec.Body)
+			}
+		})
+	}
+}
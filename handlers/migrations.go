@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang-backend/migrations"
+	"golang-backend/models"
+)
+
+// MigrationRunsResponse lists startup migration reports
+type MigrationRunsResponse struct {
+	Runs []models.MigrationRun `json:"runs"`
+}
+
+// @Summary Startup migration reports
+// @Description The 50 most recent startup migration runs that found documents to migrate, with counts and the documents that failed. Failed documents are retried on the next start (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} MigrationRunsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/migrations [get]
+func ListMigrationRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	runs, err := migrations.List(r.Context(), 50)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch migration reports"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(MigrationRunsResponse{Runs: runs})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// migrationRuns stores a report of each named migration, an hour apart
+// and oldest first
+func migrationRuns(names ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		started := clock.Now().Add(-time.Duration(len(names)) * time.Hour)
+		for i, name := range names {
+			at := started.Add(time.Duration(i) * time.Hour)
+			run := models.MigrationRun{Name: name, Host: "api-1", StartedAt: at, FinishedAt: at.Add(time.Second), Scanned: 10, Migrated: 9, Failed: 1}
+			if _, err := database.DB.Collection("migration_runs").InsertOne(context.Background(), run); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestListMigrationRuns(t *testing.T) {
+	runs := func(names ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp MigrationRunsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if len(resp.Runs) != len(names) {
+				t.Fatalf("got %+v, want %v", resp.Runs, names)
+			}
+			for i, name := range names {
+				if resp.Runs[i].Name != name {
+					t.Fatalf("got %+v, want %v, newest first", resp.Runs, names)
+				}
+			}
+		}
+	}
+	runCases(t, http.HandlerFunc(ListMigrationRuns), []handlerCase{
+		{name: "newest first", as: "admin", method: "GET", target: "/admin/migrations", setup: migrationRuns("legacy_email_hash", "org_keys"), status: http.StatusOK, check: runs("org_keys", "legacy_email_hash")},
+		{name: "none", as: "admin", method: "GET", target: "/admin/migrations", status: http.StatusOK, check: runs()},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/migrations", setup: dbDown("migration_runs"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/namefilter"
+	"golang-backend/utils"
+)
+
+// maxDisplayNameLength bounds display names in characters
+const maxDisplayNameLength = 50
+
+// AddNameFilterTermRequest represents the request for adding a filtered term
+type AddNameFilterTermRequest struct {
+	Term string `json:"term" example:"badword"`
+	Kind string `json:"kind" example:"profanity"`
+}
+
+// ListNameFilterTermsResponse represents the built-in and managed terms
+type ListNameFilterTermsResponse struct {
+	Terms []models.NameFilterTerm `json:"terms"`
+}
+
+// checkDisplayName trims a display name and runs it through the name filter.
+// It returns the name to store, or a status and message for the client.
+func checkDisplayName(ctx context.Context, name string) (string, int, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", http.StatusOK, ""
+	}
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		return "", http.StatusBadRequest, "Display name is too long"
+	}
+
+	if err := namefilter.Check(ctx, name); err == namefilter.ErrNameRejected {
+		return "", http.StatusBadRequest, "Display name is not allowed"
+	} else if err != nil {
+		return "", http.StatusInternalServerError, "Failed to check display name"
+	}
+	return name, http.StatusOK, ""
+}
+
+// @Summary List name filter terms
+// @Description List the built-in reserved words and the admin-managed reserved and profanity terms that display and organization names may not use (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListNameFilterTermsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/name-filter [get]
+func ListNameFilterTerms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	terms, err := namefilter.Reload(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch terms"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListNameFilterTermsResponse{Terms: terms})
+}
+
+// @Summary Add a name filter term
+// @Description Add a reserved word (blocks names equal to it) or a profanity term (blocks names containing it). Matching ignores case, accents, look-alike characters and digit substitutions (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body AddNameFilterTermRequest true "Term"
+// @Security BearerAuth
+// @Success 201 {object} models.NameFilterTerm
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/name-filter [post]
+func AddNameFilterTerm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req AddNameFilterTermRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	req.Term = strings.TrimSpace(req.Term)
+	if namefilter.Normalize(req.Term) == "" {
+		http.Error(w, `{"error": "Term must contain letters or digits"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Kind != models.TermReserved && req.Kind != models.TermProfanity {
+		http.Error(w, `{"error": "Invalid kind. Must be 'reserved' or 'profanity'"}`, http.StatusBadRequest)
+		return
+	}
+
+	term, err := namefilter.Add(r.Context(), req.Term, req.Kind, audit.ActorID(r))
+	if err == namefilter.ErrDuplicateTerm {
+		http.Error(w, `{"error": "Term already listed"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to add term"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionNameFilterAdd, term.ID.Hex(), nil, bson.M{"term": term.Term, "kind": term.Kind}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit name filter term %s: %v", term.ID.Hex(), err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(term)
+}
+
+// @Summary Remove a name filter term
+// @Description Remove an admin-managed term; built-in reserved words cannot be removed (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Term ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/name-filter/{id} [delete]
+func RemoveNameFilterTerm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid term ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	term, err := namefilter.Remove(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, `{"error": "Term not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to remove term"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionNameFilterRemove, id.Hex(), bson.M{"term": term.Term, "kind": term.Kind}, nil); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit name filter term %s: %v", id.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Term removed"})
+}
+
+// @Summary Recheck display names
+// @Description Run every user's display name against the current name filter, flagging users whose name is no longer allowed and clearing the flag where it now passes (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} namefilter.RecheckResult
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/name-filter/recheck [post]
+func RecheckDisplayNames(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, err := namefilter.Recheck(r.Context())
+	if err != nil {
+		correlation.Errorf(r.Context(), "Display name recheck failed: %v", err)
+		http.Error(w, `{"error": "Failed to recheck display names"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.Flagged > 0 || result.Cleared > 0 {
+		cache.Invalidate(cache.TagUsers)
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/namefilter"
+)
+
+// nameFilter lists the managed terms, as kind:term, on top of the built-in
+// ones; {term} is the last
+func nameFilter(terms ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		ctx := context.Background()
+		// The filter caches the terms of the previous test's database
+		if _, err := namefilter.Reload(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range terms {
+			kind, term, _ := strings.Cut(entry, ":")
+			added, err := namefilter.Add(ctx, term, kind, f.admin.user.ID.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.set("term", added.ID.Hex())
+		}
+	}
+}
+
+func TestCheckDisplayName(t *testing.T) {
+	f := newFixture(t)
+	nameFilter("profanity:darn", "reserved:acme")(t, f)
+	for _, tc := range []struct {
+		name, want string
+		status     int
+	}{
+		{"  Ada Lovelace ", "Ada Lovelace", http.StatusOK},
+		{"   ", "", http.StatusOK},
+		{strings.Repeat("é", 50), strings.Repeat("é", 50), http.StatusOK},
+		{strings.Repeat("é", 51), "", http.StatusBadRequest},
+		{"Admin", "", http.StatusBadRequest},
+		{"ACME", "", http.StatusBadRequest},
+		{"Acme Fan", "Acme Fan", http.StatusOK},
+		{"dárn it", "", http.StatusBadRequest},
+		{"D4RN", "", http.StatusBadRequest},
+	} {
+		name, status, _ := checkDisplayName(context.Background(), tc.name)
+		if name != tc.want || status != tc.status {
+			t.Errorf("%q: got %q %d, want %q %d", tc.name, name, status, tc.want, tc.status)
+		}
+	}
+}
+
+func TestListNameFilterTerms(t *testing.T) {
+	runCases(t, http.HandlerFunc(ListNameFilterTerms), []handlerCase{
+		{
+			name: "built-in and managed", as: "admin", method: "GET", target: "/admin/name-filter",
+			setup:  nameFilter("reserved:acme", "profanity:darn"),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ListNameFilterTermsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				n := len(resp.Terms)
+				if n < 3 || !resp.Terms[0].BuiltIn || resp.Terms[n-2].Term != "acme" || resp.Terms[n-1].Term != "darn" || resp.Terms[n-1].BuiltIn {
+					t.Fatalf("got %+v", resp.Terms)
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/name-filter", setup: dbDown("name_filter_terms"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestAddNameFilterTerm(t *testing.T) {
+	runCases(t, http.HandlerFunc(AddNameFilterTerm), []handlerCase{
+		{
+			name: "added", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"  darn ","kind":"profanity"}`,
+			setup:  nameFilter(),
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var term models.NameFilterTerm
+				json.Unmarshal(rec.Body.Bytes(), &term)
+				if term.Term != "darn" || term.Kind != models.TermProfanity || term.CreatedBy != f.admin.user.ID.Hex() {
+					t.Fatalf("got %+v", term)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionNameFilterAdd, "target_id": term.ID.Hex(), "after.term": "darn"}); n != 1 {
+					t.Fatal("term not audited")
+				}
+				if err := namefilter.Check(context.Background(), "Darnell"); err != namefilter.ErrNameRejected {
+					t.Fatalf("filter not reloaded: %v", err)
+				}
+			},
+		},
+		{name: "same term of another kind", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"darn","kind":"reserved"}`, setup: nameFilter("profanity:darn"), status: http.StatusCreated},
+		{name: "duplicate", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"DÁRN","kind":"profanity"}`, setup: nameFilter("profanity:darn"), status: http.StatusConflict},
+		{name: "built-in duplicate", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"Admin","kind":"reserved"}`, setup: nameFilter(), status: http.StatusConflict},
+		{name: "no letters", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"-- ","kind":"profanity"}`, setup: nameFilter(), status: http.StatusBadRequest},
+		{name: "invalid kind", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"darn","kind":"rude"}`, setup: nameFilter(), status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":1}`, setup: nameFilter(), status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/name-filter", body: `{"term":"darn","kind":"profanity"}`, setup: setups(nameFilter(), dbDown("name_filter_terms")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRemoveNameFilterTerm(t *testing.T) {
+	h := route("/admin/name-filter/{id}", http.HandlerFunc(RemoveNameFilterTerm))
+	runCases(t, h, []handlerCase{
+		{
+			name: "removed", as: "admin", method: "DELETE", target: "/admin/name-filter/{term}",
+			setup:  nameFilter("profanity:darn"),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("name_filter_terms", bson.M{}); n != 0 {
+					t.Fatal("term kept")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionNameFilterRemove, "target_id": f.vars["term"], "before.term": "darn"}); n != 1 {
+					t.Fatal("removal not audited")
+				}
+				if err := namefilter.Check(context.Background(), "Darnell"); err != nil {
+					t.Fatalf("filter not reloaded: %v", err)
+				}
+			},
+		},
+		{name: "unknown term", as: "admin", method: "DELETE", target: "/admin/name-filter/{missing}", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "DELETE", target: "/admin/name-filter/nope", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/name-filter/{term}", setup: setups(nameFilter("profanity:darn"), dbDown("name_filter_terms")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRecheckDisplayNames(t *testing.T) {
+	// The user's name turns out rude; the admin's was flagged by a removed term
+	names := func(t *testing.T, f *fixture) {
+		setUser(t, f, bson.M{"display_name": "Darnell", "name_flagged": false})
+		if _, err := database.DB.Collection("users").UpdateOne(context.Background(), bson.M{"_id": f.admin.user.ID}, bson.M{"$set": bson.M{"display_name": "Ada", "name_flagged": true}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, http.HandlerFunc(RecheckDisplayNames), []handlerCase{
+		{
+			name: "flagged and cleared", as: "admin", method: "POST", target: "/admin/name-filter/recheck",
+			setup:  setups(nameFilter("profanity:darn"), names),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var result namefilter.RecheckResult
+				json.Unmarshal(rec.Body.Bytes(), &result)
+				if result.Checked != 2 || result.Flagged != 1 || result.Cleared != 1 || len(result.FlaggedIDs) != 1 || result.FlaggedIDs[0] != f.user.user.ID.Hex() {
+					t.Fatalf("got %+v", result)
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "name_flagged": true}); n != 1 {
+					t.Fatal("user not flagged")
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.admin.user.ID, "name_flagged": false}); n != 1 {
+					t.Fatal("admin not cleared")
+				}
+			},
+		},
+		{
+			name: "no display names", as: "admin", method: "POST", target: "/admin/name-filter/recheck",
+			setup:  nameFilter("profanity:darn"),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if body := strings.TrimSpace(rec.Body.String()); body != `{"checked":0,"flagged":0,"cleared":0,"flagged_ids":[]}` {
+					t.Fatalf("got %s", body)
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/name-filter/recheck", setup: setups(names, dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/utils"
+)
+
+// NotificationCell is one channel and category of the settings matrix
+type NotificationCell struct {
+	Enabled bool `json:"enabled" example:"true"`
+	// Forced cells are security notices that cannot be muted
+	Forced bool `json:"forced" example:"false"`
+}
+
+// NotificationSettingsResponse is the effective channel × category matrix
+type NotificationSettingsResponse struct {
+	Settings map[string]map[string]NotificationCell `json:"settings"`
+}
+
+// InboxResponse lists in-app notifications
+type InboxResponse struct {
+	Notifications []models.InAppNotification `json:"notifications"`
+}
+
+// notificationMatrix fills in defaults and forced cells for a user's settings
+func notificationMatrix(settings models.NotificationSettings) NotificationSettingsResponse {
+	resp := NotificationSettingsResponse{Settings: make(map[string]map[string]NotificationCell)}
+	for _, channel := range models.NotificationChannels {
+		resp.Settings[channel] = make(map[string]NotificationCell)
+		for _, category := range models.NotificationCategories {
+			_, forced := models.NotificationDefault(channel, category)
+			resp.Settings[channel][category] = NotificationCell{Enabled: settings.Allows(channel, category), Forced: forced}
+		}
+	}
+	return resp
+}
+
+// @Summary Get notification settings
+// @Description The current user's notification settings: which categories (security, product, marketing) they receive on each channel (email, push, in_app). Security notices by email and in-app are always on
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} NotificationSettingsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/notifications/settings [get]
+func GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, _, err := repository.FindUser(r.Context(), bson.M{"_id": userID}, repository.Fields("notifications"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(notificationMatrix(user.Notifications))
+}
+
+// @Summary Update notification settings
+// @Description Mute or enable notification categories per channel, e.g. {"email": {"marketing": true}, "push": {"product": false}}. Cells not named keep their value; forced security cells cannot be turned off
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body models.NotificationSettings true "Channel to category to enabled"
+// @Security BearerAuth
+// @Success 200 {object} NotificationSettingsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/notifications/settings [put]
+func UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.NotificationSettings
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || len(req) == 0 {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	set := bson.M{"updated_at": clock.Now()}
+	for channel, categories := range req {
+		if !slices.Contains(models.NotificationChannels, channel) {
+			http.Error(w, `{"error": "Unknown notification channel `+channel+`"}`, http.StatusBadRequest)
+			return
+		}
+		for category, enabled := range categories {
+			if !slices.Contains(models.NotificationCategories, category) {
+				http.Error(w, `{"error": "Unknown notification category `+category+`"}`, http.StatusBadRequest)
+				return
+			}
+			if _, forced := models.NotificationDefault(channel, category); forced && !enabled {
+				http.Error(w, `{"error": "`+category+` notifications by `+channel+` cannot be muted"}`, http.StatusBadRequest)
+				return
+			}
+			set["notifications."+channel+"."+category] = enabled
+		}
+	}
+
+	ctx := r.Context()
+	collection, err := repository.LocateUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var user models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"notifications": 1})
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": userID}, bson.M{"$set": set}, opts).Decode(&user)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update notification settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(notificationMatrix(user.Notifications))
+}
+
+// @Summary List in-app notifications
+// @Description The current user's most recent in-app notifications, newest first
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} InboxResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/notifications [get]
+func ListNotifications(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	notifications, err := notifier.Inbox(r.Context(), userID, 50)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch notifications"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(InboxResponse{Notifications: notifications})
+}
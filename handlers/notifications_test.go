@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/notifier"
+)
+
+// notificationCells checks cells of the settings matrix, as channel.category
+// to enabled; every cell must be present
+func notificationCells(want map[string]bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp NotificationSettingsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		for _, channel := range models.NotificationChannels {
+			for _, category := range models.NotificationCategories {
+				cell, ok := resp.Settings[channel][category]
+				if !ok {
+					t.Fatalf("no %s %s cell in %s", channel, category, rec.Body)
+				}
+				if enabled, ok := want[channel+"."+category]; ok && cell.Enabled != enabled {
+					t.Fatalf("%s %s: got %+v, want enabled %v", channel, category, cell, enabled)
+				}
+			}
+		}
+		if cell := resp.Settings[models.ChannelEmail][models.CategorySecurity]; !cell.Forced {
+			t.Fatalf("security email not forced: %+v", cell)
+		}
+	}
+}
+
+func TestGetNotificationSettings(t *testing.T) {
+	runCases(t, http.HandlerFunc(GetNotificationSettings), []handlerCase{
+		{
+			name: "defaults", as: "user", method: "GET", target: "/user/notifications/settings",
+			status: http.StatusOK,
+			check:  notificationCells(map[string]bool{"email.security": true, "email.product": true, "email.marketing": false, "push.security": true}),
+		},
+		{
+			name: "chosen", as: "user", method: "GET", target: "/user/notifications/settings",
+			setup: func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"notifications": bson.M{"email": bson.M{"product": false, "marketing": true}, "push": bson.M{"security": false}}})
+			},
+			status: http.StatusOK,
+			check:  notificationCells(map[string]bool{"email.product": false, "email.marketing": true, "push.security": false, "in_app.product": true}),
+		},
+		{name: "deleted account", as: "user", method: "GET", target: "/user/notifications/settings", setup: userDeleted, status: http.StatusNotFound},
+		{name: "database down", as: "user", method: "GET", target: "/user/notifications/settings", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUpdateNotificationSettings(t *testing.T) {
+	runCases(t, http.HandlerFunc(UpdateNotificationSettings), []handlerCase{
+		{
+			name: "updated", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"email":{"marketing":true,"security":true},"in_app":{"product":false}}`,
+			setup: func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"notifications": bson.M{"push": bson.M{"product": false}}})
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				notificationCells(map[string]bool{"email.marketing": true, "in_app.product": false, "push.product": false, "email.product": true})(t, f, rec)
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "notifications.email.marketing": true, "notifications.push.product": false}); n != 1 {
+					t.Fatal("settings not merged")
+				}
+			},
+		},
+		{name: "muting a security notice", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"in_app":{"security":false}}`, status: http.StatusBadRequest},
+		{name: "muting security push", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"push":{"security":false}}`, status: http.StatusOK, check: notificationCells(map[string]bool{"push.security": false})},
+		{name: "unknown channel", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"sms":{"product":true}}`, status: http.StatusBadRequest},
+		{name: "unknown category", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"email":{"billing":true}}`, status: http.StatusBadRequest},
+		{name: "empty", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"email":true}`, status: http.StatusBadRequest},
+		{name: "deleted account", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"email":{"product":false}}`, setup: userDeleted, status: http.StatusNotFound},
+		{name: "database down", as: "user", method: "PUT", target: "/user/notifications/settings", body: `{"email":{"product":false}}`, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListNotifications(t *testing.T) {
+	delivered := func(t *testing.T, f *fixture) {
+		for _, msg := range []notifier.UserMessage{
+			{Type: "login.new_device", Category: models.CategorySecurity, Title: "New sign-in"},
+			{Type: "product.update", Category: models.CategoryProduct, Title: "What's new"},
+			{Type: "promo", Category: models.CategoryMarketing, Title: "Half price"},
+		} {
+			if err := notifier.Deliver(context.Background(), testConfig, f.user.user, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := notifier.Deliver(context.Background(), testConfig, f.admin.user, notifier.UserMessage{Type: "product.update", Category: models.CategoryProduct, Title: "What's new"}); err != nil {
+			t.Fatal(err)
+		}
+		// The sign-in notice came first
+		earlier := bson.M{"$set": bson.M{"created_at": clock.Now().Add(-time.Hour)}}
+		if _, err := database.DB.Collection("user_notifications").UpdateOne(context.Background(), bson.M{"type": "login.new_device"}, earlier); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCases(t, http.HandlerFunc(ListNotifications), []handlerCase{
+		{
+			name: "own inbox", as: "user", method: "GET", target: "/user/notifications",
+			setup:  delivered,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp InboxResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				// Marketing is opt-in, so the promotion never reached the inbox
+				if len(resp.Notifications) != 2 || resp.Notifications[0].Title != "What's new" || resp.Notifications[1].Title != "New sign-in" {
+					t.Fatalf("got %+v, want newest first", resp.Notifications)
+				}
+			},
+		},
+		{
+			name: "empty", as: "user", method: "GET", target: "/user/notifications",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if body := rec.Body.String(); body != "{\"notifications\":[]}\n" {
+					t.Fatalf("got %s", body)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "GET", target: "/user/notifications", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "GET", target: "/user/notifications", setup: dbDown("user_notifications"), status: http.StatusInternalServerError},
+	})
+}
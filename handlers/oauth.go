@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/emailcheck"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/oauth"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// signupFields are the query parameters of /auth/{provider} kept for the
+// callback, in case the sign-in creates an account
+var signupFields = []string{"accepted_terms_version", "date_of_birth", "region", "locale", "invite_code"}
+
+// OAuthProvidersResponse lists the configured social sign-in providers
+type OAuthProvidersResponse struct {
+	Providers []string `json:"providers" example:"github,google"`
+}
+
+// OAuthProviders lists the social sign-in providers
+// @Summary List social sign-in providers
+// @Description The identity providers users can sign in with at GET /auth/{provider}
+// @Tags auth
+// @Produce json
+// @Success 200 {object} OAuthProvidersResponse
+// @Router /auth/providers [get]
+func OAuthProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OAuthProvidersResponse{Providers: oauth.Providers()})
+}
+
+// OAuthStart handles the start of a social sign-in
+// @Summary Start social sign-in
+// @Description Redirect to the identity provider's sign-in page. Users who have no account yet are registered on the callback, so first-time sign-ins must pass accepted_terms_version and date_of_birth as for POST /register, and invite_code with INVITE_ONLY
+// @Tags auth
+// @Param provider path string true "Identity provider" example(github)
+// @Param accepted_terms_version query string false "Accepted terms version, for new accounts"
+// @Param date_of_birth query string false "Date of birth, YYYY-MM-DD, for new accounts"
+// @Param region query string false "Region, for new accounts"
+// @Param locale query string false "Locale, for new accounts"
+// @Param invite_code query string false "Invitation code, for new accounts"
+// @Success 302 {string} string "Redirect to the provider"
+// @Failure 404 {string} string "Unknown identity provider"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/{provider} [get]
+func OAuthStart(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := map[string]string{"org_id": tenant.OrgID(r)}
+	for _, field := range signupFields {
+		if v := query.Get(field); v != "" {
+			data[field] = v
+		}
+	}
+
+	authURL, err := oauth.Begin(r.Context(), mux.Vars(r)["provider"], data)
+	if errors.Is(err, oauth.ErrUnknownProvider) {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthCallback handles the provider's redirect back after a social sign-in
+// @Summary Complete social sign-in
+// @Description Exchange the provider's authorization code and sign in the linked user. An account with the provider's verified email address is linked on first use; otherwise a new account is registered. Returns the same session as POST /login
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Identity provider" example(github)
+// @Param state query string true "State from the sign-in redirect"
+// @Param code query string true "Authorization code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Missing consent for a new account"
+// @Failure 401 {string} string "Sign-in failed"
+// @Failure 403 {string} string "Account suspended"
+// @Failure 404 {string} string "Unknown identity provider"
+// @Failure 409 {string} string "Email address not verified by the provider"
+// @Failure 502 {string} string "Identity provider unavailable"
+// @Router /auth/{provider}/callback [get]
+func OAuthCallback(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		provider := mux.Vars(r)["provider"]
+		if query.Get("error") != "" || query.Get("state") == "" || query.Get("code") == "" {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", provider+" sign-in not completed")
+			http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		identity, data, err := oauth.Complete(ctx, provider, query.Get("state"), query.Get("code"))
+		switch {
+		case errors.Is(err, oauth.ErrUnknownProvider):
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		case errors.Is(err, oauth.ErrInvalidState), errors.Is(err, oauth.ErrExchange):
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", provider+": "+err.Error())
+			http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+			return
+		case err != nil:
+			correlation.Errorf(ctx, "oauth: %s sign-in failed: %v", provider, err)
+			http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		user, status, msg := identityUser(r, cfg, identity, data)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+
+		// The sign-in must end on the domain it began on
+		if user.OrgID != data["org_id"] {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, user.ID.Hex(), "not a member of the tenant")
+			recordLogin(r, user.ID.Hex(), provider, false, "not a member of the tenant")
+			http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, user.ID.Hex(), "account suspended")
+			recordLogin(r, user.ID.Hex(), provider, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, user.ID.Hex(), provider, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
+
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", false)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		correlation.SetUser(ctx, user.ID.Hex())
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, user.ID.Hex(), "via "+provider)
+		recordLogin(r, user.ID.Hex(), provider, true, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}
+}
+
+// identityUser finds the user an identity is linked to. Failing that, an
+// account with the same verified address is linked, or a new one registered
+// with the consent collected when the sign-in began.
+func identityUser(r *http.Request, cfg *config.Config, identity *oauth.Identity, data map[string]string) (*models.User, int, string) {
+	ctx := r.Context()
+	linked := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}}}
+	user, _, err := repository.FindUser(ctx, linked, repository.CredentialFields)
+	if err == nil {
+		return user, http.StatusOK, ""
+	} else if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+
+	// An unverified address could belong to someone else
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, http.StatusConflict, "Email address not verified by the provider"
+	}
+	email := utils.NormalizeEmail(identity.Email)
+	emailHash := utils.EmailIndex(email, cfg.EmailFoldAliases)
+	now := clock.Now()
+	link := models.LinkedIdentity{Provider: identity.Provider, Subject: identity.Subject, LinkedAt: now}
+
+	user, collection, err := repository.FindUserByEmailHash(ctx, emailHash, repository.CredentialFields)
+	if err == nil {
+		// The provider has just proven the address, too
+		update := bson.M{"$push": bson.M{"identities": link}, "$set": bson.M{"updated_at": now}}
+		if user.EmailUnverified {
+			update["$set"].(bson.M)["email_verified_at"] = now
+			update["$unset"] = bson.M{"email_unverified": ""}
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+			return nil, http.StatusInternalServerError, "Failed to link account"
+		}
+		cache.Invalidate(cache.TagUsers)
+		return user, http.StatusOK, ""
+	} else if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+
+	return registerIdentity(r, cfg, email, emailHash, identity, link, data)
+}
+
+// registerIdentity creates an account for an identity no user has yet. The
+// account has no password until the user sets one through a password reset.
+func registerIdentity(r *http.Request, cfg *config.Config, email, emailHash string, identity *oauth.Identity, link models.LinkedIdentity, data map[string]string) (*models.User, int, string) {
+	ctx := context.Background()
+	consent := RegisterRequest{
+		AcceptedTermsVersion: data["accepted_terms_version"],
+		DateOfBirth:          data["date_of_birth"],
+		Region:               data["region"],
+	}
+	minAge, status, msg := checkRegistrationConsent(cfg, consent)
+	if status != http.StatusOK {
+		return nil, status, msg
+	}
+	invitation, status, msg := checkInvitation(ctx, cfg, data["invite_code"])
+	if status != http.StatusOK {
+		return nil, status, msg
+	}
+	role := "user"
+	if invitation != nil {
+		role = invitation.Role
+	}
+	if err := emailcheck.Check(ctx, email); err != nil {
+		return nil, http.StatusBadRequest, emailcheck.Describe(err)
+	}
+	// Provider names are a convenience; one the filter rejects is dropped
+	displayName, status, _ := checkDisplayName(ctx, identity.Name)
+	if status == http.StatusInternalServerError {
+		return nil, status, "Failed to check display name"
+	}
+
+	orgID := data["org_id"]
+	if status, err := repository.OrgStatus(ctx, orgID); err != nil {
+		return nil, http.StatusInternalServerError, "Database error"
+	} else if status != models.OrgActive {
+		return nil, http.StatusForbidden, "Organization is not accepting registrations"
+	}
+	collection, err := repository.UsersForOrg(ctx, orgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+
+	encryptedEmail, err := keys.Encrypt(ctx, cfg, orgID, email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to encrypt data"
+	}
+	encryptedDOB, err := keys.Encrypt(ctx, cfg, orgID, consent.DateOfBirth)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to encrypt data"
+	}
+
+	now := link.LinkedAt
+	user := models.User{
+		ID:              clock.NewID(),
+		EmailHash:       emailHash,
+		Email:           encryptedEmail,
+		Role:            role,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		DateOfBirth:     encryptedDOB,
+		DisplayName:     displayName,
+		Locale:          requestLocale(r, data["locale"]),
+		OrgID:           orgID,
+		TermsVersion:    consent.AcceptedTermsVersion,
+		TermsAcceptedAt: &now,
+		EmailVerifiedAt: &now,
+		Identities:      []models.LinkedIdentity{link},
+		Waitlist:        gateSignup(r, email, invitation != nil, now),
+	}
+	if invitation != nil {
+		user.InvitationID = &invitation.ID
+	}
+	if status, msg := redeemInvitation(ctx, data["invite_code"], user.ID); status != http.StatusOK {
+		return nil, status, msg
+	}
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		releaseInvitation(ctx, user)
+		return nil, http.StatusInternalServerError, "Failed to create user"
+	}
+	if err := recordConsent(r, user.ID, consent.AcceptedTermsVersion, consent.Region, minAge, now); err != nil {
+		collection.DeleteOne(ctx, bson.M{"_id": user.ID})
+		releaseInvitation(ctx, user)
+		return nil, http.StatusInternalServerError, "Failed to record consent"
+	}
+	cache.Invalidate(cache.TagUsers)
+	if user.Waitlist == nil {
+		sendWelcomeEmail(cfg, email, user)
+	}
+	return &user, http.StatusOK, ""
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/oauth"
+	"golang-backend/security"
+	"golang-backend/utils"
+)
+
+// testProvider vouches for the identity its authorization code names
+type testProvider struct{}
+
+// providerIdentities are the identities of testProvider by code
+var providerIdentities = map[string]oauth.Identity{
+	"linked":     {Subject: "s-linked", Email: "someone@example.com", EmailVerified: true},
+	"verified":   {Subject: "s-verified", Email: "User@Example.com", EmailVerified: true},
+	"unverified": {Subject: "s-unverified", Email: "user@example.com"},
+	"new":        {Subject: "s-new", Email: "new@example.com", EmailVerified: true, Name: "Ada"},
+	"rude":       {Subject: "s-rude", Email: "new@example.com", EmailVerified: true, Name: "Admin"},
+}
+
+func (testProvider) Name() string { return "test" }
+
+func (testProvider) AuthURL(state, verifier string) string {
+	return "https://idp.example/authorize?state=" + url.QueryEscape(state)
+}
+
+func (testProvider) Exchange(ctx context.Context, code, verifier string) (*oauth.Identity, error) {
+	switch code {
+	case "rejected":
+		return nil, oauth.ErrExchange
+	case "down":
+		return nil, errors.New("connection refused")
+	}
+	identity := providerIdentities[code]
+	return &identity, nil
+}
+
+// oauthBegun starts a sign-in with testProvider on orgID's domain, keeping
+// the query of signup fields, and stores its state as {state}
+func oauthBegun(orgID, signup string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		data := map[string]string{"org_id": orgID}
+		query, _ := url.ParseQuery(signup)
+		for field := range query {
+			data[field] = query.Get(field)
+		}
+		authURL, err := oauth.Begin(context.Background(), "test", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _ := url.Parse(authURL)
+		f.set("state", url.QueryEscape(parsed.Query().Get("state")))
+	}
+}
+
+// linkedIdentity links the user to their testProvider account
+func linkedIdentity(t *testing.T, f *fixture) {
+	setUser(t, f, bson.M{"identities": bson.A{bson.M{"provider": "test", "subject": "s-linked"}}})
+}
+
+// userByEmail returns the user with an email address
+func userByEmail(t *testing.T, email string) *models.User {
+	t.Helper()
+	var user models.User
+	filter := bson.M{"email_hash": utils.EmailIndex(email, testConfig.EmailFoldAliases)}
+	if err := database.DB.Collection("users").FindOne(context.Background(), filter).Decode(&user); err != nil {
+		t.Fatalf("%s: %v", email, err)
+	}
+	return &user
+}
+
+// signedInAs checks the callback issued a session to the user with email
+func signedInAs(email string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp LoginResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Token == "" || resp.RefreshToken == "" || resp.Role != "user" {
+			t.Fatalf("got %s", rec.Body)
+		}
+		user := userByEmail(t, email)
+		if n := f.srv.Count("login_events", bson.M{"user_id": user.ID, "method": "test", "outcome": "success"}); n != 1 {
+			t.Fatal("sign-in not recorded")
+		}
+		if n := f.srv.Count("security_events", bson.M{"type": security.EventLoginSuccess, "user_id": user.ID.Hex()}); n != 1 {
+			t.Fatal("sign-in not emitted")
+		}
+	}
+}
+
+func TestOAuthProviders(t *testing.T) {
+	oauth.Register(testProvider{})
+	runCases(t, http.HandlerFunc(OAuthProviders), []handlerCase{
+		{
+			name: "registered", method: "GET", target: "/auth/providers",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp OAuthProvidersResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if strings.Join(resp.Providers, ",") != strings.Join(oauth.Providers(), ",") || !strings.Contains(rec.Body.String(), `"test"`) {
+					t.Fatalf("got %s", rec.Body)
+				}
+			},
+		},
+	})
+}
+
+func TestOAuthStart(t *testing.T) {
+	oauth.Register(testProvider{})
+	h := route("/auth/{provider}", http.HandlerFunc(OAuthStart))
+	runCases(t, h, []handlerCase{
+		{
+			name: "redirected", method: "GET", target: "/auth/test?accepted_terms_version=1&date_of_birth=1990-04-21&role=admin",
+			status: http.StatusFound,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if location := rec.Header().Get("Location"); !strings.HasPrefix(location, "https://idp.example/authorize?state=") {
+					t.Fatalf("Location %q", location)
+				}
+				kept := bson.M{"provider": "test", "data.org_id": "", "data.accepted_terms_version": "1", "data.date_of_birth": "1990-04-21", "data.role": bson.M{"$exists": false}}
+				if n := f.srv.Count("oauth_states", kept); n != 1 {
+					t.Fatal("signup fields not kept")
+				}
+			},
+		},
+		{name: "unknown provider", method: "GET", target: "/auth/myspace", status: http.StatusNotFound},
+		{name: "database down", method: "GET", target: "/auth/test", setup: dbDown("oauth_states"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestOAuthCallback(t *testing.T) {
+	oauth.Register(testProvider{})
+	h := route("/auth/{provider}/callback", OAuthCallback(testConfig))
+	signup := "accepted_terms_version=1&date_of_birth=1990-04-21&locale=fr"
+	callback := func(code string) string {
+		return "/auth/test/callback?state={state}&code=" + code
+	}
+	runCases(t, h, []handlerCase{
+		{name: "linked identity", method: "GET", target: callback("linked"), setup: setups(linkedIdentity, oauthBegun("", "")), status: http.StatusOK, check: signedInAs("user@example.com")},
+		{
+			name: "same verified address", method: "GET", target: callback("verified"),
+			setup: setups(func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"email_unverified": true})
+			}, oauthBegun("", "")),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				signedInAs("user@example.com")(t, f, rec)
+				linked := bson.M{"_id": f.user.user.ID, "identities.subject": "s-verified", "email_verified_at": bson.M{"$exists": true}, "email_unverified": bson.M{"$exists": false}}
+				if n := f.srv.Count("users", linked); n != 1 {
+					t.Fatal("identity not linked")
+				}
+			},
+		},
+		{
+			name: "new account", method: "GET", target: callback("new"),
+			setup:  oauthBegun("", signup),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				signedInAs("new@example.com")(t, f, rec)
+				user := userByEmail(t, "new@example.com")
+				if user.DisplayName != "Ada" || user.Locale != "fr" || user.Password != "" || user.EmailVerifiedAt == nil || len(user.Identities) != 1 || user.Identities[0].Subject != "s-new" {
+					t.Fatalf("got %+v", user)
+				}
+				if n := f.srv.Count("consents", bson.M{"user_id": user.ID, "terms_version": "1"}); n != 1 {
+					t.Fatal("consent not recorded")
+				}
+			},
+		},
+		{
+			name: "rejected provider name", method: "GET", target: callback("rude"),
+			setup:  setups(nameFilter(), oauthBegun("", signup)),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"email_hash": userByEmail(t, "new@example.com").EmailHash, "display_name": bson.M{"$exists": false}}); n != 1 {
+					t.Fatal("provider name kept")
+				}
+			},
+		},
+		{name: "new account without consent", method: "GET", target: callback("new"), setup: oauthBegun("", ""), status: http.StatusBadRequest},
+		{name: "unverified address", method: "GET", target: callback("unverified"), setup: oauthBegun("", signup), status: http.StatusConflict},
+		{name: "suspended", method: "GET", target: callback("linked"), setup: setups(linkedIdentity, oauthBegun("", ""), func(t *testing.T, f *fixture) {
+			setUser(t, f, bson.M{"suspended": true})
+		}), status: http.StatusForbidden},
+		{
+			name: "member of another tenant", method: "GET", target: callback("linked"),
+			setup:  setups(linkedIdentity, inOrg, oauthBegun("", "")),
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("login_events", bson.M{"user_id": f.user.user.ID, "outcome": "failure", "reason": "not a member of the tenant"}); n != 1 {
+					t.Fatal("failure not recorded")
+				}
+			},
+		},
+		{name: "suspended organization", method: "GET", target: callback("linked"), setup: setups(linkedIdentity, inOrg, orgStatus(models.OrgSuspended), oauthBegun(testOrg, "")), status: http.StatusForbidden},
+		{name: "state used twice", method: "GET", target: callback("linked"), setup: setups(linkedIdentity, oauthBegun("", ""), func(t *testing.T, f *fixture) {
+			request(h, "GET", "/auth/test/callback?state="+f.vars["state"]+"&code=linked", "", "")
+		}), status: http.StatusUnauthorized},
+		{name: "unknown state", method: "GET", target: "/auth/test/callback?state=forged&code=linked", status: http.StatusUnauthorized},
+		{name: "code rejected", method: "GET", target: callback("rejected"), setup: oauthBegun("", ""), status: http.StatusUnauthorized},
+		{name: "provider down", method: "GET", target: callback("down"), setup: oauthBegun("", ""), status: http.StatusBadGateway},
+		{name: "denied at the provider", method: "GET", target: "/auth/test/callback?error=access_denied&state={state}", setup: oauthBegun("", ""), status: http.StatusUnauthorized},
+		{name: "no code", method: "GET", target: "/auth/test/callback?state={state}", setup: oauthBegun("", ""), status: http.StatusUnauthorized},
+		{name: "unknown provider", method: "GET", target: "/auth/myspace/callback?state=s&code=c", status: http.StatusNotFound},
+		{name: "database down", method: "GET", target: callback("linked"), setup: setups(linkedIdentity, oauthBegun("", ""), dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/correlation"
+	"golang-backend/onboarding"
+)
+
+// @Summary Onboarding checklist
+// @Description The onboarding steps of the current user, such as verifying the email address, completing the profile and creating a first API key, with the completion percentage. Steps and their weights are configured with ONBOARDING_STEPS
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} onboarding.Checklist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/onboarding [get]
+func GetOnboarding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	checklist, err := onboarding.For(r.Context(), userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		correlation.Errorf(r.Context(), "Failed to compute onboarding checklist of user %s: %v", userID.Hex(), err)
+		http.Error(w, `{"error": "Failed to compute onboarding checklist"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(checklist)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/onboarding"
+)
+
+func TestGetOnboarding(t *testing.T) {
+	cfg := *testConfig
+	cfg.OnboardingSteps = []string{"email_verified", "password:2", "profile", "api_key:3", "unknown", "profile:heavy"}
+	onboarding.Init(&cfg)
+	t.Cleanup(func() { onboarding.Init(testConfig) })
+
+	checklist := func(percent int, done ...bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp onboarding.Checklist
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			ids := []string{"email_verified", "password", "profile", "api_key"}
+			if resp.Total != len(ids) || len(resp.Steps) != len(ids) || resp.Percent != percent {
+				t.Fatalf("got %+v, want %d%%", resp, percent)
+			}
+			completed := 0
+			for i, step := range resp.Steps {
+				if step.ID != ids[i] || step.Done != done[i] || step.Title == "" {
+					t.Fatalf("got %+v, want %v done", resp.Steps, done)
+				}
+				if step.Done {
+					completed++
+				}
+			}
+			if resp.Completed != completed {
+				t.Fatalf("got %d completed, want %d", resp.Completed, completed)
+			}
+		}
+	}
+	profiled := func(t *testing.T, f *fixture) {
+		setUser(t, f, bson.M{"display_name": "Ada"})
+	}
+	runCases(t, http.HandlerFunc(GetOnboarding), []handlerCase{
+		// Weights are 1, 2, 1 and 3
+		{name: "new account", as: "user", method: "GET", target: "/user/onboarding", setup: schemaReloaded, status: http.StatusOK, check: checklist(42, true, true, false, false)},
+		{name: "all done", as: "user", method: "GET", target: "/user/onboarding", setup: setups(schemaReloaded, profiled, apiKey), status: http.StatusOK, check: checklist(100, true, true, true, true)},
+		{
+			name: "unverified social account", as: "user", method: "GET", target: "/user/onboarding",
+			setup: setups(schemaReloaded, apiKey, func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"email_unverified": true, "password": ""})
+			}),
+			status: http.StatusOK, check: checklist(42, false, false, false, true),
+		},
+		{
+			name: "required custom field missing", as: "user", method: "GET", target: "/user/onboarding",
+			setup: setups(customField("team", models.FieldString), profiled, func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"custom_fields": bson.M{}})
+				if _, err := database.DB.Collection("custom_fields").UpdateOne(context.Background(), bson.M{"name": "team"}, bson.M{"$set": bson.M{"required": true}}); err != nil {
+					t.Fatal(err)
+				}
+				schemaReloaded(t, f)
+			}),
+			status: http.StatusOK, check: checklist(42, true, true, false, false),
+		},
+		{name: "deleted account", as: "user", method: "GET", target: "/user/onboarding", setup: userDeleted, status: http.StatusNotFound},
+		{name: "database down", as: "user", method: "GET", target: "/user/onboarding", setup: setups(schemaReloaded, profiled, dbDown("api_keys")), status: http.StatusInternalServerError},
+	})
+}
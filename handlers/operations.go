@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/rolegrants"
+	"golang-backend/utils"
+)
+
+// UndoOperationRequest represents the request for undoing an admin operation
+type UndoOperationRequest struct {
+	Token string `json:"token"`
+}
+
+// newUndoOperation stores an undoable operation and returns its undo token
+func newUndoOperation(cfg *config.Config, opType, actorID string, auditID primitive.ObjectID, targets []primitive.ObjectID) (*models.UndoInfo, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	op := models.Operation{
+		ID:        clock.NewID(),
+		Type:      opType,
+		ActorID:   actorID,
+		AuditID:   auditID,
+		TargetIDs: targets,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: now.Add(cfg.UndoWindow),
+		CreatedAt: now,
+	}
+
+	if _, err := database.DB.Collection("operations").InsertOne(context.Background(), op); err != nil {
+		return nil, err
+	}
+
+	return &models.UndoInfo{OperationID: op.ID.Hex(), Token: token, ExpiresAt: op.ExpiresAt}, nil
+}
+
+// @Summary Undo an admin operation
+// @Description Revert a recent delete, role change or import using the undo token returned by the operation (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Operation ID"
+// @Param request body UndoOperationRequest true "Undo token"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/operations/{id}/undo [post]
+func UndoOperation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid operation ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req UndoOperationRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Token == "" {
+		http.Error(w, `{"error": "Undo token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	collection := database.DB.Collection("operations")
+	ctx := context.Background()
+
+	var op models.Operation
+	if err := collection.FindOne(ctx, bson.M{"_id": opID}).Decode(&op); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Operation not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to fetch operation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(op.TokenHash), []byte(utils.HashToken(req.Token))) != 1 {
+		http.Error(w, `{"error": "Invalid undo token"}`, http.StatusForbidden)
+		return
+	}
+
+	// Claim the operation atomically so it can only be undone once
+	now := clock.Now()
+	filter := bson.M{"_id": opID, "undone_at": nil, "expires_at": bson.M{"$gt": now}}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"undone_at": now}})
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update operation"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, `{"error": "Undo window has expired or operation was already undone"}`, http.StatusGone)
+		return
+	}
+
+	entry, err := audit.Get(ctx, op.AuditID)
+	if err != nil {
+		http.Error(w, `{"error": "Audit snapshot not found"}`, http.StatusInternalServerError)
+		return
+	}
+
+	status, msg := revertOperation(ctx, &op, entry)
+	if status != http.StatusOK {
+		// Release the claim so the admin can retry within the window
+		collection.UpdateOne(ctx, bson.M{"_id": opID}, bson.M{"$unset": bson.M{"undone_at": ""}})
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	cache.Invalidate(cache.TagUsers)
+	audit.Record(r, audit.ActionUndo, op.ID.Hex(), nil, bson.M{"type": op.Type, "audit_id": op.AuditID})
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Operation undone successfully"})
+}
+
+// revertOperation applies the audit snapshot of an operation back to the users collection
+func revertOperation(ctx context.Context, op *models.Operation, entry *models.AuditLog) (int, string) {
+	switch op.Type {
+	case models.OperationDeleteUser:
+		if entry.Before == nil {
+			return http.StatusInternalServerError, "Audit snapshot is empty"
+		}
+		// Restore into the residency region of the user's organization
+		orgID, _ := entry.Before["org_id"].(string)
+		users, err := repository.UsersForOrg(ctx, orgID)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to resolve user region"
+		}
+		if _, err := users.InsertOne(ctx, entry.Before); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return http.StatusConflict, "User already exists"
+			}
+			return http.StatusInternalServerError, "Failed to restore user"
+		}
+
+	case models.OperationUpdateRole:
+		role, _ := entry.Before["role"].(string)
+		if role == "" || len(op.TargetIDs) == 0 {
+			return http.StatusInternalServerError, "Audit snapshot is empty"
+		}
+		users, err := repository.LocateUser(ctx, op.TargetIDs[0])
+		if err == mongo.ErrNoDocuments {
+			return http.StatusNotFound, "User not found"
+		} else if err != nil {
+			return http.StatusInternalServerError, "Failed to locate user"
+		}
+		update := bson.M{"$set": bson.M{"role": role, "updated_at": clock.Now()}, "$inc": bson.M{"role_version": 1}}
+		result, err := users.UpdateOne(ctx, bson.M{"_id": op.TargetIDs[0]}, update)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to restore role"
+		}
+		if result.MatchedCount == 0 {
+			return http.StatusNotFound, "User not found"
+		}
+		// The restored role is not temporary, whatever the undone change was
+		if err := rolegrants.Cancel(ctx, op.TargetIDs[0]); err != nil {
+			return http.StatusInternalServerError, "Failed to cancel role expiry"
+		}
+
+	case models.OperationImportUsers:
+		// Imported users may have been moved since, so remove them from every region
+		for _, db := range database.Regions {
+			if _, err := db.Collection("users").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": op.TargetIDs}}); err != nil {
+				return http.StatusInternalServerError, "Failed to remove imported users"
+			}
+		}
+
+	default:
+		return http.StatusBadRequest, "Operation cannot be undone"
+	}
+
+	return http.StatusOK, ""
+}
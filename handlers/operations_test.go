@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// undoable runs an admin request answering with an undo token and sets {op}
+// and {token} to the operation and token
+func undoable(h http.Handler, method, target, body string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		rec := f.admin.do(h, method, target, strings.ReplaceAll(body, "{user}", f.user.user.ID.Hex()))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("operation: got %d %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			Undo *models.UndoInfo `json:"undo"`
+		}
+		if json.Unmarshal(rec.Body.Bytes(), &resp); resp.Undo == nil {
+			t.Fatalf("no undo token in %s", rec.Body)
+		}
+		f.set("op", resp.Undo.OperationID)
+		f.set("token", resp.Undo.Token)
+	}
+}
+
+// importUndoable imports a user and sets {op} and {token} to the undo token of
+// the import
+func importUndoable(t *testing.T, f *fixture) {
+	importUsers("email\nnew@example.com\n")(t, f)
+	rec := f.admin.do(route("/admin/users/import/{id}", GetUserImport(testConfig)), "GET", "/admin/users/import/"+f.vars["import"], "")
+	var imp models.UserImport
+	if json.Unmarshal(rec.Body.Bytes(), &imp); imp.Undo == nil {
+		t.Fatalf("no undo token in %s", rec.Body)
+	}
+	f.set("op", imp.Undo.OperationID)
+	f.set("token", imp.Undo.Token)
+}
+
+// userDeletion deletes the user as the admin
+func userDeletion(t *testing.T, f *fixture) {
+	undoable(DeleteUser(testConfig), "POST", "/admin/users/delete", `{"user_id":"{user}"}`)(t, f)
+}
+
+// roleChange makes the user an admin as the admin
+func roleChange(t *testing.T, f *fixture) {
+	undoable(UpdateUserRole(testConfig), "PUT", "/admin/users/role", `{"user_id":"{user}","role":"admin"}`)(t, f)
+}
+
+// undoFailed checks that a failed undo left the operation undoable
+func undoFailed(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+	opID, _ := primitive.ObjectIDFromHex(f.vars["op"])
+	if n := f.srv.Count("operations", bson.M{"_id": opID, "undone_at": nil}); n != 1 {
+		t.Fatal("operation claimed by a failed undo")
+	}
+}
+
+func TestUndoOperation(t *testing.T) {
+	h := route("/admin/operations/{id}/undo", http.HandlerFunc(UndoOperation))
+	undo := `{"token":"{token}"}`
+	runCases(t, h, []handlerCase{
+		{
+			name: "restores a deleted user", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup:  userDeletion,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_hash": f.user.user.EmailHash}); n != 1 {
+					t.Fatal("user not restored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionUndo, "target_id": f.vars["op"]}); n != 1 {
+					t.Fatal("undo not audited")
+				}
+			},
+		},
+		{
+			name: "restores a role", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup:  roleChange,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "role": "user", "role_version": 2}); n != 1 {
+					t.Fatal("role not restored")
+				}
+			},
+		},
+		{
+			name: "removes imported users", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup:  importUndoable,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{}); n != 2 {
+					t.Fatalf("%d users left, want 2", n)
+				}
+			},
+		},
+		{
+			name: "undone twice", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup: setups(roleChange, func(t *testing.T, f *fixture) {
+				if rec := f.admin.do(h, "POST", "/admin/operations/"+f.vars["op"]+"/undo", `{"token":"`+f.vars["token"]+`"}`); rec.Code != http.StatusOK {
+					t.Fatalf("first undo: got %d", rec.Code)
+				}
+			}),
+			status: http.StatusGone,
+		},
+		{
+			name: "window passed", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup: setups(roleChange, func(t *testing.T, f *fixture) {
+				opID, _ := primitive.ObjectIDFromHex(f.vars["op"])
+				if _, err := database.DB.Collection("operations").UpdateOne(context.Background(), bson.M{"_id": opID}, bson.M{"$set": bson.M{"expires_at": clock.Now()}}); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusGone,
+		},
+		{
+			name: "user recreated since", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo,
+			setup: setups(userDeletion, func(t *testing.T, f *fixture) {
+				if _, err := database.DB.Collection("users").InsertOne(context.Background(), bson.M{"_id": f.user.user.ID}); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusConflict,
+			check:  undoFailed,
+		},
+		{name: "wrong token", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: `{"token":"wrong"}`, setup: roleChange, status: http.StatusForbidden, check: undoFailed},
+		{name: "missing token", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: `{}`, setup: roleChange, status: http.StatusBadRequest},
+		{name: "malformed ID", as: "admin", method: "POST", target: "/admin/operations/42/undo", body: `{"token":"x"}`, status: http.StatusBadRequest},
+		{name: "unknown operation", as: "admin", method: "POST", target: "/admin/operations/{missing}/undo", body: `{"token":"x"}`, status: http.StatusNotFound},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/operations/{missing}/undo", body: `{"token":"x"}`, status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/operations/{op}/undo", body: undo, setup: setups(roleChange, dbDown("operations")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/namefilter"
+	"golang-backend/repository"
+	"golang-backend/utils"
+)
+
+// CreateOrganizationRequest represents the request for creating an organization
+type CreateOrganizationRequest struct {
+	Name   string `json:"name" example:"Acme Inc"`
+	Region string `json:"region,omitempty" example:"eu"`
+}
+
+// ListOrganizationsResponse represents the response for listing organizations
+type ListOrganizationsResponse struct {
+	Organizations []models.Organization `json:"organizations"`
+}
+
+// AssignOrganizationRequest represents the request for moving a user into an organization
+type AssignOrganizationRequest struct {
+	OrgID string `json:"org_id"`
+}
+
+// OrgKeysResponse represents the data key versions of an organization
+type OrgKeysResponse struct {
+	Keys []models.OrgKey `json:"keys"`
+}
+
+// MoveOrganizationRegionRequest represents the request for moving a tenant to another region
+type MoveOrganizationRegionRequest struct {
+	Region string `json:"region" example:"eu"`
+}
+
+// MoveOrganizationRegionResponse reports how many users were migrated
+type MoveOrganizationRegionResponse struct {
+	Region     string `json:"region"`
+	MovedUsers int    `json:"moved_users"`
+}
+
+// DestroyOrgKeysRequest confirms crypto-shredding by repeating the organization name
+type DestroyOrgKeysRequest struct {
+	Confirm string `json:"confirm" example:"Acme Inc"`
+}
+
+// @Summary Create an organization
+// @Description Create a tenant organization with its own data encryption key (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body CreateOrganizationRequest true "Organization"
+// @Security BearerAuth
+// @Success 201 {object} models.Organization
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs [post]
+func CreateOrganization(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req CreateOrganizationRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, `{"error": "Organization name is required"}`, http.StatusBadRequest)
+			return
+		}
+		if err := namefilter.Check(r.Context(), req.Name); err == namefilter.ErrNameRejected {
+			http.Error(w, `{"error": "Organization name is not allowed"}`, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to check organization name"}`, http.StatusInternalServerError)
+			return
+		}
+
+		req.Region = strings.TrimSpace(req.Region)
+		if req.Region != "" && database.Region(req.Region) == nil {
+			http.Error(w, `{"error": "Unknown data region"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.Background()
+		now := clock.Now()
+		org := models.Organization{ID: clock.NewID(), Name: req.Name, Region: req.Region, CreatedAt: now, UpdatedAt: now}
+
+		if _, err := database.DB.Collection("organizations").InsertOne(ctx, org); err != nil {
+			http.Error(w, `{"error": "Failed to create organization"}`, http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(cache.TagOrgs)
+
+		if _, err := keys.Rotate(ctx, cfg, org.ID); err != nil {
+			http.Error(w, `{"error": "Failed to create organization data key"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionCreateOrg, org.ID.Hex(), nil, bson.M{"name": org.Name, "region": org.Region}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit creation of organization %s: %v", org.ID.Hex(), err)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(org)
+	}
+}
+
+// @Summary List organizations
+// @Description List all tenant organizations (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListOrganizationsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs [get]
+func ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx := context.Background()
+	cursor, err := database.DB.Collection("organizations").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch organizations"}`, http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	orgs := []models.Organization{}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		http.Error(w, `{"error": "Failed to decode organizations"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListOrganizationsResponse{Organizations: orgs})
+}
+
+// @Summary Assign a user to an organization
+// @Description Move a user into an organization and re-encrypt their fields with the organization's data key (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body AssignOrganizationRequest true "Organization"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/org [put]
+func AssignUserOrganization(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
+
+		var req AssignOrganizationRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.Background()
+
+		if req.OrgID != "" {
+			orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+			if err != nil {
+				http.Error(w, `{"error": "Invalid organization ID format"}`, http.StatusBadRequest)
+				return
+			}
+			count, err := database.DB.Collection("organizations").CountDocuments(ctx, bson.M{"_id": orgID})
+			if err != nil {
+				http.Error(w, `{"error": "Failed to fetch organization"}`, http.StatusInternalServerError)
+				return
+			}
+			if count == 0 {
+				http.Error(w, `{"error": "Organization not found"}`, http.StatusNotFound)
+				return
+			}
+		}
+
+		user, collection, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("email", "date_of_birth", "org_id", "custom_fields"))
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+				return
+			}
+			http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+			return
+		}
+
+		// Members cannot leave or join an archived or deleted organization
+		for _, orgID := range []string{user.OrgID, req.OrgID} {
+			if status, msg := orgWritable(ctx, orgID); status != http.StatusOK {
+				http.Error(w, `{"error": "`+msg+`"}`, status)
+				return
+			}
+		}
+
+		// Re-encrypt encrypted fields under the new organization's key
+		set := bson.M{"org_id": req.OrgID, "updated_at": clock.Now()}
+		fields := map[string]string{"email": user.Email, "date_of_birth": user.DateOfBirth}
+		for field, value := range fields {
+			if value == "" {
+				continue
+			}
+			plaintext, err := keys.Decrypt(ctx, cfg, value)
+			if err != nil {
+				http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+				return
+			}
+			if set[field], err = keys.Encrypt(ctx, cfg, req.OrgID, plaintext); err != nil {
+				http.Error(w, `{"error": "Failed to encrypt user data"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+		custom, err := customfields.Reencrypt(ctx, cfg, req.OrgID, user.CustomFields)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to encrypt user data"}`, http.StatusInternalServerError)
+			return
+		}
+		for path, value := range custom {
+			set[path] = value
+		}
+
+		// Bumping the role version makes open sessions pick up the new orgID claim
+		update := bson.M{"$set": set, "$inc": bson.M{"role_version": 1}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+			http.Error(w, `{"error": "Failed to update user"}`, http.StatusInternalServerError)
+			return
+		}
+
+		// Move the document when the organization resides in another region
+		target, err := repository.UsersForOrg(ctx, req.OrgID)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to resolve organization region"}`, http.StatusInternalServerError)
+			return
+		}
+		if err := repository.MoveUser(ctx, userID, collection, target); err != nil {
+			http.Error(w, `{"error": "Failed to move user to organization region"}`, http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(cache.TagUsers)
+
+		if _, err := audit.Record(r, audit.ActionAssignOrg, userID.Hex(), bson.M{"org_id": user.OrgID}, bson.M{"org_id": req.OrgID}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit organization change of user %s: %v", userID.Hex(), err)
+		}
+
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "User organization updated successfully"})
+	}
+}
+
+// @Summary List organization data keys
+// @Description List the data key versions of an organization, without key material (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} OrgKeysResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/keys [get]
+func ListOrgKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid organization ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	list, err := keys.List(context.Background(), orgID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch keys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(OrgKeysResponse{Keys: list})
+}
+
+// @Summary Rotate organization data key
+// @Description Create a new active data key for an organization; older versions remain usable for decryption (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Security BearerAuth
+// @Success 200 {object} models.OrgKey
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/keys/rotate [post]
+func RotateOrgKey(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		org, status, msg := findOrganization(mux.Vars(r)["id"])
+		if status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+
+		key, err := keys.Rotate(context.Background(), cfg, org.ID)
+		if err == keys.ErrKeyDestroyed {
+			http.Error(w, `{"error": "Organization keys have been destroyed"}`, http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Failed to rotate key"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionRotateOrgKey, org.ID.Hex(), nil, bson.M{"version": key.Version}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit key rotation of organization %s: %v", org.ID.Hex(), err)
+		}
+
+		json.NewEncoder(w).Encode(key)
+	}
+}
+
+// @Summary Destroy organization data keys
+// @Description Crypto-shred an organization: destroy all of its data keys so its encrypted data can never be read again. Irreversible; repeat the organization name in confirm (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body DestroyOrgKeysRequest true "Confirmation"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/keys [delete]
+func DestroyOrgKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	var req DestroyOrgKeysRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Confirm != org.Name {
+		http.Error(w, `{"error": "Confirm with the exact organization name"}`, http.StatusBadRequest)
+		return
+	}
+
+	destroyed, err := keys.Destroy(context.Background(), org.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to destroy keys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionDestroyOrgKeys, org.ID.Hex(), nil, bson.M{"destroyed_keys": destroyed}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit key destruction of organization %s: %v", org.ID.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Organization data keys destroyed"})
+}
+
+// @Summary Move an organization to another region
+// @Description Migrate a tenant's users to the cluster of another data residency region (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body MoveOrganizationRegionRequest true "Target region"
+// @Security BearerAuth
+// @Success 200 {object} MoveOrganizationRegionResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/orgs/{id}/region [put]
+func MoveOrganizationRegion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, status, msg := findOrganization(mux.Vars(r)["id"])
+	if status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+
+	var req MoveOrganizationRegionRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if database.Region(req.Region) == nil || req.Region == "" {
+		http.Error(w, `{"error": "Unknown data region"}`, http.StatusBadRequest)
+		return
+	}
+
+	moved, err := repository.MoveOrg(context.Background(), org.ID, req.Region)
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to move organization %s to region %s after %d users: %v", org.ID.Hex(), req.Region, moved, err)
+		http.Error(w, `{"error": "Failed to move organization; retry to resume"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagOrgs, cache.TagUsers)
+
+	before := bson.M{"region": org.Region}
+	after := bson.M{"region": req.Region, "moved_users": moved}
+	if _, err := audit.Record(r, audit.ActionMoveOrgRegion, org.ID.Hex(), before, after); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit region move of organization %s: %v", org.ID.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(MoveOrganizationRegionResponse{Region: req.Region, MovedUsers: moved})
+}
+
+// findOrganization loads an organization by its hex ID
+func findOrganization(id string) (*models.Organization, int, string) {
+	orgID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid organization ID format"
+	}
+
+	var org models.Organization
+	if err := database.DB.Collection("organizations").FindOne(context.Background(), bson.M{"_id": orgID}).Decode(&org); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, http.StatusNotFound, "Organization not found"
+		}
+		return nil, http.StatusInternalServerError, "Failed to fetch organization"
+	}
+
+	return &org, http.StatusOK, ""
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/repository"
+)
+
+// euRegion adds the data residency region "eu", served from another
+// database of the same server
+func euRegion(t *testing.T, f *fixture) {
+	t.Helper()
+	db, err := f.srv.Database("golang-backend-eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Regions["eu"] = db
+}
+
+// regionUsers counts the users matching filter in a region
+func regionUsers(t *testing.T, region string, filter bson.M) int64 {
+	t.Helper()
+	n, err := database.Regions[region].Collection("users").CountDocuments(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// orgKeys creates testOrg as {org} with a first data key
+func orgKeys(t *testing.T, f *fixture) {
+	t.Helper()
+	createOrg(t, f)
+	orgID, _ := primitive.ObjectIDFromHex(testOrg)
+	ctx := context.Background()
+	// Unwrapped keys of testOrg stay cached from earlier tests' databases
+	if _, err := keys.Destroy(ctx, orgID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Rotate(ctx, testConfig, orgID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// orgRegion moves testOrg, without its members, to region
+func orgRegion(region string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		if _, err := database.DB.Collection("organizations").UpdateOne(context.Background(), bson.M{"name": "Acme"}, bson.M{"$set": bson.M{"region": region}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// orgMember makes the signed-in user a member of testOrg, with their email
+// encrypted under its data key
+func orgMember(t *testing.T, f *fixture) {
+	t.Helper()
+	orgKeys(t, f)
+	email, err := keys.Encrypt(context.Background(), testConfig, testOrg, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setUser(t, f, bson.M{"org_id": testOrg, "email": email})
+}
+
+// memberOf checks the signed-in user was moved to orgID, with their email
+// encrypted for it and their sessions refreshed
+func memberOf(orgID string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		user, _, err := repository.FindUser(context.Background(), bson.M{"_id": f.user.user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.OrgID != orgID || strings.HasPrefix(user.Email, "org:"+testOrg+":") != (orgID != "") {
+			t.Fatalf("got org %q, email %q", user.OrgID, user.Email)
+		}
+		if email, err := keys.Decrypt(context.Background(), testConfig, user.Email); err != nil || email != "user@example.com" {
+			t.Fatalf("decrypted %q, %v", email, err)
+		}
+		if user.RoleVersion != f.user.user.RoleVersion+1 {
+			t.Fatalf("role version %d not bumped", user.RoleVersion)
+		}
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionAssignOrg, "target_id": f.user.user.ID.Hex(), "after.org_id": orgID}); n != 1 {
+			t.Fatal("organization change not audited")
+		}
+	}
+}
+
+func TestCreateOrganization(t *testing.T) {
+	h := route("/admin/orgs", CreateOrganization(testConfig))
+	created := func(region string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var org models.Organization
+			json.Unmarshal(rec.Body.Bytes(), &org)
+			if org.Name != "Globex" || org.Region != region || org.ID.IsZero() {
+				t.Fatalf("got %+v", org)
+			}
+			if n := f.srv.Count("organizations", bson.M{"_id": org.ID, "name": "Globex"}); n != 1 {
+				t.Fatal("organization not stored")
+			}
+			if n := f.srv.Count("org_keys", bson.M{"org_id": org.ID, "version": 1, "status": models.KeyActive}); n != 1 {
+				t.Fatal("data key not created")
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionCreateOrg, "target_id": org.ID.Hex(), "after.region": region}); n != 1 {
+				t.Fatal("creation not audited")
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "created", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":"  Globex "}`, setup: nameFilter(), status: http.StatusCreated, check: created("")},
+		{name: "in region", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":"Globex","region":"eu"}`, setup: setups(nameFilter(), euRegion), status: http.StatusCreated, check: created("eu")},
+		{name: "unknown region", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":"Globex","region":"mars"}`, setup: nameFilter(), status: http.StatusBadRequest},
+		{name: "no name", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":" "}`, status: http.StatusBadRequest},
+		{name: "rejected name", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":"Initech"}`, setup: nameFilter("reserved:initech"), status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/orgs", body: `{"name":"Globex"}`, setup: setups(nameFilter(), dbDown("organizations")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListOrganizations(t *testing.T) {
+	h := route("/admin/orgs", http.HandlerFunc(ListOrganizations))
+	listed := func(want ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ListOrganizationsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			var got []string
+			for _, org := range resp.Organizations {
+				got = append(got, org.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") || resp.Organizations == nil {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{
+			name: "sorted by name", as: "admin", method: "GET", target: "/admin/orgs",
+			setup: func(t *testing.T, f *fixture) {
+				createOrg(t, f)
+				for _, name := range []string{"Initech", "Globex"} {
+					if _, err := database.DB.Collection("organizations").InsertOne(context.Background(), models.Organization{ID: primitive.NewObjectID(), Name: name}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			status: http.StatusOK,
+			check:  listed("Acme", "Globex", "Initech"),
+		},
+		{name: "none", as: "admin", method: "GET", target: "/admin/orgs", status: http.StatusOK, check: listed()},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/orgs", setup: dbDown("organizations"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestAssignUserOrganization(t *testing.T) {
+	h := route("/admin/users/{id}/org", AssignUserOrganization(testConfig))
+	runCases(t, h, []handlerCase{
+		{name: "joined", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"{org}"}`, setup: orgKeys, status: http.StatusOK, check: memberOf(testOrg)},
+		{name: "left", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":""}`, setup: orgMember, status: http.StatusOK, check: memberOf("")},
+		{
+			name: "joined in another region", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"{org}"}`,
+			setup:  setups(euRegion, orgKeys, orgRegion("eu")),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				memberOf(testOrg)(t, f, rec)
+				if regionUsers(t, "eu", bson.M{"_id": f.user.user.ID}) != 1 || regionUsers(t, "default", bson.M{"_id": f.user.user.ID}) != 0 {
+					t.Fatal("user not moved to the organization's region")
+				}
+			},
+		},
+		{name: "archived organization", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"{org}"}`, setup: orgStatus(models.OrgArchived), status: http.StatusConflict},
+		{
+			name: "leaving archived organization", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":""}`,
+			setup:  setups(orgMember, orgStatus(models.OrgArchived)),
+			status: http.StatusConflict,
+		},
+		{name: "unknown organization", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"{missing}"}`, status: http.StatusNotFound},
+		{name: "invalid organization ID", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"acme"}`, status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "PUT", target: "/admin/users/{missing}/org", body: `{"org_id":"{org}"}`, setup: orgKeys, status: http.StatusNotFound},
+		{name: "invalid user ID", as: "admin", method: "PUT", target: "/admin/users/nope/org", body: `{"org_id":""}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":1}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/users/{user}/org", body: `{"org_id":"{org}"}`, setup: setups(orgKeys, dbDown("organizations")), status: http.StatusInternalServerError},
+	})
+}
+
+// orgKeyList decodes the keys of a ListOrgKeys response as "version:status"
+func orgKeyList(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp OrgKeysResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	var list []string
+	for _, key := range resp.Keys {
+		list = append(list, fmt.Sprintf("%d:%s", key.Version, key.Status))
+	}
+	return strings.Join(list, ",")
+}
+
+func TestListOrgKeys(t *testing.T) {
+	h := route("/admin/orgs/{id}/keys", http.HandlerFunc(ListOrgKeys))
+	runCases(t, h, []handlerCase{
+		{
+			name: "versions", as: "admin", method: "GET", target: "/admin/orgs/{org}/keys",
+			setup: setups(orgKeys, func(t *testing.T, f *fixture) {
+				orgID, _ := primitive.ObjectIDFromHex(testOrg)
+				if _, err := keys.Rotate(context.Background(), testConfig, orgID); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if got := orgKeyList(t, rec); got != "1:"+models.KeyRetired+",2:"+models.KeyActive {
+					t.Fatalf("got %s", got)
+				}
+				if strings.Contains(rec.Body.String(), "wrapped") {
+					t.Fatal("key material listed")
+				}
+			},
+		},
+		{
+			name: "none", as: "admin", method: "GET", target: "/admin/orgs/{missing}/keys", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"keys":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/orgs/acme/keys", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/orgs/{org}/keys", setup: setups(orgKeys, dbDown("org_keys")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRotateOrgKey(t *testing.T) {
+	h := route("/admin/orgs/{id}/keys/rotate", RotateOrgKey(testConfig))
+	runCases(t, h, []handlerCase{
+		{
+			name: "rotated", as: "admin", method: "POST", target: "/admin/orgs/{org}/keys/rotate", setup: orgMember, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var key models.OrgKey
+				json.Unmarshal(rec.Body.Bytes(), &key)
+				if key.Version != 2 || key.Status != models.KeyActive {
+					t.Fatalf("got %+v", key)
+				}
+				if n := f.srv.Count("org_keys", bson.M{"version": 1, "status": models.KeyRetired}); n != 1 {
+					t.Fatal("previous key not retired")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRotateOrgKey, "target_id": testOrg, "after.version": 2}); n != 1 {
+					t.Fatal("rotation not audited")
+				}
+				// Data encrypted under the retired key stays readable
+				user, _, err := repository.FindUser(context.Background(), bson.M{"_id": f.user.user.ID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if email, err := keys.Decrypt(context.Background(), testConfig, user.Email); err != nil || email != "user@example.com" {
+					t.Fatalf("decrypted %q, %v", email, err)
+				}
+			},
+		},
+		{
+			name: "destroyed", as: "admin", method: "POST", target: "/admin/orgs/{org}/keys/rotate",
+			setup: setups(orgKeys, func(t *testing.T, f *fixture) {
+				orgID, _ := primitive.ObjectIDFromHex(testOrg)
+				if _, err := keys.Destroy(context.Background(), orgID); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusGone,
+		},
+		{name: "unknown organization", as: "admin", method: "POST", target: "/admin/orgs/{missing}/keys/rotate", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/orgs/acme/keys/rotate", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/orgs/{org}/keys/rotate", setup: setups(orgKeys, dbDown("org_keys")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDestroyOrgKeys(t *testing.T) {
+	h := route("/admin/orgs/{id}/keys", http.HandlerFunc(DestroyOrgKeys))
+	runCases(t, h, []handlerCase{
+		{
+			name: "destroyed", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/keys", body: `{"confirm":"Acme"}`, setup: orgMember, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("org_keys", bson.M{"status": models.KeyDestroyed, "wrapped_key": bson.M{"$exists": false}}); n != 1 {
+					t.Fatal("key not destroyed")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionDestroyOrgKeys, "target_id": testOrg, "after.destroyed_keys": 1}); n != 1 {
+					t.Fatal("destruction not audited")
+				}
+				// The member's email is shredded with the key
+				user, _, err := repository.FindUser(context.Background(), bson.M{"_id": f.user.user.ID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := keys.Decrypt(context.Background(), testConfig, user.Email); err == nil {
+					t.Fatal("email still readable")
+				}
+			},
+		},
+		{
+			name: "wrong confirmation", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/keys", body: `{"confirm":"acme"}`, setup: orgKeys, status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("org_keys", bson.M{"status": models.KeyActive}); n != 1 {
+					t.Fatal("key destroyed")
+				}
+			},
+		},
+		{name: "no confirmation", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/keys", setup: orgKeys, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "DELETE", target: "/admin/orgs/{missing}/keys", body: `{"confirm":"Acme"}`, status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/orgs/{org}/keys", body: `{"confirm":"Acme"}`, setup: setups(orgKeys, dbDown("org_keys")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestMoveOrganizationRegion(t *testing.T) {
+	h := route("/admin/orgs/{id}/region", http.HandlerFunc(MoveOrganizationRegion))
+	runCases(t, h, []handlerCase{
+		{
+			name: "moved", as: "admin", method: "PUT", target: "/admin/orgs/{org}/region", body: `{"region":"eu"}`, setup: setups(euRegion, orgMember), status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp MoveOrganizationRegionResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Region != "eu" || resp.MovedUsers != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+				if regionUsers(t, "eu", bson.M{"_id": f.user.user.ID}) != 1 || regionUsers(t, "default", bson.M{"_id": f.user.user.ID}) != 0 {
+					t.Fatal("member not moved")
+				}
+				if regionUsers(t, "default", bson.M{"_id": f.admin.user.ID}) != 1 {
+					t.Fatal("non-member moved")
+				}
+				if n := f.srv.Count("organizations", bson.M{"name": "Acme", "region": "eu"}); n != 1 {
+					t.Fatal("region not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionMoveOrgRegion, "target_id": testOrg, "after.moved_users": 1}); n != 1 {
+					t.Fatal("move not audited")
+				}
+			},
+		},
+		{name: "unknown region", as: "admin", method: "PUT", target: "/admin/orgs/{org}/region", body: `{"region":"mars"}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "no region", as: "admin", method: "PUT", target: "/admin/orgs/{org}/region", body: `{}`, setup: createOrg, status: http.StatusBadRequest},
+		{name: "unknown organization", as: "admin", method: "PUT", target: "/admin/orgs/{missing}/region", body: `{"region":"eu"}`, setup: euRegion, status: http.StatusNotFound},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/orgs/{org}/region", body: `{"region":"eu"}`, setup: setups(euRegion, orgMember, dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/challenge"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/loginhistory"
+	"golang-backend/models"
+	"golang-backend/otp"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/sms"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// OTPRequest represents the request for a sign-in code
+type OTPRequest struct {
+	Phone string `json:"phone" example:"+4915112345678"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// OTPLoginRequest represents the request for signing in with a code
+type OTPLoginRequest struct {
+	Phone string `json:"phone" example:"+4915112345678"`
+	Code  string `json:"code" example:"123456"`
+}
+
+// RequestLoginOTP handles requests for sign-in codes
+// @Summary Request a sign-in code
+// @Description Text a six-digit code for signing in to the account that verified the phone number. The response is the same whether or not the number belongs to an account; repeated requests from one client are challenged
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body OTPRequest true "Phone number in international format"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {string} string "Invalid phone number"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /login/otp/request [post]
+func RequestLoginOTP(cfg *config.Config, sender sms.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OTPRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		phone := utils.NormalizePhone(req.Phone)
+		if phone == "" {
+			http.Error(w, "Invalid phone number", http.StatusBadRequest)
+			return
+		}
+		phoneHash := utils.PhoneIndex(phone)
+
+		// Every request counts towards the client's challenges, since each
+		// may text a stranger
+		clientIP := audit.ClientIP(r)
+		if !requireChallenge(w, r, cfg, challenge.FlowLoginOTP, clientIP, req.ChallengeResponse, phoneRecipient(cfg, phoneHash)) {
+			return
+		}
+		failChallenge(r, challenge.FlowLoginOTP, clientIP)
+
+		orgID := tenant.OrgID(r)
+		sendAnonymously(func(ctx context.Context) { sendLoginOTP(ctx, cfg, sender, phone, phoneHash, orgID) })
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "If the number belongs to an account, a code is on its way"})
+	}
+}
+
+// sendLoginOTP texts a sign-in code to the account that verified a number,
+// if there is one that may sign in here
+func sendLoginOTP(ctx context.Context, cfg *config.Config, sender sms.Sender, phone, phoneHash, orgID string) {
+	user, _, err := repository.FindUser(ctx, bson.M{"phone_hash": phoneHash}, repository.Fields("org_id", "suspended"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up sign-in code recipient: %v", err)
+		return
+	}
+	if (orgID != "" && user.OrgID != orgID) || user.Suspended {
+		return
+	}
+
+	code, err := otp.Issue(ctx, otp.PurposeLogin, phoneHash, user.ID, phoneHash, "")
+	if errors.Is(err, otp.ErrCooldown) || errors.Is(err, otp.ErrTooManyAttempts) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue sign-in code for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if err := sender.Send(ctx, phone, otpText(cfg, code)); err != nil {
+		log.Printf("Failed to text sign-in code to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// otpText is the text message carrying a code
+func otpText(cfg *config.Config, code string) string {
+	return fmt.Sprintf("%s is your %s code. It expires in %d minutes. Never share it with anyone.",
+		code, cfg.MailBrandName, int(otp.TTL().Minutes()))
+}
+
+// phoneRecipient finds where to email challenge codes for requests naming a
+// phone number: the address of the account that verified it, if any
+func phoneRecipient(cfg *config.Config, phoneHash string) func(ctx context.Context) (string, *models.User, error) {
+	return func(ctx context.Context) (string, *models.User, error) {
+		user, _, err := repository.FindUser(ctx, bson.M{"phone_hash": phoneHash}, repository.Fields("email", "display_name", "locale", "org_id"))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		email, err := keys.Decrypt(ctx, cfg, user.Email)
+		return email, user, err
+	}
+}
+
+// LoginOTP handles signing in with a texted code
+// @Summary Sign in with a code
+// @Description Exchange a code texted by POST /login/otp/request for the same session as POST /login. A code works once and allows OTP_MAX_ATTEMPTS guesses
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body OTPLoginRequest true "Phone number and code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid or expired code"
+// @Failure 403 {string} string "Account suspended"
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /login/otp/verify [post]
+func LoginOTP(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OTPLoginRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Code == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		phone := utils.NormalizePhone(req.Phone)
+		if phone == "" {
+			http.Error(w, "Invalid phone number", http.StatusBadRequest)
+			return
+		}
+		phoneHash := utils.PhoneIndex(phone)
+
+		ctx := r.Context()
+		code, err := otp.Redeem(ctx, otp.PurposeLogin, phoneHash, req.Code)
+		if errors.Is(err, otp.ErrInvalidCode) {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", "invalid sign-in code")
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// The number must still be the account's
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": code.UserID, "phone_hash": phoneHash}, repository.CredentialFields)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		userID := user.ID.Hex()
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, userID, "not a member of the tenant")
+			recordLogin(r, userID, loginhistory.MethodSMS, false, "not a member of the tenant")
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return
+		}
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "account suspended")
+			recordLogin(r, userID, loginhistory.MethodSMS, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, userID, loginhistory.MethodSMS, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
+
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", false)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		correlation.SetUser(ctx, userID)
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, userID, "sign-in code")
+		recordLogin(r, userID, loginhistory.MethodSMS, true, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}
+}
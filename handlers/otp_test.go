@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/clock"
+	"golang-backend/otp"
+	"golang-backend/utils"
+)
+
+// testPhone is the number of the user's account in the sign-in code tests
+const testPhone = "+4915112345678"
+
+// text is a message sent through a textSender
+type text struct {
+	to, body string
+}
+
+// textSender is an sms.Sender passing the messages it sends on, or failing
+// them with err
+type textSender struct {
+	sent chan text
+	err  error
+}
+
+func newTextSender() *textSender {
+	return &textSender{sent: make(chan text, 16)}
+}
+
+func (s *textSender) Send(ctx context.Context, to, body string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent <- text{to, body}
+	return nil
+}
+
+// next waits for the next message, which may be sent in the background
+func (s *textSender) next(t *testing.T) text {
+	t.Helper()
+	select {
+	case msg := <-s.sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no text sent")
+		return text{}
+	}
+}
+
+// textedCode reads the code back from a message
+func textedCode(t *testing.T, msg text) string {
+	t.Helper()
+	code, _, ok := strings.Cut(msg.body, " ")
+	if !ok {
+		t.Fatalf("no code in %q", msg.body)
+	}
+	return code
+}
+
+// phoneVerified gives the user's account testPhone
+func phoneVerified(t *testing.T, f *fixture) {
+	setUser(t, f, bson.M{"phone_hash": utils.PhoneIndex(testPhone), "phone_verified_at": clock.Now()})
+}
+
+// loginCode gives the user's account testPhone and sets {code} to a sign-in
+// code texted to it
+func loginCode(t *testing.T, f *fixture) {
+	phoneVerified(t, f)
+	phoneHash := utils.PhoneIndex(testPhone)
+	code, err := otp.Issue(context.Background(), otp.PurposeLogin, phoneHash, f.user.user.ID, phoneHash, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("code", code)
+}
+
+func TestRequestLoginOTP(t *testing.T) {
+	sender := newTextSender()
+	runCases(t, RequestLoginOTP(testConfig, sender), []handlerCase{
+		{
+			name: "texts a code", method: "POST", target: "/login/otp/request", body: `{"phone":"+49 151 12345678"}`,
+			setup:  phoneVerified,
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				msg := sender.next(t)
+				if msg.to != testPhone {
+					t.Fatalf("texted %s", msg.to)
+				}
+				body := `{"phone":"` + testPhone + `","code":"` + textedCode(t, msg) + `"}`
+				if rec := request(LoginOTP(testConfig), "POST", "/login/otp/verify", "", body); rec.Code != http.StatusOK {
+					t.Fatalf("texted code: got %d %s", rec.Code, rec.Body)
+				}
+			},
+		},
+		{name: "unknown number", method: "POST", target: "/login/otp/request", body: `{"phone":"` + testPhone + `"}`, status: http.StatusAccepted},
+		{name: "malformed body", method: "POST", target: "/login/otp/request", body: `{"phone":1}`, status: http.StatusBadRequest},
+		{name: "invalid number", method: "POST", target: "/login/otp/request", body: `{"phone":"12"}`, status: http.StatusBadRequest},
+	})
+}
+
+func TestLoginOTP(t *testing.T) {
+	login := `{"phone":"` + testPhone + `","code":"{code}"}`
+	runCases(t, LoginOTP(testConfig), []handlerCase{
+		{
+			name: "signs in", method: "POST", target: "/login/otp/verify", body: login,
+			setup:  loginCode,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var session LoginResponse
+				json.Unmarshal(rec.Body.Bytes(), &session)
+				if session.Token == "" || session.RefreshToken == "" || session.Role != "user" {
+					t.Fatalf("got %+v", session)
+				}
+				if rec := request(LoginOTP(testConfig), "POST", "/login/otp/verify", "", strings.ReplaceAll(login, "{code}", f.vars["code"])); rec.Code != http.StatusUnauthorized {
+					t.Fatalf("code used twice: got %d", rec.Code)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/login/otp/verify", body: `{"phone":1}`, status: http.StatusBadRequest},
+		{name: "missing code", method: "POST", target: "/login/otp/verify", body: `{"phone":"` + testPhone + `"}`, status: http.StatusBadRequest},
+		{name: "invalid number", method: "POST", target: "/login/otp/verify", body: `{"phone":"12","code":"123456"}`, status: http.StatusBadRequest},
+		{name: "wrong code", method: "POST", target: "/login/otp/verify", body: `{"phone":"` + testPhone + `","code":"wrong"}`, setup: loginCode, status: http.StatusUnauthorized},
+		{
+			name: "number removed since", method: "POST", target: "/login/otp/verify", body: login,
+			setup: setups(loginCode, func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"phone_hash": utils.PhoneIndex("+4915187654321")})
+			}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "suspended", method: "POST", target: "/login/otp/verify", body: login,
+			setup:  setups(loginCode, func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"suspended": true}) }),
+			status: http.StatusForbidden,
+		},
+		{name: "database down", method: "POST", target: "/login/otp/verify", body: login, setup: setups(loginCode, dbDown("otp_codes")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/challenge"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/mailer"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/passwordpolicy"
+	"golang-backend/passwordreset"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// ForgotPasswordRequest represents the request for a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// ResetPasswordRequest represents the request for setting a new password
+type ResetPasswordRequest struct {
+	Token       string `json:"token" example:"b3Jx8kQ2mV9pL1sT7wZ4nC6eH0jU5yA3dF8gR2iO1qE"`
+	NewPassword string `json:"new_password" example:"new-password123"`
+}
+
+// ChangePasswordRequest represents the request for changing the password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" example:"password123"`
+	NewPassword     string `json:"new_password" example:"new-password123"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// ChangePasswordResponse represents a changed password
+type ChangePasswordResponse struct {
+	Message         string `json:"message" example:"Password changed"`
+	SessionsRevoked int64  `json:"sessions_revoked" example:"2"`
+}
+
+// FieldError describes why a request field was rejected
+type FieldError struct {
+	Field   string `json:"field" example:"password"`
+	Code    string `json:"code" example:"too_short"`
+	Message string `json:"message" example:"Password must be at least 8 characters"`
+}
+
+// ValidationErrorResponse lists the rejected fields of a request
+type ValidationErrorResponse struct {
+	Error  string       `json:"error" example:"Password does not meet the requirements"`
+	Fields []FieldError `json:"fields"`
+}
+
+// checkNewPassword answers 400 with every rule of the password policy a new
+// password breaks and returns false, or returns true when it meets them
+func checkNewPassword(w http.ResponseWriter, field, password string) bool {
+	violations := passwordpolicy.Check(password)
+	if len(violations) == 0 {
+		return true
+	}
+	response := ValidationErrorResponse{Error: "Password does not meet the requirements", Fields: make([]FieldError, len(violations))}
+	for i, v := range violations {
+		response.Fields[i] = FieldError{Field: field, Code: v.Code, Message: v.Message}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+	return false
+}
+
+// ForgotPassword handles password reset requests
+// @Summary Request a password reset
+// @Description Email a single-use link for setting a new password. The response is the same whether or not the address has an account; repeated requests from one client are challenged as for login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account email"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /password/forgot [post]
+func ForgotPassword(cfg *config.Config, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Email == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+
+		// Every request counts towards the client's challenges, since probing
+		// for accounts and flooding inboxes both mean many requests
+		clientIP := audit.ClientIP(r)
+		if !requireChallenge(w, r, cfg, challenge.FlowPasswordReset, clientIP, req.ChallengeResponse, accountRecipient(req.Email, emailHash)) {
+			return
+		}
+		failChallenge(r, challenge.FlowPasswordReset, clientIP)
+
+		orgID := tenant.OrgID(r)
+		sendAnonymously(func(ctx context.Context) { sendPasswordReset(ctx, cfg, mail, req.Email, emailHash, orgID) })
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "If the address has an account, a reset link is on its way"})
+	}
+}
+
+// sendPasswordReset emails a reset link to the account registered with an
+// email, if there is one that may sign in here
+func sendPasswordReset(ctx context.Context, cfg *config.Config, mail mailer.Mailer, email, emailHash, orgID string) {
+	user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.Fields("display_name", "locale", "org_id", "suspended"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up password reset recipient: %v", err)
+		return
+	}
+	// Custom domains only reset their organization's accounts
+	if (orgID != "" && user.OrgID != orgID) || user.Suspended {
+		return
+	}
+
+	token, err := passwordreset.Issue(ctx, user.ID)
+	if errors.Is(err, passwordreset.ErrCooldown) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue password reset token for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	link := cfg.PasswordResetURL
+	if link == "" {
+		link = cfg.AppURL + "/reset-password"
+	}
+	vars := map[string]string{
+		"ResetURL": link + "?token=" + url.QueryEscape(token),
+		"Minutes":  strconv.Itoa(int(passwordreset.TTL().Minutes())),
+	}
+	msg, err := renderUserEmail(ctx, cfg, "password_reset", email, user, vars)
+	if err != nil {
+		log.Printf("Failed to render password reset email for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if err := sendRequested(mail, msg); err != nil {
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// ResetPassword handles setting a new password with a reset token
+// @Summary Reset password
+// @Description Set a new password with the token from a reset email. The password must meet the password policy; violations are listed per field. The token works once; every session of the account is signed out
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ValidationErrorResponse "Invalid or expired reset token, or the password does not meet the requirements"
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /password/reset [post]
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	// Checked first, so a rejected password leaves the token usable
+	if !checkNewPassword(w, "new_password", req.NewPassword) {
+		return
+	}
+
+	ctx := r.Context()
+	reset, err := passwordreset.Redeem(ctx, req.Token)
+	if errors.Is(err, passwordreset.ErrInvalidToken) {
+		security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid password reset token")
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, collection, err := repository.FindUser(ctx, bson.M{"_id": reset.UserID}, repository.Fields("email_hash", "org_id"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if errors.Is(err, utils.ErrPasswordPoolBusy) {
+		retryLater(w, "Server busy, please retry")
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	update := bson.M{"$set": bson.M{"password": hashedPassword, "updated_at": clock.Now()}}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password is signed out
+	userID := user.ID.Hex()
+	if _, err := tokens.RevokeUser(ctx, userID); err != nil {
+		correlation.Errorf(ctx, "Failed to revoke sessions of user %s after password reset: %v", userID, err)
+	}
+	resetChallenge(r, challenge.FlowLogin, user.EmailHash)
+	security.Emit(r, security.EventPasswordReset, security.OutcomeSuccess, userID, "")
+	correlation.SetUser(ctx, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Password has been reset"})
+}
+
+// ChangePassword handles changing the password of a signed-in user
+// @Summary Change password
+// @Description Replace the password after confirming the current one. The new password must meet the password policy; violations are listed per field. Every other session of the account is signed out and the user is told by email. Failed attempts are challenged as for sudo
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} ChangePasswordResponse
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 401 {object} ErrorResponse "Invalid password"
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Account has no password"
+// @Failure 428 {object} ChallengeResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/password [put]
+func ChangePassword(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Only user sessions have a password to confirm
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if claims["auth"] != nil {
+			http.Error(w, `{"error": "This endpoint requires a session token"}`, http.StatusForbidden)
+			return
+		}
+		userIDStr, _ := claims["userID"].(string)
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		if !checkNewPassword(w, "new_password", req.NewPassword) {
+			return
+		}
+
+		ctx := r.Context()
+		user, collection, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("email", "password", "display_name", "locale", "org_id", "notifications"))
+		if err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		// Accounts signing in through a provider or magic links set their
+		// first password by reset
+		if user.Password == "" {
+			http.Error(w, `{"error": "Set a password through POST /password/forgot"}`, http.StatusConflict)
+			return
+		}
+
+		// Guessing the password of a stolen session is challenged like sudo
+		codeTo := func(ctx context.Context) (string, *models.User, error) {
+			email, err := keys.Decrypt(ctx, cfg, user.Email)
+			return email, user, err
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowSudo, userIDStr, req.ChallengeResponse, codeTo) {
+			return
+		}
+		if err := utils.ComparePassword(user.Password, req.CurrentPassword); errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, `{"error": "Server busy, please retry"}`)
+			return
+		} else if err != nil {
+			failChallenge(r, challenge.FlowSudo, userIDStr)
+			security.Emit(r, security.EventPasswordChange, security.OutcomeFailure, userIDStr, "invalid password")
+			http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+			return
+		}
+		resetChallenge(r, challenge.FlowSudo, userIDStr)
+
+		hashedPassword, err := utils.HashPassword(req.NewPassword)
+		if errors.Is(err, utils.ErrPasswordPoolBusy) {
+			retryLater(w, `{"error": "Server busy, please retry"}`)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to hash password"}`, http.StatusInternalServerError)
+			return
+		}
+		update := bson.M{"$set": bson.M{"password": hashedPassword, "updated_at": clock.Now()}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+			http.Error(w, `{"error": "Failed to update password"}`, http.StatusInternalServerError)
+			return
+		}
+		cache.Invalidate(cache.TagUsers)
+
+		// Whoever else knew the old password is signed out; this session
+		// stays signed in
+		jti, _ := claims["jti"].(string)
+		revoked, err := tokens.RevokeOthers(ctx, userIDStr, jti)
+		if err != nil {
+			correlation.Errorf(ctx, "Failed to revoke sessions of user %s after password change: %v", userIDStr, err)
+		}
+		if _, err := audit.Record(r, audit.ActionChangePassword, userIDStr, nil, bson.M{"sessions_revoked": revoked}); err != nil {
+			correlation.Errorf(ctx, "Failed to audit password change of user %s: %v", userIDStr, err)
+		}
+		security.Emit(r, security.EventPasswordChange, security.OutcomeSuccess, userIDStr, "")
+		sendPasswordChangedNotice(cfg, *user)
+
+		json.NewEncoder(w).Encode(ChangePasswordResponse{Message: "Password changed", SessionsRevoked: revoked})
+	}
+}
+
+// sendPasswordChangedNotice tells the user their password was changed, in
+// the background, so an unexpected change does not go unnoticed. Security
+// notices cannot be muted by email or in-app.
+func sendPasswordChangedNotice(cfg *config.Config, user models.User) {
+	go func() {
+		ctx := context.Background()
+		to, err := keys.Decrypt(ctx, cfg, user.Email)
+		if err != nil {
+			log.Printf("Failed to decrypt email of user %s for password change notice: %v", user.ID.Hex(), err)
+			return
+		}
+		msg, err := renderUserEmail(ctx, cfg, "password_changed", to, &user, nil)
+		if err != nil {
+			log.Printf("Failed to render password change notice for user %s: %v", user.ID.Hex(), err)
+			return
+		}
+		notice := notifier.UserMessage{
+			Type:     "account.password_changed",
+			Category: models.CategorySecurity,
+			Email:    &msg,
+			Title:    msg.Subject,
+			Body:     "The password of your account was changed and your other sessions were signed out.",
+		}
+		if err := notifier.Deliver(ctx, cfg, &user, notice); err != nil {
+			log.Printf("Failed to send password change notice to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/middleware"
+	"golang-backend/passwordreset"
+)
+
+// newPassword meets the password policy and differs from testPassword
+const newPassword = "another horse battery staple"
+
+// passwordReset sets {token} to the token of a reset link sent to the user
+func passwordReset(t *testing.T, f *fixture) {
+	token, err := passwordreset.Issue(context.Background(), f.user.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("token", token)
+}
+
+// otherSession signs the user in once more and sets {other} to the token
+func otherSession(t *testing.T, f *fixture) {
+	f.set("other", signIn(t, f.user.user).token)
+}
+
+// checkSignIn checks whether the user signs in with password
+func checkSignIn(t *testing.T, password string, want int) {
+	t.Helper()
+	body := `{"email":"user@example.com","password":"` + password + `"}`
+	if rec := request(Login(testConfig), "POST", "/login", "", body); rec.Code != want {
+		t.Fatalf("sign-in with %q: got %d, want %d", password, rec.Code, want)
+	}
+}
+
+// checkToken checks whether a session token is still accepted
+func checkToken(t *testing.T, token string, want int) {
+	t.Helper()
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	if rec := request(middleware.JWTAuthMiddleware(testConfig)(ok), "GET", "/", token, ""); rec.Code != want {
+		t.Fatalf("session: got %d, want %d", rec.Code, want)
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	mail := newOutbox()
+	runCases(t, ForgotPassword(testConfig, mail), []handlerCase{
+		{
+			name: "emails a link", method: "POST", target: "/password/forgot", body: `{"email":"User@example.com"}`,
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				msg := mail.next(t)
+				if msg.To != "user@example.com" {
+					t.Fatalf("emailed %s", msg.To)
+				}
+				body := marshal(t, ResetPasswordRequest{Token: linkToken(t, msg), NewPassword: newPassword})
+				if rec := request(http.HandlerFunc(ResetPassword), "POST", "/password/reset", "", body); rec.Code != http.StatusOK {
+					t.Fatalf("emailed link: got %d %s", rec.Code, rec.Body)
+				}
+			},
+		},
+		{name: "unknown address", method: "POST", target: "/password/forgot", body: `{"email":"nobody@example.com"}`, status: http.StatusAccepted},
+		{name: "malformed body", method: "POST", target: "/password/forgot", body: `{"email":1}`, status: http.StatusBadRequest},
+		{name: "missing address", method: "POST", target: "/password/forgot", body: `{}`, status: http.StatusBadRequest},
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	reset := `{"token":"{token}","new_password":"` + newPassword + `"}`
+	runCases(t, http.HandlerFunc(ResetPassword), []handlerCase{
+		{
+			name: "sets the password", method: "POST", target: "/password/reset", body: reset,
+			setup:  passwordReset,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkSignIn(t, newPassword, http.StatusOK)
+				checkSignIn(t, testPassword, http.StatusUnauthorized)
+				checkToken(t, f.user.token, http.StatusUnauthorized)
+				body := `{"token":"` + f.vars["token"] + `","new_password":"` + newPassword + `"}`
+				if rec := request(http.HandlerFunc(ResetPassword), "POST", "/password/reset", "", body); rec.Code != http.StatusBadRequest {
+					t.Fatalf("token used twice: got %d", rec.Code)
+				}
+			},
+		},
+		{
+			name: "weak password", method: "POST", target: "/password/reset", body: `{"token":"{token}","new_password":"abc"}`,
+			setup:  passwordReset,
+			status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ValidationErrorResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Fields) == 0 || resp.Fields[0].Field != "new_password" {
+					t.Fatalf("got %+v", resp)
+				}
+				// The token still works
+				body := `{"token":"` + f.vars["token"] + `","new_password":"` + newPassword + `"}`
+				if rec := request(http.HandlerFunc(ResetPassword), "POST", "/password/reset", "", body); rec.Code != http.StatusOK {
+					t.Fatalf("token after a weak password: got %d", rec.Code)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/password/reset", body: `{"token":1}`, status: http.StatusBadRequest},
+		{name: "missing token", method: "POST", target: "/password/reset", body: `{"new_password":"` + newPassword + `"}`, status: http.StatusBadRequest},
+		{name: "unknown token", method: "POST", target: "/password/reset", body: `{"token":"unknown","new_password":"` + newPassword + `"}`, setup: passwordReset, status: http.StatusBadRequest},
+		{name: "deleted since", method: "POST", target: "/password/reset", body: reset, setup: setups(passwordReset, userDeleted), status: http.StatusBadRequest},
+		{name: "database down", method: "POST", target: "/password/reset", body: reset, setup: setups(passwordReset, dbDown("password_resets")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	change := func(current string) string {
+		return `{"current_password":"` + current + `","new_password":"` + newPassword + `"}`
+	}
+	runCases(t, ChangePassword(testConfig), []handlerCase{
+		{
+			name: "changes the password", as: "user", method: "PUT", target: "/user/password", body: change(testPassword),
+			setup:  otherSession,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ChangePasswordResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.SessionsRevoked != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+				checkSignIn(t, newPassword, http.StatusOK)
+				checkToken(t, f.user.token, http.StatusOK)
+				checkToken(t, f.vars["other"], http.StatusUnauthorized)
+			},
+		},
+		{
+			name: "weak password", as: "user", method: "PUT", target: "/user/password", body: `{"current_password":"` + testPassword + `","new_password":"abc"}`,
+			status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkSignIn(t, testPassword, http.StatusOK)
+			},
+		},
+		{
+			name: "wrong current password", as: "user", method: "PUT", target: "/user/password", body: change("wrong password"),
+			setup:  otherSession,
+			status: http.StatusUnauthorized,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				checkSignIn(t, testPassword, http.StatusOK)
+				checkToken(t, f.vars["other"], http.StatusOK)
+			},
+		},
+		{name: "malformed body", as: "user", method: "PUT", target: "/user/password", body: `{`, status: http.StatusBadRequest},
+		{
+			name: "no password yet", as: "user", method: "PUT", target: "/user/password", body: change(""),
+			setup:  func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"password": ""}) },
+			status: http.StatusConflict,
+		},
+		{name: "no session", as: "guest", method: "PUT", target: "/user/password", body: change(testPassword), status: http.StatusUnauthorized},
+		{name: "deleted after sign-in", as: "user", method: "PUT", target: "/user/password", body: change(testPassword), setup: userDeleted, status: http.StatusNotFound},
+	})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/correlation"
+	"golang-backend/permissions"
+	"golang-backend/utils"
+)
+
+// RolePermissionsResponse lists the permissions of every role and the
+// permissions there are
+type RolePermissionsResponse struct {
+	Roles       []permissions.RolePermissions `json:"roles"`
+	Permissions []string                      `json:"permissions" example:"users:delete,users:role"`
+}
+
+// SetRolePermissionsRequest replaces the permissions of a role
+type SetRolePermissionsRequest struct {
+	Permissions []string `json:"permissions" example:"users:*,audit:verify"`
+}
+
+// SetRolePermissionsResponse tells how many users' sessions are refreshed
+type SetRolePermissionsResponse struct {
+	Role          string   `json:"role" example:"admin"`
+	Permissions   []string `json:"permissions" example:"users:*,audit:verify"`
+	UsersAffected int64    `json:"users_affected" example:"3"`
+}
+
+// @Summary List role permissions
+// @Description List the permissions session tokens of each role carry, and every permission routes check (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} RolePermissionsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/permissions [get]
+func ListRolePermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	roles, err := permissions.Documents(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to list role permissions"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(RolePermissionsResponse{Roles: roles, Permissions: permissions.All})
+}
+
+// @Summary Set role permissions
+// @Description Replace the permissions of the user or admin role. Entries are permissions, resource wildcards such as users:*, or * for all. The role version of every user with the role is bumped, so their sessions are refreshed with the new permissions. The admin role must keep permissions:manage. Requires the permissions:manage permission and a sudo session (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param role path string true "user or admin"
+// @Param request body SetRolePermissionsRequest true "Permissions of the role"
+// @Success 200 {object} SetRolePermissionsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "Unknown role"
+// @Failure 409 {object} ErrorResponse "The admin role would lose permissions:manage"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/permissions/{role} [put]
+func SetRolePermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	role := mux.Vars(r)["role"]
+	var req SetRolePermissionsRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	// Admins locking themselves out would need break-glass access to recover
+	if role == "admin" && !permissions.Grants(req.Permissions, permissions.PermissionsManage) {
+		http.Error(w, `{"error": "The admin role must keep permissions:manage"}`, http.StatusConflict)
+		return
+	}
+
+	ctx := r.Context()
+	before, affected, err := permissions.Set(ctx, role, req.Permissions, audit.ActorID(r))
+	switch {
+	case errors.Is(err, permissions.ErrUnknownRole):
+		http.Error(w, `{"error": "Unknown role"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, permissions.ErrInvalidPermission):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	case err != nil:
+		correlation.Errorf(ctx, "Failed to set permissions of role %s: %v", role, err)
+		http.Error(w, `{"error": "Failed to set role permissions"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	after := bson.M{"permissions": req.Permissions, "users_affected": affected}
+	if _, err := audit.Record(r, audit.ActionUpdatePermissions, role, bson.M{"permissions": before}, after); err != nil {
+		correlation.Errorf(ctx, "Failed to audit permissions change of role %s: %v", role, err)
+	}
+	json.NewEncoder(w).Encode(SetRolePermissionsResponse{Role: role, Permissions: req.Permissions, UsersAffected: affected})
+}
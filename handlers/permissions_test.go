@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/permissions"
+)
+
+// permissionsReseeded puts the mappings of both roles back as seeded once
+// the test is done, as the cached mapping outlives the database
+func permissionsReseeded(t *testing.T, f *fixture) {
+	ctx := context.Background()
+	t.Cleanup(func() {
+		permissions.Set(ctx, "user", nil, "")
+		permissions.Set(ctx, "admin", []string{permissions.Wildcard}, "")
+	})
+}
+
+func TestListRolePermissions(t *testing.T) {
+	h := route("/admin/permissions", http.HandlerFunc(ListRolePermissions))
+	runCases(t, h, []handlerCase{
+		{
+			name: "seeded", as: "admin", method: "GET", target: "/admin/permissions", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp RolePermissionsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				var roles []string
+				for _, role := range resp.Roles {
+					roles = append(roles, role.Role+"="+strings.Join(role.Permissions, ","))
+				}
+				if got := strings.Join(roles, " "); got != "admin=* user=" {
+					t.Fatalf("got roles %s", got)
+				}
+				if len(resp.Permissions) != len(permissions.All) {
+					t.Fatalf("got permissions %v", resp.Permissions)
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/permissions", setup: dbDown("role_permissions"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestSetRolePermissions(t *testing.T) {
+	h := route("/admin/permissions/{role}", http.HandlerFunc(SetRolePermissions))
+	runCases(t, h, []handlerCase{
+		{
+			name: "user role", as: "admin", method: "PUT", target: "/admin/permissions/user", body: `{"permissions":["users:read","orgs:*"]}`, setup: permissionsReseeded, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp SetRolePermissionsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Role != "user" || strings.Join(resp.Permissions, ",") != "users:read,orgs:*" || resp.UsersAffected != 1 {
+					t.Fatalf("got %+v", resp)
+				}
+				if n := f.srv.Count("role_permissions", bson.M{"_id": "user", "permissions": "orgs:*", "updated_by": f.admin.user.ID.Hex()}); n != 1 {
+					t.Fatal("permissions not stored")
+				}
+				// Sessions of the role pick the change up through its role version
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "role_version": 1}); n != 1 {
+					t.Fatal("role version not bumped")
+				}
+				if n := f.srv.Count("users", bson.M{"_id": f.admin.user.ID, "role_version": bson.M{"$exists": false}}); n != 1 {
+					t.Fatal("other role bumped")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionUpdatePermissions, "target_id": "user", "after.users_affected": 1}); n != 1 {
+					t.Fatal("change not audited")
+				}
+			},
+		},
+		{name: "admin role", as: "admin", method: "PUT", target: "/admin/permissions/admin", body: `{"permissions":["permissions:manage","users:*"]}`, setup: permissionsReseeded, status: http.StatusOK},
+		{
+			name: "admin role without permissions:manage", as: "admin", method: "PUT", target: "/admin/permissions/admin", body: `{"permissions":["users:*"]}`, status: http.StatusConflict,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("role_permissions", bson.M{"_id": "admin", "permissions": permissions.Wildcard}); n != 1 {
+					t.Fatal("admin permissions changed")
+				}
+			},
+		},
+		{name: "unknown role", as: "admin", method: "PUT", target: "/admin/permissions/owner", body: `{"permissions":[]}`, status: http.StatusNotFound},
+		{name: "invalid permission", as: "admin", method: "PUT", target: "/admin/permissions/user", body: `{"permissions":["users:fly"]}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/permissions/user", body: `{"permissions":"*"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/permissions/user", body: `{"permissions":[]}`, setup: dbDown("role_permissions"), status: http.StatusInternalServerError},
+	})
+}
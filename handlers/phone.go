@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/otp"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/sms"
+	"golang-backend/utils"
+)
+
+// PhoneRequest names the phone number to add to the caller's account
+type PhoneRequest struct {
+	Phone string `json:"phone" example:"+4915112345678"`
+}
+
+// PhoneVerifyRequest confirms a phone number with the code texted to it
+type PhoneVerifyRequest struct {
+	Code string `json:"code" example:"123456"`
+}
+
+// AddPhone handles adding a phone number for sign-in codes
+// @Summary Add a phone number
+// @Description Text a verification code to a number in international format. The number is only saved, replacing any earlier one, once the code is confirmed at POST /user/phone/verify. Requires a session elevated with POST /auth/sudo, since the number can sign in to the account
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body PhoneRequest true "Phone number"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Number in use by another account"
+// @Failure 429 {object} ErrorResponse "Code sent moments ago"
+// @Failure 500 {object} ErrorResponse
+// @Router /user/phone [put]
+func AddPhone(cfg *config.Config, sender sms.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, ok := claimsUserID(r)
+		if !ok {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+		var req PhoneRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		phone := utils.NormalizePhone(req.Phone)
+		if phone == "" {
+			http.Error(w, `{"error": "Invalid phone number, use the international format such as +4915112345678"}`, http.StatusBadRequest)
+			return
+		}
+		phoneHash := utils.PhoneIndex(phone)
+
+		ctx := r.Context()
+		if status, msg := phoneAvailable(r, userID, phoneHash); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("org_id"))
+		if err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		encrypted, err := keys.Encrypt(ctx, cfg, user.OrgID, phone)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to encrypt phone number"}`, http.StatusInternalServerError)
+			return
+		}
+
+		code, err := otp.Issue(ctx, otp.PurposePhone, userID.Hex(), userID, phoneHash, encrypted)
+		if errors.Is(err, otp.ErrCooldown) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(cfg.OTPCooldown.Seconds())))
+			http.Error(w, `{"error": "A code was sent moments ago, please wait before requesting another"}`, http.StatusTooManyRequests)
+			return
+		} else if errors.Is(err, otp.ErrTooManyAttempts) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(cfg.OTPTTL.Seconds())))
+			http.Error(w, `{"error": "Too many wrong codes, please try again later"}`, http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to issue code"}`, http.StatusInternalServerError)
+			return
+		}
+		if err := sender.Send(ctx, phone, otpText(cfg, code)); err != nil {
+			correlation.Errorf(ctx, "Failed to text verification code to user %s: %v", userID.Hex(), err)
+			http.Error(w, `{"error": "Failed to send code"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "Verification code sent"})
+	}
+}
+
+// @Summary Verify a phone number
+// @Description Confirm the number of PUT /user/phone with the code texted to it. The number then replaces any earlier one and can be used with POST /login/otp/request
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body PhoneVerifyRequest true "Texted code"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse "Invalid or expired code"
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Number in use by another account"
+// @Failure 500 {object} ErrorResponse
+// @Router /user/phone/verify [post]
+func VerifyPhone(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	var req PhoneVerifyRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Code == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	code, err := otp.Redeem(ctx, otp.PurposePhone, userID.Hex(), req.Code)
+	if errors.Is(err, otp.ErrInvalidCode) {
+		security.Emit(r, security.EventPhoneChange, security.OutcomeFailure, userID.Hex(), "invalid code")
+		http.Error(w, `{"error": "Invalid or expired code"}`, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	// Another account may have verified the number since the code was sent
+	if status, msg := phoneAvailable(r, userID, code.PhoneHash); status != http.StatusOK {
+		http.Error(w, msg, status)
+		return
+	}
+
+	users, err := repository.LocateUser(ctx, userID)
+	if err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	now := clock.Now()
+	update := bson.M{"$set": bson.M{"phone": code.Phone, "phone_hash": code.PhoneHash, "phone_verified_at": now, "updated_at": now}}
+	if _, err := users.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		http.Error(w, `{"error": "Failed to save phone number"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	if _, err := audit.Record(r, audit.ActionUpdatePhone, userID.Hex(), nil, bson.M{"phone_verified_at": now}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit phone change of user %s: %v", userID.Hex(), err)
+	}
+	security.Emit(r, security.EventPhoneChange, security.OutcomeSuccess, userID.Hex(), "verified")
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Phone number verified"})
+}
+
+// @Summary Remove the phone number
+// @Description Remove the caller's phone number, so it can no longer sign in with texted codes
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "No phone number on the account"
+// @Failure 500 {object} ErrorResponse
+// @Router /user/phone [delete]
+func RemovePhone(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	users, err := repository.LocateUser(ctx, userID)
+	if err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	update := bson.M{
+		"$set":   bson.M{"updated_at": clock.Now()},
+		"$unset": bson.M{"phone": "", "phone_hash": "", "phone_verified_at": ""},
+	}
+	result, err := users.UpdateOne(ctx, bson.M{"_id": userID, "phone_hash": bson.M{"$exists": true}}, update)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to remove phone number"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, `{"error": "No phone number on the account"}`, http.StatusNotFound)
+		return
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	if _, err := audit.Record(r, audit.ActionUpdatePhone, userID.Hex(), nil, bson.M{"phone_removed": true}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit phone change of user %s: %v", userID.Hex(), err)
+	}
+	security.Emit(r, security.EventPhoneChange, security.OutcomeSuccess, userID.Hex(), "removed")
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Phone number removed"})
+}
+
+// phoneAvailable checks that no other account verified a number
+func phoneAvailable(r *http.Request, userID primitive.ObjectID, phoneHash string) (int, string) {
+	taken, err := repository.UserExists(r.Context(), bson.M{"phone_hash": phoneHash, "_id": bson.M{"$ne": userID}})
+	if err != nil {
+		return http.StatusInternalServerError, `{"error": "Database error"}`
+	}
+	if taken {
+		return http.StatusConflict, `{"error": "Phone number is already in use by another account"}`
+	}
+	return http.StatusOK, ""
+}
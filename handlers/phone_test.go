@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/otp"
+	"golang-backend/utils"
+)
+
+func TestAddPhone(t *testing.T) {
+	sender := newTextSender()
+	runCases(t, AddPhone(testConfig, sender), []handlerCase{
+		{
+			name: "texts a code", as: "user", method: "PUT", target: "/user/phone", body: `{"phone":"+49 151 12345678"}`,
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				msg := sender.next(t)
+				if msg.to != testPhone {
+					t.Fatalf("texted %s", msg.to)
+				}
+				if n := f.srv.Count("users", bson.M{"phone_hash": bson.M{"$exists": true}}); n != 0 {
+					t.Fatal("number saved before it was verified")
+				}
+				rec = f.user.do(http.HandlerFunc(VerifyPhone), "POST", "/user/phone/verify", `{"code":"`+textedCode(t, msg)+`"}`)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("texted code: got %d %s", rec.Code, rec.Body)
+				}
+			},
+		},
+		{name: "malformed body", as: "user", method: "PUT", target: "/user/phone", body: `{`, status: http.StatusBadRequest},
+		{name: "invalid number", as: "user", method: "PUT", target: "/user/phone", body: `{"phone":"12"}`, status: http.StatusBadRequest},
+		{
+			name: "number of another account", as: "admin", method: "PUT", target: "/user/phone", body: `{"phone":"` + testPhone + `"}`,
+			setup: phoneVerified, status: http.StatusConflict,
+		},
+		{
+			name: "code sent moments ago", as: "user", method: "PUT", target: "/user/phone", body: `{"phone":"` + testPhone + `"}`,
+			setup: func(t *testing.T, f *fixture) {
+				if rec := f.user.do(AddPhone(testConfig, newTextSender()), "PUT", "/user/phone", `{"phone":"`+testPhone+`"}`); rec.Code != http.StatusAccepted {
+					t.Fatalf("first code: got %d", rec.Code)
+				}
+			},
+			status: http.StatusTooManyRequests,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Fatal("no Retry-After")
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "PUT", target: "/user/phone", body: `{"phone":"` + testPhone + `"}`, status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "PUT", target: "/user/phone", body: `{"phone":"` + testPhone + `"}`, setup: dbDown("otp_codes"), status: http.StatusInternalServerError},
+	})
+
+}
+
+func TestAddPhoneTextNotSent(t *testing.T) {
+	f := newFixture(t)
+	h := AddPhone(testConfig, &textSender{err: errors.New("provider down")})
+	if rec := f.user.do(h, "PUT", "/user/phone", `{"phone":"`+testPhone+`"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// phoneCode sets {code} to a code texted to testPhone for adding it to the
+// user's account
+func phoneCode(t *testing.T, f *fixture) {
+	userID := f.user.user.ID
+	code, err := otp.Issue(context.Background(), otp.PurposePhone, userID.Hex(), userID, utils.PhoneIndex(testPhone), "encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("code", code)
+}
+
+func TestVerifyPhone(t *testing.T) {
+	runCases(t, http.HandlerFunc(VerifyPhone), []handlerCase{
+		{
+			name: "saves the number", as: "user", method: "POST", target: "/user/phone/verify", body: `{"code":"{code}"}`,
+			setup:  phoneCode,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				saved := bson.M{"_id": f.user.user.ID, "phone": "encrypted", "phone_hash": utils.PhoneIndex(testPhone), "phone_verified_at": bson.M{"$exists": true}}
+				if n := f.srv.Count("users", saved); n != 1 {
+					t.Fatal("number not saved")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionUpdatePhone, "target_id": f.user.user.ID.Hex()}); n != 1 {
+					t.Fatal("change not audited")
+				}
+			},
+		},
+		{name: "malformed body", as: "user", method: "POST", target: "/user/phone/verify", body: `{`, status: http.StatusBadRequest},
+		{name: "missing code", as: "user", method: "POST", target: "/user/phone/verify", body: `{}`, status: http.StatusBadRequest},
+		{name: "wrong code", as: "user", method: "POST", target: "/user/phone/verify", body: `{"code":"wrong"}`, setup: phoneCode, status: http.StatusBadRequest},
+		{
+			name: "code of another user", as: "admin", method: "POST", target: "/user/phone/verify", body: `{"code":"{code}"}`,
+			setup: phoneCode, status: http.StatusBadRequest,
+		},
+		{
+			name: "number taken since", as: "user", method: "POST", target: "/user/phone/verify", body: `{"code":"{code}"}`,
+			setup: setups(phoneCode, func(t *testing.T, f *fixture) {
+				if _, err := database.DB.Collection("users").UpdateOne(context.Background(), bson.M{"_id": f.admin.user.ID}, bson.M{"$set": bson.M{"phone_hash": utils.PhoneIndex(testPhone)}}); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusConflict,
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/user/phone/verify", body: `{"code":"123456"}`, status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "POST", target: "/user/phone/verify", body: `{"code":"{code}"}`, setup: setups(phoneCode, dbDown("otp_codes")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRemovePhone(t *testing.T) {
+	runCases(t, http.HandlerFunc(RemovePhone), []handlerCase{
+		{
+			name: "removes the number", as: "user", method: "DELETE", target: "/user/phone",
+			setup:  phoneVerified,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"phone_hash": bson.M{"$exists": true}}); n != 0 {
+					t.Fatal("number not removed")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionUpdatePhone, "target_id": f.user.user.ID.Hex()}); n != 1 {
+					t.Fatal("change not audited")
+				}
+			},
+		},
+		{name: "no number", as: "user", method: "DELETE", target: "/user/phone", status: http.StatusNotFound},
+		{name: "no session", as: "guest", method: "DELETE", target: "/user/phone", status: http.StatusUnauthorized},
+		{name: "deleted after sign-in", as: "user", method: "DELETE", target: "/user/phone", setup: userDeleted, status: http.StatusNotFound},
+	})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fullUserReads are the functions allowed to read whole user documents,
+// with the reason they need every field
+var fullUserReads = map[string]string{
+	"handlers.activateUser": "renders the activation email and delivers it by the user's locale and notification settings",
+}
+
+// userReadViolations parses the non-test sources of dir and reports user
+// reads without a projection: repository.FindUser and FindUserByEmailHash
+// calls without options, and FindOne, FindOneAndUpdate and Find results
+// decoded into a models.User without options, outside fullUserReads
+func userReadViolations(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var found []string
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			where := file.Name.Name + "." + fn.Name.Name
+			if _, ok := fullUserReads[where]; ok {
+				continue
+			}
+			for _, pos := range unprojectedUserReads(fn.Body) {
+				found = append(found, fmt.Sprintf("%s (%s)", fset.Position(pos), where))
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// unprojectedUserReads returns the positions of user reads in body that
+// fetch whole documents
+func unprojectedUserReads(body *ast.BlockStmt) []token.Pos {
+	users := map[string]bool{}   // variables holding models.User values
+	cursors := map[string]bool{} // cursors of unprojected finds
+	var found []token.Pos
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			if isUserType(n.Type) {
+				for _, name := range n.Names {
+					users[name.Name] = true
+				}
+			}
+		case *ast.AssignStmt:
+			// cursor, err := collection.Find(ctx, filter)
+			if call, ok := n.Rhs[0].(*ast.CallExpr); ok && len(n.Lhs) > 0 && method(call) == "Find" && len(call.Args) < 3 {
+				if ident, ok := n.Lhs[0].(*ast.Ident); ok {
+					cursors[ident.Name] = true
+				}
+			}
+		case *ast.CallExpr:
+			if pkg, name := qualified(n); pkg == "repository" && (name == "FindUser" || name == "FindUserByEmailHash") && len(n.Args) < 3 {
+				found = append(found, n.Pos())
+				return true
+			}
+			switch method(n) {
+			case "Decode", "All":
+				target := n.Args[len(n.Args)-1]
+				if !users[addressed(target)] {
+					return true
+				}
+				recv := n.Fun.(*ast.SelectorExpr).X
+				if inner, ok := recv.(*ast.CallExpr); ok {
+					switch method(inner) {
+					case "FindOne":
+						if len(inner.Args) < 3 {
+							found = append(found, n.Pos())
+						}
+					case "FindOneAndUpdate":
+						if len(inner.Args) < 4 {
+							found = append(found, n.Pos())
+						}
+					}
+				} else if ident, ok := recv.(*ast.Ident); ok && cursors[ident.Name] {
+					found = append(found, n.Pos())
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// isUserType reports whether expr is models.User or a slice of it
+func isUserType(expr ast.Expr) bool {
+	if slice, ok := expr.(*ast.ArrayType); ok {
+		expr = slice.Elt
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "models" && sel.Sel.Name == "User"
+}
+
+// method returns the name of the method or function call invokes
+func method(call *ast.CallExpr) string {
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	return ""
+}
+
+// qualified returns the package and name of a pkg.Func call
+func qualified(call *ast.CallExpr) (string, string) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", ""
+	}
+	return pkg.Name, sel.Sel.Name
+}
+
+// addressed returns the variable name of &name
+func addressed(expr ast.Expr) string {
+	unary, ok := expr.(*ast.UnaryExpr)
+	if !ok || unary.Op != token.AND {
+		return ""
+	}
+	ident, ok := unary.X.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	return ident.Name
+}
+
+func TestUnprojectedUserReads(t *testing.T) {
+	src := `package p
+func f() {
+	repository.FindUser(ctx, filter)
+	repository.FindUser(ctx, filter, repository.IDOnly)
+	var user models.User
+	collection.FindOne(ctx, filter).Decode(&user)
+	collection.FindOne(ctx, filter, opts).Decode(&user)
+	collection.FindOneAndUpdate(ctx, filter, update).Decode(&user)
+	var users []models.User
+	cursor, err := collection.Find(ctx, filter)
+	cursor.All(ctx, &users)
+	var org models.Organization
+	collection.FindOne(ctx, filter).Decode(&org)
+}`
+	file, err := parser.ParseFile(token.NewFileSet(), "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	if found := unprojectedUserReads(body); len(found) != 4 {
+		t.Fatalf("found %d unprojected reads, want 4", len(found))
+	}
+}
+
+func TestUserReadsAreProjected(t *testing.T) {
+	for _, dir := range []string{".", "../middleware"} {
+		for _, violation := range userReadViolations(t, dir) {
+			t.Errorf("%s reads a whole user document; pass a projection such as repository.Fields, or list the function in fullUserReads with the reason it needs every field", violation)
+		}
+	}
+}
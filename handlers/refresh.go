@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// RefreshRequest represents the request payload for refreshing a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+}
+
+// sessionTTLs returns the lifetimes of session and refresh tokens, and
+// whether the remember me policy applies
+func sessionTTLs(cfg *config.Config, remember bool) (access, refresh time.Duration, remembered bool) {
+	if remember && cfg.RememberMeRefreshTokenTTL > 0 {
+		return cfg.RememberMeAccessTokenTTL, cfg.RememberMeRefreshTokenTTL, true
+	}
+	return cfg.AccessTokenTTL, cfg.RefreshTokenTTL, false
+}
+
+// issueSession signs a session token for a user and a refresh token in the
+// given family, or in a new one when family is empty. Remembered sessions
+// get the longer lifetimes of REMEMBER_ME_ACCESS_TOKEN_TTL and
+// REMEMBER_ME_REFRESH_TOKEN_TTL.
+func issueSession(ctx context.Context, cfg *config.Config, user *models.User, client tokens.Client, family string, remember bool) (*LoginResponse, error) {
+	accessTTL, refreshTTL, remember := sessionTTLs(cfg, remember)
+	claims, err := sessionClaims(ctx, cfg, user, client, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	jti, _ := claims["jti"].(string)
+	refresh, err := tokens.IssueRefresh(ctx, user.ID.Hex(), family, jti, refreshTTL, remember, client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		Role:         user.Role,
+	}, nil
+}
+
+// RefreshToken handles session refresh
+// @Summary Refresh session
+// @Description Exchange a refresh token for a new session token and a new refresh token. Each refresh token works once; presenting a used one again revokes every token descended from the same sign-in. Sessions signed in with remember_me keep their longer lifetimes
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh token"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid refresh token"
+// @Failure 403 {string} string "Account suspended"
+// @Failure 429 {string} string "Too many attempts from this client"
+// @Failure 500 {string} string "Internal server error"
+// @Router /token/refresh [post]
+func RefreshToken(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		record, err := tokens.Redeem(ctx, req.RefreshToken)
+		switch {
+		case errors.Is(err, tokens.ErrRefreshReuse):
+			security.Emit(r, security.EventTokenReplay, security.OutcomeFailure, record.UserID, "refresh token reused, session family "+record.FamilyID+" revoked")
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		case errors.Is(err, tokens.ErrInvalidRefresh):
+			security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid refresh token")
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, "Failed to verify refresh token", http.StatusInternalServerError)
+			return
+		}
+
+		// The account may have changed since sign-in
+		userID, err := primitive.ObjectIDFromHex(record.UserID)
+		if err != nil {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.CredentialFields)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, record.UserID, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), record.FamilyID, record.Remember)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		// The access token of the rotated refresh token is no longer needed
+		if err := tokens.Supersede(ctx, record.AccessID); err != nil {
+			correlation.Errorf(ctx, "Failed to mark token %s as replaced: %v", record.AccessID, err)
+		}
+
+		correlation.SetUser(ctx, record.UserID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// refreshToken signs the user in and sets {refresh} to the refresh token
+func refreshToken(t *testing.T, f *fixture) {
+	issued, err := issueSession(context.Background(), testConfig, f.user.user, testClient, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("refresh", issued.RefreshToken)
+}
+
+func TestRefreshToken(t *testing.T) {
+	refresh := func(token string) string { return `{"refresh_token":"` + token + `"}` }
+	runCases(t, RefreshToken(testConfig), []handlerCase{
+		{
+			name: "rotates the refresh token", method: "POST", target: "/token/refresh", body: refresh("{refresh}"),
+			setup:  refreshToken,
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var session LoginResponse
+				json.Unmarshal(rec.Body.Bytes(), &session)
+				if session.Token == "" || session.RefreshToken == "" || session.RefreshToken == f.vars["refresh"] || session.Role != "user" {
+					t.Fatalf("got %+v", session)
+				}
+				checkToken(t, session.Token, http.StatusOK)
+
+				// Presenting the rotated token again ends the sign-in
+				if rec := request(RefreshToken(testConfig), "POST", "/token/refresh", "", refresh(f.vars["refresh"])); rec.Code != http.StatusUnauthorized {
+					t.Fatalf("reused: got %d", rec.Code)
+				}
+				if rec := request(RefreshToken(testConfig), "POST", "/token/refresh", "", refresh(session.RefreshToken)); rec.Code != http.StatusUnauthorized {
+					t.Fatalf("successor after reuse: got %d", rec.Code)
+				}
+				checkToken(t, session.Token, http.StatusUnauthorized)
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/token/refresh", body: `{"refresh_token":1}`, status: http.StatusBadRequest},
+		{name: "missing token", method: "POST", target: "/token/refresh", body: `{}`, status: http.StatusBadRequest},
+		{name: "unknown token", method: "POST", target: "/token/refresh", body: refresh("unknown"), setup: refreshToken, status: http.StatusUnauthorized},
+		{
+			name: "suspended", method: "POST", target: "/token/refresh", body: refresh("{refresh}"),
+			setup:  setups(refreshToken, func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"suspended": true}) }),
+			status: http.StatusForbidden,
+		},
+		{name: "deleted since", method: "POST", target: "/token/refresh", body: refresh("{refresh}"), setup: setups(refreshToken, userDeleted), status: http.StatusUnauthorized},
+		{name: "database down", method: "POST", target: "/token/refresh", body: refresh("{refresh}"), setup: setups(refreshToken, dbDown("refresh_tokens")), status: http.StatusInternalServerError},
+	})
+}
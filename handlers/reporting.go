@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang-backend/correlation"
+	"golang-backend/reporting"
+)
+
+// serveReport writes the stored snapshot of a report; ?refresh=true computes
+// it first
+func serveReport(w http.ResponseWriter, r *http.Request, name string, out interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	refresh := r.URL.Query().Get("refresh") == "true"
+	if err := reporting.Load(r.Context(), name, refresh, out); err != nil {
+		correlation.Errorf(r.Context(), "Failed to load %s report: %v", name, err)
+		http.Error(w, `{"error": "Failed to load report"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// @Summary Signup report
+// @Description New user accounts per day over the last 90 days, in total and per data residency region. Computed once a day; refresh=true recomputes it (Admin only)
+// @Tags admin
+// @Produce json
+// @Param refresh query bool false "Recompute the report instead of returning the daily snapshot"
+// @Security BearerAuth
+// @Success 200 {object} reporting.SignupReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/signups [get]
+func SignupReport(w http.ResponseWriter, r *http.Request) {
+	serveReport(w, r, reporting.Signups, &reporting.SignupReport{})
+}
+
+// @Summary Retention report
+// @Description Weekly signup cohorts of the last 12 weeks with the number and percentage of users who sent analytics events while signed in during each week since signup. Computed once a day; refresh=true recomputes it (Admin only)
+// @Tags admin
+// @Produce json
+// @Param refresh query bool false "Recompute the report instead of returning the daily snapshot"
+// @Security BearerAuth
+// @Success 200 {object} reporting.RetentionReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/retention [get]
+func RetentionReport(w http.ResponseWriter, r *http.Request) {
+	serveReport(w, r, reporting.Retention, &reporting.RetentionReport{})
+}
+
+// @Summary Active users report
+// @Description Daily, weekly and monthly active users, and active users per day over the last 30 days, counted from analytics events sent while signed in. Computed once a day; refresh=true recomputes it (Admin only)
+// @Tags admin
+// @Produce json
+// @Param refresh query bool false "Recompute the report instead of returning the daily snapshot"
+// @Security BearerAuth
+// @Success 200 {object} reporting.ActiveUsersReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/active-users [get]
+func ActiveUsersReport(w http.ResponseWriter, r *http.Request) {
+	serveReport(w, r, reporting.ActiveUsers, &reporting.ActiveUsersReport{})
+}
+
+// @Summary Duplicate accounts report
+// @Description Probable duplicate accounts: the same email blind index in several regions (high confidence), Gmail aliases of one mailbox while EMAIL_FOLD_ALIASES is off (medium), and sign-ins from the same IP address and user agent over 30 days (low; devices shared by more than 5 accounts are ignored). Each group suggests keeping the oldest active account and merging the others into it. Computed once a day; refresh=true recomputes it (Admin only)
+// @Tags admin
+// @Produce json
+// @Param refresh query bool false "Recompute the report instead of returning the daily snapshot"
+// @Security BearerAuth
+// @Success 200 {object} reporting.DuplicatesReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/duplicates [get]
+func DuplicatesReport(w http.ResponseWriter, r *http.Request) {
+	serveReport(w, r, reporting.Duplicates, &reporting.DuplicatesReport{})
+}
+
+// @Summary Custom fields report
+// @Description How many users set each custom field, with its 20 most common values, or the minimum, maximum and mean of number fields. Values of encrypted fields are only counted. Computed once a day; refresh=true recomputes it (Admin only)
+// @Tags admin
+// @Produce json
+// @Param refresh query bool false "Recompute the report instead of returning the daily snapshot"
+// @Security BearerAuth
+// @Success 200 {object} reporting.CustomFieldsReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/custom-fields [get]
+func CustomFieldsReport(w http.ResponseWriter, r *http.Request) {
+	serveReport(w, r, reporting.CustomFields, &reporting.CustomFieldsReport{})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/database"
+	"golang-backend/reporting"
+)
+
+// reportGeneratedAt is when reportSnapshot's reports were generated
+var reportGeneratedAt = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// reportSnapshot stores an empty snapshot of the named report, as the daily
+// job would have
+func reportSnapshot(name string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		raw, err := bson.Marshal(bson.M{"generated_at": reportGeneratedAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := bson.M{"_id": name, "generated_at": reportGeneratedAt, "report": bson.Raw(raw)}
+		if _, err := database.DB.Collection("report_snapshots").InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReports(t *testing.T) {
+	stored := `"generated_at":"` + reportGeneratedAt.Format(time.RFC3339) + `"`
+	served := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		if !strings.Contains(rec.Body.String(), stored) {
+			t.Fatalf("got %s", rec.Body.String())
+		}
+	}
+	computed := func(name string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			if strings.Contains(rec.Body.String(), stored) {
+				t.Fatalf("got the stored snapshot %s", rec.Body.String())
+			}
+			if n := f.srv.Count("report_snapshots", bson.M{"_id": name, "generated_at": bson.M{"$gt": reportGeneratedAt}}); n != 1 {
+				t.Fatal("computed report not stored")
+			}
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		h      http.HandlerFunc
+		target string
+		// compute is false for reports that need the configuration
+		// reporting.Init sets, which would start the daily job
+		compute bool
+	}{
+		{reporting.Signups, SignupReport, "/admin/reports/signups", true},
+		{reporting.Retention, RetentionReport, "/admin/reports/retention", true},
+		{reporting.ActiveUsers, ActiveUsersReport, "/admin/reports/active-users", true},
+		{reporting.Duplicates, DuplicatesReport, "/admin/reports/duplicates", false},
+		{reporting.CustomFields, CustomFieldsReport, "/admin/reports/custom-fields", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cases := []handlerCase{
+				{name: "snapshot", as: "admin", method: "GET", target: tc.target, setup: reportSnapshot(tc.name), status: http.StatusOK, check: served},
+				{name: "database down", as: "admin", method: "GET", target: tc.target, setup: setups(reportSnapshot(tc.name), dbDown("report_snapshots")), status: http.StatusInternalServerError},
+			}
+			if tc.compute {
+				cases = append(cases,
+					handlerCase{name: "no snapshot", as: "admin", method: "GET", target: tc.target, status: http.StatusOK, check: computed(tc.name)},
+					handlerCase{name: "refreshed", as: "admin", method: "GET", target: tc.target + "?refresh=true", setup: reportSnapshot(tc.name), status: http.StatusOK, check: computed(tc.name)},
+				)
+			}
+			runCases(t, tc.h, cases)
+		})
+	}
+}
+
+func TestSignupReport(t *testing.T) {
+	f := newFixture(t)
+	rec := f.admin.do(http.HandlerFunc(SignupReport), "GET", "/admin/reports/signups", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	var report reporting.SignupReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	// The admin is not a signup
+	today := report.Days[len(report.Days)-1]
+	if report.Total != 1 || report.Regions["default"] != 1 || len(report.Days) != 90 || today.Signups != 1 {
+		t.Fatalf("got %+v", report)
+	}
+}
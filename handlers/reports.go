@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/apikeys"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// CreateReportRequest represents the request for reporting an abusive account
+type CreateReportRequest struct {
+	ReportedUserID string `json:"reported_user_id"`
+	Reason         string `json:"reason" example:"spam"`
+	Details        string `json:"details,omitempty"`
+}
+
+// CreateReportResponse represents the response for a submitted report
+type CreateReportResponse struct {
+	Message  string `json:"message"`
+	ReportID string `json:"report_id"`
+}
+
+// UpdateReportStatusRequest represents a moderation status change
+type UpdateReportStatusRequest struct {
+	Status  string `json:"status" example:"actioned"`
+	Note    string `json:"note,omitempty"`
+	Suspend bool   `json:"suspend,omitempty"`
+}
+
+// ListReportsResponse represents the moderation queue
+type ListReportsResponse struct {
+	Reports []models.AbuseReport `json:"reports"`
+	Total   int                  `json:"total"`
+}
+
+// @Summary Report an abusive account
+// @Description Report another user for abuse. Reasons: spam, harassment, impersonation, inappropriate, other
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body CreateReportRequest true "Abuse report"
+// @Security BearerAuth
+// @Success 201 {object} CreateReportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /report [post]
+func CreateReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reporterID := audit.ActorID(r)
+
+	var req CreateReportRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if !models.ReportReasons[req.Reason] {
+		http.Error(w, `{"error": "Invalid reason. Must be one of spam, harassment, impersonation, inappropriate, other"}`, http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Details) > 2000 {
+		http.Error(w, `{"error": "Details must be at most 2000 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	reportedID, err := primitive.ObjectIDFromHex(req.ReportedUserID)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.ReportedUserID == reporterID {
+		http.Error(w, `{"error": "You cannot report yourself"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := repository.LocateUser(ctx, reportedID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	now := clock.Now()
+	report := models.AbuseReport{
+		ID:             clock.NewID(),
+		ReporterID:     reporterID,
+		ReportedUserID: req.ReportedUserID,
+		Reason:         req.Reason,
+		Details:        strings.TrimSpace(req.Details),
+		Status:         models.ReportOpen,
+		History:        []models.ReportEvent{{Status: models.ReportOpen, ActorID: reporterID, At: now}},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if _, err := database.DB.Collection("abuse_reports").InsertOne(ctx, report); err != nil {
+		http.Error(w, `{"error": "Failed to submit report"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateReportResponse{Message: "Report submitted", ReportID: report.ID.Hex()})
+}
+
+// @Summary List abuse reports
+// @Description Moderation queue of abuse reports, oldest first (Admin only)
+// @Tags admin
+// @Produce json
+// @Param status query string false "Filter by status (open, reviewing, actioned, dismissed)" default(open)
+// @Param user_id query string false "Filter by reported user ID"
+// @Security BearerAuth
+// @Success 200 {object} ListReportsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports [get]
+func ListReports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter := bson.M{}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReportOpen
+	}
+	if status != "all" {
+		filter["status"] = status
+	}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		filter["reported_user_id"] = userID
+	}
+
+	collection := database.DB.Collection("abuse_reports")
+	ctx := context.Background()
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to count reports"}`, http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": 1}).SetLimit(100)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch reports"}`, http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.AbuseReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		http.Error(w, `{"error": "Failed to decode reports"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListReportsResponse{Reports: reports, Total: int(total)})
+}
+
+// @Summary Update abuse report status
+// @Description Move a report through the moderation workflow (open -> reviewing -> actioned/dismissed). Set suspend when actioning to suspend the reported account (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Report ID"
+// @Param request body UpdateReportStatusRequest true "Status change"
+// @Security BearerAuth
+// @Success 200 {object} models.AbuseReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reports/{id}/status [put]
+func UpdateReportStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid report ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateReportStatusRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Suspend && req.Status != models.ReportActioned {
+		http.Error(w, `{"error": "Accounts can only be suspended when actioning a report"}`, http.StatusBadRequest)
+		return
+	}
+
+	collection := database.DB.Collection("abuse_reports")
+	ctx := context.Background()
+
+	var report models.AbuseReport
+	if err := collection.FindOne(ctx, bson.M{"_id": reportID}).Decode(&report); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Report not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to fetch report"}`, http.StatusInternalServerError)
+		return
+	}
+
+	allowed := false
+	for _, next := range models.ReportTransitions[report.Status] {
+		if next == req.Status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		http.Error(w, `{"error": "Invalid status transition from `+report.Status+` to `+req.Status+`"}`, http.StatusConflict)
+		return
+	}
+
+	if req.Suspend {
+		if status, msg := suspendUser(r, report.ReportedUserID, "abuse report "+report.ID.Hex()); status != http.StatusOK {
+			http.Error(w, `{"error": "`+msg+`"}`, status)
+			return
+		}
+	}
+
+	now := clock.Now()
+	event := models.ReportEvent{Status: req.Status, ActorID: audit.ActorID(r), Note: req.Note, Suspended: req.Suspend, At: now}
+
+	// Only apply the change if nobody moved the report in the meantime
+	filter := bson.M{"_id": reportID, "status": report.Status}
+	update := bson.M{
+		"$set":  bson.M{"status": req.Status, "updated_at": now},
+		"$push": bson.M{"history": event},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&report); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Report was updated by someone else"}`, http.StatusConflict)
+			return
+		}
+		http.Error(w, `{"error": "Failed to update report"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionReportStatus, report.ID.Hex(), nil, bson.M{"status": req.Status, "note": req.Note, "suspended": req.Suspend}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit report %s: %v", report.ID.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// suspendUser blocks a user's login, revokes their sessions and API keys, and
+// records the suspension in the audit log
+func suspendUser(r *http.Request, userIDStr, reason string) (int, string) {
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return http.StatusBadRequest, "Invalid user ID format"
+	}
+
+	now := clock.Now()
+	update := bson.M{"$set": bson.M{
+		"suspended":         true,
+		"suspended_at":      now,
+		"suspension_reason": reason,
+		"updated_at":        now,
+	}}
+
+	ctx := context.Background()
+	users, err := repository.LocateUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return http.StatusNotFound, "User not found"
+	} else if err != nil {
+		return http.StatusInternalServerError, "Failed to locate user"
+	}
+
+	result, err := users.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to suspend user"
+	}
+	if result.MatchedCount == 0 {
+		return http.StatusNotFound, "User not found"
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	if _, err := tokens.RevokeUser(ctx, userIDStr); err != nil {
+		correlation.Errorf(r.Context(), "Failed to revoke sessions of suspended user %s: %v", userIDStr, err)
+	}
+	if _, err := apikeys.RevokeAll(ctx, userID); err != nil {
+		correlation.Errorf(r.Context(), "Failed to revoke API keys of suspended user %s: %v", userIDStr, err)
+	}
+
+	if _, err := audit.Record(r, audit.ActionSuspendUser, userIDStr, bson.M{"suspended": false}, bson.M{"suspended": true, "reason": reason}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit suspension of user %s: %v", userIDStr, err)
+	}
+
+	return http.StatusOK, ""
+}
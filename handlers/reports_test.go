@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+func TestSuspensionEndsSessions(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	userID := f.user.user.ID
+	reportID := primitive.NewObjectID()
+	database.DB.Collection("abuse_reports").InsertOne(ctx, models.AbuseReport{ID: reportID, ReportedUserID: userID.Hex(), Status: models.ReportOpen, CreatedAt: clock.Now()})
+	database.DB.Collection("api_keys").InsertOne(ctx, bson.M{"user_id": userID, "revoked_at": nil})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/reports/{id}/status", UpdateReportStatus)
+	profile := GetUserProfile(testConfig)
+
+	// The user's session is in use, so its role check is cached
+	if rec := f.user.do(profile, "GET", "/user/profile", ""); rec.Code != http.StatusOK {
+		t.Fatalf("profile before suspension: got %d", rec.Code)
+	}
+	rec := f.admin.do(router, "PUT", "/admin/reports/"+reportID.Hex()+"/status", `{"status":"actioned","suspend":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("suspend: got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := f.user.do(profile, "GET", "/user/profile", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("existing token after suspension: got %d, want 401", rec.Code)
+	}
+	for _, collection := range []string{"token_ids", "refresh_tokens", "api_keys"} {
+		if n := f.srv.Count(collection, bson.M{"user_id": bson.M{"$in": bson.A{userID, userID.Hex()}}, "revoked_at": nil}); n != 0 {
+			t.Errorf("%d %s of the suspended user are still active", n, collection)
+		}
+	}
+}
+
+func TestSuspendedUsersTokensAreRejected(t *testing.T) {
+	f := newFixture(t)
+	// Suspended without its tokens being revoked, as by an older instance
+	setUser(t, f, bson.M{"suspended": true})
+	if rec := signIn(t, f.user.user).do(GetUserProfile(testConfig), "GET", "/user/profile", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", rec.Code)
+	}
+}
+
+// abuseReport stores the admin's report about the signed-in user as {report}
+func abuseReport(status string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		now := clock.Now()
+		report := models.AbuseReport{
+			ID:             primitive.NewObjectID(),
+			ReporterID:     f.admin.user.ID.Hex(),
+			ReportedUserID: f.user.user.ID.Hex(),
+			Reason:         "spam",
+			Status:         status,
+			History:        []models.ReportEvent{{Status: models.ReportOpen, ActorID: f.admin.user.ID.Hex(), At: now}},
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if _, err := database.DB.Collection("abuse_reports").InsertOne(context.Background(), report); err != nil {
+			t.Fatal(err)
+		}
+		f.set("report", report.ID.Hex())
+	}
+}
+
+// adminID sets {admin} to the signed-in admin's ID
+func adminID(t *testing.T, f *fixture) {
+	f.set("admin", f.admin.user.ID.Hex())
+}
+
+func TestCreateReport(t *testing.T) {
+	h := http.HandlerFunc(CreateReport)
+	runCases(t, h, []handlerCase{
+		{
+			name: "submitted", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{admin}","reason":"harassment","details":"  rude messages "}`,
+			setup:  adminID,
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp CreateReportResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				id, _ := primitive.ObjectIDFromHex(resp.ReportID)
+				filter := bson.M{
+					"_id": id, "reporter_id": f.user.user.ID.Hex(), "reported_user_id": f.admin.user.ID.Hex(),
+					"reason": "harassment", "details": "rude messages", "status": models.ReportOpen, "history.status": models.ReportOpen,
+				}
+				if n := f.srv.Count("abuse_reports", filter); n != 1 {
+					t.Fatalf("report not stored: %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "yourself", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{user}","reason":"spam"}`, status: http.StatusBadRequest},
+		{name: "unknown reason", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{missing}","reason":"rudeness"}`, status: http.StatusBadRequest},
+		{name: "details too long", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{missing}","reason":"other","details":"` + strings.Repeat("x", 2001) + `"}`, status: http.StatusBadRequest},
+		{name: "invalid user ID", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"nope","reason":"spam"}`, status: http.StatusBadRequest},
+		{name: "unknown user", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{missing}","reason":"spam"}`, status: http.StatusNotFound},
+		{name: "malformed body", as: "user", method: "POST", target: "/report", body: `{"reason":`, status: http.StatusBadRequest},
+		{name: "signed out", as: "guest", method: "POST", target: "/report", body: `{"reported_user_id":"{user}","reason":"spam"}`, status: http.StatusUnauthorized},
+		{
+			name: "database down", as: "user", method: "POST", target: "/report", body: `{"reported_user_id":"{admin}","reason":"spam"}`,
+			setup:  setups(adminID, dbDown("abuse_reports")),
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestListReports(t *testing.T) {
+	h := http.HandlerFunc(ListReports)
+	listed := func(want ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ListReportsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			var got []string
+			for _, report := range resp.Reports {
+				got = append(got, report.Status)
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") || resp.Total != len(want) || resp.Reports == nil {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+		}
+	}
+	reports := setups(abuseReport(models.ReportDismissed), abuseReport(models.ReportOpen), abuseReport(models.ReportReviewing))
+	runCases(t, h, []handlerCase{
+		{name: "open by default", as: "admin", method: "GET", target: "/admin/reports", setup: reports, status: http.StatusOK, check: listed(models.ReportOpen)},
+		{name: "by status", as: "admin", method: "GET", target: "/admin/reports?status=reviewing", setup: reports, status: http.StatusOK, check: listed(models.ReportReviewing)},
+		{name: "all", as: "admin", method: "GET", target: "/admin/reports?status=all", setup: reports, status: http.StatusOK, check: listed(models.ReportDismissed, models.ReportOpen, models.ReportReviewing)},
+		{name: "by reported user", as: "admin", method: "GET", target: "/admin/reports?status=all&user_id={missing}", setup: reports, status: http.StatusOK, check: listed()},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/reports", setup: dbDown("abuse_reports"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUpdateReportStatus(t *testing.T) {
+	h := route("/admin/reports/{id}/status", http.HandlerFunc(UpdateReportStatus))
+	moved := func(status string, suspended bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var report models.AbuseReport
+			json.Unmarshal(rec.Body.Bytes(), &report)
+			last := report.History[len(report.History)-1]
+			if report.Status != status || len(report.History) != 2 || last.Status != status || last.ActorID != f.admin.user.ID.Hex() || last.Suspended != suspended {
+				t.Fatalf("got %+v", report)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionReportStatus, "target_id": report.ID.Hex(), "after.status": status}); n != 1 {
+				t.Fatal("status change not audited")
+			}
+			if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "suspended": true}); (n == 1) != suspended {
+				t.Fatalf("suspended is %v, want %v", n == 1, suspended)
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "reviewing", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"reviewing","note":"looking"}`, setup: abuseReport(models.ReportOpen), status: http.StatusOK, check: moved(models.ReportReviewing, false)},
+		{
+			name: "actioned with suspension", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"actioned","suspend":true}`,
+			setup:  abuseReport(models.ReportReviewing),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				moved(models.ReportActioned, true)(t, f, rec)
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionSuspendUser, "target_id": f.user.user.ID.Hex(), "after.reason": "abuse report " + f.vars["report"]}); n != 1 {
+					t.Fatal("suspension not audited")
+				}
+			},
+		},
+		{name: "suspension without actioning", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"dismissed","suspend":true}`, setup: abuseReport(models.ReportOpen), status: http.StatusBadRequest},
+		{name: "from a final status", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"open"}`, setup: abuseReport(models.ReportDismissed), status: http.StatusConflict},
+		{name: "unknown status", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"closed"}`, setup: abuseReport(models.ReportOpen), status: http.StatusConflict},
+		{name: "reported user gone", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"actioned","suspend":true}`, setup: setups(abuseReport(models.ReportOpen), userDeleted), status: http.StatusNotFound},
+		{name: "unknown report", as: "admin", method: "PUT", target: "/admin/reports/{missing}/status", body: `{"status":"reviewing"}`, status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "PUT", target: "/admin/reports/nope/status", body: `{"status":"reviewing"}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":`, setup: abuseReport(models.ReportOpen), status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/reports/{report}/status", body: `{"status":"reviewing"}`, setup: setups(abuseReport(models.ReportOpen), dbDown("abuse_reports")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/security"
+)
+
+// RequestDetailsResponse gathers everything recorded for one request
+type RequestDetailsResponse struct {
+	RequestID      string             `json:"request_id" example:"9f2c4e1a7b3d5f60a1b2c3d4"`
+	Trace          *correlation.Trace `json:"trace"`
+	AuditEntries   []models.AuditLog  `json:"audit_entries"`
+	SecurityEvents []security.Event   `json:"security_events"`
+}
+
+// @Summary Get request details
+// @Description Show what one request produced, by the ID returned in its X-Request-ID header: the stored trace with its log lines and errors (kept only for requests that logged something or failed), and the audit entries and security events it created (Admin only)
+// @Tags admin
+// @Produce json
+// @Param request_id path string true "Request ID"
+// @Security BearerAuth
+// @Success 200 {object} RequestDetailsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/requests/{request_id} [get]
+func RequestDetails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := mux.Vars(r)["request_id"]
+
+	trace, err := correlation.Get(r.Context(), requestID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, `{"error": "Failed to fetch trace"}`, http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	filter := bson.M{"request_id": requestID}
+
+	entries := []models.AuditLog{}
+	cursor, err := database.DB.Collection("audit_logs").Find(r.Context(), filter, opts)
+	if err == nil {
+		err = cursor.All(r.Context(), &entries)
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch audit entries"}`, http.StatusInternalServerError)
+		return
+	}
+
+	events := []security.Event{}
+	cursor, err = database.DB.Collection("security_events").Find(r.Context(), filter, opts)
+	if err == nil {
+		err = cursor.All(r.Context(), &events)
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch security events"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if trace == nil && len(entries) == 0 && len(events) == 0 {
+		http.Error(w, `{"error": "Request not found"}`, http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(RequestDetailsResponse{
+		RequestID:      requestID,
+		Trace:          trace,
+		AuditEntries:   entries,
+		SecurityEvents: events,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/clock"
+	"golang-backend/correlation"
+	"golang-backend/database"
+	"golang-backend/security"
+)
+
+// testRequestID is the request requestRecords stores records of
+const testRequestID = "9f2c4e1a7b3d5f60a1b2c3d4"
+
+// requestRecords stores what testRequestID produced: a trace, an audit entry
+// and a security event, as far as they are named in parts
+func requestRecords(parts ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		ctx := context.Background()
+		now := clock.Now()
+		docs := map[string]interface{}{
+			"request_traces":  correlation.Trace{RequestID: testRequestID, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Method: "PUT", Path: "/admin/users/role", Status: 500, Lines: []correlation.Line{}, CreatedAt: now},
+			"audit_logs":      bson.M{"action": "user.role_update", "request_id": testRequestID, "created_at": now},
+			"security_events": security.Event{ID: clock.NewID(), Type: security.EventSudo, Outcome: security.OutcomeSuccess, RequestID: testRequestID, CreatedAt: now},
+		}
+		for _, name := range parts {
+			if _, err := database.DB.Collection(name).InsertOne(ctx, docs[name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRequestDetails(t *testing.T) {
+	h := route("/admin/requests/{request_id}", http.HandlerFunc(RequestDetails))
+	details := func(trace bool, entries, events int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp RequestDetailsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.RequestID != testRequestID || (resp.Trace != nil) != trace || len(resp.AuditEntries) != entries || len(resp.SecurityEvents) != events {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+			if resp.AuditEntries == nil || resp.SecurityEvents == nil {
+				t.Fatalf("lists missing: %s", rec.Body.String())
+			}
+		}
+	}
+	target := "/admin/requests/" + testRequestID
+	runCases(t, h, []handlerCase{
+		{name: "everything", as: "admin", method: "GET", target: target, setup: requestRecords("request_traces", "audit_logs", "security_events"), status: http.StatusOK, check: details(true, 1, 1)},
+		{name: "trace only", as: "admin", method: "GET", target: target, setup: requestRecords("request_traces"), status: http.StatusOK, check: details(true, 0, 0)},
+		{name: "not traced", as: "admin", method: "GET", target: target, setup: requestRecords("audit_logs", "security_events"), status: http.StatusOK, check: details(false, 1, 1)},
+		{name: "unknown request", as: "admin", method: "GET", target: target, status: http.StatusNotFound},
+		{name: "traces down", as: "admin", method: "GET", target: target, setup: setups(requestRecords("request_traces"), dbDown("request_traces")), status: http.StatusInternalServerError},
+		{name: "audit log down", as: "admin", method: "GET", target: target, setup: dbDown("audit_logs"), status: http.StatusInternalServerError},
+		{name: "security events down", as: "admin", method: "GET", target: target, setup: dbDown("security_events"), status: http.StatusInternalServerError},
+	})
+}
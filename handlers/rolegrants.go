@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang-backend/models"
+	"golang-backend/rolegrants"
+)
+
+// ListRoleGrantsResponse represents the response for listing role grants
+type ListRoleGrantsResponse struct {
+	Grants []models.RoleGrant `json:"grants"`
+}
+
+// @Summary List pending role expirations
+// @Description List temporary roles given with PUT /admin/users/role and expires_at that have not expired yet, the soonest to expire first. The previous role is restored within ROLE_GRANT_INTERVAL of the expiry, and the user and the admin webhooks are notified (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListRoleGrantsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/role-grants [get]
+func ListRoleGrants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	grants, err := rolegrants.Pending(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch role grants"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListRoleGrantsResponse{Grants: grants})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/rolegrants"
+)
+
+func TestListRoleGrants(t *testing.T) {
+	h := http.HandlerFunc(ListRoleGrants)
+	runCases(t, h, []handlerCase{
+		{
+			name: "soonest first", as: "admin", method: "GET", target: "/admin/role-grants",
+			setup: func(t *testing.T, f *fixture) {
+				ctx := context.Background()
+				later := clock.Now().Add(48 * time.Hour)
+				if _, err := rolegrants.Grant(ctx, f.admin.user.ID, "admin", "user", f.admin.user.ID.Hex(), later); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := rolegrants.Grant(ctx, f.user.user.ID, "admin", "user", f.admin.user.ID.Hex(), clock.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+				// Ended grants are not pending
+				ended := models.RoleGrant{ID: clock.NewID(), UserID: f.user.user.ID, Role: "admin", PreviousRole: "user", Status: models.RoleGrantSuperseded, ExpiresAt: later}
+				if _, err := database.DB.Collection("role_grants").InsertOne(ctx, ended); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ListRoleGrantsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Grants) != 2 || resp.Grants[0].UserID != f.user.user.ID || resp.Grants[1].UserID != f.admin.user.ID {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "none", as: "admin", method: "GET", target: "/admin/role-grants", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"grants":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/role-grants", setup: dbDown("role_grants"), status: http.StatusInternalServerError},
+	})
+}
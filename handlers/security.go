@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/security"
+)
+
+// SecurityEventsResponse represents a page of security events
+type SecurityEventsResponse struct {
+	Events     []security.Event `json:"events"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// @Summary Pull security events
+// @Description Incrementally pull security events for SIEM ingestion. Pass the returned next_cursor as cursor to continue; format=cef returns one CEF line per event (Admin only)
+// @Tags admin
+// @Produce json
+// @Produce text/plain
+// @Param cursor query string false "Return events after this cursor"
+// @Param type query string false "Filter by event type"
+// @Param limit query int false "Maximum events to return" default(100)
+// @Param format query string false "Response format (json or cef)"
+// @Security BearerAuth
+// @Success 200 {object} SecurityEventsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/security/events [get]
+func ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	if c := query.Get("cursor"); c != "" {
+		cursorID, err := primitive.ObjectIDFromHex(c)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = bson.M{"$gt": cursorID}
+	}
+
+	if t := query.Get("type"); t != "" {
+		filter["type"] = t
+	}
+
+	limit := 100
+	if l := query.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := database.DB.Collection("security_events").Find(ctx, filter, opts)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Failed to fetch security events"}`, http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	events := []security.Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Failed to decode security events"}`, http.StatusInternalServerError)
+		return
+	}
+
+	next := ""
+	if len(events) > 0 {
+		next = events[len(events)-1].ID.Hex()
+	}
+
+	if query.Get("format") == "cef" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		for _, event := range events {
+			w.Write([]byte(security.FormatCEF(event) + "\n"))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SecurityEventsResponse{Events: events, NextCursor: next})
+}
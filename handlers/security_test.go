@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/security"
+)
+
+// securityEvents stores an event of each type in order, setting {event} to
+// the ID of the first
+func securityEvents(types ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		for i, eventType := range types {
+			event := security.Event{ID: clock.NewID(), Type: eventType, Outcome: security.OutcomeFailure, UserID: f.user.user.ID.Hex(), IP: "203.0.113.7", Path: "/login", CreatedAt: clock.Now()}
+			if _, err := database.DB.Collection("security_events").InsertOne(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				f.set("event", event.ID.Hex())
+			}
+		}
+	}
+}
+
+func TestListSecurityEvents(t *testing.T) {
+	h := http.HandlerFunc(ListSecurityEvents)
+	events := securityEvents(security.EventLoginFailure, security.EventLockout, security.EventLoginFailure)
+	listed := func(want ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp SecurityEventsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			var got []string
+			for _, event := range resp.Events {
+				got = append(got, event.Type)
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") || resp.Events == nil {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+			if len(want) > 0 && resp.NextCursor != resp.Events[len(resp.Events)-1].ID.Hex() || len(want) == 0 && resp.NextCursor != "" {
+				t.Fatalf("next cursor %q", resp.NextCursor)
+			}
+		}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "all", as: "admin", method: "GET", target: "/admin/security/events", setup: events, status: http.StatusOK, check: listed(security.EventLoginFailure, security.EventLockout, security.EventLoginFailure)},
+		{name: "after cursor", as: "admin", method: "GET", target: "/admin/security/events?cursor={event}", setup: events, status: http.StatusOK, check: listed(security.EventLockout, security.EventLoginFailure)},
+		{name: "by type", as: "admin", method: "GET", target: "/admin/security/events?type=" + security.EventLockout, setup: events, status: http.StatusOK, check: listed(security.EventLockout)},
+		{name: "limited", as: "admin", method: "GET", target: "/admin/security/events?limit=1", setup: events, status: http.StatusOK, check: listed(security.EventLoginFailure)},
+		{name: "limit out of range", as: "admin", method: "GET", target: "/admin/security/events?limit=5000", setup: events, status: http.StatusOK, check: listed(security.EventLoginFailure, security.EventLockout, security.EventLoginFailure)},
+		{name: "none", as: "admin", method: "GET", target: "/admin/security/events", status: http.StatusOK, check: listed()},
+		{
+			name: "cef", as: "admin", method: "GET", target: "/admin/security/events?format=cef&limit=2", setup: events, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+				if len(lines) != 2 || !strings.HasPrefix(lines[0], "CEF:0|golang-backend|api|1.0|"+security.EventLoginFailure+"|") || !strings.Contains(lines[1], "|"+security.EventLockout+"|") {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+				next := rec.Header().Get("X-Next-Cursor")
+				if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || next == "" || !strings.Contains(lines[1], "externalId="+next) {
+					t.Fatalf("got headers %v", rec.Header())
+				}
+			},
+		},
+		{name: "invalid cursor", as: "admin", method: "GET", target: "/admin/security/events?cursor=nope", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/security/events", setup: dbDown("security_events"), status: http.StatusInternalServerError},
+	})
+}
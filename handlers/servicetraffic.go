@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/servicetraffic"
+)
+
+// ServiceTrafficResponse summarizes which services call which routes
+type ServiceTrafficResponse struct {
+	Service      string                      `json:"service" example:"golang-backend"`
+	SampleRate   float64                     `json:"sample_rate" example:"1"`
+	Since        time.Time                   `json:"since"`
+	Dependencies []servicetraffic.Dependency `json:"dependencies"`
+}
+
+// @Summary Summarize service-to-service traffic
+// @Description Group the recorded calls from other services by caller and route, with estimated call counts, errors, calls without a valid service token, and latency (Admin only)
+// @Tags admin
+// @Produce json
+// @Param window query string false "How far back to look, as a duration" default(24h)
+// @Security BearerAuth
+// @Success 200 {object} ServiceTrafficResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/service-traffic [get]
+func ServiceTraffic(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	window := 24 * time.Hour
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, `{"error": "Invalid window"}`, http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+
+	since := clock.Now().Add(-window)
+	dependencies, err := servicetraffic.Summarize(r.Context(), since)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to summarize service traffic"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ServiceTrafficResponse{
+		Service:      servicetraffic.Service(),
+		SampleRate:   servicetraffic.SampleRate(),
+		Since:        since,
+		Dependencies: dependencies,
+	})
+}
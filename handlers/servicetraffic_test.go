@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/servicetraffic"
+)
+
+// serviceCalls records calls of billing to GET /admin/users, one with each
+// status, made ago
+func serviceCalls(ago time.Duration, statuses ...int) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		outcomes := map[int]string{200: servicetraffic.OutcomeSuccess, 404: servicetraffic.OutcomeClientError, 503: servicetraffic.OutcomeServerError}
+		for i, status := range statuses {
+			call := servicetraffic.Call{
+				ID: clock.NewID(), Caller: "billing", Authenticated: status != 404, Target: "golang-backend",
+				Method: "GET", Route: "/admin/users", Status: status, Outcome: outcomes[status],
+				LatencyMs: float64(10 * (i + 1)), Weight: 2, CreatedAt: clock.Now().Add(-ago),
+			}
+			if _, err := database.DB.Collection("service_calls").InsertOne(context.Background(), call); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestServiceTraffic(t *testing.T) {
+	h := http.HandlerFunc(ServiceTraffic)
+	summarized := func(calls float64) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp ServiceTrafficResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Dependencies == nil || calls == 0 && len(resp.Dependencies) != 0 {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+			if calls == 0 {
+				return
+			}
+			d := resp.Dependencies[0]
+			if len(resp.Dependencies) != 1 || d.Caller != "billing" || d.Route != "/admin/users" || d.Calls != calls || d.Sampled != int(calls/2) {
+				t.Fatalf("got %+v", resp.Dependencies)
+			}
+			if d.ClientErrors != 2 || d.ServerErrors != 2 || d.Unauthenticated != 2 || d.AvgLatencyMs != 20 || d.MaxLatencyMs != 30 {
+				t.Fatalf("got %+v", d)
+			}
+		}
+	}
+	calls := setups(serviceCalls(time.Hour, 200, 404, 503), serviceCalls(48*time.Hour, 200))
+	runCases(t, h, []handlerCase{
+		{name: "last day", as: "admin", method: "GET", target: "/admin/service-traffic", setup: calls, status: http.StatusOK, check: summarized(6)},
+		{name: "window", as: "admin", method: "GET", target: "/admin/service-traffic?window=30m", setup: calls, status: http.StatusOK, check: summarized(0)},
+		{name: "invalid window", as: "admin", method: "GET", target: "/admin/service-traffic?window=-1h", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/service-traffic", setup: dbDown("service_calls"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"golang-backend/health"
+)
+
+// badgeColors maps statuses to badge colors
+var badgeColors = map[string]string{
+	health.StatusOperational: "#4c1",
+	health.StatusDegraded:    "#dfb317",
+	health.StatusOutage:      "#e05d44",
+	health.StatusDown:        "#e05d44",
+}
+
+// @Summary Public service status
+// @Description Aggregated health of the API and its components with uptime over the last 24 hours, 7 days and 30 days. Public and rate limited per IP; when a signing secret is configured the body's HMAC-SHA256 is sent in X-Signature-SHA256
+// @Tags status
+// @Produce json
+// @Success 200 {object} health.Report
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /status [get]
+func PublicStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	report, err := health.Current(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to compute status"}`, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to encode status"}`, http.StatusInternalServerError)
+		return
+	}
+	health.Sign(w, body)
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.Write(body)
+}
+
+// @Summary Status badge
+// @Description Embeddable SVG badge showing the current status, or with window set the uptime percentage, of the API or of one component. Public and rate limited per IP
+// @Tags status
+// @Produce image/svg+xml
+// @Param component query string false "Component name; the whole API when omitted"
+// @Param window query string false "Show uptime over 24h, 7d or 30d instead of the status"
+// @Success 200 {string} string "SVG badge"
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /status/badge.svg [get]
+func StatusBadge(w http.ResponseWriter, r *http.Request) {
+	report, err := health.Current(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Failed to compute status"}`, http.StatusInternalServerError)
+		return
+	}
+
+	label, status, uptime := "api", report.Status, report.Uptime
+	if name := r.URL.Query().Get("component"); name != "" {
+		found := false
+		for _, c := range report.Components {
+			if c.Name == name {
+				label, status, uptime, found = c.Name, c.Status, c.Uptime, true
+			}
+		}
+		if !found {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Unknown component"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	message, color := status, badgeColors[status]
+	if window := r.URL.Query().Get("window"); window != "" {
+		percent, ok := uptime[window]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "No uptime for window"}`, http.StatusNotFound)
+			return
+		}
+		label += " uptime " + window
+		message = fmt.Sprintf("%.2f%%", percent)
+		switch {
+		case percent >= 99.9:
+			color = badgeColors[health.StatusOperational]
+		case percent >= 99:
+			color = badgeColors[health.StatusDegraded]
+		default:
+			color = badgeColors[health.StatusOutage]
+		}
+	}
+
+	body := []byte(badgeSVG(label, message, color))
+	health.Sign(w, body)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Write(body)
+}
+
+// badgeSVG renders a flat two-part badge. Widths are estimated from the text
+// length, which is close enough for the short labels used here.
+func badgeSVG(label, message, color string) string {
+	lw, mw := 10+len(label)*7, 10+len(message)*7
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">`+
+		`<title>%[3]s: %[4]s</title>`+
+		`<rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="14">%[3]s</text><text x="%[8]d" y="14">%[4]s</text></g></svg>`,
+		lw+mw, lw, label, message, mw, color, lw/2, lw+mw/2)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/health"
+)
+
+// The report health computes is served for 30 seconds of wall time, so the
+// status endpoints are tested against a single report
+func TestStatus(t *testing.T) {
+	f := newFixture(t)
+	f.srv.Fail("health_samples")
+	if rec := request(http.HandlerFunc(PublicStatus), "GET", "/status", "", ""); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("database down: got %d", rec.Code)
+	}
+	if rec := request(http.HandlerFunc(StatusBadge), "GET", "/status/badge.svg", "", ""); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("badge with database down: got %d", rec.Code)
+	}
+	f.srv.Heal()
+
+	// Three of four rounds in the last day found every critical component up
+	for i, ok := range []bool{true, false, true, true} {
+		sample := health.Sample{Component: health.Overall, OK: ok, CreatedAt: clock.Now().Add(-time.Duration(i) * time.Hour)}
+		if _, err := database.DB.Collection("health_samples").InsertOne(context.Background(), sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := request(http.HandlerFunc(PublicStatus), "GET", "/status", "", "")
+	var report health.Report
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Status != health.StatusOperational || report.Uptime["24h"] != 75 || report.Components == nil {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=30" {
+		t.Fatalf("got headers %v", rec.Header())
+	}
+
+	for _, tc := range []struct {
+		name, query string
+		status      int
+		want        string
+	}{
+		{"status", "", http.StatusOK, `aria-label="api: operational"`},
+		{"uptime", "?window=24h", http.StatusOK, `aria-label="api uptime 24h: 75.00%"`},
+		{"unknown window", "?window=1y", http.StatusNotFound, "No uptime for window"},
+		{"unknown component", "?component=mainframe", http.StatusNotFound, "Unknown component"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := request(http.HandlerFunc(StatusBadge), "GET", "/status/badge.svg"+tc.query, "", "")
+			if rec.Code != tc.status || !strings.Contains(rec.Body.String(), tc.want) {
+				t.Fatalf("got %d %s", rec.Code, rec.Body)
+			}
+			if tc.status == http.StatusOK && rec.Header().Get("Content-Type") != "image/svg+xml" {
+				t.Fatalf("got headers %v", rec.Header())
+			}
+		})
+	}
+}
+
+func TestBadgeSVG(t *testing.T) {
+	svg := badgeSVG("api <v2>", "99.50%", "#dfb317")
+	if !strings.Contains(svg, "api &lt;v2&gt;: 99.50%") || strings.Contains(svg, "<v2>") || !strings.Contains(svg, `fill="#dfb317"`) {
+		t.Fatalf("got %s", svg)
+	}
+}
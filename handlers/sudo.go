@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/challenge"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// SudoRequest represents the request for elevating a session
+type SudoRequest struct {
+	Password string `json:"password" example:"password123"`
+	// ChallengeResponse answers the challenge of a 428 response
+	ChallengeResponse string `json:"challenge_response,omitempty" example:"123456"`
+}
+
+// SudoResponse represents an elevated session token
+type SudoResponse struct {
+	Token         string    `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	ExpiresIn     int64     `json:"expires_in" example:"900"`
+	ElevatedUntil time.Time `json:"elevated_until" example:"2024-01-15T10:10:00Z"`
+}
+
+// Sudo handles session elevation
+// @Summary Elevate session
+// @Description Confirm the password to get the session token back allowed to run sensitive operations, such as deleting users or rotating keys, for SUDO_TTL or until it expires. It replaces the current token; tokens from POST /token/refresh are not elevated. Failed attempts are challenged as for login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body SudoRequest true "Current password"
+// @Success 200 {object} SudoResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse "Invalid password"
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Account has no password"
+// @Failure 428 {object} ChallengeResponse
+// @Router /auth/sudo [post]
+func Sudo(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Only user sessions have a password to confirm
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if claims["auth"] != nil {
+			http.Error(w, `{"error": "This endpoint requires a session token"}`, http.StatusForbidden)
+			return
+		}
+		userIDStr, _ := claims["userID"].(string)
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+
+		var req SudoRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.CredentialFields)
+		if err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		if user.Password == "" {
+			http.Error(w, `{"error": "Set a password to confirm sensitive operations"}`, http.StatusConflict)
+			return
+		}
+
+		// Guessing the password of a stolen session is challenged like login
+		codeTo := func(ctx context.Context) (string, *models.User, error) {
+			email, err := keys.Decrypt(ctx, cfg, user.Email)
+			return email, user, err
+		}
+		if !requireChallenge(w, r, cfg, challenge.FlowSudo, userIDStr, req.ChallengeResponse, codeTo) {
+			return
+		}
+		if err := utils.ComparePassword(user.Password, req.Password); errors.Is(err, utils.ErrPasswordPoolBusy) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error": "Server busy, please retry"}`, http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			failChallenge(r, challenge.FlowSudo, userIDStr)
+			security.Emit(r, security.EventSudo, security.OutcomeFailure, userIDStr, "invalid password")
+			http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+			return
+		}
+
+		// The presented token is re-signed as it was, so it stays the same
+		// session; elevation never outlives it
+		presented, err := tokens.Parse(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil || !presented.Valid {
+			http.Error(w, `{"error": "This endpoint requires a session token"}`, http.StatusForbidden)
+			return
+		}
+		elevated := jwt.MapClaims{}
+		for k, v := range presented.Claims.(jwt.MapClaims) {
+			elevated[k] = v
+		}
+		exp, _ := elevated["exp"].(float64)
+		expires := time.Unix(int64(exp), 0)
+		until := clock.Now().Add(cfg.SudoTTL)
+		if expires.Before(until) {
+			until = expires
+		}
+		elevated["elevatedUntil"] = until.Unix()
+		token, err := tokens.Sign(elevated)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to generate token"}`, http.StatusInternalServerError)
+			return
+		}
+
+		resetChallenge(r, challenge.FlowSudo, userIDStr)
+		correlation.SetUser(ctx, userIDStr)
+		security.Emit(r, security.EventSudo, security.OutcomeSuccess, userIDStr, "")
+
+		json.NewEncoder(w).Encode(SudoResponse{
+			Token:         token,
+			ExpiresIn:     int64(expires.Sub(clock.Now()).Seconds()),
+			ElevatedUntil: until.UTC(),
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/middleware"
+)
+
+// checkSudo checks whether a session token may run sensitive operations
+func checkSudo(t *testing.T, token string, want int) {
+	t.Helper()
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	h := middleware.JWTAuthMiddleware(testConfig)(middleware.RequireSudo(testConfig.SudoTTL)(ok))
+	if rec := request(h, "DELETE", "/", token, ""); rec.Code != want {
+		t.Fatalf("sensitive operation: got %d, want %d", rec.Code, want)
+	}
+}
+
+func TestSudo(t *testing.T) {
+	sudo := func(password string) string { return `{"password":"` + password + `"}` }
+	runCases(t, Sudo(testConfig), []handlerCase{
+		{
+			name: "elevates the session", as: "user", method: "POST", target: "/auth/sudo", body: sudo(testPassword),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp SudoResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Token == "" || resp.ExpiresIn <= 0 || resp.ElevatedUntil.IsZero() {
+					t.Fatalf("got %+v", resp)
+				}
+				checkSudo(t, resp.Token, http.StatusOK)
+				checkSudo(t, f.user.token, http.StatusForbidden)
+			},
+		},
+		{name: "wrong password", as: "user", method: "POST", target: "/auth/sudo", body: sudo("wrong password"), status: http.StatusUnauthorized},
+		{name: "malformed body", as: "user", method: "POST", target: "/auth/sudo", body: `{"password":1}`, status: http.StatusBadRequest},
+		{
+			name: "no password", as: "user", method: "POST", target: "/auth/sudo", body: sudo(""),
+			setup:  func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"password": ""}) },
+			status: http.StatusConflict,
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/auth/sudo", body: sudo(testPassword), status: http.StatusUnauthorized},
+		{name: "deleted after sign-in", as: "user", method: "POST", target: "/auth/sudo", body: sudo(testPassword), setup: userDeleted, status: http.StatusNotFound},
+	})
+}
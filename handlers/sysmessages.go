@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/sysmessages"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/usertags"
+	"golang-backend/utils"
+)
+
+// SystemMessageRequest represents the request for creating or replacing a
+// system message
+type SystemMessageRequest struct {
+	Kind     string     `json:"kind" example:"maintenance"`
+	Severity string     `json:"severity" example:"warning"`
+	Title    string     `json:"title" example:"Scheduled maintenance"`
+	Body     string     `json:"body" example:"The API is read-only on Saturday from 02:00 to 03:00 UTC."`
+	StartsAt *time.Time `json:"starts_at" example:"2026-10-17T00:00:00Z"`
+	EndsAt   *time.Time `json:"ends_at" example:"2026-10-18T03:00:00Z"`
+	Roles    []string   `json:"roles"`
+	OrgIDs   []string   `json:"org_ids"`
+	// Tags limit the message to signed-in users with one of the tags
+	Tags []string `json:"tags"`
+}
+
+// SystemMessagesResponse represents a list of system messages
+type SystemMessagesResponse struct {
+	Messages []models.SystemMessage `json:"messages"`
+}
+
+// systemMessage validates a request and returns the message it describes, or
+// an error message for the client
+func (req SystemMessageRequest) systemMessage() (models.SystemMessage, string) {
+	msg := models.SystemMessage{
+		Kind:     req.Kind,
+		Severity: req.Severity,
+		Title:    strings.TrimSpace(req.Title),
+		Body:     strings.TrimSpace(req.Body),
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
+		Roles:    req.Roles,
+		OrgIDs:   req.OrgIDs,
+	}
+	if len(req.Tags) > 0 {
+		tags, err := usertags.Normalize(req.Tags)
+		if err != nil {
+			return msg, usertags.ErrInvalidTag.Error()
+		}
+		msg.Tags = tags
+	}
+	switch msg.Kind {
+	case models.MessageMaintenance, models.MessageIncident, models.MessageInfo:
+	default:
+		return msg, "Invalid kind. Must be 'maintenance', 'incident' or 'info'"
+	}
+	if msg.Severity == "" {
+		msg.Severity = models.SeverityInfo
+	}
+	switch msg.Severity {
+	case models.SeverityInfo, models.SeverityWarning, models.SeverityCritical:
+	default:
+		return msg, "Invalid severity. Must be 'info', 'warning' or 'critical'"
+	}
+	if msg.Title == "" {
+		return msg, "Title is required"
+	}
+	if msg.StartsAt != nil && msg.EndsAt != nil && !msg.EndsAt.After(*msg.StartsAt) {
+		return msg, "ends_at must be after starts_at"
+	}
+	for _, role := range msg.Roles {
+		if role != "user" && role != "admin" {
+			return msg, "Invalid role. Must be 'user' or 'admin'"
+		}
+	}
+	return msg, ""
+}
+
+// messageAudit is the part of a message kept in the audit log
+func messageAudit(msg *models.SystemMessage) bson.M {
+	return bson.M{
+		"kind":      msg.Kind,
+		"severity":  msg.Severity,
+		"title":     msg.Title,
+		"starts_at": msg.StartsAt,
+		"ends_at":   msg.EndsAt,
+		"roles":     msg.Roles,
+		"org_ids":   msg.OrgIDs,
+		"tags":      msg.Tags,
+	}
+}
+
+// viewerAudience returns the role, organization and tags of the caller. The
+// route is public, so a missing, invalid or revoked token just means an
+// anonymous viewer, who still belongs to the organization of a custom domain.
+func viewerAudience(r *http.Request) sysmessages.Viewer {
+	viewer := sysmessages.Viewer{OrgID: tenant.OrgID(r)}
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		return viewer
+	}
+	token, err := tokens.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return viewer
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if jti, _ := claims["jti"].(string); jti != "" {
+		if state, _, err := tokens.Lookup(r.Context(), jti); err != nil || state == tokens.StateRevoked {
+			return viewer
+		}
+	}
+
+	role, _ := claims["role"].(string)
+	if tokenOrg, ok := claims["orgID"].(string); ok && tokenOrg != "" {
+		viewer.OrgID = tokenOrg
+	}
+	subject, _ := claims["userID"].(string)
+	if sub, ok := claims["sub"].(string); ok {
+		subject = sub
+		if role == "" {
+			// Minimal tokens leave the role and organization to the database
+			id, err := primitive.ObjectIDFromHex(sub)
+			if err != nil {
+				return viewer
+			}
+			user, _, err := repository.FindUser(r.Context(), bson.M{"_id": id}, repository.Fields("role", "org_id"))
+			if err != nil {
+				return viewer
+			}
+			role = user.Role
+			if user.OrgID != "" {
+				viewer.OrgID = user.OrgID
+			}
+		}
+	}
+	viewer.Role = role
+
+	if userID, err := primitive.ObjectIDFromHex(subject); err == nil {
+		viewer.Tags = func() []string {
+			user, _, err := repository.FindUser(r.Context(), bson.M{"_id": userID}, repository.Fields("tags"))
+			if err != nil {
+				return nil
+			}
+			return user.Tags
+		}
+	}
+	return viewer
+}
+
+// @Summary Active system messages
+// @Description Maintenance windows, incidents and announcements to show now. Public; send a session token to also get messages targeted at the caller's role, organization or tags
+// @Tags system
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SystemMessagesResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /system/messages [get]
+func ActiveSystemMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	messages, err := sysmessages.Active(r.Context(), viewerAudience(r))
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch messages"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Responses differ per viewer, so only private caches may keep them
+	w.Header().Set("Cache-Control", "private, max-age=30")
+	json.NewEncoder(w).Encode(SystemMessagesResponse{Messages: messages})
+}
+
+// @Summary List system messages
+// @Description List every system message, including scheduled and ended ones, newest first (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SystemMessagesResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/system-messages [get]
+func ListSystemMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	messages, err := sysmessages.List(r.Context())
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch messages"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SystemMessagesResponse{Messages: messages})
+}
+
+// @Summary Create a system message
+// @Description Schedule a banner for frontends. Without starts_at it shows immediately and without ends_at until deleted; roles, org_ids and tags limit who sees it (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body SystemMessageRequest true "Message"
+// @Security BearerAuth
+// @Success 201 {object} models.SystemMessage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/system-messages [post]
+func CreateSystemMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req SystemMessageRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	msg, problem := req.systemMessage()
+	if problem != "" {
+		http.Error(w, `{"error": "`+problem+`"}`, http.StatusBadRequest)
+		return
+	}
+	msg.CreatedBy = audit.ActorID(r)
+
+	created, err := sysmessages.Create(r.Context(), msg)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionSystemMessageCreate, created.ID.Hex(), nil, messageAudit(created)); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit system message %s: %v", created.ID.Hex(), err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// @Summary Update a system message
+// @Description Replace a system message's text, schedule and audience (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Message ID"
+// @Param request body SystemMessageRequest true "Message"
+// @Security BearerAuth
+// @Success 200 {object} models.SystemMessage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/system-messages/{id} [put]
+func UpdateSystemMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
+	}
+	var req SystemMessageRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	msg, problem := req.systemMessage()
+	if problem != "" {
+		http.Error(w, `{"error": "`+problem+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	before, after, err := sysmessages.Update(r.Context(), id, msg)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to update message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionSystemMessageUpdate, id.Hex(), messageAudit(before), messageAudit(after)); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit system message %s: %v", id.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(after)
+}
+
+// @Summary Delete a system message
+// @Description Remove a system message; frontends stop showing it within 30 seconds (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "Message ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/system-messages/{id} [delete]
+func DeleteSystemMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	removed, err := sysmessages.Delete(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to delete message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionSystemMessageDelete, id.Hex(), messageAudit(removed), nil); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit system message %s: %v", id.Hex(), err)
+	}
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Message deleted"})
+}
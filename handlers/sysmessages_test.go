@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/sysmessages"
+	"golang-backend/tokens"
+)
+
+// systemMessages stores msgs, setting {message} to the ID of the last. The
+// active list is reloaded even without any, as it is cached across tests.
+func systemMessages(msgs ...models.SystemMessage) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		ctx := context.Background()
+		if _, err := sysmessages.Reload(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range msgs {
+			created, err := sysmessages.Create(ctx, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.set("message", created.ID.Hex())
+		}
+	}
+}
+
+// messageTitles decodes the titles of a SystemMessagesResponse
+func messageTitles(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp SystemMessagesResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Messages == nil {
+		t.Fatalf("no message list: %s", rec.Body.String())
+	}
+	var titles []string
+	for _, msg := range resp.Messages {
+		titles = append(titles, msg.Title)
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestActiveSystemMessages(t *testing.T) {
+	tomorrow, yesterday := clock.Now().Add(24*time.Hour), clock.Now().Add(-24*time.Hour)
+	messages := systemMessages(
+		models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityInfo, Title: "everyone"},
+		models.SystemMessage{Kind: models.MessageIncident, Severity: models.SeverityCritical, Title: "admins", Roles: []string{"admin"}},
+		models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityWarning, Title: "beta", Tags: []string{"beta"}},
+		models.SystemMessage{Kind: models.MessageMaintenance, Severity: models.SeverityWarning, Title: "scheduled", StartsAt: &tomorrow},
+		models.SystemMessage{Kind: models.MessageMaintenance, Severity: models.SeverityWarning, Title: "ended", StartsAt: &yesterday, EndsAt: &yesterday},
+		models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityInfo, Title: "acme", OrgIDs: []string{testOrg}},
+	)
+	for _, tc := range []struct {
+		name  string
+		token func(f *fixture) string
+		setup func(*testing.T, *fixture)
+		want  string
+	}{
+		{"anonymous", func(f *fixture) string { return "" }, nil, "everyone"},
+		{"user", func(f *fixture) string { return f.user.token }, nil, "everyone"},
+		{"tagged user", func(f *fixture) string { return f.user.token }, func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"tags": []string{"beta"}}) }, "beta,everyone"},
+		{"admin", func(f *fixture) string { return f.admin.token }, nil, "admins,everyone"},
+		{"invalid token", func(f *fixture) string { return "nope" }, nil, "everyone"},
+		{"revoked token", func(f *fixture) string { return f.admin.token }, func(t *testing.T, f *fixture) {
+			if _, err := tokens.RevokeUser(context.Background(), f.admin.user.ID.Hex()); err != nil {
+				t.Fatal(err)
+			}
+		}, "everyone"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			messages(t, f)
+			if tc.setup != nil {
+				tc.setup(t, f)
+			}
+			rec := request(http.HandlerFunc(ActiveSystemMessages), "GET", "/system/messages", tc.token(f), "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d %s", rec.Code, rec.Body)
+			}
+			if got := messageTitles(t, rec); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+			if rec.Header().Get("Cache-Control") != "private, max-age=30" {
+				t.Fatalf("got headers %v", rec.Header())
+			}
+		})
+	}
+}
+
+func TestListSystemMessages(t *testing.T) {
+	h := http.HandlerFunc(ListSystemMessages)
+	yesterday := clock.Now().Add(-24 * time.Hour)
+	runCases(t, h, []handlerCase{
+		{
+			name: "newest first", as: "admin", method: "GET", target: "/admin/system-messages",
+			setup: setups(
+				systemMessages(
+					models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityInfo, Title: "current"},
+					models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityInfo, Title: "ended", EndsAt: &yesterday},
+				),
+				// Created in the same millisecond otherwise
+				func(t *testing.T, f *fixture) {
+					if _, err := database.DB.Collection("system_messages").UpdateOne(context.Background(), bson.M{"title": "ended"}, bson.M{"$set": bson.M{"created_at": yesterday}}); err != nil {
+						t.Fatal(err)
+					}
+				},
+			),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if got := messageTitles(t, rec); got != "current,ended" {
+					t.Fatalf("got %s", got)
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/system-messages", setup: dbDown("system_messages"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestCreateSystemMessage(t *testing.T) {
+	h := http.HandlerFunc(CreateSystemMessage)
+	created := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var msg models.SystemMessage
+		json.Unmarshal(rec.Body.Bytes(), &msg)
+		if msg.ID.IsZero() || msg.Title != "Scheduled maintenance" || msg.Severity != models.SeverityInfo || strings.Join(msg.Tags, ",") != "beta" || msg.CreatedBy != f.admin.user.ID.Hex() {
+			t.Fatalf("got %+v", msg)
+		}
+		if n := f.srv.Count("system_messages", bson.M{"_id": msg.ID, "roles": "user"}); n != 1 {
+			t.Fatal("message not stored")
+		}
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionSystemMessageCreate, "target_id": msg.ID.Hex(), "after.title": msg.Title}); n != 1 {
+			t.Fatal("creation not audited")
+		}
+		// The active list is reloaded right away
+		rec = request(http.HandlerFunc(ActiveSystemMessages), "GET", "/system/messages", f.user.token, "")
+		if got := messageTitles(t, rec); got != "" {
+			t.Fatalf("untagged user sees %s", got)
+		}
+		setUser(t, f, bson.M{"tags": []string{"beta"}})
+		rec = request(http.HandlerFunc(ActiveSystemMessages), "GET", "/system/messages", f.user.token, "")
+		if got := messageTitles(t, rec); got != msg.Title {
+			t.Fatalf("tagged user sees %q", got)
+		}
+	}
+	invalid := func(name, body string) handlerCase {
+		return handlerCase{name: name, as: "admin", method: "POST", target: "/admin/system-messages", body: body, status: http.StatusBadRequest}
+	}
+	runCases(t, h, []handlerCase{
+		{name: "created", as: "admin", method: "POST", target: "/admin/system-messages", body: `{"kind":"maintenance","title":" Scheduled maintenance ","roles":["user"],"tags":["Beta"]}`, setup: systemMessages(), status: http.StatusCreated, check: created},
+		invalid("unknown kind", `{"kind":"outage","title":"Down"}`),
+		invalid("unknown severity", `{"kind":"incident","severity":"fatal","title":"Down"}`),
+		invalid("no title", `{"kind":"incident","title":" "}`),
+		invalid("ends before start", `{"kind":"maintenance","title":"Window","starts_at":"2026-10-18T03:00:00Z","ends_at":"2026-10-18T02:00:00Z"}`),
+		invalid("unknown role", `{"kind":"info","title":"Hello","roles":["owner"]}`),
+		invalid("invalid tag", `{"kind":"info","title":"Hello","tags":["no spaces"]}`),
+		invalid("malformed body", `{"kind":`),
+		{name: "database down", as: "admin", method: "POST", target: "/admin/system-messages", body: `{"kind":"info","title":"Hello"}`, setup: dbDown("system_messages"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUpdateSystemMessage(t *testing.T) {
+	h := route("/admin/system-messages/{id}", http.HandlerFunc(UpdateSystemMessage))
+	message := systemMessages(models.SystemMessage{Kind: models.MessageIncident, Severity: models.SeverityCritical, Title: "Outage", Roles: []string{"admin"}, CreatedBy: "someone"})
+	runCases(t, h, []handlerCase{
+		{
+			name: "replaced", as: "admin", method: "PUT", target: "/admin/system-messages/{message}", body: `{"kind":"incident","severity":"warning","title":"Recovering"}`, setup: message, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var msg models.SystemMessage
+				json.Unmarshal(rec.Body.Bytes(), &msg)
+				if msg.ID.Hex() != f.vars["message"] || msg.Title != "Recovering" || msg.CreatedBy != "someone" || msg.Roles != nil {
+					t.Fatalf("got %+v", msg)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionSystemMessageUpdate, "target_id": msg.ID.Hex(), "before.title": "Outage", "after.title": "Recovering"}); n != 1 {
+					t.Fatal("update not audited")
+				}
+				// Everyone sees it now that no role is required
+				rec = request(http.HandlerFunc(ActiveSystemMessages), "GET", "/system/messages", "", "")
+				if got := messageTitles(t, rec); got != "Recovering" {
+					t.Fatalf("active messages %q", got)
+				}
+			},
+		},
+		{name: "invalid", as: "admin", method: "PUT", target: "/admin/system-messages/{message}", body: `{"kind":"incident","title":""}`, setup: message, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/system-messages/{message}", body: `{"kind":`, setup: message, status: http.StatusBadRequest},
+		{name: "unknown message", as: "admin", method: "PUT", target: "/admin/system-messages/{missing}", body: `{"kind":"info","title":"Hello"}`, status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "PUT", target: "/admin/system-messages/nope", body: `{"kind":"info","title":"Hello"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/system-messages/{message}", body: `{"kind":"info","title":"Hello"}`, setup: setups(message, dbDown("system_messages")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDeleteSystemMessage(t *testing.T) {
+	h := route("/admin/system-messages/{id}", http.HandlerFunc(DeleteSystemMessage))
+	message := systemMessages(models.SystemMessage{Kind: models.MessageInfo, Severity: models.SeverityInfo, Title: "Hello"})
+	runCases(t, h, []handlerCase{
+		{
+			name: "deleted", as: "admin", method: "DELETE", target: "/admin/system-messages/{message}", setup: message, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("system_messages", bson.M{}); n != 0 {
+					t.Fatal("message not deleted")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionSystemMessageDelete, "target_id": f.vars["message"], "before.title": "Hello"}); n != 1 {
+					t.Fatal("deletion not audited")
+				}
+				rec = request(http.HandlerFunc(ActiveSystemMessages), "GET", "/system/messages", "", "")
+				if got := messageTitles(t, rec); got != "" {
+					t.Fatalf("active messages %q", got)
+				}
+			},
+		},
+		{name: "unknown message", as: "admin", method: "DELETE", target: "/admin/system-messages/{missing}", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "DELETE", target: "/admin/system-messages/nope", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/system-messages/{message}", setup: setups(message, dbDown("system_messages")), status: http.StatusInternalServerError},
+	})
+}
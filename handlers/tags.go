@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/repository"
+	"golang-backend/tenant"
+	"golang-backend/usertags"
+	"golang-backend/utils"
+)
+
+// maxBulkTagUsers bounds the users of one bulk tagging request
+const maxBulkTagUsers = 1000
+
+// TagUserRequest represents the request for tagging a user
+type TagUserRequest struct {
+	Tags []string `json:"tags" example:"beta,enterprise-trial"`
+}
+
+// UserTagsResponse represents the tags of a user
+type UserTagsResponse struct {
+	UserID string   `json:"user_id"`
+	Tags   []string `json:"tags"`
+}
+
+// BulkTagUsersRequest represents the request for tagging users in bulk
+type BulkTagUsersRequest struct {
+	UserIDs []string `json:"user_ids"`
+	Add     []string `json:"add,omitempty" example:"beta"`
+	Remove  []string `json:"remove,omitempty" example:"churn-risk"`
+}
+
+// BulkTagUsersResponse represents the outcome of tagging users in bulk
+type BulkTagUsersResponse struct {
+	Message string `json:"message"`
+	// Changed counts the users the additions and the removals changed
+	Changed int64 `json:"changed" example:"12"`
+}
+
+// ListTagsResponse represents the tags in use
+type ListTagsResponse struct {
+	Tags []usertags.Count `json:"tags"`
+}
+
+// requestTags reads ?tag= filters, which a user must all carry. It reports
+// false after answering 400 for an invalid one.
+func requestTags(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	values := r.URL.Query()["tag"]
+	if len(values) == 0 {
+		return nil, true
+	}
+	tags, err := usertags.Normalize(values)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "`+usertags.ErrInvalidTag.Error()+`"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return tags, true
+}
+
+// @Summary List user tags
+// @Description Every tag in use with its number of users, most used first. On an organization's custom domain only its members are counted (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListTagsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/tags [get]
+func ListUserTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	counts, err := usertags.Counts(r.Context(), tenant.OrgID(r))
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to count user tags: %v", err)
+		http.Error(w, `{"error": "Failed to fetch tags"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(ListTagsResponse{Tags: counts})
+}
+
+// @Summary Tag a user
+// @Description Add tags to a user. Tags are lowercased; they are 1-64 letters, digits or _.:- and a user has at most 50 (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body TagUserRequest true "Tags to add"
+// @Security BearerAuth
+// @Success 200 {object} UserTagsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Too many tags"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/tags [post]
+func TagUser(w http.ResponseWriter, r *http.Request) {
+	var req TagUserRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || len(req.Tags) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Tags are required"}`, http.StatusBadRequest)
+		return
+	}
+	updateUserTags(w, r, req.Tags, nil)
+}
+
+// @Summary Untag a user
+// @Description Remove a tag from a user (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Param tag path string true "Tag"
+// @Security BearerAuth
+// @Success 200 {object} UserTagsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/tags/{tag} [delete]
+func UntagUser(w http.ResponseWriter, r *http.Request) {
+	updateUserTags(w, r, nil, []string{mux.Vars(r)["tag"]})
+}
+
+// updateUserTags adds and removes tags of the user in the path and answers
+// with the tags it has afterwards
+func updateUserTags(w http.ResponseWriter, r *http.Request, add, remove []string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return
+	}
+	if add, err = usertags.Normalize(add); err != nil {
+		http.Error(w, `{"error": "`+usertags.ErrInvalidTag.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if remove, err = usertags.Normalize(remove); err != nil {
+		http.Error(w, `{"error": "`+usertags.ErrInvalidTag.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	orgID := tenant.OrgID(r)
+	filter := bson.M{"_id": userID}
+	if orgID != "" {
+		filter["org_id"] = orgID
+	}
+	before, _, err := repository.FindUser(ctx, filter, repository.Fields("tags"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+	if status, msg := memberWritable(ctx, userID); status != http.StatusOK {
+		http.Error(w, `{"error": "`+msg+`"}`, status)
+		return
+	}
+	if len(before.Tags)+len(add) > usertags.MaxPerUser {
+		http.Error(w, `{"error": "Users have at most `+strconv.Itoa(usertags.MaxPerUser)+` tags"}`, http.StatusConflict)
+		return
+	}
+
+	if _, err := usertags.Update(ctx, []primitive.ObjectID{userID}, orgID, add, remove); err != nil {
+		correlation.Errorf(ctx, "Failed to update tags of user %s: %v", userID.Hex(), err)
+		http.Error(w, `{"error": "Failed to update tags"}`, http.StatusInternalServerError)
+		return
+	}
+	after, _, err := repository.FindUser(ctx, filter, repository.Fields("tags"))
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch user"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionTagUsers, userID.Hex(), bson.M{"tags": before.Tags}, bson.M{"tags": after.Tags}); err != nil {
+		correlation.Errorf(ctx, "Failed to audit tags of user %s: %v", userID.Hex(), err)
+	}
+
+	tags := after.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	json.NewEncoder(w).Encode(UserTagsResponse{UserID: userID.Hex(), Tags: tags})
+}
+
+// @Summary Tag users in bulk
+// @Description Add and remove tags on up to 1000 users at once. Removal runs after adding, so a tag in both is removed; users the additions would take past 50 tags get none added. On an organization's custom domain only its members are changed (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body BulkTagUsersRequest true "Users and tags"
+// @Security BearerAuth
+// @Success 200 {object} BulkTagUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/tags [post]
+func BulkTagUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req BulkTagUsersRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.UserIDs) == 0 || len(req.UserIDs) > maxBulkTagUsers {
+		http.Error(w, `{"error": "Between 1 and `+strconv.Itoa(maxBulkTagUsers)+` user IDs are required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		http.Error(w, `{"error": "Tags to add or remove are required"}`, http.StatusBadRequest)
+		return
+	}
+	add, err := usertags.Normalize(req.Add)
+	if err != nil {
+		http.Error(w, `{"error": "`+usertags.ErrInvalidTag.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	remove, err := usertags.Normalize(req.Remove)
+	if err != nil {
+		http.Error(w, `{"error": "`+usertags.ErrInvalidTag.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	userIDs := make([]primitive.ObjectID, 0, len(req.UserIDs))
+	for _, id := range req.UserIDs {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+			return
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	ctx := r.Context()
+	changed, err := usertags.Update(ctx, userIDs, tenant.OrgID(r), add, remove)
+	if errors.Is(err, usertags.ErrTooMany) {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		correlation.Errorf(ctx, "Bulk tagging failed after %d changes: %v", changed, err)
+		http.Error(w, `{"error": "Failed to update tags"}`, http.StatusInternalServerError)
+		return
+	}
+
+	after := bson.M{"user_ids": req.UserIDs, "add": add, "remove": remove, "changed": changed}
+	if _, err := audit.Record(r, audit.ActionTagUsers, "", nil, after); err != nil {
+		correlation.Errorf(ctx, "Failed to audit bulk tagging: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(BulkTagUsersResponse{Message: "Tags updated", Changed: changed})
+}
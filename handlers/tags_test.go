@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/usertags"
+)
+
+// userTags gives the signed-in user tags
+func userTags(tags ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		setUser(t, f, bson.M{"tags": tags})
+	}
+}
+
+// tagsOf returns the stored tags of a user, comma separated
+func tagsOf(t *testing.T, user *models.User) string {
+	t.Helper()
+	var stored models.User
+	if err := database.DB.Collection("users").FindOne(context.Background(), bson.M{"_id": user.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(stored.Tags, ",")
+}
+
+func TestListUserTags(t *testing.T) {
+	h := http.HandlerFunc(ListUserTags)
+	runCases(t, h, []handlerCase{
+		{
+			name: "most used first", as: "admin", method: "GET", target: "/admin/tags",
+			setup: setups(userTags("vip", "beta"), func(t *testing.T, f *fixture) {
+				if _, err := database.DB.Collection("users").UpdateOne(context.Background(), bson.M{"_id": f.admin.user.ID}, bson.M{"$set": bson.M{"tags": []string{"beta"}}}); err != nil {
+					t.Fatal(err)
+				}
+			}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ListTagsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				var got []string
+				for _, c := range resp.Tags {
+					got = append(got, fmt.Sprintf("%s:%d", c.Tag, c.Users))
+				}
+				if strings.Join(got, ",") != "beta:2,vip:1" {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "none", as: "admin", method: "GET", target: "/admin/tags", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"tags":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/tags", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+// taggedAs checks the response and the stored tags of the signed-in user
+func taggedAs(want string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var resp UserTagsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.UserID != f.user.user.ID.Hex() || resp.Tags == nil || strings.Join(resp.Tags, ",") != want {
+			t.Fatalf("got %s", rec.Body.String())
+		}
+		if got := tagsOf(t, f.user.user); got != want {
+			t.Fatalf("stored %s, want %s", got, want)
+		}
+		if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionTagUsers, "target_id": f.user.user.ID.Hex()}); n != 1 {
+			t.Fatal("tags not audited")
+		}
+	}
+}
+
+// fullTags are as many tags as a user may have
+var fullTags = func() []string {
+	tags := make([]string, usertags.MaxPerUser)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag%d", i)
+	}
+	return tags
+}()
+
+func TestTagUser(t *testing.T) {
+	h := route("/admin/users/{id}/tags", http.HandlerFunc(TagUser))
+	runCases(t, h, []handlerCase{
+		{name: "tagged", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":["Beta"," vip ","beta"]}`, setup: userTags("beta"), status: http.StatusOK, check: taggedAs("beta,vip")},
+		{name: "no tags", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":[]}`, status: http.StatusBadRequest},
+		{name: "invalid tag", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":["no spaces"]}`, status: http.StatusBadRequest},
+		{name: "too many", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":["vip"]}`, setup: userTags(fullTags...), status: http.StatusConflict},
+		{name: "archived organization", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":["vip"]}`, setup: setups(orgStatus(models.OrgArchived), func(t *testing.T, f *fixture) { joinOrg(t, f, f.user.user.ID) }), status: http.StatusConflict},
+		{name: "unknown user", as: "admin", method: "POST", target: "/admin/users/{missing}/tags", body: `{"tags":["vip"]}`, status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/users/nope/tags", body: `{"tags":["vip"]}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/{user}/tags", body: `{"tags":["vip"]}`, setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestUntagUser(t *testing.T) {
+	h := route("/admin/users/{id}/tags/{tag}", http.HandlerFunc(UntagUser))
+	runCases(t, h, []handlerCase{
+		{name: "untagged", as: "admin", method: "DELETE", target: "/admin/users/{user}/tags/Beta", setup: userTags("beta", "vip"), status: http.StatusOK, check: taggedAs("vip")},
+		{name: "last tag", as: "admin", method: "DELETE", target: "/admin/users/{user}/tags/vip", setup: userTags("vip"), status: http.StatusOK, check: taggedAs("")},
+		{name: "invalid tag", as: "admin", method: "DELETE", target: "/admin/users/{user}/tags/-vip", status: http.StatusBadRequest},
+		{name: "unknown user", as: "admin", method: "DELETE", target: "/admin/users/{missing}/tags/vip", status: http.StatusNotFound},
+	})
+}
+
+func TestBulkTagUsers(t *testing.T) {
+	h := http.HandlerFunc(BulkTagUsers)
+	both := `"user_ids":["{user}","{admin}"]`
+	tooMany := append([]string{"vip"}, fullTags...)
+	runCases(t, h, []handlerCase{
+		{
+			name: "tagged", as: "admin", method: "POST", target: "/admin/users/tags", body: `{` + both + `,"add":["Beta"],"remove":["vip"]}`,
+			setup:  setups(adminID, userTags("vip")),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp BulkTagUsersResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Changed != 3 {
+					t.Fatalf("got %+v", resp)
+				}
+				if tagsOf(t, f.user.user) != "beta" || tagsOf(t, f.admin.user) != "beta" {
+					t.Fatal("tags not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionTagUsers, "after.changed": 3, "after.add": "beta"}); n != 1 {
+					t.Fatal("bulk tagging not audited")
+				}
+			},
+		},
+		{name: "no users", as: "admin", method: "POST", target: "/admin/users/tags", body: `{"user_ids":[],"add":["beta"]}`, status: http.StatusBadRequest},
+		{name: "no tags", as: "admin", method: "POST", target: "/admin/users/tags", body: `{` + both + `}`, setup: adminID, status: http.StatusBadRequest},
+		{name: "invalid tag", as: "admin", method: "POST", target: "/admin/users/tags", body: `{` + both + `,"remove":["no spaces"]}`, setup: adminID, status: http.StatusBadRequest},
+		{
+			name: "too many", as: "admin", method: "POST", target: "/admin/users/tags", setup: adminID, status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), "at most") {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+			body: `{` + both + `,"add":["` + strings.Join(tooMany, `","`) + `"]}`,
+		},
+		{name: "invalid user ID", as: "admin", method: "POST", target: "/admin/users/tags", body: `{"user_ids":["nope"],"add":["beta"]}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/users/tags", body: `{"user_ids":`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/users/tags", body: `{` + both + `,"add":["beta"]}`, setup: setups(adminID, dbDown("users")), status: http.StatusInternalServerError},
+	})
+}
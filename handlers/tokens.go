@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/tokens"
+)
+
+// RevokeTokensResponse reports a global token revocation
+type RevokeTokensResponse struct {
+	Revoked int64 `json:"revoked" example:"1280"`
+	Strict  bool  `json:"strict" example:"true"`
+}
+
+// @Summary Revoke all session tokens
+// @Description Forget the IDs of every issued session token, for example after the signing secret leaked. With JWT_STRICT_JTI every outstanding token is rejected and users must sign in again; without it revoked tokens are only reported (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} RevokeTokensResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/tokens/revoke [post]
+func RevokeAllTokens(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		revoked, err := tokens.RevokeAll(r.Context())
+		if err != nil {
+			http.Error(w, `{"error": "Failed to revoke tokens"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionRevokeTokens, "", nil, bson.M{"revoked": revoked, "strict": cfg.JWTStrictJTI}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit token revocation: %v", err)
+		}
+
+		json.NewEncoder(w).Encode(RevokeTokensResponse{Revoked: revoked, Strict: cfg.JWTStrictJTI})
+	}
+}
+
+// JWTSecretsResponse lists the secrets session tokens are verified with
+type JWTSecretsResponse struct {
+	Secrets []tokens.SecretInfo `json:"secrets"`
+}
+
+// RotateJWTSecretResponse reports a new signing secret
+type RotateJWTSecretResponse struct {
+	Secret    tokens.SecretInfo `json:"secret"`
+	Retention string            `json:"retention" example:"48h0m0s"`
+}
+
+// @Summary List JWT secrets
+// @Description List the secrets session tokens are verified with, signing secret first. Secrets themselves are never returned (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} JWTSecretsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/jwt/secrets [get]
+func ListJWTSecrets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JWTSecretsResponse{Secrets: tokens.Secrets()})
+}
+
+// @Summary Rotate the JWT signing secret
+// @Description Generate a new signing secret shared by every instance. Previously rotated secrets keep verifying tokens for JWT_SECRET_RETENTION, and JWT_SECRETS entries until they are removed, so sessions are not invalidated at once. Use /admin/tokens/revoke to end them immediately (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} RotateJWTSecretResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jwt/rotate [post]
+func RotateJWTSecret(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		secret, err := tokens.RotateSecret(r.Context())
+		if err != nil {
+			correlation.Errorf(r.Context(), "Failed to rotate JWT secret: %v", err)
+			http.Error(w, `{"error": "Failed to rotate secret"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := audit.Record(r, audit.ActionRotateJWTSecret, secret.KeyID, nil, bson.M{"kid": secret.KeyID, "retention": cfg.JWTSecretRetention.String()}); err != nil {
+			correlation.Errorf(r.Context(), "Failed to audit JWT secret rotation: %v", err)
+		}
+
+		json.NewEncoder(w).Encode(RotateJWTSecretResponse{Secret: *secret, Retention: cfg.JWTSecretRetention.String()})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+)
+
+func TestRevokeAllTokens(t *testing.T) {
+	runCases(t, RevokeAllTokens(testConfig), []handlerCase{
+		{
+			name: "revokes every session", as: "admin", method: "POST", target: "/admin/tokens/revoke",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp RevokeTokensResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Revoked != 2 || resp.Strict != testConfig.JWTStrictJTI {
+					t.Fatalf("got %+v", resp)
+				}
+				if n := f.srv.Count("token_ids", bson.M{}) + f.srv.Count("refresh_tokens", bson.M{}); n != 0 {
+					t.Fatalf("%d tokens left", n)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRevokeTokens}); n != 1 {
+					t.Fatal("revocation not audited")
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/tokens/revoke", status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/tokens/revoke", setup: dbDown("refresh_tokens"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestListJWTSecrets(t *testing.T) {
+	runCases(t, http.HandlerFunc(ListJWTSecrets), []handlerCase{
+		{
+			name: "lists the signing secret first", as: "admin", method: "GET", target: "/admin/jwt/secrets",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp JWTSecretsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Secrets) == 0 || !resp.Secrets[0].Signing {
+					t.Fatalf("got %+v", resp)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "GET", target: "/admin/jwt/secrets", status: http.StatusUnauthorized},
+	})
+}
+
+func TestRotateJWTSecret(t *testing.T) {
+	runCases(t, RotateJWTSecret(testConfig), []handlerCase{
+		{
+			name: "signs with a new secret", as: "admin", method: "POST", target: "/admin/jwt/rotate",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp RotateJWTSecretResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Secret.KeyID == "" || !resp.Secret.Signing || resp.Retention != testConfig.JWTSecretRetention.String() {
+					t.Fatalf("got %+v", resp)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRotateJWTSecret, "target_id": resp.Secret.KeyID}); n != 1 {
+					t.Fatal("rotation not audited")
+				}
+
+				// Sessions signed before keep working; new ones use the new secret
+				checkToken(t, f.user.token, http.StatusOK)
+				checkToken(t, signIn(t, f.user.user).token, http.StatusOK)
+				var list JWTSecretsResponse
+				json.Unmarshal(f.admin.do(http.HandlerFunc(ListJWTSecrets), "GET", "/admin/jwt/secrets", "").Body.Bytes(), &list)
+				if len(list.Secrets) < 2 || list.Secrets[0].KeyID != resp.Secret.KeyID {
+					t.Fatalf("listed %+v", list.Secrets)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/admin/jwt/rotate", status: http.StatusUnauthorized},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/jwt/rotate", setup: dbDown("jwt_secrets"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/scan"
+	"golang-backend/utils"
+)
+
+// QuarantinedUploadsResponse lists flagged uploads
+type QuarantinedUploadsResponse struct {
+	Uploads []models.QuarantinedUpload `json:"uploads"`
+}
+
+// ReviewUploadRequest resolves a flagged upload
+type ReviewUploadRequest struct {
+	Decision string `json:"decision" example:"delete"`
+	Note     string `json:"note,omitempty" example:"EICAR test file"`
+}
+
+// scanUpload checks an upload and writes the error response when it is
+// refused, reporting whether the caller may go on
+func scanUpload(w http.ResponseWriter, r *http.Request, upload scan.Upload) bool {
+	err := scan.Check(r, upload)
+	if err == nil {
+		return true
+	}
+	var rejection *scan.Rejection
+	if errors.As(err, &rejection) {
+		http.Error(w, fmt.Sprintf(`{"error": %q, "quarantine_id": %q}`, "File rejected: "+rejection.Reason, rejection.QuarantineID.Hex()), http.StatusUnprocessableEntity)
+		return false
+	}
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, `{"error": "File could not be scanned, try again later"}`, http.StatusServiceUnavailable)
+	return false
+}
+
+// @Summary List quarantined uploads
+// @Description List uploads flagged by the upload scanners, newest first. Content is only available through the download endpoint (Admin only)
+// @Tags admin
+// @Produce json
+// @Param status query string false "pending, released or deleted" default(pending)
+// @Param limit query int false "Maximum number of uploads" default(100)
+// @Security BearerAuth
+// @Success 200 {object} QuarantinedUploadsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/uploads/quarantine [get]
+func ListQuarantinedUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.QuarantinePending
+	case models.QuarantinePending, models.QuarantineReleased, models.QuarantineDeleted:
+	default:
+		http.Error(w, `{"error": "Invalid status"}`, http.StatusBadRequest)
+		return
+	}
+	limit := int64(100)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			http.Error(w, `{"error": "limit must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	uploads, err := scan.List(r.Context(), status, limit)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to list quarantined uploads"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(QuarantinedUploadsResponse{Uploads: uploads})
+}
+
+// @Summary Download a quarantined upload
+// @Description Download the content of a flagged upload for inspection, as an attachment that browsers will not render. The download is audited (Admin only)
+// @Tags admin
+// @Produce octet-stream
+// @Param id path string true "Quarantined upload ID"
+// @Security BearerAuth
+// @Success 200 {file} binary
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Router /admin/uploads/quarantine/{id}/content [get]
+func DownloadQuarantinedUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Invalid upload ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	upload, err := scan.Get(r.Context(), id)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, `{"error": "Upload not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error": "Failed to fetch upload"}`, http.StatusInternalServerError)
+		return
+	}
+	if upload.Content == nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Upload content was deleted"}`, http.StatusGone)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionDownloadUpload, id.Hex(), nil, bson.M{"filename": upload.Filename, "sha256": upload.SHA256}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit quarantined upload download: %v", err)
+	}
+
+	// Never let a browser sniff and render the file
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "quarantine-"+id.Hex()+".bin"))
+	w.Write(upload.Content)
+}
+
+// @Summary Review a quarantined upload
+// @Description Resolve a pending flagged upload. "release" marks it as a false positive, so the same file is accepted when uploaded again; "delete" discards its content (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Quarantined upload ID"
+// @Param request body ReviewUploadRequest true "Review decision"
+// @Security BearerAuth
+// @Success 200 {object} models.QuarantinedUpload
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/uploads/quarantine/{id}/review [post]
+func ReviewQuarantinedUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid upload ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req ReviewUploadRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	status := map[string]string{"release": models.QuarantineReleased, "delete": models.QuarantineDeleted}[req.Decision]
+	if status == "" {
+		http.Error(w, `{"error": "decision must be release or delete"}`, http.StatusBadRequest)
+		return
+	}
+
+	upload, err := scan.Review(r.Context(), id, status, audit.ActorID(r), req.Note)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, `{"error": "Upload not found or already reviewed"}`, http.StatusConflict)
+			return
+		}
+		http.Error(w, `{"error": "Failed to review upload"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionReviewUpload, id.Hex(), bson.M{"status": models.QuarantinePending}, bson.M{"status": status, "note": req.Note}); err != nil {
+		correlation.Errorf(r.Context(), "Failed to audit upload review: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(upload)
+}
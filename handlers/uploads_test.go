@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// quarantined stores a flagged upload of the signed-in user for each
+// status, newest last, setting {upload} to the ID of the last
+func quarantined(statuses ...string) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		for i, status := range statuses {
+			upload := models.QuarantinedUpload{
+				ID: clock.NewID(), Kind: "csv", Filename: "users.csv", DeclaredType: "text/csv", DetectedType: "application/x-msdownload",
+				Size: 4, SHA256: "5d41402abc4b2a76b9719d911017c592", Scanner: "mime", Reason: "Windows executable",
+				UploadedBy: f.user.user.ID.Hex(), Status: status, CreatedAt: clock.Now().Add(time.Duration(i-len(statuses)) * time.Minute),
+			}
+			if status != models.QuarantineDeleted {
+				upload.Content = []byte("MZ\x90\x00")
+			}
+			if _, err := database.DB.Collection("quarantined_uploads").InsertOne(context.Background(), upload); err != nil {
+				t.Fatal(err)
+			}
+			f.set("upload", upload.ID.Hex())
+		}
+	}
+}
+
+func TestListQuarantinedUploads(t *testing.T) {
+	h := http.HandlerFunc(ListQuarantinedUploads)
+	listed := func(want ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var resp QuarantinedUploadsResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			var got []string
+			for _, upload := range resp.Uploads {
+				got = append(got, upload.Status)
+				if upload.Content != nil {
+					t.Fatal("content listed")
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") || resp.Uploads == nil {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+			if len(want) > 1 && resp.Uploads[0].ID.Hex() != f.vars["upload"] {
+				t.Fatal("not newest first")
+			}
+		}
+	}
+	uploads := quarantined(models.QuarantineReleased, models.QuarantinePending, models.QuarantineDeleted, models.QuarantinePending)
+	runCases(t, h, []handlerCase{
+		{name: "pending by default", as: "admin", method: "GET", target: "/admin/uploads/quarantine", setup: uploads, status: http.StatusOK, check: listed(models.QuarantinePending, models.QuarantinePending)},
+		{name: "by status", as: "admin", method: "GET", target: "/admin/uploads/quarantine?status=released", setup: uploads, status: http.StatusOK, check: listed(models.QuarantineReleased)},
+		{name: "limited", as: "admin", method: "GET", target: "/admin/uploads/quarantine?limit=1", setup: uploads, status: http.StatusOK, check: listed(models.QuarantinePending)},
+		{name: "none", as: "admin", method: "GET", target: "/admin/uploads/quarantine", status: http.StatusOK, check: listed()},
+		{name: "unknown status", as: "admin", method: "GET", target: "/admin/uploads/quarantine?status=all", status: http.StatusBadRequest},
+		{name: "limit out of range", as: "admin", method: "GET", target: "/admin/uploads/quarantine?limit=1001", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/uploads/quarantine", setup: dbDown("quarantined_uploads"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDownloadQuarantinedUpload(t *testing.T) {
+	h := route("/admin/uploads/quarantine/{id}/content", http.HandlerFunc(DownloadQuarantinedUpload))
+	runCases(t, h, []handlerCase{
+		{
+			name: "downloaded", as: "admin", method: "GET", target: "/admin/uploads/quarantine/{upload}/content", setup: quarantined(models.QuarantinePending), status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if rec.Body.String() != "MZ\x90\x00" {
+					t.Fatalf("got %q", rec.Body.String())
+				}
+				// The file must never render in the admin's browser
+				header := rec.Header()
+				if header.Get("Content-Type") != "application/octet-stream" || header.Get("X-Content-Type-Options") != "nosniff" || header.Get("Content-Disposition") != `attachment; filename="quarantine-`+f.vars["upload"]+`.bin"` {
+					t.Fatalf("got headers %v", header)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionDownloadUpload, "target_id": f.vars["upload"], "after.filename": "users.csv"}); n != 1 {
+					t.Fatal("download not audited")
+				}
+			},
+		},
+		{name: "deleted content", as: "admin", method: "GET", target: "/admin/uploads/quarantine/{upload}/content", setup: quarantined(models.QuarantineDeleted), status: http.StatusGone},
+		{name: "unknown upload", as: "admin", method: "GET", target: "/admin/uploads/quarantine/{missing}/content", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "GET", target: "/admin/uploads/quarantine/nope/content", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/uploads/quarantine/{upload}/content", setup: setups(quarantined(models.QuarantinePending), dbDown("quarantined_uploads")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestReviewQuarantinedUpload(t *testing.T) {
+	h := route("/admin/uploads/quarantine/{id}/review", http.HandlerFunc(ReviewQuarantinedUpload))
+	reviewed := func(status string, content bool) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+		return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+			var upload models.QuarantinedUpload
+			json.Unmarshal(rec.Body.Bytes(), &upload)
+			if upload.Status != status || upload.ReviewedBy != f.admin.user.ID.Hex() || upload.ReviewNote != "checked" || upload.ReviewedAt == nil {
+				t.Fatalf("got %s", rec.Body.String())
+			}
+			if n := f.srv.Count("quarantined_uploads", bson.M{"status": status, "content": bson.M{"$exists": true}}); (n == 1) != content {
+				t.Fatalf("content kept is %v, want %v", n == 1, content)
+			}
+			if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionReviewUpload, "target_id": f.vars["upload"], "after.status": status}); n != 1 {
+				t.Fatal("review not audited")
+			}
+		}
+	}
+	target := "/admin/uploads/quarantine/{upload}/review"
+	runCases(t, h, []handlerCase{
+		{name: "released", as: "admin", method: "POST", target: target, body: `{"decision":"release","note":"checked"}`, setup: quarantined(models.QuarantinePending), status: http.StatusOK, check: reviewed(models.QuarantineReleased, true)},
+		{name: "deleted", as: "admin", method: "POST", target: target, body: `{"decision":"delete","note":"checked"}`, setup: quarantined(models.QuarantinePending), status: http.StatusOK, check: reviewed(models.QuarantineDeleted, false)},
+		{name: "already reviewed", as: "admin", method: "POST", target: target, body: `{"decision":"delete"}`, setup: quarantined(models.QuarantineReleased), status: http.StatusConflict},
+		{name: "unknown upload", as: "admin", method: "POST", target: "/admin/uploads/quarantine/{missing}/review", body: `{"decision":"delete"}`, status: http.StatusConflict},
+		{name: "unknown decision", as: "admin", method: "POST", target: target, body: `{"decision":"ignore"}`, setup: quarantined(models.QuarantinePending), status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: target, body: `{"decision":`, setup: quarantined(models.QuarantinePending), status: http.StatusBadRequest},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/uploads/quarantine/nope/review", body: `{"decision":"delete"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: target, body: `{"decision":"delete"}`, setup: setups(quarantined(models.QuarantinePending), dbDown("quarantined_uploads")), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/models"
+)
+
+// exportLines decodes the lines of an NDJSON export
+func exportLines(t *testing.T, rec *httptest.ResponseRecorder) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(rec.Body.Bytes()))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// exported checks the export has a line per email, in order
+func exported(emails ...string) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var got []string
+		for _, line := range exportLines(t, rec) {
+			email, _ := line["email"].(string)
+			got = append(got, email)
+		}
+		if strings.Join(got, ",") != strings.Join(emails, ",") {
+			t.Fatalf("got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestExportUsersNDJSON(t *testing.T) {
+	h := ExportUsersNDJSON(testConfig)
+	runCases(t, h, []handlerCase{
+		{
+			name: "all users", as: "admin", method: "GET", target: "/admin/users/export.ndjson", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				exported("admin@example.com", "user@example.com")(t, f, rec)
+				if rec.Header().Get("Content-Type") != "application/x-ndjson" || rec.Header().Get("Cache-Control") != "no-store" {
+					t.Fatalf("got headers %v", rec.Header())
+				}
+				if line := exportLines(t, rec)[1]; line["id"] != f.user.user.ID.Hex() || line["role"] != "user" {
+					t.Fatalf("got %v", line)
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionExportUsers}); n != 1 {
+					t.Fatal("export not audited")
+				}
+			},
+		},
+		{
+			name: "resumed", as: "admin", method: "GET", target: "/admin/users/export.ndjson?cursor={admin}", setup: adminID,
+			status: http.StatusOK, check: exported("user@example.com"),
+		},
+		{name: "limited", as: "admin", method: "GET", target: "/admin/users/export.ndjson?limit=1", status: http.StatusOK, check: exported("admin@example.com")},
+		{name: "by role", as: "admin", method: "GET", target: "/admin/users/export.ndjson?role=user", status: http.StatusOK, check: exported("user@example.com")},
+		{
+			name: "by tag", as: "admin", method: "GET", target: "/admin/users/export.ndjson?tag=beta", status: http.StatusOK, check: exported("user@example.com"),
+			setup: func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"tags": []string{"beta"}}) },
+		},
+		{
+			// Copies of erased users are purged by their forgotten_at
+			name: "forgotten user", as: "admin", method: "GET", target: "/admin/users/export.ndjson?role=user", status: http.StatusOK,
+			setup: func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"forgotten_at": clock.Now()}) },
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if line := exportLines(t, rec)[0]; line["email"] != "" || line["forgotten_at"] == nil {
+					t.Fatalf("got %v", line)
+				}
+			},
+		},
+		{
+			name: "columns", as: "admin", method: "GET", target: "/admin/users/export.ndjson?columns=role,locale", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if line := exportLines(t, rec)[0]; len(line) != 2 || line["id"] != f.admin.user.ID.Hex() || line["role"] != "admin" {
+					t.Fatalf("got %v", line)
+				}
+			},
+		},
+		{
+			name: "saved view", as: "admin", method: "GET", target: "/admin/users/export.ndjson?view=people",
+			setup:  savedView("people", models.AdminView{Filter: models.AdminViewFilter{Role: "user"}, Columns: []string{"email"}}),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if lines := exportLines(t, rec); len(lines) != 1 || len(lines[0]) != 2 || lines[0]["email"] != "user@example.com" {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "saved view overridden", as: "admin", method: "GET", target: "/admin/users/export.ndjson?view=people&role=admin",
+			setup:  savedView("people", models.AdminView{Filter: models.AdminViewFilter{Role: "user"}, Columns: []string{"email"}}),
+			status: http.StatusOK, check: exported("admin@example.com"),
+		},
+		{name: "unknown view", as: "admin", method: "GET", target: "/admin/users/export.ndjson?view=people", status: http.StatusNotFound},
+		{name: "unknown column", as: "admin", method: "GET", target: "/admin/users/export.ndjson?columns=password", status: http.StatusBadRequest},
+		{name: "unknown role", as: "admin", method: "GET", target: "/admin/users/export.ndjson?role=owner", status: http.StatusBadRequest},
+		{name: "invalid cursor", as: "admin", method: "GET", target: "/admin/users/export.ndjson?cursor=nope", status: http.StatusBadRequest},
+		{name: "invalid limit", as: "admin", method: "GET", target: "/admin/users/export.ndjson?limit=0", status: http.StatusBadRequest},
+		{name: "unknown region", as: "admin", method: "GET", target: "/admin/users/export.ndjson?region=mars", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/users/export.ndjson", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/emailverify"
+	"golang-backend/jobs"
+	"golang-backend/keys"
+	"golang-backend/mailer"
+	"golang-backend/models"
+	"golang-backend/ratelimit"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/utils"
+)
+
+// VerifyEmailRequest represents the request for verifying an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" example:"k7Fq2mV9pL1sT7wZ4nC6eH0jU5yA3dF8gR2iO1qEb3J"`
+}
+
+// ResendVerificationRequest asks for a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+}
+
+// ResendVerificationResponse reports a resend request, with when the next
+// one is allowed so clients can show a countdown
+type ResendVerificationResponse struct {
+	Message string `json:"message" example:"If the address is awaiting verification, a new link is on its way"`
+	ratelimit.Allowance
+}
+
+// verificationEmailJob sends the verification email of a user still
+// awaiting verification
+func verificationEmailJob(cfg *config.Config) jobs.Handler {
+	return func(ctx context.Context, payload bson.Raw) error {
+		var job userJob
+		if err := bson.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": job.UserID},
+			repository.Fields("email", "email_hash", "email_unverified", "display_name", "locale", "org_id"))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Verified or deleted since the job was queued
+		if !user.EmailUnverified {
+			jobs.Logf(ctx, "user %s is already verified", job.UserID.Hex())
+			return nil
+		}
+		to, err := keys.Decrypt(ctx, cfg, user.Email)
+		if err != nil {
+			return err
+		}
+		return deliverVerificationEmail(ctx, cfg, to, *user)
+	}
+}
+
+// sendVerificationEmail emails a new verification link to a user in the
+// background
+func sendVerificationEmail(cfg *config.Config, to string, user models.User) {
+	go func() {
+		if err := deliverVerificationEmail(context.Background(), cfg, to, user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
+
+// deliverVerificationEmail issues a verification token and emails its link
+func deliverVerificationEmail(ctx context.Context, cfg *config.Config, to string, user models.User) error {
+	token, err := emailverify.Issue(ctx, user.ID, user.EmailHash)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.EmailVerificationURL
+	if link == "" {
+		link = cfg.AppURL + "/verify-email"
+	}
+	vars := map[string]string{
+		"VerifyURL": link + "?token=" + url.QueryEscape(token),
+		"Hours":     strconv.Itoa(int(emailverify.TTL().Hours())),
+	}
+	msg, err := renderUserEmail(ctx, cfg, "verify_email", to, &user, vars)
+	if err != nil {
+		return err
+	}
+	return sendRequested(mailer.New(cfg), msg)
+}
+
+// VerifyEmail handles email verification
+// @Summary Verify email address
+// @Description Confirm the address a user registered with, using the token from the verification email, in the query (so the emailed link can point here) or the body. The token works once
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Verification token"
+// @Param request body VerifyEmailRequest false "Verification token"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {string} string "Invalid or expired verification token"
+// @Failure 500 {string} string "Internal server error"
+// @Router /verify-email [get]
+// @Router /verify-email [post]
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var req VerifyEmailRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	verification, err := emailverify.Redeem(ctx, token)
+	if errors.Is(err, emailverify.ErrInvalidToken) {
+		security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid email verification token")
+		http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The address must not have changed since the link was sent
+	filter := bson.M{"_id": verification.UserID, "email_hash": verification.EmailHash}
+	_, collection, err := repository.FindUser(ctx, filter, repository.IDOnly)
+	if err != nil {
+		http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+	update := bson.M{
+		"$set":   bson.M{"email_verified_at": clock.Now()},
+		"$unset": bson.M{"email_unverified": ""},
+	}
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	cache.Invalidate(cache.TagUsers)
+	correlation.SetUser(ctx, verification.UserID.Hex())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Email verified"})
+}
+
+// ResendVerification handles requests for a new verification email
+// @Summary Resend the verification email
+// @Description Queue a new verification link for an address awaiting verification. Requests are limited per address to one per VERIFY_EMAIL_RESEND_COOLDOWN and VERIFY_EMAIL_RESEND_DAILY_CAP a day; the response tells when the next is allowed. It is the same whether or not the address belongs to an account
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResendVerificationRequest true "Address to verify"
+// @Success 202 {object} ResendVerificationResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 429 {object} ResendVerificationResponse "Sent too recently or too often today"
+// @Failure 500 {string} string "Internal server error"
+// @Router /verify-email/resend [post]
+func ResendVerification(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResendVerificationRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Email == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		req.Email = utils.NormalizeEmail(req.Email)
+		emailHash := utils.EmailIndex(req.Email, cfg.EmailFoldAliases)
+
+		// Limited per address, registered or not, so the limits do not tell
+		// which addresses have accounts
+		ctx := r.Context()
+		allowance, err := ratelimit.Cooldown(ctx, "verify_email_resend", emailHash, cfg.VerifyEmailResendCooldown, cfg.VerifyEmailResendDailyCap)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !allowance.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(allowance.RetryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(ResendVerificationResponse{Message: "Please wait before requesting another email", Allowance: allowance})
+			return
+		}
+
+		sendAnonymously(func(ctx context.Context) {
+			user, _, err := repository.FindUserByEmailHash(ctx, emailHash, repository.Fields("email_unverified"))
+			if err != nil {
+				if !errors.Is(err, mongo.ErrNoDocuments) {
+					log.Printf("Failed to find user to resend verification email: %v", err)
+				}
+				return
+			}
+			if !user.EmailUnverified {
+				return
+			}
+			if _, err := jobs.Enqueue(ctx, jobVerificationEmail, userJob{UserID: user.ID}); err != nil {
+				log.Printf("Failed to queue verification email for user %s: %v", user.ID.Hex(), err)
+			}
+		})
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ResendVerificationResponse{Message: "If the address is awaiting verification, a new link is on its way", Allowance: allowance})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/emailverify"
+	"golang-backend/utils"
+)
+
+// emailUnverified marks the user's address as awaiting verification and
+// sets {token} to the token of a verification link sent to it
+func emailUnverified(t *testing.T, f *fixture) {
+	setUser(t, f, bson.M{"email_unverified": true})
+	token, err := emailverify.Issue(context.Background(), f.user.user.ID, f.user.user.EmailHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set("token", token)
+}
+
+// checkVerified checks that the user's address was verified
+func checkVerified(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+	if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_unverified": bson.M{"$exists": false}, "email_verified_at": bson.M{"$exists": true}}); n != 1 {
+		t.Fatal("address not verified")
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	runCases(t, http.HandlerFunc(VerifyEmail), []handlerCase{
+		{name: "token in the query", method: "GET", target: "/verify-email?token={token}", setup: emailUnverified, status: http.StatusOK, check: checkVerified},
+		{name: "token in the body", method: "POST", target: "/verify-email", body: `{"token":"{token}"}`, setup: emailUnverified, status: http.StatusOK, check: checkVerified},
+		{
+			name: "token used twice", method: "GET", target: "/verify-email?token={token}",
+			setup: setups(emailUnverified, func(t *testing.T, f *fixture) {
+				if rec := request(http.HandlerFunc(VerifyEmail), "GET", "/verify-email?token="+f.vars["token"], "", ""); rec.Code != http.StatusOK {
+					t.Fatalf("first use: got %d", rec.Code)
+				}
+			}),
+			status: http.StatusBadRequest,
+		},
+		{name: "malformed body", method: "POST", target: "/verify-email", body: `{"token":1}`, status: http.StatusBadRequest},
+		{name: "missing token", method: "GET", target: "/verify-email", status: http.StatusBadRequest},
+		{name: "unknown token", method: "GET", target: "/verify-email?token=unknown", setup: emailUnverified, status: http.StatusBadRequest},
+		{
+			name: "address changed since", method: "GET", target: "/verify-email?token={token}",
+			setup: setups(emailUnverified, func(t *testing.T, f *fixture) {
+				setUser(t, f, bson.M{"email_hash": utils.EmailIndex("new@example.com", testConfig.EmailFoldAliases)})
+			}),
+			status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("users", bson.M{"_id": f.user.user.ID, "email_unverified": true}); n != 1 {
+					t.Fatal("new address verified")
+				}
+			},
+		},
+		{name: "database down", method: "GET", target: "/verify-email?token={token}", setup: setups(emailUnverified, dbDown("email_verifications")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestResendVerification(t *testing.T) {
+	resend := `{"email":"user@example.com"}`
+	runCases(t, ResendVerification(testConfig), []handlerCase{
+		{
+			name: "queues the email", method: "POST", target: "/verify-email/resend", body: resend,
+			setup:  emailUnverified,
+			status: http.StatusAccepted,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ResendVerificationResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if !resp.Allowed || resp.NextAllowedAt.IsZero() {
+					t.Fatalf("got %+v", resp)
+				}
+				eventually(t, "the email is queued", func() bool {
+					return f.srv.Count("jobs", bson.M{"kind": jobVerificationEmail, "payload.user_id": f.user.user.ID}) == 1
+				})
+			},
+		},
+		{
+			name: "sent moments ago", method: "POST", target: "/verify-email/resend", body: resend,
+			setup: setups(emailUnverified, func(t *testing.T, f *fixture) {
+				if rec := request(ResendVerification(testConfig), "POST", "/verify-email/resend", "", resend); rec.Code != http.StatusAccepted {
+					t.Fatalf("first resend: got %d", rec.Code)
+				}
+			}),
+			status: http.StatusTooManyRequests,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Fatal("no Retry-After")
+				}
+			},
+		},
+		{name: "unknown address", method: "POST", target: "/verify-email/resend", body: `{"email":"nobody@example.com"}`, status: http.StatusAccepted},
+		{name: "malformed body", method: "POST", target: "/verify-email/resend", body: `{"email":1}`, status: http.StatusBadRequest},
+		{name: "missing address", method: "POST", target: "/verify-email/resend", body: `{}`, status: http.StatusBadRequest},
+		{name: "database down", method: "POST", target: "/verify-email/resend", body: resend, setup: dbDown("action_limits"), status: http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/adminviews"
+	"golang-backend/cache"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/usertags"
+	"golang-backend/utils"
+)
+
+// AdminViewRequest represents the request for saving a view
+type AdminViewRequest struct {
+	Filter  models.AdminViewFilter `json:"filter"`
+	Sort    string                 `json:"sort,omitempty" example:"-created_at"`
+	Columns []string               `json:"columns,omitempty" example:"email,role,tags"`
+}
+
+// ListAdminViewsResponse represents the views of an admin
+type ListAdminViewsResponse struct {
+	Views []models.AdminView `json:"views"`
+}
+
+// userQuery is what the user list and export show: the view the request
+// names, with the request's own parameters taking precedence
+type userQuery struct {
+	Region  string
+	Role    string
+	Tags    []string
+	Sort    bson.D
+	Columns []string
+}
+
+// filter returns the database filter of the query
+func (q *userQuery) filter() bson.M {
+	filter := bson.M{}
+	if q.Role != "" {
+		filter["role"] = q.Role
+	}
+	if len(q.Tags) > 0 {
+		filter["tags"] = usertags.Filter(q.Tags)
+	}
+	return filter
+}
+
+// requestUserQuery reads the view and filter parameters of the user list and
+// export. It reports false after answering an invalid one.
+func requestUserQuery(w http.ResponseWriter, r *http.Request) (*userQuery, bool) {
+	query := r.URL.Query()
+	view := &models.AdminView{}
+	if name := query.Get("view"); name != "" {
+		adminID, ok := claimsUserID(r)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Saved views require a user session"}`, http.StatusBadRequest)
+			return nil, false
+		}
+		saved, err := adminviews.Get(r.Context(), adminID, name)
+		if errors.Is(err, adminviews.ErrNotFound) {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "View not found"}`, http.StatusNotFound)
+			return nil, false
+		} else if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Failed to fetch view"}`, http.StatusInternalServerError)
+			return nil, false
+		}
+		view = saved
+	}
+
+	if query.Has("region") {
+		view.Filter.Region = query.Get("region")
+	}
+	if query.Has("role") {
+		view.Filter.Role = query.Get("role")
+	}
+	if query.Has("sort") {
+		view.Sort = query.Get("sort")
+	}
+	if query.Has("columns") {
+		view.Columns = strings.Split(query.Get("columns"), ",")
+	}
+	if query.Has("tag") {
+		tags, ok := requestTags(w, r)
+		if !ok {
+			return nil, false
+		}
+		view.Filter.Tags = tags
+	}
+
+	q := &userQuery{Region: view.Filter.Region, Role: view.Filter.Role, Tags: view.Filter.Tags}
+	var err error
+	if q.Role != "" && q.Role != "user" && q.Role != "admin" {
+		err = adminviews.ErrInvalidRole
+	} else if q.Sort, err = adminviews.ParseSort(view.Sort); err == nil {
+		q.Columns, err = adminviews.NormalizeColumns(view.Columns)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return q, true
+}
+
+// pickColumns returns the JSON fields of a record named by columns, and its
+// id
+func pickColumns(record interface{}, columns []string) map[string]json.RawMessage {
+	data, _ := json.Marshal(record)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+
+	picked := map[string]json.RawMessage{"id": fields["id"]}
+	for _, column := range columns {
+		if value, ok := fields[column]; ok {
+			picked[column] = value
+		}
+	}
+	return picked
+}
+
+// @Summary List saved views
+// @Description The views of the user list the admin has saved, by name (Admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ListAdminViewsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/views [get]
+func ListAdminViews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Saved views require a user session"}`, http.StatusBadRequest)
+		return
+	}
+	views, err := adminviews.List(r.Context(), adminID)
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to list views of admin %s: %v", adminID.Hex(), err)
+		http.Error(w, `{"error": "Failed to fetch views"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(ListAdminViewsResponse{Views: views})
+}
+
+// @Summary Save a view
+// @Description Save a filter, sort and columns of the user list under a name, replacing the admin's view with that name. Pass ?view={name} to GET /admin/users or /admin/users/export.ndjson to apply it; parameters of the request take precedence over the view's. Names are 1-64 lowercase letters, digits or _.- and an admin has at most 50 views (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param name path string true "View name"
+// @Param request body AdminViewRequest true "View"
+// @Security BearerAuth
+// @Success 200 {object} models.AdminView
+// @Success 201 {object} models.AdminView
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Too many views"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/views/{name} [put]
+func SaveAdminView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Saved views require a user session"}`, http.StatusBadRequest)
+		return
+	}
+	var req AdminViewRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	view := &models.AdminView{
+		AdminID: adminID,
+		Name:    mux.Vars(r)["name"],
+		Filter:  req.Filter,
+		Sort:    req.Sort,
+		Columns: req.Columns,
+	}
+	if err := adminviews.Normalize(view); err != nil {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	created, err := adminviews.Save(r.Context(), view)
+	if errors.Is(err, adminviews.ErrTooMany) {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		correlation.Errorf(r.Context(), "Failed to save view %q of admin %s: %v", view.Name, adminID.Hex(), err)
+		http.Error(w, `{"error": "Failed to save view"}`, http.StatusInternalServerError)
+		return
+	}
+	// Cached lists of the view show its previous configuration
+	cache.Invalidate(cache.TagUsers)
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(view)
+}
+
+// @Summary Delete a view
+// @Description Delete one of the admin's saved views (Admin only)
+// @Tags admin
+// @Produce json
+// @Param name path string true "View name"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/views/{name} [delete]
+func DeleteAdminView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Saved views require a user session"}`, http.StatusBadRequest)
+		return
+	}
+	err := adminviews.Delete(r.Context(), adminID, mux.Vars(r)["name"])
+	if errors.Is(err, adminviews.ErrNotFound) {
+		http.Error(w, `{"error": "View not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		correlation.Errorf(r.Context(), "Failed to delete view of admin %s: %v", adminID.Hex(), err)
+		http.Error(w, `{"error": "Failed to delete view"}`, http.StatusInternalServerError)
+		return
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "View deleted"})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/adminviews"
+	"golang-backend/models"
+)
+
+// savedView saves a view of the admin under name
+func savedView(name string, view models.AdminView) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		t.Helper()
+		view.AdminID, view.Name = f.admin.user.ID, name
+		if err := adminviews.Normalize(&view); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := adminviews.Save(context.Background(), &view); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListAdminViews(t *testing.T) {
+	h := http.HandlerFunc(ListAdminViews)
+	runCases(t, h, []handlerCase{
+		{
+			name: "by name", as: "admin", method: "GET", target: "/admin/views",
+			setup:  setups(savedView("signups", models.AdminView{Sort: "-created_at"}), savedView("admins", models.AdminView{Filter: models.AdminViewFilter{Role: "admin"}})),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp ListAdminViewsResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if len(resp.Views) != 2 || resp.Views[0].Name != "admins" || resp.Views[0].Filter.Role != "admin" || resp.Views[1].Name != "signups" {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "none", as: "admin", method: "GET", target: "/admin/views", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"views":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/views", setup: dbDown("admin_views"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestSaveAdminView(t *testing.T) {
+	h := route("/admin/views/{name}", http.HandlerFunc(SaveAdminView))
+	saved := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var view models.AdminView
+		json.Unmarshal(rec.Body.Bytes(), &view)
+		if view.Name != "beta" || view.Filter.Role != "user" || strings.Join(view.Filter.Tags, ",") != "beta" || strings.Join(view.Columns, ",") != "email,tags" {
+			t.Fatalf("got %s", rec.Body.String())
+		}
+		if n := f.srv.Count("admin_views", bson.M{"admin_id": f.admin.user.ID, "name": "beta", "sort": "display_name"}); n != 1 {
+			t.Fatal("view not stored")
+		}
+	}
+	body := `{"filter":{"role":"user","tags":["Beta"]},"sort":"display_name","columns":["email","Tags","email"]}`
+	runCases(t, h, []handlerCase{
+		{name: "created", as: "admin", method: "PUT", target: "/admin/views/Beta", body: body, status: http.StatusCreated, check: saved},
+		{name: "replaced", as: "admin", method: "PUT", target: "/admin/views/beta", body: body, setup: savedView("beta", models.AdminView{Sort: "-created_at"}), status: http.StatusOK, check: saved},
+		{
+			name: "too many", as: "admin", method: "PUT", target: "/admin/views/beta", body: body, status: http.StatusConflict,
+			setup: func(t *testing.T, f *fixture) {
+				for i := 0; i < adminviews.MaxPerAdmin; i++ {
+					savedView(fmt.Sprintf("view-%d", i), models.AdminView{})(t, f)
+				}
+			},
+		},
+		{name: "invalid name", as: "admin", method: "PUT", target: "/admin/views/-beta", body: body, status: http.StatusBadRequest},
+		{name: "unknown sort", as: "admin", method: "PUT", target: "/admin/views/beta", body: `{"sort":"email"}`, status: http.StatusBadRequest},
+		{name: "unknown column", as: "admin", method: "PUT", target: "/admin/views/beta", body: `{"columns":["password"]}`, status: http.StatusBadRequest},
+		{name: "unknown role", as: "admin", method: "PUT", target: "/admin/views/beta", body: `{"filter":{"role":"owner"}}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "PUT", target: "/admin/views/beta", body: `{"filter":[]}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "PUT", target: "/admin/views/beta", body: body, setup: dbDown("admin_views"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestDeleteAdminView(t *testing.T) {
+	h := route("/admin/views/{name}", http.HandlerFunc(DeleteAdminView))
+	runCases(t, h, []handlerCase{
+		{
+			name: "deleted", as: "admin", method: "DELETE", target: "/admin/views/beta", setup: savedView("beta", models.AdminView{}), status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("admin_views", bson.M{}); n != 0 {
+					t.Fatal("view still stored")
+				}
+			},
+		},
+		{name: "unknown view", as: "admin", method: "DELETE", target: "/admin/views/beta", status: http.StatusNotFound},
+		{
+			// Views are per admin
+			name: "view of another admin", as: "user", method: "DELETE", target: "/admin/views/beta", setup: savedView("beta", models.AdminView{}), status: http.StatusNotFound,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("admin_views", bson.M{}); n != 1 {
+					t.Fatal("view deleted")
+				}
+			},
+		},
+		{name: "database down", as: "admin", method: "DELETE", target: "/admin/views/beta", setup: setups(savedView("beta", models.AdminView{}), dbDown("admin_views")), status: http.StatusInternalServerError},
+	})
+}
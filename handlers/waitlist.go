@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/geoip"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+	"golang-backend/signupgate"
+	"golang-backend/tenant"
+	"golang-backend/utils"
+)
+
+// WaitlistedUserResponse is a user waiting for activation
+type WaitlistedUserResponse struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Rule        string    `json:"rule" example:"waitlist:country!=US|CA"`
+	Country     string    `json:"country,omitempty" example:"DE"`
+	WaitingFrom time.Time `json:"waiting_from"`
+}
+
+// WaitlistResponse is a page of the waitlist, longest waiting first
+type WaitlistResponse struct {
+	Users      []WaitlistedUserResponse `json:"users"`
+	Total      int                      `json:"total"`
+	Page       int                      `json:"page"`
+	Limit      int                      `json:"limit"`
+	TotalPages int                      `json:"total_pages"`
+}
+
+// ActivateWaitlistRequest activates the users who have waited longest
+type ActivateWaitlistRequest struct {
+	Count  int    `json:"count" example:"100"`
+	Region string `json:"region,omitempty" example:"eu"`
+}
+
+// ActivateWaitlistResponse reports the users activated
+type ActivateWaitlistResponse struct {
+	Activated []string `json:"activated"`
+}
+
+// maxWaitlistActivation bounds the users activated by one request
+const maxWaitlistActivation = 1000
+
+// gateSignup evaluates the signup gate for a registration and returns the
+// waitlist entry to store, or nil when the user is activated
+func gateSignup(r *http.Request, email string, invited bool, now time.Time) *models.WaitlistEntry {
+	if !signupgate.Enabled() {
+		return nil
+	}
+	attrs := signupgate.Attributes{Country: geoip.Country(r), Invited: invited}
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		attrs.EmailDomain = email[at+1:]
+	}
+	action, rule := signupgate.Evaluate(attrs)
+	if action != signupgate.ActionWaitlist {
+		return nil
+	}
+	return &models.WaitlistEntry{Rule: rule, Country: attrs.Country, At: now}
+}
+
+// @Summary List the waitlist
+// @Description Users the signup gate put on the waitlist, longest waiting first. They cannot sign in until activated (Admin only)
+// @Tags admin
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Param region query string false "Data residency region (defaults to the default region)"
+// @Security BearerAuth
+// @Success 200 {object} WaitlistResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/waitlist [get]
+func ListWaitlist(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		page, limit := 1, 10
+		if p := r.URL.Query().Get("page"); p != "" {
+			if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+				page = parsed
+			}
+		}
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+				limit = parsed
+			}
+		}
+
+		ctx := r.Context()
+		collection, filter, err := waitlistScope(r, r.URL.Query().Get("region"))
+		if err != nil {
+			http.Error(w, `{"error": "Unknown region"}`, http.StatusBadRequest)
+			return
+		}
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to count users"}`, http.StatusInternalServerError)
+			return
+		}
+
+		opts := options.Find().SetSkip(int64((page - 1) * limit)).SetLimit(int64(limit)).
+			SetSort(bson.D{{Key: "waitlist.at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"email": 1, "display_name": 1, "waitlist": 1})
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to fetch users"}`, http.StatusInternalServerError)
+			return
+		}
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			http.Error(w, `{"error": "Failed to decode users"}`, http.StatusInternalServerError)
+			return
+		}
+
+		response := WaitlistResponse{
+			Users:      make([]WaitlistedUserResponse, 0, len(users)),
+			Total:      int(total),
+			Page:       page,
+			Limit:      limit,
+			TotalPages: (int(total) + limit - 1) / limit,
+		}
+		for _, user := range users {
+			email, err := keys.Decrypt(ctx, cfg, user.Email)
+			if err != nil && !errors.Is(err, keys.ErrKeyDestroyed) {
+				http.Error(w, `{"error": "Failed to decrypt user data"}`, http.StatusInternalServerError)
+				return
+			}
+			response.Users = append(response.Users, WaitlistedUserResponse{
+				ID:          user.ID.Hex(),
+				Email:       email,
+				DisplayName: user.DisplayName,
+				Rule:        user.Waitlist.Rule,
+				Country:     user.Waitlist.Country,
+				WaitingFrom: user.Waitlist.At,
+			})
+		}
+		utils.WriteJSON(w, response)
+	}
+}
+
+// @Summary Activate a waitlisted user
+// @Description Take a user off the waitlist so they can sign in, and email them that their account is active (Admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "User not found or not waitlisted"
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/waitlist/{id}/activate [post]
+func ActivateWaitlistedUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+		collection, err := repository.LocateUser(r.Context(), userID)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found or not waitlisted"}`, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, `{"error": "Failed to locate user"}`, http.StatusInternalServerError)
+			return
+		}
+
+		filter := bson.M{"_id": userID}
+		if orgID := tenant.OrgID(r); orgID != "" {
+			filter["org_id"] = orgID
+		}
+		switch err := activateUser(r, cfg, collection, filter); {
+		case err == mongo.ErrNoDocuments:
+			http.Error(w, `{"error": "User not found or not waitlisted"}`, http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, `{"error": "Failed to activate user"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "User activated"})
+	}
+}
+
+// @Summary Activate the longest waiting users
+// @Description Activate up to count users of a region in the order they joined the waitlist, at most 1000 at a time, and email each that their account is active (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body ActivateWaitlistRequest true "Number of users to activate"
+// @Security BearerAuth
+// @Success 200 {object} ActivateWaitlistResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/waitlist/activate [post]
+func ActivateWaitlist(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req ActivateWaitlistRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil {
+			http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Count < 1 || req.Count > maxWaitlistActivation {
+			http.Error(w, `{"error": "count must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		collection, filter, err := waitlistScope(r, req.Region)
+		if err != nil {
+			http.Error(w, `{"error": "Unknown region"}`, http.StatusBadRequest)
+			return
+		}
+		opts := options.Find().SetLimit(int64(req.Count)).
+			SetSort(bson.D{{Key: "waitlist.at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"_id": 1})
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to fetch users"}`, http.StatusInternalServerError)
+			return
+		}
+		var waiting []models.User
+		if err := cursor.All(ctx, &waiting); err != nil {
+			http.Error(w, `{"error": "Failed to decode users"}`, http.StatusInternalServerError)
+			return
+		}
+
+		response := ActivateWaitlistResponse{Activated: []string{}}
+		for _, user := range waiting {
+			// Users activated by someone else in the meantime are skipped
+			err := activateUser(r, cfg, collection, bson.M{"_id": user.ID})
+			if err == mongo.ErrNoDocuments {
+				continue
+			} else if err != nil {
+				correlation.Errorf(ctx, "Failed to activate user %s: %v", user.ID.Hex(), err)
+				break
+			}
+			response.Activated = append(response.Activated, user.ID.Hex())
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// waitlistScope returns the users collection and filter of the waitlist. On
+// an organization's custom domain it is limited to its members, in its region.
+func waitlistScope(r *http.Request, region string) (*mongo.Collection, bson.M, error) {
+	filter := bson.M{"waitlist": bson.M{"$exists": true}}
+	if orgID := tenant.OrgID(r); orgID != "" {
+		filter["org_id"] = orgID
+		collection, err := repository.UsersForOrg(r.Context(), orgID)
+		return collection, filter, err
+	}
+	collection, err := repository.Users(region)
+	return collection, filter, err
+}
+
+// activateUser takes the user matching filter off the waitlist, records it in
+// the audit log and emails the user. It returns mongo.ErrNoDocuments when no
+// waitlisted user matches.
+func activateUser(r *http.Request, cfg *config.Config, collection *mongo.Collection, filter bson.M) error {
+	ctx := r.Context()
+	filter["waitlist"] = bson.M{"$exists": true}
+	update := bson.M{
+		"$unset": bson.M{"waitlist": ""},
+		"$set":   bson.M{"updated_at": clock.Now()},
+	}
+	var user models.User
+	if err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&user); err != nil {
+		return err
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	if _, err := audit.Record(r, audit.ActionActivateUser, user.ID.Hex(), bson.M{"waitlist": user.Waitlist}, nil); err != nil {
+		correlation.Errorf(ctx, "Failed to audit activation of user %s: %v", user.ID.Hex(), err)
+	}
+
+	email, err := keys.Decrypt(ctx, cfg, user.Email)
+	if err != nil {
+		correlation.Errorf(ctx, "Failed to decrypt email of activated user %s: %v", user.ID.Hex(), err)
+		return nil
+	}
+	user.Waitlist = nil
+	sendActivationEmail(cfg, email, user)
+	return nil
+}
+
+// sendActivationEmail tells a user in the background that their account left
+// the waitlist. They asked to be told, so it cannot be muted.
+func sendActivationEmail(cfg *config.Config, to string, user models.User) {
+	go func() {
+		msg, err := renderUserEmail(context.Background(), cfg, "account_activated", to, &user, nil)
+		if err != nil {
+			log.Printf("Failed to render activation email for user %s: %v", user.ID.Hex(), err)
+			return
+		}
+		notice := notifier.UserMessage{Type: "account.activated", Category: models.CategorySecurity, Email: &msg, Title: msg.Subject}
+		if err := notifier.Deliver(context.Background(), cfg, &user, notice); err != nil {
+			log.Printf("Failed to send activation email to user %s: %v", user.ID.Hex(), err)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// waitlisted puts the signed-in user on the waitlist an hour ago and a
+// second user, {waiting}, a minute ago
+func waitlisted(t *testing.T, f *fixture) {
+	t.Helper()
+	now := clock.Now()
+	setUser(t, f, bson.M{"waitlist": models.WaitlistEntry{Rule: "waitlist:country!=US", Country: "DE", At: now.Add(-time.Hour)}})
+	waiting := createUser(t, "waiting@example.com", "user")
+	update := bson.M{"$set": bson.M{"waitlist": models.WaitlistEntry{Rule: "waitlist:*", At: now.Add(-time.Minute)}}}
+	if _, err := database.DB.Collection("users").UpdateByID(context.Background(), waiting.ID, update); err != nil {
+		t.Fatal(err)
+	}
+	f.set("waiting", waiting.ID.Hex())
+}
+
+// stillWaiting checks how many users are on the waitlist
+func stillWaiting(want int) func(*testing.T, *fixture, *httptest.ResponseRecorder) {
+	return func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		if n := f.srv.Count("users", bson.M{"waitlist": bson.M{"$exists": true}}); n != want {
+			t.Fatalf("%d users waiting, want %d", n, want)
+		}
+	}
+}
+
+func TestListWaitlist(t *testing.T) {
+	h := ListWaitlist(testConfig)
+	runCases(t, h, []handlerCase{
+		{
+			name: "longest waiting first", as: "admin", method: "GET", target: "/admin/waitlist", setup: waitlisted, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp WaitlistResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Total != 2 || len(resp.Users) != 2 || resp.Users[1].ID != f.vars["waiting"] {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+				if first := resp.Users[0]; first.Email != "user@example.com" || first.Rule != "waitlist:country!=US" || first.Country != "DE" {
+					t.Fatalf("got %+v", first)
+				}
+			},
+		},
+		{
+			name: "paged", as: "admin", method: "GET", target: "/admin/waitlist?page=2&limit=1", setup: waitlisted, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var resp WaitlistResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.TotalPages != 2 || resp.Page != 2 || len(resp.Users) != 1 || resp.Users[0].Email != "waiting@example.com" {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{
+			name: "empty", as: "admin", method: "GET", target: "/admin/waitlist", status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"users":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "unknown region", as: "admin", method: "GET", target: "/admin/waitlist?region=mars", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "GET", target: "/admin/waitlist", setup: dbDown("users"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestActivateWaitlistedUser(t *testing.T) {
+	h := route("/admin/waitlist/{id}/activate", ActivateWaitlistedUser(testConfig))
+	runCases(t, h, []handlerCase{
+		{
+			name: "activated", as: "admin", method: "POST", target: "/admin/waitlist/{waiting}/activate", setup: waitlisted, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				stillWaiting(1)(t, f, rec)
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionActivateUser, "target_id": f.vars["waiting"], "before.waitlist.rule": "waitlist:*"}); n != 1 {
+					t.Fatal("activation not audited")
+				}
+			},
+		},
+		{name: "not waitlisted", as: "admin", method: "POST", target: "/admin/waitlist/{user}/activate", status: http.StatusNotFound},
+		{name: "unknown user", as: "admin", method: "POST", target: "/admin/waitlist/{missing}/activate", status: http.StatusNotFound},
+		{name: "invalid ID", as: "admin", method: "POST", target: "/admin/waitlist/nope/activate", status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/waitlist/{waiting}/activate", setup: setups(waitlisted, dbDown("users")), status: http.StatusInternalServerError, check: stillWaiting(2)},
+	})
+}
+
+func TestActivateWaitlist(t *testing.T) {
+	h := ActivateWaitlist(testConfig)
+	activated := func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+		var resp ActivateWaitlistResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Activated) != 1 || resp.Activated[0] != f.user.user.ID.Hex() {
+			t.Fatalf("got %s", rec.Body.String())
+		}
+		stillWaiting(1)(t, f, rec)
+	}
+	runCases(t, h, []handlerCase{
+		{name: "longest waiting", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":1}`, setup: waitlisted, status: http.StatusOK, check: activated},
+		{name: "all", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":1000}`, setup: waitlisted, status: http.StatusOK, check: stillWaiting(0)},
+		{
+			name: "none waiting", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":10}`, status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if !strings.Contains(rec.Body.String(), `"activated":[]`) {
+					t.Fatalf("got %s", rec.Body.String())
+				}
+			},
+		},
+		{name: "too many", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":1001}`, status: http.StatusBadRequest},
+		{name: "none", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":0}`, status: http.StatusBadRequest},
+		{name: "unknown region", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":1,"region":"mars"}`, status: http.StatusBadRequest},
+		{name: "malformed body", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":"1"}`, status: http.StatusBadRequest},
+		{name: "database down", as: "admin", method: "POST", target: "/admin/waitlist/activate", body: `{"count":1}`, setup: setups(waitlisted, dbDown("users")), status: http.StatusInternalServerError, check: stillWaiting(2)},
+	})
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/keys"
+	"golang-backend/loginhistory"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+	"golang-backend/webauthn"
+)
+
+// maxPasskeyName bounds the name users give a passkey
+const maxPasskeyName = 64
+
+// PasskeyCreationResponse starts registering a passkey. Pass publicKey to
+// PublicKeyCredential.parseCreationOptionsFromJSON and the result to
+// navigator.credentials.create.
+type PasskeyCreationResponse struct {
+	Session   string                   `json:"session" example:"q3ZC1Xb0m9kT6yW2vR8pLg"`
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// PasskeyRegistrationRequest finishes registering a passkey with the
+// credential's toJSON()
+type PasskeyRegistrationRequest struct {
+	Session    string                       `json:"session" example:"q3ZC1Xb0m9kT6yW2vR8pLg"`
+	Name       string                       `json:"name,omitempty" example:"MacBook"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// PasskeyRequestResponse starts signing in with a passkey. Pass publicKey to
+// PublicKeyCredential.parseRequestOptionsFromJSON and the result to
+// navigator.credentials.get.
+type PasskeyRequestResponse struct {
+	Session   string                  `json:"session" example:"q3ZC1Xb0m9kT6yW2vR8pLg"`
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+// PasskeyLoginRequest signs in with the credential's toJSON()
+type PasskeyLoginRequest struct {
+	Session    string                     `json:"session" example:"q3ZC1Xb0m9kT6yW2vR8pLg"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// PasskeysResponse lists the caller's passkeys
+type PasskeysResponse struct {
+	Passkeys []webauthn.Credential `json:"passkeys"`
+}
+
+// BeginPasskeyRegistration handles starting to register a passkey
+// @Summary Start registering a passkey
+// @Description Start the WebAuthn registration ceremony for a passkey on the caller's account. The options exclude passkeys already registered, and the session must be sent to POST /webauthn/register/finish within WEBAUTHN_TIMEOUT. Requires a session elevated with POST /auth/sudo, since the passkey can sign in to the account
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} PasskeyCreationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/register/begin [post]
+func BeginPasskeyRegistration(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, ok := claimsUserID(r)
+		if !ok {
+			http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.Fields("email", "display_name"))
+		if err != nil {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		email, err := keys.Decrypt(ctx, cfg, user.Email)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to decrypt email"}`, http.StatusInternalServerError)
+			return
+		}
+		displayName := user.DisplayName
+		if displayName == "" {
+			displayName = email
+		}
+
+		session, opts, err := webauthn.BeginRegistration(ctx, userID, email, displayName)
+		if err != nil {
+			correlation.Errorf(ctx, "Failed to start passkey registration for user %s: %v", userID.Hex(), err)
+			http.Error(w, `{"error": "Failed to start registration"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(PasskeyCreationResponse{Session: session, PublicKey: *opts})
+	}
+}
+
+// @Summary Finish registering a passkey
+// @Description Verify the credential created for the options of POST /webauthn/register/begin and save it under the given name. The passkey can then sign in with POST /webauthn/login/finish, alongside the password
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body PasskeyRegistrationRequest true "Ceremony session, name and created credential"
+// @Success 201 {object} webauthn.Credential
+// @Failure 400 {object} ErrorResponse "Invalid or expired ceremony, or a credential that fails verification"
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "Passkey already registered"
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/register/finish [post]
+func FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	var req PasskeyRegistrationRequest
+	if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Session == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxPasskeyName {
+		http.Error(w, `{"error": "name must be at most 64 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	credential, err := webauthn.FinishRegistration(ctx, userID, req.Session, name, req.Credential)
+	switch {
+	case errors.Is(err, webauthn.ErrCeremony):
+		http.Error(w, `{"error": "Registration expired, please start again"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, webauthn.ErrInvalidResponse):
+		security.Emit(r, security.EventPasskeyChange, security.OutcomeFailure, userID.Hex(), err.Error())
+		http.Error(w, `{"error": "The passkey could not be verified"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, webauthn.ErrCredentialExists):
+		http.Error(w, `{"error": "This passkey is already registered"}`, http.StatusConflict)
+		return
+	case err != nil:
+		correlation.Errorf(ctx, "Failed to register passkey for user %s: %v", userID.Hex(), err)
+		http.Error(w, `{"error": "Failed to save passkey"}`, http.StatusInternalServerError)
+		return
+	}
+
+	after := bson.M{"passkey_id": credential.ID, "name": credential.Name, "aaguid": credential.AAGUID}
+	if _, err := audit.Record(r, audit.ActionAddPasskey, userID.Hex(), nil, after); err != nil {
+		correlation.Errorf(ctx, "Failed to audit passkey registration of user %s: %v", userID.Hex(), err)
+	}
+	security.Emit(r, security.EventPasskeyChange, security.OutcomeSuccess, userID.Hex(), "added")
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+// @Summary List your passkeys
+// @Description List the passkeys registered to the caller's account, oldest first, with when each last signed in
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} PasskeysResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/passkeys [get]
+func ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	passkeys, err := webauthn.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to fetch passkeys"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(PasskeysResponse{Passkeys: passkeys})
+}
+
+// @Summary Remove a passkey
+// @Description Remove a passkey from the caller's account, so it can no longer sign in. Delete it from the authenticator too, or it keeps offering it
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Passkey ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /user/passkeys/{id} [delete]
+func RemovePasskey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := claimsUserID(r)
+	if !ok {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid passkey ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if err := webauthn.Remove(ctx, userID, id); errors.Is(err, webauthn.ErrNotFound) {
+		http.Error(w, `{"error": "Passkey not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error": "Failed to remove passkey"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := audit.Record(r, audit.ActionRemovePasskey, userID.Hex(), bson.M{"passkey_id": id}, nil); err != nil {
+		correlation.Errorf(ctx, "Failed to audit passkey removal of user %s: %v", userID.Hex(), err)
+	}
+	security.Emit(r, security.EventPasskeyChange, security.OutcomeSuccess, userID.Hex(), "removed")
+
+	json.NewEncoder(w).Encode(SuccessResponse{Message: "Passkey removed"})
+}
+
+// BeginPasskeyLogin handles starting to sign in with a passkey
+// @Summary Start signing in with a passkey
+// @Description Start the WebAuthn authentication ceremony. No username is needed: the authenticator offers the passkeys it holds for this site. Send the session to POST /webauthn/login/finish within WEBAUTHN_TIMEOUT
+// @Tags auth
+// @Produce json
+// @Success 200 {object} PasskeyRequestResponse
+// @Failure 429 {string} string "Too many requests"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webauthn/login/begin [post]
+func BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	session, opts, err := webauthn.BeginLogin(r.Context())
+	if err != nil {
+		correlation.Errorf(r.Context(), "Failed to start passkey sign-in: %v", err)
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PasskeyRequestResponse{Session: session, PublicKey: *opts})
+}
+
+// FinishPasskeyLogin handles signing in with a passkey
+// @Summary Sign in with a passkey
+// @Description Verify the assertion made for the options of POST /webauthn/login/begin and return the same session as POST /login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body PasskeyLoginRequest true "Ceremony session and assertion"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid passkey"
+// @Failure 403 {string} string "Account suspended"
+// @Failure 429 {string} string "Too many requests"
+// @Failure 500 {string} string "Internal server error"
+// @Router /webauthn/login/finish [post]
+func FinishPasskeyLogin(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PasskeyLoginRequest
+		if err := utils.DecodeJSON(r.Body, &req); err != nil || req.Session == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		credential, err := webauthn.FinishLogin(ctx, req.Session, req.Credential)
+		if errors.Is(err, webauthn.ErrCeremony) || errors.Is(err, webauthn.ErrInvalidResponse) || errors.Is(err, webauthn.ErrUnknownCredential) {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, "", err.Error())
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			correlation.Errorf(ctx, "Failed to verify passkey: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": credential.UserID}, repository.CredentialFields)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		userID := user.ID.Hex()
+		if orgID := tenant.OrgID(r); orgID != "" && user.OrgID != orgID {
+			security.Emit(r, security.EventLoginFailure, security.OutcomeFailure, userID, "not a member of the tenant")
+			recordLogin(r, userID, loginhistory.MethodPasskey, false, "not a member of the tenant")
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		}
+		if user.Suspended {
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "account suspended")
+			recordLogin(r, userID, loginhistory.MethodPasskey, false, "account suspended")
+			http.Error(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+		if status, msg := signInAllowed(r, user); status != http.StatusOK {
+			recordLogin(r, userID, loginhistory.MethodPasskey, false, msg)
+			http.Error(w, msg, status)
+			return
+		}
+
+		session, err := issueSession(ctx, cfg, user, tokens.ClientOf(r), "", false)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		correlation.SetUser(ctx, userID)
+		security.Emit(r, security.EventLoginSuccess, security.OutcomeSuccess, userID, "passkey")
+		recordLogin(r, userID, loginhistory.MethodPasskey, true, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/audit"
+	"golang-backend/webauthn/webauthntest"
+)
+
+// newPasskey returns a software passkey for the test server's APP_URL
+func newPasskey(t *testing.T) *webauthntest.Authenticator {
+	return webauthntest.New(t, testConfig.AppURL)
+}
+
+// beginPasskey starts registering a passkey as s and returns the request
+// finishing it with a
+func beginPasskey(t *testing.T, s *session, a *webauthntest.Authenticator) PasskeyRegistrationRequest {
+	t.Helper()
+	rec := s.do(BeginPasskeyRegistration(testConfig), "POST", "/webauthn/register/begin", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("begin registration: got %d %s", rec.Code, rec.Body)
+	}
+	var begin PasskeyCreationResponse
+	json.Unmarshal(rec.Body.Bytes(), &begin)
+	return PasskeyRegistrationRequest{Session: begin.Session, Name: "Laptop", Credential: a.Create(&begin.PublicKey)}
+}
+
+// addPasskey registers a new passkey to s
+func addPasskey(t *testing.T, s *session) *webauthntest.Authenticator {
+	t.Helper()
+	a := newPasskey(t)
+	rec := s.do(http.HandlerFunc(FinishPasskeyRegistration), "POST", "/webauthn/register/finish", marshal(t, beginPasskey(t, s, a)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("finish registration: got %d %s", rec.Code, rec.Body)
+	}
+	return a
+}
+
+// passkeyRegistration sets {registration} to a request registering a new
+// passkey to the user; change, when set, alters it first
+func passkeyRegistration(change func(req *PasskeyRegistrationRequest)) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		req := beginPasskey(t, f.user, newPasskey(t))
+		if change != nil {
+			change(&req)
+		}
+		f.set("registration", marshal(t, req))
+	}
+}
+
+// passkeyLogin registers a passkey to the user and sets {login} to a request
+// signing in with it. sign, when set, returns the signature sent for the
+// assertion, after changing it as it likes.
+func passkeyLogin(sign func(a *webauthntest.Authenticator, as *webauthntest.Assertion) []byte) func(*testing.T, *fixture) {
+	return func(t *testing.T, f *fixture) {
+		a := addPasskey(t, f.user)
+		rec := request(http.HandlerFunc(BeginPasskeyLogin), "POST", "/webauthn/login/begin", "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("begin sign-in: got %d %s", rec.Code, rec.Body)
+		}
+		var begin PasskeyRequestResponse
+		json.Unmarshal(rec.Body.Bytes(), &begin)
+		as := a.Assert(begin.PublicKey.Challenge)
+		sig := as.Sign(a.Key)
+		if sign != nil {
+			sig = sign(a, as)
+		}
+		f.set("login", marshal(t, PasskeyLoginRequest{Session: begin.Session, Credential: a.Response(as, sig)}))
+	}
+}
+
+func TestBeginPasskeyRegistration(t *testing.T) {
+	runCases(t, BeginPasskeyRegistration(testConfig), []handlerCase{
+		{
+			name: "returns the options", as: "user", method: "POST", target: "/webauthn/register/begin",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var begin PasskeyCreationResponse
+				json.Unmarshal(rec.Body.Bytes(), &begin)
+				if begin.Session == "" || begin.PublicKey.Challenge == "" || begin.PublicKey.User.Name != "user@example.com" {
+					t.Fatalf("got %+v", begin)
+				}
+			},
+		},
+		{
+			name: "excludes registered passkeys", as: "user", method: "POST", target: "/webauthn/register/begin",
+			setup:  func(t *testing.T, f *fixture) { addPasskey(t, f.user) },
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var begin PasskeyCreationResponse
+				json.Unmarshal(rec.Body.Bytes(), &begin)
+				if len(begin.PublicKey.ExcludeCredentials) != 1 {
+					t.Fatalf("excluded %+v", begin.PublicKey.ExcludeCredentials)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/webauthn/register/begin", status: http.StatusUnauthorized},
+		{name: "deleted after sign-in", as: "user", method: "POST", target: "/webauthn/register/begin", setup: userDeleted, status: http.StatusNotFound},
+		{name: "database down", as: "user", method: "POST", target: "/webauthn/register/begin", setup: dbDown("webauthn_ceremonies"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestFinishPasskeyRegistration(t *testing.T) {
+	runCases(t, http.HandlerFunc(FinishPasskeyRegistration), []handlerCase{
+		{
+			name: "registers the passkey", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  passkeyRegistration(nil),
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("webauthn_credentials", bson.M{"user_id": f.user.user.ID, "name": "Laptop"}); n != 1 {
+					t.Fatal("passkey not stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionAddPasskey, "target_id": f.user.user.ID.Hex()}); n != 1 {
+					t.Fatal("registration not audited")
+				}
+			},
+		},
+		{
+			name: "unnamed", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  passkeyRegistration(func(req *PasskeyRegistrationRequest) { req.Name = "  " }),
+			status: http.StatusCreated,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("webauthn_credentials", bson.M{"name": "Passkey"}); n != 1 {
+					t.Fatal("passkey not given the default name")
+				}
+			},
+		},
+		{name: "malformed body", as: "user", method: "POST", target: "/webauthn/register/finish", body: `{`, status: http.StatusBadRequest},
+		{name: "missing session", as: "user", method: "POST", target: "/webauthn/register/finish", body: `{"credential":{}}`, status: http.StatusBadRequest},
+		{
+			name: "name too long", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  passkeyRegistration(func(req *PasskeyRegistrationRequest) { req.Name = strings.Repeat("n", maxPasskeyName+1) }),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "unknown ceremony", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  passkeyRegistration(func(req *PasskeyRegistrationRequest) { req.Session = "unknown" }),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "created for another site", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup: func(t *testing.T, f *fixture) {
+				req := beginPasskey(t, f.user, webauthntest.New(t, "https://evil.example.com"))
+				f.set("registration", marshal(t, req))
+			},
+			status: http.StatusBadRequest,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("webauthn_credentials", bson.M{}); n != 0 {
+					t.Fatal("passkey stored")
+				}
+			},
+		},
+		{
+			name: "ceremony of another user", as: "admin", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  passkeyRegistration(nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "already registered", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup: func(t *testing.T, f *fixture) {
+				a := addPasskey(t, f.user)
+				f.set("registration", marshal(t, beginPasskey(t, f.user, a)))
+			},
+			status: http.StatusConflict,
+		},
+		{name: "no session", as: "guest", method: "POST", target: "/webauthn/register/finish", body: `{"session":"s"}`, status: http.StatusUnauthorized},
+		{
+			name: "database down", as: "user", method: "POST", target: "/webauthn/register/finish", body: "{registration}",
+			setup:  setups(passkeyRegistration(nil), dbDown("webauthn_credentials")),
+			status: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestListPasskeys(t *testing.T) {
+	runCases(t, http.HandlerFunc(ListPasskeys), []handlerCase{
+		{
+			name: "lists the caller's passkeys", as: "user", method: "GET", target: "/user/passkeys",
+			setup: func(t *testing.T, f *fixture) {
+				addPasskey(t, f.user)
+				addPasskey(t, f.admin)
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var list PasskeysResponse
+				json.Unmarshal(rec.Body.Bytes(), &list)
+				if len(list.Passkeys) != 1 || list.Passkeys[0].Name != "Laptop" {
+					t.Fatalf("got %+v", list)
+				}
+			},
+		},
+		{
+			name: "none", as: "user", method: "GET", target: "/user/passkeys",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if body := strings.TrimSpace(rec.Body.String()); body != `{"passkeys":[]}` {
+					t.Fatalf("got %s", body)
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "GET", target: "/user/passkeys", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "GET", target: "/user/passkeys", setup: dbDown("webauthn_credentials"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestRemovePasskey(t *testing.T) {
+	// stored sets {passkey} to the ID of a passkey registered to s
+	stored := func(admin bool) func(*testing.T, *fixture) {
+		return func(t *testing.T, f *fixture) {
+			s := f.user
+			if admin {
+				s = f.admin
+			}
+			addPasskey(t, s)
+			rec := s.do(http.HandlerFunc(ListPasskeys), "GET", "/user/passkeys", "")
+			var list PasskeysResponse
+			json.Unmarshal(rec.Body.Bytes(), &list)
+			f.set("passkey", list.Passkeys[0].ID.Hex())
+		}
+	}
+	h := route("/user/passkeys/{id}", http.HandlerFunc(RemovePasskey))
+	runCases(t, h, []handlerCase{
+		{
+			name: "removes the passkey", as: "user", method: "DELETE", target: "/user/passkeys/{passkey}",
+			setup:  stored(false),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("webauthn_credentials", bson.M{}); n != 0 {
+					t.Fatal("passkey still stored")
+				}
+				if n := f.srv.Count("audit_logs", bson.M{"action": audit.ActionRemovePasskey}); n != 1 {
+					t.Fatal("removal not audited")
+				}
+			},
+		},
+		{name: "malformed ID", as: "user", method: "DELETE", target: "/user/passkeys/42", status: http.StatusBadRequest},
+		{name: "unknown passkey", as: "user", method: "DELETE", target: "/user/passkeys/{missing}", status: http.StatusNotFound},
+		{
+			name: "passkey of another user", as: "user", method: "DELETE", target: "/user/passkeys/{passkey}",
+			setup:  stored(true),
+			status: http.StatusNotFound,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				if n := f.srv.Count("webauthn_credentials", bson.M{}); n != 1 {
+					t.Fatal("passkey removed")
+				}
+			},
+		},
+		{name: "no session", as: "guest", method: "DELETE", target: "/user/passkeys/{missing}", status: http.StatusUnauthorized},
+		{name: "database down", as: "user", method: "DELETE", target: "/user/passkeys/{passkey}", setup: setups(stored(false), dbDown("webauthn_credentials")), status: http.StatusInternalServerError},
+	})
+}
+
+func TestBeginPasskeyLogin(t *testing.T) {
+	runCases(t, http.HandlerFunc(BeginPasskeyLogin), []handlerCase{
+		{
+			name: "returns the options", method: "POST", target: "/webauthn/login/begin",
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var begin PasskeyRequestResponse
+				json.Unmarshal(rec.Body.Bytes(), &begin)
+				if begin.Session == "" || begin.PublicKey.Challenge == "" || begin.PublicKey.RPID != "localhost" {
+					t.Fatalf("got %+v", begin)
+				}
+			},
+		},
+		{name: "database down", method: "POST", target: "/webauthn/login/begin", setup: dbDown("webauthn_ceremonies"), status: http.StatusInternalServerError},
+	})
+}
+
+func TestFinishPasskeyLogin(t *testing.T) {
+	stranger := newPasskey(t)
+	runCases(t, FinishPasskeyLogin(testConfig), []handlerCase{
+		{
+			name: "signs in", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup:  passkeyLogin(nil),
+			status: http.StatusOK,
+			check: func(t *testing.T, f *fixture, rec *httptest.ResponseRecorder) {
+				var session LoginResponse
+				json.Unmarshal(rec.Body.Bytes(), &session)
+				if session.Token == "" || session.RefreshToken == "" || session.Role != "user" {
+					t.Fatalf("got %+v", session)
+				}
+			},
+		},
+		{name: "malformed body", method: "POST", target: "/webauthn/login/finish", body: `{`, status: http.StatusBadRequest},
+		{name: "missing session", method: "POST", target: "/webauthn/login/finish", body: `{"credential":{}}`, status: http.StatusBadRequest},
+		{name: "unknown ceremony", method: "POST", target: "/webauthn/login/finish", body: `{"session":"unknown","credential":{}}`, status: http.StatusUnauthorized},
+		{
+			name: "signed by another key", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup: passkeyLogin(func(a *webauthntest.Authenticator, as *webauthntest.Assertion) []byte {
+				return as.Sign(stranger.Key)
+			}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup: setups(passkeyLogin(nil), func(t *testing.T, f *fixture) {
+				if rec := request(FinishPasskeyLogin(testConfig), "POST", "/webauthn/login/finish", "", f.vars["login"]); rec.Code != http.StatusOK {
+					t.Fatalf("first sign-in: got %d %s", rec.Code, rec.Body)
+				}
+			}),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "suspended", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup:  setups(passkeyLogin(nil), func(t *testing.T, f *fixture) { setUser(t, f, bson.M{"suspended": true}) }),
+			status: http.StatusForbidden,
+		},
+		{
+			name: "deleted", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup:  setups(passkeyLogin(nil), userDeleted),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "database down", method: "POST", target: "/webauthn/login/finish", body: "{login}",
+			setup:  setups(passkeyLogin(nil), dbDown("users")),
+			status: http.StatusInternalServerError,
+		},
+	})
+}
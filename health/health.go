@@ -0,0 +1,388 @@
+// Package health checks the components the API depends on and keeps every
+// result as a sample, so the public status page can report current health and
+// uptime over recent windows. Each check round also stores an "overall"
+// sample, which is up when every critical component was.
+package health
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Aggregated and component statuses
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusOutage      = "major_outage"
+	StatusDown        = "down"
+)
+
+// Overall names the samples recording whether every critical component was up
+const Overall = "overall"
+
+// SignatureHeader carries the HMAC-SHA256 of a signed status response
+const SignatureHeader = "X-Signature-SHA256"
+
+// collection holds health check samples
+const collection = "health_samples"
+
+// checkTimeout bounds a single component check
+const checkTimeout = 5 * time.Second
+
+// reportTTL is how long a computed report is served before recomputing
+const reportTTL = 30 * time.Second
+
+// Windows are the uptime windows reported, in order
+var Windows = []string{"24h", "7d", "30d"}
+
+var windowDurations = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// Check returns an error when a component is unhealthy
+type Check func(ctx context.Context) error
+
+// Sample is the result of one component check
+type Sample struct {
+	Component string    `bson:"component" json:"component"`
+	OK        bool      `bson:"ok" json:"ok"`
+	LatencyMs float64   `bson:"latency_ms" json:"latency_ms"`
+	Error     string    `bson:"error,omitempty" json:"-"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// ComponentStatus is the latest public health of a component. Uptime maps
+// each window to the percentage of checks that passed.
+type ComponentStatus struct {
+	Name      string             `json:"name" example:"database"`
+	Status    string             `json:"status" example:"operational"`
+	LatencyMs float64            `json:"latency_ms" example:"1.8"`
+	CheckedAt time.Time          `json:"checked_at"`
+	Uptime    map[string]float64 `json:"uptime"`
+}
+
+// Report is the aggregated health published at /status. Error details are
+// never included, since the report is public.
+type Report struct {
+	Status      string             `json:"status" example:"operational"`
+	Uptime      map[string]float64 `json:"uptime"`
+	Components  []ComponentStatus  `json:"components"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+type component struct {
+	name     string
+	critical bool
+	check    Check
+}
+
+var (
+	mu         sync.Mutex
+	components []component
+	latest     = make(map[string]Sample)
+	report     *Report
+	reportAt   time.Time
+
+	signingSecret []byte
+	limiter       *ipLimiter
+)
+
+// Register adds a component to every check round. A failing critical
+// component is an outage; any other failure degrades the status.
+func Register(name string, critical bool, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	components = append(components, component{name: name, critical: critical, check: check})
+}
+
+// Init registers the built-in checks, creates the TTL index for samples and
+// starts checking every HEALTH_CHECK_INTERVAL
+func Init(cfg *config.Config) {
+	signingSecret = []byte(cfg.StatusSigningSecret)
+	limiter = &ipLimiter{limit: cfg.StatusRateLimit, counts: make(map[string]int)}
+
+	Register("database", true, func(ctx context.Context) error {
+		return database.DB.Client().Ping(ctx, nil)
+	})
+	for region, db := range database.Regions {
+		if db == database.DB {
+			continue
+		}
+		db := db
+		Register("database:"+region, false, func(ctx context.Context) error {
+			return db.Client().Ping(ctx, nil)
+		})
+	}
+	if cache.Enabled() {
+		Register("cache", false, func(ctx context.Context) error {
+			return cache.Ping()
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(cfg.HealthSampleTTL.Seconds())),
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("health: failed to create TTL index: %v", err)
+	}
+	index = mongo.IndexModel{Keys: bson.D{{Key: "component", Value: 1}, {Key: "created_at", Value: 1}}}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("health: failed to create component index: %v", err)
+	}
+
+	go func() {
+		run(context.Background())
+		ticker := time.NewTicker(cfg.HealthCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(context.Background())
+		}
+	}()
+}
+
+// run checks every component once and stores the samples
+func run(ctx context.Context) {
+	mu.Lock()
+	checks := append([]component(nil), components...)
+	mu.Unlock()
+
+	samples := make([]interface{}, 0, len(checks)+1)
+	overall := Sample{Component: Overall, OK: true, CreatedAt: clock.Now()}
+	for _, c := range checks {
+		sample := probe(ctx, c)
+		if !sample.OK {
+			log.Printf("health: %s is down: %s", c.name, sample.Error)
+			if c.critical {
+				overall.OK = false
+			}
+		}
+		samples = append(samples, sample)
+	}
+	samples = append(samples, overall)
+
+	mu.Lock()
+	for _, s := range samples {
+		sample := s.(Sample)
+		latest[sample.Component] = sample
+	}
+	reportAt = time.Time{}
+	mu.Unlock()
+
+	if _, err := database.DB.Collection(collection).InsertMany(ctx, samples); err != nil {
+		log.Printf("health: failed to store samples: %v", err)
+	}
+}
+
+// probe runs one check with a timeout
+func probe(ctx context.Context, c component) Sample {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	sample := Sample{
+		Component: c.name,
+		OK:        err == nil,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CreatedAt: clock.Now(),
+	}
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	return sample
+}
+
+// Latest returns the most recent sample of a component
+func Latest(name string) (Sample, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	sample, ok := latest[name]
+	return sample, ok
+}
+
+// Current returns the aggregated report, recomputing uptime at most every
+// reportTTL so the public endpoints stay cheap
+func Current(ctx context.Context) (*Report, error) {
+	mu.Lock()
+	if report != nil && time.Since(reportAt) < reportTTL {
+		defer mu.Unlock()
+		return report, nil
+	}
+	checks := append([]component(nil), components...)
+	samples := make(map[string]Sample, len(latest))
+	for name, sample := range latest {
+		samples[name] = sample
+	}
+	mu.Unlock()
+
+	uptime, err := computeUptime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Status: StatusOperational, Uptime: uptime[Overall], Components: []ComponentStatus{}, GeneratedAt: clock.Now()}
+	if r.Uptime == nil {
+		r.Uptime = map[string]float64{}
+	}
+	for _, c := range checks {
+		sample, ok := samples[c.name]
+		if !ok {
+			continue
+		}
+		status := StatusOperational
+		if !sample.OK {
+			status = StatusDown
+			if c.critical {
+				r.Status = StatusOutage
+			} else if r.Status == StatusOperational {
+				r.Status = StatusDegraded
+			}
+		}
+		componentUptime := uptime[c.name]
+		if componentUptime == nil {
+			componentUptime = map[string]float64{}
+		}
+		r.Components = append(r.Components, ComponentStatus{
+			Name:      c.name,
+			Status:    status,
+			LatencyMs: sample.LatencyMs,
+			CheckedAt: sample.CreatedAt,
+			Uptime:    componentUptime,
+		})
+	}
+	sort.SliceStable(r.Components, func(i, j int) bool { return r.Components[i].Name < r.Components[j].Name })
+
+	mu.Lock()
+	report, reportAt = r, time.Now()
+	mu.Unlock()
+	return r, nil
+}
+
+// computeUptime returns, per component and window, the percentage of stored
+// samples that passed
+func computeUptime(ctx context.Context) (map[string]map[string]float64, error) {
+	now := clock.Now()
+	group := bson.M{"_id": "$component"}
+	for _, window := range Windows {
+		since := now.Add(-windowDurations[window])
+		inWindow := bson.M{"$gte": bson.A{"$created_at", since}}
+		group["total_"+window] = bson.M{"$sum": bson.M{"$cond": bson.A{inWindow, 1, 0}}}
+		group["ok_"+window] = bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$and": bson.A{inWindow, "$ok"}}, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": now.Add(-windowDurations[Windows[len(Windows)-1]])}}}},
+		{{Key: "$group", Value: group}},
+	}
+
+	cursor, err := database.DB.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []bson.M
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	uptime := make(map[string]map[string]float64, len(rows))
+	for _, row := range rows {
+		name, _ := row["_id"].(string)
+		windows := make(map[string]float64, len(Windows))
+		for _, window := range Windows {
+			total := toFloat(row["total_"+window])
+			if total == 0 {
+				continue
+			}
+			// Round down to two decimals so a single failure never shows as 100%
+			percent := toFloat(row["ok_"+window]) / total * 100
+			windows[window] = float64(int64(percent*100)) / 100
+		}
+		uptime[name] = windows
+	}
+	return uptime, nil
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// Sign sets the signature header of a public response body when
+// STATUS_SIGNING_SECRET is configured
+func Sign(w http.ResponseWriter, body []byte) {
+	if len(signingSecret) == 0 {
+		return
+	}
+	mac := hmac.New(sha256.New, signingSecret)
+	mac.Write(body)
+	w.Header().Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// ipLimiter counts requests per client IP in fixed one-minute windows
+type ipLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	minute int64
+	counts map[string]int
+}
+
+// allow counts a request, or returns the seconds until the window resets
+func (l *ipLimiter) allow(ip string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Unix()
+	if minute := now / 60; minute != l.minute {
+		l.minute = minute
+		l.counts = make(map[string]int)
+	}
+	l.counts[ip]++
+	if l.counts[ip] > l.limit {
+		return false, int(60 - now%60)
+	}
+	return true, 0
+}
+
+// RateLimit answers clients over STATUS_RATE_LIMIT requests per minute with
+// 429. It is meant for the unauthenticated status endpoints.
+func RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil && limiter.limit > 0 {
+			if ok, retryAfter := limiter.allow(audit.ClientIP(r)); !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,218 @@
+// Package invitations issues single-use codes that let someone register,
+// optionally with a role other than user. Codes are stored only as hashes in
+// invitations; registering consumes a code atomically, so each creates one
+// account. With INVITE_ONLY every registration needs one.
+package invitations
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// collection holds issued invitations, used or not
+const collection = "invitations"
+
+// Invitation statuses, derived from the stored times
+const (
+	StatusPending = "pending"
+	StatusUsed    = "used"
+	StatusExpired = "expired"
+	StatusRevoked = "revoked"
+)
+
+var (
+	// ErrInvalid is returned for unknown, used, revoked and expired codes
+	ErrInvalid = errors.New("invalid or expired invitation")
+	// ErrNotFound is returned for invitation IDs that do not exist
+	ErrNotFound = errors.New("invitation not found")
+	// ErrNotPending is returned when revoking a used or revoked invitation
+	ErrNotPending = errors.New("invitation was already used or revoked")
+)
+
+// Invitation is an issued invitation
+type Invitation struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	CodeHash string             `bson:"code_hash" json:"-"`
+	// Role is given to the account registered with the invitation
+	Role      string              `bson:"role" json:"role" example:"user"`
+	Note      string              `bson:"note,omitempty" json:"note,omitempty" example:"Beta cohort 3"`
+	CreatedBy string              `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time           `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time          `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	UsedBy    *primitive.ObjectID `bson:"used_by,omitempty" json:"used_by,omitempty"`
+	RevokedAt *time.Time          `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	Status    string              `bson:"-" json:"status" example:"pending"`
+}
+
+// Init creates the invitation indexes
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"code_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"created_at": -1}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("invitations: failed to create indexes: %v", err)
+	}
+}
+
+// Create issues an invitation for role, valid for ttl, and returns its code,
+// which is not stored and cannot be shown again
+func Create(ctx context.Context, role, note, createdBy string, ttl time.Duration) (string, *Invitation, error) {
+	code, err := utils.RandomToken(24)
+	if err != nil {
+		return "", nil, err
+	}
+	now := clock.Now()
+	inv := &Invitation{
+		ID:        clock.NewID(),
+		CodeHash:  utils.HashToken(code),
+		Role:      role,
+		Note:      note,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, inv); err != nil {
+		return "", nil, err
+	}
+	inv.Status = StatusPending
+	return code, inv, nil
+}
+
+// Find returns the pending invitation of a code, without using it
+func Find(ctx context.Context, code string) (*Invitation, error) {
+	var inv Invitation
+	err := database.DB.Collection(collection).FindOne(ctx, pendingCode(code)).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	inv.Status = StatusPending
+	return &inv, nil
+}
+
+// Redeem uses the invitation of a code for the account userID. Only one
+// request can redeem a code.
+func Redeem(ctx context.Context, code string, userID primitive.ObjectID) (*Invitation, error) {
+	now := clock.Now()
+	update := bson.M{"$set": bson.M{"used_at": now, "used_by": userID}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var inv Invitation
+	err := database.DB.Collection(collection).FindOneAndUpdate(ctx, pendingCode(code), update, opts).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	inv.Status = StatusUsed
+	return &inv, nil
+}
+
+// Release makes an invitation redeemed for userID pending again, when
+// creating the account failed after all
+func Release(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := database.DB.Collection(collection).UpdateOne(ctx,
+		bson.M{"_id": id, "used_by": userID},
+		bson.M{"$unset": bson.M{"used_at": "", "used_by": ""}})
+	return err
+}
+
+// Revoke voids a pending invitation
+func Revoke(ctx context.Context, id primitive.ObjectID) (*Invitation, error) {
+	invitations := database.DB.Collection(collection)
+	filter := bson.M{"_id": id, "used_at": bson.M{"$exists": false}, "revoked_at": bson.M{"$exists": false}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var inv Invitation
+	err := invitations.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"revoked_at": clock.Now()}}, opts).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if n, err := invitations.CountDocuments(ctx, bson.M{"_id": id}); err != nil {
+			return nil, err
+		} else if n == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, ErrNotPending
+	} else if err != nil {
+		return nil, err
+	}
+	inv.Status = StatusRevoked
+	return &inv, nil
+}
+
+// List returns up to limit invitations, newest first, optionally only those
+// with a status
+func List(ctx context.Context, status string, limit int64) ([]Invitation, error) {
+	now := clock.Now()
+	open := bson.M{"used_at": bson.M{"$exists": false}, "revoked_at": bson.M{"$exists": false}}
+	filter := bson.M{}
+	switch status {
+	case StatusPending:
+		filter = open
+		filter["expires_at"] = bson.M{"$gt": now}
+	case StatusExpired:
+		filter = open
+		filter["expires_at"] = bson.M{"$lte": now}
+	case StatusUsed:
+		filter["used_at"] = bson.M{"$exists": true}
+	case StatusRevoked:
+		filter["revoked_at"] = bson.M{"$exists": true}
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	invitations := []Invitation{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		return nil, err
+	}
+	for i := range invitations {
+		invitations[i].Status = statusOf(&invitations[i], now)
+	}
+	return invitations, nil
+}
+
+// ValidStatus reports whether List can filter by status
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusUsed, StatusExpired, StatusRevoked:
+		return true
+	}
+	return false
+}
+
+// pendingCode matches the invitation of a code while it can be used
+func pendingCode(code string) bson.M {
+	return bson.M{
+		"code_hash":  utils.HashToken(code),
+		"used_at":    bson.M{"$exists": false},
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": clock.Now()},
+	}
+}
+
+func statusOf(inv *Invitation, now time.Time) string {
+	switch {
+	case inv.UsedAt != nil:
+		return StatusUsed
+	case inv.RevokedAt != nil:
+		return StatusRevoked
+	case !inv.ExpiresAt.After(now):
+		return StatusExpired
+	}
+	return StatusPending
+}
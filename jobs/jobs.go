@@ -0,0 +1,292 @@
+// Package jobs runs background work queued by request handlers, such as
+// emails that should not hold up the response. Jobs are stored in the
+// database, so they survive restarts and any instance may run them; a worker
+// claims a job for a lease and another instance picks it up again once the
+// lease lapses. Failed jobs are retried with backoff up to JobMaxAttempts.
+//
+// Job kinds are registered with Register before Start, usually by the
+// package that enqueues them. Kinds registered with RegisterEvery also run on
+// their own, one run at a time across instances, each run scheduled when the
+// previous one finishes. Handlers may write to the log of their run with
+// Logf; admins read it, with each kind's last and next run, through
+// /admin/jobs.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// collection holds queued, running and finished jobs
+const collection = "jobs"
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// lease is how long a worker holds a job before others may take it over
+const lease = 5 * time.Minute
+
+// Job is a unit of queued work
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Kind        string             `bson:"kind" json:"kind" example:"email.verification"`
+	Payload     bson.Raw           `bson:"payload" json:"-"`
+	Status      string             `bson:"status" json:"status" example:"queued"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	RunAt       time.Time          `bson:"run_at" json:"run_at"`
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	// StartedAt and DurationMS describe the latest attempt
+	StartedAt  *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	DurationMS int64      `bson:"duration_ms,omitempty" json:"duration_ms,omitempty" example:"412"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	// TriggeredBy is the admin who ran the job on demand
+	TriggeredBy string    `bson:"triggered_by,omitempty" json:"triggered_by,omitempty"`
+	Log         []LogLine `bson:"log,omitempty" json:"-"`
+	// Recurring holds the kind of a pending scheduled run of a recurring
+	// kind; a unique index keeps one per kind
+	Recurring string `bson:"recurring,omitempty" json:"-"`
+}
+
+// LogLine is a line of a job's log
+type LogLine struct {
+	At      time.Time `bson:"at" json:"at"`
+	Message string    `bson:"message" json:"message" example:"attempt 1 started"`
+}
+
+// Handler runs one job of a kind. Returning an error retries the job.
+type Handler func(ctx context.Context, payload bson.Raw) error
+
+// ErrUnknownKind is returned when enqueueing a kind nobody registered
+var ErrUnknownKind = errors.New("unknown job kind")
+
+var (
+	mu          sync.RWMutex
+	handlers    = make(map[string]Handler)
+	intervals   = make(map[string]time.Duration)
+	maxAttempts = 5
+)
+
+// Register sets the handler of a job kind
+func Register(kind string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[kind] = h
+}
+
+// RegisterEvery sets the handler of a job kind that runs every interval,
+// counted from the end of the previous run. Start schedules the first run.
+func RegisterEvery(kind string, interval time.Duration, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[kind] = h
+	intervals[kind] = interval
+}
+
+func intervalOf(kind string) (time.Duration, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	interval, ok := intervals[kind]
+	return interval, ok
+}
+
+func handlerOf(kind string) (Handler, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	h, ok := handlers[kind]
+	return h, ok
+}
+
+// Enqueue queues a job of a registered kind to run as soon as a worker is
+// free. The payload is stored as a BSON document.
+func Enqueue(ctx context.Context, kind string, payload interface{}) (primitive.ObjectID, error) {
+	return Schedule(ctx, kind, payload, clock.Now())
+}
+
+// Schedule queues a job of a registered kind to run once runAt has passed
+func Schedule(ctx context.Context, kind string, payload interface{}, runAt time.Time) (primitive.ObjectID, error) {
+	return schedule(ctx, kind, payload, runAt, "")
+}
+
+func schedule(ctx context.Context, kind string, payload interface{}, runAt time.Time, triggeredBy string) (primitive.ObjectID, error) {
+	if _, ok := handlerOf(kind); !ok {
+		return primitive.NilObjectID, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	now := clock.Now()
+	job := Job{
+		ID:          clock.NewID(),
+		Kind:        kind,
+		Payload:     raw,
+		Status:      StatusQueued,
+		RunAt:       runAt,
+		CreatedAt:   now,
+		TriggeredBy: triggeredBy,
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, job); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return job.ID, nil
+}
+
+// scheduleRecurring queues the next run of a recurring kind, unless one is
+// already pending
+func scheduleRecurring(ctx context.Context, kind string, runAt time.Time) {
+	payload, _ := bson.Marshal(bson.D{})
+	job := Job{
+		ID:        clock.NewID(),
+		Kind:      kind,
+		Payload:   payload,
+		Status:    StatusQueued,
+		RunAt:     runAt,
+		CreatedAt: clock.Now(),
+		Recurring: kind,
+	}
+	_, err := database.DB.Collection(collection).InsertOne(ctx, job)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("jobs: failed to schedule next %s run: %v", kind, err)
+	}
+}
+
+// Start creates the job indexes and runs JobWorkers workers polling for due
+// jobs every JobPollInterval. Finished jobs are kept for JobRetention.
+func Start(cfg *config.Config) {
+	if cfg.JobMaxAttempts > 0 {
+		maxAttempts = cfg.JobMaxAttempts
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.M{"finished_at": 1}, Options: options.Index().SetExpireAfterSeconds(int32(cfg.JobRetention.Seconds()))},
+		{Keys: bson.M{"recurring": 1}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"recurring": bson.M{"$exists": true}})},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("jobs: failed to create indexes: %v", err)
+	}
+
+	// Every instance offers the first runs; the index keeps one of each
+	for _, kind := range Kinds() {
+		if _, ok := intervalOf(kind); ok {
+			scheduleRecurring(ctx, kind, clock.Now())
+		}
+	}
+
+	for i := 0; i < cfg.JobWorkers; i++ {
+		go work(cfg.JobPollInterval)
+	}
+	log.Printf("Job workers started (%d)", cfg.JobWorkers)
+}
+
+// work runs due jobs one at a time, sleeping while there are none
+func work(interval time.Duration) {
+	for {
+		ran, err := runNext(context.Background())
+		if err != nil {
+			log.Printf("jobs: failed to claim a job: %v", err)
+		}
+		if !ran {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// runNext claims and runs the next due job. It reports whether there was one.
+func runNext(ctx context.Context) (bool, error) {
+	now := clock.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": StatusQueued, "run_at": bson.M{"$lte": now}},
+		// Jobs of a worker that died are taken over once its lease lapses
+		bson.M{"status": StatusRunning, "locked_until": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set":  bson.M{"status": StatusRunning, "locked_until": now.Add(lease), "started_at": now},
+		"$inc":  bson.M{"attempts": 1},
+		"$push": logPush(LogLine{At: now, Message: "attempt started"}),
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"run_at": 1}).SetReturnDocument(options.After)
+	var job Job
+	err := database.DB.Collection(collection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	runErr := run(ctx, &job)
+	finish(ctx, &job, runErr)
+	return true, nil
+}
+
+// run calls the handler of a job, turning panics into errors
+func run(ctx context.Context, job *Job) (err error) {
+	h, ok := handlerOf(job.Kind)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKind, job.Kind)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, lease)
+	defer cancel()
+	return h(context.WithValue(ctx, runKey{}, job.ID), job.Payload)
+}
+
+// finish records the outcome of a run. Failed jobs are queued again after
+// a backoff doubling from 30 seconds, until they run out of attempts.
+func finish(ctx context.Context, job *Job, runErr error) {
+	now := clock.Now()
+	duration := now.Sub(*job.StartedAt)
+	set := bson.M{"status": StatusDone, "finished_at": now, "duration_ms": duration.Milliseconds()}
+	line := fmt.Sprintf("attempt %d done in %s", job.Attempts, duration.Round(time.Millisecond))
+	if runErr != nil {
+		log.Printf("jobs: %s job %s failed (attempt %d): %v", job.Kind, job.ID.Hex(), job.Attempts, runErr)
+		set = bson.M{"status": StatusFailed, "error": runErr.Error(), "finished_at": now, "duration_ms": duration.Milliseconds()}
+		line = fmt.Sprintf("attempt %d failed after %s: %v", job.Attempts, duration.Round(time.Millisecond), runErr)
+		if job.Attempts < maxAttempts {
+			backoff := 30 * time.Second << (job.Attempts - 1)
+			set = bson.M{"status": StatusQueued, "error": runErr.Error(), "run_at": now.Add(backoff), "duration_ms": duration.Milliseconds()}
+			line += fmt.Sprintf(", retrying in %s", backoff)
+		}
+	}
+	unset := bson.M{"locked_until": ""}
+	final := set["status"] != StatusQueued
+	if final {
+		unset["recurring"] = ""
+	}
+	update := bson.M{"$set": set, "$unset": unset, "$push": logPush(LogLine{At: now, Message: line})}
+	if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": job.ID}, update); err != nil {
+		log.Printf("jobs: failed to record outcome of job %s: %v", job.ID.Hex(), err)
+		return
+	}
+
+	// The scheduled run of a recurring kind queues the next one; runs
+	// triggered on demand do not
+	if interval, ok := intervalOf(job.Kind); ok && final && job.Recurring != "" {
+		scheduleRecurring(ctx, job.Kind, now.Add(interval))
+	}
+}
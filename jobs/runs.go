@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+)
+
+// maxLogLines is how many lines of its log a job keeps; older lines are
+// dropped
+const maxLogLines = 200
+
+// ErrNotFound is returned for a run that does not exist
+var ErrNotFound = errors.New("job run not found")
+
+// KindSummary is the state of a registered job kind
+type KindSummary struct {
+	Kind    string `json:"kind" example:"email.verification"`
+	Queued  int64  `json:"queued" example:"3"`
+	Running int64  `json:"running" example:"1"`
+	Failed  int64  `json:"failed" example:"0"`
+	// Every is the interval of recurring kinds
+	Every string `json:"every,omitempty" example:"1h0m0s"`
+	// LastRun is the job of the kind that started last
+	LastRun *Job `json:"last_run,omitempty"`
+	// NextRunAt is when the earliest queued job of the kind is due
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+}
+
+// runKey is the context key of the job a handler is running
+type runKey struct{}
+
+// logPush is the $push appending a line to a job's log
+func logPush(line LogLine) bson.M {
+	return bson.M{"log": bson.M{"$each": bson.A{line}, "$slice": -maxLogLines}}
+}
+
+// Logf appends a line to the log of the job running with ctx. It does
+// nothing outside a job handler.
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	id, ok := ctx.Value(runKey{}).(primitive.ObjectID)
+	if !ok {
+		return
+	}
+	line := LogLine{At: clock.Now(), Message: fmt.Sprintf(format, args...)}
+	if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$push": logPush(line)}); err != nil {
+		log.Printf("jobs: failed to log to job %s: %v", id.Hex(), err)
+	}
+}
+
+// Kinds returns the registered job kinds, sorted
+func Kinds() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	kinds := make([]string, 0, len(handlers))
+	for kind := range handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Summarize returns the state of every registered job kind
+func Summarize(ctx context.Context) ([]KindSummary, error) {
+	jobs := database.DB.Collection(collection)
+	withoutLog := bson.M{"payload": 0, "log": 0}
+	summaries := make([]KindSummary, 0)
+	for _, kind := range Kinds() {
+		s := KindSummary{Kind: kind}
+		if interval, ok := intervalOf(kind); ok {
+			s.Every = interval.String()
+		}
+		for status, count := range map[string]*int64{StatusQueued: &s.Queued, StatusRunning: &s.Running, StatusFailed: &s.Failed} {
+			n, err := jobs.CountDocuments(ctx, bson.M{"kind": kind, "status": status})
+			if err != nil {
+				return nil, err
+			}
+			*count = n
+		}
+
+		var last Job
+		opts := options.FindOne().SetSort(bson.M{"started_at": -1}).SetProjection(withoutLog)
+		err := jobs.FindOne(ctx, bson.M{"kind": kind, "started_at": bson.M{"$exists": true}}, opts).Decode(&last)
+		if err == nil {
+			s.LastRun = &last
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		var next Job
+		opts = options.FindOne().SetSort(bson.M{"run_at": 1}).SetProjection(withoutLog)
+		err = jobs.FindOne(ctx, bson.M{"kind": kind, "status": StatusQueued}, opts).Decode(&next)
+		if err == nil {
+			s.NextRunAt = &next.RunAt
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
+
+// Trigger queues a job of a registered kind to run now on behalf of an
+// admin
+func Trigger(ctx context.Context, kind string, payload interface{}, actorID string) (primitive.ObjectID, error) {
+	return schedule(ctx, kind, payload, clock.Now(), actorID)
+}
+
+// Runs returns the most recent jobs of a kind, newest first, without their
+// payloads and logs
+func Runs(ctx context.Context, kind string, limit int64) ([]Job, error) {
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit).SetProjection(bson.M{"payload": 0, "log": 0})
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"kind": kind}, opts)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]Job, 0)
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// Run returns a job with the last tail lines of its log
+func Run(ctx context.Context, id primitive.ObjectID, tail int) (*Job, error) {
+	opts := options.FindOne().SetProjection(bson.M{"payload": 0, "log": bson.M{"$slice": -tail}})
+	var job Job
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id}, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	return &job, err
+}
@@ -0,0 +1,238 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// orgPrefix marks ciphertexts produced with an organization data key:
+// org:<org id>:<key version>:<ciphertext>
+const orgPrefix = "org:"
+
+var (
+	// ErrKeyDestroyed is returned when data was encrypted with a destroyed (shredded) key
+	ErrKeyDestroyed = errors.New("organization data key has been destroyed")
+	// ErrNoActiveKey is returned when an organization has no usable data key
+	ErrNoActiveKey = errors.New("organization has no active data key")
+)
+
+// cache holds unwrapped data keys by org id and version
+var (
+	cacheMu sync.RWMutex
+	cache   = make(map[string][]byte)
+)
+
+func cacheKey(orgID string, version int) string {
+	return orgID + ":" + strconv.Itoa(version)
+}
+
+// Encrypt encrypts a field value. Values of users in an organization use the
+// organization's active data key; everything else uses the master key.
+func Encrypt(ctx context.Context, cfg *config.Config, orgID, plaintext string) (string, error) {
+	if orgID == "" {
+		return utils.Encrypt(plaintext, cfg.EncryptionKey)
+	}
+
+	key, version, err := activeKey(ctx, cfg, orgID)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := utils.Encrypt(plaintext, string(key))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%d:%s", orgPrefix, orgID, version, ciphertext), nil
+}
+
+// Decrypt decrypts a field value produced by Encrypt, picking the key from its prefix
+// Empty values (such as erased fields) decrypt to an empty string.
+func Decrypt(ctx context.Context, cfg *config.Config, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(value, orgPrefix) {
+		return utils.Decrypt(value, cfg.EncryptionKey)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, orgPrefix), ":", 3)
+	if len(parts) != 3 {
+		return "", errors.New("malformed organization ciphertext")
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", errors.New("malformed organization key version")
+	}
+
+	key, err := keyVersion(ctx, cfg, parts[0], version)
+	if err != nil {
+		return "", err
+	}
+	return utils.Decrypt(parts[2], string(key))
+}
+
+// Rotate creates a new active data key for the organization and retires the previous one.
+// Existing data stays readable with the retired key.
+func Rotate(ctx context.Context, cfg *config.Config, orgID primitive.ObjectID) (*models.OrgKey, error) {
+	collection := database.DB.Collection("org_keys")
+
+	var latest models.OrgKey
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	err := collection.FindOne(ctx, bson.M{"org_id": orgID}, opts).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == nil && latest.Status == models.KeyDestroyed {
+		return nil, ErrKeyDestroyed
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := utils.Encrypt(string(dek), cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	key := models.OrgKey{
+		ID:         clock.NewID(),
+		OrgID:      orgID,
+		Version:    latest.Version + 1,
+		WrappedKey: wrapped,
+		Status:     models.KeyActive,
+		CreatedAt:  now,
+	}
+
+	if _, err := collection.UpdateMany(ctx,
+		bson.M{"org_id": orgID, "status": models.KeyActive},
+		bson.M{"$set": bson.M{"status": models.KeyRetired, "retired_at": now}}); err != nil {
+		return nil, err
+	}
+	if _, err := collection.InsertOne(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// List returns all data key versions of an organization (without key material)
+func List(ctx context.Context, orgID primitive.ObjectID) ([]models.OrgKey, error) {
+	cursor, err := database.DB.Collection("org_keys").Find(ctx, bson.M{"org_id": orgID}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.OrgKey{}
+	err = cursor.All(ctx, &keys)
+	return keys, err
+}
+
+// Destroy removes the key material of every data key of the organization,
+// making all data encrypted with them permanently unreadable.
+func Destroy(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	now := clock.Now()
+	result, err := database.DB.Collection("org_keys").UpdateMany(ctx,
+		bson.M{"org_id": orgID, "status": bson.M{"$ne": models.KeyDestroyed}},
+		bson.M{
+			"$set":   bson.M{"status": models.KeyDestroyed, "destroyed_at": now},
+			"$unset": bson.M{"wrapped_key": ""},
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	cacheMu.Lock()
+	for k := range cache {
+		if strings.HasPrefix(k, orgID.Hex()+":") {
+			delete(cache, k)
+		}
+	}
+	cacheMu.Unlock()
+
+	return result.ModifiedCount, nil
+}
+
+// activeKey returns the organization's active data key, creating the first one on demand
+func activeKey(ctx context.Context, cfg *config.Config, orgID string) ([]byte, int, error) {
+	oid, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var key models.OrgKey
+	err = database.DB.Collection("org_keys").FindOne(ctx, bson.M{"org_id": oid, "status": models.KeyActive}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		created, err := Rotate(ctx, cfg, oid)
+		if err != nil {
+			return nil, 0, err
+		}
+		key = *created
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	dek, err := unwrap(cfg, orgID, &key)
+	return dek, key.Version, err
+}
+
+// keyVersion returns a specific data key version, using the in-memory cache
+func keyVersion(ctx context.Context, cfg *config.Config, orgID string, version int) ([]byte, error) {
+	cacheMu.RLock()
+	dek, ok := cache[cacheKey(orgID, version)]
+	cacheMu.RUnlock()
+	if ok {
+		return dek, nil
+	}
+
+	oid, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var key models.OrgKey
+	if err := database.DB.Collection("org_keys").FindOne(ctx, bson.M{"org_id": oid, "version": version}).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoActiveKey
+		}
+		return nil, err
+	}
+
+	return unwrap(cfg, orgID, &key)
+}
+
+// unwrap decrypts a data key with the master key and caches it
+func unwrap(cfg *config.Config, orgID string, key *models.OrgKey) ([]byte, error) {
+	if key.Status == models.KeyDestroyed || key.WrappedKey == "" {
+		return nil, ErrKeyDestroyed
+	}
+
+	raw, err := utils.Decrypt(key.WrappedKey, cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	dek := []byte(raw)
+
+	cacheMu.Lock()
+	cache[cacheKey(orgID, key.Version)] = dek
+	cacheMu.Unlock()
+
+	return dek, nil
+}
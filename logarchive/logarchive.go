@@ -0,0 +1,479 @@
+// Package logarchive backs up the audit log and security events to object
+// storage. A recurring job writes every complete UTC day of each log to
+// LOG_ARCHIVE_BUCKET as gzipped NDJSON sealed with AES-GCM, and records a
+// manifest with checksums of the records and of the stored object in
+// log_archives. The same job moves archives through the retention tiers:
+// records older than LOG_ARCHIVE_HOT_RETENTION are deleted from the database
+// once archived, archives older than LOG_ARCHIVE_WARM_RETENTION move to the
+// cold prefix and storage class, and those older than LOG_ARCHIVE_RETENTION
+// are deleted. Archives can be verified and a time range restored into
+// restored_* collections for investigations.
+package logarchive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/jobs"
+	"golang-backend/storage"
+)
+
+// collection holds the manifest of every archive
+const collection = "log_archives"
+
+// JobArchive archives, compacts and tiers the logs
+const JobArchive = "logs.archive"
+
+// Logs are the collections archived
+var Logs = []string{"audit_logs", "security_events"}
+
+// Archive tiers
+const (
+	TierWarm    = "warm"
+	TierCold    = "cold"
+	TierExpired = "expired"
+)
+
+// day is the span of one archive
+const day = 24 * time.Hour
+
+// maxDaysPerRun bounds how many days of a log one run archives, so a first
+// run over a long history finishes within the job lease
+const maxDaysPerRun = 31
+
+// Archive is the manifest of one UTC day of a log. Days without records have
+// a manifest but no object.
+type Archive struct {
+	ID      string    `bson:"_id" json:"id" example:"audit_logs:2026-10-15"`
+	Log     string    `bson:"log" json:"log" example:"audit_logs"`
+	Day     time.Time `bson:"day" json:"day"`
+	Records int       `bson:"records" json:"records" example:"1840"`
+	Tier    string    `bson:"tier" json:"tier" example:"warm"`
+	Key     string    `bson:"key,omitempty" json:"key,omitempty" example:"log-archives/warm/audit_logs/2026/10/15.ndjson.gz.enc"`
+	Size    int64     `bson:"size,omitempty" json:"size,omitempty" example:"52311"`
+	// ContentSHA256 is the checksum of the NDJSON records, ObjectSHA256 of
+	// the encrypted object as stored
+	ContentSHA256 string `bson:"content_sha256" json:"content_sha256"`
+	ObjectSHA256  string `bson:"object_sha256,omitempty" json:"object_sha256,omitempty"`
+	// KeyID fingerprints the key the archive was sealed with
+	KeyID       string     `bson:"key_id" json:"key_id" example:"3f9a1c2e"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	CompactedAt *time.Time `bson:"compacted_at,omitempty" json:"compacted_at,omitempty"`
+	Compacted   int64      `bson:"compacted,omitempty" json:"compacted,omitempty"`
+	TieredAt    *time.Time `bson:"tiered_at,omitempty" json:"tiered_at,omitempty"`
+	VerifiedAt  *time.Time `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	Verified    *bool      `bson:"verified,omitempty" json:"verified,omitempty"`
+}
+
+var (
+	bucket         *storage.Bucket
+	prefix         string
+	sealKey        []byte
+	keyID          string
+	hotRetention   time.Duration
+	warmRetention  time.Duration
+	retention      time.Duration
+	coldClass      string
+	maxRestoreDays int
+)
+
+// Init configures the archive bucket and registers the archive job. Without
+// LOG_ARCHIVE_BUCKET logs are not archived.
+func Init(cfg *config.Config) {
+	if cfg.LogArchiveBucket == "" {
+		return
+	}
+	key := cfg.LogArchiveKey
+	if key == "" {
+		key = cfg.EncryptionKey
+	}
+	if _, err := aes.NewCipher([]byte(key)); err != nil {
+		log.Printf("logarchive: invalid LOG_ARCHIVE_KEY, logs are not archived: %v", err)
+		return
+	}
+	sealKey = []byte(key)
+	fingerprint := sha256.Sum256(sealKey)
+	keyID = hex.EncodeToString(fingerprint[:4])
+
+	creds := storage.Credentials{
+		AccessKey:    cfg.AWSAccessKeyID,
+		SecretKey:    cfg.AWSSecretAccessKey,
+		SessionToken: cfg.AWSSessionToken,
+		Region:       cfg.LogArchiveRegion,
+	}
+	bucket = storage.NewBucket(cfg.LogArchiveBucket, cfg.LogArchiveEndpoint, creds)
+	prefix = cfg.LogArchivePrefix
+	hotRetention = cfg.LogArchiveHotRetention
+	warmRetention = cfg.LogArchiveWarmRetention
+	retention = cfg.LogArchiveRetention
+	coldClass = cfg.LogArchiveColdStorageClass
+	maxRestoreDays = cfg.LogArchiveMaxRestoreDays
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.D{{Key: "log", Value: 1}, {Key: "day", Value: -1}}}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("logarchive: failed to create indexes: %v", err)
+	}
+	for _, name := range Logs {
+		index := mongo.IndexModel{Keys: bson.M{"restored_at": 1}, Options: options.Index().SetExpireAfterSeconds(int32(cfg.LogArchiveRestoreTTL.Seconds()))}
+		if _, err := database.DB.Collection(restoredCollection(name)).Indexes().CreateOne(ctx, index); err != nil {
+			log.Printf("logarchive: failed to create %s TTL index: %v", restoredCollection(name), err)
+		}
+	}
+
+	jobs.RegisterEvery(JobArchive, cfg.LogArchiveInterval, run)
+}
+
+// Enabled reports whether log archiving is configured
+func Enabled() bool {
+	return bucket != nil
+}
+
+// run archives the days each log completed since the last run, then
+// compacts and tiers its archives
+func run(ctx context.Context, _ bson.Raw) error {
+	for _, name := range Logs {
+		archived, err := archiveLog(ctx, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		compacted, err := compactLog(ctx, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		moved, expired, err := tierLog(ctx, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		jobs.Logf(ctx, "%s: archived %d days, compacted %d records, moved %d archives to cold, expired %d", name, archived, compacted, moved, expired)
+	}
+	return nil
+}
+
+// archiveLog archives the complete days of a log after the last archived one
+func archiveLog(ctx context.Context, name string) (int, error) {
+	next, err := nextDay(ctx, name)
+	if err != nil || next.IsZero() {
+		return 0, err
+	}
+	today := clock.Now().UTC().Truncate(day)
+	archived := 0
+	for d := next; d.Before(today) && archived < maxDaysPerRun; d = d.Add(day) {
+		if err := archiveDay(ctx, name, d); err != nil {
+			return archived, fmt.Errorf("%s: %w", d.Format("2006-01-02"), err)
+		}
+		archived++
+	}
+	return archived, nil
+}
+
+// nextDay returns the first day of a log not archived yet: the day after
+// the last archive, or the day of the oldest record. It is zero for an empty
+// log without archives.
+func nextDay(ctx context.Context, name string) (time.Time, error) {
+	var last Archive
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"log": name}, options.FindOne().SetSort(bson.M{"day": -1})).Decode(&last)
+	if err == nil {
+		return last.Day.Add(day), nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, err
+	}
+
+	var oldest struct {
+		CreatedAt time.Time `bson:"created_at"`
+	}
+	opts := options.FindOne().SetSort(bson.M{"created_at": 1}).SetProjection(bson.M{"created_at": 1})
+	err = database.DB.Collection(name).FindOne(ctx, bson.M{}, opts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return oldest.CreatedAt.UTC().Truncate(day), nil
+}
+
+// archiveDay writes the records of one day of a log to the bucket and
+// records its manifest
+func archiveDay(ctx context.Context, name string, d time.Time) error {
+	filter := bson.M{"created_at": bson.M{"$gte": d, "$lt": d.Add(day)}}
+	cursor, err := database.DB.Collection(name).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var content bytes.Buffer
+	records := 0
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return err
+		}
+		content.Write(line)
+		content.WriteByte('\n')
+		records++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	archive := Archive{
+		ID:            archiveID(name, d),
+		Log:           name,
+		Day:           d,
+		Records:       records,
+		Tier:          TierWarm,
+		ContentSHA256: checksum(content.Bytes()),
+		KeyID:         keyID,
+		CreatedAt:     clock.Now(),
+	}
+	if records > 0 {
+		object, err := seal(content.Bytes())
+		if err != nil {
+			return err
+		}
+		archive.Key = objectKey(TierWarm, name, d)
+		archive.Size = int64(len(object))
+		archive.ObjectSHA256 = checksum(object)
+		header := http.Header{"Content-Type": {"application/octet-stream"}, "X-Amz-Meta-Sha256": {archive.ObjectSHA256}}
+		if err := bucket.Put(ctx, archive.Key, object, header); err != nil {
+			return err
+		}
+	}
+	// A run triggered alongside the scheduled one may have archived the
+	// day already, with the same content
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, archive); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// compactLog deletes the records of archived days older than the hot
+// retention from the database
+func compactLog(ctx context.Context, name string) (int64, error) {
+	if hotRetention <= 0 {
+		return 0, nil
+	}
+	cutoff := clock.Now().Add(-hotRetention).Add(-day)
+	filter := bson.M{"log": name, "compacted_at": bson.M{"$exists": false}, "day": bson.M{"$lte": cutoff}}
+	archives, err := find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, archive := range archives {
+		var deleted int64
+		if name == "audit_logs" {
+			// The audit chain is cut rather than holed, and anchored where
+			// it now starts
+			deleted, err = audit.Truncate(ctx, archive.Day, archive.Day.Add(day))
+		} else {
+			var result *mongo.DeleteResult
+			result, err = database.DB.Collection(name).DeleteMany(ctx, bson.M{"created_at": bson.M{"$gte": archive.Day, "$lt": archive.Day.Add(day)}})
+			if result != nil {
+				deleted = result.DeletedCount
+			}
+		}
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		update := bson.M{"$set": bson.M{"compacted_at": clock.Now(), "compacted": deleted}}
+		if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": archive.ID}, update); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// tierLog moves archives older than the warm retention to the cold tier and
+// deletes those older than the retention. It returns how many it moved and
+// deleted.
+func tierLog(ctx context.Context, name string) (int, int, error) {
+	now := clock.Now()
+	moved, expired := 0, 0
+	if retention > 0 {
+		filter := bson.M{"log": name, "tier": bson.M{"$ne": TierExpired}, "day": bson.M{"$lt": now.Add(-retention)}}
+		archives, err := find(ctx, filter)
+		if err != nil {
+			return moved, expired, err
+		}
+		for _, archive := range archives {
+			if archive.Key != "" {
+				if err := bucket.Delete(ctx, archive.Key); err != nil {
+					return moved, expired, err
+				}
+			}
+			update := bson.M{"$set": bson.M{"tier": TierExpired, "tiered_at": now}, "$unset": bson.M{"key": ""}}
+			if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": archive.ID}, update); err != nil {
+				return moved, expired, err
+			}
+			expired++
+		}
+	}
+
+	if warmRetention > 0 {
+		filter := bson.M{"log": name, "tier": TierWarm, "day": bson.M{"$lt": now.Add(-warmRetention)}}
+		archives, err := find(ctx, filter)
+		if err != nil {
+			return moved, expired, err
+		}
+		for _, archive := range archives {
+			if err := moveCold(ctx, archive); err != nil {
+				return moved, expired, fmt.Errorf("%s: %w", archive.ID, err)
+			}
+			moved++
+		}
+	}
+	return moved, expired, nil
+}
+
+// moveCold copies a warm archive to the cold prefix and storage class, after
+// checking it, and deletes the warm copy
+func moveCold(ctx context.Context, archive Archive) error {
+	set := bson.M{"tier": TierCold, "tiered_at": clock.Now()}
+	if archive.Key != "" {
+		object, err := bucket.Get(ctx, archive.Key)
+		if err != nil {
+			return err
+		}
+		if checksum(object) != archive.ObjectSHA256 {
+			return errors.New("object checksum mismatch, left in the warm tier")
+		}
+		set["key"] = objectKey(TierCold, archive.Log, archive.Day)
+		header := http.Header{"Content-Type": {"application/octet-stream"}, "X-Amz-Meta-Sha256": {archive.ObjectSHA256}}
+		if coldClass != "" {
+			header.Set("X-Amz-Storage-Class", coldClass)
+		}
+		if err := bucket.Put(ctx, set["key"].(string), object, header); err != nil {
+			return err
+		}
+	}
+	if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": archive.ID}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+	if archive.Key != "" {
+		return bucket.Delete(ctx, archive.Key)
+	}
+	return nil
+}
+
+// List returns the archives of a log, or of every log when name is empty,
+// newest first
+func List(ctx context.Context, name string, limit int64) ([]Archive, error) {
+	filter := bson.M{}
+	if name != "" {
+		filter["log"] = name
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: -1}, {Key: "log", Value: 1}}).SetLimit(limit)
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]Archive, 0)
+	if err := cursor.All(ctx, &archives); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// find returns the archives matching filter, oldest first
+func find(ctx context.Context, filter bson.M) ([]Archive, error) {
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"day": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var archives []Archive
+	err = cursor.All(ctx, &archives)
+	return archives, err
+}
+
+// seal compresses and encrypts archive content; the nonce leads the result
+func seal(content []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, compressed.Bytes(), nil), nil
+}
+
+// open decrypts and decompresses a sealed archive
+func open(object []byte) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	if len(object) < gcm.NonceSize() {
+		return nil, errors.New("archive too short")
+	}
+	nonce, ciphertext := object[:gcm.NonceSize()], object[gcm.NonceSize():]
+	compressed, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// archiveID is the manifest ID of a day of a log
+func archiveID(name string, d time.Time) string {
+	return name + ":" + d.Format("2006-01-02")
+}
+
+// objectKey is where a tier keeps the archive of a day of a log
+func objectKey(tier, name string, d time.Time) string {
+	return prefix + tier + "/" + name + "/" + d.Format("2006/01/02") + ".ndjson.gz.enc"
+}
+
+// restoredCollection is where ranges of a log are restored to
+func restoredCollection(name string) string {
+	return "restored_" + name
+}
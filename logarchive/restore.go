@@ -0,0 +1,166 @@
+package logarchive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+)
+
+var (
+	// ErrNotFound is returned for archives that do not exist
+	ErrNotFound = errors.New("archive not found")
+	// ErrUnknownLog is returned for logs that are not archived
+	ErrUnknownLog = errors.New("unknown log")
+	// ErrInvalidRange is returned for restore ranges that are empty or too long
+	ErrInvalidRange = errors.New("invalid restore range")
+)
+
+// VerifyResult is the outcome of checking an archive
+type VerifyResult struct {
+	Archive  Archive  `json:"archive"`
+	OK       bool     `json:"ok" example:"true"`
+	Problems []string `json:"problems,omitempty" example:"object checksum mismatch"`
+}
+
+// RestoreResult tells where a range of a log was restored to
+type RestoreResult struct {
+	Log        string    `json:"log" example:"audit_logs"`
+	Collection string    `json:"collection" example:"restored_audit_logs"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Archives   int       `json:"archives" example:"3"`
+	Records    int       `json:"records" example:"5120"`
+	// Problems lists archives that could not be restored
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Verify downloads an archive and checks the object checksum, that it
+// decrypts, and the checksum and count of its records. The outcome is
+// recorded on the manifest.
+func Verify(ctx context.Context, id string) (*VerifyResult, error) {
+	var archive Archive
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id}).Decode(&archive)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{Archive: archive}
+	if _, err := load(ctx, archive); err != nil {
+		result.Problems = append(result.Problems, err.Error())
+	}
+	result.OK = len(result.Problems) == 0
+
+	now := clock.Now()
+	update := bson.M{"$set": bson.M{"verified_at": now, "verified": result.OK}}
+	if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return nil, err
+	}
+	result.Archive.VerifiedAt, result.Archive.Verified = &now, &result.OK
+	return result, nil
+}
+
+// load returns the records of an archive after checking them against the
+// manifest
+func load(ctx context.Context, archive Archive) ([][]byte, error) {
+	switch {
+	case archive.Tier == TierExpired:
+		return nil, errors.New("archive expired")
+	case archive.Records == 0:
+		return nil, nil
+	}
+	object, err := bucket.Get(ctx, archive.Key)
+	if err != nil {
+		return nil, fmt.Errorf("object unavailable: %w", err)
+	}
+	if checksum(object) != archive.ObjectSHA256 {
+		return nil, errors.New("object checksum mismatch")
+	}
+	content, err := open(object)
+	if err != nil {
+		if archive.KeyID != keyID {
+			return nil, fmt.Errorf("sealed with key %s, not the current key %s", archive.KeyID, keyID)
+		}
+		return nil, fmt.Errorf("cannot decrypt: %w", err)
+	}
+	if checksum(content) != archive.ContentSHA256 {
+		return nil, errors.New("content checksum mismatch")
+	}
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	if len(lines) != archive.Records {
+		return nil, fmt.Errorf("%d records, manifest says %d", len(lines), archive.Records)
+	}
+	return lines, nil
+}
+
+// Restore copies the records of a log created in [from, to) from its
+// archives into restored_<log>, where they are kept for
+// LOG_ARCHIVE_RESTORE_TTL. Restoring a range again replaces the records.
+func Restore(ctx context.Context, name string, from, to time.Time) (*RestoreResult, error) {
+	known := false
+	for _, l := range Logs {
+		known = known || l == name
+	}
+	if !known {
+		return nil, ErrUnknownLog
+	}
+	if !to.After(from) || to.Sub(from) > time.Duration(maxRestoreDays)*day {
+		return nil, ErrInvalidRange
+	}
+
+	filter := bson.M{"log": name, "records": bson.M{"$gt": 0}, "day": bson.M{"$gte": from.UTC().Truncate(day), "$lt": to}}
+	archives, err := find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := &RestoreResult{Log: name, Collection: restoredCollection(name), From: from, To: to}
+	restored := database.DB.Collection(result.Collection)
+	now := clock.Now()
+	for _, archive := range archives {
+		lines, err := load(ctx, archive)
+		if err != nil {
+			result.Problems = append(result.Problems, archive.ID+": "+err.Error())
+			continue
+		}
+		var writes []mongo.WriteModel
+		for _, line := range lines {
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(line, true, &doc); err != nil {
+				return nil, fmt.Errorf("%s: %w", archive.ID, err)
+			}
+			var record struct {
+				ID        interface{} `bson:"_id"`
+				CreatedAt time.Time   `bson:"created_at"`
+			}
+			raw, err := bson.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			if err := bson.Unmarshal(raw, &record); err != nil {
+				return nil, err
+			}
+			if record.CreatedAt.Before(from) || !record.CreatedAt.Before(to) {
+				continue
+			}
+			doc = append(doc, bson.E{Key: "restored_at", Value: now})
+			writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": record.ID}).SetReplacement(doc).SetUpsert(true))
+		}
+		if len(writes) > 0 {
+			if _, err := restored.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+				return nil, fmt.Errorf("%s: %w", archive.ID, err)
+			}
+		}
+		result.Archives++
+		result.Records += len(writes)
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+// Package loginhistory records every sign-in attempt on an account, whether
+// it succeeded or not, in the login_events collection, so users can review
+// where their account was used and admins can investigate it. Attempts on
+// addresses without an account have no user to file them under; they are
+// only kept as security events.
+package loginhistory
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/tokens"
+)
+
+// collection holds login events
+const collection = "login_events"
+
+// Sign-in methods, besides the names of OAuth providers
+const (
+	MethodPassword  = "password"
+	MethodMagicLink = "magic_link"
+	MethodSMS       = "sms"
+	MethodPasskey   = "passkey"
+)
+
+// Outcomes of an attempt
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one sign-in attempt on an account. Method is MethodPassword,
+// MethodMagicLink, MethodSMS, MethodPasskey or the OAuth provider signed in
+// with.
+type Event struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Method    string             `bson:"method" json:"method" example:"password"`
+	Outcome   string             `bson:"outcome" json:"outcome" example:"failure"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty" example:"invalid password"`
+	IP        string             `bson:"ip,omitempty" json:"ip,omitempty" example:"203.0.113.7"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty" example:"Mozilla/5.0"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Init indexes login events by user and drops them after
+// LoginHistoryRetention
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.M{"created_at": 1}, Options: options.Index().SetExpireAfterSeconds(int32(cfg.LoginHistoryRetention.Seconds()))},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("loginhistory: failed to create indexes: %v", err)
+	}
+}
+
+// Record stores a sign-in attempt on a user's account, made by the client
+// of the request. Failures to store it are logged; they never fail the
+// sign-in.
+func Record(r *http.Request, userID, method, outcome, reason string) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return
+	}
+	client := tokens.ClientOf(r)
+	event := Event{
+		ID:        clock.NewID(),
+		UserID:    id,
+		Method:    method,
+		Outcome:   outcome,
+		Reason:    reason,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: clock.Now(),
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(context.Background(), event); err != nil {
+		log.Printf("loginhistory: failed to record %s sign-in of user %s: %v", outcome, userID, err)
+	}
+}
+
+// List returns up to limit of a user's login events, newest first, older
+// than the event before when it is set
+func List(ctx context.Context, userID, before primitive.ObjectID, limit int64) ([]Event, error) {
+	filter := bson.M{"user_id": userID}
+	if !before.IsZero() {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit)
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+// Purge deletes a user's login history and returns how many events it held
+func Purge(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := database.DB.Collection(collection).DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,51 @@
+// Package magiclink issues the one-time sign-in links of passwordless login.
+// A token is bound to the address it was sent to, expires after
+// MAGIC_LINK_TTL and works once; requesting a new link replaces the old. See
+// singleuse.
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/singleuse"
+)
+
+var (
+	// ErrInvalidToken is returned for unknown, used and expired tokens
+	ErrInvalidToken = errors.New("invalid or expired sign-in link")
+	// ErrCooldown is returned by Issue when the user was sent a link moments
+	// ago
+	ErrCooldown = errors.New("a sign-in link was sent recently")
+)
+
+// Token is an unused sign-in token
+type Token = singleuse.Token
+
+var store = singleuse.Store{Collection: "login_tokens", ErrInvalid: ErrInvalidToken, ErrCooldown: ErrCooldown}
+
+// Init reads the token lifetime and cooldown and creates the indexes
+func Init(cfg *config.Config) {
+	store.TTL, store.Cooldown = cfg.MagicLinkTTL, cfg.MagicLinkCooldown
+	store.Init()
+}
+
+// TTL returns how long issued links are valid
+func TTL() time.Duration {
+	return store.TTL
+}
+
+// Issue creates a sign-in token for a user's address, voiding any earlier
+// one. It returns ErrCooldown instead when the last token is younger than
+// MAGIC_LINK_COOLDOWN.
+func Issue(ctx context.Context, userID primitive.ObjectID, emailHash string) (string, error) {
+	return store.Issue(ctx, userID, emailHash)
+}
+
+// Redeem consumes a token and returns it
+func Redeem(ctx context.Context, raw string) (*Token, error) {
+	return store.Redeem(ctx, raw)
+}
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+
+	"golang-backend/config"
+)
+
+// Mailer delivers emails to users
+type Mailer interface {
+	// Send delivers a plain-text email
+	Send(to, subject, body string) error
+	// SendMessage delivers a rendered template, with an HTML part when it has one
+	SendMessage(msg Message) error
+}
+
+// New returns an SMTP mailer when SMTP is configured, otherwise a log mailer
+func New(cfg *config.Config) Mailer {
+	if cfg.SMTPHost == "" {
+		return LogMailer{}
+	}
+	return &SMTPMailer{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}
+}
+
+// LogMailer writes emails to the application log instead of sending them
+type LogMailer struct{}
+
+// Send logs the email
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("mailer: to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+// SendMessage logs the subject and plain-text body of the email
+func (l LogMailer) SendMessage(msg Message) error {
+	return l.Send(msg.To, msg.Subject, msg.Text)
+}
+
+// SMTPMailer sends emails through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the email through the configured SMTP server
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", m.From, to, subject, body)
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// SendMessage delivers a rendered email as UTF-8, as multipart/alternative when
+// it has an HTML body. The brand's sender name is shown alongside the From address.
+func (m *SMTPMailer) SendMessage(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	from := (&mail.Address{Name: msg.FromName, Address: m.From}).String()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", from, msg.To, mime.QEncoding.Encode("utf-8", msg.Subject))
+
+	if msg.HTML == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s", msg.Text)
+		return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, buf.Bytes())
+	}
+
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(part.body)); err != nil {
+			return err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, buf.Bytes())
+}
@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Brand is the look of an email: the sending product or organization's name,
+// logo and accent color. Organizations override the defaults from configuration.
+type Brand struct {
+	Name         string
+	SenderName   string
+	LogoURL      string
+	PrimaryColor string
+}
+
+// Recipient is what templates may show about the user an email is sent to
+type Recipient struct {
+	Email       string
+	DisplayName string
+}
+
+// TemplateData is passed to every email template
+type TemplateData struct {
+	Locale string
+	AppURL string
+	Brand  Brand
+	User   Recipient
+	Vars   map[string]string
+}
+
+// Message is a rendered email with plain-text and HTML bodies
+type Message struct {
+	To       string
+	FromName string
+	Subject  string
+	Text     string
+	HTML     string
+}
+
+// Locales returns the locales a template is available in
+func Locales(name string) []string {
+	matches, _ := fs.Glob(templateFS, "templates/"+name+".*.txt")
+	locales := make([]string, 0, len(matches))
+	for _, match := range matches {
+		locales = append(locales, strings.TrimSuffix(strings.TrimPrefix(match, "templates/"+name+"."), ".txt"))
+	}
+	return locales
+}
+
+// ResolveLocale picks the best available locale of a template: the exact
+// locale ("pt-br"), then its language ("pt"), then fallback
+func ResolveLocale(name, locale, fallback string) string {
+	available := Locales(name)
+	has := func(l string) bool {
+		for _, a := range available {
+			if a == l {
+				return true
+			}
+		}
+		return false
+	}
+
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if has(locale) {
+		return locale
+	}
+	if lang, _, ok := strings.Cut(locale, "-"); ok && has(lang) {
+		return lang
+	}
+	return fallback
+}
+
+// Render renders a named template in the locale set in data, which must be
+// one returned by ResolveLocale
+func Render(name, to string, data TemplateData) (Message, error) {
+	base := fmt.Sprintf("templates/%s.%s", name, data.Locale)
+
+	text, err := template.ParseFS(templateFS, base+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{To: to, FromName: data.Brand.SenderName, Subject: strings.TrimSpace(subject.String()), Text: body.String()}
+
+	// HTML is optional; the plain-text body is always sent
+	if _, err := fs.Stat(templateFS, base+".html"); err != nil {
+		return msg, nil
+	}
+	html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", base+".html")
+	if err != nil {
+		return Message{}, err
+	}
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, name+"."+data.Locale+".html", data); err != nil {
+		return Message{}, err
+	}
+	msg.HTML = htmlBody.String()
+	return msg, nil
+}
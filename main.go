@@ -3,14 +3,69 @@ package main
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "golang-backend/docs"
+	"golang-backend/adminviews"
+	"golang-backend/analytics"
+	"golang-backend/anomaly"
+	"golang-backend/apikeys"
+	"golang-backend/audit"
+	"golang-backend/breakglass"
+	"golang-backend/cache"
+	"golang-backend/challenge"
+	"golang-backend/chaos"
 	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/customfields"
 	"golang-backend/database"
+	"golang-backend/deprecation"
+	"golang-backend/emailcheck"
+	"golang-backend/emailverify"
+	"golang-backend/eventsource"
+	"golang-backend/files"
+	"golang-backend/geoip"
 	"golang-backend/handlers"
+	"golang-backend/health"
+	"golang-backend/invitations"
+	"golang-backend/jobs"
+	"golang-backend/logarchive"
+	"golang-backend/loginhistory"
+	"golang-backend/magiclink"
+	"golang-backend/mailer"
+	"golang-backend/mesh"
 	"golang-backend/middleware"
+	"golang-backend/migrations"
+	"golang-backend/mock"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/oauth"
+	"golang-backend/onboarding"
+	"golang-backend/otp"
+	"golang-backend/passwordpolicy"
+	"golang-backend/passwordreset"
+	"golang-backend/permissions"
+	"golang-backend/ratelimit"
+	"golang-backend/recorder"
+	"golang-backend/reporting"
+	"golang-backend/rolegrants"
+	"golang-backend/routes"
+	"golang-backend/scan"
+	"golang-backend/security"
+	"golang-backend/routestats"
+	"golang-backend/servicetraffic"
+	"golang-backend/signupgate"
+	"golang-backend/sms"
+	"golang-backend/synthetic"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+	"golang-backend/uploads"
+	"golang-backend/usertags"
+	"golang-backend/utils"
+	"golang-backend/watcher"
+	"golang-backend/webauthn"
 )
 
 // @title Golang Backend API
@@ -35,38 +90,396 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Mock and demo mode serve the core API from memory without MongoDB
+	if cfg.MockMode || cfg.DemoMode {
+		mr := mux.NewRouter()
+		mr.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+		mr.PathPrefix("/").Handler(mock.Router(cfg))
+
+		log.Println("Mock server starting on :8080")
+		log.Fatal(http.ListenAndServe(":8080", middleware.Recover(mr)))
+	}
+
 	// Connect to database
 	database.Connect(cfg.MongoURI)
+	database.ConnectRegions(cfg.DataRegion, cfg.MongoRegionURIs)
+	database.ConfigureStandby(cfg.MongoStandbyURI, cfg.MongoCutoverDrainTimeout, cfg.MongoCutoverPoll)
+
+	// Bring legacy documents up to date before serving
+	migrations.Run(cfg)
+
+	// Audit chain, and security event storage and SIEM exporters
+	audit.Init()
+	security.Init(cfg)
+	loginhistory.Init(cfg)
+
+	// Upload scanners and quarantine, and direct uploads to object storage
+	scan.Init(cfg)
+	uploads.Init(cfg)
+	files.Init(cfg)
+
+	// Response cache for read endpoints
+	cache.Init(cfg)
+
+	// Email deliverability checks for registration and email changes
+	emailcheck.Init(cfg)
+	ratelimit.Init(cfg)
+	servicetraffic.Init(cfg)
+	correlation.Init(cfg)
+
+	// Routes retired by configuration
+	deprecation.Init(cfg)
+
+	// Permissions session tokens carry for each role
+	permissions.Init(cfg)
+
+	// Session token IDs for replay detection and global revocation
+	tokens.Init(cfg)
+
+	// Caller identity forwarded by a service mesh sidecar
+	mesh.Init(cfg)
+
+	// Replay protection for signed API key requests
+	apikeys.Init(cfg)
+
+	// One-time emergency admin tokens issued by adminctl
+	breakglass.Init(cfg)
+	challenge.Init(cfg)
+
+	// Rules for new passwords
+	passwordpolicy.Init(cfg)
+
+	// Soft launch gating of registrations by country, email domain and invitation
+	geoip.Init(cfg)
+	signupgate.Init(cfg)
+	invitations.Init()
+
+	// Single-use tokens emailed by the forgotten password, email
+	// verification and passwordless sign-in flows
+	passwordreset.Init(cfg)
+	emailverify.Init(cfg)
+	magiclink.Init(cfg)
+	otp.Init(cfg)
+	webauthn.Init(cfg)
+
+	// Social sign-in providers with credentials configured
+	oauth.Init(cfg)
+
+	// Admin-managed user tags
+	usertags.Init()
+	adminviews.Init()
+	customfields.Init()
+	onboarding.Init(cfg)
+
+	// Optional event-sourced history of user documents
+	eventsource.Init(cfg)
+
+	// Component health checks and uptime samples for the public status page
+	health.Init(cfg)
+
+	// In-app notification inbox
+	notifier.InitInbox(cfg)
+
+	// Client analytics event buffer and sink
+	analytics.Init(cfg)
+
+	// Daily signup, retention and active user reports
+	reporting.Init(cfg)
+
+	// Bound concurrent bcrypt work
+	utils.InitPasswordPool(cfg.BcryptWorkers, cfg.BcryptQueueTimeout)
+
+	// Start background workers
+	notify := notifier.New(cfg)
+	anomaly.Start(cfg, notify)
+	watcher.Start(cfg)
+	eventsource.Start(cfg)
+	synthetic.Start(cfg, notify)
+	routestats.Start(cfg, notify)
+	rolegrants.Start(cfg, notify)
+	handlers.RegisterJobs(cfg, notify)
+	logarchive.Init(cfg)
+	jobs.Start(cfg)
 
 	// Create router
 	r := mux.NewRouter()
 
+	// Serve verified custom domains as their organization
+	r.Use(tenant.Middleware)
+
+	// Record calls made by other services
+	r.Use(servicetraffic.Middleware)
+
+	// Count responses per route for metrics and error rate alerts
+	r.Use(routestats.Middleware)
+
+	// Route groups decide CORS, authentication, rate limits and auditing
+	for _, line := range routes.Describe(cfg) {
+		log.Printf("Route group %s", line)
+	}
+	public := routes.Group(r, cfg, routes.Public, "")
+	internal := routes.Group(r, cfg, routes.Internal, "")
+
 	// Auth routes
-	r.HandleFunc("/register", handlers.Register(cfg)).Methods("POST")
-	r.HandleFunc("/login", handlers.Login(cfg)).Methods("POST")
+	public.Handle("/register", ratelimit.PerClient("register")(handlers.Register(cfg))).Methods("POST")
+	public.Handle("/login", ratelimit.PerClient("login")(handlers.Login(cfg))).Methods("POST")
+	public.Handle("/login/magic", ratelimit.PerClient("login_magic")(handlers.RequestMagicLink(cfg, mailer.New(cfg)))).Methods("POST")
+	public.Handle("/login/magic/verify", ratelimit.PerClient("login")(handlers.MagicLinkLogin(cfg))).Methods("POST")
+	public.Handle("/login/otp/request", ratelimit.PerClient("login_otp")(handlers.RequestLoginOTP(cfg, sms.New(cfg)))).Methods("POST")
+	public.Handle("/login/otp/verify", ratelimit.PerClient("login")(handlers.LoginOTP(cfg))).Methods("POST")
+	public.Handle("/webauthn/login/begin", ratelimit.PerClient("login")(http.HandlerFunc(handlers.BeginPasskeyLogin))).Methods("POST")
+	public.Handle("/webauthn/login/finish", ratelimit.PerClient("login")(handlers.FinishPasskeyLogin(cfg))).Methods("POST")
+	public.Handle("/token/refresh", ratelimit.PerClient("token_refresh")(handlers.RefreshToken(cfg))).Methods("POST")
+	public.Handle("/password/forgot", ratelimit.PerClient("password_forgot")(handlers.ForgotPassword(cfg, mailer.New(cfg)))).Methods("POST")
+	public.Handle("/password/reset", ratelimit.PerClient("password_reset")(http.HandlerFunc(handlers.ResetPassword))).Methods("POST")
+	public.HandleFunc("/verify-email", handlers.VerifyEmail).Methods("GET", "POST")
+	switch cfg.RegistrationEnumeration {
+	case handlers.EnumerationAvailability:
+		public.Handle("/availability", ratelimit.PerClient("availability")(handlers.CheckAvailability(cfg))).Methods("GET")
+	case handlers.EnumerationSilent, "":
+	default:
+		log.Printf("Unknown REGISTRATION_ENUMERATION %q, taken addresses get 409", cfg.RegistrationEnumeration)
+	}
+	public.Handle("/verify-email/resend", ratelimit.PerClient("verify_email_resend")(handlers.ResendVerification(cfg))).Methods("POST")
+	if oauth.Enabled() {
+		// Only configured providers match, leaving the rest of /auth/ free
+		provider := "/auth/{provider:" + strings.Join(oauth.Providers(), "|") + "}"
+		public.HandleFunc("/auth/providers", handlers.OAuthProviders).Methods("GET")
+		public.HandleFunc(provider, handlers.OAuthStart).Methods("GET")
+		public.HandleFunc(provider+"/callback", handlers.OAuthCallback(cfg)).Methods("GET")
+	}
 
 	// Admin auth routes
-	r.HandleFunc("/admin/register", handlers.AdminRegister(cfg)).Methods("POST")
-	r.HandleFunc("/admin/login", handlers.AdminLogin(cfg)).Methods("POST")
+	public.Handle("/admin/login", ratelimit.PerClient("login")(handlers.AdminLogin(cfg))).Methods("POST")
+	if breakglass.Enabled() {
+		public.HandleFunc("/admin/break-glass", handlers.RedeemBreakGlass).Methods("POST")
+	}
+
+	// scoped limits API key access to a route to keys holding the scope
+	scoped := func(scope string, h http.Handler) http.Handler {
+		return middleware.RequireScope(scope)(h)
+	}
+
+	// Operations that are hard to undo need a recently elevated session
+	sudo := middleware.RequireSudo(cfg.SudoTTL)
+
+	// permitted limits a route to sessions whose role grants the permission
+	permitted := func(permission string, h http.Handler) http.Handler {
+		return middleware.RequirePermission(permission)(h)
+	}
 
 	// Protected routes
-	protected := r.PathPrefix("/").Subrouter()
-	protected.Use(middleware.JWTAuthMiddleware(cfg))
+	protected := routes.Group(r, cfg, routes.Authenticated, "")
+
+	// Sign out, revoking the session token
+	protected.HandleFunc("/logout", handlers.Logout).Methods("POST")
+
+	// Re-authenticate for operations behind sudo
+	protected.Handle("/auth/sudo", middleware.SessionOnly(handlers.Sudo(cfg))).Methods("POST")
 
 	// User routes
-	protected.HandleFunc("/user/profile", handlers.GetUserProfile).Methods("GET")
-	protected.HandleFunc("/user/profile", handlers.UpdateUserProfile).Methods("PUT")
+	protected.Handle("/user/profile", scoped(models.ScopeProfileRead, cache.Middleware(cache.TagUsers)(handlers.GetUserProfile(cfg)))).Methods("GET")
+	protected.Handle("/user/profile", scoped(models.ScopeProfileWrite, handlers.UpdateUserProfile(cfg))).Methods("PUT")
+	protected.Handle("/user/custom-fields", scoped(models.ScopeProfileRead, http.HandlerFunc(handlers.ListCustomFields))).Methods("GET")
+	protected.Handle("/user/onboarding", scoped(models.ScopeProfileRead, http.HandlerFunc(handlers.GetOnboarding))).Methods("GET")
+	protected.Handle("/report", scoped(models.ScopeReportsWrite, http.HandlerFunc(handlers.CreateReport))).Methods("POST")
+	protected.Handle("/user/events", scoped(models.ScopeEventsRead, http.HandlerFunc(handlers.UserEvents))).Methods("GET")
+	protected.Handle("/user/login-history", scoped(models.ScopeProfileRead, http.HandlerFunc(handlers.GetLoginHistory))).Methods("GET")
+	protected.Handle("/user/notifications", scoped(models.ScopeProfileRead, http.HandlerFunc(handlers.ListNotifications))).Methods("GET")
+	protected.Handle("/user/notifications/settings", scoped(models.ScopeProfileRead, http.HandlerFunc(handlers.GetNotificationSettings))).Methods("GET")
+	protected.Handle("/user/notifications/settings", scoped(models.ScopeProfileWrite, http.HandlerFunc(handlers.UpdateNotificationSettings))).Methods("PUT")
+
+	// Direct-to-storage uploads
+	if uploads.Enabled() {
+		protected.Handle("/user/uploads", scoped(models.ScopeUploadsWrite, http.HandlerFunc(handlers.ListUploads))).Methods("GET")
+		protected.Handle("/user/uploads/sign", scoped(models.ScopeUploadsWrite, http.HandlerFunc(handlers.SignUpload))).Methods("POST")
+		protected.Handle("/user/uploads/{id}/confirm", scoped(models.ScopeUploadsWrite, http.HandlerFunc(handlers.ConfirmUpload))).Methods("POST")
+
+		// Files attached from confirmed uploads, with ownership checked per file
+		fileRead := func(h http.HandlerFunc) http.Handler {
+			return scoped(models.ScopeFilesRead, middleware.FileAccess(false)(h))
+		}
+		fileWrite := func(h http.HandlerFunc) http.Handler {
+			return scoped(models.ScopeUploadsWrite, middleware.FileAccess(true)(h))
+		}
+		protected.Handle("/user/files", scoped(models.ScopeUploadsWrite, http.HandlerFunc(handlers.CreateFile))).Methods("POST")
+		protected.Handle("/user/files", scoped(models.ScopeFilesRead, http.HandlerFunc(handlers.ListFiles))).Methods("GET")
+		protected.Handle("/files/{id}", fileRead(handlers.GetFile)).Methods("GET")
+		protected.Handle("/files/{id}", fileWrite(handlers.UpdateFile)).Methods("PATCH")
+		protected.Handle("/files/{id}", fileWrite(handlers.DeleteFile)).Methods("DELETE")
+	}
 
-	// Admin routes
-	admin := r.PathPrefix("/admin").Subrouter()
-	admin.Use(middleware.JWTAuthMiddleware(cfg))
-	admin.HandleFunc("/users", handlers.ListUsers).Methods("GET")
-	admin.HandleFunc("/users/delete", handlers.DeleteUser).Methods("POST")
-	admin.HandleFunc("/users/role", handlers.UpdateUserRole).Methods("PUT")
+	// API key management is only available to signed-in sessions
+	protected.Handle("/user/api-keys", middleware.SessionOnly(http.HandlerFunc(handlers.CreateAPIKey))).Methods("POST")
+	protected.Handle("/user/api-keys", middleware.SessionOnly(http.HandlerFunc(handlers.ListAPIKeys))).Methods("GET")
+	protected.Handle("/user/api-keys/usage", middleware.SessionOnly(http.HandlerFunc(handlers.GetAPIKeyUsage))).Methods("GET")
+	protected.Handle("/user/api-keys/{id}", middleware.SessionOnly(http.HandlerFunc(handlers.RevokeAPIKey))).Methods("DELETE")
+	protected.Handle("/user/password", middleware.SessionOnly(handlers.ChangePassword(cfg))).Methods("PUT")
+	protected.Handle("/user/account", middleware.SessionOnly(handlers.DeleteAccount(cfg))).Methods("DELETE")
+	protected.Handle("/user/phone", middleware.SessionOnly(sudo(handlers.AddPhone(cfg, sms.New(cfg))))).Methods("PUT")
+	protected.Handle("/user/phone/verify", middleware.SessionOnly(http.HandlerFunc(handlers.VerifyPhone))).Methods("POST")
+	protected.Handle("/user/phone", middleware.SessionOnly(http.HandlerFunc(handlers.RemovePhone))).Methods("DELETE")
+	protected.Handle("/webauthn/register/begin", middleware.SessionOnly(sudo(handlers.BeginPasskeyRegistration(cfg)))).Methods("POST")
+	protected.Handle("/webauthn/register/finish", middleware.SessionOnly(http.HandlerFunc(handlers.FinishPasskeyRegistration))).Methods("POST")
+	protected.Handle("/user/passkeys", middleware.SessionOnly(http.HandlerFunc(handlers.ListPasskeys))).Methods("GET")
+	protected.Handle("/user/passkeys/{id}", middleware.SessionOnly(http.HandlerFunc(handlers.RemovePasskey))).Methods("DELETE")
+
+	// Heavy route groups get their own concurrency limits
+	importLimit := middleware.ConcurrencyLimit("import", cfg.GroupLimit("import", 2), cfg.ConcurrencyQueueTimeout)
+	exportLimit := middleware.ConcurrencyLimit("export", cfg.GroupLimit("export", 2), cfg.ConcurrencyQueueTimeout)
+
+	// Admin routes, each limited to the permission it needs
+	admin := routes.Group(r, cfg, routes.Admin, "/admin")
+	admin.Handle("/register", permitted(permissions.UsersRole, sudo(handlers.AdminRegister(cfg)))).Methods("POST")
+	admin.Handle("/users", permitted(permissions.UsersRead, cache.Middleware(cache.TagUsers)(handlers.ListUsers(cfg)))).Methods("GET")
+	admin.Handle("/users/export.ndjson", permitted(permissions.UsersExport, exportLimit(handlers.ExportUsersNDJSON(cfg)))).Methods("GET")
+	admin.Handle("/users/delete", permitted(permissions.UsersDelete, sudo(handlers.DeleteUser(cfg)))).Methods("POST")
+	admin.Handle("/users/role", permitted(permissions.UsersRole, sudo(handlers.UpdateUserRole(cfg)))).Methods("PUT")
+	admin.Handle("/users/import", permitted(permissions.UsersImport, sudo(importLimit(handlers.ImportUsers(cfg))))).Methods("POST")
+	admin.Handle("/users/import/{id}", permitted(permissions.UsersImport, handlers.GetUserImport(cfg))).Methods("GET")
+	admin.Handle("/tags", permitted(permissions.UsersTag, http.HandlerFunc(handlers.ListUserTags))).Methods("GET")
+	admin.Handle("/users/tags", permitted(permissions.UsersTag, http.HandlerFunc(handlers.BulkTagUsers))).Methods("POST")
+	admin.Handle("/users/{id}/tags", permitted(permissions.UsersTag, http.HandlerFunc(handlers.TagUser))).Methods("POST")
+	admin.Handle("/users/{id}/tags/{tag}", permitted(permissions.UsersTag, http.HandlerFunc(handlers.UntagUser))).Methods("DELETE")
+	admin.Handle("/operations/{id}/undo", permitted(permissions.UsersManage, http.HandlerFunc(handlers.UndoOperation))).Methods("POST")
+	admin.Handle("/approvals", permitted(permissions.ApprovalsManage, http.HandlerFunc(handlers.ListApprovals))).Methods("GET")
+	admin.Handle("/approvals/{id}/approve", permitted(permissions.ApprovalsManage, sudo(handlers.ApproveApproval(cfg)))).Methods("POST")
+	admin.Handle("/approvals/{id}/reject", permitted(permissions.ApprovalsManage, http.HandlerFunc(handlers.RejectApproval))).Methods("POST")
+	admin.Handle("/role-grants", permitted(permissions.UsersRead, http.HandlerFunc(handlers.ListRoleGrants))).Methods("GET")
+	admin.Handle("/views", permitted(permissions.ViewsManage, http.HandlerFunc(handlers.ListAdminViews))).Methods("GET")
+	admin.Handle("/views/{name}", permitted(permissions.ViewsManage, http.HandlerFunc(handlers.SaveAdminView))).Methods("PUT")
+	admin.Handle("/views/{name}", permitted(permissions.ViewsManage, http.HandlerFunc(handlers.DeleteAdminView))).Methods("DELETE")
+	admin.Handle("/custom-fields", permitted(permissions.FieldsManage, http.HandlerFunc(handlers.ListCustomFields))).Methods("GET")
+	admin.Handle("/custom-fields/{name}", permitted(permissions.FieldsManage, http.HandlerFunc(handlers.DefineCustomField))).Methods("PUT")
+	admin.Handle("/custom-fields/{name}", permitted(permissions.FieldsManage, http.HandlerFunc(handlers.RemoveCustomField))).Methods("DELETE")
+	admin.Handle("/security/events", permitted(permissions.SecurityRead, exportLimit(http.HandlerFunc(handlers.ListSecurityEvents)))).Methods("GET")
+	admin.Handle("/uploads/quarantine", permitted(permissions.UploadsReview, http.HandlerFunc(handlers.ListQuarantinedUploads))).Methods("GET")
+	admin.Handle("/uploads/quarantine/{id}/content", permitted(permissions.UploadsReview, http.HandlerFunc(handlers.DownloadQuarantinedUpload))).Methods("GET")
+	admin.Handle("/uploads/quarantine/{id}/review", permitted(permissions.UploadsReview, http.HandlerFunc(handlers.ReviewQuarantinedUpload))).Methods("POST")
+	admin.Handle("/reports", permitted(permissions.ReportsRead, http.HandlerFunc(handlers.ListReports))).Methods("GET")
+	admin.Handle("/reports/{id}/status", permitted(permissions.ReportsManage, http.HandlerFunc(handlers.UpdateReportStatus))).Methods("PUT")
+	admin.Handle("/reports/signups", permitted(permissions.ReportsRead, exportLimit(http.HandlerFunc(handlers.SignupReport)))).Methods("GET")
+	admin.Handle("/reports/retention", permitted(permissions.ReportsRead, exportLimit(http.HandlerFunc(handlers.RetentionReport)))).Methods("GET")
+	admin.Handle("/reports/active-users", permitted(permissions.ReportsRead, exportLimit(http.HandlerFunc(handlers.ActiveUsersReport)))).Methods("GET")
+	admin.Handle("/reports/duplicates", permitted(permissions.ReportsRead, exportLimit(http.HandlerFunc(handlers.DuplicatesReport)))).Methods("GET")
+	admin.Handle("/reports/custom-fields", permitted(permissions.ReportsRead, exportLimit(http.HandlerFunc(handlers.CustomFieldsReport)))).Methods("GET")
+	admin.Handle("/orgs", permitted(permissions.OrgsManage, handlers.CreateOrganization(cfg))).Methods("POST")
+	admin.Handle("/orgs", permitted(permissions.OrgsRead, cache.Middleware(cache.TagOrgs)(http.HandlerFunc(handlers.ListOrganizations)))).Methods("GET")
+	admin.Handle("/orgs/{id}/keys", permitted(permissions.OrgsRead, http.HandlerFunc(handlers.ListOrgKeys))).Methods("GET")
+	admin.Handle("/orgs/{id}/keys/rotate", permitted(permissions.OrgsKeys, sudo(handlers.RotateOrgKey(cfg)))).Methods("POST")
+	admin.Handle("/orgs/{id}/keys", permitted(permissions.OrgsKeys, sudo(http.HandlerFunc(handlers.DestroyOrgKeys)))).Methods("DELETE")
+	admin.Handle("/orgs/{id}/region", permitted(permissions.OrgsManage, sudo(http.HandlerFunc(handlers.MoveOrganizationRegion)))).Methods("PUT")
+	admin.Handle("/orgs/{id}/branding", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.UpdateOrganizationBranding))).Methods("PUT")
+	admin.Handle("/orgs/{id}/suspend", permitted(permissions.OrgsManage, sudo(http.HandlerFunc(handlers.SuspendOrganization)))).Methods("POST")
+	admin.Handle("/orgs/{id}/archive", permitted(permissions.OrgsManage, sudo(http.HandlerFunc(handlers.ArchiveOrganization)))).Methods("POST")
+	admin.Handle("/orgs/{id}/reactivate", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.ReactivateOrganization))).Methods("POST")
+	admin.Handle("/orgs/{id}/export", permitted(permissions.OrgsExport, exportLimit(handlers.ExportOrganization(cfg)))).Methods("GET")
+	admin.Handle("/orgs/{id}", permitted(permissions.OrgsDelete, sudo(handlers.DeleteOrganization(cfg, notify)))).Methods("DELETE")
+	admin.Handle("/orgs/{id}/limits", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.UpdateOrganizationLimits))).Methods("PUT")
+	admin.Handle("/orgs/{id}/usage", permitted(permissions.OrgsRead, http.HandlerFunc(handlers.OrganizationUsage))).Methods("GET")
+	admin.Handle("/orgs/{id}/domains", permitted(permissions.OrgsRead, http.HandlerFunc(handlers.ListOrgDomains))).Methods("GET")
+	admin.Handle("/orgs/{id}/domains", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.AddOrgDomain))).Methods("POST")
+	admin.Handle("/orgs/{id}/domains/{domainID}/verify", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.VerifyOrgDomain))).Methods("POST")
+	admin.Handle("/orgs/{id}/domains/{domainID}", permitted(permissions.OrgsManage, http.HandlerFunc(handlers.RemoveOrgDomain))).Methods("DELETE")
+	admin.Handle("/users/{id}/org", permitted(permissions.UsersManage, sudo(handlers.AssignUserOrganization(cfg)))).Methods("PUT")
+	admin.Handle("/cache/stats", permitted(permissions.SystemRead, http.HandlerFunc(handlers.CacheStats))).Methods("GET")
+	admin.Handle("/service-traffic", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ServiceTraffic))).Methods("GET")
+	admin.Handle("/deprecations", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListDeprecations))).Methods("GET")
+	admin.Handle("/permissions", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListRolePermissions))).Methods("GET")
+	admin.Handle("/permissions/{role}", permitted(permissions.PermissionsManage, sudo(http.HandlerFunc(handlers.SetRolePermissions)))).Methods("PUT")
+	admin.Handle("/requests/{request_id}", permitted(permissions.SecurityRead, http.HandlerFunc(handlers.RequestDetails))).Methods("GET")
+	admin.Handle("/audit/verify", permitted(permissions.AuditVerify, http.HandlerFunc(handlers.VerifyAuditChain))).Methods("POST")
+	admin.Handle("/tokens/revoke", permitted(permissions.TokensRevoke, sudo(handlers.RevokeAllTokens(cfg)))).Methods("POST")
+	admin.Handle("/jwt/secrets", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListJWTSecrets))).Methods("GET")
+	admin.Handle("/jwt/rotate", permitted(permissions.JWTRotate, sudo(handlers.RotateJWTSecret(cfg)))).Methods("POST")
+	admin.Handle("/users/events", permitted(permissions.UsersRead, http.HandlerFunc(handlers.AdminUserEvents))).Methods("GET")
+	admin.Handle("/password-pool/stats", permitted(permissions.SystemRead, http.HandlerFunc(handlers.PasswordPoolStats))).Methods("GET")
+	admin.Handle("/routes/stats", permitted(permissions.SystemRead, http.HandlerFunc(handlers.RouteStats))).Methods("GET")
+	admin.Handle("/jobs", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListJobs))).Methods("GET")
+	admin.Handle("/jobs/{name}/run", permitted(permissions.JobsRun, http.HandlerFunc(handlers.RunJob))).Methods("POST")
+	admin.Handle("/jobs/{name}/runs", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListJobRuns))).Methods("GET")
+	admin.Handle("/jobs/{name}/runs/{id}/log", permitted(permissions.SystemRead, http.HandlerFunc(handlers.JobRunLog))).Methods("GET")
+	if logarchive.Enabled() {
+		admin.Handle("/log-archives", permitted(permissions.LogsManage, http.HandlerFunc(handlers.ListLogArchives))).Methods("GET")
+		admin.Handle("/log-archives/restore", permitted(permissions.LogsManage, http.HandlerFunc(handlers.RestoreLogArchives))).Methods("POST")
+		admin.Handle("/log-archives/{id}/verify", permitted(permissions.LogsManage, http.HandlerFunc(handlers.VerifyLogArchive))).Methods("POST")
+	}
+	admin.Handle("/migrations", permitted(permissions.SystemRead, http.HandlerFunc(handlers.ListMigrationRuns))).Methods("GET")
+	admin.Handle("/users/{id}/forget", permitted(permissions.UsersForget, sudo(handlers.ForgetUser(cfg, notify)))).Methods("POST")
+	admin.Handle("/users/{id}/credentials", permitted(permissions.UsersRead, http.HandlerFunc(handlers.ListUserCredentials))).Methods("GET")
+	admin.Handle("/users/{id}/logins", permitted(permissions.UsersRead, http.HandlerFunc(handlers.ListUserLogins))).Methods("GET")
+	admin.Handle("/users/{id}/credentials", permitted(permissions.UsersCredentials, sudo(handlers.RevokeUserCredentials(cfg)))).Methods("DELETE")
+	admin.Handle("/name-filter", permitted(permissions.NamesManage, http.HandlerFunc(handlers.ListNameFilterTerms))).Methods("GET")
+	admin.Handle("/name-filter", permitted(permissions.NamesManage, http.HandlerFunc(handlers.AddNameFilterTerm))).Methods("POST")
+	admin.Handle("/name-filter/recheck", permitted(permissions.NamesManage, http.HandlerFunc(handlers.RecheckDisplayNames))).Methods("POST")
+	admin.Handle("/name-filter/{id}", permitted(permissions.NamesManage, http.HandlerFunc(handlers.RemoveNameFilterTerm))).Methods("DELETE")
+	admin.Handle("/system-messages", permitted(permissions.MessagesManage, http.HandlerFunc(handlers.ListSystemMessages))).Methods("GET")
+	admin.Handle("/system-messages", permitted(permissions.MessagesManage, http.HandlerFunc(handlers.CreateSystemMessage))).Methods("POST")
+	admin.Handle("/system-messages/{id}", permitted(permissions.MessagesManage, http.HandlerFunc(handlers.UpdateSystemMessage))).Methods("PUT")
+	admin.Handle("/system-messages/{id}", permitted(permissions.MessagesManage, http.HandlerFunc(handlers.DeleteSystemMessage))).Methods("DELETE")
+	admin.Handle("/database/cluster", permitted(permissions.SystemRead, http.HandlerFunc(handlers.DatabaseCluster))).Methods("GET")
+	admin.Handle("/database/cutover", permitted(permissions.DatabaseCutover, sudo(http.HandlerFunc(handlers.DatabaseCutover)))).Methods("POST")
+	admin.Handle("/waitlist", permitted(permissions.UsersRead, handlers.ListWaitlist(cfg))).Methods("GET")
+	admin.Handle("/waitlist/activate", permitted(permissions.UsersManage, handlers.ActivateWaitlist(cfg))).Methods("POST")
+	admin.Handle("/waitlist/{id}/activate", permitted(permissions.UsersManage, handlers.ActivateWaitlistedUser(cfg))).Methods("POST")
+	admin.Handle("/invitations", permitted(permissions.InvitationsCreate, sudo(handlers.CreateInvitation(cfg)))).Methods("POST")
+	admin.Handle("/invitations", permitted(permissions.InvitationsManage, http.HandlerFunc(handlers.ListInvitations))).Methods("GET")
+	admin.Handle("/invitations/{id}", permitted(permissions.InvitationsManage, http.HandlerFunc(handlers.RevokeInvitation))).Methods("DELETE")
+	if cfg.EventSourcingEnabled {
+		admin.Handle("/users/{id}/history", permitted(permissions.UsersRead, http.HandlerFunc(handlers.UserHistory))).Methods("GET")
+		admin.Handle("/users/{id}/rebuild", permitted(permissions.UsersManage, sudo(http.HandlerFunc(handlers.RebuildUser)))).Methods("POST")
+		admin.Handle("/users/rebuild", permitted(permissions.UsersManage, sudo(http.HandlerFunc(handlers.RebuildUsers)))).Methods("POST")
+	}
+	// Registered last and limited to IDs, so /users/events and the like match first
+	admin.Handle("/users/{id:[0-9a-f]{24}}", permitted(permissions.UsersRead, handlers.GetUser(cfg))).Methods("GET")
+
+	// Fault injection for resilience testing, rules managed by admins
+	if cfg.ChaosEnabled {
+		log.Println("WARNING: chaos fault injection is enabled")
+		r.Use(chaos.Middleware)
+
+		debug := routes.Group(r, cfg, routes.Admin, "/debug")
+		debug.Handle("/chaos", permitted(permissions.ChaosManage, http.HandlerFunc(chaos.Handler))).Methods("GET", "PUT", "DELETE")
+	}
+
+	// Developer portal
+	public.HandleFunc("/developer", handlers.DeveloperPortal).Methods("GET")
+	public.HandleFunc("/developer/scopes", handlers.ListAPIScopes).Methods("GET")
+
+	// Public status page and badge
+	public.Handle("/status", health.RateLimit(http.HandlerFunc(handlers.PublicStatus))).Methods("GET")
+	public.Handle("/status/badge.svg", health.RateLimit(http.HandlerFunc(handlers.StatusBadge))).Methods("GET")
+
+	// Maintenance and incident banners for frontends
+	public.HandleFunc("/system/messages", handlers.ActiveSystemMessages).Methods("GET")
+
+	// Client analytics events
+	if analytics.Enabled() {
+		public.HandleFunc("/events", handlers.IngestEvents(cfg)).Methods("POST")
+	}
+
+	// Metrics for scraping
+	internal.HandleFunc("/metrics", handlers.Metrics(cfg)).Methods("GET")
 
 	// Swagger route
-	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+	public.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+
+	// Report deprecations naming routes that were never registered
+	deprecation.Check(r)
 
 	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	var handler http.Handler = correlation.Middleware(middleware.Recover(database.Track(r)))
+	if cfg.RecordRequestsDir != "" {
+		rec, err := recorder.New(cfg.RecordRequestsDir, cfg.RecordMaxBody)
+		if err != nil {
+			log.Fatal("Failed to start request recorder:", err)
+		}
+		log.Printf("Recording sanitized requests to %s", cfg.RecordRequestsDir)
+		handler = rec.Middleware(handler)
+	}
+
+	// Event streams get their own pool so idle listeners cannot starve the service
+	serviceLimit := middleware.ConcurrencyLimit("service", cfg.MaxConcurrentRequests, cfg.ConcurrencyQueueTimeout)
+	streamLimit := middleware.ConcurrencyLimit("events", cfg.GroupLimit("events", 1024), cfg.ConcurrencyQueueTimeout)
+	log.Fatal(http.ListenAndServe(":8080", middleware.EventStreams(streamLimit, serviceLimit)(handler)))
 }
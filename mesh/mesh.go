@@ -0,0 +1,227 @@
+// Package mesh accepts caller identity established by a service mesh such as
+// Istio or Linkerd, as an alternative to verifying bearer tokens in-app. The
+// sidecar proxy authenticates the caller and forwards the result in a header:
+//
+//   - "jwt": the payload of a JWT the mesh verified (Istio's
+//     outputPayloadToHeader), naming the user in userID or sub
+//   - "xfcc": the client certificate of an mTLS caller in
+//     X-Forwarded-Client-Cert, whose SPIFFE ID maps to a service account user
+//
+// Anyone can send these headers, so they are only believed from peers inside
+// TRUSTED_PROXY, the addresses the sidecar connects from.
+package mesh
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/config"
+)
+
+// Identity modes
+const (
+	ModeJWT  = "jwt"
+	ModeXFCC = "xfcc"
+)
+
+// XFCCHeader carries the client certificate details forwarded by Envoy
+const XFCCHeader = "X-Forwarded-Client-Cert"
+
+var (
+	// ErrInvalidIdentity is returned for identity headers that cannot be parsed
+	ErrInvalidIdentity = errors.New("invalid mesh identity")
+	// ErrUnknownIdentity is returned for mesh identities mapped to no user
+	ErrUnknownIdentity = errors.New("unknown mesh identity")
+)
+
+var (
+	mode     string
+	header   string
+	issuers  map[string]bool
+	accounts map[string]string
+	trusted  []*net.IPNet
+)
+
+// Init validates the mesh configuration. An unknown mode, an invalid CIDR in
+// TRUSTED_PROXY, or a mode without trusted proxies stops the server, since
+// any of them would either disable the feature or let clients forge identity.
+func Init(cfg *config.Config) {
+	mode = cfg.MeshIdentity
+	if mode == "" {
+		return
+	}
+	if mode != ModeJWT && mode != ModeXFCC {
+		log.Fatalf("mesh: unknown MESH_IDENTITY %q, want %q or %q", mode, ModeJWT, ModeXFCC)
+	}
+
+	trusted = nil
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("mesh: invalid CIDR %q in TRUSTED_PROXY: %v", cidr, err)
+		}
+		trusted = append(trusted, network)
+	}
+	if len(trusted) == 0 {
+		log.Fatal("mesh: MESH_IDENTITY requires TRUSTED_PROXY")
+	}
+
+	header = cfg.MeshJWTHeader
+	if mode == ModeXFCC {
+		header = XFCCHeader
+	}
+	issuers = make(map[string]bool)
+	for _, iss := range cfg.MeshJWTIssuers {
+		issuers[iss] = true
+	}
+	accounts = cfg.MeshServiceAccounts
+	log.Printf("mesh: accepting %s identity from %s", mode, strings.Join(cfg.TrustedProxies, ", "))
+}
+
+// Enabled reports whether mesh identity is accepted
+func Enabled() bool {
+	return mode != ""
+}
+
+// Mode returns the configured identity mode
+func Mode() string {
+	return mode
+}
+
+// Present reports whether the request carries a mesh identity header
+func Present(r *http.Request) bool {
+	return Enabled() && r.Header.Get(header) != ""
+}
+
+// Trusted reports whether the request comes straight from a trusted proxy
+func Trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity is a caller authenticated by the mesh
+type Identity struct {
+	// UserID is the user the caller acts as
+	UserID string
+	// Subject is what the mesh authenticated: the token issuer and subject,
+	// or the SPIFFE ID
+	Subject string
+	// TokenID and ExpiresAt come from a forwarded JWT, when it has them
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// Resolve reads the identity header of a request from a trusted proxy
+func Resolve(r *http.Request) (*Identity, error) {
+	value := r.Header.Get(header)
+	if mode == ModeXFCC {
+		return fromXFCC(value)
+	}
+	return fromPayload(value)
+}
+
+// fromPayload decodes a verified JWT payload. The signature was checked by
+// the mesh; expiry and issuer are checked again here.
+func fromPayload(value string) (*Identity, error) {
+	// Meshes differ in alphabet and padding
+	value = strings.TrimRight(value, "=")
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		if raw, err = base64.RawStdEncoding.DecodeString(value); err != nil {
+			return nil, ErrInvalidIdentity
+		}
+	}
+	var claims struct {
+		UserID string  `json:"userID"`
+		Sub    string  `json:"sub"`
+		Iss    string  `json:"iss"`
+		JTI    string  `json:"jti"`
+		Exp    float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrInvalidIdentity
+	}
+	if len(issuers) > 0 && !issuers[claims.Iss] {
+		return nil, fmt.Errorf("%w: issuer %q is not accepted", ErrUnknownIdentity, claims.Iss)
+	}
+
+	id := &Identity{UserID: claims.UserID, TokenID: claims.JTI}
+	if id.UserID == "" {
+		id.UserID = claims.Sub
+	}
+	if id.UserID == "" {
+		return nil, ErrInvalidIdentity
+	}
+	id.Subject = claims.Iss + "#" + id.UserID
+	if claims.Exp > 0 {
+		id.ExpiresAt = time.Unix(int64(claims.Exp), 0)
+		if !clock.Now().Before(id.ExpiresAt) {
+			return nil, fmt.Errorf("%w: token expired", ErrInvalidIdentity)
+		}
+	}
+	return id, nil
+}
+
+// fromXFCC maps the SPIFFE ID of the client certificate to its service
+// account. Every proxy on the way appends an element; the last one was added
+// by the sidecar next to this service.
+func fromXFCC(value string) (*Identity, error) {
+	elements := splitQuoted(value, ',')
+	for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "URI") {
+			continue
+		}
+		uri := strings.Trim(strings.TrimSpace(val), `"`)
+		if !strings.HasPrefix(uri, "spiffe://") {
+			continue
+		}
+		userID, ok := accounts[uri]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownIdentity, uri)
+		}
+		return &Identity{UserID: userID, Subject: uri}, nil
+	}
+	return nil, ErrInvalidIdentity
+}
+
+// splitQuoted splits s at sep outside double quotes
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -41,14 +41,14 @@ func main() {
 	// Create router
 	r := mux.NewRouter()
 
-	// Apply authentication and admin middleware to all routes
-	r.Use(middleware.JWTAuthMiddleware(cfg))
-	r.Use(middleware.AdminOnlyMiddleware)
+	// Admin routes; health checks and docs stay public
+	api := r.NewRoute().Subrouter()
+	api.Use(middleware.JWTAuthMiddleware(cfg))
+	api.Use(middleware.AdminOnlyMiddleware)
 
-	// Admin routes
-	r.HandleFunc("/users", handlers.ListUsers).Methods("GET")
-	r.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
-	r.HandleFunc("/users/{id}/role", handlers.UpdateUserRole).Methods("PUT")
+	api.HandleFunc("/users", handlers.ListUsers).Methods("GET")
+	api.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	api.HandleFunc("/users/{id}/role", handlers.UpdateUserRole).Methods("PUT")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
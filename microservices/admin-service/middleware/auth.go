@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/jwks"
+)
+
+// JWTAuthMiddleware validates JWT tokens for protected routes, against the
+// auth service's published keys when JWKS_URL is set
+func JWTAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	var verifier *jwks.Verifier
+	if cfg.JWKSURL != "" {
+		verifier = jwks.NewVerifier(cfg.JWKSURL)
+	}
+	keyfunc := jwks.Keyfunc(verifier, cfg.JWTSecret)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			token, err := jwt.Parse(tokenString, keyfunc)
+
+			if err != nil || !token.Valid {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			// Extract claims and add to context
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				ctx := context.WithValue(r.Context(), "userID", claims["userID"])
+				ctx = context.WithValue(ctx, "email", claims["email"])
+				ctx = context.WithValue(ctx, "role", claims["role"])
+				ctx = context.WithValue(ctx, "encryptionKey", cfg.EncryptionKey)
+				r = r.WithContext(ctx)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
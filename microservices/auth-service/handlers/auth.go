@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -28,6 +27,8 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email" example:"user@example.com"`
 	Password string `json:"password" example:"password123"`
+	// RememberMe asks for a longer-lived session
+	RememberMe bool `json:"remember_me,omitempty" example:"true"`
 }
 
 // RegisterResponse represents the response for user registration
@@ -37,8 +38,10 @@ type RegisterResponse struct {
 
 // LoginResponse represents the response for user login
 type LoginResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	Role  string `json:"role" example:"user"`
+	Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+	Role         string `json:"role" example:"user"`
 }
 
 // AdminRegisterRequest represents the request payload for admin user registration
@@ -55,8 +58,10 @@ type AdminLoginRequest struct {
 
 // AdminLoginResponse represents the response for admin login
 type AdminLoginResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	Role  string `json:"role" example:"admin"`
+	Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+	Role         string `json:"role" example:"admin"`
 }
 
 // Register handles user registration
@@ -180,32 +185,15 @@ func Login(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Decrypt email for JWT
-		decryptedEmail, err := utils.Decrypt(user.Email, cfg.EncryptionKey)
-		if err != nil {
-			http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
-			return
-		}
-
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"userID": user.ID.Hex(),
-			"email":  decryptedEmail,
-			"role":   user.Role,
-			"exp":    time.Now().Add(time.Hour * 24).Unix(),
-		})
-
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		// Generate the access and refresh tokens
+		response, err := issueTokens(ctx, cfg, user, "", req.RememberMe)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"token": tokenString,
-			"role":  user.Role,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
@@ -331,31 +319,14 @@ func AdminLogin(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Decrypt email for JWT
-		decryptedEmail, err := utils.Decrypt(user.Email, cfg.EncryptionKey)
-		if err != nil {
-			http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
-			return
-		}
-
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"userID": user.ID.Hex(),
-			"email":  decryptedEmail,
-			"role":   user.Role,
-			"exp":    time.Now().Add(time.Hour * 24).Unix(),
-		})
-
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		// Generate the access and refresh tokens
+		response, err := issueTokens(ctx, cfg, user, "", false)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"token": tokenString,
-			"role":  user.Role,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/microservices/auth-service/oauth"
+	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/database"
+	"golang-backend/microservices/shared/models"
+	"golang-backend/microservices/shared/utils"
+)
+
+// OAuthProvidersResponse lists the configured social sign-in providers
+type OAuthProvidersResponse struct {
+	Providers []string `json:"providers" example:"github,google"`
+}
+
+// OAuthProviders lists the social sign-in providers
+// @Summary List social sign-in providers
+// @Description The identity providers users can sign in with at GET /auth/{provider}
+// @Tags auth
+// @Produce json
+// @Success 200 {object} OAuthProvidersResponse
+// @Router /auth/providers [get]
+func OAuthProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OAuthProvidersResponse{Providers: oauth.Providers()})
+}
+
+// OAuthStart handles the start of a social sign-in
+// @Summary Start social sign-in
+// @Description Redirect to the identity provider's sign-in page. Users who have no account yet are registered on the callback
+// @Tags auth
+// @Param provider path string true "Identity provider" example(github)
+// @Success 302 {string} string "Redirect to the provider"
+// @Failure 404 {string} string "Unknown identity provider"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/{provider} [get]
+func OAuthStart(w http.ResponseWriter, r *http.Request) {
+	authURL, err := oauth.Begin(r.Context(), mux.Vars(r)["provider"])
+	if errors.Is(err, oauth.ErrUnknownProvider) {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthCallback handles the provider's redirect back after a social sign-in
+// @Summary Complete social sign-in
+// @Description Exchange the provider's authorization code and sign in the linked user. An account with the provider's verified email address is linked on first use; otherwise a new account is registered. Returns the same tokens as POST /login
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Identity provider" example(github)
+// @Param state query string true "State from the sign-in redirect"
+// @Param code query string true "Authorization code"
+// @Success 200 {object} LoginResponse
+// @Failure 401 {string} string "Sign-in failed"
+// @Failure 404 {string} string "Unknown identity provider"
+// @Failure 409 {string} string "Email address not verified by the provider"
+// @Failure 502 {string} string "Identity provider unavailable"
+// @Router /auth/{provider}/callback [get]
+func OAuthCallback(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("error") != "" || query.Get("state") == "" || query.Get("code") == "" {
+			http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		provider := mux.Vars(r)["provider"]
+		identity, err := oauth.Complete(ctx, provider, query.Get("state"), query.Get("code"))
+		switch {
+		case errors.Is(err, oauth.ErrUnknownProvider):
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		case errors.Is(err, oauth.ErrInvalidState), errors.Is(err, oauth.ErrExchange):
+			http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Printf("oauth: %s sign-in failed: %v", provider, err)
+			http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		user, status, msg := identityUser(cfg, identity)
+		if status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+
+		// Generate the access and refresh tokens
+		response, err := issueTokens(context.Background(), cfg, *user, "", false)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// identityUser finds the user an identity is linked to. Failing that, an
+// account with the same verified address is linked, or a new one registered.
+func identityUser(cfg *config.Config, identity *oauth.Identity) (*models.User, int, string) {
+	collection := database.GetCollection("users")
+	ctx := context.Background()
+
+	var user models.User
+	linked := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}}}
+	err := collection.FindOne(ctx, linked).Decode(&user)
+	if err == nil {
+		return &user, http.StatusOK, ""
+	} else if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+
+	// An unverified address could belong to someone else
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, http.StatusConflict, "Email address not verified by the provider"
+	}
+	email := identity.Email
+	now := time.Now()
+	link := models.LinkedIdentity{Provider: identity.Provider, Subject: identity.Subject, LinkedAt: now}
+
+	err = collection.FindOne(ctx, bson.M{"email_hash": email}).Decode(&user)
+	if err == nil {
+		update := bson.M{"$push": bson.M{"identities": link}, "$set": bson.M{"updated_at": now}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+			return nil, http.StatusInternalServerError, "Failed to link account"
+		}
+		return &user, http.StatusOK, ""
+	} else if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Database error"
+	}
+
+	// New accounts have no password until the user sets one through a
+	// password reset
+	encryptedEmail, err := utils.Encrypt(email, cfg.EncryptionKey)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to encrypt data"
+	}
+	user = models.User{
+		ID:         primitive.NewObjectID(),
+		EmailHash:  email,
+		Email:      encryptedEmail,
+		Role:       "user",
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Identities: []models.LinkedIdentity{link},
+	}
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		return nil, http.StatusInternalServerError, "Failed to create user"
+	}
+	return &user, http.StatusOK, ""
+}
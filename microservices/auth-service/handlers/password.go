@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/database"
+	"golang-backend/microservices/shared/mailer"
+	"golang-backend/microservices/shared/models"
+	"golang-backend/microservices/shared/utils"
+)
+
+// ForgotPasswordRequest represents the request for a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+}
+
+// ResetPasswordRequest represents the request for setting a new password
+type ResetPasswordRequest struct {
+	Token       string `json:"token" example:"b3Jx8kQ2mV9pL1sT7wZ4nC6eH0jU5yA3dF8gR2iO1qE"`
+	NewPassword string `json:"new_password" example:"new-password123"`
+}
+
+// passwordReset is a pending reset token, stored by the hash of the token.
+// The monolith keeps its tokens in the same collection and shape.
+type passwordReset struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// CreatePasswordResetIndexes creates the indexes of pending reset tokens,
+// including the TTL index that drops expired ones
+func CreatePasswordResetIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"user_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	if _, err := database.GetCollection("password_resets").Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Failed to create password reset indexes: %v", err)
+	}
+}
+
+// ForgotPassword handles password reset requests
+// @Summary Request a password reset
+// @Description Email a single-use link for setting a new password. The response is the same whether or not the address has an account
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account email"
+// @Success 202 {object} RegisterResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Router /password/forgot [post]
+func ForgotPassword(cfg *config.Config, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		// The lookup runs after the response, which is the same for unknown
+		// addresses
+		go sendPasswordReset(cfg, mail, req.Email)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "If the address has an account, a reset link is on its way"})
+	}
+}
+
+// sendPasswordReset emails a reset link to the account registered with an
+// email, if there is one. A token younger than PASSWORD_RESET_COOLDOWN is
+// not replaced, so the form cannot be used to flood an inbox.
+func sendPasswordReset(cfg *config.Config, mail mailer.Mailer, email string) {
+	ctx := context.Background()
+	var user models.User
+	err := database.GetCollection("users").FindOne(ctx, bson.M{"email_hash": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return
+	} else if err != nil {
+		log.Printf("Failed to look up password reset recipient: %v", err)
+		return
+	}
+
+	resets := database.GetCollection("password_resets")
+	now := time.Now()
+	recent := bson.M{"user_id": user.ID, "created_at": bson.M{"$gt": now.Add(-cfg.PasswordResetCooldown)}}
+	if n, err := resets.CountDocuments(ctx, recent); err != nil {
+		log.Printf("Failed to check password reset cooldown for user %s: %v", user.ID.Hex(), err)
+		return
+	} else if n > 0 {
+		return
+	}
+
+	// A new token voids the earlier ones
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		log.Printf("Failed to generate password reset token: %v", err)
+		return
+	}
+	if _, err := resets.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		log.Printf("Failed to void password reset tokens of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	reset := passwordReset{ID: utils.HashToken(token), UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(cfg.PasswordResetTTL)}
+	if _, err := resets.InsertOne(ctx, reset); err != nil {
+		log.Printf("Failed to store password reset token for user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	link := cfg.PasswordResetURL
+	if link == "" {
+		link = cfg.AppURL + "/reset-password"
+	}
+	body := fmt.Sprintf("Someone asked to reset the password of your account. To choose a new one, open this link within %d minutes:\n\n%s?token=%s\n\nThe link works once. Setting a new password signs you out everywhere.\n\nIf you did not ask for this, you can ignore this email; your password stays the same.\n",
+		int(cfg.PasswordResetTTL.Minutes()), link, url.QueryEscape(token))
+	if err := mail.Send(email, "Reset your password", body); err != nil {
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// ResetPassword handles setting a new password with a reset token
+// @Summary Reset password
+// @Description Set a new password with the token from a reset email. The token works once; every refresh token of the account is revoked
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} RegisterResponse
+// @Failure 400 {string} string "Invalid or expired reset token"
+// @Failure 500 {string} string "Internal server error"
+// @Router /password/reset [post]
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.NewPassword == "" {
+		http.Error(w, "New password is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Consume the token; only one request can
+	var reset passwordReset
+	filter := bson.M{"_id": utils.HashToken(req.Token), "expires_at": bson.M{"$gt": now}}
+	err := database.GetCollection("password_resets").FindOneAndDelete(ctx, filter).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	update := bson.M{"$set": bson.M{"password": string(hashedPassword), "updated_at": now}}
+	result, err := database.GetCollection("users").UpdateOne(ctx, bson.M{"_id": reset.UserID}, update)
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		// The user was deleted since the email was sent
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+
+	// Whoever knew the old password cannot refresh their session
+	_, err = database.GetCollection("refresh_tokens").UpdateMany(ctx, bson.M{"user_id": reset.UserID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": now}})
+	if err != nil {
+		log.Printf("Failed to revoke refresh tokens of user %s after password reset: %v", reset.UserID.Hex(), err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/database"
+	"golang-backend/microservices/shared/jwks"
+	"golang-backend/microservices/shared/models"
+	"golang-backend/microservices/shared/utils"
+)
+
+// RefreshRequest represents the request payload for refreshing a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"9vQx2mR7cK1pL4sT8wZ0bN3eH6jU5yA2dF7gV1iO4qE"`
+}
+
+// refreshToken is a stored refresh token. Every rotation issues a new token
+// in the same family; a family starts at login and keeps its remember me
+// policy.
+type refreshToken struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	FamilyID  string             `bson:"family_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Remember  bool               `bson:"remember,omitempty"`
+	IssuedAt  time.Time          `bson:"issued_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty"`
+}
+
+// signer signs access tokens with a private key; nil signs them with
+// JWT_SECRET
+var signer *jwks.Signer
+
+// UseSigner signs access tokens from now on with a private key
+func UseSigner(s *jwks.Signer) {
+	signer = s
+}
+
+// issueTokens signs an access token for a user and stores a refresh token in
+// the given family, or in a new one when family is empty. Remembered logins
+// get the longer REMEMBER_ME lifetimes.
+func issueTokens(ctx context.Context, cfg *config.Config, user models.User, family string, remember bool) (*LoginResponse, error) {
+	accessTTL, refreshTTL := cfg.AccessTokenTTL, cfg.RefreshTokenTTL
+	if remember && cfg.RememberMeRefreshTokenTTL > 0 {
+		accessTTL, refreshTTL = cfg.RememberMeAccessTokenTTL, cfg.RememberMeRefreshTokenTTL
+	} else {
+		remember = false
+	}
+
+	decryptedEmail, err := utils.Decrypt(user.Email, cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{
+		"userID": user.ID.Hex(),
+		"email":  decryptedEmail,
+		"role":   user.Role,
+		"exp":    time.Now().Add(accessTTL).Unix(),
+	}
+	var tokenString string
+	if signer != nil {
+		tokenString, err = signer.Sign(claims)
+	} else {
+		tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecret))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if family == "" {
+		family = primitive.NewObjectID().Hex()
+	}
+	now := time.Now()
+	_, err = database.GetCollection("refresh_tokens").InsertOne(ctx, refreshToken{
+		ID:        primitive.NewObjectID(),
+		TokenHash: utils.HashToken(raw),
+		FamilyID:  family,
+		UserID:    user.ID,
+		Remember:  remember,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:        tokenString,
+		RefreshToken: raw,
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		Role:         user.Role,
+	}, nil
+}
+
+// RefreshToken handles session refresh
+// @Summary Refresh session
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token works once; presenting a used one again revokes every refresh token descended from the same login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh token"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid refresh token"
+// @Failure 500 {string} string "Internal server error"
+// @Router /token/refresh [post]
+func RefreshToken(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		collection := database.GetCollection("refresh_tokens")
+		ctx := context.Background()
+		now := time.Now()
+		hash := utils.HashToken(req.RefreshToken)
+
+		// Mark the token used; only one request can
+		var stored refreshToken
+		filter := bson.M{"token_hash": hash, "used_at": nil, "revoked_at": nil, "expires_at": bson.M{"$gt": now}}
+		err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			// A token used before was stolen, by whoever presented it first or
+			// now: revoke its whole family
+			err = collection.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&stored)
+			if err == nil && stored.UsedAt != nil && stored.RevokedAt == nil {
+				log.Printf("Refresh token reused for user %s, revoking family %s", stored.UserID.Hex(), stored.FamilyID)
+				_, err = collection.UpdateMany(ctx, bson.M{"family_id": stored.FamilyID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": now}})
+				if err != nil {
+					http.Error(w, "Database error", http.StatusInternalServerError)
+					return
+				}
+			}
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// The user may have been deleted since login
+		var user models.User
+		err = database.GetCollection("users").FindOne(ctx, bson.M{"_id": stored.UserID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		response, err := issueTokens(ctx, cfg, user, stored.FamilyID, stored.Remember)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
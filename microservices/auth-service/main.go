@@ -3,13 +3,18 @@ package main
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "golang-backend/microservices/auth-service/docs"
 	"golang-backend/microservices/shared/config"
 	"golang-backend/microservices/shared/database"
+	"golang-backend/microservices/shared/jwks"
+	"golang-backend/microservices/shared/mailer"
+	"golang-backend/microservices/shared/ratelimit"
 	"golang-backend/microservices/auth-service/handlers"
+	"golang-backend/microservices/auth-service/oauth"
 )
 
 // @title Auth Service API
@@ -36,15 +41,47 @@ func main() {
 
 	// Connect to database
 	database.Connect(cfg.MongoURI)
+	handlers.CreatePasswordResetIndexes()
+
+	// Social sign-in providers with credentials configured
+	oauth.Init(cfg)
 
 	// Create router
 	r := mux.NewRouter()
 
+	// With a private key, tokens are signed asymmetrically and the public
+	// key is published for the other services
+	if cfg.JWTPrivateKeyFile != "" {
+		signer, err := jwks.LoadSigner(cfg.JWTPrivateKeyFile, cfg.JWTRetiredKeyFiles...)
+		if err != nil {
+			log.Fatalf("Failed to load JWT signing key: %v", err)
+		}
+		handlers.UseSigner(signer)
+		r.HandleFunc(jwks.Path, signer.Handler).Methods("GET")
+	}
+
+	// Sign-in and registration attempts are throttled per client IP
+	limiter := ratelimit.New(cfg.LoginRatePerMinute, cfg.LoginRateBurst, cfg.LoginRateRedisAddr)
+
 	// Auth routes
-	r.HandleFunc("/register", handlers.Register(cfg)).Methods("POST")
-	r.HandleFunc("/login", handlers.Login(cfg)).Methods("POST")
-	r.HandleFunc("/admin/register", handlers.AdminRegister(cfg)).Methods("POST")
-	r.HandleFunc("/admin/login", handlers.AdminLogin(cfg)).Methods("POST")
+	r.HandleFunc("/register", limiter.Wrap("register", handlers.Register(cfg))).Methods("POST")
+	r.HandleFunc("/login", limiter.Wrap("login", handlers.Login(cfg))).Methods("POST")
+	r.HandleFunc("/token/refresh", handlers.RefreshToken(cfg)).Methods("POST")
+	r.HandleFunc("/admin/register", limiter.Wrap("register", handlers.AdminRegister(cfg))).Methods("POST")
+	r.HandleFunc("/admin/login", limiter.Wrap("login", handlers.AdminLogin(cfg))).Methods("POST")
+
+	// Forgotten passwords, reset through a single-use emailed token
+	r.HandleFunc("/password/forgot", limiter.Wrap("password_reset", handlers.ForgotPassword(cfg, mailer.New(cfg)))).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPassword).Methods("POST")
+
+	// Social sign-in
+	if oauth.Enabled() {
+		// Only configured providers match, leaving the rest of /auth/ free
+		provider := "/auth/{provider:" + strings.Join(oauth.Providers(), "|") + "}"
+		r.HandleFunc("/auth/providers", handlers.OAuthProviders).Methods("GET")
+		r.HandleFunc(provider, handlers.OAuthStart).Methods("GET")
+		r.HandleFunc(provider+"/callback", limiter.Wrap("login", handlers.OAuthCallback(cfg))).Methods("GET")
+	}
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,229 @@
+// Package oauth signs users in with third-party identity providers through
+// the OAuth 2.0 authorization code flow with PKCE, as the monolith's oauth
+// package does. The state of each sign-in is kept in the database between
+// the redirect to the provider and the callback, so any replica of the auth
+// service can complete it.
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/database"
+	"golang-backend/microservices/shared/utils"
+)
+
+// states holds pending sign-ins by state hash
+const states = "oauth_states"
+
+// stateTTL bounds how long a user may take at the provider
+const stateTTL = 10 * time.Minute
+
+var (
+	// ErrUnknownProvider is returned for providers that are not configured
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrInvalidState is returned for unknown, used and expired states, and
+	// for states begun with another provider
+	ErrInvalidState = errors.New("invalid or expired OAuth state")
+	// ErrExchange is returned when the provider refuses the authorization
+	// code, e.g. because it was used or has expired
+	ErrExchange = errors.New("authorization code rejected")
+)
+
+// Identity is the account a provider vouched for
+type Identity struct {
+	Provider string
+	// Subject is the provider's stable ID for the account
+	Subject string
+	Email   string
+	// EmailVerified is whether the provider checked the user owns Email
+	EmailVerified bool
+}
+
+// Provider is an OAuth 2.0 identity provider
+type Provider interface {
+	// Name is the provider's path segment, e.g. "google" or "github"
+	Name() string
+	// AuthURL is where to send the user to sign in, carrying state and the
+	// PKCE challenge for verifier
+	AuthURL(state, verifier string) string
+	// Exchange trades an authorization code for the user's identity
+	Exchange(ctx context.Context, code, verifier string) (*Identity, error)
+}
+
+// pending is a sign-in waiting for its callback
+type pending struct {
+	ID        string    `bson:"_id"`
+	Provider  string    `bson:"provider"`
+	Verifier  string    `bson:"verifier"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+var providers = make(map[string]Provider)
+
+// Init registers the providers that have credentials configured and creates
+// the indexes for pending sign-ins and linked identities
+func Init(cfg *config.Config) {
+	if cfg.GoogleClientID != "" {
+		Register(newGoogle(cfg))
+	}
+	if cfg.GitHubClientID != "" {
+		Register(newGitHub(cfg))
+	}
+	if len(providers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.GetCollection(states).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("oauth: failed to create state TTL index: %v", err)
+	}
+	identities := mongo.IndexModel{Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}}}
+	if _, err := database.GetCollection("users").Indexes().CreateOne(ctx, identities); err != nil {
+		log.Printf("oauth: failed to create identity index: %v", err)
+	}
+}
+
+// Register adds or replaces a provider
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+// Providers returns the names of the configured providers, sorted
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled reports whether any provider is configured
+func Enabled() bool {
+	return len(providers) > 0
+}
+
+// Begin starts a sign-in with a provider and returns the URL to send the
+// user to
+func Begin(ctx context.Context, provider string) (string, error) {
+	p, ok := providers[provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	state, err := utils.RandomToken(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	doc := pending{ID: utils.HashToken(state), Provider: provider, Verifier: verifier, ExpiresAt: time.Now().Add(stateTTL)}
+	if _, err := database.GetCollection(states).InsertOne(ctx, doc); err != nil {
+		return "", err
+	}
+	return p.AuthURL(state, verifier), nil
+}
+
+// Complete finishes a sign-in from the provider's callback. Each state is
+// accepted once.
+func Complete(ctx context.Context, provider, state, code string) (*Identity, error) {
+	p, ok := providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	filter := bson.M{"_id": utils.HashToken(state), "provider": provider, "expires_at": bson.M{"$gt": time.Now()}}
+	var doc pending
+	err := database.GetCollection(states).FindOneAndDelete(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := p.Exchange(ctx, code, doc.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	identity.Provider = provider
+	return identity, nil
+}
+
+// challengeS256 is the PKCE code challenge for a verifier
+func challengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// tokenResponse is the token endpoint's answer
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// exchangeCode redeems an authorization code at a provider's token endpoint
+// and returns the access token
+func exchangeCode(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("%w: %s %s", ErrExchange, token.Error, token.Description)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	return token.AccessToken, nil
+}
+
+// getJSON fetches an API resource with an access token
+func getJSON(ctx context.Context, client *http.Client, resourceURL, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", resourceURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
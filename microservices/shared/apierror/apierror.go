@@ -0,0 +1,211 @@
+// Package apierror holds the errors services return to clients, so a failure
+// means the same over HTTP and gRPC. An Error carries a gRPC status code, a
+// message safe to show, a machine-readable reason and the fields a request
+// got wrong. gRPC servers return it as is; the status it converts to carries
+// the reason and fields as ErrorInfo and BadRequest details, which FromStatus
+// reads back. Gateways answer HTTP clients with WriteHTTP, which maps the code
+// to an HTTP status.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Domain names this backend in ErrorInfo details
+const Domain = "golang-backend"
+
+// Error is a failure to report to a client
+type Error struct {
+	Code    codes.Code
+	Message string
+	// Reason identifies the failure for clients, like "REFRESH_TOKEN_REUSED"
+	Reason string
+	// Fields describes invalid request fields by name
+	Fields map[string]string
+	// Err is the cause, logged but never sent
+	Err error
+}
+
+// New creates an Error
+func New(code codes.Code, reason, message string) *Error {
+	return &Error{Code: code, Reason: reason, Message: message}
+}
+
+// InvalidArgument reports a malformed request
+func InvalidArgument(reason, message string) *Error {
+	return New(codes.InvalidArgument, reason, message)
+}
+
+// Unauthenticated reports missing or invalid credentials
+func Unauthenticated(reason, message string) *Error {
+	return New(codes.Unauthenticated, reason, message)
+}
+
+// PermissionDenied reports a caller not allowed to do something
+func PermissionDenied(reason, message string) *Error {
+	return New(codes.PermissionDenied, reason, message)
+}
+
+// NotFound reports a missing resource
+func NotFound(reason, message string) *Error {
+	return New(codes.NotFound, reason, message)
+}
+
+// AlreadyExists reports a resource that conflicts with an existing one
+func AlreadyExists(reason, message string) *Error {
+	return New(codes.AlreadyExists, reason, message)
+}
+
+// ResourceExhausted reports a rate limit or quota hit
+func ResourceExhausted(reason, message string) *Error {
+	return New(codes.ResourceExhausted, reason, message)
+}
+
+// Unavailable reports a dependency that is down, like the database
+func Unavailable(message string, err error) *Error {
+	return &Error{Code: codes.Unavailable, Message: message, Err: err}
+}
+
+// Internal reports an unexpected failure; err is kept from the client
+func Internal(message string, err error) *Error {
+	return &Error{Code: codes.Internal, Message: message, Err: err}
+}
+
+// WithField records why a request field is invalid
+func (e *Error) WithField(field, description string) *Error {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[field] = description
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// From returns the Error in err's chain, turning gRPC statuses from other
+// services back into Errors and anything else into an internal error
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if s, ok := status.FromError(err); ok {
+		return FromStatus(s)
+	}
+	return Internal("Internal server error", err)
+}
+
+// GRPCStatus converts the error to a gRPC status, which gRPC servers send
+// for errors returned by handlers
+func (e *Error) GRPCStatus() *status.Status {
+	s := status.New(e.Code, e.Message)
+	var details []protoadapt.MessageV1
+	if e.Reason != "" {
+		details = append(details, &errdetails.ErrorInfo{Reason: e.Reason, Domain: Domain})
+	}
+	if len(e.Fields) > 0 {
+		fields := make([]string, 0, len(e.Fields))
+		for field := range e.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(fields))
+		for i, field := range fields {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: field, Description: e.Fields[field]}
+		}
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+	if len(details) == 0 {
+		return s
+	}
+	withDetails, err := s.WithDetails(details...)
+	if err != nil {
+		log.Printf("apierror: failed to attach error details: %v", err)
+		return s
+	}
+	return withDetails
+}
+
+// FromStatus reads an Error back from a gRPC status
+func FromStatus(s *status.Status) *Error {
+	e := &Error{Code: s.Code(), Message: s.Message()}
+	for _, detail := range s.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			e.Reason = d.Reason
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				e.WithField(v.Field, v.Description)
+			}
+		}
+	}
+	return e
+}
+
+// HTTPStatus maps a gRPC status code to the HTTP status gateways answer with
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// Client Closed Request, as gRPC gateways answer
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// Response is the body WriteHTTP answers with
+type Response struct {
+	Error  string            `json:"error"`
+	Reason string            `json:"reason,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// WriteHTTP answers an HTTP request with err, which may be an Error or a
+// status returned by a gRPC call. Internal causes are logged, not sent.
+func WriteHTTP(w http.ResponseWriter, err error) {
+	e := From(err)
+	if e.Err != nil {
+		log.Printf("%s: %v", e.Message, e.Err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(HTTPStatus(e.Code))
+	json.NewEncoder(w).Encode(Response{Error: e.Message, Reason: e.Reason, Fields: e.Fields})
+}
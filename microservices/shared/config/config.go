@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -11,6 +14,62 @@ type Config struct {
 	EncryptionKey string
 	ServiceName   string
 	ServicePort   string
+
+	// Sign-in issues an access token valid for AccessTokenTTL and a refresh
+	// token valid for RefreshTokenTTL. Logins with remember_me use the
+	// RememberMe lifetimes instead; a zero RememberMeRefreshTokenTTL ignores
+	// remember_me
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	RememberMeAccessTokenTTL  time.Duration
+	RememberMeRefreshTokenTTL time.Duration
+
+	// LoginRatePerMinute limits sign-in and registration attempts per client
+	// IP, in bursts of up to LoginRateBurst (0 disables). Buckets are shared
+	// through Redis at LoginRateRedisAddr when it is set.
+	LoginRatePerMinute int
+	LoginRateBurst     int
+	LoginRateRedisAddr string
+
+	// The auth service signs access tokens with the RSA or ECDSA key in
+	// JWTPrivateKeyFile and publishes its public half at
+	// /.well-known/jwks.json; the other services verify against the set at
+	// JWKSURL. Without them tokens are signed and verified with JWTSecret.
+	// After a key rotation the previous keys stay in JWTRetiredKeyFiles until
+	// the tokens they signed have expired.
+	JWTPrivateKeyFile  string
+	JWTRetiredKeyFiles []string
+	JWKSURL            string
+
+	// SMTP settings for outgoing email; when SMTPHost is empty emails are logged
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// AppURL is the public base URL used in links sent to users
+	AppURL string
+
+	// Password reset tokens are valid for PasswordResetTTL and sent at most
+	// once per PasswordResetCooldown per account. The emailed link opens
+	// PasswordResetURL with the token in the query, APP_URL/reset-password
+	// when empty.
+	PasswordResetTTL      time.Duration
+	PasswordResetCooldown time.Duration
+	PasswordResetURL      string
+
+	// Google sign-in is enabled with a client ID; GoogleRedirectURL must be
+	// registered with Google and defaults to APP_URL/auth/google/callback
+	GoogleClientID     string
+	GoogleClientSecret string
+	GoogleRedirectURL  string
+
+	// GitHub sign-in likewise; GitHubRedirectURL defaults to
+	// APP_URL/auth/github/callback
+	GitHubClientID     string
+	GitHubClientSecret string
+	GitHubRedirectURL  string
 }
 
 // Load loads configuration from environment variables
@@ -21,6 +80,38 @@ func Load() *Config {
 		EncryptionKey: getEnv("ENCRYPTION_KEY", "your-32-byte-encryption-key-here"),
 		ServiceName:   getEnv("SERVICE_NAME", "unknown-service"),
 		ServicePort:   getEnv("SERVICE_PORT", "8080"),
+
+		AccessTokenTTL:            getDuration("ACCESS_TOKEN_TTL", 24*time.Hour),
+		RefreshTokenTTL:           getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		RememberMeAccessTokenTTL:  getDuration("REMEMBER_ME_ACCESS_TOKEN_TTL", 24*time.Hour),
+		RememberMeRefreshTokenTTL: getDuration("REMEMBER_ME_REFRESH_TOKEN_TTL", 90*24*time.Hour),
+
+		LoginRatePerMinute: getInt("LOGIN_RATE_PER_MINUTE", 10),
+		LoginRateBurst:     getInt("LOGIN_RATE_BURST", 20),
+		LoginRateRedisAddr: getEnv("LOGIN_RATE_REDIS_ADDR", ""),
+
+		JWTPrivateKeyFile:  getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTRetiredKeyFiles: getList("JWT_RETIRED_KEY_FILES"),
+		JWKSURL:            getEnv("JWKS_URL", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "no-reply@example.com"),
+		AppURL:       getEnv("APP_URL", "http://localhost:8080"),
+
+		PasswordResetTTL:      getDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetCooldown: getDuration("PASSWORD_RESET_COOLDOWN", time.Minute),
+		PasswordResetURL:      getEnv("PASSWORD_RESET_URL", ""),
+
+		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
+		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
+		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", ""),
+
+		GitHubClientID:     getEnv("GITHUB_CLIENT_ID", ""),
+		GitHubClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+		GitHubRedirectURL:  getEnv("GITHUB_REDIRECT_URL", ""),
 	}
 }
 
@@ -31,3 +122,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getInt gets an integer environment variable or returns a default value
+func getInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getDuration gets a duration environment variable such as "15m" or returns a default value
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getList gets a comma-separated environment variable, dropping empty entries
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,316 @@
+// Package jwks lets the auth service sign access tokens with an RSA or ECDSA
+// private key and publish the public key as a JSON Web Key Set, so the other
+// services verify tokens without sharing a secret. Services that verify
+// fetch the set from the auth service and refetch it when a token names a
+// key they do not know, which is how key rotation reaches them.
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Path is where the auth service publishes its key set
+const Path = "/.well-known/jwks.json"
+
+// minRefetchGap bounds how often an unknown kid triggers a refetch, so
+// tokens with made-up kids cannot flood the auth service
+const minRefetchGap = 10 * time.Second
+
+// ErrUnknownKey is returned for tokens signed with a key not in the set
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+// JWK is the public half of a signing key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// ECDSA curve and point
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// Set is a JSON Web Key Set
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Signer signs tokens with a private key
+type Signer struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	kid    string
+
+	published []JWK
+}
+
+// LoadSigner reads a PEM private key: RSA (PKCS#1 or PKCS#8) signs with
+// RS256, ECDSA P-256 with ES256. The kid is derived from the public key, so
+// every replica of the auth service agrees on it. The public halves of the
+// retired keys are published as well, so tokens they signed stay valid
+// until they expire.
+func LoadSigner(path string, retired ...string) (*Signer, error) {
+	s, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	s.published = []JWK{s.jwk()}
+	for _, p := range retired {
+		old, err := loadKey(p)
+		if err != nil {
+			return nil, err
+		}
+		s.published = append(s.published, old.jwk())
+	}
+	return s, nil
+}
+
+func loadKey(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwks: no PEM block in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %s: %w", path, err)
+	}
+
+	s := &Signer{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("jwks: RSA keys must have at least 2048 bits")
+		}
+		s.key, s.method = k, jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("jwks: ECDSA keys must use P-256")
+		}
+		s.key, s.method = k, jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("jwks: unsupported key type %T", key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	s.kid = base64.RawURLEncoding.EncodeToString(sum[:8])
+	return s, nil
+}
+
+// Sign signs claims, naming the key in the kid header
+func (s *Signer) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
+
+// Set returns the published key set: the signing key, then retired ones
+func (s *Signer) Set() Set {
+	return Set{Keys: s.published}
+}
+
+// jwk returns the public half of the key
+func (s *Signer) jwk() JWK {
+	jwk := JWK{KeyID: s.kid, Use: "sig", Algorithm: s.method.Alg()}
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(pub.N)
+		jwk.E = encode(big.NewInt(int64(pub.E)))
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = encodeFixed(pub.X, 32)
+		jwk.Y = encodeFixed(pub.Y, 32)
+	}
+	return jwk
+}
+
+// Handler serves the key set. Verifiers may cache it for an hour; they
+// refetch sooner when a token names a new key.
+func (s *Signer) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(s.Set())
+}
+
+// Keyfunc returns the jwt.Parse key function of a verifying service: keys
+// from the verifier when there is one, otherwise the shared HMAC secret
+func Keyfunc(v *Verifier, secret string) jwt.Keyfunc {
+	if v != nil {
+		return v.Keyfunc
+	}
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("jwks: unexpected signing method %s", token.Method.Alg())
+		}
+		return []byte(secret), nil
+	}
+}
+
+// Verifier checks tokens against the key set published at a URL
+type Verifier struct {
+	url    string
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]verifyKey
+	fetchedAt time.Time
+}
+
+type verifyKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// NewVerifier creates a verifier for the key set at url. The set is fetched
+// on first use.
+func NewVerifier(url string) *Verifier {
+	return &Verifier{url: url, client: &http.Client{Timeout: 5 * time.Second}, keys: make(map[string]verifyKey)}
+}
+
+// Keyfunc returns the public key a token names, for jwt.Parse. The token's
+// alg must be the key's, so a public key is never used as an HMAC secret.
+func (v *Verifier) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+	key, ok := v.lookup(kid)
+	if !ok {
+		if err := v.refetch(); err != nil {
+			return nil, err
+		}
+		if key, ok = v.lookup(kid); !ok {
+			return nil, ErrUnknownKey
+		}
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("jwks: unexpected signing method %s", token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+func (v *Verifier) lookup(kid string) (verifyKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// refetch replaces the keys with the published set, at most once per
+// minRefetchGap
+func (v *Verifier) refetch() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Since(v.fetchedAt) < minRefetchGap {
+		return nil
+	}
+	v.fetchedAt = time.Now()
+
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return fmt.Errorf("jwks: fetching key set: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: fetching key set: status %d", resp.StatusCode)
+	}
+	var set Set
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: decoding key set: %w", err)
+	}
+
+	keys := make(map[string]verifyKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = verifyKey{alg: jwk.Algorithm, key: key}
+		}
+	}
+	v.keys = keys
+	return nil
+}
+
+// publicKey decodes an RS256 or ES256 key
+func (k JWK) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.KeyType == "RSA" && k.Algorithm == "RS256":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.KeyType == "EC" && k.Algorithm == "ES256" && k.Curve == "P-256":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("jwks: point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("jwks: unsupported key %s/%s", k.KeyType, k.Algorithm)
+}
+
+func encode(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// encodeFixed encodes a curve coordinate padded to the curve's size
+func encodeFixed(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+func decode(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -15,6 +15,16 @@ type User struct {
 	Role      string             `bson:"role" json:"role"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// Identities are the third-party accounts the user can sign in with
+	Identities []LinkedIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+}
+
+// LinkedIdentity is an account at an OAuth identity provider linked to a user
+type LinkedIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
 }
 
 // UserResponse represents the user data returned to clients
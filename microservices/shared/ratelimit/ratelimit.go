@@ -0,0 +1,229 @@
+// Package ratelimit throttles brute-force targets such as /login per client
+// IP. Each IP gets a token bucket per endpoint; buckets live in the service's
+// memory, or in Redis when a Redis address is configured so every replica of
+// a service draws from the same one.
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter hands out attempts per endpoint and client
+type Limiter struct {
+	perSecond float64
+	burst     int
+	store     store
+}
+
+// store takes a token from the bucket of a key, or returns how long until
+// one is available
+type store interface {
+	take(key string, perSecond float64, burst int) (time.Duration, error)
+}
+
+// New creates a limiter refilling perMinute attempts per minute up to burst.
+// With redisAddr set, buckets are kept in Redis. A nil limiter, returned when
+// perMinute is 0, lets every request through.
+func New(perMinute, burst int, redisAddr string) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	l := &Limiter{perSecond: float64(perMinute) / 60, burst: burst}
+	if redisAddr != "" {
+		l.store = &redisStore{addr: redisAddr}
+	} else {
+		l.store = newMemoryStore()
+	}
+	return l
+}
+
+// Wrap answers clients that used up their attempts at endpoint with 429 and
+// Retry-After. Store errors let the request through.
+func (l *Limiter) Wrap(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		wait, err := l.store.take(endpoint+":"+ip, l.perSecond, l.burst)
+		if err != nil {
+			log.Printf("ratelimit: failed to check %s attempts from %s: %v", endpoint, ip, err)
+			next(w, r)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many attempts, please retry later", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP returns the first X-Forwarded-For address set by the gateway, or
+// the peer address
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		ip, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(ip)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// memoryStore keeps the buckets of this process. Full buckets are dropped
+// periodically, since they behave like missing ones.
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{buckets: make(map[string]*bucket)}
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.sweep()
+		}
+	}()
+	return s
+}
+
+func (s *memoryStore) take(key string, perSecond float64, burst int) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / perSecond * float64(time.Second)))
+	return 0, nil
+}
+
+func (s *memoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// takeScript refills and takes from a bucket stored as a hash, on the Redis
+// server's clock, and returns the milliseconds to wait (0 when taken). The
+// key expires once the bucket would be full again.
+const takeScript = `
+local rate = tonumber(ARGV[1]) / 1000
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - last) * rate)
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate)
+else
+	tokens = tokens - 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return wait
+`
+
+// redisStore runs the bucket script over a single RESP connection
+type redisStore struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func (s *redisStore) take(key string, perSecond float64, burst int) (time.Duration, error) {
+	args := []string{"EVAL", takeScript, "1", "ratelimit:" + key,
+		strconv.FormatFloat(perSecond, 'f', -1, 64), strconv.Itoa(burst)}
+	wait, err := s.eval(args)
+	return time.Duration(wait) * time.Millisecond, err
+}
+
+// eval sends a command and reads its integer reply, reconnecting once on a
+// broken connection
+func (s *redisStore) eval(args []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			conn, dialErr := net.DialTimeout("tcp", s.addr, 2*time.Second)
+			if dialErr != nil {
+				return 0, dialErr
+			}
+			s.conn, s.rd = conn, bufio.NewReader(conn)
+		}
+
+		s.conn.SetDeadline(time.Now().Add(2 * time.Second))
+		var n int64
+		if n, err = s.roundTrip(args); err == nil {
+			return n, nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return 0, err
+}
+
+func (s *redisStore) roundTrip(args []string) (int64, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := s.conn.Write(buf); err != nil {
+		return 0, err
+	}
+
+	line, err := s.rd.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, ":") {
+		return 0, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+	return strconv.ParseInt(line[1:], 10, 64)
+}
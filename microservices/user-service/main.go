@@ -41,12 +41,13 @@ func main() {
 	// Create router
 	r := mux.NewRouter()
 
-	// Apply authentication middleware to all routes
-	r.Use(middleware.JWTAuthMiddleware(cfg))
+	// Authenticated routes; health checks and docs stay public
+	api := r.NewRoute().Subrouter()
+	api.Use(middleware.JWTAuthMiddleware(cfg))
 
 	// User routes
-	r.HandleFunc("/profile", handlers.GetUserProfile).Methods("GET")
-	r.HandleFunc("/profile", handlers.UpdateUserProfile).Methods("PUT")
+	api.HandleFunc("/profile", handlers.GetUserProfile).Methods("GET")
+	api.HandleFunc("/profile", handlers.UpdateUserProfile).Methods("PUT")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -7,10 +7,18 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"golang-backend/microservices/shared/config"
+	"golang-backend/microservices/shared/jwks"
 )
 
-// JWTAuthMiddleware validates JWT tokens for protected routes
+// JWTAuthMiddleware validates JWT tokens for protected routes, against the
+// auth service's published keys when JWKS_URL is set
 func JWTAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	var verifier *jwks.Verifier
+	if cfg.JWKSURL != "" {
+		verifier = jwks.NewVerifier(cfg.JWKSURL)
+	}
+	keyfunc := jwks.Keyfunc(verifier, cfg.JWTSecret)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -20,9 +28,7 @@ func JWTAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return []byte(cfg.JWTSecret), nil
-			})
+			token, err := jwt.Parse(tokenString, keyfunc)
 
 			if err != nil || !token.Valid {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/permissions"
+	"golang-backend/security"
+)
+
+// AnyPermissionMiddleware keeps sessions whose role grants no permission at
+// all off admin routes. Each route then checks its own with RequirePermission.
+func AnyPermissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+		if !ok {
+			explain(r, "any_permission", "permissions claim", ExplainDeny, "no claims on request")
+			http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
+			return
+		}
+
+		if len(permissions.Held(claims)) == 0 {
+			userID, _ := claims["userID"].(string)
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "no permissions")
+			explain(r, "any_permission", "permissions claim", ExplainDeny, fmt.Sprintf("role %v grants no permissions", claims["role"]))
+			http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
+			return
+		}
+
+		explain(r, "any_permission", "permissions claim", ExplainPass, "role grants permissions")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,40 +2,149 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/apikeys"
 	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/mesh"
+	"golang-backend/repository"
+	"golang-backend/security"
+	"golang-backend/tokens"
 )
 
 // JWTAuthMiddleware validates JWT tokens for protected routes
 func JWTAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	roles := newRoleChecker(cfg)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// API keys authenticate as their owner, limited to the key's scopes
+			if rawKey := r.Header.Get("X-API-Key"); rawKey != "" {
+				claims, status, msg := apiKeyClaims(r, rawKey)
+				if status != http.StatusOK {
+					explain(r, "api_key", "X-API-Key header", ExplainDeny, msg)
+					http.Error(w, msg, status)
+					return
+				}
+				explain(r, "api_key", "X-API-Key header", ExplainPass, fmt.Sprintf("key %v of user %v with scopes %v", claims["keyID"], claims["userID"], claims["scopes"]))
+				if status, msg := orgGate(r, claims); status != http.StatusOK {
+					http.Error(w, msg, status)
+					return
+				}
+				correlation.SetUser(r.Context(), fmt.Sprint(claims["userID"]))
+				next.ServeHTTP(w, r.WithContext(withProfile(context.WithValue(r.Context(), "claims", claims), cfg)))
+				return
+			}
+
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
+				// Behind a service mesh the sidecar's identity header stands in
+				// for the token
+				if mesh.Present(r) {
+					meshAuth(w, r, next, cfg, roles)
+					return
+				}
+				explain(r, "jwt_auth", "Authorization header", ExplainDeny, "header missing")
 				http.Error(w, "Authorization header required", http.StatusUnauthorized)
 				return
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return []byte(cfg.JWTSecret), nil
-			})
+			token, err := tokens.Parse(tokenString)
 
 			if err != nil || !token.Valid {
+				security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid or expired token")
+				explain(r, "jwt_auth", "bearer token signature and expiry", ExplainDeny, "invalid or expired token")
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
 			// Extract claims and add to context if needed
 			if claims, ok := token.Claims.(jwt.MapClaims); ok {
-				ctx := context.WithValue(r.Context(), "claims", claims)
+				explain(r, "jwt_auth", "bearer token signature and expiry", ExplainPass, fmt.Sprintf("valid token for user %v with role %v", claims["userID"], claims["role"]))
+
+				if status, msg := replayGate(r, cfg, claims); status != http.StatusOK {
+					http.Error(w, msg, status)
+					return
+				}
+
+				// Break-glass sessions belong to no user: they skip the role and
+				// organization checks, and every request is audited
+				if claims["auth"] == "break_glass" {
+					if status, msg := breakGlassGate(r, claims); status != http.StatusOK {
+						http.Error(w, msg, status)
+						return
+					}
+					correlation.SetUser(r.Context(), fmt.Sprint(claims["userID"]))
+					r = r.WithContext(context.WithValue(r.Context(), "claims", claims))
+					explainAdmin(r, true)
+					auditBreakGlass(w, r, next)
+					return
+				}
+
+				// A role changed since the token was issued replaces the stale one
+				claims, status, msg := roles.refresh(w, r, claims)
+				if status != http.StatusOK {
+					http.Error(w, msg, status)
+					return
+				}
+				if status, msg := orgGate(r, claims); status != http.StatusOK {
+					http.Error(w, msg, status)
+					return
+				}
+
+				correlation.SetUser(r.Context(), fmt.Sprint(claims["userID"]))
+				ctx := withProfile(context.WithValue(r.Context(), "claims", claims), cfg)
 				r = r.WithContext(ctx)
+				explainAdmin(r, claims["role"] == "admin")
 			}
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// apiKeyClaims authenticates an API key and builds claims for its owner. Keys
+// never carry the admin role, so admin routes stay session-only.
+func apiKeyClaims(r *http.Request, rawKey string) (jwt.MapClaims, int, string) {
+	ctx := r.Context()
+	key, err := apikeys.Authenticate(ctx, rawKey)
+	if err != nil {
+		if err == apikeys.ErrInvalidKey {
+			security.Emit(r, security.EventTokenInvalid, security.OutcomeFailure, "", "invalid API key")
+			return nil, http.StatusUnauthorized, "Invalid API key"
+		}
+		return nil, http.StatusInternalServerError, "Failed to verify API key"
+	}
+	// Keys requiring signatures are useless without the signing secret
+	if key.RequireSignature {
+		if status, msg := signatureGate(r, key); status != http.StatusOK {
+			return nil, status, msg
+		}
+	}
+
+	user, _, err := repository.FindUser(ctx, bson.M{"_id": key.UserID}, repository.Fields("suspended", "org_id"))
+	if err != nil || user.Suspended {
+		security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, key.UserID.Hex(), "API key owner unavailable")
+		return nil, http.StatusUnauthorized, "Invalid API key"
+	}
+
+	scopes := make([]interface{}, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = scope
+	}
+
+	return jwt.MapClaims{
+		"userID": key.UserID.Hex(),
+		"role":   "user",
+		"auth":   "api_key",
+		"keyID":  key.ID.Hex(),
+		"scopes": scopes,
+		"orgID":  user.OrgID,
+	}, http.StatusOK, ""
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConcurrencyLimit allows at most max requests to run at once. Further requests
+// wait up to queueTimeout for a slot and are then rejected with 503. A max of
+// zero or less disables the limit.
+func ConcurrencyLimit(name string, max int, queueTimeout time.Duration) func(http.Handler) http.Handler {
+	if max <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	slots := make(chan struct{}, max)
+	retryAfter := strconv.Itoa(int(queueTimeout.Seconds()) + 1)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				timer := time.NewTimer(queueTimeout)
+				select {
+				case slots <- struct{}{}:
+					timer.Stop()
+				case <-timer.C:
+					log.Printf("concurrency limit %s reached (%d), rejecting %s %s", name, max, r.Method, r.URL.Path)
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Retry-After", retryAfter)
+					http.Error(w, `{"error": "Server is busy, please retry"}`, http.StatusServiceUnavailable)
+					return
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+			defer func() { <-slots }()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EventStreams sends requests for server-sent event streams through streams
+// and every other request through limit. Streams stay open for as long as the
+// client listens, so they are bounded by a pool of their own instead of
+// holding slots of the service-wide limit.
+func EventStreams(streams, limit func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		streamed, limited := streams(next), limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				streamed.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventStreamsDoNotHoldServiceSlots(t *testing.T) {
+	open := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/events" {
+			once.Do(func() { close(open) })
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	streams := ConcurrencyLimit("events", 1, 10*time.Millisecond)
+	service := ConcurrencyLimit("service", 1, 10*time.Millisecond)
+	h := EventStreams(streams, service)(handler)
+
+	stream := func() *http.Request {
+		req := httptest.NewRequest("GET", "/user/events", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		return req
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), stream())
+		close(done)
+	}()
+	<-open
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/user/profile", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request next to an open stream got %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, stream())
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stream beyond the stream pool got %d, want 503", rec.Code)
+	}
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsExposed are the response headers browsers may read
+const corsExposed = "X-Request-ID, X-Trace-ID, X-Refreshed-Token, X-Signature-SHA256"
+
+// CORS lets browsers on the given origins read responses; "*" allows any
+// origin. Without origins no CORS headers are sent, so browsers block
+// cross-origin calls.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				w.Header().Add("Vary", "Origin")
+				if allowedOrigin(origins, origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Preflight answers CORS preflight requests from the given origins. It serves
+// as a router's MethodNotAllowedHandler, so preflights never reach the
+// authentication middleware; other unsupported methods still get a 405.
+func Preflight(origins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if r.Method != http.MethodOptions || origin == "" || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigin(origins, origin) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func allowedOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
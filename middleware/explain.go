@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// ExplainHeader asks for an authorization trace; admins get it back in the
+// response header of the same name
+const ExplainHeader = "X-Explain-Authz"
+
+// Authorization trace outcomes
+const (
+	ExplainPass = "pass"
+	ExplainDeny = "deny"
+)
+
+// ExplainStep is one authorization check made while serving a request
+type ExplainStep struct {
+	Policy  string `json:"policy"`
+	Checked string `json:"checked"`
+	Result  string `json:"result"`
+	Reason  string `json:"reason"`
+}
+
+// explainTrace collects the checks of a request
+type explainTrace struct {
+	mu       sync.Mutex
+	Decision string        `json:"decision"`
+	Steps    []ExplainStep `json:"steps"`
+	admin    bool
+}
+
+type explainKey struct{}
+
+// ExplainAuthz traces the authorization middleware that runs after it when
+// the request sends X-Explain-Authz. The trace is only returned to callers
+// authenticated as admins, so it never helps anyone probe the policies.
+func ExplainAuthz(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ExplainHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		trace := &explainTrace{Decision: "granted"}
+		ew := &explainWriter{ResponseWriter: w, trace: trace}
+		next.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), explainKey{}, trace)))
+	})
+}
+
+// explain records an authorization step when the request is being traced
+func explain(r *http.Request, policy, checked, result, reason string) {
+	trace, ok := r.Context().Value(explainKey{}).(*explainTrace)
+	if !ok {
+		return
+	}
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.Steps = append(trace.Steps, ExplainStep{Policy: policy, Checked: checked, Result: result, Reason: reason})
+	if result == ExplainDeny {
+		trace.Decision = "denied"
+	}
+}
+
+// explainAdmin marks the traced caller as an admin allowed to see the trace
+func explainAdmin(r *http.Request, isAdmin bool) {
+	if trace, ok := r.Context().Value(explainKey{}).(*explainTrace); ok {
+		trace.mu.Lock()
+		trace.admin = isAdmin
+		trace.mu.Unlock()
+	}
+}
+
+// explainWriter adds the trace header before the response starts
+type explainWriter struct {
+	http.ResponseWriter
+	trace   *explainTrace
+	written bool
+}
+
+func (w *explainWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.trace.mu.Lock()
+		if w.trace.admin {
+			if data, err := json.Marshal(w.trace); err == nil {
+				w.Header().Set(ExplainHeader, string(data))
+			}
+		}
+		w.trace.mu.Unlock()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *explainWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps event streams working while traced
+func (w *explainWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/files"
+	"golang-backend/security"
+)
+
+// FileAccess loads the file named by the {id} route variable and lets the
+// request through only when the caller may read it, or with write, change
+// it. Files the caller may not see are reported as missing, so their IDs
+// cannot be probed. Handlers get the file from files.FromContext.
+func FileAccess(write bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+			if err != nil {
+				http.Error(w, `{"error": "Invalid file ID format"}`, http.StatusBadRequest)
+				return
+			}
+			file, err := files.Get(r.Context(), id)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				explain(r, "file_access", "file "+id.Hex(), ExplainDeny, "file does not exist")
+				http.Error(w, `{"error": "File not found"}`, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error": "Failed to load file"}`, http.StatusInternalServerError)
+				return
+			}
+
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			userID, _ := claims["userID"].(string)
+			orgID, _ := claims["orgID"].(string)
+			role, _ := claims["role"].(string)
+			admin := role == "admin"
+
+			allowed := files.CanRead(file, userID, orgID, admin)
+			if write {
+				allowed = files.CanWrite(file, userID, admin)
+			}
+			if !allowed {
+				if files.CanRead(file, userID, orgID, admin) {
+					explain(r, "file_access", "file "+id.Hex(), ExplainDeny, "only the owner can change a "+file.Visibility+" file")
+					security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "not the owner of file "+id.Hex())
+					http.Error(w, `{"error": "Only the owner can change this file"}`, http.StatusForbidden)
+					return
+				}
+				explain(r, "file_access", "file "+id.Hex(), ExplainDeny, "file is "+file.Visibility+" and owned by someone else")
+				http.Error(w, `{"error": "File not found"}`, http.StatusNotFound)
+				return
+			}
+
+			switch {
+			case file.OwnerID == userID:
+				explain(r, "file_access", "file "+id.Hex(), ExplainPass, "caller owns the file")
+			case admin:
+				explain(r, "file_access", "file "+id.Hex(), ExplainPass, "role is admin")
+			default:
+				explain(r, "file_access", "file "+id.Hex(), ExplainPass, "file is "+file.Visibility)
+			}
+			next.ServeHTTP(w, r.WithContext(files.WithFile(r.Context(), file)))
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/models"
+	"golang-backend/repository"
+)
+
+// orgGate applies the lifecycle status of the caller's organization: members
+// of suspended or deleted organizations are locked out, and members of
+// archived ones may only read. It returns a status other than 200 to reject.
+func orgGate(r *http.Request, claims jwt.MapClaims) (int, string) {
+	orgID, _ := claims["orgID"].(string)
+	if orgID == "" {
+		return http.StatusOK, ""
+	}
+
+	status, err := repository.OrgStatus(r.Context(), orgID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to verify organization"
+	}
+
+	switch status {
+	case models.OrgSuspended:
+		explain(r, "org_status", "caller's organization", ExplainDeny, "organization "+orgID+" is suspended")
+		return http.StatusForbidden, "Organization suspended"
+	case models.OrgDeleted:
+		explain(r, "org_status", "caller's organization", ExplainDeny, "organization "+orgID+" is deleted")
+		return http.StatusForbidden, "Organization deleted"
+	case models.OrgArchived:
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			explain(r, "org_status", "caller's organization", ExplainDeny, "organization "+orgID+" is archived and read-only")
+			return http.StatusForbidden, "Organization is archived and read-only"
+		}
+	}
+	explain(r, "org_status", "caller's organization", ExplainPass, "organization "+orgID+" is "+status)
+	return http.StatusOK, ""
+}
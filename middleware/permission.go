@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/permissions"
+	"golang-backend/security"
+)
+
+// RequirePermission lets requests through only when their session holds
+// permission, directly or through a wildcard. API keys hold no permissions.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			held := permissions.Held(claims)
+			if permissions.Grants(held, permission) {
+				explain(r, "require_permission", permission, ExplainPass, "role grants the permission")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _ := claims["userID"].(string)
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "missing permission "+permission)
+			explain(r, "require_permission", permission, ExplainDeny, fmt.Sprintf("role %v only grants %v", claims["role"], held))
+			http.Error(w, `{"error": "Forbidden: missing permission `+permission+`"}`, http.StatusForbidden)
+		})
+	}
+}
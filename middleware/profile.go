@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/customfields"
+	"golang-backend/keys"
+	"golang-backend/repository"
+)
+
+// UserProfile is the profile of the authenticated user, with the email
+// decrypted
+type UserProfile struct {
+	ID          primitive.ObjectID
+	Email       string
+	DisplayName string
+	Role        string
+	OrgID       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// CustomFields are the user's custom field values, decrypted
+	CustomFields map[string]interface{}
+}
+
+// lazyProfile loads the profile on first use and keeps it for the request
+type lazyProfile struct {
+	cfg     *config.Config
+	once    sync.Once
+	profile *UserProfile
+	err     error
+}
+
+type profileKey struct{}
+
+// ErrNoSession is returned by Profile outside an authenticated request
+var ErrNoSession = errors.New("no authenticated user")
+
+// withProfile lets handlers behind the auth middleware load the caller's
+// profile. Tokens carry no personal data, so it is read when first needed.
+func withProfile(ctx context.Context, cfg *config.Config) context.Context {
+	return context.WithValue(ctx, profileKey{}, &lazyProfile{cfg: cfg})
+}
+
+// Profile returns the authenticated user's profile, loading it once per
+// request. It returns mongo.ErrNoDocuments when the user no longer exists.
+func Profile(r *http.Request) (*UserProfile, error) {
+	lazy, ok := r.Context().Value(profileKey{}).(*lazyProfile)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	lazy.once.Do(func() {
+		lazy.profile, lazy.err = loadProfile(r.Context(), lazy.cfg)
+	})
+	return lazy.profile, lazy.err
+}
+
+func loadProfile(ctx context.Context, cfg *config.Config) (*UserProfile, error) {
+	claims, _ := ctx.Value("claims").(jwt.MapClaims)
+	idStr, _ := claims["userID"].(string)
+	userID, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.ProfileFields)
+	if err != nil {
+		return nil, err
+	}
+	email, err := keys.Decrypt(ctx, cfg, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := customfields.Decode(ctx, cfg, user.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+	return &UserProfile{
+		ID:          user.ID,
+		Email:       email,
+		DisplayName: user.DisplayName,
+		Role:        user.Role,
+		OrgID:       user.OrgID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+
+		CustomFields: custom,
+	}, nil
+}
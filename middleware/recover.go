@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"golang-backend/correlation"
+)
+
+// Recover turns a panic in a handler into a 500 response and logs the stack,
+// so malformed input never drops the connection without an answer.
+// http.ErrAbortHandler is re-raised because it deliberately aborts a response.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			correlation.Errorf(r.Context(), "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/models"
+	"golang-backend/permissions"
+	"golang-backend/repository"
+	"golang-backend/tokens"
+	"golang.org/x/sync/singleflight"
+)
+
+// RefreshedTokenHeader carries a reissued token when a session token's role
+// was out of date. Clients should replace their stored token with it.
+const RefreshedTokenHeader = "X-Refreshed-Token"
+
+// roleChecker compares the role version in session tokens with the user's
+// current one, caching lookups for cfg.RoleCheckTTL. Concurrent lookups of
+// the same user share one query.
+type roleChecker struct {
+	cfg   *config.Config
+	cache *repository.UserCache
+	loads singleflight.Group
+}
+
+func newRoleChecker(cfg *config.Config) *roleChecker {
+	rc := &roleChecker{cfg: cfg}
+	if cfg.RoleCheckTTL > 0 {
+		rc.cache = repository.NewUserCache(cfg.RoleCheckTTL)
+	}
+	return rc
+}
+
+// refresh returns claims carrying the user's current role, its permissions,
+// and organization. When the role or organization changed after the token was
+// issued, the claims are rewritten and a token with the same expiry is sent
+// in RefreshedTokenHeader. Minimal tokens (see JWT_MINIMAL_CLAIMS) are
+// expanded with the current values instead. It returns a status other than
+// 200 when the user is gone, suspended or cannot be looked up.
+func (rc *roleChecker) refresh(w http.ResponseWriter, r *http.Request, claims jwt.MapClaims) (jwt.MapClaims, int, string) {
+	idStr, _ := claims["userID"].(string)
+	minimal := false
+	if sub, ok := claims["sub"].(string); ok && idStr == "" {
+		idStr, minimal = sub, true
+	}
+	userID, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid token"
+	}
+
+	user, err := rc.current(r.Context(), userID)
+	if err == mongo.ErrNoDocuments {
+		explain(r, "role_version", "role version claim", ExplainDeny, "user no longer exists")
+		return nil, http.StatusUnauthorized, "Invalid token"
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to verify token"
+	}
+	if user.Suspended {
+		explain(r, "role_version", "role version claim", ExplainDeny, "user is suspended")
+		return nil, http.StatusUnauthorized, "Account suspended"
+	}
+
+	// Minimal tokens never carry a role or organization to go stale
+	if minimal {
+		expanded := jwt.MapClaims{}
+		for k, v := range claims {
+			expanded[k] = v
+		}
+		perms, err := permissions.For(r.Context(), user.Role)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Failed to verify token"
+		}
+		expanded["userID"] = idStr
+		expanded["role"] = user.Role
+		expanded["permissions"] = perms
+		expanded["roleVersion"] = user.RoleVersion
+		expanded["orgID"] = user.OrgID
+		issued, _ := claims["rv"].(float64)
+		explain(r, "role_version", "role version claim", ExplainPass, fmt.Sprintf("minimal token issued at version %d, loaded role %q at version %d", int(issued), user.Role, user.RoleVersion))
+		return expanded, http.StatusOK, ""
+	}
+
+	// Tokens issued before role versions existed count as version 0
+	version, _ := claims["roleVersion"].(float64)
+	orgID, _ := claims["orgID"].(string)
+	if int(version) == user.RoleVersion && orgID == user.OrgID {
+		explain(r, "role_version", "role version claim", ExplainPass, fmt.Sprintf("version %d is current", user.RoleVersion))
+		// Tokens issued before permissions get their role's for this request
+		if _, ok := claims["permissions"]; !ok {
+			perms, err := permissions.For(r.Context(), user.Role)
+			if err != nil {
+				return nil, http.StatusInternalServerError, "Failed to verify token"
+			}
+			expanded := jwt.MapClaims{}
+			for k, v := range claims {
+				expanded[k] = v
+			}
+			expanded["permissions"] = perms
+			return expanded, http.StatusOK, ""
+		}
+		return claims, http.StatusOK, ""
+	}
+
+	refreshed := jwt.MapClaims{}
+	for k, v := range claims {
+		refreshed[k] = v
+	}
+	perms, err := permissions.Load(r.Context(), user.Role)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to refresh token"
+	}
+	refreshed["role"] = user.Role
+	refreshed["permissions"] = perms
+	refreshed["roleVersion"] = user.RoleVersion
+	refreshed["orgID"] = user.OrgID
+	refreshed["jti"] = tokens.NewID()
+
+	exp, _ := claims["exp"].(float64)
+	if err := tokens.Issue(r.Context(), refreshed["jti"].(string), idStr, time.Unix(int64(exp), 0), tokens.ClientOf(r)); err != nil {
+		return nil, http.StatusInternalServerError, "Failed to refresh token"
+	}
+	token, err := tokens.Sign(refreshed)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to refresh token"
+	}
+	// Reusing the replaced token from now on is a replay
+	if jti, _ := claims["jti"].(string); jti != "" {
+		if err := tokens.Supersede(r.Context(), jti); err != nil {
+			correlation.Errorf(r.Context(), "Failed to mark token %s as replaced: %v", jti, err)
+		}
+	}
+	w.Header().Set(RefreshedTokenHeader, token)
+	explain(r, "role_version", "role version claim", ExplainPass, fmt.Sprintf("version %d is stale, role refreshed to %q at version %d", int(version), user.Role, user.RoleVersion))
+	return refreshed, http.StatusOK, ""
+}
+
+// current returns the user's role and role version, from cache when fresh
+func (rc *roleChecker) current(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	if user, ok := rc.cache.Get(userID); ok {
+		return user, nil
+	}
+	loaded, err, _ := rc.loads.Do(userID.Hex(), func() (interface{}, error) {
+		user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, repository.RoleFields)
+		if err != nil {
+			return nil, err
+		}
+		rc.cache.Set(user)
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loaded.(*models.User), nil
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/security"
+)
+
+// RequireScope lets API key requests through only when the key grants scope.
+// Session tokens are not scoped and always pass.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			if claims["auth"] != "api_key" {
+				explain(r, "require_scope", scope, ExplainPass, "session tokens are not scoped")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scopes, _ := claims["scopes"].([]interface{})
+			for _, s := range scopes {
+				if s == scope {
+					explain(r, "require_scope", scope, ExplainPass, "API key grants the scope")
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			userID, _ := claims["userID"].(string)
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "API key lacks scope "+scope)
+			explain(r, "require_scope", scope, ExplainDeny, fmt.Sprintf("API key only grants %v", scopes))
+			http.Error(w, `{"error": "API key is missing the `+scope+` scope"}`, http.StatusForbidden)
+		})
+	}
+}
+
+// SessionOnly rejects API key requests, e.g. for managing the keys themselves
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, _ := r.Context().Value("claims").(jwt.MapClaims); claims["auth"] == "api_key" {
+			explain(r, "session_only", "auth method", ExplainDeny, "API keys cannot use this endpoint")
+			http.Error(w, `{"error": "This endpoint requires a session token"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang-backend/clock"
+	"golang-backend/security"
+)
+
+// RequireSudo lets a request through only when its session was elevated with
+// POST /auth/sudo in the last ttl, for operations that are hard to undo. A
+// ttl of zero or less disables the check. Break-glass sessions were just
+// redeemed with a one-time token and always pass.
+func RequireSudo(ttl time.Duration) func(http.Handler) http.Handler {
+	if ttl <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+			if claims["auth"] == "break_glass" {
+				explain(r, "require_sudo", "elevatedUntil claim", ExplainPass, "break-glass session")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			until, _ := claims["elevatedUntil"].(float64)
+			if now := clock.Now().Unix(); int64(until) > now {
+				explain(r, "require_sudo", "elevatedUntil claim", ExplainPass, fmt.Sprintf("elevated for %ds more", int64(until)-now))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _ := claims["userID"].(string)
+			security.Emit(r, security.EventPermissionDenied, security.OutcomeFailure, userID, "sudo required")
+			explain(r, "require_sudo", "elevatedUntil claim", ExplainDeny, "session not elevated or elevation expired")
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Recent re-authentication required", "sudo": "/auth/sudo"}`, http.StatusForbidden)
+		})
+	}
+}
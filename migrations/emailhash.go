@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/utils"
+)
+
+// legacyEmailHash matches users whose email_hash holds the raw address, as
+// the auth and user microservices store it. Blind indexes are base64 and
+// never contain "@", and erased users carry a "forgotten:" placeholder.
+var legacyEmailHash = bson.M{"email_hash": bson.M{"$regex": "@"}}
+
+// migrateLegacyEmailHash replaces raw addresses in email_hash with the blind
+// index of the encrypted email. Users whose email cannot be decrypted, or
+// whose address is already indexed for another account, are left unchanged.
+func migrateLegacyEmailHash(ctx context.Context, cfg *config.Config, report *models.MigrationRun) error {
+	opts := options.Find().SetProjection(bson.M{"email": 1, "email_hash": 1})
+	for region, db := range database.Regions {
+		collection := db.Collection("users")
+		cursor, err := collection.Find(ctx, legacyEmailHash, opts)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next(ctx) {
+			var user models.User
+			if err := cursor.Decode(&user); err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			report.Scanned++
+			id := user.ID.Hex()
+
+			plain, err := keys.Decrypt(ctx, cfg, user.Email)
+			if err != nil || plain == "" {
+				fail(report, id, region, "email cannot be decrypted")
+				continue
+			}
+			index := utils.EmailIndex(plain, cfg.EmailFoldAliases)
+
+			owner, _, err := repository.FindUserByEmailHash(ctx, index, repository.IDOnly)
+			if err == nil && owner.ID != user.ID {
+				fail(report, id, region, "address is already indexed for user "+owner.ID.Hex())
+				continue
+			}
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				cursor.Close(ctx)
+				return err
+			}
+
+			// Only replace the value that was read, in case the user changed
+			// their email meanwhile
+			filter := bson.M{"_id": user.ID, "email_hash": user.EmailHash}
+			result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"email_hash": index}})
+			if err != nil {
+				fail(report, id, region, err.Error())
+				continue
+			}
+			if result.ModifiedCount == 1 {
+				report.Migrated++
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
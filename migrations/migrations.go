@@ -0,0 +1,136 @@
+// Package migrations runs data migrations at startup. Each migration only
+// touches documents still in the old form, so it is safe to run on every
+// start and finds nothing once done; documents it fails on are reported and
+// retried on the next start. A lease keeps instances starting together from
+// running the same migration at once, and every run that found work is
+// recorded in migration_runs.
+package migrations
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+const (
+	// locks holds one lease document per migration
+	locks = "migration_locks"
+	// runs holds the report of every run that found work
+	runs = "migration_runs"
+	// lease bounds a run; an instance that dies mid-run frees the migration
+	// once it lapses
+	lease = 10 * time.Minute
+	// maxFailures bounds the failures listed in a report
+	maxFailures = 100
+)
+
+// migration is an idempotent data migration
+type migration struct {
+	name string
+	run  func(ctx context.Context, cfg *config.Config, report *models.MigrationRun) error
+}
+
+// all lists the migrations in the order they run
+var all = []migration{
+	{name: "legacy_email_hash", run: migrateLegacyEmailHash},
+}
+
+// Run runs every migration before the server starts, unless
+// MIGRATE_ON_STARTUP is false. Migrations another instance is running are
+// skipped.
+func Run(cfg *config.Config) {
+	if !cfg.MigrateOnStartup {
+		return
+	}
+	for _, m := range all {
+		runOne(cfg, m)
+	}
+}
+
+func runOne(cfg *config.Config, m migration) {
+	ctx, cancel := context.WithTimeout(context.Background(), lease)
+	defer cancel()
+
+	owner := clock.NewID().Hex()
+	acquired, err := acquire(ctx, m.name, owner)
+	if err != nil {
+		log.Printf("migrations: %s: failed to acquire lease: %v", m.name, err)
+		return
+	}
+	if !acquired {
+		log.Printf("migrations: %s: running on another instance, skipped", m.name)
+		return
+	}
+	defer release(m.name, owner)
+
+	host, _ := os.Hostname()
+	report := models.MigrationRun{ID: clock.NewID(), Name: m.name, Host: host, StartedAt: clock.Now()}
+	err = m.run(ctx, cfg, &report)
+	report.FinishedAt = clock.Now()
+	if err != nil {
+		report.Error = err.Error()
+	} else if report.Scanned == 0 {
+		return
+	}
+
+	log.Printf("migrations: %s: migrated %d of %d documents, %d failed", m.name, report.Migrated, report.Scanned, report.Failed)
+	if err != nil {
+		log.Printf("migrations: %s: stopped: %v", m.name, err)
+	}
+	if _, err := database.DB.Collection(runs).InsertOne(context.Background(), report); err != nil {
+		log.Printf("migrations: %s: failed to store report: %v", m.name, err)
+	}
+}
+
+// fail records a document left unchanged
+func fail(report *models.MigrationRun, id, region, reason string) {
+	report.Failed++
+	if len(report.Failures) < maxFailures {
+		report.Failures = append(report.Failures, models.MigrationFailure{ID: id, Region: region, Reason: reason})
+	}
+	log.Printf("migrations: %s: document %s left unchanged: %s", report.Name, id, reason)
+}
+
+// acquire takes the lease of a migration unless another owner holds it
+func acquire(ctx context.Context, name, owner string) (bool, error) {
+	now := clock.Now()
+	filter := bson.M{"_id": name, "locked_until": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"locked_until": now.Add(lease), "owner": owner}}
+	_, err := database.DB.Collection(locks).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and has not lapsed
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release lets the migration run again right away
+func release(name, owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	update := bson.M{"$set": bson.M{"locked_until": clock.Now()}}
+	if _, err := database.DB.Collection(locks).UpdateOne(ctx, bson.M{"_id": name, "owner": owner}, update); err != nil {
+		log.Printf("migrations: %s: failed to release lease: %v", name, err)
+	}
+}
+
+// List returns the most recent migration reports, newest first
+func List(ctx context.Context, limit int64) ([]models.MigrationRun, error) {
+	opts := options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(limit)
+	cursor, err := database.DB.Collection(runs).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []models.MigrationRun{}
+	err = cursor.All(ctx, &list)
+	return list, err
+}
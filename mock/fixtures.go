@@ -0,0 +1,69 @@
+package mock
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/utils"
+)
+
+//go:embed fixtures/demo.json
+var fixtureFS embed.FS
+
+// fixtures is the dataset demo mode serves and resets to
+type fixtures struct {
+	Users []struct {
+		ID       string    `json:"id"`
+		Email    string    `json:"email"`
+		Password string    `json:"password"`
+		Role     string    `json:"role"`
+		Created  time.Time `json:"created_at"`
+	} `json:"users"`
+}
+
+// loadFixtures reads the demo dataset from path, or the built-in one when
+// path is empty. Users without a password get SeedPassword.
+func loadFixtures(path string) ([]user, error) {
+	var data []byte
+	var err error
+	if path == "" {
+		data, err = fixtureFS.ReadFile("fixtures/demo.json")
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %w", err)
+	}
+	users := make([]user, 0, len(f.Users))
+	for i, fu := range f.Users {
+		id, err := primitive.ObjectIDFromHex(fu.ID)
+		if err != nil {
+			return nil, fmt.Errorf("fixture user %d: invalid id %q", i, fu.ID)
+		}
+		if fu.Role != "user" && fu.Role != "admin" {
+			return nil, fmt.Errorf("fixture user %d: invalid role %q", i, fu.Role)
+		}
+		password := fu.Password
+		if password == "" {
+			password = SeedPassword
+		}
+		users = append(users, user{
+			ID:        id,
+			Email:     utils.NormalizeEmail(fu.Email),
+			Password:  password,
+			Role:      fu.Role,
+			CreatedAt: fu.Created,
+			UpdatedAt: fu.Created,
+		})
+	}
+	return users, nil
+}
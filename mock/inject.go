@@ -0,0 +1,50 @@
+package mock
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// injector adds canned latency and failures to mock responses
+type injector struct {
+	latency   time.Duration
+	jitter    time.Duration
+	errorRate float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// wrap delays every request and fails a share of them with 500. The random
+// source is seeded, so a given sequence of requests fails the same way on
+// every run.
+func (in *injector) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in.mu.Lock()
+		delay := in.latency
+		if in.jitter > 0 {
+			delay += time.Duration(in.rnd.Int63n(int64(in.jitter)))
+		}
+		fail := in.rnd.Float64() < in.errorRate
+		in.mu.Unlock()
+
+		time.Sleep(delay)
+
+		// Clients can force an error status per request while building error states
+		if status, err := strconv.Atoi(r.Header.Get("X-Mock-Status")); err == nil && status >= 400 && status < 600 {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Forced mock status"}`, status)
+			return
+		}
+
+		if fail {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Injected mock failure"}`, http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
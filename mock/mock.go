@@ -0,0 +1,314 @@
+// Package mock serves the core API from an in-memory store so frontends can be
+// developed without MongoDB or real credentials. It is enabled with MOCK_MODE.
+// DEMO_MODE serves the same API from a fixture dataset for public demos, with
+// POST /demo/reset and a periodic reset undoing whatever visitors changed.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/handlers"
+	"golang-backend/utils"
+)
+
+// server holds the mock store and configuration
+type server struct {
+	cfg   *config.Config
+	store *store
+}
+
+// Router returns a router serving auth, profile and admin user endpoints from
+// memory. Endpoints not covered by mock mode answer 501.
+func Router(cfg *config.Config) http.Handler {
+	s := &server{cfg: cfg, store: newStore(cfg.MockSeedUsers)}
+	mode := "mock"
+	if cfg.DemoMode {
+		seed, err := loadFixtures(cfg.DemoFixtures)
+		if err != nil {
+			log.Fatal("Failed to load demo fixtures:", err)
+		}
+		s.store = newSeededStore(seed)
+		mode = "demo"
+	}
+	in := &injector{
+		latency:   cfg.MockLatency,
+		jitter:    cfg.MockLatencyJitter,
+		errorRate: cfg.MockErrorRate,
+		rnd:       rand.New(rand.NewSource(cfg.MockSeed)),
+	}
+
+	r := mux.NewRouter()
+	r.Use(in.wrap)
+
+	r.HandleFunc("/register", s.register).Methods("POST")
+	r.HandleFunc("/login", s.login(false)).Methods("POST")
+	r.HandleFunc("/admin/login", s.login(true)).Methods("POST")
+
+	r.Handle("/user/profile", s.auth(false, s.getProfile)).Methods("GET")
+	r.Handle("/user/profile", s.auth(false, s.updateProfile)).Methods("PUT")
+	r.Handle("/user/password", s.auth(false, s.changePassword)).Methods("PUT")
+
+	r.Handle("/admin/users", s.auth(true, s.listUsers)).Methods("GET")
+	r.Handle("/admin/users/delete", s.auth(true, s.deleteUser)).Methods("POST")
+	r.Handle("/admin/users/role", s.auth(true, s.updateRole)).Methods("PUT")
+
+	if cfg.DemoMode {
+		r.Handle("/demo/reset", s.auth(true, s.resetDemo)).Methods("POST")
+		if cfg.DemoResetInterval > 0 {
+			go func() {
+				for range time.Tick(cfg.DemoResetInterval) {
+					s.store.reset()
+					log.Println("Demo mode: data reset")
+				}
+			}()
+		}
+	}
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Not available in `+mode+` mode"}`, http.StatusNotImplemented)
+	})
+
+	if cfg.DemoMode {
+		log.Printf("Demo mode: %d fixture users, sign in as admin@example.com with %q unless the fixtures say otherwise", len(s.store.seed), SeedPassword)
+	} else {
+		log.Printf("Mock mode: %d seeded users, sign in as admin@example.com or user1@example.com with %q", cfg.MockSeedUsers+1, SeedPassword)
+	}
+	return r
+}
+
+// auth validates the bearer token like the real middleware, without security events
+func (s *server) auth(adminOnly bool, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return []byte(s.cfg.JWTSecret), nil
+		})
+		if err != nil || !token.Valid {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		claims := token.Claims.(jwt.MapClaims)
+		if role, _ := claims["role"].(string); adminOnly && role != "admin" {
+			http.Error(w, `{"error": "Forbidden: Admin access required"}`, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "claims", claims)))
+	})
+}
+
+func (s *server) register(w http.ResponseWriter, r *http.Request) {
+	var req handlers.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" || req.Password == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if _, exists := s.store.byEmail(req.Email); exists {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+
+	now := clock.Now()
+	s.store.add(&user{ID: clock.NewID(), Email: utils.NormalizeEmail(req.Email), Password: req.Password, Role: "user", CreatedAt: now, UpdatedAt: now})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+}
+
+func (s *server) login(adminOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req handlers.LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		u, ok := s.store.byEmail(req.Email)
+		if !ok || u.Password != req.Password {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if adminOnly && u.Role != "admin" {
+			http.Error(w, "Access denied: Admin only", http.StatusForbidden)
+			return
+		}
+
+		ttl := s.cfg.AccessTokenTTL
+		if req.RememberMe && s.cfg.RememberMeRefreshTokenTTL > 0 {
+			ttl = s.cfg.RememberMeAccessTokenTTL
+		}
+		if ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"userID": u.ID.Hex(),
+			"email":  u.Email,
+			"role":   u.Role,
+			"exp":    clock.Now().Add(ttl).Unix(),
+		})
+		tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": tokenString, "role": u.Role})
+	}
+}
+
+func (s *server) getProfile(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.store.get(claimsUserID(r))
+	if !ok {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(response(u))
+}
+
+func (s *server) updateProfile(w http.ResponseWriter, r *http.Request) {
+	var req handlers.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Password != "" {
+		http.Error(w, `{"error": "Change the password at PUT /user/password"}`, http.StatusBadRequest)
+		return
+	}
+
+	id := claimsUserID(r)
+	if req.Email != "" {
+		if other, exists := s.store.byEmail(req.Email); exists && other.ID != id {
+			http.Error(w, `{"error": "Email already in use"}`, http.StatusConflict)
+			return
+		}
+	}
+
+	ok := s.store.update(id, func(u *user) {
+		if req.Email != "" {
+			u.Email = utils.NormalizeEmail(req.Email)
+		}
+	})
+	if !ok {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(handlers.SuccessResponse{Message: "Profile updated successfully"})
+}
+
+func (s *server) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req handlers.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.NewPassword == "" {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	u, ok := s.store.get(claimsUserID(r))
+	if !ok {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if u.Password != req.CurrentPassword {
+		http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
+		return
+	}
+	s.store.update(u.ID, func(u *user) {
+		u.Password = req.NewPassword
+	})
+	json.NewEncoder(w).Encode(handlers.ChangePasswordResponse{Message: "Password changed"})
+}
+
+func (s *server) listUsers(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	users, total := s.store.list((page-1)*limit, limit)
+	resp := handlers.ListUsersResponse{Total: total, Page: page, Limit: limit, TotalPages: (total + limit - 1) / limit}
+	for _, u := range users {
+		resp.Users = append(resp.Users, response(u))
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	var req handlers.DeleteUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return
+	}
+	if !s.store.delete(id) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(handlers.DeleteUserResponse{Message: "User deleted successfully"})
+}
+
+func (s *server) updateRole(w http.ResponseWriter, r *http.Request) {
+	var req handlers.UpdateUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Role != "user" && req.Role != "admin" {
+		http.Error(w, `{"error": "Invalid role. Must be 'user' or 'admin'"}`, http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID format"}`, http.StatusBadRequest)
+		return
+	}
+	if !s.store.update(id, func(u *user) { u.Role = req.Role }) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(handlers.UpdateUserRoleResponse{Message: "User role updated successfully"})
+}
+
+// resetDemo restores the fixture dataset
+func (s *server) resetDemo(w http.ResponseWriter, r *http.Request) {
+	s.store.reset()
+	log.Println("Demo mode: data reset on request")
+	json.NewEncoder(w).Encode(handlers.SuccessResponse{Message: "Demo data reset"})
+}
+
+// claimsUserID reads the user ID set by auth
+func claimsUserID(r *http.Request) primitive.ObjectID {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	raw, _ := claims["userID"].(string)
+	id, _ := primitive.ObjectIDFromHex(raw)
+	return id
+}
+
+// response renders a user like the real API does
+func response(u user) handlers.UserResponse {
+	return handlers.UserResponse{ID: u.ID.Hex(), Email: u.Email, Role: u.Role, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt}
+}
@@ -0,0 +1,140 @@
+package mock
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/utils"
+)
+
+// SeedPassword is the password of every seeded account
+const SeedPassword = "password123"
+
+// user is an in-memory account; passwords are kept in plain text on purpose
+// since mock mode never holds real credentials
+type user struct {
+	ID        primitive.ObjectID
+	Email     string
+	Password  string
+	Role      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// store is the in-memory user store behind mock mode. Writes change the
+// working copy in users; the seed it started from is kept for reset.
+type store struct {
+	mu    sync.RWMutex
+	seed  []user
+	users map[primitive.ObjectID]*user
+}
+
+// newStore creates a store seeded with admin@example.com and count users
+// named user1@example.com and up. IDs and timestamps are derived from the
+// position, so every run serves the same data.
+func newStore(count int) *store {
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	seed := []user{*seedUser(0, "admin@example.com", "admin", base)}
+	for i := 1; i <= count; i++ {
+		seed = append(seed, *seedUser(i, fmt.Sprintf("user%d@example.com", i), "user", base.Add(time.Duration(i)*time.Hour)))
+	}
+	return newSeededStore(seed)
+}
+
+// newSeededStore creates a store holding copies of seed
+func newSeededStore(seed []user) *store {
+	s := &store{seed: seed}
+	s.reset()
+	return s
+}
+
+// reset drops every change since the store was seeded
+func (s *store) reset() {
+	users := make(map[primitive.ObjectID]*user, len(s.seed))
+	for _, u := range s.seed {
+		u := u
+		users[u.ID] = &u
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+}
+
+func seedUser(i int, email, role string, created time.Time) *user {
+	var id primitive.ObjectID
+	copy(id[:], fmt.Sprintf("mock%08d", i))
+	return &user{ID: id, Email: email, Password: SeedPassword, Role: role, CreatedAt: created, UpdatedAt: created}
+}
+
+func (s *store) add(u *user) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+}
+
+func (s *store) get(id primitive.ObjectID) (user, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user{}, false
+	}
+	return *u, true
+}
+
+// byEmail finds a user by address, ignoring case and surrounding spaces
+func (s *store) byEmail(email string) (user, bool) {
+	email = utils.NormalizeEmail(email)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			return *u, true
+		}
+	}
+	return user{}, false
+}
+
+// update applies fn to a user under the write lock
+func (s *store) update(id primitive.ObjectID, fn func(u *user)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return false
+	}
+	fn(u)
+	u.UpdatedAt = clock.Now()
+	return true
+}
+
+func (s *store) delete(id primitive.ObjectID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return false
+	}
+	delete(s.users, id)
+	return true
+}
+
+// list returns a page of users, newest first, and the total count
+func (s *store) list(skip, limit int) ([]user, int) {
+	s.mu.RLock()
+	all := make([]user, 0, len(s.users))
+	for _, u := range s.users {
+		all = append(all, *u)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+	if skip > len(all) {
+		skip = len(all)
+	}
+	end := min(skip+limit, len(all))
+	return all[skip:end], len(all)
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminView is a named configuration of the admin user list saved by one
+// admin: which users to show, in which order and with which columns
+type AdminView struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	AdminID primitive.ObjectID `bson:"admin_id" json:"-"`
+	Name    string             `bson:"name" json:"name" example:"beta-admins"`
+	Filter  AdminViewFilter    `bson:"filter" json:"filter"`
+	// Sort is a field, descending when prefixed with "-"
+	Sort string `bson:"sort,omitempty" json:"sort,omitempty" example:"-created_at"`
+	// Columns are the user fields shown besides the id; all when empty
+	Columns   []string  `bson:"columns,omitempty" json:"columns,omitempty" example:"email,role,tags"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// AdminViewFilter selects the users of a view
+type AdminViewFilter struct {
+	Region string   `bson:"region,omitempty" json:"region,omitempty" example:"eu"`
+	Role   string   `bson:"role,omitempty" json:"role,omitempty" example:"admin"`
+	Tags   []string `bson:"tags,omitempty" json:"tags,omitempty" example:"beta"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// API key scopes grant access to user routes when calling with X-API-Key
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeReportsWrite = "reports:write"
+	ScopeEventsRead   = "events:read"
+	ScopeUploadsWrite = "uploads:write"
+	ScopeFilesRead    = "files:read"
+)
+
+// APIScopes describes every scope an API key can be granted
+var APIScopes = map[string]string{
+	ScopeProfileRead:  "Read your profile",
+	ScopeProfileWrite: "Update your profile",
+	ScopeReportsWrite: "File abuse reports",
+	ScopeEventsRead:   "Stream account change events",
+	ScopeUploadsWrite: "Upload and manage files",
+	ScopeFilesRead:    "Read files",
+}
+
+// APIKey is a long-lived credential a user creates for programmatic access.
+// Only a hash of the key is stored; the key itself is shown once on creation.
+// Keys with RequireSignature only accept requests signed with their signing
+// secret, which is stored encrypted since verifying needs it.
+type APIKey struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name             string             `bson:"name" json:"name"`
+	Prefix           string             `bson:"prefix" json:"prefix"`
+	KeyHash          string             `bson:"key_hash" json:"-"`
+	Scopes           []string           `bson:"scopes" json:"scopes"`
+	RequireSignature bool               `bson:"require_signature,omitempty" json:"require_signature"`
+	SigningSecret    string             `bson:"signing_secret,omitempty" json:"-"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt       *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt        *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// APIKeyUsage counts the requests made with a key on one UTC day
+type APIKeyUsage struct {
+	KeyID  primitive.ObjectID `bson:"key_id" json:"key_id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Day    string             `bson:"day" json:"day"`
+	Count  int64              `bson:"count" json:"count"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions that require a second admin's approval
+const (
+	ApprovalDeleteUser = "user.delete"
+	ApprovalUpdateRole = "user.role_update"
+)
+
+// Approval statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// Approval is a sensitive admin action waiting for a second admin to approve it
+type Approval struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Action      string             `bson:"action" json:"action"`
+	TargetID    string             `bson:"target_id" json:"target_id"`
+	Payload     bson.M             `bson:"payload,omitempty" json:"payload,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	RequestedBy string             `bson:"requested_by" json:"requested_by"`
+	DecidedBy   string             `bson:"decided_by,omitempty" json:"decided_by,omitempty"`
+	DecidedAt   *time.Time         `bson:"decided_at,omitempty" json:"decided_at,omitempty"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog records an administrative action together with before/after snapshots
+type AuditLog struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ActorID   string             `bson:"actor_id" json:"actor_id"`
+	Action    string             `bson:"action" json:"action"`
+	TargetID  string             `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	Before    bson.M             `bson:"before,omitempty" json:"-"`
+	After     bson.M             `bson:"after,omitempty" json:"-"`
+	IP        string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	RequestID string             `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TraceID   string             `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	// Seq, PrevHash and Hash chain entries in the order written, so edited
+	// and missing entries can be found; see audit.Verify
+	Seq      int64  `bson:"seq,omitempty" json:"seq,omitempty"`
+	PrevHash string `bson:"prev_hash,omitempty" json:"-"`
+	// RedactedAt is set when the entry's personal data was erased, leaving
+	// PersonalHashes to stand for the erased fields
+	RedactedAt     *time.Time `bson:"redacted_at,omitempty" json:"redacted_at,omitempty"`
+	PersonalHashes []string   `bson:"personal_hashes,omitempty" json:"-"`
+	PersonalHash   string     `bson:"personal_hash,omitempty" json:"-"`
+	Hash           string     `bson:"hash,omitempty" json:"hash,omitempty"`
+}
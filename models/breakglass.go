@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BreakGlassGrant is an emergency admin token issued by adminctl on a server
+// host. Only the hash of the token is stored; it can be redeemed once, for an
+// admin session that ends when the grant expires.
+type BreakGlassGrant struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	Reason     string             `bson:"reason" json:"reason"`
+	IssuedBy   string             `bson:"issued_by" json:"issued_by" example:"root@api-1"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+	RedeemedAt *time.Time         `bson:"redeemed_at,omitempty" json:"redeemed_at,omitempty"`
+	RedeemedIP string             `bson:"redeemed_ip,omitempty" json:"redeemed_ip,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
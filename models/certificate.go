@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeletionCertificate is the signed record that a user's personal data was erased
+type DeletionCertificate struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubjectHash string             `bson:"subject_hash" json:"subject_hash"`
+	RequestedBy string             `bson:"requested_by" json:"requested_by"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Affected    map[string]int64   `bson:"affected" json:"affected"`
+	CompletedAt time.Time          `bson:"completed_at" json:"completed_at"`
+	Signature   string             `bson:"signature" json:"signature"`
+}
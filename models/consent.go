@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Consent is the compliance record of a user accepting the terms at signup
+type Consent struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TermsVersion string             `bson:"terms_version" json:"terms_version"`
+	Region       string             `bson:"region,omitempty" json:"region,omitempty"`
+	MinimumAge   int                `bson:"minimum_age" json:"minimum_age"`
+	AgeVerified  bool               `bson:"age_verified" json:"age_verified"`
+	IP           string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent    string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	AcceptedAt   time.Time          `bson:"accepted_at" json:"accepted_at"`
+}
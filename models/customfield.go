@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Custom field types
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	// FieldDate values are dates in YYYY-MM-DD format
+	FieldDate = "date"
+	// FieldEnum values are one of the field's options
+	FieldEnum = "enum"
+)
+
+// CustomField is a profile field defined by the deployment's admins. Users
+// set its value through the profile endpoints; the values are stored in the
+// user's custom_fields, encrypted with the user's data key when Encrypted.
+type CustomField struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Name     string             `bson:"name" json:"name" example:"company_size"`
+	Label    string             `bson:"label,omitempty" json:"label,omitempty" example:"Company size"`
+	Type     string             `bson:"type" json:"type" example:"enum"`
+	Required bool               `bson:"required,omitempty" json:"required,omitempty"`
+	// Encrypted values cannot be filtered or reported on
+	Encrypted bool `bson:"encrypted,omitempty" json:"encrypted,omitempty"`
+
+	// MinLength, MaxLength and Pattern validate string values
+	MinLength int    `bson:"min_length,omitempty" json:"min_length,omitempty"`
+	MaxLength int    `bson:"max_length,omitempty" json:"max_length,omitempty" example:"200"`
+	Pattern   string `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	// Min and Max validate number values
+	Min *float64 `bson:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `bson:"max,omitempty" json:"max,omitempty"`
+	// Options are the values of an enum
+	Options []string `bson:"options,omitempty" json:"options,omitempty" example:"1-10,11-50,51+"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrgDomain is a custom domain serving an organization. It only resolves to
+// the organization once ownership was proven with a DNS TXT record.
+type OrgDomain struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID      primitive.ObjectID `bson:"org_id" json:"org_id"`
+	Host       string             `bson:"host" json:"host"`
+	Token      string             `bson:"token" json:"-"`
+	Verified   bool               `bson:"verified" json:"verified"`
+	VerifiedAt *time.Time         `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// File visibilities
+const (
+	FilePrivate = "private"
+	FileOrg     = "org"
+	FilePublic  = "public"
+)
+
+// File is a stored object owned by a user, created from a confirmed direct
+// upload. Private files are visible to their owner, org files to members of
+// the owner's organization and public files to every signed-in user; admins
+// see all of them.
+type File struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID     string             `bson:"owner_id" json:"owner_id"`
+	OrgID       string             `bson:"org_id,omitempty" json:"org_id,omitempty"`
+	UploadID    primitive.ObjectID `bson:"upload_id" json:"upload_id"`
+	Name        string             `bson:"name" json:"name" example:"report.pdf"`
+	Size        int64              `bson:"size" json:"size" example:"48213"`
+	Checksum    string             `bson:"checksum,omitempty" json:"checksum,omitempty" example:"9b2cf535f27731c974343645a3985328"`
+	ContentType string             `bson:"content_type" json:"content_type" example:"application/pdf"`
+	Key         string             `bson:"key" json:"-"`
+	Visibility  string             `bson:"visibility" json:"visibility" example:"private"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Import statuses
+const (
+	ImportStatusProcessing = "processing"
+	ImportStatusCompleted  = "completed"
+)
+
+// Import row statuses
+const (
+	ImportRowCreated = "created"
+	ImportRowInvited = "invited"
+	ImportRowInvalid = "invalid"
+	ImportRowFailed  = "failed"
+)
+
+// UserImport tracks a bulk user import and its per-row results
+type UserImport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	Status      string             `bson:"status" json:"status"`
+	SendInvites bool               `bson:"send_invites" json:"send_invites"`
+	Total       int                `bson:"total" json:"total"`
+	Succeeded   int                `bson:"succeeded" json:"succeeded"`
+	Failed      int                `bson:"failed" json:"failed"`
+	Rows        []ImportRow        `bson:"rows" json:"rows"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	// Undo holds the undo token (encrypted at rest) for the users created by the import
+	Undo *UndoInfo `bson:"undo,omitempty" json:"undo,omitempty"`
+}
+
+// ImportRow is the result of importing a single CSV row
+type ImportRow struct {
+	Line   int    `bson:"line" json:"line"`
+	Email  string `bson:"email" json:"email"`
+	Role   string `bson:"role" json:"role"`
+	Status string `bson:"status" json:"status"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+	UserID string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	// TempPassword is stored encrypted and only decrypted when the report is downloaded
+	TempPassword string `bson:"temp_password,omitempty" json:"temp_password,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrationRun reports one run of a startup data migration
+type MigrationRun struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name" example:"legacy_email_hash"`
+	Host       string             `bson:"host" json:"host"`
+	StartedAt  time.Time          `bson:"started_at" json:"started_at"`
+	FinishedAt time.Time          `bson:"finished_at" json:"finished_at"`
+	Scanned    int                `bson:"scanned" json:"scanned"`
+	Migrated   int                `bson:"migrated" json:"migrated"`
+	Failed     int                `bson:"failed" json:"failed"`
+	Failures   []MigrationFailure `bson:"failures,omitempty" json:"failures,omitempty"`
+	Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// MigrationFailure is a document a migration left unchanged, to be retried
+// on the next run
+type MigrationFailure struct {
+	ID     string `bson:"id" json:"id"`
+	Region string `bson:"region,omitempty" json:"region,omitempty"`
+	Reason string `bson:"reason" json:"reason"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Name filter term kinds. Reserved terms block names equal to them; profanity
+// blocks names containing them anywhere.
+const (
+	TermReserved  = "reserved"
+	TermProfanity = "profanity"
+)
+
+// NameFilterTerm is an admin-managed word that user-chosen names may not use
+type NameFilterTerm struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Term      string             `bson:"term" json:"term"`
+	Kind      string             `bson:"kind" json:"kind"`
+	BuiltIn   bool               `bson:"-" json:"built_in,omitempty"`
+	CreatedBy string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
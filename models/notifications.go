@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+	ChannelInApp = "in_app"
+)
+
+// Notification categories
+const (
+	CategorySecurity  = "security"
+	CategoryProduct   = "product"
+	CategoryMarketing = "marketing"
+)
+
+// NotificationChannels and NotificationCategories are the axes of the settings matrix
+var (
+	NotificationChannels   = []string{ChannelEmail, ChannelPush, ChannelInApp}
+	NotificationCategories = []string{CategorySecurity, CategoryProduct, CategoryMarketing}
+)
+
+// NotificationSettings maps channel to category to whether the user receives
+// it. Cells the user never set fall back to NotificationDefault.
+type NotificationSettings map[string]map[string]bool
+
+// NotificationDefault returns whether a cell is on by default and whether it
+// is forced on. Security notices always reach the user by email and in-app,
+// product news is opt-out and marketing opt-in.
+func NotificationDefault(channel, category string) (enabled, forced bool) {
+	switch category {
+	case CategorySecurity:
+		return true, channel == ChannelEmail || channel == ChannelInApp
+	case CategoryProduct:
+		return true, false
+	}
+	return false, false
+}
+
+// Allows reports whether the user receives a category on a channel
+func (s NotificationSettings) Allows(channel, category string) bool {
+	enabled, forced := NotificationDefault(channel, category)
+	if forced {
+		return true
+	}
+	if value, ok := s[channel][category]; ok {
+		return value
+	}
+	return enabled
+}
+
+// InAppNotification is a notification kept in a user's in-app inbox
+type InAppNotification struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Type      string             `bson:"type" json:"type"`
+	Category  string             `bson:"category" json:"category"`
+	Title     string             `bson:"title" json:"title"`
+	Body      string             `bson:"body,omitempty" json:"body,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Operation types that can be undone
+const (
+	OperationDeleteUser  = "delete_user"
+	OperationUpdateRole  = "update_role"
+	OperationImportUsers = "import_users"
+)
+
+// Operation is an undoable admin change, valid until ExpiresAt
+type Operation struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Type      string               `bson:"type" json:"type"`
+	ActorID   string               `bson:"actor_id" json:"actor_id"`
+	AuditID   primitive.ObjectID   `bson:"audit_id" json:"audit_id"`
+	TargetIDs []primitive.ObjectID `bson:"target_ids" json:"target_ids"`
+	TokenHash string               `bson:"token_hash" json:"-"`
+	ExpiresAt time.Time            `bson:"expires_at" json:"expires_at"`
+	UndoneAt  *time.Time           `bson:"undone_at,omitempty" json:"undone_at,omitempty"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+}
+
+// UndoInfo is returned with undoable admin operations
+type UndoInfo struct {
+	OperationID string    `bson:"operation_id" json:"operation_id"`
+	Token       string    `bson:"token" json:"token"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
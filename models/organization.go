@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Organization lifecycle statuses; an empty status is active
+const (
+	OrgActive    = "active"
+	OrgSuspended = "suspended"
+	OrgArchived  = "archived"
+	OrgDeleted   = "deleted"
+)
+
+// Organization is a tenant in multi-tenant deployments
+type Organization struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	Status       string             `bson:"status,omitempty" json:"status,omitempty" example:"suspended"`
+	StatusReason string             `bson:"status_reason,omitempty" json:"status_reason,omitempty"`
+	Region       string             `bson:"region,omitempty" json:"region,omitempty"`
+	Branding     *OrgBranding       `bson:"branding,omitempty" json:"branding,omitempty"`
+	Plan         string             `bson:"plan,omitempty" json:"plan,omitempty" example:"pro"`
+	Limits       *OrgLimits         `bson:"limits,omitempty" json:"limits,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// OrgBranding customizes emails sent to an organization's users. Empty fields
+// fall back to the deployment's defaults.
+type OrgBranding struct {
+	SenderName   string `bson:"sender_name,omitempty" json:"sender_name,omitempty" example:"Acme Support"`
+	LogoURL      string `bson:"logo_url,omitempty" json:"logo_url,omitempty" example:"https://acme.example/logo.png"`
+	PrimaryColor string `bson:"primary_color,omitempty" json:"primary_color,omitempty" example:"#d0021b"`
+}
+
+// OrgDeletionReport is the outcome of deleting an organization: every member
+// run through the forget workflow, with their deletion certificates
+type OrgDeletionReport struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	OrgID        primitive.ObjectID   `bson:"org_id" json:"org_id"`
+	RequestedBy  string               `bson:"requested_by" json:"requested_by"`
+	Reason       string               `bson:"reason,omitempty" json:"reason,omitempty"`
+	Members      int                  `bson:"members" json:"members"`
+	Forgotten    int                  `bson:"forgotten" json:"forgotten"`
+	Failed       []string             `bson:"failed,omitempty" json:"failed,omitempty"`
+	Certificates []primitive.ObjectID `bson:"certificates" json:"certificates"`
+	Affected     map[string]int64     `bson:"affected" json:"affected"`
+	StartedAt    time.Time            `bson:"started_at" json:"started_at"`
+	CompletedAt  time.Time            `bson:"completed_at" json:"completed_at"`
+}
+
+// OrgLimits overrides an organization's plan limits. Unset fields use the
+// plan's value; zero means unlimited.
+type OrgLimits struct {
+	RequestsPerMinute *int `bson:"requests_per_minute,omitempty" json:"requests_per_minute,omitempty" example:"1200"`
+	MonthlyRequests   *int `bson:"monthly_requests,omitempty" json:"monthly_requests,omitempty" example:"5000000"`
+}
+
+// OrgUsage counts an organization's requests in one calendar month
+type OrgUsage struct {
+	OrgID    string `bson:"org_id" json:"org_id"`
+	Period   string `bson:"period" json:"period" example:"2026-10"`
+	Requests int64  `bson:"requests" json:"requests"`
+}
+
+// Organization key statuses
+const (
+	KeyActive    = "active"
+	KeyRetired   = "retired"
+	KeyDestroyed = "destroyed"
+)
+
+// OrgKey is a per-organization data key, stored wrapped by the master key
+type OrgKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID       primitive.ObjectID `bson:"org_id" json:"org_id"`
+	Version     int                `bson:"version" json:"version"`
+	WrappedKey  string             `bson:"wrapped_key,omitempty" json:"-"`
+	Status      string             `bson:"status" json:"status"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	RetiredAt   *time.Time         `bson:"retired_at,omitempty" json:"retired_at,omitempty"`
+	DestroyedAt *time.Time         `bson:"destroyed_at,omitempty" json:"destroyed_at,omitempty"`
+}
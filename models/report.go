@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Abuse report statuses
+const (
+	ReportOpen      = "open"
+	ReportReviewing = "reviewing"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Abuse report reasons
+var ReportReasons = map[string]bool{
+	"spam":          true,
+	"harassment":    true,
+	"impersonation": true,
+	"inappropriate": true,
+	"other":         true,
+}
+
+// ReportTransitions lists the statuses each report status may move to
+var ReportTransitions = map[string][]string{
+	ReportOpen:      {ReportReviewing, ReportActioned, ReportDismissed},
+	ReportReviewing: {ReportActioned, ReportDismissed, ReportOpen},
+}
+
+// AbuseReport is a user's report about another account
+type AbuseReport struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ReporterID     string             `bson:"reporter_id" json:"reporter_id"`
+	ReportedUserID string             `bson:"reported_user_id" json:"reported_user_id"`
+	Reason         string             `bson:"reason" json:"reason"`
+	Details        string             `bson:"details,omitempty" json:"details,omitempty"`
+	Status         string             `bson:"status" json:"status"`
+	History        []ReportEvent      `bson:"history" json:"history"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ReportEvent records a moderation status change
+type ReportEvent struct {
+	Status    string    `bson:"status" json:"status"`
+	ActorID   string    `bson:"actor_id" json:"actor_id"`
+	Note      string    `bson:"note,omitempty" json:"note,omitempty"`
+	Suspended bool      `bson:"suspended,omitempty" json:"suspended,omitempty"`
+	At        time.Time `bson:"at" json:"at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Role grant statuses
+const (
+	// RoleGrantActive grants revert to the previous role when they expire
+	RoleGrantActive = "active"
+	// RoleGrantReverted grants expired and the previous role was restored
+	RoleGrantReverted = "reverted"
+	// RoleGrantSuperseded grants were replaced by a later role change
+	RoleGrantSuperseded = "superseded"
+)
+
+// RoleGrant is a role given to a user until ExpiresAt, after which the
+// previous role is restored
+type RoleGrant struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role         string             `bson:"role" json:"role" example:"admin"`
+	PreviousRole string             `bson:"previous_role" json:"previous_role" example:"user"`
+	GrantedBy    string             `bson:"granted_by" json:"granted_by"`
+	Status       string             `bson:"status" json:"status"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	EndedAt      *time.Time         `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// System message kinds
+const (
+	MessageMaintenance = "maintenance"
+	MessageIncident    = "incident"
+	MessageInfo        = "info"
+)
+
+// System message severities, for how prominently frontends show a banner
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// SystemMessage is an admin-managed banner shown by frontends between its
+// start and end times. Roles and OrgIDs narrow the audience, and Tags to
+// signed-in users with one of the tags; empty means everyone, including
+// visitors who are not signed in.
+type SystemMessage struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind      string             `bson:"kind" json:"kind"`
+	Severity  string             `bson:"severity" json:"severity"`
+	Title     string             `bson:"title" json:"title"`
+	Body      string             `bson:"body,omitempty" json:"body,omitempty"`
+	StartsAt  *time.Time         `bson:"starts_at,omitempty" json:"starts_at,omitempty"`
+	EndsAt    *time.Time         `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+	Roles     []string           `bson:"roles,omitempty" json:"roles,omitempty"`
+	OrgIDs    []string           `bson:"org_ids,omitempty" json:"org_ids,omitempty"`
+	Tags      []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	CreatedBy string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upload kinds, each with its own allowed content types
+const (
+	UploadCSV    = "csv"
+	UploadAvatar = "avatar"
+	UploadFile   = "file"
+)
+
+// Quarantine review statuses
+const (
+	QuarantinePending  = "pending"
+	QuarantineReleased = "released"
+	QuarantineDeleted  = "deleted"
+)
+
+// QuarantinedUpload is an upload a scanner flagged, kept for review. Content
+// is removed once it is deleted; a released upload's hash lets the same file
+// through when it is uploaded again.
+type QuarantinedUpload struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind         string             `bson:"kind" json:"kind" example:"csv"`
+	Filename     string             `bson:"filename" json:"filename" example:"users.csv"`
+	DeclaredType string             `bson:"declared_type,omitempty" json:"declared_type,omitempty" example:"text/csv"`
+	DetectedType string             `bson:"detected_type" json:"detected_type" example:"application/x-msdownload"`
+	Size         int                `bson:"size" json:"size"`
+	SHA256       string             `bson:"sha256" json:"sha256"`
+	Scanner      string             `bson:"scanner" json:"scanner" example:"mime"`
+	Reason       string             `bson:"reason" json:"reason" example:"Windows executable"`
+	UploadedBy   string             `bson:"uploaded_by,omitempty" json:"uploaded_by,omitempty"`
+	IP           string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	Status       string             `bson:"status" json:"status"`
+	ReviewedBy   string             `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewNote   string             `bson:"review_note,omitempty" json:"review_note,omitempty"`
+	ReviewedAt   *time.Time         `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	Content      []byte             `bson:"content,omitempty" json:"-"`
+}
+
+// Direct upload statuses
+const (
+	DirectUploadPending   = "pending"
+	DirectUploadConfirmed = "confirmed"
+	DirectUploadRejected  = "rejected"
+	DirectUploadExpired   = "expired"
+)
+
+// DirectUpload tracks a file a client uploads straight to object storage with
+// a presigned URL. It stays pending until the client confirms the upload and
+// the stored object passed validation.
+type DirectUpload struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       string             `bson:"user_id" json:"-"`
+	Kind         string             `bson:"kind" json:"kind" example:"avatar"`
+	Key          string             `bson:"key" json:"key" example:"uploads/6650c0ffee/6650c1a2b3/me.png"`
+	Filename     string             `bson:"filename" json:"filename" example:"me.png"`
+	ContentType  string             `bson:"content_type" json:"content_type" example:"image/png"`
+	Size         int64              `bson:"size" json:"size" example:"48213"`
+	Checksum     string             `bson:"checksum,omitempty" json:"checksum,omitempty" example:"9b2cf535f27731c974343645a3985328"`
+	DetectedType string             `bson:"detected_type,omitempty" json:"detected_type,omitempty"`
+	Status       string             `bson:"status" json:"status"`
+	Reason       string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	ConfirmedAt  *time.Time         `bson:"confirmed_at,omitempty" json:"confirmed_at,omitempty"`
+}
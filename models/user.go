@@ -15,4 +15,83 @@ type User struct {
 	Role      string             `bson:"role" json:"role"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// EmailUnverified marks users registered since email verification was
+	// added who have not followed their verification link yet; older accounts
+	// count as verified
+	EmailUnverified bool       `bson:"email_unverified,omitempty" json:"email_unverified,omitempty"`
+	EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+
+	// Phone is the encrypted number the user verified for sign-in codes,
+	// PhoneHash its blind index
+	Phone           string     `bson:"phone,omitempty" json:"-"`
+	PhoneHash       string     `bson:"phone_hash,omitempty" json:"-"`
+	PhoneVerifiedAt *time.Time `bson:"phone_verified_at,omitempty" json:"phone_verified_at,omitempty"`
+
+	// Identities are the third-party accounts the user can sign in with
+	Identities []LinkedIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+
+	// RoleVersion increases on every role change so tokens issued before it
+	// can be refreshed with the current role
+	RoleVersion int `bson:"role_version,omitempty" json:"-"`
+
+	// DisplayName is the user-chosen public name; NameFlagged marks names that a
+	// later name filter recheck no longer allows
+	DisplayName string `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	NameFlagged bool   `bson:"name_flagged,omitempty" json:"name_flagged,omitempty"`
+
+	// Locale picks the language of emails sent to the user, e.g. "de" or "pt-br"
+	Locale string `bson:"locale,omitempty" json:"locale,omitempty"`
+
+	// Notifications mutes or enables notification categories per channel
+	Notifications NotificationSettings `bson:"notifications,omitempty" json:"-"`
+
+	// OrgID links the user to an organization whose data key encrypts their fields
+	OrgID string `bson:"org_id,omitempty" json:"org_id,omitempty"`
+
+	// DateOfBirth is stored encrypted; TermsVersion is the last accepted terms version
+	DateOfBirth     string     `bson:"date_of_birth,omitempty" json:"-"`
+	TermsVersion    string     `bson:"terms_version,omitempty" json:"terms_version,omitempty"`
+	TermsAcceptedAt *time.Time `bson:"terms_accepted_at,omitempty" json:"terms_accepted_at,omitempty"`
+
+	// Suspension blocks login until lifted by an admin
+	Suspended        bool       `bson:"suspended,omitempty" json:"suspended,omitempty"`
+	SuspendedAt      *time.Time `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
+	SuspensionReason string     `bson:"suspension_reason,omitempty" json:"suspension_reason,omitempty"`
+
+	// ForgottenAt is set once the user's personal data has been erased
+	ForgottenAt *time.Time `bson:"forgotten_at,omitempty" json:"forgotten_at,omitempty"`
+
+	// DeletedAt is set when the user deleted their account. The account stays
+	// suspended until PurgeAt, when its personal data is erased.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	PurgeAt   *time.Time `bson:"purge_at,omitempty" json:"purge_at,omitempty"`
+
+	// Tags are admin-managed labels for segmenting users; users do not see them
+	Tags []string `bson:"tags,omitempty" json:"-"`
+
+	// CustomFields holds the values of the deployment's custom fields by name;
+	// encrypted ones are ciphertext
+	CustomFields map[string]interface{} `bson:"custom_fields,omitempty" json:"-"`
+
+	// Waitlist is set on users the signup gate did not activate; they cannot
+	// sign in until an admin activates them
+	Waitlist *WaitlistEntry `bson:"waitlist,omitempty" json:"waitlist,omitempty"`
+
+	// InvitationID is the invitation the user registered with
+	InvitationID *primitive.ObjectID `bson:"invitation_id,omitempty" json:"invitation_id,omitempty"`
+}
+
+// WaitlistEntry records why and when a registration was waitlisted
+type WaitlistEntry struct {
+	Rule    string    `bson:"rule" json:"rule" example:"waitlist:country!=US|CA"`
+	Country string    `bson:"country,omitempty" json:"country,omitempty" example:"DE"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// LinkedIdentity is an account at an OAuth identity provider linked to a user
+type LinkedIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
 }
@@ -0,0 +1,235 @@
+// Package namefilter keeps user-chosen names such as display names and
+// organization names free of profanity and reserved words. Names are compared
+// in a normalized form that folds case, accents, look-alike characters from
+// other scripts and common digit substitutions, so "Аdmіn" and "4dm1n" match
+// "admin".
+package namefilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang.org/x/text/unicode/norm"
+)
+
+// builtInReserved are always reserved, on top of the admin-managed list
+var builtInReserved = []string{"admin", "administrator", "root", "system", "support", "security", "moderator", "staff", "official", "help"}
+
+// reloadInterval bounds how long other instances serve a stale term list
+const reloadInterval = time.Minute
+
+// ErrNameRejected is returned by Check when a name uses a filtered term
+var ErrNameRejected = errors.New("name is not allowed")
+
+var (
+	mu       sync.RWMutex
+	terms    []models.NameFilterTerm
+	loadedAt time.Time
+)
+
+// collection holds the admin-managed terms
+const collection = "name_filter_terms"
+
+// Normalize returns the form names and terms are compared in: lowercase
+// letters and digits only, with accents stripped and confusable characters
+// and digit substitutions mapped to the Latin letter they imitate
+func Normalize(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if mapped, ok := confusables[r]; ok {
+			r = mapped
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Check returns ErrNameRejected when name equals a reserved term or contains
+// profanity, and nil otherwise
+func Check(ctx context.Context, name string) error {
+	list, err := Terms(ctx)
+	if err != nil {
+		return err
+	}
+	if matches(Normalize(name), list) {
+		return ErrNameRejected
+	}
+	return nil
+}
+
+// matches reports whether a normalized name hits any term
+func matches(normalized string, list []models.NameFilterTerm) bool {
+	if normalized == "" {
+		return false
+	}
+	for _, term := range list {
+		t := Normalize(term.Term)
+		if t == "" {
+			continue
+		}
+		if term.Kind == models.TermReserved && normalized == t {
+			return true
+		}
+		if term.Kind == models.TermProfanity && strings.Contains(normalized, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Terms returns the built-in and admin-managed terms, reloading the managed
+// ones when the cached copy is older than reloadInterval
+func Terms(ctx context.Context) ([]models.NameFilterTerm, error) {
+	mu.RLock()
+	list, fresh := terms, time.Since(loadedAt) < reloadInterval
+	mu.RUnlock()
+	if fresh {
+		return list, nil
+	}
+	return Reload(ctx)
+}
+
+// Reload reads the managed terms from the database, e.g. after an admin change
+func Reload(ctx context.Context) ([]models.NameFilterTerm, error) {
+	list := make([]models.NameFilterTerm, 0, len(builtInReserved))
+	for _, term := range builtInReserved {
+		list = append(list, models.NameFilterTerm{Term: term, Kind: models.TermReserved, BuiltIn: true})
+	}
+
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"term": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var managed []models.NameFilterTerm
+	if err := cursor.All(ctx, &managed); err != nil {
+		return nil, err
+	}
+	list = append(list, managed...)
+
+	mu.Lock()
+	terms, loadedAt = list, time.Now()
+	mu.Unlock()
+	return list, nil
+}
+
+// ErrDuplicateTerm is returned by Add when the term is already listed
+var ErrDuplicateTerm = errors.New("term already listed")
+
+// Add stores a managed term and reloads the list
+func Add(ctx context.Context, term, kind, createdBy string) (*models.NameFilterTerm, error) {
+	list, err := Terms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	normalized := Normalize(term)
+	for _, existing := range list {
+		if existing.Kind == kind && Normalize(existing.Term) == normalized {
+			return nil, ErrDuplicateTerm
+		}
+	}
+
+	entry := models.NameFilterTerm{ID: clock.NewID(), Term: term, Kind: kind, CreatedBy: createdBy, CreatedAt: clock.Now()}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, entry); err != nil {
+		return nil, err
+	}
+	_, err = Reload(ctx)
+	return &entry, err
+}
+
+// Remove deletes a managed term and reloads the list. Built-in terms cannot be
+// removed. It returns mongo.ErrNoDocuments when no managed term has the ID.
+func Remove(ctx context.Context, id primitive.ObjectID) (*models.NameFilterTerm, error) {
+	var removed models.NameFilterTerm
+	if err := database.DB.Collection(collection).FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&removed); err != nil {
+		return nil, err
+	}
+	_, err := Reload(ctx)
+	return &removed, err
+}
+
+// RecheckResult summarizes a Recheck run
+type RecheckResult struct {
+	Checked    int      `json:"checked"`
+	Flagged    int      `json:"flagged"`
+	Cleared    int      `json:"cleared"`
+	FlaggedIDs []string `json:"flagged_ids"`
+}
+
+// Recheck runs every user's display name in every region against the current
+// list, setting name_flagged on users whose name is no longer allowed and
+// clearing it on users whose name now passes
+func Recheck(ctx context.Context) (*RecheckResult, error) {
+	list, err := Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RecheckResult{FlaggedIDs: []string{}}
+	filter := bson.M{"display_name": bson.M{"$exists": true, "$ne": ""}}
+	opts := options.Find().SetProjection(bson.M{"display_name": 1, "name_flagged": 1})
+
+	for region, db := range database.Regions {
+		users := db.Collection("users")
+		cursor, err := users.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+
+		var docs []models.User
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+
+		for _, user := range docs {
+			result.Checked++
+			flagged := matches(Normalize(user.DisplayName), list)
+			if flagged {
+				result.Flagged++
+				result.FlaggedIDs = append(result.FlaggedIDs, user.ID.Hex())
+			}
+			if flagged == user.NameFlagged {
+				continue
+			}
+			if !flagged {
+				result.Cleared++
+			}
+			if _, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"name_flagged": flagged}}); err != nil {
+				return nil, fmt.Errorf("region %s: %w", region, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// confusables maps characters that look like Latin letters, and digits and
+// symbols commonly used in their place, to those letters
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ї': 'i', 'ј': 'j',
+	'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'һ': 'h',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin look-alikes NFKD leaves alone
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ß': 's',
+	// Digit and symbol substitutions
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i', '|': 'l',
+}
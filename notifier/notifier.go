@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/config"
+)
+
+// Severity levels for events
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Event is a notification delivered to the configured webhooks
+type Event struct {
+	Type     string                 `json:"type"`
+	Severity string                 `json:"severity"`
+	Message  string                 `json:"message"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// Notifier delivers events to webhook endpoints and the application log
+type Notifier struct {
+	webhooks []string
+	secret   string
+	client   *http.Client
+}
+
+// New creates a notifier for the webhooks configured in cfg
+func New(cfg *config.Config) *Notifier {
+	return &Notifier{
+		webhooks: cfg.WebhookURLs,
+		secret:   cfg.WebhookSecret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send logs the event and posts it to every webhook. Deliveries run in the
+// background so callers are never blocked by slow receivers.
+func (n *Notifier) Send(event Event) {
+	if event.Time.IsZero() {
+		event.Time = clock.Now()
+	}
+	log.Printf("notifier: [%s] %s: %s", event.Severity, event.Type, event.Message)
+
+	if len(n.webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("notifier: failed to encode event %s: %v", event.Type, err)
+		return
+	}
+
+	for _, url := range n.webhooks {
+		go n.post(url, body)
+	}
+}
+
+// post delivers a single webhook, signing the body when a secret is configured
+func (n *Notifier) post(url string, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("notifier: invalid webhook URL %s: %v", url, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		log.Printf("notifier: webhook %s failed: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("notifier: webhook %s returned %d", url, resp.StatusCode)
+	}
+}
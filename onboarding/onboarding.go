@@ -0,0 +1,165 @@
+// Package onboarding computes the checklist onboarding UIs walk new users
+// through, such as verifying the email address or creating an API key. Each
+// step is a rule evaluated against the user's account when the checklist is
+// requested; ONBOARDING_STEPS picks the steps, their order and their weight
+// in the completion percentage.
+package onboarding
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/customfields"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/repository"
+)
+
+// Step is one item of the checklist
+type Step struct {
+	ID     string `json:"id" example:"email_verified"`
+	Title  string `json:"title" example:"Verify your email address"`
+	Done   bool   `json:"done"`
+	Weight int    `json:"weight" example:"1"`
+}
+
+// Checklist is the onboarding progress of a user. Percent is the share of
+// the steps' weight done, rounded down.
+type Checklist struct {
+	Steps     []Step `json:"steps"`
+	Completed int    `json:"completed" example:"2"`
+	Total     int    `json:"total" example:"3"`
+	Percent   int    `json:"percent" example:"66"`
+}
+
+// rule checks one step against a user loaded with its fields
+type rule struct {
+	title  string
+	fields []string
+	check  func(ctx context.Context, user *models.User) (bool, error)
+}
+
+// rules are the steps ONBOARDING_STEPS can name
+var rules = map[string]rule{
+	"email_verified": {
+		title:  "Verify your email address",
+		fields: []string{"email_unverified"},
+		check: func(ctx context.Context, user *models.User) (bool, error) {
+			return !user.EmailUnverified, nil
+		},
+	},
+	"password": {
+		title:  "Set a password",
+		fields: []string{"password"},
+		check: func(ctx context.Context, user *models.User) (bool, error) {
+			return user.Password != "", nil
+		},
+	},
+	"profile": {
+		title:  "Complete your profile",
+		fields: []string{"display_name", "custom_fields"},
+		check:  profileComplete,
+	},
+	"api_key": {
+		title: "Create your first API key",
+		check: func(ctx context.Context, user *models.User) (bool, error) {
+			count, err := database.DB.Collection("api_keys").CountDocuments(ctx, bson.M{"user_id": user.ID})
+			return count > 0, err
+		},
+	},
+}
+
+// configured is a step of the checklist with its weight
+type configured struct {
+	id     string
+	weight int
+}
+
+// steps are the configured steps, in order
+var steps []configured
+
+// Init reads the steps of the checklist. Unknown steps are logged and left
+// out.
+func Init(cfg *config.Config) {
+	steps = nil
+	for _, entry := range cfg.OnboardingSteps {
+		id, weightStr, hasWeight := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || w < 1 {
+				log.Printf("onboarding: invalid weight in step %q, ignoring the step", entry)
+				continue
+			}
+			weight = w
+		}
+		if _, ok := rules[id]; !ok {
+			log.Printf("onboarding: unknown step %q, ignoring", id)
+			continue
+		}
+		steps = append(steps, configured{id: id, weight: weight})
+	}
+}
+
+// For computes the checklist of a user. It returns mongo.ErrNoDocuments
+// when the user does not exist.
+func For(ctx context.Context, userID primitive.ObjectID) (*Checklist, error) {
+	var fields []string
+	for _, step := range steps {
+		fields = append(fields, rules[step.id].fields...)
+	}
+	projection := repository.IDOnly
+	if len(fields) > 0 {
+		projection = repository.Fields(fields...)
+	}
+	user, _, err := repository.FindUser(ctx, bson.M{"_id": userID}, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	checklist := &Checklist{Steps: []Step{}, Total: len(steps)}
+	var done, total int
+	for _, step := range steps {
+		r := rules[step.id]
+		ok, err := r.check(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		checklist.Steps = append(checklist.Steps, Step{ID: step.id, Title: r.title, Done: ok, Weight: step.weight})
+		total += step.weight
+		if ok {
+			checklist.Completed++
+			done += step.weight
+		}
+	}
+	if total > 0 {
+		checklist.Percent = done * 100 / total
+	} else {
+		checklist.Percent = 100
+	}
+	return checklist, nil
+}
+
+// profileComplete reports whether the user chose a display name and set
+// every required custom field
+func profileComplete(ctx context.Context, user *models.User) (bool, error) {
+	if user.DisplayName == "" {
+		return false, nil
+	}
+	schema, err := customfields.Schema(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, field := range schema {
+		if _, ok := user.CustomFields[field.Name]; field.Required && !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,198 @@
+// Package otp issues the six-digit codes texted for phone sign-in and for
+// verifying a new phone number. A code is stored only as a hash in
+// otp_codes, expires after OTP_TTL and works once; requesting a new code for
+// the same purpose and key replaces the old. A key allows OTP_MAX_ATTEMPTS
+// guesses per OTP_TTL, counted across the codes sent to it, so requesting
+// new codes does not buy more guesses.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// collection holds unused codes by purpose and key
+const collection = "otp_codes"
+
+// Purposes of a code
+const (
+	// PurposeLogin codes sign in the account with a phone number, keyed by
+	// the number's hash
+	PurposeLogin = "login"
+	// PurposePhone codes prove a user owns the number they are adding, keyed
+	// by user ID
+	PurposePhone = "phone"
+)
+
+var (
+	// ErrInvalidCode is returned for wrong, used and expired codes, and once
+	// a code's guesses are used up
+	ErrInvalidCode = errors.New("invalid or expired code")
+	// ErrCooldown is returned by Issue when a code was sent moments ago
+	ErrCooldown = errors.New("a code was sent recently")
+	// ErrTooManyAttempts is returned by Issue when the key's guesses are
+	// used up, until OTP_TTL after they started
+	ErrTooManyAttempts = errors.New("too many wrong codes")
+)
+
+// Code is an unused code
+type Code struct {
+	ID        string             `bson:"_id"`
+	Purpose   string             `bson:"purpose"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	PhoneHash string             `bson:"phone_hash"`
+	// Phone is the encrypted number a PurposePhone code was sent to
+	Phone     string    `bson:"phone,omitempty"`
+	CodeHash string `bson:"code_hash"`
+	// Attempts counts the key's guesses since AttemptsSince, across codes
+	Attempts      int       `bson:"attempts"`
+	AttemptsSince time.Time `bson:"attempts_since"`
+	CreatedAt     time.Time `bson:"created_at"`
+	ExpiresAt     time.Time `bson:"expires_at"`
+}
+
+var (
+	ttl         time.Duration
+	cooldown    time.Duration
+	maxAttempts int
+)
+
+// Init reads the code settings and creates the TTL index that drops expired
+// codes
+func Init(cfg *config.Config) {
+	ttl, cooldown, maxAttempts = cfg.OTPTTL, cfg.OTPCooldown, cfg.OTPMaxAttempts
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"user_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("otp: failed to create indexes: %v", err)
+	}
+}
+
+// TTL returns how long issued codes are valid
+func TTL() time.Duration {
+	return ttl
+}
+
+// Issue creates a code for a purpose and key, voiding any earlier one, and
+// returns it. It returns ErrCooldown instead when the last code is younger
+// than OTP_COOLDOWN, and ErrTooManyAttempts when the key's guesses are used
+// up. The guesses made on earlier codes carry over to the new one.
+func Issue(ctx context.Context, purpose, key string, userID primitive.ObjectID, phoneHash, phone string) (string, error) {
+	codes := database.DB.Collection(collection)
+	now := clock.Now()
+	id := purpose + ":" + key
+
+	var prev Code
+	err := codes.FindOne(ctx, bson.M{"_id": id}).Decode(&prev)
+	exists := err == nil
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	if exists && prev.CreatedAt.After(now.Add(-cooldown)) {
+		return "", ErrCooldown
+	}
+	attempts, since := 0, now
+	if exists && now.Before(prev.AttemptsSince.Add(ttl)) {
+		attempts, since = prev.Attempts, prev.AttemptsSince
+	}
+	if attempts >= maxAttempts {
+		return "", ErrTooManyAttempts
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	doc := Code{
+		ID:            id,
+		Purpose:       purpose,
+		UserID:        userID,
+		PhoneHash:     phoneHash,
+		Phone:         phone,
+		CodeHash:      hashCode(id, code),
+		Attempts:      attempts,
+		AttemptsSince: since,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	}
+	// Replaced only while no guess or other code came in since it was read;
+	// otherwise the upsert collides with the changed code
+	filter := bson.M{"_id": id}
+	if exists {
+		filter["attempts"], filter["code_hash"] = prev.Attempts, prev.CodeHash
+	}
+	_, err = codes.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrCooldown
+	} else if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Redeem consumes the code of a purpose and key and returns it. Each call
+// uses up one guess, before the code is compared, so concurrent guesses
+// cannot exceed OTP_MAX_ATTEMPTS; only one request can redeem a code. A code
+// whose guesses are used up is kept until it expires, so the count carries
+// over to the next code.
+func Redeem(ctx context.Context, purpose, key, code string) (*Code, error) {
+	codes := database.DB.Collection(collection)
+	id := purpose + ":" + key
+
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": clock.Now()}, "attempts": bson.M{"$lt": maxAttempts}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var doc Code
+	err := codes.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(id, code)), []byte(doc.CodeHash)) != 1 {
+		return nil, ErrInvalidCode
+	}
+	result, err := codes.DeleteOne(ctx, bson.M{"_id": id, "code_hash": doc.CodeHash})
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == 0 {
+		return nil, ErrInvalidCode
+	}
+	return &doc, nil
+}
+
+// Purge deletes the unused codes of a user
+func Purge(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := database.DB.Collection(collection).DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// hashCode binds a code to its purpose and key, so equal codes hash apart
+func hashCode(id, code string) string {
+	return utils.HashToken(id + ":" + code)
+}
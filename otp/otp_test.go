@@ -0,0 +1,121 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/dbtest"
+)
+
+// start sets up codes valid for five minutes with three guesses, under a
+// clock the test moves
+func start(t *testing.T) *clock.Fixed {
+	t.Helper()
+	dbtest.Start(t)
+	now := clock.NewFixed(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(now, nil)
+	t.Cleanup(func() { clock.Set(nil, nil) })
+	Init(&config.Config{OTPTTL: 5 * time.Minute, OTPCooldown: 30 * time.Second, OTPMaxAttempts: 3})
+	return now
+}
+
+func issue(t *testing.T, key string) string {
+	t.Helper()
+	code, err := Issue(context.Background(), PurposeLogin, key, primitive.NewObjectID(), key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wrong returns a code other than code
+func wrong(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+func TestRedeemOnce(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	code := issue(t, "phone")
+	if _, err := Redeem(ctx, PurposeLogin, "phone", code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Redeem(ctx, PurposeLogin, "phone", code); err != ErrInvalidCode {
+		t.Fatalf("second redeem: %v, want ErrInvalidCode", err)
+	}
+	if _, err := Redeem(ctx, PurposePhone, "phone", code); err != ErrInvalidCode {
+		t.Fatalf("other purpose: %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestRedeemExpired(t *testing.T) {
+	now := start(t)
+	code := issue(t, "phone")
+	now.Advance(5*time.Minute + time.Second)
+	if _, err := Redeem(context.Background(), PurposeLogin, "phone", code); err != ErrInvalidCode {
+		t.Fatalf("got %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestIssueCooldown(t *testing.T) {
+	now := start(t)
+	issue(t, "phone")
+	if _, err := Issue(context.Background(), PurposeLogin, "phone", primitive.NewObjectID(), "phone", ""); err != ErrCooldown {
+		t.Fatalf("got %v, want ErrCooldown", err)
+	}
+	now.Advance(31 * time.Second)
+	issue(t, "phone")
+}
+
+func TestAttemptsCarryOverNewCodes(t *testing.T) {
+	now := start(t)
+	ctx := context.Background()
+	code := issue(t, "phone")
+	for i := 0; i < 2; i++ {
+		if _, err := Redeem(ctx, PurposeLogin, "phone", wrong(code)); err != ErrInvalidCode {
+			t.Fatalf("guess %d: %v", i, err)
+		}
+	}
+
+	// A new code leaves one guess, then none
+	now.Advance(time.Minute)
+	code = issue(t, "phone")
+	if _, err := Redeem(ctx, PurposeLogin, "phone", wrong(code)); err != ErrInvalidCode {
+		t.Fatal(err)
+	}
+	if _, err := Redeem(ctx, PurposeLogin, "phone", code); err != ErrInvalidCode {
+		t.Fatalf("right code after the guesses ran out: %v", err)
+	}
+	now.Advance(time.Minute)
+	if _, err := Issue(ctx, PurposeLogin, "phone", primitive.NewObjectID(), "phone", ""); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("got %v, want ErrTooManyAttempts", err)
+	}
+
+	// The guesses come back once OTP_TTL has passed since the first code
+	now.Advance(3*time.Minute + time.Second)
+	code = issue(t, "phone")
+	if _, err := Redeem(ctx, PurposeLogin, "phone", code); err != nil {
+		t.Fatalf("after the window: %v", err)
+	}
+}
+
+func TestAttemptsAreKeptPerKey(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	code := issue(t, "a")
+	for i := 0; i < 3; i++ {
+		Redeem(ctx, PurposeLogin, "a", wrong(code))
+	}
+	code = issue(t, "b")
+	if _, err := Redeem(ctx, PurposeLogin, "b", code); err != nil {
+		t.Fatalf("other key: %v", err)
+	}
+}
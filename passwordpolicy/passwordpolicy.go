@@ -0,0 +1,135 @@
+// Package passwordpolicy checks new passwords against the configured policy:
+// a minimum and maximum length, required character classes, and a list of
+// common passwords that are rejected however long they are.
+package passwordpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang-backend/config"
+)
+
+// Violation codes
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeMissingLower  = "missing_lowercase"
+	CodeMissingUpper  = "missing_uppercase"
+	CodeMissingDigit  = "missing_digit"
+	CodeMissingSymbol = "missing_symbol"
+	CodeCommon        = "common_password"
+)
+
+// Character classes of PASSWORD_REQUIRED_CLASSES
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// Violation is a rule a password breaks
+type Violation struct {
+	Code    string `json:"code" example:"too_short"`
+	Message string `json:"message" example:"Password must be at least 8 characters"`
+}
+
+// classes are the character classes a password can be required to use
+var classes = map[string]struct {
+	code    string
+	message string
+	has     func(rune) bool
+}{
+	ClassLower:  {CodeMissingLower, "Password must contain a lowercase letter", unicode.IsLower},
+	ClassUpper:  {CodeMissingUpper, "Password must contain an uppercase letter", unicode.IsUpper},
+	ClassDigit:  {CodeMissingDigit, "Password must contain a digit", unicode.IsDigit},
+	ClassSymbol: {CodeMissingSymbol, "Password must contain a symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }},
+}
+
+//go:embed common.txt
+var builtInCommon string
+
+// policy is set by Init; the zero value only rejects empty passwords
+var policy struct {
+	minLength int
+	maxLength int
+	required  []string
+	common    map[string]bool
+}
+
+// Init loads the policy from the configuration. Unknown character classes
+// and an unreadable deny list are logged and ignored.
+func Init(cfg *config.Config) {
+	policy.minLength = cfg.PasswordMinLength
+	policy.maxLength = cfg.PasswordMaxLength
+	policy.required = nil
+	for _, class := range cfg.PasswordRequiredClasses {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if _, ok := classes[class]; !ok {
+			log.Printf("passwordpolicy: unknown character class %q in PASSWORD_REQUIRED_CLASSES, ignoring", class)
+			continue
+		}
+		policy.required = append(policy.required, class)
+	}
+
+	policy.common = nil
+	if !cfg.PasswordDenyCommon {
+		return
+	}
+	policy.common = make(map[string]bool)
+	addCommon(strings.NewReader(builtInCommon))
+	if cfg.PasswordDenylistFile != "" {
+		f, err := os.Open(cfg.PasswordDenylistFile)
+		if err != nil {
+			log.Printf("passwordpolicy: failed to read PASSWORD_DENYLIST_FILE: %v", err)
+			return
+		}
+		defer f.Close()
+		addCommon(f)
+	}
+}
+
+// addCommon adds the passwords of a list, one per line
+func addCommon(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			policy.common[strings.ToLower(line)] = true
+		}
+	}
+}
+
+// Check returns the rules a new password breaks, or nil when it meets the
+// policy. The maximum length is counted in bytes, as bcrypt ignores the
+// rest of a longer password.
+func Check(password string) []Violation {
+	var violations []Violation
+	minLength := policy.minLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	if n := utf8.RuneCountInString(password); n < minLength {
+		violations = append(violations, Violation{CodeTooShort, fmt.Sprintf("Password must be at least %d characters", minLength)})
+	}
+	if policy.maxLength > 0 && len(password) > policy.maxLength {
+		violations = append(violations, Violation{CodeTooLong, fmt.Sprintf("Password must be at most %d bytes", policy.maxLength)})
+	}
+	for _, name := range policy.required {
+		class := classes[name]
+		if strings.IndexFunc(password, class.has) < 0 {
+			violations = append(violations, Violation{class.code, class.message})
+		}
+	}
+	if policy.common[strings.ToLower(password)] {
+		violations = append(violations, Violation{CodeCommon, "Password is too common"})
+	}
+	return violations
+}
@@ -0,0 +1,50 @@
+// Package passwordreset issues the tokens emailed by the forgotten password
+// flow. A token expires after PASSWORD_RESET_TTL and works once; requesting
+// a new one replaces the old. See singleuse.
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/singleuse"
+)
+
+var (
+	// ErrInvalidToken is returned for unknown, used and expired tokens
+	ErrInvalidToken = errors.New("invalid or expired reset token")
+	// ErrCooldown is returned by Issue when the user was sent a token moments
+	// ago
+	ErrCooldown = errors.New("a reset token was sent recently")
+)
+
+// Reset is a pending reset token
+type Reset = singleuse.Token
+
+var store = singleuse.Store{Collection: "password_resets", ErrInvalid: ErrInvalidToken, ErrCooldown: ErrCooldown}
+
+// Init reads the token lifetime and cooldown and creates the indexes
+func Init(cfg *config.Config) {
+	store.TTL, store.Cooldown = cfg.PasswordResetTTL, cfg.PasswordResetCooldown
+	store.Init()
+}
+
+// TTL returns how long issued tokens are valid
+func TTL() time.Duration {
+	return store.TTL
+}
+
+// Issue creates a reset token for a user, voiding any earlier one. It returns
+// ErrCooldown instead when the last token is younger than
+// PASSWORD_RESET_COOLDOWN.
+func Issue(ctx context.Context, userID primitive.ObjectID) (string, error) {
+	return store.Issue(ctx, userID, "")
+}
+
+// Redeem consumes a token and returns it
+func Redeem(ctx context.Context, raw string) (*Reset, error) {
+	return store.Redeem(ctx, raw)
+}
@@ -0,0 +1,278 @@
+// Package permissions maps roles to the permissions their sessions hold. The
+// mapping is stored in role_permissions, one document per role, seeded on
+// first start with the admin role holding every permission and the user role
+// none, and edited by admins through /admin/permissions. Session tokens carry
+// the permissions of the user's role in their permissions claim, and
+// middleware.RequirePermission guards every admin route by them. Changing a role's
+// permissions bumps the role version of its users, so their tokens are
+// refreshed as after a role change.
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// collection holds the permissions of each role
+const collection = "role_permissions"
+
+// Permissions guarding admin routes. Every admin route checks one.
+const (
+	UsersRead         = "users:read"
+	UsersManage       = "users:manage"
+	UsersTag          = "users:tag"
+	UsersDelete       = "users:delete"
+	UsersRole         = "users:role"
+	UsersImport       = "users:import"
+	UsersExport       = "users:export"
+	UsersForget       = "users:forget"
+	UsersCredentials  = "users:credentials"
+	OrgsRead          = "orgs:read"
+	OrgsManage        = "orgs:manage"
+	OrgsExport        = "orgs:export"
+	OrgsKeys          = "orgs:keys"
+	OrgsDelete        = "orgs:delete"
+	ApprovalsManage   = "approvals:manage"
+	ViewsManage       = "views:manage"
+	FieldsManage      = "fields:manage"
+	ReportsRead       = "reports:read"
+	ReportsManage     = "reports:manage"
+	SecurityRead      = "security:read"
+	UploadsReview     = "uploads:review"
+	SystemRead        = "system:read"
+	JobsRun           = "jobs:run"
+	LogsManage        = "logs:manage"
+	NamesManage       = "names:manage"
+	MessagesManage    = "messages:manage"
+	ChaosManage       = "chaos:manage"
+	TokensRevoke      = "tokens:revoke"
+	JWTRotate         = "jwt:rotate"
+	DatabaseCutover   = "database:cutover"
+	AuditVerify       = "audit:verify"
+	InvitationsCreate = "invitations:create"
+	InvitationsManage = "invitations:manage"
+	PermissionsManage = "permissions:manage"
+)
+
+// All lists every permission
+var All = []string{
+	UsersRead, UsersManage, UsersTag, UsersDelete, UsersRole, UsersImport, UsersExport, UsersForget, UsersCredentials,
+	OrgsRead, OrgsManage, OrgsExport, OrgsKeys, OrgsDelete,
+	ApprovalsManage, ViewsManage, FieldsManage, ReportsRead, ReportsManage,
+	SecurityRead, UploadsReview, SystemRead, JobsRun, LogsManage, NamesManage, MessagesManage, ChaosManage,
+	TokensRevoke, JWTRotate,
+	DatabaseCutover, AuditVerify, InvitationsCreate, InvitationsManage, PermissionsManage,
+}
+
+// Wildcard grants every permission; "users:*" grants every users permission
+const Wildcard = "*"
+
+// Roles are the roles with a mapping
+var Roles = []string{"user", "admin"}
+
+// defaults seed the mapping of roles that have no document yet
+var defaults = map[string][]string{
+	"admin": {Wildcard},
+	"user":  {},
+}
+
+var (
+	// ErrUnknownRole is returned for roles outside Roles
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrInvalidPermission is returned for names that grant nothing
+	ErrInvalidPermission = errors.New("invalid permission")
+)
+
+// RolePermissions is the role_permissions document of a role
+type RolePermissions struct {
+	Role        string    `bson:"_id" json:"role" example:"admin"`
+	Permissions []string  `bson:"permissions" json:"permissions" example:"users:*,audit:verify"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+	UpdatedBy   string    `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	cached   = make(map[string][]string)
+	loadedAt time.Time
+	cacheTTL time.Duration
+)
+
+// Init seeds the default mapping of roles that have none. Mappings are cached
+// for PermissionCacheTTL.
+func Init(cfg *config.Config) {
+	cacheTTL = cfg.PermissionCacheTTL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, role := range Roles {
+		update := bson.M{"$setOnInsert": bson.M{"permissions": defaults[role], "updated_at": clock.Now()}}
+		_, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": role}, update, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Printf("permissions: failed to seed %s role: %v", role, err)
+		}
+	}
+}
+
+// For returns the permissions of a role, from cache when fresh. Unknown
+// roles have none.
+func For(ctx context.Context, role string) ([]string, error) {
+	mu.RLock()
+	if clock.Now().Sub(loadedAt) < cacheTTL {
+		perms := cached[role]
+		mu.RUnlock()
+		return perms, nil
+	}
+	mu.RUnlock()
+
+	mappings, err := List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mappings[role], nil
+}
+
+// Load returns the stored permissions of a role, bypassing the cache, for
+// tokens that will carry them until they expire
+func Load(ctx context.Context, role string) ([]string, error) {
+	var doc RolePermissions
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": role}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return []string{}, nil
+	}
+	return doc.Permissions, err
+}
+
+// List returns the permissions of every role and refreshes the cache
+func List(ctx context.Context) (map[string][]string, error) {
+	docs, err := Documents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mappings := make(map[string][]string, len(docs))
+	for _, doc := range docs {
+		mappings[doc.Role] = doc.Permissions
+	}
+	mu.Lock()
+	cached, loadedAt = mappings, clock.Now()
+	mu.Unlock()
+	return mappings, nil
+}
+
+// Documents returns the stored mapping of every role
+func Documents(ctx context.Context) ([]RolePermissions, error) {
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	docs := []RolePermissions{}
+	err = cursor.All(ctx, &docs)
+	return docs, err
+}
+
+// Set replaces the permissions of a role and bumps the role version of its
+// users in every region, so their sessions pick up the change. It returns the
+// previous permissions and how many users were affected.
+func Set(ctx context.Context, role string, perms []string, actorID string) ([]string, int64, error) {
+	if !knownRole(role) {
+		return nil, 0, ErrUnknownRole
+	}
+	for _, p := range perms {
+		if !Valid(p) {
+			return nil, 0, fmt.Errorf("%w: %q", ErrInvalidPermission, p)
+		}
+	}
+	if perms == nil {
+		perms = []string{}
+	}
+
+	var before RolePermissions
+	update := bson.M{"$set": bson.M{"permissions": perms, "updated_at": clock.Now(), "updated_by": actorID}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	err := database.DB.Collection(collection).FindOneAndUpdate(ctx, bson.M{"_id": role}, update, opts).Decode(&before)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, 0, err
+	}
+	mu.Lock()
+	loadedAt = time.Time{}
+	mu.Unlock()
+
+	var affected int64
+	for region, db := range database.Regions {
+		result, err := db.Collection("users").UpdateMany(ctx, bson.M{"role": role}, bson.M{"$inc": bson.M{"role_version": 1}})
+		if err != nil {
+			return before.Permissions, affected, fmt.Errorf("region %s: %w", region, err)
+		}
+		affected += result.ModifiedCount
+	}
+	return before.Permissions, affected, nil
+}
+
+func knownRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether a name grants anything: a permission of All, a
+// resource wildcard such as "users:*", or Wildcard
+func Valid(name string) bool {
+	if name == Wildcard {
+		return true
+	}
+	for _, p := range All {
+		if p == name {
+			return true
+		}
+		if resource, _, _ := strings.Cut(p, ":"); name == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Held reads the permissions claim of session claims, a JSON array once a
+// token is parsed and a string slice when the claims were built on this
+// request
+func Held(claims map[string]interface{}) []string {
+	switch perms := claims["permissions"].(type) {
+	case []string:
+		return perms
+	case []interface{}:
+		held := make([]string, 0, len(perms))
+		for _, p := range perms {
+			if s, ok := p.(string); ok {
+				held = append(held, s)
+			}
+		}
+		return held
+	}
+	return nil
+}
+
+// Grants reports whether held permissions include permission, directly or
+// through a wildcard
+func Grants(held []string, permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+	for _, h := range held {
+		if h == permission || h == Wildcard || h == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/config"
+	"golang-backend/security"
+)
+
+// bucketStore takes a token from the bucket of a key, or returns how long
+// until one is available
+type bucketStore interface {
+	take(key string, perSecond float64, burst int) (time.Duration, error)
+}
+
+var (
+	clientPerMinute int
+	clientBurst     int
+	clientBuckets   bucketStore
+)
+
+// initClients selects the bucket store for client limits
+func initClients(cfg *config.Config) {
+	clientPerMinute = cfg.AuthRatePerMinute
+	clientBurst = cfg.AuthRateBurst
+	if clientBurst <= 0 {
+		clientBurst = clientPerMinute
+	}
+	switch cfg.AuthRateStore {
+	case "redis":
+		clientBuckets = &redisBuckets{store: cache.NewRedisStore(cfg.RedisAddr)}
+	case "memory", "":
+		clientBuckets = newMemoryBuckets()
+	default:
+		log.Printf("ratelimit: unknown AUTH_RATE_STORE %q, client limits disabled", cfg.AuthRateStore)
+	}
+}
+
+// PerClient throttles an endpoint worth brute-forcing, such as /login, per
+// client IP. Each IP gets a token bucket per endpoint holding AuthRateBurst
+// attempts and refilling at AuthRatePerMinute; clients that used it up are
+// answered with 429 and Retry-After. Buckets live in memory, or in Redis so
+// every instance draws from the same one. Store errors let the request
+// through.
+func PerClient(endpoint string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if clientBuckets == nil || clientPerMinute <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := audit.ClientIP(r)
+			wait, err := clientBuckets.take(endpoint+":"+ip, float64(clientPerMinute)/60, clientBurst)
+			if err != nil {
+				log.Printf("ratelimit: failed to check %s attempts from %s: %v", endpoint, ip, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait > 0 {
+				security.Emit(r, security.EventRateLimited, security.OutcomeFailure, "", endpoint+" attempts from "+ip)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many attempts, please retry later", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// memoryBuckets keeps the buckets of this instance. Full buckets are
+// dropped periodically, since they behave like missing ones.
+type memoryBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+func newMemoryBuckets() *memoryBuckets {
+	m := &memoryBuckets{buckets: make(map[string]*bucket)}
+	go func() {
+		for range time.Tick(time.Minute) {
+			m.sweep()
+		}
+	}()
+	return m
+}
+
+func (m *memoryBuckets) take(key string, perSecond float64, burst int) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / perSecond * float64(time.Second)))
+	return 0, nil
+}
+
+func (m *memoryBuckets) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, b := range m.buckets {
+		if now.After(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// takeScript refills and takes from a bucket stored as a hash, on the Redis
+// server's clock, and returns the milliseconds to wait (0 when taken). The
+// key expires once the bucket would be full again.
+const takeScript = `
+local rate = tonumber(ARGV[1]) / 1000
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - last) * rate)
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate)
+else
+	tokens = tokens - 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return wait
+`
+
+// redisBuckets keeps buckets in Redis, shared by every instance
+type redisBuckets struct {
+	store *cache.RedisStore
+}
+
+func (b *redisBuckets) take(key string, perSecond float64, burst int) (time.Duration, error) {
+	wait, err := b.store.Eval(takeScript, "ratelimit:"+key,
+		strconv.FormatFloat(perSecond, 'f', -1, 64), strconv.Itoa(burst))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+)
+
+// actionCollection counts actions per key and UTC day for Cooldown
+const actionCollection = "action_limits"
+
+// Allowance is the outcome of Cooldown, with what clients need to show when
+// the action is next allowed. RemainingToday is -1 without a daily cap.
+type Allowance struct {
+	Allowed bool `json:"allowed"`
+	// NextAllowedAt is when the action may be taken again, given the
+	// cooldown and the daily cap
+	NextAllowedAt  time.Time `json:"next_allowed_at"`
+	RetryAfter     int       `json:"retry_after_seconds" example:"60"`
+	RemainingToday int       `json:"remaining_today" example:"4"`
+	ResetsAt       time.Time `json:"resets_at"`
+}
+
+// actionCount is the action_limits document of a key and day
+type actionCount struct {
+	Count int       `bson:"count"`
+	Last  time.Time `bson:"last"`
+}
+
+// initCooldowns indexes action counts so days past are dropped
+func initCooldowns() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.DB.Collection(actionCollection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("ratelimit: failed to create action limit TTL index: %v", err)
+	}
+}
+
+// Cooldown takes an action for key, such as resending an email to a user,
+// if at least cooldown passed since the last time and fewer than dailyCap
+// were taken this UTC day (0 is no cap). Counts are kept in the database, so
+// every instance enforces the same limits.
+func Cooldown(ctx context.Context, action, key string, cooldown time.Duration, dailyCap int) (Allowance, error) {
+	now := clock.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+	resets := day.Add(24 * time.Hour)
+	id := actionID(action, key, day)
+	collection := database.DB.Collection(actionCollection)
+
+	// The action is taken only when the stored count allows it; otherwise the
+	// upsert collides with the existing document
+	filter := bson.M{"_id": id, "last": bson.M{"$lte": now.Add(-cooldown)}}
+	if dailyCap > 0 {
+		filter["count"] = bson.M{"$lt": dailyCap}
+	}
+	update := bson.M{
+		"$inc": bson.M{"count": 1},
+		"$set": bson.M{"last": now, "expires_at": resets},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var doc actionCount
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+	allowed := err == nil
+	if mongo.IsDuplicateKeyError(err) {
+		err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	}
+	if err != nil {
+		return Allowance{}, err
+	}
+
+	a := Allowance{Allowed: allowed, ResetsAt: resets, NextAllowedAt: doc.Last.Add(cooldown)}
+	a.RemainingToday = -1
+	if dailyCap > 0 {
+		a.RemainingToday = dailyCap - doc.Count
+		if a.RemainingToday <= 0 {
+			a.RemainingToday, a.NextAllowedAt = 0, resets
+		}
+	}
+	if a.NextAllowedAt.After(now) {
+		a.RetryAfter = int(a.NextAllowedAt.Sub(now).Round(time.Second).Seconds())
+	} else {
+		a.NextAllowedAt = now
+	}
+	return a, nil
+}
+
+// TakenToday returns how many times an action was taken for key this UTC day
+func TakenToday(ctx context.Context, action, key string) (int, error) {
+	var doc actionCount
+	id := actionID(action, key, clock.Now().UTC().Truncate(24*time.Hour))
+	err := database.DB.Collection(actionCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return doc.Count, err
+}
+
+// actionID is the action_limits key of an action, key and day
+func actionID(action, key string, day time.Time) string {
+	return action + ":" + key + ":" + day.Format("2006-01-02")
+}
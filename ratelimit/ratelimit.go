@@ -0,0 +1,439 @@
+// Package ratelimit enforces per-organization request rate limits and monthly
+// quotas at the gateway. Limits come from the organization's plan and can be
+// overridden per organization by admins. Sign-in endpoints are additionally
+// limited per client IP (see PerClient), and actions such as resending an
+// email per user (see Cooldown).
+//
+// A request counts against an organization when it arrives on one of its
+// custom domains or carries a session token of one of its members. Requests
+// per minute are limited by each instance; monthly usage is shared through
+// the database, so the quota may be overshot by up to one flush interval of
+// traffic across instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/repository"
+	"golang-backend/tenant"
+	"golang-backend/tokens"
+)
+
+// orgTTL bounds how long an organization's limits are reused before reloading
+const orgTTL = time.Minute
+
+// collection holds monthly usage per organization
+const collection = "org_usage"
+
+// Limits are the effective limits of an organization; zero is unlimited
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute" example:"600"`
+	MonthlyRequests   int `json:"monthly_requests" example:"1000000"`
+}
+
+// Usage is an organization's consumption of its monthly quota. Remaining is
+// -1 when the quota is unlimited.
+type Usage struct {
+	Period    string `json:"period" example:"2026-10"`
+	Requests  int64  `json:"requests" example:"48213"`
+	Remaining int64  `json:"remaining" example:"951787"`
+}
+
+var (
+	enabled     bool
+	plans       map[string]Limits
+	defaultPlan string
+)
+
+// Init parses the plans and starts writing usage to the database. Without it
+// the middleware lets every request through.
+func Init(cfg *config.Config) {
+	initClients(cfg)
+	initCooldowns()
+	plans = parsePlans(cfg.RateLimitPlans)
+	defaultPlan = cfg.DefaultPlan
+	if _, ok := plans[defaultPlan]; !ok {
+		log.Printf("ratelimit: default plan %q is not defined in RATE_LIMIT_PLANS, organizations without a plan are unlimited", defaultPlan)
+	}
+	enabled = cfg.RateLimitEnabled
+	if !enabled {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.UsageFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			flush(context.Background())
+		}
+	}()
+}
+
+// parsePlans reads "name=perMinute/monthly" entries, skipping malformed ones
+func parsePlans(value string) map[string]Limits {
+	m := make(map[string]Limits)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(item, "=")
+		perMinute, monthly, ok2 := strings.Cut(spec, "/")
+		rpm, err1 := strconv.Atoi(strings.TrimSpace(perMinute))
+		quota, err2 := strconv.Atoi(strings.TrimSpace(monthly))
+		if !ok || !ok2 || err1 != nil || err2 != nil || rpm < 0 || quota < 0 {
+			log.Printf("Invalid entry %q in RATE_LIMIT_PLANS, ignoring", item)
+			continue
+		}
+		m[strings.TrimSpace(name)] = Limits{RequestsPerMinute: rpm, MonthlyRequests: quota}
+	}
+	return m
+}
+
+// HasPlan reports whether a plan is defined
+func HasPlan(name string) bool {
+	_, ok := plans[name]
+	return ok
+}
+
+// Effective returns an organization's plan and its limits after overrides
+func Effective(org *models.Organization) (string, Limits) {
+	plan := org.Plan
+	if plan == "" {
+		plan = defaultPlan
+	}
+	limits := plans[plan]
+	if o := org.Limits; o != nil {
+		if o.RequestsPerMinute != nil {
+			limits.RequestsPerMinute = *o.RequestsPerMinute
+		}
+		if o.MonthlyRequests != nil {
+			limits.MonthlyRequests = *o.MonthlyRequests
+		}
+	}
+	return plan, limits
+}
+
+// Middleware rejects requests of organizations over their rate limit or
+// monthly quota with 429 and counts the rest
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		orgID := requestOrg(r)
+		if orgID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		state, err := load(r.Context(), orgID)
+		if err != nil {
+			// Never turn a database hiccup into an outage for the tenant
+			log.Printf("ratelimit: failed to load organization %s: %v", orgID, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if state == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok, retryAfter := state.allow(w); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
+			return
+		}
+		if !state.consume(w) {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Monthly request quota exceeded"}`, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestOrg returns the organization a request counts against: the tenant of
+// its host, else the organization of a valid session token
+func requestOrg(r *http.Request) string {
+	if orgID := tenant.OrgID(r); orgID != "" {
+		return orgID
+	}
+
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		return ""
+	}
+	token, err := tokens.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return ""
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if orgID, ok := claims["orgID"].(string); ok {
+		return orgID
+	}
+	// Minimal tokens only name the user
+	if sub, ok := claims["sub"].(string); ok {
+		return userOrg(r.Context(), sub)
+	}
+	return ""
+}
+
+// memberOrg is a cached user to organization lookup
+type memberOrg struct {
+	orgID   string
+	expires time.Time
+}
+
+var (
+	memberMu   sync.Mutex
+	memberOrgs = make(map[string]memberOrg)
+)
+
+// userOrg returns the organization of a user, cached for orgTTL. Lookup
+// failures count as no organization.
+func userOrg(ctx context.Context, userID string) string {
+	memberMu.Lock()
+	cached, ok := memberOrgs[userID]
+	memberMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.orgID
+	}
+
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ""
+	}
+	user, _, err := repository.FindUser(ctx, bson.M{"_id": id}, repository.Fields("org_id"))
+	if err != nil {
+		return ""
+	}
+
+	memberMu.Lock()
+	// Bound the cache; entries are cheap to reload
+	if len(memberOrgs) > 100000 {
+		memberOrgs = make(map[string]memberOrg)
+	}
+	memberOrgs[userID] = memberOrg{orgID: user.OrgID, expires: time.Now().Add(orgTTL)}
+	memberMu.Unlock()
+	return user.OrgID
+}
+
+// orgState holds one organization's limits, minute bucket and month counter
+type orgState struct {
+	mu      sync.Mutex
+	orgID   string
+	limits  Limits
+	expires time.Time
+
+	// Token bucket refilled at limits.RequestsPerMinute per minute
+	tokens float64
+	last   time.Time
+
+	// stored is the month's count in the database at the last flush, pending
+	// what this instance counted since
+	period  string
+	stored  int64
+	pending int64
+}
+
+var (
+	mu     sync.Mutex
+	states = make(map[string]*orgState)
+)
+
+// load returns the state of an organization, reloading its limits when stale.
+// It returns nil for organizations that do not exist.
+func load(ctx context.Context, orgID string) (*orgState, error) {
+	mu.Lock()
+	state, ok := states[orgID]
+	mu.Unlock()
+	if ok && time.Now().Before(state.expires) {
+		return state, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, nil
+	}
+	var org models.Organization
+	err = database.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	_, limits := Effective(&org)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if state, ok = states[orgID]; !ok {
+		state = &orgState{orgID: orgID, tokens: float64(limits.RequestsPerMinute), last: time.Now()}
+		states[orgID] = state
+	}
+	state.mu.Lock()
+	state.limits = limits
+	state.expires = time.Now().Add(orgTTL)
+	state.mu.Unlock()
+	return state, nil
+}
+
+// Forget drops an organization's cached limits after they changed
+func Forget(orgID string) {
+	mu.Lock()
+	if state, ok := states[orgID]; ok {
+		state.mu.Lock()
+		state.expires = time.Time{}
+		state.mu.Unlock()
+	}
+	mu.Unlock()
+}
+
+// allow takes a token from the minute bucket, or returns the seconds until
+// one is available
+func (s *orgState) allow(w http.ResponseWriter) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rpm := s.limits.RequestsPerMinute
+	if rpm <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	rate := float64(rpm) / 60
+	s.tokens = math.Min(float64(rpm), s.tokens+now.Sub(s.last).Seconds()*rate)
+	s.last = now
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rpm))
+	if s.tokens < 1 {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		return false, int(math.Ceil((1 - s.tokens) / rate))
+	}
+	s.tokens--
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(s.tokens)))
+	return true, 0
+}
+
+// consume counts the request against the monthly quota, or reports that the
+// quota is used up
+func (s *orgState) consume(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if period := currentPeriod(); period != s.period {
+		s.period, s.stored, s.pending = period, 0, 0
+	}
+
+	quota := int64(s.limits.MonthlyRequests)
+	if quota > 0 {
+		used := s.stored + s.pending
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(quota, 10))
+		if used >= quota {
+			w.Header().Set("X-Quota-Remaining", "0")
+			return false
+		}
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(quota-used-1, 10))
+	}
+	s.pending++
+	return true
+}
+
+// currentPeriod names the calendar month usage is counted in
+func currentPeriod() string {
+	return clock.Now().UTC().Format("2006-01")
+}
+
+// flush adds each organization's pending count to the database and picks up
+// what other instances counted
+func flush(ctx context.Context) {
+	mu.Lock()
+	pending := make([]*orgState, 0, len(states))
+	for _, state := range states {
+		pending = append(pending, state)
+	}
+	mu.Unlock()
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	for _, state := range pending {
+		state.mu.Lock()
+		period, count := state.period, state.pending
+		state.mu.Unlock()
+		if period == "" {
+			continue
+		}
+
+		var usage models.OrgUsage
+		filter := bson.M{"_id": usageID(state.orgID, period)}
+		update := bson.M{
+			"$inc":         bson.M{"requests": count},
+			"$setOnInsert": bson.M{"org_id": state.orgID, "period": period},
+		}
+		if err := database.DB.Collection(collection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&usage); err != nil {
+			log.Printf("ratelimit: failed to flush usage of organization %s: %v", state.orgID, err)
+			continue
+		}
+
+		state.mu.Lock()
+		if state.period == period {
+			state.stored = usage.Requests
+			state.pending -= count
+		}
+		state.mu.Unlock()
+	}
+}
+
+// usageID keys an organization's usage document for a month
+func usageID(orgID, period string) string {
+	return fmt.Sprintf("%s:%s", orgID, period)
+}
+
+// CurrentUsage returns an organization's usage this month, including requests
+// this instance has not written yet
+func CurrentUsage(ctx context.Context, orgID string, limits Limits) (Usage, error) {
+	period := currentPeriod()
+	usage := Usage{Period: period}
+
+	var stored models.OrgUsage
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": usageID(orgID, period)}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return usage, err
+	}
+	usage.Requests = stored.Requests
+
+	mu.Lock()
+	state, ok := states[orgID]
+	mu.Unlock()
+	if ok {
+		state.mu.Lock()
+		if state.period == period {
+			usage.Requests += state.pending
+		}
+		state.mu.Unlock()
+	}
+
+	usage.Remaining = -1
+	if limits.MonthlyRequests > 0 {
+		usage.Remaining = max(int64(limits.MonthlyRequests)-usage.Requests, 0)
+	}
+	return usage, nil
+}
@@ -0,0 +1,259 @@
+// Package recorder writes sanitized request/response pairs to disk as HAR
+// entries, one JSON object per line, so production-only bugs can be replayed
+// locally with cmd/replay.
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-backend/utils"
+)
+
+// Redacted replaces secrets in recorded headers and bodies
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are never written to disk, nor are headers named like
+// secrets (see sensitiveField) such as X-Refreshed-Token, X-Service-Token
+// and the X-Signature of signed API key requests
+var sensitiveHeaders = map[string]bool{
+	"Authorization":           true,
+	"Proxy-Authorization":     true,
+	"Cookie":                  true,
+	"Set-Cookie":              true,
+	"X-Api-Key":               true,
+	"X-Forwarded-Client-Cert": true,
+}
+
+// Entry is a HAR 1.2 entry, trimmed to the fields replay needs
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+}
+
+// Request is a recorded HAR request
+type Request struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  []NameValue `json:"headers"`
+	PostData *PostData   `json:"postData,omitempty"`
+}
+
+// Response is a recorded HAR response
+type Response struct {
+	Status  int         `json:"status"`
+	Headers []NameValue `json:"headers"`
+	Content PostData    `json:"content"`
+}
+
+// NameValue is a HAR header
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is a HAR body
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Recorder appends entries to a daily file in dir
+type Recorder struct {
+	dir     string
+	maxBody int
+
+	mu sync.Mutex
+}
+
+// New creates a Recorder writing to dir, keeping at most maxBody bytes per body
+func New(dir string, maxBody int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, maxBody: maxBody}, nil
+}
+
+// Middleware records every request except event streams
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var reqBody []byte
+		if r.Body != nil {
+			reqBody, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+		}
+
+		cw := &captureWriter{StatusWriter: utils.NewStatusWriter(w), limit: rec.maxBody}
+		next.ServeHTTP(cw, r)
+
+		entry := Entry{
+			StartedDateTime: start,
+			Time:            float64(time.Since(start).Microseconds()) / 1000,
+			Request: Request{
+				Method:  r.Method,
+				URL:     requestURI(r.URL),
+				Headers: headers(r.Header),
+			},
+			Response: Response{
+				Status:  cw.Status,
+				Headers: headers(w.Header()),
+				Content: rec.body(w.Header().Get("Content-Type"), cw.body.Bytes()),
+			},
+		}
+		if len(reqBody) > 0 {
+			body := rec.body(r.Header.Get("Content-Type"), reqBody)
+			entry.Request.PostData = &body
+		}
+
+		if err := rec.write(entry); err != nil {
+			log.Printf("recorder: failed to write entry: %v", err)
+		}
+	})
+}
+
+// write appends an entry to today's file
+func (rec *Recorder) write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	name := filepath.Join(rec.dir, "requests-"+entry.StartedDateTime.UTC().Format("2006-01-02")+".jsonl")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// body sanitizes a body for storage. Only JSON is kept; anything else, such
+// as CSV uploads, is replaced with a placeholder since it may hold raw PII.
+func (rec *Recorder) body(contentType string, data []byte) PostData {
+	mime := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if len(data) > rec.maxBody {
+		return PostData{MimeType: mime, Text: "[OMITTED: body too large]"}
+	}
+	if mime != "application/json" {
+		if len(data) == 0 {
+			return PostData{MimeType: mime}
+		}
+		return PostData{MimeType: mime, Text: "[OMITTED: non-JSON body]"}
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return PostData{MimeType: mime, Text: "[OMITTED: invalid JSON]"}
+	}
+	clean, _ := json.Marshal(Sanitize(v))
+	return PostData{MimeType: mime, Text: string(clean)}
+}
+
+// Sanitize redacts values of JSON fields that look like secrets or personal data
+func Sanitize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if sensitiveField(k) {
+				value[k] = Redacted
+			} else {
+				value[k] = Sanitize(field)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = Sanitize(value[i])
+		}
+	}
+	return v
+}
+
+// sensitiveField reports whether a JSON field, query parameter or header
+// name must be redacted: credentials, one-time codes and challenge
+// responses, and personal data
+func sensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, marker := range []string{"password", "token", "secret", "key", "signature", "code", "challenge", "email", "phone", "date_of_birth"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveParams are query parameters redacted besides those named like
+// secrets: the state an OAuth code is bound to
+var sensitiveParams = map[string]bool{
+	"state": true,
+}
+
+// requestURI returns the path and query of a URL with the values of sensitive
+// query parameters, such as the email verification token, redacted
+func requestURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		name, _, hasValue := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(name)
+		if err != nil {
+			key = name
+		}
+		if hasValue && (sensitiveField(key) || sensitiveParams[strings.ToLower(key)]) {
+			pairs[i] = name + "=" + Redacted
+		}
+	}
+	return u.EscapedPath() + "?" + strings.Join(pairs, "&")
+}
+
+// headers converts and redacts a header map
+func headers(h http.Header) []NameValue {
+	var list []NameValue
+	for name, values := range h {
+		for _, value := range values {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] || sensitiveField(name) {
+				value = Redacted
+			}
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+// captureWriter keeps the status and a copy of the response up to limit
+// bytes. Flushes pass through, so NDJSON exports still stream while recorded.
+type captureWriter struct {
+	*utils.StatusWriter
+	limit int
+	body  bytes.Buffer
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.body.Len() <= c.limit {
+		c.body.Write(b)
+	}
+	return c.StatusWriter.Write(b)
+}
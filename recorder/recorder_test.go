@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// record serves one request through a recorder and returns the entry it
+// wrote, decoded and raw
+func record(t *testing.T, req *http.Request, h http.HandlerFunc) (*httptest.ResponseRecorder, Entry, string) {
+	t.Helper()
+	dir := t.TempDir()
+	rec, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	rec.Middleware(h).ServeHTTP(w, req)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("wrote %d files, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	return w, entry, string(data)
+}
+
+func TestMiddlewareKeepsStreaming(t *testing.T) {
+	w, _, _ := record(t, httptest.NewRequest("GET", "/admin/users/export.ndjson", nil), func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{}\n"))
+		flusher.Flush()
+	})
+	if w.Code != http.StatusOK || !w.Flushed {
+		t.Fatalf("got %d, flushed %v; want 200 and a flush through the recorder", w.Code, w.Flushed)
+	}
+}
+
+func TestMiddlewareRedactsQuery(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, tc := range []struct {
+		url    string
+		secret []string
+		kept   string
+	}{
+		{"/verify-email?token=a1b2c3d4", []string{"a1b2c3d4"}, "/verify-email?token="},
+		{"/auth/google/callback?state=st4te&code=c0de&scope=email", []string{"st4te", "c0de"}, "scope=email"},
+		{"/admin/users?page=2&search=jane", nil, "/admin/users?page=2&search=jane"},
+	} {
+		_, entry, data := record(t, httptest.NewRequest("GET", tc.url, nil), ok)
+		for _, secret := range tc.secret {
+			if strings.Contains(data, secret) {
+				t.Errorf("%s: recorded %q", tc.url, secret)
+			}
+		}
+		if !strings.Contains(entry.Request.URL, tc.kept) {
+			t.Errorf("%s: recorded URL %q lost %q", tc.url, entry.Request.URL, tc.kept)
+		}
+	}
+}
+
+func TestMiddlewareRedactsSecretsAndPersonalData(t *testing.T) {
+	body := `{"email":"jane@example.com","password":"hunter22","code":"482913","challenge_response":"c-resp-77","profile":{"phone":"+15550100"}}`
+	req := httptest.NewRequest("POST", "/login/otp/verify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer live-session-jwt")
+	req.Header.Set("X-Signature", "5e1f0c9a")
+	req.Header.Set("X-Api-Signature", "a9c0f1e5")
+	req.Header.Set("X-Service-Token", "service-jwt")
+
+	_, _, data := record(t, req, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Refreshed-Token", "refreshed-jwt")
+		w.Write([]byte(`{"token":"issued-jwt","user":{"email":"jane@example.com"},"challenge":"webauthn-chal"}`))
+	})
+
+	for _, secret := range []string{
+		"jane@example.com", "hunter22", "482913", "c-resp-77", "+15550100",
+		"live-session-jwt", "5e1f0c9a", "a9c0f1e5", "service-jwt",
+		"refreshed-jwt", "issued-jwt", "webauthn-chal",
+	} {
+		if strings.Contains(data, secret) {
+			t.Errorf("recording contains %q", secret)
+		}
+	}
+	if !strings.Contains(data, "X-Refreshed-Token") || !strings.Contains(data, Redacted) {
+		t.Errorf("redacted headers should stay listed: %s", data)
+	}
+}
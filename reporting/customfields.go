@@ -0,0 +1,153 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/clock"
+	"golang-backend/customfields"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// CustomFields is the report of the values users set for custom fields
+const CustomFields = "custom_fields"
+
+// maxFieldValues bounds the values listed per field
+const maxFieldValues = 20
+
+// FieldValue counts the users who set a field to one value
+type FieldValue struct {
+	Value interface{} `bson:"value" json:"value"`
+	Users int64       `bson:"users" json:"users" example:"42"`
+}
+
+// FieldStats summarizes the values of a custom field: the most common ones,
+// or their range for numbers. Values of encrypted fields are only counted.
+type FieldStats struct {
+	Name      string       `bson:"name" json:"name" example:"company_size"`
+	Type      string       `bson:"type" json:"type" example:"enum"`
+	Encrypted bool         `bson:"encrypted,omitempty" json:"encrypted,omitempty"`
+	Users     int64        `bson:"users" json:"users" example:"310"`
+	Values    []FieldValue `bson:"values,omitempty" json:"values,omitempty"`
+	Min       *float64     `bson:"min,omitempty" json:"min,omitempty"`
+	Max       *float64     `bson:"max,omitempty" json:"max,omitempty"`
+	Mean      *float64     `bson:"mean,omitempty" json:"mean,omitempty"`
+}
+
+// CustomFieldsReport summarizes every custom field, out of Users users
+type CustomFieldsReport struct {
+	GeneratedAt time.Time    `bson:"generated_at" json:"generated_at"`
+	Users       int64        `bson:"users" json:"users" example:"3120"`
+	Fields      []FieldStats `bson:"fields" json:"fields"`
+}
+
+// ComputeCustomFields counts the users who set each custom field in every
+// region, with the most common values, or the range of numbers. Erased
+// accounts are ignored.
+func ComputeCustomFields(ctx context.Context) (*CustomFieldsReport, error) {
+	schema, err := customfields.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &CustomFieldsReport{GeneratedAt: clock.Now().UTC(), Fields: []FieldStats{}}
+	active := bson.M{"forgotten_at": bson.M{"$exists": false}}
+	for _, db := range database.Regions {
+		count, err := db.Collection("users").CountDocuments(ctx, active)
+		if err != nil {
+			return nil, err
+		}
+		report.Users += count
+	}
+
+	for _, field := range schema {
+		stats, err := fieldStats(ctx, field)
+		if err != nil {
+			return nil, err
+		}
+		report.Fields = append(report.Fields, *stats)
+	}
+	return report, nil
+}
+
+// fieldStats summarizes one field over every region
+func fieldStats(ctx context.Context, field models.CustomField) (*FieldStats, error) {
+	stats := &FieldStats{Name: field.Name, Type: field.Type, Encrypted: field.Encrypted}
+	path := "custom_fields." + field.Name
+	match := bson.D{{Key: "$match", Value: bson.M{path: bson.M{"$exists": true}, "forgotten_at": bson.M{"$exists": false}}}}
+
+	var group bson.D
+	switch {
+	case field.Encrypted:
+		group = bson.D{{Key: "$group", Value: bson.M{"_id": nil, "users": bson.M{"$sum": 1}}}}
+	case field.Type == models.FieldNumber:
+		group = bson.D{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"users": bson.M{"$sum": 1},
+			"min":   bson.M{"$min": "$" + path},
+			"max":   bson.M{"$max": "$" + path},
+			"sum":   bson.M{"$sum": "$" + path},
+		}}}
+	default:
+		group = bson.D{{Key: "$group", Value: bson.M{"_id": "$" + path, "users": bson.M{"$sum": 1}}}}
+	}
+
+	var sum float64
+	values := make(map[string]*FieldValue)
+	for _, db := range database.Regions {
+		cursor, err := db.Collection("users").Aggregate(ctx, mongo.Pipeline{match, group})
+		if err != nil {
+			return nil, err
+		}
+		var rows []struct {
+			Value interface{} `bson:"_id"`
+			Users int64       `bson:"users"`
+			Min   *float64    `bson:"min"`
+			Max   *float64    `bson:"max"`
+			Sum   float64     `bson:"sum"`
+		}
+		if err := cursor.All(ctx, &rows); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			stats.Users += row.Users
+			sum += row.Sum
+			if row.Min != nil && (stats.Min == nil || *row.Min < *stats.Min) {
+				stats.Min = row.Min
+			}
+			if row.Max != nil && (stats.Max == nil || *row.Max > *stats.Max) {
+				stats.Max = row.Max
+			}
+			if row.Value == nil {
+				continue
+			}
+			key := fmt.Sprint(row.Value)
+			if values[key] == nil {
+				values[key] = &FieldValue{Value: row.Value}
+			}
+			values[key].Users += row.Users
+		}
+	}
+
+	if field.Type == models.FieldNumber && !field.Encrypted && stats.Users > 0 {
+		mean := sum / float64(stats.Users)
+		stats.Mean = &mean
+	}
+	for _, value := range values {
+		stats.Values = append(stats.Values, *value)
+	}
+	sort.Slice(stats.Values, func(i, j int) bool {
+		if stats.Values[i].Users != stats.Values[j].Users {
+			return stats.Values[i].Users > stats.Values[j].Users
+		}
+		return fmt.Sprint(stats.Values[i].Value) < fmt.Sprint(stats.Values[j].Value)
+	})
+	if len(stats.Values) > maxFieldValues {
+		stats.Values = stats.Values[:maxFieldValues]
+	}
+	return stats, nil
+}
@@ -0,0 +1,335 @@
+package reporting
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/keys"
+	"golang-backend/security"
+	"golang-backend/utils"
+)
+
+// Duplicates is the report of probable duplicate accounts
+const Duplicates = "duplicates"
+
+// Duplicate signals, from the strongest
+const (
+	// SignalEmail groups accounts with the same email blind index, which
+	// registration prevents within a region but not across regions
+	SignalEmail = "email"
+	// SignalEmailAlias groups Gmail addresses delivering to the same mailbox
+	// while EMAIL_FOLD_ALIASES is off
+	SignalEmailAlias = "email_alias"
+	// SignalDevice groups accounts signed in from the same IP address and
+	// user agent
+	SignalDevice = "device"
+)
+
+const (
+	// deviceDays is how far back sign-ins are compared
+	deviceDays = 30
+	// maxDeviceAccounts skips devices shared by more accounts, such as
+	// office networks and kiosks, which are not duplicates
+	maxDeviceAccounts = 5
+)
+
+// confidence of each signal
+var confidence = map[string]string{
+	SignalEmail:      "high",
+	SignalEmailAlias: "medium",
+	SignalDevice:     "low",
+}
+
+// DuplicateAccount is an account of a duplicate group
+type DuplicateAccount struct {
+	ID        string    `bson:"id" json:"id" example:"6650c1a2b3d4e5f60718293a"`
+	Region    string    `bson:"region" json:"region" example:"default"`
+	Role      string    `bson:"role" json:"role" example:"user"`
+	Suspended bool      `bson:"suspended,omitempty" json:"suspended,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// MergeSuggestion names the account to keep and the accounts to fold into
+// it: the oldest active account is kept
+type MergeSuggestion struct {
+	Keep  string   `bson:"keep" json:"keep" example:"6650c1a2b3d4e5f60718293a"`
+	Merge []string `bson:"merge" json:"merge"`
+}
+
+// DuplicateGroup is a set of accounts sharing a signal. Key identifies the
+// shared value without revealing it: the blind index, or a hash of the
+// device.
+type DuplicateGroup struct {
+	Signal     string             `bson:"signal" json:"signal" example:"email"`
+	Confidence string             `bson:"confidence" json:"confidence" example:"high"`
+	Key        string             `bson:"key" json:"key"`
+	Accounts   []DuplicateAccount `bson:"accounts" json:"accounts"`
+	Suggestion MergeSuggestion    `bson:"suggestion" json:"suggestion"`
+}
+
+// DuplicatesReport lists probable duplicate accounts, strongest signal first
+type DuplicatesReport struct {
+	GeneratedAt time.Time        `bson:"generated_at" json:"generated_at"`
+	Counts      map[string]int   `bson:"counts" json:"counts"`
+	Groups      []DuplicateGroup `bson:"groups" json:"groups"`
+}
+
+// ComputeDuplicates groups accounts sharing an email blind index, a folded
+// Gmail address or a sign-in device. Erased accounts are ignored.
+func ComputeDuplicates(ctx context.Context) (*DuplicatesReport, error) {
+	report := &DuplicatesReport{GeneratedAt: clock.Now().UTC(), Counts: make(map[string]int)}
+	accounts := make(map[string]DuplicateAccount)
+
+	// Accounts per blind index in every region; with one region the index is
+	// unique, so only groups found by the aggregation are kept
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"forgotten_at": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$email_hash",
+			"users": bson.M{"$push": bson.M{"id": "$_id", "role": "$role", "suspended": "$suspended", "created_at": "$created_at"}},
+		}}},
+	}
+	if len(database.Regions) == 1 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"users.1": bson.M{"$exists": true}}}})
+	}
+	byIndex := make(map[string][]string)
+	for region, db := range database.Regions {
+		cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			var group struct {
+				Index string `bson:"_id"`
+				Users []struct {
+					ID        primitive.ObjectID `bson:"id"`
+					Role      string             `bson:"role"`
+					Suspended bool               `bson:"suspended"`
+					CreatedAt time.Time          `bson:"created_at"`
+				} `bson:"users"`
+			}
+			if err := cursor.Decode(&group); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			for _, u := range group.Users {
+				id := u.ID.Hex()
+				accounts[id] = DuplicateAccount{ID: id, Region: region, Role: u.Role, Suspended: u.Suspended, CreatedAt: u.CreatedAt}
+				byIndex[group.Index] = append(byIndex[group.Index], id)
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for index, ids := range byIndex {
+		report.add(SignalEmail, index, ids, accounts)
+	}
+
+	if !conf.EmailFoldAliases {
+		if err := aliasDuplicates(ctx, report, accounts); err != nil {
+			return nil, err
+		}
+	}
+	if err := deviceDuplicates(ctx, report, accounts); err != nil {
+		return nil, err
+	}
+
+	rank := map[string]int{SignalEmail: 0, SignalEmailAlias: 1, SignalDevice: 2}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Signal != b.Signal {
+			return rank[a.Signal] < rank[b.Signal]
+		}
+		if len(a.Accounts) != len(b.Accounts) {
+			return len(a.Accounts) > len(b.Accounts)
+		}
+		return a.Key < b.Key
+	})
+	return report, nil
+}
+
+// aliasDuplicates groups Gmail accounts whose addresses fold to the same
+// mailbox. The addresses are encrypted, so Gmail accounts are found by
+// decrypting each one.
+func aliasDuplicates(ctx context.Context, report *DuplicatesReport, accounts map[string]DuplicateAccount) error {
+	byMailbox := make(map[string][]string)
+	addresses := make(map[string]map[string]bool)
+	for region, db := range database.Regions {
+		opts := options.Find().SetProjection(bson.M{"email": 1, "role": 1, "suspended": 1, "created_at": 1})
+		cursor, err := db.Collection("users").Find(ctx, bson.M{"forgotten_at": bson.M{"$exists": false}}, opts)
+		if err != nil {
+			return err
+		}
+		for cursor.Next(ctx) {
+			var u struct {
+				ID        primitive.ObjectID `bson:"_id"`
+				Email     string             `bson:"email"`
+				Role      string             `bson:"role"`
+				Suspended bool               `bson:"suspended"`
+				CreatedAt time.Time          `bson:"created_at"`
+			}
+			if err := cursor.Decode(&u); err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			plain, err := keys.Decrypt(ctx, conf, u.Email)
+			if err != nil || plain == "" {
+				continue
+			}
+			normalized := utils.NormalizeEmail(plain)
+			if !utils.AliasDomain(normalized) {
+				continue
+			}
+			id := u.ID.Hex()
+			accounts[id] = DuplicateAccount{ID: id, Region: region, Role: u.Role, Suspended: u.Suspended, CreatedAt: u.CreatedAt}
+			mailbox := utils.HashEmail(utils.FoldEmailAlias(normalized))
+			byMailbox[mailbox] = append(byMailbox[mailbox], id)
+			if addresses[mailbox] == nil {
+				addresses[mailbox] = make(map[string]bool)
+			}
+			addresses[mailbox][normalized] = true
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for mailbox, ids := range byMailbox {
+		// The same address in several regions is already an email group
+		if len(addresses[mailbox]) > 1 {
+			report.add(SignalEmailAlias, mailbox, ids, accounts)
+		}
+	}
+	return nil
+}
+
+// deviceDuplicates groups accounts that signed in from the same IP address
+// and user agent over the last 30 days, from the stored security events
+func deviceDuplicates(ctx context.Context, report *DuplicatesReport, accounts map[string]DuplicateAccount) error {
+	since := clock.Now().Add(-deviceDays * day)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"type":       security.EventLoginSuccess,
+			"created_at": bson.M{"$gte": since},
+			"user_id":    bson.M{"$nin": bson.A{"", nil}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"ip": "$ip", "user_agent": "$user_agent"},
+			"users": bson.M{"$addToSet": "$user_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{
+			"users.1": bson.M{"$exists": true},
+			"users." + strconv.Itoa(maxDeviceAccounts): bson.M{"$exists": false},
+		}}},
+	}
+	cursor, err := database.DB.Collection("security_events").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var devices []struct {
+		Device struct {
+			IP        string `bson:"ip"`
+			UserAgent string `bson:"user_agent"`
+		} `bson:"_id"`
+		Users []string `bson:"users"`
+	}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return err
+	}
+
+	// Load the accounts not seen by the email passes
+	var missing []primitive.ObjectID
+	for _, d := range devices {
+		for _, id := range d.Users {
+			if _, ok := accounts[id]; ok {
+				continue
+			}
+			if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+				missing = append(missing, oid)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if err := loadAccounts(ctx, missing, accounts); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range devices {
+		report.add(SignalDevice, utils.HashToken(d.Device.IP + "\n" + d.Device.UserAgent)[:16], d.Users, accounts)
+	}
+	return nil
+}
+
+// loadAccounts looks accounts up in every region
+func loadAccounts(ctx context.Context, ids []primitive.ObjectID, accounts map[string]DuplicateAccount) error {
+	filter := bson.M{"_id": bson.M{"$in": ids}, "forgotten_at": bson.M{"$exists": false}}
+	opts := options.Find().SetProjection(bson.M{"role": 1, "suspended": 1, "created_at": 1})
+	for region, db := range database.Regions {
+		cursor, err := db.Collection("users").Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var users []struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			Role      string             `bson:"role"`
+			Suspended bool               `bson:"suspended"`
+			CreatedAt time.Time          `bson:"created_at"`
+		}
+		if err := cursor.All(ctx, &users); err != nil {
+			return err
+		}
+		for _, u := range users {
+			id := u.ID.Hex()
+			accounts[id] = DuplicateAccount{ID: id, Region: region, Role: u.Role, Suspended: u.Suspended, CreatedAt: u.CreatedAt}
+		}
+	}
+	return nil
+}
+
+// add records a group of two or more known accounts with the suggestion to
+// keep the oldest active one
+func (report *DuplicatesReport) add(signal, key string, ids []string, accounts map[string]DuplicateAccount) {
+	seen := make(map[string]bool)
+	var members []DuplicateAccount
+	for _, id := range ids {
+		if account, ok := accounts[id]; ok && !seen[id] {
+			seen[id] = true
+			members = append(members, account)
+		}
+	}
+	if len(members) < 2 {
+		return
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Suspended != members[j].Suspended {
+			return !members[i].Suspended
+		}
+		return members[i].CreatedAt.Before(members[j].CreatedAt)
+	})
+
+	suggestion := MergeSuggestion{Keep: members[0].ID}
+	for _, m := range members[1:] {
+		suggestion.Merge = append(suggestion.Merge, m.ID)
+	}
+	report.Groups = append(report.Groups, DuplicateGroup{
+		Signal:     signal,
+		Confidence: confidence[signal],
+		Key:        key,
+		Accounts:   members,
+		Suggestion: suggestion,
+	})
+	report.Counts[signal]++
+}
@@ -0,0 +1,392 @@
+// Package reporting computes signup, retention and active user reports from
+// the users of every data residency region and the analytics events users
+// sent while signed in. Reports are aggregations over many documents, so a
+// job computes them once a day and stores them in the report_snapshots
+// collection, where every instance reads them from.
+package reporting
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Report names, also the _id of their snapshot
+const (
+	Signups     = "signups"
+	Retention   = "retention"
+	ActiveUsers = "active_users"
+)
+
+// Report windows
+const (
+	signupDays     = 90
+	retentionWeeks = 12
+	activeDays     = 30
+)
+
+const (
+	snapshotCollection = "report_snapshots"
+	eventCollection    = "analytics_events"
+
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// SignupDay counts the accounts created on one UTC day
+type SignupDay struct {
+	Date    string `bson:"date" json:"date" example:"2024-05-01"`
+	Signups int64  `bson:"signups" json:"signups" example:"42"`
+}
+
+// SignupReport counts new accounts per day over the last 90 days
+type SignupReport struct {
+	GeneratedAt time.Time        `bson:"generated_at" json:"generated_at"`
+	Since       time.Time        `bson:"since" json:"since"`
+	Total       int64            `bson:"total" json:"total" example:"3120"`
+	Regions     map[string]int64 `bson:"regions" json:"regions"`
+	Days        []SignupDay      `bson:"days" json:"days"`
+}
+
+// Cohort is the users who signed up in one week and the share of them active
+// in each following week; Retained[0] is the signup week itself
+type Cohort struct {
+	Week     string    `bson:"week" json:"week" example:"2024-04-29"`
+	Users    int64     `bson:"users" json:"users" example:"310"`
+	Active   []int64   `bson:"active" json:"active"`
+	Retained []float64 `bson:"retained" json:"retained"`
+}
+
+// RetentionReport holds the weekly cohorts of the last 12 weeks, oldest first
+type RetentionReport struct {
+	GeneratedAt time.Time `bson:"generated_at" json:"generated_at"`
+	Cohorts     []Cohort  `bson:"cohorts" json:"cohorts"`
+}
+
+// ActiveDay counts the users who sent events on one UTC day
+type ActiveDay struct {
+	Date  string `bson:"date" json:"date" example:"2024-05-01"`
+	Users int64  `bson:"users" json:"users" example:"860"`
+}
+
+// ActiveUsersReport counts users active in the last day, week and 30 days,
+// and per day over the last 30 days
+type ActiveUsersReport struct {
+	GeneratedAt time.Time   `bson:"generated_at" json:"generated_at"`
+	DAU         int64       `bson:"dau" json:"dau" example:"860"`
+	WAU         int64       `bson:"wau" json:"wau" example:"2400"`
+	MAU         int64       `bson:"mau" json:"mau" example:"5100"`
+	Days        []ActiveDay `bson:"days" json:"days"`
+}
+
+// snapshot is a stored report
+type snapshot struct {
+	Name        string    `bson:"_id"`
+	GeneratedAt time.Time `bson:"generated_at"`
+	Report      bson.Raw  `bson:"report"`
+}
+
+var computers = map[string]func(context.Context) (interface{}, error){
+	Signups:     func(ctx context.Context) (interface{}, error) { return ComputeSignups(ctx) },
+	Retention:   func(ctx context.Context) (interface{}, error) { return ComputeRetention(ctx) },
+	ActiveUsers: func(ctx context.Context) (interface{}, error) { return ComputeActiveUsers(ctx) },
+	Duplicates:  func(ctx context.Context) (interface{}, error) { return ComputeDuplicates(ctx) },
+
+	CustomFields: func(ctx context.Context) (interface{}, error) { return ComputeCustomFields(ctx) },
+}
+
+// conf is the configuration reports are computed with
+var conf *config.Config
+
+// Init creates the index the activity reports query analytics events by, and
+// starts the job refreshing reports older than REPORTS_REFRESH_INTERVAL.
+// Instances check hourly, so only the first to find a report stale
+// recomputes it.
+func Init(cfg *config.Config) {
+	conf = cfg
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.D{{Key: "timestamp", Value: 1}, {Key: "user_id", Value: 1}}}
+	if _, err := database.DB.Collection(eventCollection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("reporting: failed to create event index: %v", err)
+	}
+
+	go func() {
+		for {
+			refreshStale(context.Background(), cfg.ReportsRefreshInterval)
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// refreshStale recomputes every report generated more than maxAge ago
+func refreshStale(ctx context.Context, maxAge time.Duration) {
+	for name := range computers {
+		var stored snapshot
+		err := database.DB.Collection(snapshotCollection).FindOne(ctx, bson.M{"_id": name}).Decode(&stored)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("reporting: failed to load %s report: %v", name, err)
+			continue
+		}
+		if err == nil && clock.Now().Sub(stored.GeneratedAt) < maxAge {
+			continue
+		}
+		if err := Refresh(ctx, name); err != nil {
+			log.Printf("reporting: failed to compute %s report: %v", name, err)
+		}
+	}
+}
+
+// Refresh computes a report and stores it as the current snapshot
+func Refresh(ctx context.Context, name string) error {
+	compute, ok := computers[name]
+	if !ok {
+		return errors.New("unknown report " + name)
+	}
+	report, err := compute(ctx)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Collection(snapshotCollection).ReplaceOne(ctx, bson.M{"_id": name},
+		snapshot{Name: name, GeneratedAt: clock.Now(), Report: raw}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Load decodes the stored snapshot of a report into out, computing it first
+// when there is none yet or refresh is set
+func Load(ctx context.Context, name string, refresh bool, out interface{}) error {
+	collection := database.DB.Collection(snapshotCollection)
+	var stored snapshot
+	err := collection.FindOne(ctx, bson.M{"_id": name}).Decode(&stored)
+	if refresh || errors.Is(err, mongo.ErrNoDocuments) {
+		if err := Refresh(ctx, name); err != nil {
+			return err
+		}
+		err = collection.FindOne(ctx, bson.M{"_id": name}).Decode(&stored)
+	}
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(stored.Report, out)
+}
+
+// ComputeSignups counts the accounts created per day in every region,
+// excluding admins
+func ComputeSignups(ctx context.Context) (*SignupReport, error) {
+	now := clock.Now().UTC()
+	since := now.Truncate(day).Add(-(signupDays - 1) * day)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"role": "user", "created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"signups": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "date": "$_id", "signups": 1}}},
+	}
+
+	counts := make(map[string]int64)
+	report := &SignupReport{GeneratedAt: now, Since: since, Regions: make(map[string]int64)}
+	for region, db := range database.Regions {
+		cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		var days []SignupDay
+		if err := cursor.All(ctx, &days); err != nil {
+			return nil, err
+		}
+		for _, d := range days {
+			counts[d.Date] += d.Signups
+			report.Regions[region] += d.Signups
+			report.Total += d.Signups
+		}
+	}
+
+	// Every day is listed, including those without signups
+	for t := since; !t.After(now); t = t.Add(day) {
+		date := t.Format("2006-01-02")
+		report.Days = append(report.Days, SignupDay{Date: date, Signups: counts[date]})
+	}
+	return report, nil
+}
+
+// ComputeRetention groups the users who signed up in each of the last 12
+// weeks (starting Monday, UTC) and counts how many of them sent analytics
+// events while signed in during each week since
+func ComputeRetention(ctx context.Context) (*RetentionReport, error) {
+	now := clock.Now().UTC()
+	today := now.Truncate(day)
+	// Days since Monday
+	thisWeek := today.Add(-time.Duration((int(today.Weekday())+6)%7) * day)
+	start := thisWeek.Add(-(retentionWeeks - 1) * week)
+
+	// Cohort index of every user who signed up since start
+	cohortOf := make(map[string]int)
+	sizes := make([]int64, retentionWeeks)
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "created_at": 1})
+	for _, db := range database.Regions {
+		cursor, err := db.Collection("users").Find(ctx, bson.M{"role": "user", "created_at": bson.M{"$gte": start}}, opts)
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			var user struct {
+				ID        primitive.ObjectID `bson:"_id"`
+				CreatedAt time.Time          `bson:"created_at"`
+			}
+			if err := cursor.Decode(&user); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			cohort := int(user.CreatedAt.Sub(start) / week)
+			if cohort >= retentionWeeks {
+				continue
+			}
+			cohortOf[user.ID.Hex()] = cohort
+			sizes[cohort]++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Weeks since start in which each user sent events
+	weekMs := week.Milliseconds()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"timestamp": bson.M{"$gte": start}, "user_id": bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{
+			"user": "$user_id",
+			"week": bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$timestamp", start}}, weekMs}}},
+		}}}},
+	}
+	cursor, err := database.DB.Collection(eventCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	active := make([][]int64, retentionWeeks)
+	for i := range active {
+		active[i] = make([]int64, retentionWeeks-i)
+	}
+	for cursor.Next(ctx) {
+		var row struct {
+			ID struct {
+				User string  `bson:"user"`
+				Week float64 `bson:"week"`
+			} `bson:"_id"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		cohort, ok := cohortOf[row.ID.User]
+		offset := int(row.ID.Week) - cohort
+		if !ok || offset < 0 || offset >= len(active[cohort]) {
+			continue
+		}
+		active[cohort][offset]++
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{GeneratedAt: now}
+	for i := range retentionWeeks {
+		cohort := Cohort{
+			Week:     start.Add(time.Duration(i) * week).Format("2006-01-02"),
+			Users:    sizes[i],
+			Active:   active[i],
+			Retained: make([]float64, len(active[i])),
+		}
+		for j, n := range active[i] {
+			if sizes[i] > 0 {
+				// Percentages with one decimal
+				cohort.Retained[j] = float64(n*1000/sizes[i]) / 10
+			}
+		}
+		report.Cohorts = append(report.Cohorts, cohort)
+	}
+	return report, nil
+}
+
+// ComputeActiveUsers counts the distinct users who sent analytics events
+// while signed in
+func ComputeActiveUsers(ctx context.Context) (*ActiveUsersReport, error) {
+	now := clock.Now().UTC()
+	since := now.Truncate(day).Add(-(activeDays - 1) * day)
+	match := bson.M{"$match": bson.M{"timestamp": bson.M{"$gte": now.Add(-activeDays * day)}, "user_id": bson.M{"$gt": ""}}}
+	activeSince := func(t time.Time) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$last", t}}, 1, 0}}}
+	}
+
+	pipeline := bson.A{
+		match,
+		bson.M{"$facet": bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{"_id": "$user_id", "last": bson.M{"$max": "$timestamp"}}},
+				bson.M{"$group": bson.M{
+					"_id": nil,
+					"dau": activeSince(now.Add(-day)),
+					"wau": activeSince(now.Add(-week)),
+					"mau": activeSince(now.Add(-activeDays * day)),
+				}},
+			},
+			"days": bson.A{
+				bson.M{"$match": bson.M{"timestamp": bson.M{"$gte": since}}},
+				bson.M{"$group": bson.M{"_id": bson.M{
+					"date": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$timestamp"}},
+					"user": "$user_id",
+				}}},
+				bson.M{"$group": bson.M{"_id": "$_id.date", "users": bson.M{"$sum": 1}}},
+				bson.M{"$project": bson.M{"_id": 0, "date": "$_id", "users": 1}},
+			},
+		}},
+	}
+	cursor, err := database.DB.Collection(eventCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Totals []struct {
+			DAU int64 `bson:"dau"`
+			WAU int64 `bson:"wau"`
+			MAU int64 `bson:"mau"`
+		} `bson:"totals"`
+		Days []ActiveDay `bson:"days"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	report := &ActiveUsersReport{GeneratedAt: now}
+	counts := make(map[string]int64)
+	if len(results) > 0 {
+		if len(results[0].Totals) > 0 {
+			totals := results[0].Totals[0]
+			report.DAU, report.WAU, report.MAU = totals.DAU, totals.WAU, totals.MAU
+		}
+		for _, d := range results[0].Days {
+			counts[d.Date] = d.Users
+		}
+	}
+	for t := since; !t.After(now); t = t.Add(day) {
+		date := t.Format("2006-01-02")
+		report.Days = append(report.Days, ActiveDay{Date: date, Users: counts[date]})
+	}
+	return report, nil
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// DefaultBatchSize is the number of IDs sent in a single $in query
+const DefaultBatchSize = 500
+
+// BatchOptions tune GetUsersByIDs
+type BatchOptions struct {
+	// BatchSize caps the IDs per $in query; zero uses DefaultBatchSize
+	BatchSize int
+	// Cache, when set, answers IDs it already holds and is primed with the rest
+	Cache *UserCache
+	// Fields limits the returned fields; projected results are never cached
+	Fields []string
+}
+
+// GetUsersByIDs resolves many users with chunked $in queries across all
+// regions and returns them keyed by ID. Unknown IDs are absent from the map.
+func GetUsersByIDs(ctx context.Context, ids []primitive.ObjectID, opts *BatchOptions) (map[primitive.ObjectID]*models.User, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	users := make(map[primitive.ObjectID]*models.User, len(ids))
+	pending := make([]primitive.ObjectID, 0, len(ids))
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if user, ok := opts.Cache.Get(id); ok {
+			users[id] = user
+			continue
+		}
+		pending = append(pending, id)
+	}
+
+	findOpts := options.Find()
+	if len(opts.Fields) > 0 {
+		projection := bson.M{}
+		for _, name := range opts.Fields {
+			projection[name] = 1
+		}
+		findOpts.SetProjection(projection)
+	}
+
+	for _, region := range regionOrder() {
+		if len(pending) == 0 {
+			break
+		}
+		collection := database.Regions[region].Collection("users")
+
+		var missing []primitive.ObjectID
+		for start := 0; start < len(pending); start += size {
+			chunk := pending[start:min(start+size, len(pending))]
+
+			cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": chunk}}, findOpts)
+			if err != nil {
+				return nil, err
+			}
+			var found []models.User
+			if err := cursor.All(ctx, &found); err != nil {
+				return nil, err
+			}
+
+			for i := range found {
+				user := &found[i]
+				users[user.ID] = user
+				if len(opts.Fields) == 0 {
+					opts.Cache.Set(user)
+				}
+			}
+			for _, id := range chunk {
+				if _, ok := users[id]; !ok {
+					missing = append(missing, id)
+				}
+			}
+		}
+		pending = missing
+	}
+
+	return users, nil
+}
+
+// UserCache is a small in-process cache of user documents for batch lookups.
+// A nil *UserCache is valid and caches nothing.
+type UserCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[primitive.ObjectID]cachedUser
+}
+
+type cachedUser struct {
+	user      *models.User
+	expiresAt time.Time
+}
+
+// NewUserCache creates a UserCache whose entries live for ttl
+func NewUserCache(ttl time.Duration) *UserCache {
+	return &UserCache{ttl: ttl, entries: make(map[primitive.ObjectID]cachedUser)}
+}
+
+// Get returns a cached user that has not expired
+func (c *UserCache) Get(id primitive.ObjectID) (*models.User, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.user, true
+}
+
+// Set caches a user
+func (c *UserCache) Set(user *models.User) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[user.ID] = cachedUser{user: user, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// Forget drops a user, e.g. after it was changed
+func (c *UserCache) Forget(id primitive.ObjectID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
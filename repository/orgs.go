@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// orgStatusTTL bounds how long other instances keep serving an organization
+// after its status changed
+const orgStatusTTL = 30 * time.Second
+
+type cachedStatus struct {
+	status    string
+	expiresAt time.Time
+}
+
+var (
+	orgStatusMu sync.RWMutex
+	orgStatuses = make(map[string]cachedStatus)
+)
+
+// OrgStatus returns an organization's lifecycle status, models.OrgActive for
+// users outside an organization. Unknown organizations count as deleted.
+func OrgStatus(ctx context.Context, orgID string) (string, error) {
+	if orgID == "" {
+		return models.OrgActive, nil
+	}
+
+	orgStatusMu.RLock()
+	entry, ok := orgStatuses[orgID]
+	orgStatusMu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.status, nil
+	}
+
+	status := models.OrgDeleted
+	if oid, err := primitive.ObjectIDFromHex(orgID); err == nil {
+		var org models.Organization
+		opts := options.FindOne().SetProjection(bson.M{"status": 1})
+		err := database.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": oid}, opts).Decode(&org)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", err
+		}
+		if err == nil {
+			status = org.Status
+			if status == "" {
+				status = models.OrgActive
+			}
+		}
+	}
+
+	orgStatusMu.Lock()
+	orgStatuses[orgID] = cachedStatus{status: status, expiresAt: time.Now().Add(orgStatusTTL)}
+	orgStatusMu.Unlock()
+	return status, nil
+}
+
+// ForgetOrgStatus drops an organization's cached status after it changed
+func ForgetOrgStatus(orgID string) {
+	orgStatusMu.Lock()
+	delete(orgStatuses, orgID)
+	orgStatusMu.Unlock()
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// ErrUnknownRegion is returned when a region has no configured cluster
+var ErrUnknownRegion = errors.New("unknown data residency region")
+
+// Users returns the users collection of a data residency region
+func Users(region string) (*mongo.Collection, error) {
+	db := database.Region(region)
+	if db == nil {
+		return nil, ErrUnknownRegion
+	}
+	return db.Collection("users"), nil
+}
+
+// RegionForOrg returns the residency region of an organization. Users outside
+// an organization live in the default region.
+func RegionForOrg(ctx context.Context, orgID string) (string, error) {
+	if orgID == "" {
+		return database.DefaultRegion, nil
+	}
+
+	oid, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return "", err
+	}
+
+	var org models.Organization
+	opts := options.FindOne().SetProjection(bson.M{"region": 1})
+	if err := database.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": oid}, opts).Decode(&org); err != nil {
+		return "", err
+	}
+	if org.Region == "" {
+		return database.DefaultRegion, nil
+	}
+	return org.Region, nil
+}
+
+// UsersForOrg returns the users collection of an organization's residency region
+func UsersForOrg(ctx context.Context, orgID string) (*mongo.Collection, error) {
+	region, err := RegionForOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return Users(region)
+}
+
+// Projections for reads that only need part of a user document. Fields left
+// out decode as zero values, which also skips fetching encrypted data that
+// would otherwise travel over the network for nothing.
+var (
+	// IDOnly is enough to check existence or locate a user's region
+	IDOnly = Fields("_id")
+	// CredentialFields are what login needs to verify and issue a token
+	CredentialFields = Fields("email", "password", "role", "role_version", "suspended", "org_id", "email_unverified", "waitlist")
+	// ProfileFields are what the profile endpoints render
+	ProfileFields = Fields("email", "display_name", "role", "org_id", "created_at", "updated_at", "custom_fields")
+	// RoleFields are what token checks need to detect a changed role or
+	// organization, or a suspension
+	RoleFields = Fields("role", "role_version", "org_id", "suspended")
+)
+
+// Fields returns find options projecting a user read onto the named fields
+func Fields(names ...string) *options.FindOneOptions {
+	projection := bson.M{}
+	for _, name := range names {
+		projection[name] = 1
+	}
+	return options.FindOne().SetProjection(projection)
+}
+
+// FindUser looks a user up in every region and returns it with the collection
+// it lives in. It returns mongo.ErrNoDocuments when no region has a match.
+func FindUser(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) (*models.User, *mongo.Collection, error) {
+	for _, region := range regionOrder() {
+		collection := database.Regions[region].Collection("users")
+
+		var user models.User
+		err := collection.FindOne(ctx, filter, opts...).Decode(&user)
+		if err == nil {
+			return &user, collection, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, mongo.ErrNoDocuments
+}
+
+// FindUserByEmailHash looks a user up by email hash across all regions
+func FindUserByEmailHash(ctx context.Context, emailHash string, opts ...*options.FindOneOptions) (*models.User, *mongo.Collection, error) {
+	return FindUser(ctx, bson.M{"email_hash": emailHash}, opts...)
+}
+
+// UserExists reports whether any region has a user matching filter, without
+// fetching the document
+func UserExists(ctx context.Context, filter bson.M) (bool, error) {
+	_, _, err := FindUser(ctx, filter, IDOnly)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// LocateUser returns the users collection holding the given user ID
+func LocateUser(ctx context.Context, userID primitive.ObjectID) (*mongo.Collection, error) {
+	_, collection, err := FindUser(ctx, bson.M{"_id": userID}, IDOnly)
+	return collection, err
+}
+
+// MoveUser moves a single user document between regional collections.
+// It does nothing when both collections belong to the same region.
+func MoveUser(ctx context.Context, userID primitive.ObjectID, from, to *mongo.Collection) error {
+	if from.Database() == to.Database() {
+		return nil
+	}
+
+	var doc bson.M
+	if err := from.FindOne(ctx, bson.M{"_id": userID}).Decode(&doc); err != nil {
+		return err
+	}
+	if _, err := to.ReplaceOne(ctx, bson.M{"_id": userID}, doc, options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+	_, err := from.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// MoveOrg migrates every user of an organization into the target region and
+// records the new residency. It is idempotent, so an interrupted move can be re-run.
+func MoveOrg(ctx context.Context, orgID primitive.ObjectID, target string) (int, error) {
+	targetUsers, err := Users(target)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for region, db := range database.Regions {
+		if region == target {
+			continue
+		}
+		source := db.Collection("users")
+
+		cursor, err := source.Find(ctx, bson.M{"org_id": orgID.Hex()})
+		if err != nil {
+			return moved, fmt.Errorf("region %s: %w", region, err)
+		}
+
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return moved, err
+			}
+
+			// Copy first, then delete, so a failure never loses a user
+			if _, err := targetUsers.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc, options.Replace().SetUpsert(true)); err != nil {
+				cursor.Close(ctx)
+				return moved, fmt.Errorf("copy to %s: %w", target, err)
+			}
+			if _, err := source.DeleteOne(ctx, bson.M{"_id": doc["_id"]}); err != nil {
+				cursor.Close(ctx)
+				return moved, fmt.Errorf("delete from %s: %w", region, err)
+			}
+			moved++
+		}
+		cursor.Close(ctx)
+	}
+
+	_, err = database.DB.Collection("organizations").UpdateOne(ctx, bson.M{"_id": orgID}, bson.M{"$set": bson.M{"region": target}})
+	return moved, err
+}
+
+// regionOrder returns the default region first, followed by the others
+func regionOrder() []string {
+	order := []string{database.DefaultRegion}
+	for region := range database.Regions {
+		if region != database.DefaultRegion {
+			order = append(order, region)
+		}
+	}
+	return order
+}
@@ -0,0 +1,196 @@
+// Package rolegrants gives users a role for a limited time. A background job
+// restores the previous role once a grant expires, unless the role has been
+// changed again since, and tells the user and the admins about it.
+package rolegrants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/repository"
+)
+
+// collection holds role grants
+const collection = "role_grants"
+
+// ErrExpired is returned for grants that would end before they start
+var ErrExpired = errors.New("role grant expiry is in the past")
+
+// Start creates the grant indexes and reverts expired grants in the
+// background every RoleGrantInterval
+func Start(cfg *config.Config, n *notifier.Notifier) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("rolegrants: failed to create indexes: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.RoleGrantInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := RevertExpired(context.Background(), cfg, n); err != nil {
+				log.Printf("rolegrants: failed to revert expired grants: %v", err)
+			}
+		}
+	}()
+}
+
+// Grant records that a user was given role in place of previous until
+// expiresAt. Grants of the user still active are superseded by it.
+func Grant(ctx context.Context, userID primitive.ObjectID, role, previous, grantedBy string, expiresAt time.Time) (*models.RoleGrant, error) {
+	now := clock.Now()
+	if !expiresAt.After(now) {
+		return nil, ErrExpired
+	}
+	if err := Cancel(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	grant := models.RoleGrant{
+		ID:           clock.NewID(),
+		UserID:       userID,
+		Role:         role,
+		PreviousRole: previous,
+		GrantedBy:    grantedBy,
+		Status:       models.RoleGrantActive,
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+	}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// Cancel supersedes the active grants of a user, for role changes that are
+// meant to last
+func Cancel(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := database.DB.Collection(collection).UpdateMany(ctx,
+		bson.M{"user_id": userID, "status": models.RoleGrantActive},
+		bson.M{"$set": bson.M{"status": models.RoleGrantSuperseded, "ended_at": clock.Now()}})
+	return err
+}
+
+// Pending returns the active grants, the soonest to expire first
+func Pending(ctx context.Context) ([]models.RoleGrant, error) {
+	opts := options.Find().SetSort(bson.M{"expires_at": 1})
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"status": models.RoleGrantActive}, opts)
+	if err != nil {
+		return nil, err
+	}
+	grants := []models.RoleGrant{}
+	err = cursor.All(ctx, &grants)
+	return grants, err
+}
+
+// RevertExpired restores the previous role of every expired grant. Each grant
+// is claimed before its user is changed, so instances running the job at the
+// same time revert it once.
+func RevertExpired(ctx context.Context, cfg *config.Config, n *notifier.Notifier) error {
+	grants := database.DB.Collection(collection)
+	for {
+		now := clock.Now()
+		var grant models.RoleGrant
+		err := grants.FindOneAndUpdate(ctx,
+			bson.M{"status": models.RoleGrantActive, "expires_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"status": models.RoleGrantReverted, "ended_at": now}},
+			options.FindOneAndUpdate().SetSort(bson.M{"expires_at": 1}).SetReturnDocument(options.After)).Decode(&grant)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		reverted, err := revert(ctx, cfg, n, &grant)
+		if err != nil {
+			// Put the grant back for the next run
+			grants.UpdateOne(ctx, bson.M{"_id": grant.ID}, bson.M{
+				"$set":   bson.M{"status": models.RoleGrantActive},
+				"$unset": bson.M{"ended_at": ""},
+			})
+			return err
+		}
+		if !reverted {
+			grants.UpdateOne(ctx, bson.M{"_id": grant.ID}, bson.M{"$set": bson.M{"status": models.RoleGrantSuperseded}})
+		}
+	}
+}
+
+// revert restores the previous role of a grant's user. It reports false when
+// the user is gone or no longer has the granted role.
+func revert(ctx context.Context, cfg *config.Config, n *notifier.Notifier, grant *models.RoleGrant) (bool, error) {
+	filter := bson.M{"_id": grant.UserID, "role": grant.Role}
+	user, users, err := repository.FindUser(ctx, filter, repository.Fields("notifications"))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{"role": grant.PreviousRole, "updated_at": clock.Now()},
+		"$inc": bson.M{"role_version": 1},
+	}
+	result, err := users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+	cache.Invalidate(cache.TagUsers)
+
+	userID := grant.UserID.Hex()
+	entry := models.AuditLog{
+		ActorID:  "system",
+		Action:   audit.ActionRoleGrantExpire,
+		TargetID: userID,
+		Before:   bson.M{"role": grant.Role},
+		After:    bson.M{"role": grant.PreviousRole, "grant_id": grant.ID.Hex()},
+	}
+	if _, err := audit.Insert(entry); err != nil {
+		log.Printf("rolegrants: failed to audit expiry of grant %s: %v", grant.ID.Hex(), err)
+	}
+
+	msg := notifier.UserMessage{
+		Type:     "role_grant.expired",
+		Category: models.CategorySecurity,
+		Title:    "Your temporary access has ended",
+		Body:     fmt.Sprintf("Your %s role expired; your role is %s again.", grant.Role, grant.PreviousRole),
+	}
+	if err := notifier.Deliver(ctx, cfg, user, msg); err != nil {
+		log.Printf("rolegrants: failed to notify user %s: %v", userID, err)
+	}
+	n.Send(notifier.Event{
+		Type:     "role_grant.expired",
+		Severity: notifier.SeverityInfo,
+		Message:  fmt.Sprintf("Role %s of user %s expired; reverted to %s", grant.Role, userID, grant.PreviousRole),
+		Data: map[string]interface{}{
+			"grant_id":      grant.ID.Hex(),
+			"user_id":       userID,
+			"role":          grant.Role,
+			"previous_role": grant.PreviousRole,
+			"granted_by":    grant.GrantedBy,
+		},
+	})
+	return true, nil
+}
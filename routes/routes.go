@@ -0,0 +1,127 @@
+// Package routes declares the route groups of the API and the middleware
+// each one applies, so CORS, authentication, rate limits and auditing are
+// decided in one place instead of per router. main only picks the group a
+// route belongs to.
+package routes
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang-backend/audit"
+	"golang-backend/config"
+	"golang-backend/deprecation"
+	"golang-backend/middleware"
+	"golang-backend/ratelimit"
+)
+
+// Route groups
+const (
+	Public        = "public"
+	Authenticated = "authenticated"
+	Admin         = "admin"
+	Internal      = "internal"
+)
+
+// Authentication levels
+const (
+	AuthNone    = "none"
+	AuthSession = "session"
+	AuthAdmin   = "admin"
+)
+
+// Policy is the middleware applied to the routes of a group
+type Policy struct {
+	// Auth is AuthNone, AuthSession (session token or API key) or AuthAdmin
+	// (a session whose role grants permissions, checked again per route)
+	Auth string
+	// CORSOrigins are the browser origins allowed to call the group, "*" for
+	// any; empty disables CORS
+	CORSOrigins []string
+	// RateLimit applies organization rate limits and quotas
+	RateLimit bool
+	// Audit records every authenticated write in the audit log
+	Audit bool
+}
+
+// policies are the group defaults. CORS_ORIGINS, ROUTE_RATE_LIMIT and
+// ROUTE_AUDIT override the rest per group, but authentication is fixed here
+// so a configuration mistake cannot open up admin routes. Internal routes
+// (metrics, probes) check their own credentials and are not meant for
+// browsers.
+var policies = map[string]Policy{
+	Public:        {Auth: AuthNone, RateLimit: true},
+	Authenticated: {Auth: AuthSession, RateLimit: true},
+	Admin:         {Auth: AuthAdmin, RateLimit: true, Audit: true},
+	Internal:      {Auth: AuthNone},
+}
+
+// PolicyFor returns a group's policy after configuration overrides
+func PolicyFor(cfg *config.Config, group string) Policy {
+	p, ok := policies[group]
+	if !ok {
+		// Unknown groups get the strictest policy
+		log.Printf("routes: unknown route group %q, requiring admin", group)
+		p = Policy{Auth: AuthAdmin, RateLimit: true, Audit: true}
+	}
+	if origins, ok := cfg.CORSOrigins[group]; ok {
+		p.CORSOrigins = strings.Fields(origins)
+	}
+	if enabled, ok := cfg.RouteRateLimit[group]; ok {
+		p.RateLimit = enabled
+	}
+	if enabled, ok := cfg.RouteAudit[group]; ok {
+		p.Audit = enabled
+	}
+	return p
+}
+
+// Group returns a router for routes of a group under prefix ("" for none).
+// Routes not registered on it fall through to the parent router, and CORS
+// preflights for its routes are answered before authentication.
+func Group(r *mux.Router, cfg *config.Config, group, prefix string) *mux.Router {
+	p := PolicyFor(cfg, group)
+
+	var sub *mux.Router
+	if prefix == "" {
+		sub = r.NewRoute().Subrouter()
+	} else {
+		sub = r.PathPrefix(prefix).Subrouter()
+	}
+
+	if len(p.CORSOrigins) > 0 {
+		sub.Use(middleware.CORS(p.CORSOrigins))
+		sub.MethodNotAllowedHandler = middleware.Preflight(p.CORSOrigins)
+	}
+	if p.RateLimit {
+		sub.Use(ratelimit.Middleware)
+	}
+	switch p.Auth {
+	case AuthSession:
+		sub.Use(middleware.ExplainAuthz)
+		sub.Use(middleware.JWTAuthMiddleware(cfg))
+	case AuthAdmin:
+		sub.Use(middleware.ExplainAuthz)
+		sub.Use(middleware.JWTAuthMiddleware(cfg))
+		sub.Use(middleware.AnyPermissionMiddleware)
+	}
+	// Audit after authentication so entries name the actor
+	if p.Audit {
+		sub.Use(audit.Middleware(group))
+	}
+	// Deprecated routes last, so their callers are known
+	sub.Use(deprecation.Middleware)
+	return sub
+}
+
+// Describe lists the policy of every group, for startup logs
+func Describe(cfg *config.Config) []string {
+	var lines []string
+	for _, group := range []string{Public, Authenticated, Admin, Internal} {
+		p := PolicyFor(cfg, group)
+		lines = append(lines, fmt.Sprintf("%s: auth=%s cors=%q rate_limit=%t audit=%t", group, p.Auth, strings.Join(p.CORSOrigins, " "), p.RateLimit, p.Audit))
+	}
+	return lines
+}
@@ -0,0 +1,231 @@
+// Package routestats counts requests per route and how many of them failed:
+// client errors (4xx), server errors (5xx) and payload validation failures
+// (400, 413 and 422 responses). Counters are kept in memory per instance and
+// exported as Prometheus metrics.
+//
+// With route alerts enabled, an evaluator compares each route's rates over
+// the last RouteAlertInterval with their thresholds and raises an alert when
+// one is crossed; another is sent when the route recovers. Routes with fewer
+// than RouteAlertMinRequests requests in an interval are not judged, so a
+// handful of failures on a quiet route neither raises nor clears an alert.
+package routestats
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang-backend/config"
+	"golang-backend/notifier"
+	"golang-backend/utils"
+)
+
+// Alert types raised by the evaluator
+const (
+	AlertServerErrors       = "route.server_errors"
+	AlertClientErrors       = "route.client_errors"
+	AlertValidationFailures = "route.validation_failures"
+	AlertRecovered          = "route.recovered"
+)
+
+// counters are the requests of a route and how many failed
+type counters struct {
+	requests, clientErrors, serverErrors, validationFailures int64
+}
+
+// route holds the counters of a method and path template since startup and
+// since the last evaluation, and the alerts it has raised
+type route struct {
+	method, path string
+	total        counters
+	window       counters
+	alerting     map[string]bool
+}
+
+// Stats are the counters of a route since startup
+type Stats struct {
+	Method             string   `json:"method" example:"POST"`
+	Route              string   `json:"route" example:"/register"`
+	Requests           int64    `json:"requests" example:"1520"`
+	ClientErrors       int64    `json:"client_errors" example:"96"`
+	ServerErrors       int64    `json:"server_errors" example:"2"`
+	ValidationFailures int64    `json:"validation_failures" example:"41"`
+	Alerting           []string `json:"alerting,omitempty" example:"route.validation_failures"`
+}
+
+var (
+	mu     sync.Mutex
+	routes = make(map[string]*route)
+)
+
+// Middleware counts the requests of every matched route by outcome
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := utils.NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+
+		path := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				path = template
+			}
+		}
+		record(r.Method, path, sw.Status)
+	})
+}
+
+// record counts one response of a route
+func record(method, path string, status int) {
+	key := method + " " + path
+	mu.Lock()
+	defer mu.Unlock()
+	rt, ok := routes[key]
+	if !ok {
+		rt = &route{method: method, path: path, alerting: make(map[string]bool)}
+		routes[key] = rt
+	}
+	for _, c := range []*counters{&rt.total, &rt.window} {
+		c.requests++
+		switch {
+		case status >= 500:
+			c.serverErrors++
+		case status >= 400:
+			c.clientErrors++
+		}
+		switch status {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			c.validationFailures++
+		}
+	}
+}
+
+// Snapshot returns the counters of every route seen since startup, by route
+// and method
+func Snapshot() []Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	stats := make([]Stats, 0, len(routes))
+	for _, rt := range routes {
+		s := Stats{
+			Method:             rt.method,
+			Route:              rt.path,
+			Requests:           rt.total.requests,
+			ClientErrors:       rt.total.clientErrors,
+			ServerErrors:       rt.total.serverErrors,
+			ValidationFailures: rt.total.validationFailures,
+		}
+		for alert, on := range rt.alerting {
+			if on {
+				s.Alerting = append(s.Alerting, alert)
+			}
+		}
+		sort.Strings(s.Alerting)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Route != stats[j].Route {
+			return stats[i].Route < stats[j].Route
+		}
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// WriteMetrics writes the route counters in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	stats := Snapshot()
+	metric := func(name, help string, value func(Stats) int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{method=%q,route=%q} %d\n", name, s.Method, s.Route, value(s))
+		}
+	}
+	metric("http_route_requests_total", "Requests to the route since startup.", func(s Stats) int64 { return s.Requests })
+	metric("http_route_client_errors_total", "Requests to the route answered 4xx.", func(s Stats) int64 { return s.ClientErrors })
+	metric("http_route_server_errors_total", "Requests to the route answered 5xx.", func(s Stats) int64 { return s.ServerErrors })
+	metric("http_route_validation_failures_total", "Requests to the route rejected as invalid (400, 413 or 422).", func(s Stats) int64 { return s.ValidationFailures })
+}
+
+// Start evaluates the routes every RouteAlertInterval when route alerts are
+// enabled
+func Start(cfg *config.Config, n *notifier.Notifier) {
+	if !cfg.RouteAlertsEnabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.RouteAlertInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, alert := range evaluate(cfg) {
+				n.Send(alert)
+			}
+		}
+	}()
+	log.Println("Route error rate alerts started")
+}
+
+// threshold is an error rate a route is judged against
+type threshold struct {
+	alert    string
+	severity string
+	label    string
+	limit    float64
+	count    func(counters) int64
+}
+
+// evaluate judges the requests of each route since the last evaluation,
+// starts a new interval and returns the alerts to send
+func evaluate(cfg *config.Config) []notifier.Event {
+	thresholds := []threshold{
+		{AlertServerErrors, notifier.SeverityCritical, "server error", cfg.RouteAlertServerErrorRate, func(c counters) int64 { return c.serverErrors }},
+		{AlertClientErrors, notifier.SeverityWarning, "client error", cfg.RouteAlertClientErrorRate, func(c counters) int64 { return c.clientErrors }},
+		{AlertValidationFailures, notifier.SeverityWarning, "validation failure", cfg.RouteAlertValidationRate, func(c counters) int64 { return c.validationFailures }},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var alerts []notifier.Event
+	for _, rt := range routes {
+		window := rt.window
+		rt.window = counters{}
+		if window.requests == 0 || window.requests < int64(cfg.RouteAlertMinRequests) {
+			continue
+		}
+		for _, t := range thresholds {
+			if t.limit <= 0 {
+				continue
+			}
+			failed := t.count(window)
+			rate := float64(failed) / float64(window.requests)
+			data := map[string]interface{}{
+				"method": rt.method, "route": rt.path, "alert": t.alert,
+				"requests": window.requests, "failed": failed, "rate": rate, "threshold": t.limit,
+				"interval": cfg.RouteAlertInterval.String(),
+			}
+			switch {
+			case rate >= t.limit && !rt.alerting[t.alert]:
+				rt.alerting[t.alert] = true
+				alerts = append(alerts, notifier.Event{
+					Type:     t.alert,
+					Severity: t.severity,
+					Message:  fmt.Sprintf("%s %s: %.0f%% %s rate over the last %s (%d of %d requests)", rt.method, rt.path, rate*100, t.label, cfg.RouteAlertInterval, failed, window.requests),
+					Data:     data,
+				})
+			case rate < t.limit && rt.alerting[t.alert]:
+				rt.alerting[t.alert] = false
+				alerts = append(alerts, notifier.Event{
+					Type:     AlertRecovered,
+					Severity: notifier.SeverityInfo,
+					Message:  fmt.Sprintf("%s %s: %s rate back to %.0f%%", rt.method, rt.path, t.label, rate*100),
+					Data:     data,
+				})
+			}
+		}
+	}
+	return alerts
+}
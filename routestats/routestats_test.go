@@ -0,0 +1,40 @@
+package routestats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddlewareKeepsStreaming(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/stream/{id}", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/stream/7", nil))
+	if rec.Code != http.StatusAccepted || !rec.Flushed {
+		t.Fatalf("got %d, flushed %v; want 202 and a flush through the middleware", rec.Code, rec.Flushed)
+	}
+
+	for _, s := range Snapshot() {
+		if s.Route == "/stream/{id}" {
+			if s.Requests != 1 || s.ClientErrors != 0 || s.ServerErrors != 0 {
+				t.Fatalf("counted %+v, want one successful request", s)
+			}
+			return
+		}
+	}
+	t.Fatal("route was not counted by its template")
+}
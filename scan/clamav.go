@@ -0,0 +1,78 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamChunkSize is the size of the chunks streamed to clamd
+const clamChunkSize = 64 << 10
+
+// ClamAV scans uploads with a clamd daemon over its INSTREAM command. The
+// daemon's StreamMaxLength must be at least the largest upload.
+type ClamAV struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+// Name implements Scanner
+func (c *ClamAV) Name() string {
+	return "clamav"
+}
+
+// Scan implements Scanner
+func (c *ClamAV) Scan(ctx context.Context, upload Upload) (string, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	// zINSTREAM takes NUL-terminated commands and length-prefixed chunks,
+	// ended by an empty chunk
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	size := make([]byte, 4)
+	for data := upload.Content; len(data) > 0; {
+		chunk := data[:min(len(data), clamChunkSize)]
+		data = data[len(chunk):]
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err := conn.Write(append(size, chunk...)); err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		return "", err
+	}
+	return parseClamReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamReply interprets "stream: OK", "stream: <signature> FOUND" and
+// "... ERROR" replies
+func parseClamReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return "malware detected: " + strings.TrimSuffix(result, " FOUND"), nil
+	case strings.HasSuffix(result, " ERROR"):
+		return "", errors.New("clamd: " + strings.TrimSuffix(result, " ERROR"))
+	}
+	return "", fmt.Errorf("clamd: unexpected reply %q", reply)
+}
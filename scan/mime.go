@@ -0,0 +1,97 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang-backend/models"
+)
+
+// allowedTypes lists the content types accepted per upload kind, as detected
+// from the content rather than declared by the client. Generic files may be
+// of any type that is not rejected by its signature.
+var allowedTypes = map[string][]string{
+	models.UploadCSV:    {"text/plain", "text/csv"},
+	models.UploadAvatar: {"image/png", "image/jpeg", "image/gif", "image/webp"},
+	models.UploadFile:   nil,
+}
+
+// signatures are file formats rejected for every upload kind
+var signatures = []struct {
+	magic []byte
+	name  string
+}{
+	{[]byte("MZ"), "Windows executable"},
+	{[]byte("\x7fELF"), "ELF executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "Mach-O executable"},
+	{[]byte("\xfe\xed\xfa\xcf"), "Mach-O executable"},
+	{[]byte("\xce\xfa\xed\xfe"), "Mach-O executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "Mach-O executable"},
+	{[]byte("\xca\xfe\xba\xbe"), "Java class or universal binary"},
+	{[]byte("#!"), "script"},
+	{[]byte("PK\x03\x04"), "ZIP archive"},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "OLE document, which may carry macros"},
+}
+
+// dangerousExtensions are rejected whatever the content, since a browser or
+// desktop may run the file based on its name alone
+var dangerousExtensions = []string{
+	".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".vbs",
+	".js", ".jse", ".wsf", ".hta", ".jar", ".sh", ".app", ".lnk", ".svg", ".html", ".htm",
+}
+
+// MIMEVerifier checks the magic bytes of an upload against the content types
+// allowed for its kind
+type MIMEVerifier struct{}
+
+// Name implements Scanner
+func (MIMEVerifier) Name() string {
+	return "mime"
+}
+
+// Scan implements Scanner
+func (MIMEVerifier) Scan(ctx context.Context, upload Upload) (string, error) {
+	if ext := strings.ToLower(filepath.Ext(upload.Filename)); slices.Contains(dangerousExtensions, ext) {
+		return fmt.Sprintf("file extension %s is not allowed", ext), nil
+	}
+	for _, sig := range signatures {
+		if bytes.HasPrefix(upload.Content, sig.magic) {
+			return sig.name, nil
+		}
+	}
+	allowed, ok := allowedTypes[upload.Kind]
+	if !ok {
+		return "", fmt.Errorf("no content types configured for %s uploads", upload.Kind)
+	}
+	detected := DetectType(upload.Content)
+	if allowed != nil && !slices.Contains(allowed, detected) {
+		return fmt.Sprintf("content is %s, expected one of %s", detected, strings.Join(allowed, ", ")), nil
+	}
+	return "", nil
+}
+
+// DetectType returns the content type of data sniffed from its first bytes,
+// without parameters
+func DetectType(data []byte) string {
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return detected
+}
+
+// Allowed reports whether uploads of a kind may declare a content type
+func Allowed(kind, contentType string) bool {
+	allowed, ok := allowedTypes[kind]
+	if !ok {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (allowed == nil || slices.Contains(allowed, mediaType))
+}
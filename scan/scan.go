@@ -0,0 +1,201 @@
+// Package scan checks uploaded files before they are used. Every upload runs
+// through the configured scanners: the magic-bytes MIME verifier, which only
+// lets through the content types expected for the kind of upload and rejects
+// executables and archives whatever their name, and optionally a ClamAV
+// daemon. Flagged uploads are kept in quarantine for an admin to review.
+package scan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/security"
+)
+
+// collection holds quarantined uploads
+const collection = "quarantined_uploads"
+
+// ErrUnavailable is returned when a scanner could not check an upload and
+// UPLOAD_SCAN_FAIL_OPEN is off
+var ErrUnavailable = errors.New("upload scanner unavailable")
+
+// Upload is a file to check
+type Upload struct {
+	Kind         string
+	Filename     string
+	DeclaredType string
+	Content      []byte
+}
+
+// Scanner checks an upload. It returns a reason when the upload must be
+// rejected, "" when it is clean, and an error when it could not decide.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, upload Upload) (string, error)
+}
+
+// Rejection is returned for flagged uploads
+type Rejection struct {
+	QuarantineID primitive.ObjectID
+	Reason       string
+}
+
+func (e *Rejection) Error() string {
+	return "upload rejected: " + e.Reason
+}
+
+var (
+	scanners []Scanner
+	failOpen bool
+)
+
+// Init creates the quarantine's TTL index and configures the scanners named
+// by UPLOAD_SCANNERS. Without it uploads are not scanned.
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"created_at": 1}, Options: options.Index().SetExpireAfterSeconds(int32(cfg.UploadQuarantineTTL.Seconds()))},
+		{Keys: bson.D{{Key: "sha256", Value: 1}, {Key: "status", Value: 1}}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("scan: failed to create quarantine indexes: %v", err)
+	}
+
+	failOpen = cfg.UploadScanFailOpen
+	for _, name := range cfg.UploadScanners {
+		switch name {
+		case "none":
+		case "mime":
+			scanners = append(scanners, MIMEVerifier{})
+		case "clamav":
+			if cfg.ClamAVAddr == "" {
+				log.Printf("scan: CLAMAV_ADDR is not set, ClamAV scanning disabled")
+				continue
+			}
+			scanners = append(scanners, &ClamAV{Addr: cfg.ClamAVAddr, Timeout: cfg.ClamAVTimeout})
+		default:
+			log.Printf("scan: unknown scanner %q in UPLOAD_SCANNERS, ignoring", name)
+		}
+	}
+}
+
+// Check runs an upload through every scanner. A flagged upload is stored in
+// quarantine, reported as a security event and returned as a *Rejection. A
+// file an admin released from quarantine before is let through unscanned.
+func Check(r *http.Request, upload Upload) error {
+	ctx := r.Context()
+	sum := sha256.Sum256(upload.Content)
+	hash := hex.EncodeToString(sum[:])
+
+	if len(scanners) > 0 {
+		released, err := database.DB.Collection(collection).CountDocuments(ctx, bson.M{"sha256": hash, "status": models.QuarantineReleased})
+		if err == nil && released > 0 {
+			return nil
+		}
+	}
+
+	for _, scanner := range scanners {
+		reason, err := scanner.Scan(ctx, upload)
+		if err != nil {
+			log.Printf("scan: %s failed on %s upload %q: %v", scanner.Name(), upload.Kind, upload.Filename, err)
+			if failOpen {
+				continue
+			}
+			return ErrUnavailable
+		}
+		if reason == "" {
+			continue
+		}
+
+		entry := models.QuarantinedUpload{
+			ID:           clock.NewID(),
+			Kind:         upload.Kind,
+			Filename:     upload.Filename,
+			DeclaredType: upload.DeclaredType,
+			DetectedType: DetectType(upload.Content),
+			Size:         len(upload.Content),
+			SHA256:       hash,
+			Scanner:      scanner.Name(),
+			Reason:       reason,
+			UploadedBy:   audit.ActorID(r),
+			IP:           audit.ClientIP(r),
+			Status:       models.QuarantinePending,
+			CreatedAt:    clock.Now(),
+			Content:      upload.Content,
+		}
+		if _, err := database.DB.Collection(collection).InsertOne(ctx, entry); err != nil {
+			log.Printf("scan: failed to quarantine %s upload %q: %v", upload.Kind, upload.Filename, err)
+		}
+		security.Emit(r, security.EventUploadFlagged, security.OutcomeFailure, entry.UploadedBy,
+			fmt.Sprintf("%s upload %q flagged by %s: %s", upload.Kind, upload.Filename, scanner.Name(), reason))
+		return &Rejection{QuarantineID: entry.ID, Reason: reason}
+	}
+	return nil
+}
+
+// List returns quarantined uploads with a status, newest first, without
+// their content
+func List(ctx context.Context, status string, limit int64) ([]models.QuarantinedUpload, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(limit).
+		SetProjection(bson.M{"content": 0})
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	uploads := []models.QuarantinedUpload{}
+	err = cursor.All(ctx, &uploads)
+	return uploads, err
+}
+
+// Get returns a quarantined upload with its content
+func Get(ctx context.Context, id primitive.ObjectID) (*models.QuarantinedUpload, error) {
+	var upload models.QuarantinedUpload
+	if err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id}).Decode(&upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Review resolves a pending upload: released uploads are let through when
+// uploaded again, deleted ones lose their content. It returns
+// mongo.ErrNoDocuments when the upload is not pending.
+func Review(ctx context.Context, id primitive.ObjectID, status, reviewerID, note string) (*models.QuarantinedUpload, error) {
+	if !slices.Contains([]string{models.QuarantineReleased, models.QuarantineDeleted}, status) {
+		return nil, fmt.Errorf("invalid review status %q", status)
+	}
+	now := clock.Now()
+	update := bson.M{"$set": bson.M{"status": status, "reviewed_by": reviewerID, "review_note": note, "reviewed_at": now}}
+	if status == models.QuarantineDeleted {
+		update["$unset"] = bson.M{"content": ""}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"content": 0})
+	var upload models.QuarantinedUpload
+	err := database.DB.Collection(collection).FindOneAndUpdate(ctx, bson.M{"_id": id, "status": models.QuarantinePending}, update, opts).Decode(&upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
@@ -0,0 +1,149 @@
+package security
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/correlation"
+	"golang-backend/database"
+)
+
+// Security event types
+const (
+	EventLoginSuccess     = "auth.login.success"
+	EventLoginFailure     = "auth.login.failure"
+	EventLockout          = "auth.lockout"
+	EventTokenInvalid     = "auth.token.invalid"
+	EventTokenRevoked     = "auth.token.revoked"
+	EventTokenReplay      = "auth.token.replay"
+	EventPermissionDenied = "authz.permission.denied"
+	EventUploadFlagged    = "upload.flagged"
+	EventBreakGlass       = "auth.break_glass"
+	EventLogout           = "auth.logout"
+	EventPasswordReset    = "auth.password.reset"
+	EventPasswordChange   = "auth.password.change"
+	EventSudo             = "auth.sudo"
+	EventRateLimited      = "auth.rate_limited"
+	EventAccountDeleted   = "auth.account.deleted"
+	EventPhoneChange      = "auth.phone.change"
+	EventPasskeyChange    = "auth.passkey.change"
+)
+
+// Event outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is a structured security event in a SIEM friendly schema
+type Event struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type      string             `bson:"type" json:"type"`
+	Outcome   string             `bson:"outcome" json:"outcome"`
+	Severity  int                `bson:"severity" json:"severity"`
+	UserID    string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	IP        string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Method    string             `bson:"method,omitempty" json:"method,omitempty"`
+	Path      string             `bson:"path,omitempty" json:"path,omitempty"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	RequestID string             `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TraceID   string             `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Exporter forwards security events to an external system
+type Exporter interface {
+	Export(event Event) error
+}
+
+var exporters []Exporter
+
+// Init creates the TTL index for stored events and configures exporters
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(cfg.SecurityEventTTL.Seconds())),
+	}
+	if _, err := database.DB.Collection("security_events").Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("security: failed to create TTL index: %v", err)
+	}
+
+	if cfg.SIEMSyslogAddr != "" {
+		exporter, err := NewSyslogExporter(cfg.SIEMSyslogAddr)
+		if err != nil {
+			log.Printf("security: syslog exporter disabled: %v", err)
+		} else {
+			exporters = append(exporters, exporter)
+		}
+	}
+}
+
+// Emit records a security event for the request and forwards it to exporters
+func Emit(r *http.Request, eventType, outcome, userID, reason string) {
+	ids := correlation.FromContext(r.Context())
+	event := Event{
+		ID:        clock.NewID(),
+		Type:      eventType,
+		Outcome:   outcome,
+		Severity:  severity(eventType, outcome),
+		UserID:    userID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Reason:    reason,
+		RequestID: ids.RequestID,
+		TraceID:   ids.TraceID,
+		CreatedAt: clock.Now(),
+	}
+
+	if _, err := database.DB.Collection("security_events").InsertOne(context.Background(), event); err != nil {
+		log.Printf("security: failed to store event %s: %v", eventType, err)
+	}
+
+	for _, exporter := range exporters {
+		go func(exporter Exporter) {
+			if err := exporter.Export(event); err != nil {
+				log.Printf("security: export failed: %v", err)
+			}
+		}(exporter)
+	}
+}
+
+// severity maps an event to a CEF severity between 0 and 10
+func severity(eventType, outcome string) int {
+	switch eventType {
+	case EventBreakGlass:
+		return 10
+	case EventLockout, EventTokenReplay, EventUploadFlagged:
+		return 8
+	case EventPermissionDenied, EventTokenRevoked:
+		return 6
+	}
+	if outcome == OutcomeFailure {
+		return 5
+	}
+	return 3
+}
+
+// clientIP returns the remote IP of the request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
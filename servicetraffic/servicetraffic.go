@@ -0,0 +1,278 @@
+// Package servicetraffic authenticates and records service-to-service calls.
+//
+// A calling service sends an X-Service-Token, a short-lived JWT naming the
+// service and signed with the shared SERVICE_TOKEN_SECRET; Transport adds it
+// to outgoing requests. Middleware verifies the token and stores a sample of
+// the calls (caller, route, latency, outcome) so admins can see which services
+// depend on which routes. The token only identifies the caller; requests are
+// still authorized by the regular user or API key credentials they carry.
+package servicetraffic
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// TokenHeader carries the calling service's token
+const TokenHeader = "X-Service-Token"
+
+// collection holds sampled calls
+const collection = "service_calls"
+
+// queueSize bounds calls waiting to be stored; beyond it calls are dropped
+const queueSize = 1024
+
+// Call outcomes
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeServerError = "server_error"
+)
+
+// UnknownCaller names callers whose token could not be verified
+const UnknownCaller = "unknown"
+
+// Call is one recorded service-to-service request. Weight is the inverse of
+// the sample rate at the time, so summing weights estimates the total calls.
+type Call struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Caller        string             `bson:"caller" json:"caller"`
+	Authenticated bool               `bson:"authenticated" json:"authenticated"`
+	Target        string             `bson:"target" json:"target"`
+	Method        string             `bson:"method" json:"method"`
+	Route         string             `bson:"route" json:"route"`
+	Status        int                `bson:"status" json:"status"`
+	Outcome       string             `bson:"outcome" json:"outcome"`
+	LatencyMs     float64            `bson:"latency_ms" json:"latency_ms"`
+	Weight        float64            `bson:"weight" json:"weight"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Dependency summarizes the calls of one service to one route
+type Dependency struct {
+	Caller          string    `bson:"caller" json:"caller" example:"user-service"`
+	Method          string    `bson:"method" json:"method" example:"GET"`
+	Route           string    `bson:"route" json:"route" example:"/admin/users"`
+	Calls           float64   `bson:"calls" json:"calls" example:"1520"`
+	Sampled         int       `bson:"sampled" json:"sampled" example:"152"`
+	ClientErrors    float64   `bson:"client_errors" json:"client_errors"`
+	ServerErrors    float64   `bson:"server_errors" json:"server_errors"`
+	Unauthenticated float64   `bson:"unauthenticated" json:"unauthenticated"`
+	AvgLatencyMs    float64   `bson:"avg_latency_ms" json:"avg_latency_ms"`
+	MaxLatencyMs    float64   `bson:"max_latency_ms" json:"max_latency_ms"`
+	LastSeen        time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+var (
+	service    string
+	secret     []byte
+	sampleRate float64
+	queue      chan Call
+)
+
+// Init creates the TTL index for recorded calls and starts storing them.
+// Without it the middleware only passes requests through.
+func Init(cfg *config.Config) {
+	service = cfg.ServiceName
+	secret = []byte(cfg.ServiceTokenSecret)
+	sampleRate = cfg.ServiceTrafficSampleRate
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(cfg.ServiceTrafficTTL.Seconds())),
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("servicetraffic: failed to create TTL index: %v", err)
+	}
+
+	queue = make(chan Call, queueSize)
+	go func() {
+		for call := range queue {
+			if _, err := database.DB.Collection(collection).InsertOne(context.Background(), call); err != nil {
+				log.Printf("servicetraffic: failed to store call from %s: %v", call.Caller, err)
+			}
+		}
+	}()
+}
+
+// Sign returns a token identifying service, valid for ttl
+func Sign(secret, service string, ttl time.Duration) (string, error) {
+	if secret == "" {
+		return "", errors.New("service token secret is not configured")
+	}
+	now := clock.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"svc": service,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}).SignedString([]byte(secret))
+}
+
+// Transport signs outgoing requests as Service. Base defaults to
+// http.DefaultTransport.
+type Transport struct {
+	Base    http.RoundTripper
+	Service string
+	Secret  string
+}
+
+// RoundTrip adds a fresh service token to a copy of the request
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := Sign(t.Secret, t.Service, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(TokenHeader, token)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// Caller returns the service named by a request's token, if it carries a
+// token that verifies
+func Caller(r *http.Request) (string, bool) {
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		return "", false
+	}
+	return identify(token)
+}
+
+// identify returns the service named by a token and whether it verified
+func identify(token string) (string, bool) {
+	if len(secret) == 0 {
+		return UnknownCaller, false
+	}
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return UnknownCaller, false
+	}
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	name, _ := claims["svc"].(string)
+	if name == "" {
+		return UnknownCaller, false
+	}
+	return name, true
+}
+
+// Middleware records a sample of requests that carry a service token.
+// Requests without one are not service calls and pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(TokenHeader)
+		if token == "" || queue == nil || sampleRate <= 0 || rand.Float64() >= sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := utils.NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+		latency := time.Since(start)
+
+		caller, authenticated := identify(token)
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		call := Call{
+			ID:            clock.NewID(),
+			Caller:        caller,
+			Authenticated: authenticated,
+			Target:        service,
+			Method:        r.Method,
+			Route:         route,
+			Status:        sw.Status,
+			Outcome:       outcome(sw.Status),
+			LatencyMs:     float64(latency.Microseconds()) / 1000,
+			Weight:        1 / sampleRate,
+			CreatedAt:     clock.Now(),
+		}
+		select {
+		case queue <- call:
+		default:
+			log.Printf("servicetraffic: queue full, dropping call from %s to %s %s", caller, r.Method, route)
+		}
+	})
+}
+
+func outcome(status int) string {
+	switch {
+	case status >= 500:
+		return OutcomeServerError
+	case status >= 400:
+		return OutcomeClientError
+	}
+	return OutcomeSuccess
+}
+
+// Summarize groups the calls recorded since a time by caller and route,
+// busiest first
+func Summarize(ctx context.Context, since time.Time) ([]Dependency, error) {
+	weightIf := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, "$weight", 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":             bson.M{"caller": "$caller", "method": "$method", "route": "$route"},
+			"calls":           bson.M{"$sum": "$weight"},
+			"sampled":         bson.M{"$sum": 1},
+			"client_errors":   weightIf(bson.M{"$eq": bson.A{"$outcome", OutcomeClientError}}),
+			"server_errors":   weightIf(bson.M{"$eq": bson.A{"$outcome", OutcomeServerError}}),
+			"unauthenticated": weightIf(bson.M{"$eq": bson.A{"$authenticated", false}}),
+			"avg_latency_ms":  bson.M{"$avg": "$latency_ms"},
+			"max_latency_ms":  bson.M{"$max": "$latency_ms"},
+			"last_seen":       bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"caller": "$_id.caller", "method": "$_id.method", "route": "$_id.route"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "calls", Value: -1}, {Key: "caller", Value: 1}}}},
+	}
+
+	cursor, err := database.DB.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	dependencies := []Dependency{}
+	err = cursor.All(ctx, &dependencies)
+	return dependencies, err
+}
+
+// Service returns the name calls are recorded against
+func Service() string {
+	return service
+}
+
+// SampleRate returns the share of service calls being recorded
+func SampleRate() float64 {
+	return sampleRate
+}
@@ -0,0 +1,44 @@
+package servicetraffic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRecordsStreamingCalls(t *testing.T) {
+	service, secret, sampleRate, queue = "user-service", []byte("service-secret"), 1, make(chan Call, 1)
+	t.Cleanup(func() { secret, sampleRate, queue = nil, 0, nil })
+
+	token, err := Sign("service-secret", "admin-service", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("{}\n"))
+		flusher.Flush()
+	}))
+
+	req := httptest.NewRequest("GET", "/admin/users/export.ndjson", nil)
+	req.Header.Set(TokenHeader, token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !rec.Flushed {
+		t.Fatalf("got %d, flushed %v; want 200 and a flush through the middleware", rec.Code, rec.Flushed)
+	}
+
+	select {
+	case call := <-queue:
+		if call.Caller != "admin-service" || !call.Authenticated || call.Status != http.StatusOK || call.Outcome != OutcomeSuccess {
+			t.Fatalf("recorded %+v", call)
+		}
+	default:
+		t.Fatal("call was not recorded")
+	}
+}
@@ -0,0 +1,162 @@
+// Package signupgate decides at registration whether a new account is
+// activated or put on the waitlist, for soft launches limited to some
+// countries, email domains or invited users.
+//
+// Rules are evaluated in order and the first that matches decides. Each is
+// "action:condition", where action is activate or waitlist. The condition
+// "*" matches every registration; the others are
+//
+//	invited / !invited      whether the registration carries an invitation
+//	country=US|CA           the country the request comes from (see geoip)
+//	country!=US|CA          any other country, including an unknown one
+//	email_domain=example.com|example.org
+//	email_domain!=example.com
+//
+// Registrations no rule matches are activated.
+package signupgate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Actions of a rule
+const (
+	ActionActivate = "activate"
+	ActionWaitlist = "waitlist"
+)
+
+// Attributes of a registration
+const (
+	AttrCountry     = "country"
+	AttrEmailDomain = "email_domain"
+	AttrInvited     = "invited"
+)
+
+// Attributes describe a registration to the rules
+type Attributes struct {
+	Country     string
+	EmailDomain string
+	Invited     bool
+}
+
+// rule is a parsed gating rule
+type rule struct {
+	source    string
+	action    string
+	attribute string
+	negate    bool
+	values    map[string]bool
+}
+
+// matches reports whether a rule's condition holds for a registration
+func (r *rule) matches(a Attributes) bool {
+	var match bool
+	switch r.attribute {
+	case "*":
+		return true
+	case AttrInvited:
+		match = a.Invited
+	case AttrCountry:
+		match = r.values[a.Country]
+	case AttrEmailDomain:
+		match = r.values[a.EmailDomain]
+	}
+	return match != r.negate
+}
+
+// rules are set by Init, in order
+var rules []rule
+
+// Init parses the gating rules from the configuration and indexes waitlisted
+// users. Rules that do not parse are logged and skipped.
+func Init(cfg *config.Config) {
+	rules = nil
+	for _, source := range cfg.SignupGateRules {
+		r, err := parse(source)
+		if err != nil {
+			log.Printf("signupgate: %v, ignoring", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"waitlist.at": 1}, Options: options.Index().SetSparse(true)}
+	for region, db := range database.Regions {
+		if _, err := db.Collection("users").Indexes().CreateOne(ctx, index); err != nil {
+			log.Printf("signupgate: failed to create waitlist index in region %s: %v", region, err)
+		}
+	}
+}
+
+// parse reads an "action:condition" rule
+func parse(source string) (rule, error) {
+	source = strings.TrimSpace(source)
+	action, condition, ok := strings.Cut(source, ":")
+	r := rule{source: source, action: strings.ToLower(action)}
+	if !ok || (r.action != ActionActivate && r.action != ActionWaitlist) {
+		return r, fmt.Errorf("rule %q must start with activate: or waitlist:", source)
+	}
+
+	condition = strings.TrimSpace(condition)
+	switch {
+	case condition == "*":
+		r.attribute = "*"
+		return r, nil
+	case strings.TrimPrefix(condition, "!") == AttrInvited:
+		r.attribute, r.negate = AttrInvited, strings.HasPrefix(condition, "!")
+		return r, nil
+	}
+
+	attribute, values, ok := strings.Cut(condition, "=")
+	if strings.HasSuffix(attribute, "!") {
+		attribute, r.negate = strings.TrimSuffix(attribute, "!"), true
+	}
+	r.attribute = strings.TrimSpace(attribute)
+	if !ok || (r.attribute != AttrCountry && r.attribute != AttrEmailDomain) || values == "" {
+		return r, fmt.Errorf("rule %q has an unknown condition", source)
+	}
+	r.values = make(map[string]bool)
+	for _, v := range strings.Split(values, "|") {
+		v = strings.TrimSpace(v)
+		if r.attribute == AttrCountry {
+			v = strings.ToUpper(v)
+		} else {
+			v = strings.ToLower(v)
+		}
+		r.values[v] = true
+	}
+	return r, nil
+}
+
+// Enabled reports whether any gating rule is configured
+func Enabled() bool {
+	return len(rules) > 0
+}
+
+// Evaluate returns the action for a registration and the rule that decided
+// it, or activate and "" when no rule matches
+func Evaluate(a Attributes) (action, decidedBy string) {
+	a.Country = strings.ToUpper(a.Country)
+	a.EmailDomain = strings.ToLower(a.EmailDomain)
+	for i := range rules {
+		if rules[i].matches(a) {
+			return rules[i].action, rules[i].source
+		}
+	}
+	return ActionActivate, ""
+}
@@ -0,0 +1,101 @@
+// Package singleuse stores the single-use tokens emailed to users, such as
+// password reset and sign-in links. A token is random, stored only as a
+// hash, expires after the store's TTL and works once; issuing a new one for
+// a user replaces the old.
+package singleuse
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// Token is a pending token. EmailHash binds it to the address it was sent
+// to, for flows that need one.
+type Token struct {
+	ID        string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	EmailHash string             `bson:"email_hash,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// Store issues and redeems the tokens of one flow
+type Store struct {
+	// Collection holds the pending tokens by hash
+	Collection string
+	TTL        time.Duration
+	// Cooldown is how long after issuing a token Issue refuses another for
+	// the same user, so the flow cannot be used to flood an inbox; zero
+	// allows one at any time
+	Cooldown time.Duration
+	// ErrInvalid is returned by Redeem for unknown, used and expired tokens,
+	// and ErrCooldown by Issue during the cooldown
+	ErrInvalid, ErrCooldown error
+}
+
+// Init creates the indexes, including the TTL index that drops expired tokens
+func (s *Store) Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"user_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	if _, err := database.DB.Collection(s.Collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("singleuse: failed to create %s indexes: %v", s.Collection, err)
+	}
+}
+
+// Issue creates a token for a user, voiding any earlier one. It returns
+// ErrCooldown instead when the last token is younger than Cooldown.
+func (s *Store) Issue(ctx context.Context, userID primitive.ObjectID, emailHash string) (string, error) {
+	tokens := database.DB.Collection(s.Collection)
+	now := clock.Now()
+
+	if s.Cooldown > 0 {
+		recent := bson.M{"user_id": userID, "created_at": bson.M{"$gt": now.Add(-s.Cooldown)}}
+		if n, err := tokens.CountDocuments(ctx, recent); err != nil {
+			return "", err
+		} else if n > 0 {
+			return "", s.ErrCooldown
+		}
+	}
+
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tokens.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return "", err
+	}
+	token := Token{ID: utils.HashToken(raw), UserID: userID, EmailHash: emailHash, CreatedAt: now, ExpiresAt: now.Add(s.TTL)}
+	if _, err := tokens.InsertOne(ctx, token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// Redeem consumes a token and returns it. Only one request can redeem a
+// token; later ones get ErrInvalid.
+func (s *Store) Redeem(ctx context.Context, raw string) (*Token, error) {
+	filter := bson.M{"_id": utils.HashToken(raw), "expires_at": bson.M{"$gt": clock.Now()}}
+	var token Token
+	err := database.DB.Collection(s.Collection).FindOneAndDelete(ctx, filter).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, s.ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
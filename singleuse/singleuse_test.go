@@ -0,0 +1,102 @@
+package singleuse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/clock"
+	"golang-backend/dbtest"
+)
+
+var (
+	errInvalid  = errors.New("invalid")
+	errCooldown = errors.New("cooldown")
+)
+
+// start returns a store on a fresh database, under a clock the test moves
+func start(t *testing.T) (*Store, *clock.Fixed) {
+	t.Helper()
+	dbtest.Start(t)
+	now := clock.NewFixed(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(now, nil)
+	t.Cleanup(func() { clock.Set(nil, nil) })
+	s := &Store{Collection: "tokens", TTL: time.Hour, Cooldown: time.Minute, ErrInvalid: errInvalid, ErrCooldown: errCooldown}
+	s.Init()
+	return s, now
+}
+
+func TestRedeemOnce(t *testing.T) {
+	s, _ := start(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	raw, err := s.Issue(ctx, userID, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.Redeem(ctx, raw)
+	if err != nil || token.UserID != userID || token.EmailHash != "hash" {
+		t.Fatalf("got %+v %v", token, err)
+	}
+	if _, err := s.Redeem(ctx, raw); err != errInvalid {
+		t.Fatalf("second redeem: %v, want ErrInvalid", err)
+	}
+	if _, err := s.Redeem(ctx, "unknown"); err != errInvalid {
+		t.Fatalf("unknown token: %v, want ErrInvalid", err)
+	}
+}
+
+func TestRedeemExpired(t *testing.T) {
+	s, now := start(t)
+	ctx := context.Background()
+	raw, err := s.Issue(ctx, primitive.NewObjectID(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now.Advance(time.Hour + time.Second)
+	if _, err := s.Redeem(ctx, raw); err != errInvalid {
+		t.Fatalf("got %v, want ErrInvalid", err)
+	}
+}
+
+func TestIssueCooldownAndReplace(t *testing.T) {
+	s, now := start(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	first, err := s.Issue(ctx, userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Issue(ctx, userID, ""); err != errCooldown {
+		t.Fatalf("got %v, want ErrCooldown", err)
+	}
+	if _, err := s.Issue(ctx, primitive.NewObjectID(), ""); err != nil {
+		t.Fatalf("another user: %v", err)
+	}
+
+	now.Advance(2 * time.Minute)
+	second, err := s.Issue(ctx, userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Redeem(ctx, first); err != errInvalid {
+		t.Fatalf("replaced token: %v, want ErrInvalid", err)
+	}
+	if _, err := s.Redeem(ctx, second); err != nil {
+		t.Fatalf("new token: %v", err)
+	}
+}
+
+func TestIssueWithoutCooldown(t *testing.T) {
+	s, _ := start(t)
+	s.Cooldown = 0
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	for i := 0; i < 2; i++ {
+		if _, err := s.Issue(ctx, userID, ""); err != nil {
+			t.Fatalf("issue %d: %v", i, err)
+		}
+	}
+}
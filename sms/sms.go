@@ -0,0 +1,102 @@
+// Package sms sends text messages, such as sign-in codes, through the
+// provider picked by SMS_PROVIDER. Other providers plug in by implementing
+// Sender.
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang-backend/config"
+)
+
+// Sender delivers text messages to phone numbers in E.164 format
+type Sender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// New returns the sender of SMS_PROVIDER: twilio, webhook, or a log sender
+// for anything else
+func New(cfg *config.Config) Sender {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch cfg.SMSProvider {
+	case "twilio":
+		return &TwilioSender{AccountSID: cfg.TwilioAccountSID, AuthToken: cfg.TwilioAuthToken, From: cfg.SMSFrom, Client: client}
+	case "webhook":
+		return &WebhookSender{URL: cfg.SMSWebhookURL, From: cfg.SMSFrom, Client: client}
+	default:
+		return LogSender{}
+	}
+}
+
+// LogSender writes messages to the application log instead of sending them
+type LogSender struct{}
+
+// Send logs the message
+func (LogSender) Send(ctx context.Context, to, body string) error {
+	log.Printf("sms: to=%s\n%s", to, body)
+	return nil
+}
+
+// TwilioSender sends messages through the Twilio Messages API
+type TwilioSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+	Client     *http.Client
+}
+
+// Send posts the message to Twilio
+func (t *TwilioSender) Send(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "From": {t.From}, "Body": {body}}
+	endpoint := "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return send(t.Client, req)
+}
+
+// WebhookSender posts messages as JSON to a gateway of the deployment's
+// choosing: {"from": "...", "to": "+4915112345678", "body": "..."}
+type WebhookSender struct {
+	URL    string
+	From   string
+	Client *http.Client
+}
+
+// Send posts the message to the webhook
+func (wh *WebhookSender) Send(ctx context.Context, to, body string) error {
+	payload, err := json.Marshal(map[string]string{"from": wh.From, "to": to, "body": body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return send(wh.Client, req)
+}
+
+// send performs a provider request, failing on non-2xx responses
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms: provider returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Package storage talks to S3 compatible object storage over its REST API:
+// AWS S3, and through their S3 interoperability, Google Cloud Storage (with
+// HMAC keys), MinIO or Cloudflare R2. Requests are signed with AWS Signature
+// Version 4, either directly or as presigned URLs clients use to transfer
+// files without going through the API servers.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-backend/clock"
+)
+
+// ErrNotFound is returned for objects that do not exist
+var ErrNotFound = errors.New("object not found")
+
+// Bucket is a bucket in an object store. Without an Endpoint it is an AWS S3
+// bucket addressed by virtual host; with one, such as
+// https://storage.googleapis.com, objects are addressed by path.
+type Bucket struct {
+	Name        string
+	Endpoint    string
+	Credentials Credentials
+
+	client *http.Client
+}
+
+// ObjectInfo describes a stored object. ETag is the MD5 of the content for
+// objects uploaded in a single request.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// NewBucket returns a bucket using creds for every request
+func NewBucket(name, endpoint string, creds Credentials) *Bucket {
+	return &Bucket{Name: name, Endpoint: endpoint, Credentials: creds, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// URL returns the URL of an object
+func (b *Bucket) URL(key string) *url.URL {
+	var escaped []string
+	for _, segment := range strings.Split(key, "/") {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	path := strings.Join(escaped, "/")
+	if b.Endpoint != "" {
+		u, _ := url.Parse(strings.TrimRight(b.Endpoint, "/") + "/" + b.Name + "/" + path)
+		return u
+	}
+	u, _ := url.Parse("https://" + b.Name + ".s3." + b.Credentials.Region + ".amazonaws.com/" + path)
+	return u
+}
+
+// bucketURL returns the URL of the bucket itself
+func (b *Bucket) bucketURL() *url.URL {
+	if b.Endpoint != "" {
+		u, _ := url.Parse(strings.TrimRight(b.Endpoint, "/") + "/" + b.Name)
+		return u
+	}
+	u, _ := url.Parse("https://" + b.Name + ".s3." + b.Credentials.Region + ".amazonaws.com/")
+	return u
+}
+
+// PresignPut returns a URL to upload an object with a PUT request carrying
+// exactly the given Content-Type and Content-Length
+func (b *Bucket) PresignPut(key, contentType string, size int64, expires time.Duration) string {
+	headers := map[string]string{"content-type": contentType, "content-length": strconv.FormatInt(size, 10)}
+	return b.Credentials.Presign(http.MethodPut, b.URL(key), headers, expires, clock.Now())
+}
+
+// PresignGet returns a URL to download an object
+func (b *Bucket) PresignGet(key string, expires time.Duration) string {
+	return b.Credentials.Presign(http.MethodGet, b.URL(key), nil, expires, clock.Now())
+}
+
+// Put stores an object
+func (b *Bucket) Put(ctx context.Context, key string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.URL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := b.do(req, sha256Hex(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Head returns an object's metadata
+func (b *Bucket) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, b.URL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(req, sha256Hex(nil))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:          key,
+		Size:         resp.ContentLength,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         strings.Trim(resp.Header.Get("ETag"), `"`),
+		LastModified: modified,
+	}, nil
+}
+
+// Get returns the content of an object
+func (b *Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(req, sha256Hex(nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ReadHead returns up to the first n bytes of an object
+func (b *Bucket) ReadHead(ctx context.Context, key string, n int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	resp, err := b.do(req, sha256Hex(nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, n))
+}
+
+// List calls fn with every page of up to 1000 objects under prefix, until fn
+// returns an error
+func (b *Bucket) List(ctx context.Context, prefix string, fn func([]ObjectInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u := b.bucketURL()
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := b.do(req, sha256Hex(nil))
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+				Size         int64     `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		page := make([]ObjectInfo, len(result.Contents))
+		for i, c := range result.Contents {
+			page[i] = ObjectInfo{Key: c.Key, Size: c.Size, ETag: strings.Trim(c.ETag, `"`), LastModified: c.LastModified}
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete removes an object; deleting a missing object succeeds
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, b.URL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req, sha256Hex(nil))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do signs and sends a request, turning error statuses into errors
+func (b *Bucket) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	b.Credentials.Sign(req, payloadHash, clock.Now())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s %s returned %d: %s", req.Method, req.URL.Path, resp.StatusCode, msg)
+	}
+	return resp, nil
+}
@@ -0,0 +1,360 @@
+// Package synthetic runs scheduled self-tests of the critical flows: a
+// database roundtrip, and registering, logging in and verifying the token of a
+// throwaway user. The flows use the same password hashing, encryption and
+// token signing as the handlers, but the test user lives in a sandbox
+// collection so it never shows up among real users.
+//
+// Results are exported as Prometheus metrics, reported as the "synthetic"
+// component of the status page, and a check failing FailureThreshold times in
+// a row raises an alert; another is sent when it recovers.
+package synthetic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/health"
+	"golang-backend/keys"
+	"golang-backend/models"
+	"golang-backend/notifier"
+	"golang-backend/tokens"
+	"golang-backend/utils"
+)
+
+// Checks in the order they run
+const (
+	CheckDBRoundtrip = "db_roundtrip"
+	CheckRegister    = "register"
+	CheckLogin       = "login"
+	CheckTokenVerify = "token_verify"
+)
+
+// Alert types raised by the prober
+const (
+	AlertFailure   = "synthetic.failure"
+	AlertRecovered = "synthetic.recovered"
+)
+
+// Sandbox collections the checks write to
+const (
+	probeCollection = "synthetic_probes"
+	userCollection  = "synthetic_users"
+)
+
+// runTimeout bounds one run of all checks
+const runTimeout = 30 * time.Second
+
+// Result is the latest outcome of a check and its counters since startup
+type Result struct {
+	Check       string    `json:"check"`
+	OK          bool      `json:"ok"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  float64   `json:"duration_ms"`
+	LastRun     time.Time `json:"last_run"`
+	Runs        int64     `json:"runs"`
+	Failures    int64     `json:"failures"`
+	Consecutive int       `json:"consecutive_failures"`
+	alerted     bool
+}
+
+// Prober runs the checks and keeps their results
+type Prober struct {
+	cfg      *config.Config
+	notifier *notifier.Notifier
+
+	mu      sync.Mutex
+	results map[string]*Result
+}
+
+var current *Prober
+
+// Start runs the checks every SYNTHETIC_INTERVAL when synthetic tests are
+// enabled, and adds them to the status page
+func Start(cfg *config.Config, n *notifier.Notifier) {
+	if !cfg.SyntheticEnabled {
+		return
+	}
+
+	p := &Prober{cfg: cfg, notifier: n, results: make(map[string]*Result)}
+	current = p
+
+	health.Register("synthetic", false, func(ctx context.Context) error {
+		return p.failing()
+	})
+
+	go func() {
+		ticker := time.NewTicker(cfg.SyntheticInterval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+			p.Run(ctx)
+			cancel()
+			<-ticker.C
+		}
+	}()
+
+	log.Println("Synthetic self-tests started")
+}
+
+// Run executes every check once. Checks depending on a failed one fail too,
+// without running.
+func (p *Prober) Run(ctx context.Context) {
+	password, err := utils.RandomToken(16)
+	if err != nil {
+		log.Printf("synthetic: failed to generate password: %v", err)
+		return
+	}
+	email := fmt.Sprintf("synthetic-%s@probe.invalid", clock.NewID().Hex())
+
+	var user *models.User
+	var token string
+
+	p.check(ctx, CheckDBRoundtrip, "", p.dbRoundtrip)
+	p.check(ctx, CheckRegister, "", func(ctx context.Context) (err error) {
+		user, err = p.register(ctx, email, password)
+		return err
+	})
+	skip := ""
+	if user == nil {
+		skip = CheckRegister
+	}
+	p.check(ctx, CheckLogin, skip, func(ctx context.Context) (err error) {
+		token, err = p.login(ctx, email, password)
+		return err
+	})
+	if token == "" && skip == "" {
+		skip = CheckLogin
+	}
+	p.check(ctx, CheckTokenVerify, skip, func(ctx context.Context) error {
+		return p.verify(token, user)
+	})
+
+	if user != nil {
+		if _, err := database.DB.Collection(userCollection).DeleteOne(context.Background(), bson.M{"_id": user.ID}); err != nil {
+			log.Printf("synthetic: failed to remove test user: %v", err)
+		}
+	}
+}
+
+// check times one check, records the result and alerts on failure streaks.
+// A non-empty skip names the failed check this one depends on.
+func (p *Prober) check(ctx context.Context, name, skip string, fn func(context.Context) error) {
+	start := time.Now()
+	var err error
+	if skip != "" {
+		err = fmt.Errorf("skipped after %s failed", skip)
+	} else {
+		err = fn(ctx)
+	}
+	duration := time.Since(start)
+
+	p.mu.Lock()
+	result, ok := p.results[name]
+	if !ok {
+		result = &Result{Check: name}
+		p.results[name] = result
+	}
+	result.Runs++
+	result.OK = err == nil
+	result.DurationMs = float64(duration.Microseconds()) / 1000
+	result.LastRun = clock.Now()
+	result.Error = ""
+
+	var alert *notifier.Event
+	if err != nil {
+		result.Error = err.Error()
+		result.Failures++
+		result.Consecutive++
+		if result.Consecutive >= p.cfg.SyntheticFailureThreshold && !result.alerted {
+			result.alerted = true
+			alert = &notifier.Event{
+				Type:     AlertFailure,
+				Severity: notifier.SeverityCritical,
+				Message:  fmt.Sprintf("Synthetic check %s failed %d times in a row: %v", name, result.Consecutive, err),
+				Data:     map[string]interface{}{"check": name, "consecutive_failures": result.Consecutive, "error": err.Error()},
+			}
+		}
+	} else {
+		if result.alerted {
+			alert = &notifier.Event{
+				Type:     AlertRecovered,
+				Severity: notifier.SeverityInfo,
+				Message:  fmt.Sprintf("Synthetic check %s recovered after %d failures", name, result.Consecutive),
+				Data:     map[string]interface{}{"check": name, "failures": result.Consecutive},
+			}
+		}
+		result.Consecutive = 0
+		result.alerted = false
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		log.Printf("synthetic: %s failed: %v", name, err)
+	}
+	if alert != nil {
+		p.notifier.Send(*alert)
+	}
+}
+
+// dbRoundtrip writes, reads back and deletes a document
+func (p *Prober) dbRoundtrip(ctx context.Context) error {
+	collection := database.DB.Collection(probeCollection)
+	id := clock.NewID()
+	if _, err := collection.InsertOne(ctx, bson.M{"_id": id, "created_at": clock.Now()}); err != nil {
+		return fmt.Errorf("insert: %w", err)
+	}
+	var doc bson.M
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return fmt.Errorf("read back: %w", err)
+	}
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// register stores a test user the way Register does
+func (p *Prober) register(ctx context.Context, email, password string) (*models.User, error) {
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+	encrypted, err := keys.Encrypt(ctx, p.cfg, "", email)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt email: %w", err)
+	}
+
+	now := clock.Now()
+	user := &models.User{
+		ID:        clock.NewID(),
+		Email:     encrypted,
+		EmailHash: utils.EmailIndex(email, p.cfg.EmailFoldAliases),
+		Password:  hashed,
+		Role:      "user",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := database.DB.Collection(userCollection).InsertOne(ctx, user); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	return user, nil
+}
+
+// login looks the test user up, checks its password and signs a token the
+// way Login does
+func (p *Prober) login(ctx context.Context, email, password string) (string, error) {
+	var user models.User
+	filter := bson.M{"email_hash": utils.EmailIndex(email, p.cfg.EmailFoldAliases)}
+	if err := database.DB.Collection(userCollection).FindOne(ctx, filter).Decode(&user); err != nil {
+		return "", fmt.Errorf("find user: %w", err)
+	}
+	if err := utils.ComparePassword(user.Password, password); err != nil {
+		return "", fmt.Errorf("compare password: %w", err)
+	}
+	decrypted, err := keys.Decrypt(ctx, p.cfg, user.Email)
+	if err != nil {
+		return "", fmt.Errorf("decrypt email: %w", err)
+	}
+	if decrypted != email {
+		return "", errors.New("decrypted email does not match")
+	}
+
+	token, err := tokens.Sign(jwt.MapClaims{
+		"userID":      user.ID.Hex(),
+		"email":       decrypted,
+		"role":        user.Role,
+		"roleVersion": user.RoleVersion,
+		"exp":         clock.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("sign token: %w", err)
+	}
+	return token, nil
+}
+
+// verify checks the token the way JWTAuthMiddleware does
+func (p *Prober) verify(tokenString string, user *models.User) error {
+	token, err := tokens.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return fmt.Errorf("token rejected: %v", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if claims["userID"] != user.ID.Hex() {
+		return errors.New("token names another user")
+	}
+	return nil
+}
+
+// failing returns an error naming the checks whose last run failed
+func (p *Prober) failing() error {
+	var failed []string
+	for _, result := range p.Results() {
+		if !result.OK {
+			failed = append(failed, result.Check)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failing checks: %v", failed)
+	}
+	return nil
+}
+
+// Results returns the latest result of every check, by name
+func (p *Prober) Results() []Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := make([]Result, 0, len(p.results))
+	for _, result := range p.results {
+		results = append(results, *result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Check < results[j].Check })
+	return results
+}
+
+// WriteMetrics writes the check results in the Prometheus text format. It
+// writes nothing when synthetic tests are disabled.
+func WriteMetrics(w io.Writer) {
+	if current == nil {
+		return
+	}
+	results := current.Results()
+
+	metric := func(name, kind, help string, value func(Result) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, result := range results {
+			fmt.Fprintf(w, "%s{check=%q} %g\n", name, result.Check, value(result))
+		}
+	}
+	metric("synthetic_check_success", "gauge", "Whether the last run of the check passed.", func(r Result) float64 {
+		if r.OK {
+			return 1
+		}
+		return 0
+	})
+	metric("synthetic_check_duration_seconds", "gauge", "Duration of the last run of the check.", func(r Result) float64 {
+		return r.DurationMs / 1000
+	})
+	metric("synthetic_check_last_run_timestamp_seconds", "gauge", "Unix time of the last run of the check.", func(r Result) float64 {
+		return float64(r.LastRun.Unix())
+	})
+	metric("synthetic_check_consecutive_failures", "gauge", "Failures of the check since it last passed.", func(r Result) float64 {
+		return float64(r.Consecutive)
+	})
+	metric("synthetic_check_runs_total", "counter", "Runs of the check since startup.", func(r Result) float64 {
+		return float64(r.Runs)
+	})
+	metric("synthetic_check_failures_total", "counter", "Failed runs of the check since startup.", func(r Result) float64 {
+		return float64(r.Failures)
+	})
+}
@@ -0,0 +1,196 @@
+// Package sysmessages keeps the admin-managed banners frontends show above
+// the app: scheduled maintenance windows, ongoing incidents and other
+// announcements. Each message has an optional start and end time and may be
+// limited to some roles, organizations or user tags.
+package sysmessages
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+)
+
+// collection holds the messages
+const collection = "system_messages"
+
+// reloadInterval bounds how long an instance serves a stale message list;
+// every open frontend polls, so the list is not read per request
+const reloadInterval = 30 * time.Second
+
+var (
+	mu       sync.RWMutex
+	current  []models.SystemMessage
+	loadedAt time.Time
+)
+
+// severityOrder sorts critical banners first
+var severityOrder = map[string]int{models.SeverityCritical: 0, models.SeverityWarning: 1, models.SeverityInfo: 2}
+
+// List returns every message, including scheduled and ended ones, newest first
+func List(ctx context.Context) ([]models.SystemMessage, error) {
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	messages := []models.SystemMessage{}
+	err = cursor.All(ctx, &messages)
+	return messages, err
+}
+
+// Viewer is who active messages are selected for. Visitors who are not
+// signed in have an empty role and organization and only see messages for
+// everyone.
+type Viewer struct {
+	Role  string
+	OrgID string
+	// Tags loads the viewer's user tags. It is only called when a message
+	// targets tags, and may be nil for visitors.
+	Tags func() []string
+}
+
+// Active returns the messages showing now to a viewer, most severe first
+func Active(ctx context.Context, viewer Viewer) ([]models.SystemMessage, error) {
+	mu.RLock()
+	list, fresh := current, time.Since(loadedAt) < reloadInterval
+	mu.RUnlock()
+	if !fresh {
+		var err error
+		if list, err = Reload(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []string
+	tagsLoaded := false
+	now := clock.Now()
+	active := []models.SystemMessage{}
+	for _, msg := range list {
+		if msg.StartsAt != nil && now.Before(*msg.StartsAt) {
+			continue
+		}
+		if msg.EndsAt != nil && !now.Before(*msg.EndsAt) {
+			continue
+		}
+		if len(msg.Roles) > 0 && !contains(msg.Roles, viewer.Role) {
+			continue
+		}
+		if len(msg.OrgIDs) > 0 && !contains(msg.OrgIDs, viewer.OrgID) {
+			continue
+		}
+		if len(msg.Tags) > 0 {
+			if !tagsLoaded && viewer.Tags != nil {
+				tags, tagsLoaded = viewer.Tags(), true
+			}
+			if !containsAny(msg.Tags, tags) {
+				continue
+			}
+		}
+		active = append(active, msg)
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return severityOrder[active[i].Severity] < severityOrder[active[j].Severity]
+	})
+	return active, nil
+}
+
+// Reload reads the messages that have not ended yet, e.g. after an admin
+// change
+func Reload(ctx context.Context) ([]models.SystemMessage, error) {
+	filter := bson.M{"$or": []bson.M{{"ends_at": nil}, {"ends_at": bson.M{"$gt": clock.Now()}}}}
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"starts_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var list []models.SystemMessage
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	current, loadedAt = list, time.Now()
+	mu.Unlock()
+	return list, nil
+}
+
+// Create stores a message and reloads the list
+func Create(ctx context.Context, msg models.SystemMessage) (*models.SystemMessage, error) {
+	msg.ID = clock.NewID()
+	msg.CreatedAt = clock.Now()
+	msg.UpdatedAt = msg.CreatedAt
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, msg); err != nil {
+		return nil, err
+	}
+	_, err := Reload(ctx)
+	return &msg, err
+}
+
+// Update replaces a message's content, schedule and audience and reloads the
+// list. It returns the message before and after, or mongo.ErrNoDocuments
+// when no message has the ID.
+func Update(ctx context.Context, id primitive.ObjectID, msg models.SystemMessage) (*models.SystemMessage, *models.SystemMessage, error) {
+	now := clock.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"kind":       msg.Kind,
+			"severity":   msg.Severity,
+			"title":      msg.Title,
+			"body":       msg.Body,
+			"starts_at":  msg.StartsAt,
+			"ends_at":    msg.EndsAt,
+			"roles":      msg.Roles,
+			"org_ids":    msg.OrgIDs,
+			"tags":       msg.Tags,
+			"updated_at": now,
+		},
+	}
+	var before models.SystemMessage
+	if err := database.DB.Collection(collection).FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(&before); err != nil {
+		return nil, nil, err
+	}
+	after := msg
+	after.ID, after.CreatedBy, after.CreatedAt = before.ID, before.CreatedBy, before.CreatedAt
+	after.UpdatedAt = now
+	_, err := Reload(ctx)
+	return &before, &after, err
+}
+
+// Delete removes a message and reloads the list. It returns
+// mongo.ErrNoDocuments when no message has the ID.
+func Delete(ctx context.Context, id primitive.ObjectID) (*models.SystemMessage, error) {
+	var removed models.SystemMessage
+	if err := database.DB.Collection(collection).FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&removed); err != nil {
+		return nil, err
+	}
+	_, err := Reload(ctx)
+	return &removed, err
+}
+
+func contains(list []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny reports whether list and values share a value
+func containsAny(list, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+// Package tenant resolves the organization a request is for from its Host
+// header. Organizations register custom domains and prove ownership with a DNS
+// TXT record; requests to a verified domain carry that organization in their
+// context, which handlers use for branding and to scope data.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/utils"
+)
+
+// TXTPrefix is the label under which a domain's verification record lives,
+// e.g. _gbk-verify.app.acme.example
+const TXTPrefix = "_gbk-verify."
+
+// cacheTTL bounds how long a host lookup, including a miss, is reused
+const cacheTTL = time.Minute
+
+// collection holds custom domains
+const collection = "org_domains"
+
+var (
+	// ErrDomainTaken is returned when another organization registered the host
+	ErrDomainTaken = errors.New("domain already registered")
+	// ErrNotVerified is returned when the TXT record is missing or wrong
+	ErrNotVerified = errors.New("verification record not found")
+)
+
+// Tenant is the organization a request was resolved to
+type Tenant struct {
+	OrgID string
+	Host  string
+}
+
+type contextKey struct{}
+
+// FromContext returns the request's tenant, or nil on the primary domain
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
+
+// OrgID returns the request's tenant organization ID, or "" on the primary domain
+func OrgID(r *http.Request) string {
+	if t := FromContext(r.Context()); t != nil {
+		return t.OrgID
+	}
+	return ""
+}
+
+// Middleware resolves the tenant from the Host header. Hosts that are not a
+// verified custom domain are served as the primary domain.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := NormalizeHost(r.Host)
+		orgID, err := resolve(r.Context(), host)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Failed to resolve tenant"}`, http.StatusInternalServerError)
+			return
+		}
+		if orgID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, &Tenant{OrgID: orgID, Host: host}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NormalizeHost lowercases a host and strips the port and any trailing dot
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+type cached struct {
+	orgID   string
+	expires time.Time
+}
+
+var (
+	mu    sync.RWMutex
+	hosts = make(map[string]cached)
+)
+
+// resolve returns the organization of a verified host, or "" for other hosts
+func resolve(ctx context.Context, host string) (string, error) {
+	mu.RLock()
+	entry, ok := hosts[host]
+	mu.RUnlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.orgID, nil
+	}
+
+	var domain models.OrgDomain
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"host": host, "verified": true}).Decode(&domain)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	orgID := ""
+	if err == nil {
+		orgID = domain.OrgID.Hex()
+	}
+
+	mu.Lock()
+	hosts[host] = cached{orgID: orgID, expires: time.Now().Add(cacheTTL)}
+	mu.Unlock()
+	return orgID, nil
+}
+
+// forget drops a host from the resolution cache after it changed
+func forget(host string) {
+	mu.Lock()
+	delete(hosts, host)
+	mu.Unlock()
+}
+
+// Add registers an unverified custom domain for an organization and returns
+// it with the token to publish in its TXT record
+func Add(ctx context.Context, orgID primitive.ObjectID, host string) (*models.OrgDomain, error) {
+	var existing models.OrgDomain
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"host": host}).Decode(&existing)
+	if err == nil {
+		if existing.OrgID != orgID {
+			return nil, ErrDomainTaken
+		}
+		return &existing, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	token, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := models.OrgDomain{ID: clock.NewID(), OrgID: orgID, Host: host, Token: token, CreatedAt: clock.Now()}
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, domain); err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// List returns an organization's custom domains
+func List(ctx context.Context, orgID primitive.ObjectID) ([]models.OrgDomain, error) {
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"org_id": orgID})
+	if err != nil {
+		return nil, err
+	}
+	domains := []models.OrgDomain{}
+	err = cursor.All(ctx, &domains)
+	return domains, err
+}
+
+// Get returns one of an organization's domains
+func Get(ctx context.Context, orgID, domainID primitive.ObjectID) (*models.OrgDomain, error) {
+	var domain models.OrgDomain
+	err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": domainID, "org_id": orgID}).Decode(&domain)
+	if err != nil {
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// TXTRecord returns the name and value of the record proving ownership of a domain
+func TXTRecord(domain *models.OrgDomain) (string, string) {
+	return TXTPrefix + domain.Host, "gbk-verify=" + domain.Token
+}
+
+// Verify looks up the domain's TXT record and marks it verified when it holds
+// the expected token
+func Verify(ctx context.Context, domain *models.OrgDomain) error {
+	name, want := TXTRecord(domain)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	records, err := net.DefaultResolver.LookupTXT(lookupCtx, name)
+	if err != nil {
+		return ErrNotVerified
+	}
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrNotVerified
+	}
+
+	now := clock.Now()
+	_, err = database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": domain.ID}, bson.M{"$set": bson.M{"verified": true, "verified_at": now}})
+	if err != nil {
+		return err
+	}
+	domain.Verified, domain.VerifiedAt = true, &now
+	forget(domain.Host)
+	return nil
+}
+
+// Remove deletes a custom domain; requests to it are served as the primary
+// domain again
+func Remove(ctx context.Context, domain *models.OrgDomain) error {
+	if _, err := database.DB.Collection(collection).DeleteOne(ctx, bson.M{"_id": domain.ID}); err != nil {
+		return err
+	}
+	forget(domain.Host)
+	return nil
+}
+
+// RemoveOrg deletes every custom domain of an organization and returns how
+// many were removed
+func RemoveOrg(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	domains, err := List(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+	result, err := database.DB.Collection(collection).DeleteMany(ctx, bson.M{"org_id": orgID})
+	if err != nil {
+		return 0, err
+	}
+	for _, domain := range domains {
+		forget(domain.Host)
+	}
+	return result.DeletedCount, nil
+}
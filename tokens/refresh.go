@@ -0,0 +1,169 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// refreshCollection holds refresh tokens by hash
+const refreshCollection = "refresh_tokens"
+
+var (
+	// ErrInvalidRefresh is returned for unknown, expired or revoked refresh
+	// tokens
+	ErrInvalidRefresh = errors.New("invalid refresh token")
+	// ErrRefreshReuse is returned when a refresh token is presented after it
+	// was already rotated; its whole family has been revoked
+	ErrRefreshReuse = errors.New("refresh token reused")
+)
+
+// RefreshRecord is an issued refresh token. Every rotation issues a new
+// token in the same family; a family starts at sign-in and keeps its
+// remember me policy.
+type RefreshRecord struct {
+	ID        string     `bson:"_id"`
+	TokenHash string     `bson:"token_hash"`
+	FamilyID  string     `bson:"family_id"`
+	UserID    string     `bson:"user_id"`
+	AccessID  string     `bson:"access_id"`
+	Remember  bool       `bson:"remember,omitempty"`
+	Client    Client     `bson:",inline"`
+	IssuedAt  time.Time  `bson:"issued_at"`
+	ExpiresAt time.Time  `bson:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+}
+
+// initRefresh indexes refresh tokens by hash and family, and drops them once
+// expired
+func initRefresh(ctx context.Context) {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"family_id": 1}},
+		{Keys: bson.M{"user_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	if _, err := database.DB.Collection(refreshCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("tokens: failed to create refresh token indexes: %v", err)
+	}
+}
+
+// IssueRefresh creates a refresh token for a user, paired with the ID of the
+// access token issued alongside it. An empty family starts a new one;
+// remember is kept for the successors of the token.
+func IssueRefresh(ctx context.Context, userID, family, accessID string, ttl time.Duration, remember bool, client Client) (string, error) {
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if family == "" {
+		family = NewID()
+	}
+	now := clock.Now()
+	record := RefreshRecord{
+		ID:        NewID(),
+		TokenHash: utils.HashToken(raw),
+		FamilyID:  family,
+		UserID:    userID,
+		AccessID:  accessID,
+		Remember:  remember,
+		Client:    client,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := database.DB.Collection(refreshCollection).InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// Redeem marks a refresh token used and returns it, so the caller can issue
+// its successor in the same family. Only one request can redeem a token. A
+// token redeemed before means it was stolen, by whoever presented it first
+// or now, so the whole family and its access tokens are revoked and
+// ErrRefreshReuse returned.
+func Redeem(ctx context.Context, raw string) (*RefreshRecord, error) {
+	now := clock.Now()
+	collection := database.DB.Collection(refreshCollection)
+	hash := utils.HashToken(raw)
+
+	filter := bson.M{"token_hash": hash, "used_at": nil, "revoked_at": nil, "expires_at": bson.M{"$gt": now}}
+	var record RefreshRecord
+	err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}).Decode(&record)
+	if err == nil {
+		return &record, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	// Tell a replayed token from an unknown, expired or revoked one
+	err = collection.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.UsedAt == nil || record.RevokedAt != nil || !record.ExpiresAt.After(now) {
+		return nil, ErrInvalidRefresh
+	}
+	if _, err := RevokeFamily(ctx, record.FamilyID); err != nil {
+		return nil, err
+	}
+	return &record, ErrRefreshReuse
+}
+
+// RevokeFamily revokes every refresh token of a family and the access
+// tokens issued with them. It returns how many refresh tokens were revoked.
+func RevokeFamily(ctx context.Context, family string) (int64, error) {
+	return revokeRefresh(ctx, bson.M{"family_id": family})
+}
+
+// revokeSessionRefresh revokes the refresh token family a session token was
+// issued with, if any
+func revokeSessionRefresh(ctx context.Context, userID, jti string) error {
+	families, err := database.DB.Collection(refreshCollection).Distinct(ctx, "family_id", bson.M{"user_id": userID, "access_id": jti})
+	if err != nil || len(families) == 0 {
+		return err
+	}
+	_, err = revokeRefresh(ctx, bson.M{"family_id": bson.M{"$in": families}})
+	return err
+}
+
+// revokeRefresh revokes the refresh tokens matching filter along with their
+// access tokens
+func revokeRefresh(ctx context.Context, filter bson.M) (int64, error) {
+	refresh := database.DB.Collection(refreshCollection)
+	ids, err := refresh.Distinct(ctx, "access_id", filter)
+	if err != nil {
+		return 0, err
+	}
+	now := clock.Now()
+	filter["revoked_at"] = nil
+	result, err := refresh.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": now}})
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) > 0 && enabled {
+		_, err := database.DB.Collection(collection).UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "revoked_at": nil},
+			bson.M{"$set": bson.M{"revoked_at": now}})
+		if err != nil {
+			return 0, err
+		}
+		mu.Lock()
+		cache = make(map[string]cached)
+		mu.Unlock()
+	}
+	return result.ModifiedCount, nil
+}
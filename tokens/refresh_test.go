@@ -0,0 +1,230 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-backend/clock"
+	"golang-backend/dbtest"
+)
+
+// start stores token IDs and refresh tokens under a clock the test moves.
+// Unlike Init it loads no secrets, which these tests do not sign with.
+func start(t *testing.T) *clock.Fixed {
+	t.Helper()
+	dbtest.Start(t)
+	now := clock.NewFixed(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(now, nil)
+	initRefresh(context.Background())
+	enabled = true
+	t.Cleanup(func() {
+		clock.Set(nil, nil)
+		enabled = false
+		mu.Lock()
+		cache = make(map[string]cached)
+		mu.Unlock()
+	})
+	return now
+}
+
+// signIn issues an access token ID and a refresh token in family, a new
+// family when empty, and returns the access ID and the raw refresh token
+func signIn(t *testing.T, userID, family string) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	jti := NewID()
+	if err := Issue(ctx, jti, userID, clock.Now().Add(15*time.Minute), Client{}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := IssueRefresh(ctx, userID, family, jti, time.Hour, false, Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jti, raw
+}
+
+// state returns the state of a token ID
+func state(t *testing.T, jti string) string {
+	t.Helper()
+	s, _, err := Lookup(context.Background(), jti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRedeemRotates(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	jti, raw := signIn(t, "u1", "")
+	record, err := Redeem(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.UserID != "u1" || record.AccessID != jti || record.FamilyID == "" {
+		t.Fatalf("got %+v", record)
+	}
+
+	_, next := signIn(t, "u1", record.FamilyID)
+	successor, err := Redeem(ctx, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if successor.FamilyID != record.FamilyID {
+		t.Fatalf("successor in family %q, want %q", successor.FamilyID, record.FamilyID)
+	}
+}
+
+func TestRedeemReuseRevokesFamily(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	firstAccess, first := signIn(t, "u1", "")
+	record, err := Redeem(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextAccess, next := signIn(t, "u1", record.FamilyID)
+	otherAccess, other := signIn(t, "u1", "")
+
+	// The rotated token comes back: whoever holds either token is suspect
+	reused, err := Redeem(ctx, first)
+	if !errors.Is(err, ErrRefreshReuse) {
+		t.Fatalf("got %v, want ErrRefreshReuse", err)
+	}
+	if reused == nil || reused.FamilyID != record.FamilyID {
+		t.Fatalf("reuse reported for %+v", reused)
+	}
+	if _, err := Redeem(ctx, next); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("successor: got %v, want ErrInvalidRefresh", err)
+	}
+	if _, err := Redeem(ctx, first); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("reused again: got %v, want ErrInvalidRefresh once revoked", err)
+	}
+	for _, jti := range []string{firstAccess, nextAccess} {
+		if s := state(t, jti); s != StateRevoked {
+			t.Fatalf("access token of the family is %s", s)
+		}
+	}
+
+	// Another sign-in of the same user is left alone
+	if s := state(t, otherAccess); s != StateActive {
+		t.Fatalf("access token of another family is %s", s)
+	}
+	if _, err := Redeem(ctx, other); err != nil {
+		t.Fatalf("refresh token of another family: %v", err)
+	}
+}
+
+func TestRedeemRejects(t *testing.T) {
+	cases := []struct {
+		name  string
+		token func(t *testing.T, now *clock.Fixed) string
+	}{
+		{"unknown", func(t *testing.T, now *clock.Fixed) string { return "unknown" }},
+		{"expired", func(t *testing.T, now *clock.Fixed) string {
+			_, raw := signIn(t, "u1", "")
+			now.Advance(time.Hour)
+			return raw
+		}},
+		{"revoked with the user", func(t *testing.T, now *clock.Fixed) string {
+			_, raw := signIn(t, "u1", "")
+			if _, err := RevokeUser(context.Background(), "u1"); err != nil {
+				t.Fatal(err)
+			}
+			return raw
+		}},
+		{"revoked with its session", func(t *testing.T, now *clock.Fixed) string {
+			jti, raw := signIn(t, "u1", "")
+			if err := Revoke(context.Background(), "u1", jti); err != nil {
+				t.Fatal(err)
+			}
+			return raw
+		}},
+		{"used after it expired", func(t *testing.T, now *clock.Fixed) string {
+			_, raw := signIn(t, "u1", "")
+			if _, err := Redeem(context.Background(), raw); err != nil {
+				t.Fatal(err)
+			}
+			now.Advance(time.Hour)
+			return raw
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := start(t)
+			if _, err := Redeem(context.Background(), tc.token(t, now)); !errors.Is(err, ErrInvalidRefresh) {
+				t.Fatalf("got %v, want ErrInvalidRefresh", err)
+			}
+		})
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	first, firstRaw := signIn(t, "u1", "")
+	second, secondRaw := signIn(t, "u1", "")
+	stranger, strangerRaw := signIn(t, "u2", "")
+
+	revoked, err := RevokeUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 2 {
+		t.Fatalf("revoked %d sessions, want 2", revoked)
+	}
+	for _, jti := range []string{first, second} {
+		if s := state(t, jti); s != StateRevoked {
+			t.Fatalf("session of the user is %s", s)
+		}
+	}
+	for _, raw := range []string{firstRaw, secondRaw} {
+		if _, err := Redeem(ctx, raw); !errors.Is(err, ErrInvalidRefresh) {
+			t.Fatalf("refresh token of the user: got %v, want ErrInvalidRefresh", err)
+		}
+	}
+	if s := state(t, stranger); s != StateActive {
+		t.Fatalf("session of another user is %s", s)
+	}
+	if _, err := Redeem(ctx, strangerRaw); err != nil {
+		t.Fatalf("refresh token of another user: %v", err)
+	}
+}
+
+func TestRevokeOthers(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	keep, kept := signIn(t, "u1", "")
+	others := make([]string, 2)
+	raws := make([]string, 2)
+	for i := range others {
+		others[i], raws[i] = signIn(t, "u1", "")
+	}
+	stranger, _ := signIn(t, "u2", "")
+
+	revoked, err := RevokeOthers(ctx, "u1", keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 2 {
+		t.Fatalf("revoked %d sessions, want 2", revoked)
+	}
+	for i, jti := range others {
+		if s := state(t, jti); s != StateRevoked {
+			t.Fatalf("other session is %s", s)
+		}
+		if _, err := Redeem(ctx, raws[i]); !errors.Is(err, ErrInvalidRefresh) {
+			t.Fatalf("refresh token of another session: got %v, want ErrInvalidRefresh", err)
+		}
+	}
+	for _, jti := range []string{keep, stranger} {
+		if s := state(t, jti); s != StateActive {
+			t.Fatalf("kept session is %s", s)
+		}
+	}
+	if _, err := Redeem(ctx, kept); err != nil {
+		t.Fatalf("refresh token of the kept session: %v", err)
+	}
+}
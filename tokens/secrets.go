@@ -0,0 +1,279 @@
+package tokens
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// secretCollection holds signing secrets rotated through the admin API
+const secretCollection = "jwt_secrets"
+
+// reloadInterval is how often rotated secrets are reloaded. Tokens naming an
+// unknown key trigger a reload too, at most every minReloadGap.
+const (
+	reloadInterval = time.Minute
+	minReloadGap   = 5 * time.Second
+)
+
+// ErrUnknownKey is returned for tokens signed with no known secret
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+// StoredSecret is a signing secret rotated through the admin API, encrypted
+// with the master key. ExpiresAt is set once a newer secret replaced it.
+type StoredSecret struct {
+	KeyID     string     `bson:"_id" json:"kid"`
+	Secret    string     `bson:"secret" json:"-"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// SecretInfo describes a verification secret without revealing it
+type SecretInfo struct {
+	KeyID     string     `json:"kid" example:"3f9a1c2b"`
+	Source    string     `json:"source" example:"rotated"`
+	Signing   bool       `json:"signing"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type secret struct {
+	info SecretInfo
+	key  []byte
+}
+
+var (
+	secretsMu  sync.RWMutex
+	secrets    []secret
+	lastReload time.Time
+
+	secretsCfg *config.Config
+)
+
+// initSecrets loads the secrets and keeps reloading rotated ones
+func initSecrets(cfg *config.Config) {
+	secretsCfg = cfg
+	// Configured secrets work even if rotated ones cannot be loaded
+	list := configSecrets(cfg)
+	list[0].info.Signing = true
+	secretsMu.Lock()
+	secrets = list
+	secretsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := database.DB.Collection(secretCollection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("tokens: failed to create secret TTL index: %v", err)
+	}
+	if err := reloadSecrets(ctx); err != nil {
+		log.Printf("tokens: failed to load rotated secrets: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(reloadInterval) {
+			if err := reloadSecrets(context.Background()); err != nil {
+				log.Printf("tokens: failed to reload rotated secrets: %v", err)
+			}
+		}
+	}()
+}
+
+// reloadSecrets rebuilds the secret list: rotated secrets newest first, then
+// JWT_SECRETS in order
+func reloadSecrets(ctx context.Context) error {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := database.DB.Collection(secretCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	var stored []StoredSecret
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	list := make([]secret, 0, len(stored)+len(secretsCfg.JWTSecrets))
+	for _, s := range stored {
+		// The TTL monitor runs about once a minute
+		if s.ExpiresAt != nil && now.After(*s.ExpiresAt) {
+			continue
+		}
+		key, err := utils.Decrypt(s.Secret, secretsCfg.EncryptionKey)
+		if err != nil {
+			log.Printf("tokens: cannot decrypt secret %s: %v", s.KeyID, err)
+			continue
+		}
+		createdAt := s.CreatedAt
+		list = append(list, secret{
+			info: SecretInfo{KeyID: s.KeyID, Source: "rotated", CreatedAt: &createdAt, ExpiresAt: s.ExpiresAt},
+			key:  []byte(key),
+		})
+	}
+	list = append(list, configSecrets(secretsCfg)...)
+	list[0].info.Signing = true
+
+	secretsMu.Lock()
+	secrets, lastReload = list, time.Now()
+	secretsMu.Unlock()
+	return nil
+}
+
+// configSecrets returns the JWT_SECRETS list
+func configSecrets(cfg *config.Config) []secret {
+	list := make([]secret, 0, len(cfg.JWTSecrets))
+	for _, s := range cfg.JWTSecrets {
+		list = append(list, secret{info: SecretInfo{KeyID: keyID(s), Source: "config"}, key: []byte(s)})
+	}
+	return list
+}
+
+// keyID derives the kid of a configured secret, so every instance agrees
+// on it without revealing the secret
+func keyID(s string) string {
+	sum := sha256.Sum256([]byte("kid:" + s))
+	return hex.EncodeToString(sum[:4])
+}
+
+func currentSecrets() []secret {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return secrets
+}
+
+// Sign signs claims with the newest secret, naming it in the kid header
+func Sign(claims jwt.MapClaims) (string, error) {
+	list := currentSecrets()
+	if len(list) == 0 {
+		return "", errors.New("no signing secret loaded")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = list[0].info.KeyID
+	return token.SignedString(list[0].key)
+}
+
+// Parse verifies a token against every known secret. Tokens naming a kid are
+// checked against that secret only; older tokens without one are tried
+// against each.
+func Parse(tokenString string) (*jwt.Token, error) {
+	var lastErr error
+	for _, candidate := range candidates(tokenString) {
+		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+			return candidate, nil
+		})
+		if err == nil && token.Valid {
+			return token, nil
+		}
+		lastErr = err
+		// Only a bad signature is worth retrying with another secret
+		var validation *jwt.ValidationError
+		if !errors.As(err, &validation) || validation.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return token, err
+		}
+	}
+	if lastErr == nil {
+		lastErr = ErrUnknownKey
+	}
+	return nil, lastErr
+}
+
+// candidates returns the secrets a token may be signed with
+func candidates(tokenString string) [][]byte {
+	var header struct {
+		KeyID string `json:"kid"`
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err == nil {
+		header.KeyID, _ = parsed.Header["kid"].(string)
+	}
+
+	find := func() [][]byte {
+		var keys [][]byte
+		for _, s := range currentSecrets() {
+			if header.KeyID == "" || s.info.KeyID == header.KeyID {
+				keys = append(keys, s.key)
+			}
+		}
+		return keys
+	}
+
+	keys := find()
+	if len(keys) == 0 && header.KeyID != "" && secretsCfg != nil {
+		// A secret rotated on another instance may not have been loaded yet
+		secretsMu.RLock()
+		stale := time.Since(lastReload) > minReloadGap
+		secretsMu.RUnlock()
+		if stale {
+			if err := reloadSecrets(context.Background()); err != nil {
+				log.Printf("tokens: failed to reload rotated secrets: %v", err)
+			}
+			keys = find()
+		}
+	}
+	return keys
+}
+
+// Secrets describes the secrets tokens are verified with, signing one first
+func Secrets() []SecretInfo {
+	list := currentSecrets()
+	infos := make([]SecretInfo, len(list))
+	for i, s := range list {
+		infos[i] = s.info
+	}
+	return infos
+}
+
+// RotateSecret generates a new signing secret shared by every instance. The
+// secrets it replaces keep verifying tokens for JWT_SECRET_RETENTION, so
+// sessions continue until they expire.
+func RotateSecret(ctx context.Context) (*SecretInfo, error) {
+	if secretsCfg == nil {
+		return nil, errors.New("token secrets are not initialized")
+	}
+	raw, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(raw, secretsCfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := utils.RandomToken(4)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	expires := now.Add(secretsCfg.JWTSecretRetention)
+	collection := database.DB.Collection(secretCollection)
+	if _, err := collection.UpdateMany(ctx, bson.M{"expires_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"expires_at": expires}}); err != nil {
+		return nil, err
+	}
+	if _, err := collection.InsertOne(ctx, StoredSecret{KeyID: kid, Secret: encrypted, CreatedAt: now}); err != nil {
+		return nil, err
+	}
+
+	if err := reloadSecrets(ctx); err != nil {
+		return nil, err
+	}
+	return &SecretInfo{KeyID: kid, Source: "rotated", Signing: true, CreatedAt: &now}, nil
+}
@@ -0,0 +1,312 @@
+// Package tokens tracks the IDs (jti) of issued session tokens and the
+// refresh tokens issued with them. Every token
+// gets a unique ID that is stored until the token expires. A token replaced
+// by a refreshed one is marked superseded, so presenting it again is a replay,
+// and RevokeAll forgets every ID at once, so that in strict mode tokens issued
+// before it are rejected as unknown.
+//
+// It also holds the secrets tokens are signed with: JWT_SECRETS plus secrets
+// rotated through the admin API, which every instance shares through the
+// database. Tokens are signed with the newest and verified against all.
+package tokens
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/audit"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+// Token ID states
+const (
+	StateActive     = "active"
+	StateSuperseded = "superseded"
+	StateRevoked    = "revoked"
+	StateUnknown    = "unknown"
+)
+
+// collection holds issued token IDs
+const collection = "token_ids"
+
+// cacheTTL bounds how long a lookup is reused. Revocations reach other
+// instances within it.
+const cacheTTL = 30 * time.Second
+
+// Client describes where a token was issued to
+type Client struct {
+	IP        string `bson:"ip,omitempty"`
+	UserAgent string `bson:"user_agent,omitempty"`
+}
+
+// ClientOf describes the client making a request
+func ClientOf(r *http.Request) Client {
+	ua := r.UserAgent()
+	if len(ua) > 256 {
+		ua = ua[:256]
+	}
+	return Client{IP: audit.ClientIP(r), UserAgent: ua}
+}
+
+// Record is an issued token ID
+type Record struct {
+	ID           string     `bson:"_id"`
+	UserID       string     `bson:"user_id"`
+	Client       Client     `bson:",inline"`
+	IssuedAt     time.Time  `bson:"issued_at"`
+	ExpiresAt    time.Time  `bson:"expires_at"`
+	SupersededAt *time.Time `bson:"superseded_at,omitempty"`
+	RevokedAt    *time.Time `bson:"revoked_at,omitempty"`
+}
+
+type cached struct {
+	record  *Record
+	expires time.Time
+}
+
+var (
+	enabled bool
+
+	mu    sync.Mutex
+	cache = make(map[string]cached)
+)
+
+// Init creates the TTL index that drops IDs once their token expired and
+// loads the signing secrets. Without it IDs are neither stored nor checked.
+func Init(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateOne(ctx, index); err != nil {
+		log.Printf("tokens: failed to create TTL index: %v", err)
+	}
+	initRefresh(ctx)
+	enabled = true
+
+	initSecrets(cfg)
+}
+
+// NewID returns a random token ID
+func NewID() string {
+	id, err := utils.RandomToken(16)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		return clock.NewID().Hex()
+	}
+	return id
+}
+
+// Issue stores the ID of a token issued to a user
+func Issue(ctx context.Context, jti, userID string, expiresAt time.Time, client Client) error {
+	if !enabled {
+		return nil
+	}
+	record := Record{ID: jti, UserID: userID, Client: client, IssuedAt: clock.Now(), ExpiresAt: expiresAt}
+	_, err := database.DB.Collection(collection).InsertOne(ctx, record)
+	return err
+}
+
+// Lookup returns the state of a token ID and, unless unknown, its record
+func Lookup(ctx context.Context, jti string) (string, *Record, error) {
+	if !enabled {
+		return StateActive, nil, nil
+	}
+
+	mu.Lock()
+	c, ok := cache[jti]
+	mu.Unlock()
+	if !ok || time.Now().After(c.expires) {
+		var record Record
+		err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": jti}).Decode(&record)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", nil, err
+		}
+		c = cached{expires: time.Now().Add(cacheTTL)}
+		if err == nil {
+			c.record = &record
+		}
+		mu.Lock()
+		// Bound the cache; lookups are cheap to repeat
+		if len(cache) > 100000 {
+			cache = make(map[string]cached)
+		}
+		cache[jti] = c
+		mu.Unlock()
+	}
+
+	switch {
+	case c.record == nil:
+		return StateUnknown, nil, nil
+	case c.record.RevokedAt != nil:
+		return StateRevoked, c.record, nil
+	case c.record.SupersededAt != nil:
+		return StateSuperseded, c.record, nil
+	}
+	return StateActive, c.record, nil
+}
+
+// Supersede marks a token ID as replaced by a refreshed token
+func Supersede(ctx context.Context, jti string) error {
+	if !enabled || jti == "" {
+		return nil
+	}
+	now := clock.Now()
+	if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": jti}, bson.M{"$set": bson.M{"superseded_at": now}}); err != nil {
+		return err
+	}
+	mu.Lock()
+	delete(cache, jti)
+	mu.Unlock()
+	return nil
+}
+
+// Active returns a user's tokens that are neither expired, replaced nor
+// revoked, newest first
+func Active(ctx context.Context, userID string) ([]Record, error) {
+	records := []Record{}
+	if !enabled {
+		return records, nil
+	}
+	filter := bson.M{
+		"user_id":       userID,
+		"expires_at":    bson.M{"$gt": clock.Now()},
+		"superseded_at": nil,
+		"revoked_at":    nil,
+	}
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"issued_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &records)
+	return records, err
+}
+
+// Revoke rejects a user's token from now on, in strict mode or not, along
+// with the refresh tokens of its sign-in. It returns mongo.ErrNoDocuments
+// when the user has no such active token.
+func Revoke(ctx context.Context, userID, jti string) error {
+	if !enabled {
+		return mongo.ErrNoDocuments
+	}
+	result, err := database.DB.Collection(collection).UpdateOne(ctx,
+		bson.M{"_id": jti, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": clock.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	mu.Lock()
+	delete(cache, jti)
+	mu.Unlock()
+	return revokeSessionRefresh(ctx, userID, jti)
+}
+
+// RevokeSession revokes the token a user signs out with, along with the
+// refresh tokens of its sign-in. Unlike Revoke it also records IDs that were
+// never stored, so they are rejected until expiresAt.
+func RevokeSession(ctx context.Context, userID, jti string, expiresAt time.Time) error {
+	if !enabled {
+		return errors.New("token IDs are not stored")
+	}
+	now := clock.Now()
+	update := bson.M{
+		"$set":         bson.M{"revoked_at": now},
+		"$setOnInsert": bson.M{"issued_at": now, "expires_at": expiresAt},
+	}
+	_, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": jti, "user_id": userID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	delete(cache, jti)
+	mu.Unlock()
+	return revokeSessionRefresh(ctx, userID, jti)
+}
+
+// RevokeUser revokes every outstanding token of a user, refresh tokens
+// included, and returns how many session tokens were revoked. Other instances
+// reject them once their cached lookups expire.
+func RevokeUser(ctx context.Context, userID string) (int64, error) {
+	if !enabled {
+		return 0, nil
+	}
+	// Revoked ahead of the refresh tokens, which take their session tokens
+	// along and would leave none to count
+	result, err := database.DB.Collection(collection).UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": clock.Now()}},
+		bson.M{"$set": bson.M{"revoked_at": clock.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := revokeRefresh(ctx, bson.M{"user_id": userID}); err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	cache = make(map[string]cached)
+	mu.Unlock()
+	return result.ModifiedCount, nil
+}
+
+// RevokeOthers revokes every outstanding token of a user except the session
+// token keep and the refresh tokens of its sign-in, and returns how many
+// session tokens were revoked
+func RevokeOthers(ctx context.Context, userID, keep string) (int64, error) {
+	if !enabled {
+		return 0, nil
+	}
+	families, err := database.DB.Collection(refreshCollection).Distinct(ctx, "family_id", bson.M{"user_id": userID, "access_id": keep})
+	if err != nil {
+		return 0, err
+	}
+	// Session tokens are counted before revoking the refresh tokens, which
+	// revokes the session tokens of their sign-ins too
+	result, err := database.DB.Collection(collection).UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$ne": keep}, "user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": clock.Now()}},
+		bson.M{"$set": bson.M{"revoked_at": clock.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := revokeRefresh(ctx, bson.M{"user_id": userID, "family_id": bson.M{"$nin": families}}); err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	cache = make(map[string]cached)
+	mu.Unlock()
+	return result.ModifiedCount, nil
+}
+
+// RevokeAll forgets every issued token ID, so strict mode rejects all
+// outstanding tokens, and deletes every refresh token. It returns how many
+// IDs were dropped.
+func RevokeAll(ctx context.Context) (int64, error) {
+	if !enabled {
+		return 0, nil
+	}
+	if _, err := database.DB.Collection(refreshCollection).DeleteMany(ctx, bson.M{}); err != nil {
+		return 0, err
+	}
+	result, err := database.DB.Collection(collection).DeleteMany(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	cache = make(map[string]cached)
+	mu.Unlock()
+	return result.DeletedCount, nil
+}
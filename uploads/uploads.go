@@ -0,0 +1,280 @@
+// Package uploads lets clients upload files straight to object storage. The
+// API hands out a presigned PUT URL bound to the declared content type and
+// size and records the upload as pending. Once the client confirms it, the
+// stored object is checked (size, and magic bytes through the MIME verifier)
+// and either accepted or deleted. Uploads never confirmed are deleted from
+// the bucket when their confirmation window closes.
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/models"
+	"golang-backend/scan"
+	"golang-backend/storage"
+)
+
+// collection tracks direct uploads
+const collection = "direct_uploads"
+
+const (
+	// confirmGrace is how long after the URL expired an upload may still be
+	// confirmed, for transfers that started just before
+	confirmGrace = time.Hour
+	// maxPending bounds the unconfirmed uploads of a user
+	maxPending = 20
+	// sniffBytes is how much of an object is read to detect its type
+	sniffBytes = 512
+)
+
+// Errors returned by Sign and Confirm
+var (
+	ErrTooManyPending = errors.New("too many unconfirmed uploads")
+	ErrNotPending     = errors.New("upload is not pending")
+	ErrExpired        = errors.New("upload confirmation window has closed")
+	ErrNotUploaded    = errors.New("object has not been uploaded")
+)
+
+// Rejection is returned by Confirm when the stored object fails validation;
+// the object has been deleted
+type Rejection struct {
+	Reason string
+}
+
+func (e *Rejection) Error() string {
+	return "upload rejected: " + e.Reason
+}
+
+var (
+	bucket   *storage.Bucket
+	prefix   string
+	urlTTL   time.Duration
+	maxBytes int64
+
+	unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// Init configures the upload bucket and starts deleting abandoned uploads.
+// Without UPLOAD_BUCKET direct uploads are disabled.
+func Init(cfg *config.Config) {
+	if cfg.UploadBucket == "" {
+		return
+	}
+	creds := storage.Credentials{
+		AccessKey:    cfg.AWSAccessKeyID,
+		SecretKey:    cfg.AWSSecretAccessKey,
+		SessionToken: cfg.AWSSessionToken,
+		Region:       cfg.UploadStorageRegion,
+	}
+	bucket = storage.NewBucket(cfg.UploadBucket, cfg.UploadStorageEndpoint, creds)
+	prefix = cfg.UploadKeyPrefix
+	urlTTL = cfg.UploadURLTTL
+	maxBytes = cfg.UploadMaxBytes
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	}
+	if _, err := database.DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("uploads: failed to create indexes: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			sweep(context.Background())
+		}
+	}()
+}
+
+// Enabled reports whether direct uploads are configured
+func Enabled() bool {
+	return bucket != nil
+}
+
+// MaxBytes is the largest file that may be uploaded
+func MaxBytes() int64 {
+	return maxBytes
+}
+
+// Sign records a pending upload and returns it with the presigned URL the
+// client must PUT the file to, with the declared Content-Type and size
+func Sign(ctx context.Context, userID, kind, filename, contentType string, size int64) (*models.DirectUpload, string, error) {
+	pending, err := database.DB.Collection(collection).CountDocuments(ctx, bson.M{"user_id": userID, "status": models.DirectUploadPending})
+	if err != nil {
+		return nil, "", err
+	}
+	if pending >= maxPending {
+		return nil, "", ErrTooManyPending
+	}
+
+	now := clock.Now()
+	upload := models.DirectUpload{
+		ID:          clock.NewID(),
+		UserID:      userID,
+		Kind:        kind,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Status:      models.DirectUploadPending,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(urlTTL),
+	}
+	upload.Key = fmt.Sprintf("%s%s/%s/%s", prefix, userID, upload.ID.Hex(), safeName(filename))
+	if _, err := database.DB.Collection(collection).InsertOne(ctx, upload); err != nil {
+		return nil, "", err
+	}
+	return &upload, bucket.PresignPut(upload.Key, contentType, size, urlTTL), nil
+}
+
+// Confirm validates the uploaded object of a pending upload. Objects of the
+// wrong size or content are deleted and reported as a *Rejection.
+func Confirm(ctx context.Context, userID string, id primitive.ObjectID) (*models.DirectUpload, error) {
+	upload, err := Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.DirectUploadPending {
+		return nil, ErrNotPending
+	}
+	if clock.Now().After(upload.ExpiresAt.Add(confirmGrace)) {
+		return nil, ErrExpired
+	}
+
+	info, err := bucket.Head(ctx, upload.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotUploaded
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+	set := bson.M{}
+	if info.Size != upload.Size {
+		reason = fmt.Sprintf("object is %d bytes, %d were declared", info.Size, upload.Size)
+	} else {
+		// Only the first bytes are read: enough to verify the type, while the
+		// file itself never passes through the API
+		head, err := bucket.ReadHead(ctx, upload.Key, sniffBytes)
+		if err != nil {
+			return nil, err
+		}
+		set["detected_type"] = scan.DetectType(head)
+		reason, err = scan.MIMEVerifier{}.Scan(ctx, scan.Upload{Kind: upload.Kind, Filename: upload.Filename, DeclaredType: upload.ContentType, Content: head})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if reason != "" {
+		if err := bucket.Delete(ctx, upload.Key); err != nil {
+			return nil, err
+		}
+		set["status"], set["reason"] = models.DirectUploadRejected, reason
+	} else {
+		set["status"], set["confirmed_at"], set["checksum"] = models.DirectUploadConfirmed, clock.Now(), info.ETag
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter := bson.M{"_id": id, "status": models.DirectUploadPending}
+	var updated models.DirectUpload
+	if err := database.DB.Collection(collection).FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotPending
+		}
+		return nil, err
+	}
+	if reason != "" {
+		return &updated, &Rejection{Reason: reason}
+	}
+	return &updated, nil
+}
+
+// Get returns an upload of a user
+func Get(ctx context.Context, userID string, id primitive.ObjectID) (*models.DirectUpload, error) {
+	var upload models.DirectUpload
+	if err := database.DB.Collection(collection).FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// List returns a user's recent uploads, newest first
+func List(ctx context.Context, userID string, limit int64) ([]models.DirectUpload, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := database.DB.Collection(collection).Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []models.DirectUpload{}
+	err = cursor.All(ctx, &list)
+	return list, err
+}
+
+// DownloadURL returns a presigned URL to read a stored object
+func DownloadURL(key string) string {
+	return bucket.PresignGet(key, urlTTL)
+}
+
+// Bucket returns the upload bucket, nil when direct uploads are disabled
+func Bucket() *storage.Bucket {
+	return bucket
+}
+
+// Prefix returns the key prefix of uploaded objects
+func Prefix() string {
+	return prefix
+}
+
+// sweep deletes the objects of uploads whose confirmation window closed and
+// marks them expired
+func sweep(ctx context.Context) {
+	filter := bson.M{"status": models.DirectUploadPending, "expires_at": bson.M{"$lt": clock.Now().Add(-confirmGrace)}}
+	cursor, err := database.DB.Collection(collection).Find(ctx, filter, options.Find().SetLimit(500))
+	if err != nil {
+		log.Printf("uploads: failed to find abandoned uploads: %v", err)
+		return
+	}
+	var abandoned []models.DirectUpload
+	if err := cursor.All(ctx, &abandoned); err != nil {
+		log.Printf("uploads: failed to load abandoned uploads: %v", err)
+		return
+	}
+	for _, upload := range abandoned {
+		if err := bucket.Delete(ctx, upload.Key); err != nil {
+			log.Printf("uploads: failed to delete abandoned object %s: %v", upload.Key, err)
+			continue
+		}
+		update := bson.M{"$set": bson.M{"status": models.DirectUploadExpired}}
+		if _, err := database.DB.Collection(collection).UpdateOne(ctx, bson.M{"_id": upload.ID, "status": models.DirectUploadPending}, update); err != nil {
+			log.Printf("uploads: failed to expire upload %s: %v", upload.ID.Hex(), err)
+		}
+	}
+}
+
+// safeName reduces a client file name to characters safe in object keys
+func safeName(filename string) string {
+	name := strings.Trim(unsafeChars.ReplaceAllString(filename, "_"), "._")
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,166 @@
+// Package usertags lets admins label users with free-form tags, such as
+// "beta" or "churn-risk", to target them as a segment: tags filter the admin
+// user list and export and narrow the audience of system messages. Tags are
+// stored in an indexed array on the user document.
+package usertags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang-backend/cache"
+	"golang-backend/clock"
+	"golang-backend/database"
+)
+
+// MaxPerUser bounds the tags of one user; tags are not added to users they
+// would take past it
+const MaxPerUser = 50
+
+// pattern is what a tag looks like after lowercasing
+var pattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,63}$`)
+
+// ErrInvalidTag is returned for tags that do not match the pattern
+var ErrInvalidTag = errors.New("tags must be 1-64 lowercase letters, digits or _.:- starting with a letter or digit")
+
+// ErrTooMany is returned when more tags are added than a user may have
+var ErrTooMany = fmt.Errorf("users have at most %d tags", MaxPerUser)
+
+// Count is how many users carry a tag
+type Count struct {
+	Tag   string `json:"tag" bson:"_id" example:"beta"`
+	Users int64  `json:"users" bson:"users" example:"42"`
+}
+
+// Init indexes the tags of users in every region
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index := mongo.IndexModel{Keys: bson.M{"tags": 1}}
+	for region, db := range database.Regions {
+		if _, err := db.Collection("users").Indexes().CreateOne(ctx, index); err != nil {
+			log.Printf("usertags: failed to create tag index in region %s: %v", region, err)
+		}
+	}
+}
+
+// Normalize lowercases and deduplicates tags, in sorted order, and checks
+// them against the pattern
+func Normalize(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !pattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// Filter matches users carrying every one of tags
+func Filter(tags []string) bson.M {
+	return bson.M{"$all": tags}
+}
+
+// Update adds and removes tags on users in every region, limited to members
+// of orgID unless it is empty. It returns how many users the additions and
+// the removals changed, summed. Removal runs after adding, so a tag in both
+// is removed.
+func Update(ctx context.Context, userIDs []primitive.ObjectID, orgID string, add, remove []string) (int64, error) {
+	filter := bson.M{"_id": bson.M{"$in": userIDs}}
+	if orgID != "" {
+		filter["org_id"] = orgID
+	}
+
+	var changed int64
+	apply := func(filter, update bson.M) error {
+		update["$set"] = bson.M{"updated_at": clock.Now()}
+		for region, db := range database.Regions {
+			result, err := db.Collection("users").UpdateMany(ctx, filter, update)
+			if err != nil {
+				return fmt.Errorf("region %s: %w", region, err)
+			}
+			changed += result.ModifiedCount
+		}
+		return nil
+	}
+	defer func() {
+		if changed > 0 {
+			cache.Invalidate(cache.TagUsers)
+		}
+	}()
+
+	if len(add) > MaxPerUser {
+		return 0, ErrTooMany
+	}
+	if len(add) > 0 {
+		// Users with room for every added tag, even ones they already have
+		room := bson.M{"tags." + strconv.Itoa(MaxPerUser-len(add)): bson.M{"$exists": false}}
+		if err := apply(bson.M{"$and": []bson.M{filter, room}}, bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": add}}}); err != nil {
+			return changed, err
+		}
+	}
+	if len(remove) > 0 {
+		if err := apply(filter, bson.M{"$pullAll": bson.M{"tags": remove}}); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// Counts returns every tag in use with its number of users, most used
+// first, limited to members of orgID unless it is empty
+func Counts(ctx context.Context, orgID string) ([]Count, error) {
+	match := bson.M{"tags": bson.M{"$exists": true}}
+	if orgID != "" {
+		match["org_id"] = orgID
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "users": bson.M{"$sum": 1}}}},
+	}
+
+	totals := make(map[string]int64)
+	for region, db := range database.Regions {
+		cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+		var counts []Count
+		if err := cursor.All(ctx, &counts); err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+		for _, c := range counts {
+			totals[c.Tag] += c.Users
+		}
+	}
+
+	counts := []Count{}
+	for tag, users := range totals {
+		counts = append(counts, Count{Tag: tag, Users: users})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Users != counts[j].Users {
+			return counts[i].Users > counts[j].Users
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts, nil
+}
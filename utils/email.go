@@ -0,0 +1,46 @@
+package utils
+
+import "strings"
+
+// aliasDomains are providers that ignore dots and +tags in the local part
+var aliasDomains = map[string]bool{"gmail.com": true, "googlemail.com": true}
+
+// NormalizeEmail returns the canonical form of an address: trimmed and
+// lowercased. It is what gets stored and compared.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// AliasDomain reports whether a normalized address is at a provider whose
+// aliases FoldEmailAlias folds
+func AliasDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at >= 0 && aliasDomains[email[at+1:]]
+}
+
+// FoldEmailAlias maps a normalized Gmail address to the mailbox it delivers
+// to, dropping dots and any +tag from the local part. Other addresses are
+// returned unchanged.
+func FoldEmailAlias(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || !aliasDomains[email[at+1:]] {
+		return email
+	}
+
+	local := email[:at]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return strings.ReplaceAll(local, ".", "") + "@gmail.com"
+}
+
+// EmailIndex returns the blind index stored in email_hash for an address.
+// Addresses that normalize (and, with foldAliases, fold) to the same mailbox
+// share an index, so they resolve to the same account.
+func EmailIndex(email string, foldAliases bool) string {
+	email = NormalizeEmail(email)
+	if foldAliases {
+		email = FoldEmailAlias(email)
+	}
+	return HashEmail(email)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
 )
@@ -22,21 +23,30 @@ func Encrypt(plaintext, key string) (string, error) {
 		return "", err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
+	buf := getBytes(gcm.NonceSize() + len(plaintext) + gcm.Overhead())
+	defer putBytes(buf)
+
+	nonce := (*buf)[:gcm.NonceSize()]
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	*buf = ciphertext[:0]
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
 // Decrypt decrypts ciphertext using AES-GCM with the provided key
 func Decrypt(ciphertext, key string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	buf := getBytes(base64.StdEncoding.DecodedLen(len(ciphertext)))
+	defer putBytes(buf)
+
+	data := (*buf)[:cap(*buf)]
+	n, err := base64.StdEncoding.Decode(data, []byte(ciphertext))
 	if err != nil {
 		return "", err
 	}
+	data = data[:n]
 
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
@@ -54,7 +64,7 @@ func Decrypt(ciphertext, key string) (string, error) {
 	}
 
 	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
+	plaintext, err := gcm.Open(ciphertextBytes[:0], nonce, ciphertextBytes, nil)
 	if err != nil {
 		return "", err
 	}
@@ -67,3 +77,9 @@ func HashEmail(email string) string {
 	hash := sha256.Sum256([]byte(email))
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
+
+// HashToken creates a hex SHA-256 hash of a secret token for storage
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,14 @@
+//go:build !easyjson
+
+package utils
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// WriteJSON encodes v as JSON followed by a newline. Build with -tags easyjson
+// to use the reflection-free marshalers of large response types.
+func WriteJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
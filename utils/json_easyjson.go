@@ -0,0 +1,25 @@
+//go:build easyjson
+
+package utils
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/mailru/easyjson"
+)
+
+// WriteJSON encodes v as JSON followed by a newline, using its easyjson
+// marshaler when it has one and encoding/json otherwise
+func WriteJSON(w io.Writer, v interface{}) error {
+	m, ok := v.(easyjson.Marshaler)
+	if !ok {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	if _, err := easyjson.MarshalToWriter(m, w); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordPoolBusy is returned when no bcrypt worker frees up within the queue timeout
+var ErrPasswordPoolBusy = errors.New("password hashing pool is saturated")
+
+// PasswordPoolStats are the bcrypt pool counters since startup
+type PasswordPoolStats struct {
+	Workers         int     `json:"workers"`
+	InFlight        int     `json:"in_flight"`
+	Completed       int64   `json:"completed"`
+	Rejected        int64   `json:"rejected"`
+	AvgQueueWaitMs  float64 `json:"avg_queue_wait_ms"`
+	QueueTimeoutSec float64 `json:"queue_timeout_sec"`
+}
+
+// passwordPool bounds how many bcrypt operations run at once so hashing
+// under load cannot starve the rest of the server of CPU
+type passwordPool struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	completed, rejected, waitNanos atomic.Int64
+}
+
+var pool = newPasswordPool(runtime.NumCPU(), time.Second)
+
+func newPasswordPool(workers int, queueTimeout time.Duration) *passwordPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &passwordPool{slots: make(chan struct{}, workers), queueTimeout: queueTimeout}
+}
+
+// InitPasswordPool sizes the bcrypt pool; call it once at startup
+func InitPasswordPool(workers int, queueTimeout time.Duration) {
+	pool = newPasswordPool(workers, queueTimeout)
+}
+
+// run executes fn on a free worker slot. With wait set it queues without a
+// timeout, which suits background jobs that have no client waiting.
+func (p *passwordPool) run(wait bool, fn func() error) error {
+	start := time.Now()
+	if wait {
+		p.slots <- struct{}{}
+	} else {
+		timer := time.NewTimer(p.queueTimeout)
+		select {
+		case p.slots <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			p.rejected.Add(1)
+			return ErrPasswordPoolBusy
+		}
+	}
+	p.waitNanos.Add(int64(time.Since(start)))
+	defer func() { <-p.slots }()
+
+	err := fn()
+	p.completed.Add(1)
+	return err
+}
+
+// HashPassword bcrypt-hashes a password, failing with ErrPasswordPoolBusy
+// when the pool stays saturated for the queue timeout
+func HashPassword(password string) (string, error) {
+	return hashPassword(false, password)
+}
+
+// HashPasswordQueued is HashPassword for background jobs: it waits for a worker
+// instead of giving up
+func HashPasswordQueued(password string) (string, error) {
+	return hashPassword(true, password)
+}
+
+func hashPassword(wait bool, password string) (string, error) {
+	var hash []byte
+	err := pool.run(wait, func() error {
+		var err error
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return err
+	})
+	return string(hash), err
+}
+
+// ComparePassword checks a password against a bcrypt hash on the pool. The
+// password bytes come from a buffer pool and are wiped afterwards instead of
+// being left to the GC.
+func ComparePassword(hash, password string) error {
+	return pool.run(false, func() error {
+		buf := getBytes(len(password))
+		defer putBytes(buf)
+
+		pw := append((*buf)[:0], password...)
+		*buf = pw
+		return bcrypt.CompareHashAndPassword([]byte(hash), pw)
+	})
+}
+
+var (
+	noPasswordOnce sync.Once
+	noPasswordHash []byte
+)
+
+// CompareNoPassword does the work of ComparePassword against a hash of a
+// random secret, so checking the password of an account that does not exist
+// takes as long as checking a real one. It always fails.
+func CompareNoPassword(password string) error {
+	noPasswordOnce.Do(func() {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		noPasswordHash, _ = bcrypt.GenerateFromPassword(secret, bcrypt.DefaultCost)
+	})
+	if err := ComparePassword(string(noPasswordHash), password); err != nil {
+		return err
+	}
+	return bcrypt.ErrMismatchedHashAndPassword
+}
+
+// PasswordPool returns the current bcrypt pool counters
+func PasswordPool() PasswordPoolStats {
+	p := pool
+	stats := PasswordPoolStats{
+		Workers:         cap(p.slots),
+		InFlight:        len(p.slots),
+		Completed:       p.completed.Load(),
+		Rejected:        p.rejected.Load(),
+		QueueTimeoutSec: p.queueTimeout.Seconds(),
+	}
+	if stats.Completed > 0 {
+		stats.AvgQueueWaitMs = float64(p.waitNanos.Load()) / float64(stats.Completed) / float64(time.Millisecond)
+	}
+	return stats
+}
@@ -0,0 +1,36 @@
+package utils
+
+import "strings"
+
+// NormalizePhone returns a phone number in E.164 format, dropping spaces,
+// dashes, dots and parentheses, or "" when it is not an international
+// number of 8 to 15 digits
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+	if !strings.HasPrefix(phone, "+") {
+		return ""
+	}
+	digits := make([]byte, 0, len(phone))
+	for _, c := range phone[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, byte(c))
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return ""
+		}
+	}
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return ""
+	}
+	return "+" + string(digits)
+}
+
+// PhoneIndex returns the blind index stored in phone_hash for a normalized
+// number
+func PhoneIndex(phone string) string {
+	return HashEmail("phone:" + phone)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxPooledSize keeps unusually large buffers out of the pools
+const maxPooledSize = 64 << 10
+
+// MaxJSONBody is the largest request body DecodeJSON reads
+const MaxJSONBody = 1 << 20
+
+// ErrBodyTooLarge is returned by DecodeJSON for bodies over MaxJSONBody
+var ErrBodyTooLarge = errors.New("request body too large")
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+var bytesPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// DecodeJSON decodes a JSON request body into v, reading it through a pooled
+// buffer. Bodies over MaxJSONBody are rejected without being read in full.
+func DecodeJSON(r io.Reader, v interface{}) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledSize {
+			buf.Reset()
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if _, err := buf.ReadFrom(io.LimitReader(r, MaxJSONBody+1)); err != nil {
+		return err
+	}
+	if buf.Len() > MaxJSONBody {
+		return ErrBodyTooLarge
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
+
+// getBytes returns a pooled byte slice with at least size bytes of capacity
+func getBytes(size int) *[]byte {
+	b := bytesPool.Get().(*[]byte)
+	if cap(*b) < size {
+		*b = make([]byte, 0, size)
+	}
+	return b
+}
+
+// putBytes wipes a byte slice, which may hold key material or plaintext, and
+// returns it to the pool
+func putBytes(b *[]byte) {
+	if cap(*b) > maxPooledSize {
+		return
+	}
+	full := (*b)[:cap(*b)]
+	clear(full)
+	*b = full[:0]
+	bytesPool.Put(b)
+}
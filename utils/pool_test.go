@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const benchKey = "0123456789abcdef0123456789abcdef"
+
+// loginBody is what a password login posts
+var loginBody = []byte(`{"email":"jane.doe@example.com","password":"correct horse battery staple","captcha_token":"","challenge_response":""}`)
+
+type loginRequest struct {
+	Email             string `json:"email"`
+	Password          string `json:"password"`
+	CaptchaToken      string `json:"captcha_token"`
+	ChallengeResponse string `json:"challenge_response"`
+}
+
+// decryptUnpooled is Decrypt as it was before pooling, for comparison
+func decryptUnpooled(ciphertext, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	return string(plaintext), err
+}
+
+func TestDecodeJSONRejectsOversizedBodies(t *testing.T) {
+	var v map[string]string
+	body := `{"name":"` + strings.Repeat("a", MaxJSONBody) + `"}`
+	if err := DecodeJSON(strings.NewReader(body), &v); err != ErrBodyTooLarge {
+		t.Fatalf("got %v, want ErrBodyTooLarge", err)
+	}
+	if err := DecodeJSON(strings.NewReader(`{"name":"a"}`), &v); err != nil || v["name"] != "a" {
+		t.Fatalf("got %v, %v", v, err)
+	}
+}
+
+func TestPoolingSavesAllocations(t *testing.T) {
+	ciphertext, err := Encrypt("jane.doe@example.com", benchKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name          string
+		pooled, plain func()
+	}{
+		{
+			"login decode",
+			func() { var req loginRequest; DecodeJSON(bytes.NewReader(loginBody), &req) },
+			func() { var req loginRequest; json.NewDecoder(bytes.NewReader(loginBody)).Decode(&req) },
+		},
+		{
+			"list decrypt",
+			func() { Decrypt(ciphertext, benchKey) },
+			func() { decryptUnpooled(ciphertext, benchKey) },
+		},
+	} {
+		tc.pooled() // warm the pools
+		pooled, plain := testing.AllocsPerRun(100, tc.pooled), testing.AllocsPerRun(100, tc.plain)
+		if pooled >= plain {
+			t.Errorf("%s: %v allocations pooled, %v without; pooling should save some", tc.name, pooled, plain)
+		}
+	}
+}
+
+func BenchmarkLoginDecode(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req loginRequest
+			if err := DecodeJSON(bytes.NewReader(loginBody), &req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req loginRequest
+			if err := json.NewDecoder(bytes.NewReader(loginBody)).Decode(&req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkListDecrypt decrypts a page of emails, as listing users does
+func BenchmarkListDecrypt(b *testing.B) {
+	page := make([]string, 50)
+	for i := range page {
+		page[i], _ = Encrypt("user"+strings.Repeat("x", i%8)+"@example.com", benchKey)
+	}
+	for _, bc := range []struct {
+		name    string
+		decrypt func(string, string) (string, error)
+	}{
+		{"pooled", Decrypt},
+		{"unpooled", decryptUnpooled},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, ciphertext := range page {
+					if _, err := bc.decrypt(ciphertext, benchKey); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
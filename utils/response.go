@@ -0,0 +1,34 @@
+package utils
+
+import "net/http"
+
+// StatusWriter remembers the status of a response for middleware that logs,
+// counts or audits it. Flush is passed through, so event streams and NDJSON
+// exports keep streaming behind it, and Unwrap lets http.ResponseController
+// reach the writer underneath.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+}
+
+// NewStatusWriter wraps w, assuming 200 until the handler says otherwise
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (s *StatusWriter) WriteHeader(status int) {
+	s.Status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush sends buffered data to the client when the underlying writer can
+func (s *StatusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer
+func (s *StatusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
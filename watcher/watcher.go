@@ -0,0 +1,162 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/cache"
+	"golang-backend/config"
+	"golang-backend/database"
+)
+
+// Event is a change to a user document, as pushed to subscribers
+type Event struct {
+	Type   string    `json:"type"`
+	UserID string    `json:"user_id"`
+	Region string    `json:"region"`
+	Time   time.Time `json:"time"`
+}
+
+// changeEvent is the part of a change stream document the watcher reads
+type changeEvent struct {
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// tokenCollection stores the last processed resume token of every stream
+const tokenCollection = "change_stream_tokens"
+
+// Start watches the users collection of every region. Changes made by any
+// server instance invalidate the user cache and are pushed to subscribers.
+// Change streams need a replica set or sharded cluster.
+func Start(cfg *config.Config) {
+	if !cfg.ChangeStreamsEnabled {
+		return
+	}
+
+	for region, db := range database.Regions {
+		go watch(region, db.Collection("users"))
+	}
+
+	log.Println("User change stream watcher started")
+}
+
+// watch consumes a change stream, reopening it from the stored resume token
+// after errors
+func watch(region string, collection *mongo.Collection) {
+	streamID := "users:" + region
+	backoff := time.Second
+
+	for {
+		err := consume(context.Background(), streamID, region, collection)
+		log.Printf("watcher: stream %s stopped: %v; retrying in %s", streamID, err, backoff)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// consume processes a change stream until it fails
+func consume(ctx context.Context, streamID, region string, collection *mongo.Collection) error {
+	opts := options.ChangeStream()
+	if token, err := loadToken(ctx, streamID); err != nil {
+		return err
+	} else if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	stream, err := collection.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var change changeEvent
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+
+		cache.Invalidate(cache.TagUsers)
+		publish(Event{
+			Type:   change.OperationType,
+			UserID: change.DocumentKey.ID.Hex(),
+			Region: region,
+			Time:   time.Now(),
+		})
+
+		if err := saveToken(ctx, streamID, change.ID); err != nil {
+			log.Printf("watcher: failed to persist resume token of %s: %v", streamID, err)
+		}
+	}
+	return stream.Err()
+}
+
+// loadToken returns the stored resume token of a stream, or nil
+func loadToken(ctx context.Context, streamID string) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := database.DB.Collection(tokenCollection).FindOne(ctx, bson.M{"_id": streamID}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+// saveToken persists the resume token of a stream
+func saveToken(ctx context.Context, streamID string, token bson.Raw) error {
+	_, err := database.DB.Collection(tokenCollection).UpdateOne(ctx,
+		bson.M{"_id": streamID},
+		bson.M{"$set": bson.M{"token": token, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = make(map[chan Event]func(Event) bool)
+)
+
+// Subscribe registers for events accepted by filter. The returned cancel
+// function must be called to unsubscribe. Slow subscribers miss events
+// rather than blocking the stream.
+func Subscribe(filter func(Event) bool) (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+
+	subscribersMu.Lock()
+	subscribers[ch] = filter
+	subscribersMu.Unlock()
+
+	return ch, func() {
+		subscribersMu.Lock()
+		delete(subscribers, ch)
+		subscribersMu.Unlock()
+	}
+}
+
+// publish fans an event out to matching subscribers
+func publish(event Event) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	for ch, filter := range subscribers {
+		if filter != nil && !filter(event) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms accepted for credential keys, in order of preference
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// Algorithms lists the accepted COSE algorithms
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9053)
+const (
+	coseKty = 1
+	coseAlg = 3
+	// EC2 and OKP keys
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	// RSA keys
+	coseN = -1
+	coseE = -2
+)
+
+// errKey is returned for credential keys that cannot be used
+var errKey = errors.New("unsupported credential key")
+
+// parseKey decodes a COSE public key and returns it with its algorithm
+func parseKey(cose []byte) (crypto.PublicKey, int64, error) {
+	item, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errKey
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	crv, _ := m[int64(coseCrv)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == 2 && crv == 1:
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errKey
+		}
+		// Rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, errKey
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, alg, nil
+
+	case alg == AlgEdDSA && kty == 1 && crv == 6:
+		x, _ := m[int64(coseX)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errKey
+		}
+		return ed25519.PublicKey(x), alg, nil
+
+	case alg == AlgRS256 && kty == 3:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		exponent := new(big.Int).SetBytes(e)
+		if len(e) == 0 || len(e) > 4 || exponent.Int64() < 3 {
+			return nil, 0, errKey
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, 0, errKey
+		}
+		return key, alg, nil
+	}
+	return nil, 0, fmt.Errorf("%w: algorithm %d", errKey, alg)
+}
+
+// verifySignature checks an assertion signature over data with a stored
+// COSE key
+func verifySignature(cose, data, sig []byte) error {
+	key, _, err := parseKey(cose)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
@@ -0,0 +1,595 @@
+// Package webauthn lets users sign in with passkeys. A registration ceremony
+// stores the public key an authenticator creates for the relying party
+// WEBAUTHN_RP_ID in webauthn_credentials; an authentication ceremony checks
+// an assertion signed with it. Each ceremony starts with a random challenge
+// kept in webauthn_ceremonies for WEBAUTHN_TIMEOUT, which the finishing
+// request consumes once.
+//
+// Only "none" attestation is requested, so the authenticator's make and model
+// are not verified. Keys may use ES256, EdDSA or RS256.
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-backend/clock"
+	"golang-backend/config"
+	"golang-backend/database"
+	"golang-backend/utils"
+)
+
+const (
+	// credentialCollection holds registered credentials
+	credentialCollection = "webauthn_credentials"
+	// ceremonyCollection holds the challenges of unfinished ceremonies
+	ceremonyCollection = "webauthn_ceremonies"
+)
+
+// Ceremony kinds
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttested       = 0x40
+)
+
+var (
+	// ErrCeremony is returned for unknown, expired and used ceremonies
+	ErrCeremony = errors.New("unknown or expired ceremony")
+	// ErrInvalidResponse is returned, wrapped with the reason, when an
+	// authenticator response fails verification
+	ErrInvalidResponse = errors.New("invalid authenticator response")
+	// ErrUnknownCredential is returned for assertions by credentials nobody
+	// registered
+	ErrUnknownCredential = errors.New("unknown credential")
+	// ErrCredentialExists is returned when registering a credential twice
+	ErrCredentialExists = errors.New("credential already registered")
+	// ErrNotFound is returned when removing a credential the user does not have
+	ErrNotFound = errors.New("credential not found")
+)
+
+// Credential is a passkey registered to a user
+type Credential struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"-"`
+	// CredentialID is the authenticator's ID of the key, base64url encoded
+	CredentialID string `bson:"credential_id" json:"credential_id" example:"AaZ1Qm3t..."`
+	PublicKey    []byte `bson:"public_key" json:"-"`
+	Algorithm    int64  `bson:"algorithm" json:"algorithm" example:"-7"`
+	SignCount    uint32 `bson:"sign_count" json:"-"`
+	// AAGUID names the authenticator model, when it tells
+	AAGUID     string   `bson:"aaguid,omitempty" json:"aaguid,omitempty" example:"fbfc3007-154e-4ecc-8c0b-6e020557d7bd"`
+	Transports []string `bson:"transports,omitempty" json:"transports,omitempty" example:"internal,hybrid"`
+	// BackupEligible keys may be synced between devices; BackedUp ones are
+	BackupEligible bool       `bson:"backup_eligible" json:"backup_eligible"`
+	BackedUp       bool       `bson:"backed_up" json:"backed_up"`
+	Name           string     `bson:"name" json:"name" example:"MacBook"`
+	CreatedAt      time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt     *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// ceremony is the state of an unfinished ceremony
+type ceremony struct {
+	ID        string             `bson:"_id"`
+	Kind      string             `bson:"kind"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	Challenge string             `bson:"challenge"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// RelyingParty names the service passkeys are created for
+type RelyingParty struct {
+	ID   string `json:"id" example:"example.com"`
+	Name string `json:"name" example:"Golang Backend"`
+}
+
+// UserEntity is the account a passkey is created for. ID is the user
+// handle, base64url encoded.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name" example:"user@example.com"`
+	DisplayName string `json:"displayName" example:"Jane Doe"`
+}
+
+// CredentialParameter is an accepted key type
+type CredentialParameter struct {
+	Type string `json:"type" example:"public-key"`
+	Alg  int64  `json:"alg" example:"-7"`
+}
+
+// CredentialDescriptor names a registered credential
+type CredentialDescriptor struct {
+	Type       string   `json:"type" example:"public-key"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection states what the authenticator must support
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey" example:"required"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification" example:"preferred"`
+}
+
+// CreationOptions are the options of navigator.credentials.create, in the
+// JSON form of PublicKeyCredential.parseCreationOptionsFromJSON
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout" example:"300000"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation" example:"none"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in the JSON
+// form of PublicKeyCredential.parseRequestOptionsFromJSON
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId" example:"example.com"`
+	Timeout          int64                  `json:"timeout" example:"300000"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification" example:"preferred"`
+}
+
+// AttestationResponse is the JSON form of the credential created by
+// navigator.credentials.create, with binary fields base64url encoded
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type" example:"public-key"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the credential returned by
+// navigator.credentials.get, with binary fields base64url encoded
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type" example:"public-key"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// clientData is the part of clientDataJSON that is checked
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is parsed authenticator data
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Set during registration only
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+var (
+	rpID             string
+	rpName           string
+	origins          []string
+	timeout          time.Duration
+	userVerification string
+)
+
+// Init reads the relying party settings and creates the indexes
+func Init(cfg *config.Config) {
+	rpID, rpName, origins = cfg.WebAuthnRPID, cfg.WebAuthnRPName, cfg.WebAuthnOrigins
+	timeout, userVerification = cfg.WebAuthnTimeout, cfg.WebAuthnUserVerification
+	if app, err := url.Parse(cfg.AppURL); err == nil {
+		if rpID == "" {
+			rpID = app.Hostname()
+		}
+		if len(origins) == 0 {
+			origins = []string{app.Scheme + "://" + app.Host}
+		}
+	}
+	if rpName == "" {
+		rpName = cfg.MailBrandName
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	credentials := []mongo.IndexModel{
+		{Keys: bson.M{"credential_id": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"user_id": 1}},
+	}
+	if _, err := database.DB.Collection(credentialCollection).Indexes().CreateMany(ctx, credentials); err != nil {
+		log.Printf("webauthn: failed to create indexes: %v", err)
+	}
+	ceremonies := mongo.IndexModel{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)}
+	if _, err := database.DB.Collection(ceremonyCollection).Indexes().CreateOne(ctx, ceremonies); err != nil {
+		log.Printf("webauthn: failed to create indexes: %v", err)
+	}
+}
+
+// BeginRegistration starts registering a passkey for a user, named name and
+// displayName on the authenticator, and returns the ceremony's session with
+// the options for the browser. Credentials the user already has are
+// excluded, so an authenticator is not registered twice.
+func BeginRegistration(ctx context.Context, userID primitive.ObjectID, name, displayName string) (string, *CreationOptions, error) {
+	existing, err := List(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	session, challenge, err := begin(ctx, ceremonyRegister, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	opts := &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: rpID, Name: rpName},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(userID[:]),
+			Name:        name,
+			DisplayName: displayName,
+		},
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: []CredentialDescriptor{},
+		// Passkeys are discoverable, so signing in needs no username
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "required", RequireResidentKey: true, UserVerification: userVerification},
+		Attestation:            "none",
+	}
+	for _, alg := range Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	for _, c := range existing {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: c.CredentialID, Transports: c.Transports})
+	}
+	return session, opts, nil
+}
+
+// FinishRegistration verifies the credential an authenticator created in a
+// ceremony started by the same user and stores it under name
+func FinishRegistration(ctx context.Context, userID primitive.ObjectID, session, name string, resp AttestationResponse) (*Credential, error) {
+	c, err := finish(ctx, session, ceremonyRegister)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != userID {
+		return nil, ErrCeremony
+	}
+
+	rawClientData, err := decodeBase64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, invalid("clientDataJSON is not base64url")
+	}
+	if err := checkClientData(rawClientData, "webauthn.create", c.Challenge); err != nil {
+		return nil, err
+	}
+	rawAttestation, err := decodeBase64(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, invalid("attestationObject is not base64url")
+	}
+	item, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, invalid("attestationObject is not CBOR")
+	}
+	attestation, _ := item.(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	data, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAuthenticatorData(data); err != nil {
+		return nil, err
+	}
+	if data.flags&flagAttested == 0 {
+		return nil, invalid("no attested credential data")
+	}
+	_, alg, err := parseKey(data.publicKey)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	now := clock.Now()
+	credential := &Credential{
+		ID:             clock.NewID(),
+		UserID:         userID,
+		CredentialID:   base64.RawURLEncoding.EncodeToString(data.credentialID),
+		PublicKey:      data.publicKey,
+		Algorithm:      alg,
+		SignCount:      data.signCount,
+		AAGUID:         formatAAGUID(data.aaguid),
+		Transports:     resp.Response.Transports,
+		BackupEligible: data.flags&flagBackupEligible != 0,
+		BackedUp:       data.flags&flagBackedUp != 0,
+		Name:           name,
+		CreatedAt:      now,
+	}
+	if _, err := database.DB.Collection(credentialCollection).InsertOne(ctx, credential); mongo.IsDuplicateKeyError(err) {
+		return nil, ErrCredentialExists
+	} else if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginLogin starts signing in with a passkey and returns the ceremony's
+// session with the options for the browser. No credentials are listed, so
+// the authenticator offers the user's discoverable passkeys.
+func BeginLogin(ctx context.Context) (string, *RequestOptions, error) {
+	session, challenge, err := begin(ctx, ceremonyLogin, primitive.NilObjectID)
+	if err != nil {
+		return "", nil, err
+	}
+	return session, &RequestOptions{
+		Challenge:        challenge,
+		RPID:             rpID,
+		Timeout:          timeout.Milliseconds(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: userVerification,
+	}, nil
+}
+
+// FinishLogin verifies an assertion made in a sign-in ceremony and returns
+// the credential that signed it, whose UserID is the user signing in
+func FinishLogin(ctx context.Context, session string, resp AssertionResponse) (*Credential, error) {
+	c, err := finish(ctx, session, ceremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, err := decodeBase64(resp.RawID)
+	if err != nil || len(rawID) == 0 {
+		return nil, invalid("rawId is not base64url")
+	}
+	credentials := database.DB.Collection(credentialCollection)
+	var credential Credential
+	err = credentials.FindOne(ctx, bson.M{"credential_id": base64.RawURLEncoding.EncodeToString(rawID)}).Decode(&credential)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownCredential
+	} else if err != nil {
+		return nil, err
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := decodeBase64(resp.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, credential.UserID[:]) {
+			return nil, invalid("user handle does not match the credential")
+		}
+	}
+
+	rawClientData, err := decodeBase64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, invalid("clientDataJSON is not base64url")
+	}
+	if err := checkClientData(rawClientData, "webauthn.get", c.Challenge); err != nil {
+		return nil, err
+	}
+	rawAuthData, err := decodeBase64(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, invalid("authenticatorData is not base64url")
+	}
+	data, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAuthenticatorData(data); err != nil {
+		return nil, err
+	}
+	signature, err := decodeBase64(resp.Response.Signature)
+	if err != nil {
+		return nil, invalid("signature is not base64url")
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err := verifySignature(credential.PublicKey, append(rawAuthData, clientDataHash[:]...), signature); err != nil {
+		return nil, invalid(err.Error())
+	}
+
+	// A counter that does not grow suggests a cloned authenticator; synced
+	// passkeys keep it at zero
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return nil, invalid("signature counter did not increase")
+	}
+
+	now := clock.Now()
+	credential.SignCount, credential.LastUsedAt = data.signCount, &now
+	credential.BackedUp = data.flags&flagBackedUp != 0
+	update := bson.M{"$set": bson.M{"sign_count": data.signCount, "last_used_at": now, "backed_up": credential.BackedUp}}
+	if _, err := credentials.UpdateOne(ctx, bson.M{"_id": credential.ID}, update); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// List returns the credentials of a user, oldest first
+func List(ctx context.Context, userID primitive.ObjectID) ([]Credential, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := database.DB.Collection(credentialCollection).Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	credentials := []Credential{}
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Remove deletes a credential of a user
+func Remove(ctx context.Context, userID, id primitive.ObjectID) error {
+	result, err := database.DB.Collection(credentialCollection).DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Purge deletes the credentials and unfinished registrations of a user
+func Purge(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	if _, err := database.DB.Collection(ceremonyCollection).DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return 0, err
+	}
+	result, err := database.DB.Collection(credentialCollection).DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// begin stores a new ceremony and returns its session and challenge
+func begin(ctx context.Context, kind string, userID primitive.ObjectID) (string, string, error) {
+	session, err := utils.RandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	challenge, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	c := ceremony{
+		ID:        utils.HashToken(session),
+		Kind:      kind,
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: clock.Now().Add(timeout),
+	}
+	if _, err := database.DB.Collection(ceremonyCollection).InsertOne(ctx, c); err != nil {
+		return "", "", err
+	}
+	return session, challenge, nil
+}
+
+// finish consumes a ceremony, so each challenge is answered once
+func finish(ctx context.Context, session, kind string) (*ceremony, error) {
+	filter := bson.M{"_id": utils.HashToken(session), "kind": kind, "expires_at": bson.M{"$gt": clock.Now()}}
+	var c ceremony
+	err := database.DB.Collection(ceremonyCollection).FindOneAndDelete(ctx, filter).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCeremony
+	}
+	return &c, err
+}
+
+// checkClientData checks the type, challenge and origin the browser signed
+func checkClientData(raw []byte, typ, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return invalid("clientDataJSON is not JSON")
+	}
+	if data.Type != typ {
+		return invalid("client data type is " + data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return invalid("challenge does not match")
+	}
+	if data.CrossOrigin {
+		return invalid("cross-origin ceremonies are not accepted")
+	}
+	for _, origin := range origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return invalid("origin " + data.Origin + " is not allowed")
+}
+
+// checkAuthenticatorData checks the relying party and the user's presence
+// and, when required, verification
+func checkAuthenticatorData(data *authenticatorData) error {
+	want := sha256.Sum256([]byte(rpID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, want[:]) != 1 {
+		return invalid("relying party ID does not match")
+	}
+	if data.flags&flagUserPresent == 0 {
+		return invalid("user not present")
+	}
+	if userVerification == "required" && data.flags&flagUserVerified == 0 {
+		return invalid("user not verified")
+	}
+	return nil
+}
+
+// parseAuthenticatorData splits authenticator data into its fields
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, invalid("authenticator data too short")
+	}
+	data := &authenticatorData{rpIDHash: b[:32], flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if data.flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, invalid("attested credential data too short")
+	}
+	data.aaguid = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return nil, invalid("invalid credential ID length")
+	}
+	data.credentialID, rest = rest[:n], rest[n:]
+	// The key is followed by extensions, if any
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, invalid("credential public key is not CBOR")
+	}
+	data.publicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+	return data, nil
+}
+
+// formatAAGUID formats an AAGUID as a UUID, or returns "" for the zero AAGUID
+// of authenticators that do not tell
+func formatAAGUID(b []byte) string {
+	if len(b) != 16 || bytes.Equal(b, make([]byte, 16)) {
+		return ""
+	}
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// decodeBase64 decodes base64url with or without padding
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// invalid wraps ErrInvalidResponse with a reason
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidResponse, reason)
+}
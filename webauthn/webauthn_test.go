@@ -0,0 +1,218 @@
+package webauthn_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-backend/config"
+	"golang-backend/dbtest"
+	"golang-backend/webauthn"
+	"golang-backend/webauthn/webauthntest"
+)
+
+const origin = "https://app.example.com"
+
+func start(t *testing.T) {
+	t.Helper()
+	dbtest.Start(t)
+	webauthn.Init(&config.Config{AppURL: origin, WebAuthnTimeout: time.Minute, WebAuthnUserVerification: "preferred"})
+}
+
+// register runs a registration ceremony of a for userID
+func register(t *testing.T, a *webauthntest.Authenticator, userID primitive.ObjectID) (*webauthn.Credential, error) {
+	t.Helper()
+	ctx := context.Background()
+	session, opts, err := webauthn.BeginRegistration(ctx, userID, "user@example.com", "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webauthn.FinishRegistration(ctx, userID, session, "Laptop", a.Create(opts))
+}
+
+// begin starts a sign-in ceremony and has a make an unsigned assertion for it
+func begin(t *testing.T, a *webauthntest.Authenticator) (string, *webauthntest.Assertion) {
+	t.Helper()
+	session, opts, err := webauthn.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, a.Assert(opts.Challenge)
+}
+
+// finish sends an assertion signed with key
+func finish(a *webauthntest.Authenticator, session string, as *webauthntest.Assertion, key *ecdsa.PrivateKey) (*webauthn.Credential, error) {
+	return webauthn.FinishLogin(context.Background(), session, a.Response(as, as.Sign(key)))
+}
+
+func login(t *testing.T, a *webauthntest.Authenticator) (*webauthn.Credential, error) {
+	t.Helper()
+	session, as := begin(t, a)
+	return finish(a, session, as, a.Key)
+}
+
+// registered returns a passkey registered to a new user
+func registered(t *testing.T) *webauthntest.Authenticator {
+	t.Helper()
+	a := webauthntest.New(t, origin)
+	if _, err := register(t, a, primitive.NewObjectID()); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	start(t)
+	userID := primitive.NewObjectID()
+	a := webauthntest.New(t, origin)
+	credential, err := register(t, a, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.Algorithm != webauthn.AlgES256 || credential.Name != "Laptop" {
+		t.Fatalf("registered %+v", credential)
+	}
+
+	for i := 0; i < 2; i++ {
+		credential, err := login(t, a)
+		if err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+		if credential.UserID != userID || credential.SignCount != a.Count || credential.LastUsedAt == nil {
+			t.Fatalf("login %d: got %+v", i, credential)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	start(t)
+	userID := primitive.NewObjectID()
+	a := webauthntest.New(t, origin)
+	if _, err := register(t, a, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := register(t, a, userID); !errors.Is(err, webauthn.ErrCredentialExists) {
+		t.Fatalf("got %v, want ErrCredentialExists", err)
+	}
+}
+
+func TestRegisterCeremonyOfAnotherUser(t *testing.T) {
+	start(t)
+	ctx := context.Background()
+	session, opts, err := webauthn.BeginRegistration(ctx, primitive.NewObjectID(), "a@example.com", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := webauthntest.New(t, origin).Create(opts)
+	if _, err := webauthn.FinishRegistration(ctx, primitive.NewObjectID(), session, "Laptop", resp); !errors.Is(err, webauthn.ErrCeremony) {
+		t.Fatalf("got %v, want ErrCeremony", err)
+	}
+}
+
+func TestRegisterFromAnotherOrigin(t *testing.T) {
+	start(t)
+	if _, err := register(t, webauthntest.New(t, "https://evil.example.com"), primitive.NewObjectID()); !errors.Is(err, webauthn.ErrInvalidResponse) {
+		t.Fatalf("got %v, want ErrInvalidResponse", err)
+	}
+}
+
+func TestLoginRejects(t *testing.T) {
+	start(t)
+	a := registered(t)
+	other := webauthntest.New(t, origin)
+
+	cases := []struct {
+		name string
+		// sign returns the signature sent for the assertion
+		sign func(as *webauthntest.Assertion) []byte
+	}{
+		{"signed by another key", func(as *webauthntest.Assertion) []byte { return as.Sign(other.Key) }},
+		{"counter raised after signing", func(as *webauthntest.Assertion) []byte {
+			sig := as.Sign(a.Key)
+			as.SetCount(a.Count + 1)
+			return sig
+		}},
+		{"wrong origin", func(as *webauthntest.Assertion) []byte {
+			as.ClientData = webauthntest.ClientData("webauthn.get", webauthntest.Challenge(as.ClientData), "https://evil.example.com")
+			return as.Sign(a.Key)
+		}},
+		{"wrong challenge", func(as *webauthntest.Assertion) []byte {
+			as.ClientData = webauthntest.ClientData("webauthn.get", "c2VjcmV0", origin)
+			return as.Sign(a.Key)
+		}},
+		{"registration response", func(as *webauthntest.Assertion) []byte {
+			as.ClientData = webauthntest.ClientData("webauthn.create", webauthntest.Challenge(as.ClientData), origin)
+			return as.Sign(a.Key)
+		}},
+		{"user not present", func(as *webauthntest.Assertion) []byte {
+			as.AuthData[32] &^= webauthntest.FlagUserPresent
+			return as.Sign(a.Key)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session, as := begin(t, a)
+			resp := a.Response(as, tc.sign(as))
+			if _, err := webauthn.FinishLogin(context.Background(), session, resp); !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Fatalf("got %v, want ErrInvalidResponse", err)
+			}
+		})
+	}
+
+	// None of the rejected assertions moved the counter
+	if _, err := login(t, a); err != nil {
+		t.Fatalf("valid login after rejections: %v", err)
+	}
+}
+
+func TestLoginCounterRegression(t *testing.T) {
+	start(t)
+	a := registered(t)
+	a.Count = 9
+	if _, err := login(t, a); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clone replaying an older counter
+	for _, count := range []uint32{4, 9} {
+		a.Count = count
+		if _, err := login(t, a); !errors.Is(err, webauthn.ErrInvalidResponse) {
+			t.Fatalf("counter %d after 10: got %v, want ErrInvalidResponse", count+1, err)
+		}
+	}
+}
+
+func TestLoginSyncedPasskeyWithoutCounter(t *testing.T) {
+	start(t)
+	a := registered(t)
+	for i := 0; i < 2; i++ {
+		session, as := begin(t, a)
+		as.SetCount(0)
+		if _, err := finish(a, session, as, a.Key); err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+	}
+}
+
+func TestLoginCeremonyUsedOnce(t *testing.T) {
+	start(t)
+	a := registered(t)
+	session, as := begin(t, a)
+	if _, err := finish(a, session, as, a.Key); err != nil {
+		t.Fatal(err)
+	}
+	as.SetCount(a.Count + 1)
+	if _, err := finish(a, session, as, a.Key); !errors.Is(err, webauthn.ErrCeremony) {
+		t.Fatalf("replayed session: got %v, want ErrCeremony", err)
+	}
+}
+
+func TestLoginUnknownCredential(t *testing.T) {
+	start(t)
+	if _, err := login(t, webauthntest.New(t, origin)); !errors.Is(err, webauthn.ErrUnknownCredential) {
+		t.Fatalf("got %v, want ErrUnknownCredential", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Package webauthntest is a software passkey for tests. It holds an ES256 key
+// like a platform authenticator and answers the options of registration and
+// sign-in ceremonies. Assertions can be changed before or after they are
+// signed, to test how forged responses are refused.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"golang-backend/webauthn"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent = 0x01
+	FlagAttested    = 0x40
+)
+
+// COSE key parameters of an ES256 key
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	ktyEC2  = 2
+	crvP256 = 1
+)
+
+// Authenticator holds one passkey for the relying party at Origin
+type Authenticator struct {
+	Origin string
+	RPID   string
+	// ID is the credential ID
+	ID  []byte
+	Key *ecdsa.PrivateKey
+	// Count is the signature counter of the last assertion
+	Count uint32
+}
+
+// New creates a passkey for origin
+func New(t testing.TB, origin string) *Authenticator {
+	t.Helper()
+	u, err := url.Parse(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &Authenticator{Origin: origin, RPID: u.Hostname(), ID: id, Key: key}
+}
+
+// Create answers the options of a registration ceremony with the passkey
+func (a *Authenticator) Create(opts *webauthn.CreationOptions) webauthn.AttestationResponse {
+	var resp webauthn.AttestationResponse
+	resp.ID, resp.RawID, resp.Type = encode(a.ID), encode(a.ID), "public-key"
+	resp.Response.ClientDataJSON = encode(ClientData("webauthn.create", opts.Challenge, a.Origin))
+	resp.Response.AttestationObject = encode(cbor([]pair{
+		{"fmt", "none"},
+		{"attStmt", []pair{}},
+		{"authData", a.authData(FlagUserPresent|FlagAttested, true)},
+	}))
+	return resp
+}
+
+// Get answers the options of a sign-in ceremony with a signed assertion
+func (a *Authenticator) Get(opts *webauthn.RequestOptions) webauthn.AssertionResponse {
+	as := a.Assert(opts.Challenge)
+	return a.Response(as, as.Sign(a.Key))
+}
+
+// Assertion is the signed part of a sign-in response
+type Assertion struct {
+	ClientData []byte
+	AuthData   []byte
+}
+
+// Assert makes an unsigned assertion for challenge, counting one more
+// signature
+func (a *Authenticator) Assert(challenge string) *Assertion {
+	a.Count++
+	return &Assertion{
+		ClientData: ClientData("webauthn.get", challenge, a.Origin),
+		AuthData:   a.authData(FlagUserPresent, false),
+	}
+}
+
+// SetCount replaces the signature counter of an assertion
+func (as *Assertion) SetCount(count uint32) {
+	binary.BigEndian.PutUint32(as.AuthData[33:], count)
+}
+
+// Sign signs an assertion with key
+func (as *Assertion) Sign(key *ecdsa.PrivateKey) []byte {
+	clientDataHash := sha256.Sum256(as.ClientData)
+	digest := sha256.Sum256(append(append([]byte{}, as.AuthData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return sig
+}
+
+// Response returns the sign-in response carrying an assertion and sig
+func (a *Authenticator) Response(as *Assertion, sig []byte) webauthn.AssertionResponse {
+	var resp webauthn.AssertionResponse
+	resp.ID, resp.RawID, resp.Type = encode(a.ID), encode(a.ID), "public-key"
+	resp.Response.ClientDataJSON = encode(as.ClientData)
+	resp.Response.AuthenticatorData = encode(as.AuthData)
+	resp.Response.Signature = encode(sig)
+	return resp
+}
+
+// ClientData returns the clientDataJSON a browser sends for a ceremony
+func ClientData(typ, challenge, origin string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": origin})
+	return b
+}
+
+// Challenge reads the challenge back from clientDataJSON
+func Challenge(clientData []byte) string {
+	var cd struct {
+		Challenge string `json:"challenge"`
+	}
+	json.Unmarshal(clientData, &cd)
+	return cd.Challenge
+}
+
+// authData returns authenticator data with the current counter, and with
+// the credential when attested
+func (a *Authenticator) authData(flags byte, attested bool) []byte {
+	rp := sha256.Sum256([]byte(a.RPID))
+	data := append(rp[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.Count)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.ID)))
+		data = append(append(data, a.ID...), a.coseKey()...)
+	}
+	return data
+}
+
+func (a *Authenticator) coseKey() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.Key.PublicKey.X.FillBytes(x)
+	a.Key.PublicKey.Y.FillBytes(y)
+	return cbor([]pair{
+		{int64(coseKty), int64(ktyEC2)}, {int64(coseAlg), webauthn.AlgES256}, {int64(coseCrv), int64(crvP256)},
+		{int64(coseX), x}, {int64(coseY), y},
+	})
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pair is a map entry for cbor, which keeps maps in order
+type pair struct {
+	key, value interface{}
+}
+
+// cbor encodes the items attestation objects and COSE keys are made of:
+// int64, []byte, string and maps
+func cbor(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []pair:
+		b := head(5, uint64(len(v)))
+		for _, p := range v {
+			b = append(append(b, cbor(p.key)...), cbor(p.value)...)
+		}
+		return b
+	}
+	panic("webauthntest: unsupported cbor type")
+}